@@ -2,16 +2,22 @@ package main
 
 import (
 	"context"
+	_ "time/tzdata" // user timezones, the docker image has no zoneinfo
 
 	"github.com/coneno/logger"
 	"github.com/influenzanet/study-service/pkg/api"
 	"github.com/influenzanet/user-management-service/internal/config"
+	"github.com/influenzanet/user-management-service/pkg/dbs"
 	"github.com/influenzanet/user-management-service/pkg/dbs/globaldb"
 	"github.com/influenzanet/user-management-service/pkg/dbs/userdb"
 	gc "github.com/influenzanet/user-management-service/pkg/grpc/clients"
 	"github.com/influenzanet/user-management-service/pkg/grpc/service"
+	"github.com/influenzanet/user-management-service/pkg/metrics"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/timer_event"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"github.com/influenzanet/user-management-service/pkg/webhook"
+	"google.golang.org/grpc"
 )
 
 const userManagementTimerEventFrequency = 90 * 60 // seconds
@@ -20,16 +26,28 @@ func main() {
 	conf := config.InitConfig()
 
 	logger.SetLevel(conf.LogLevel)
+	tokens.SetLeeway(conf.Intervals.JWTLeeway)
+	tokens.SetAudience(conf.JWTAudience)
 
 	clients := &models.APIClients{}
 
 	messagingClient, close := gc.ConnectToMessagingService(conf.ServiceURLs.MessagingService)
 	defer close()
-	clients.MessagingService = messagingClient
+	if conf.MessagingCircuitBreaker.FailureThreshold > 0 {
+		clients.MessagingService = gc.NewMessagingClientWithCircuitBreaker(messagingClient, conf.MessagingCircuitBreaker)
+	} else {
+		clients.MessagingService = messagingClient
+	}
 
 	loggingClient, close := gc.ConnectToLoggingService(conf.ServiceURLs.LoggingService)
 	defer close()
-	clients.LoggingService = loggingClient
+	if conf.LoggingBufferSize > 0 {
+		bufferedLoggingClient := gc.NewBufferedLoggingClient(loggingClient, conf.LoggingBufferSize)
+		go bufferedLoggingClient.FlushPeriodically(conf.LoggingBufferFlushInterval)
+		clients.LoggingService = bufferedLoggingClient
+	} else {
+		clients.LoggingService = loggingClient
+	}
 
 	var studyClient api.StudyServiceApiClient
 	if shouldConnectToStudyService(conf.DeleteAccountAfterNotifyingUser) {
@@ -38,8 +56,43 @@ func main() {
 	}
 	clients.StudyService = studyClient
 
+	if conf.Webhook.URL != "" {
+		clients.Webhooks = webhook.NewClient(conf.Webhook)
+	}
+
+	serverOptions := []grpc.ServerOption{}
+	if conf.MetricsPort != "" {
+		m := metrics.New()
+		conf.UserDBConfig.PoolMonitor = m.PoolMonitor("users")
+		conf.GlobalDBConfig.PoolMonitor = m.PoolMonitor("global")
+		serverOptions = append(serverOptions, grpc.UnaryInterceptor(m.UnaryServerInterceptor()))
+		go func() {
+			logger.Info.Printf("serving metrics on port %s", conf.MetricsPort)
+			if err := m.ListenAndServe(conf.MetricsPort); err != nil {
+				logger.Error.Printf("metrics server stopped: %v", err)
+			}
+		}()
+	}
+
 	userDBService := userdb.NewUserDBService(conf.UserDBConfig)
-	globalDBService := globaldb.NewGlobalDBService(conf.GlobalDBConfig)
+	// on the same server, the global DB shares the client of the user DB so that a transaction can cover both
+	var globalDBService *globaldb.GlobalDBService
+	if dbs.SameServer(conf.UserDBConfig, conf.GlobalDBConfig) {
+		globalDBService = globaldb.NewGlobalDBServiceWithClient(userDBService.DBClient, conf.GlobalDBConfig)
+	} else {
+		globalDBService = globaldb.NewGlobalDBService(conf.GlobalDBConfig)
+	}
+	// the rate limit interceptor uses the token validated by the auth interceptor
+	interceptors := []grpc.UnaryServerInterceptor{}
+	if conf.AuthInterceptorEnabled {
+		interceptors = append(interceptors, service.NewAuthUnaryInterceptor(userDBService, conf.AuthPublicMethods))
+	}
+	if len(conf.RateLimits.Methods) > 0 {
+		interceptors = append(interceptors, service.NewRateLimitUnaryInterceptor(conf.RateLimits))
+	}
+	if len(interceptors) > 0 {
+		serverOptions = append(serverOptions, grpc.ChainUnaryInterceptor(interceptors...))
+	}
 
 	// Read instance ID list
 	instanceIDObjects, err := globalDBService.GetAllInstances()
@@ -56,6 +109,17 @@ func main() {
 
 	// Ensure indexes
 	ensureDBIndexes(instanceIDs, userDBService)
+	if err := globalDBService.CreateIndexForIdempotencyKeys(); err != nil {
+		logger.Error.Printf("failed to create indexes for idempotency keys: %v", err)
+	}
+	if err := globalDBService.CreateIndexForReleasedEmails(); err != nil {
+		logger.Error.Printf("failed to create indexes for released emails: %v", err)
+	}
+	if err := globalDBService.CreateIndexForEmailRetries(); err != nil {
+		logger.Error.Printf("failed to create indexes for email retries: %v", err)
+	}
+	migrateTempTokens(globalDBService)
+	migrateNewsletterTopic(instanceIDs, userDBService)
 
 	// Start timer thread
 	userTimerService := timer_event.NewUserManagmentTimerService(
@@ -65,8 +129,15 @@ func main() {
 		clients,
 		conf.CleanUpUnverifiedUsersAfter,
 		conf.ReminderToUnverifiedAccountsAfter,
+		conf.ReminderPacing,
 		conf.NotifyInactiveUsersAfter,
+		conf.FinalInactivityWarningAfter,
 		conf.DeleteAccountAfterNotifyingUser,
+		conf.DeletionReminderBefore,
+		conf.CleanupDryRun,
+		conf.AnonymizeDeletedAccounts,
+		conf.TempTokenCleanupInterval,
+		conf.EmailRetry,
 	)
 
 	// Start server thread
@@ -80,10 +151,23 @@ func main() {
 		clients,
 		userDBService,
 		globalDBService,
-		conf.Intervals,
-		conf.NewUserCountLimit,
-		conf.WeekDayStrategy,
-		instanceIDs,
+		service.ServerConfig{
+			Intervals:                 conf.Intervals,
+			NewUserCountLimit:         conf.NewUserCountLimit,
+			MaxSessionsPerUser:        conf.MaxSessionsPerUser,
+			PasswordResetTriggerLimit: conf.PasswordResetTriggerLimit,
+			SignupPerIPLimit:          conf.SignupPerIPLimit,
+			TrustedProxies:            conf.TrustedProxies,
+			WeekdayStrategy:           conf.WeekDayStrategy,
+			AnonymizeDeletedAccounts:  conf.AnonymizeDeletedAccounts,
+			LoginIPStorage:            conf.LoginIPStorage,
+			LoginHistory:              conf.LoginHistory,
+			ExtraTempTokenPurposes:    conf.ExtraTempTokenPurposes,
+			DisposableEmails:          conf.DisposableEmails,
+			EmbedProfilesInToken:      conf.EmbedProfilesInToken,
+			ManagementInstanceID:      conf.ManagementInstanceID,
+		},
+		serverOptions...,
 	); err != nil {
 		logger.Error.Fatal(err)
 	}
@@ -99,6 +183,31 @@ func ensureDBIndexes(instanceIDs []string, udb *userdb.UserDBService) {
 	}
 }
 
+func migrateNewsletterTopic(instanceIDs []string, udb *userdb.UserDBService) {
+	for _, i := range instanceIDs {
+		count, err := udb.MigrateNewsletterTopic(context.Background(), i)
+		if err != nil {
+			logger.Error.Printf("%s: failed to migrate newsletter subscriptions: %v", i, err)
+			continue
+		}
+		if count > 0 {
+			logger.Info.Printf("%s: newsletter subscription migrated to topics for %d users", i, count)
+		}
+	}
+}
+
+func migrateTempTokens(gdb *globaldb.GlobalDBService) {
+	count, err := gdb.MigrateTempTokenExpiresAt()
+	if err != nil {
+		logger.Error.Printf("failed to migrate temp tokens: %v", err)
+	} else if count > 0 {
+		logger.Info.Printf("expiration date set for %d temp tokens", count)
+	}
+	if err := gdb.CreateIndexForTempTokens(); err != nil {
+		logger.Error.Printf("failed to create indexes for temp tokens: %v", err)
+	}
+}
+
 func shouldConnectToStudyService(deleteAccountAfterNotifyingUser int64) bool {
 	return deleteAccountAfterNotifyingUser > 0
 }
@@ -2,8 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coneno/logger"
@@ -15,6 +17,7 @@ import (
 type Config struct {
 	LogLevel    logger.LogLevel
 	Port        string
+	MetricsPort string // metrics are not served if empty
 	ServiceURLs struct {
 		MessagingService string
 		LoggingService   string
@@ -26,8 +29,33 @@ type Config struct {
 	NewUserCountLimit                 int64
 	CleanUpUnverifiedUsersAfter       int64
 	ReminderToUnverifiedAccountsAfter int64
+	ReminderPacing                    models.SendPacingConfig
 	NotifyInactiveUsersAfter          int64
+	FinalInactivityWarningAfter       int64 // 0: inactive users are marked for deletion without first warning
 	DeleteAccountAfterNotifyingUser   int64
+	DeletionReminderBefore            int64 // seconds before the deletion of an account, 0: no reminder
+	CleanupDryRun                     bool
+	TempTokenCleanupInterval          time.Duration   // 0 disables the periodic cleanup
+	ExtraTempTokenPurposes            map[string]bool // temp token purposes accepted in addition to the known ones
+	AnonymizeDeletedAccounts          map[string]bool // instance IDs where removed accounts are anonymized instead of deleted
+	LoginIPStorage                    string
+	LoginHistory                      models.LoginHistoryConfig
+	DisposableEmails                  models.DisposableEmailConfig
+	EmbedProfilesInToken              bool   // compact profile list in the access tokens
+	ManagementInstanceID              string // service accounts of this instance may create and list all instances
+	JWTAudience                       string // aud claim of the issued tokens, required in validated tokens if not empty
+	MaxSessionsPerUser                int64
+	PasswordResetTriggerLimit         int64
+	SignupPerIPLimit                  int64        // 0 means no limit
+	TrustedProxies                    []*net.IPNet // proxies whose x-forwarded-for header is used for the client IP
+	MessagingCircuitBreaker           models.CircuitBreakerConfig
+	LoggingBufferSize                 int // 0 disables the buffer
+	LoggingBufferFlushInterval        time.Duration
+	AuthInterceptorEnabled            bool
+	AuthPublicMethods                 map[string]bool // methods callable without access token, defaults of the service if empty
+	RateLimits                        models.RateLimitConfig
+	Webhook                           models.WebhookConfig // disabled if the URL is empty
+	EmailRetry                        models.EmailRetryConfig
 
 	WeekDayStrategy utils.WeekDayStrategy
 }
@@ -35,6 +63,7 @@ type Config struct {
 func InitConfig() Config {
 	conf := Config{}
 	conf.Port = os.Getenv(ENV_USER_MANAGEMENT_LISTEN_PORT)
+	conf.MetricsPort = os.Getenv(ENV_METRICS_LISTEN_PORT)
 	conf.ServiceURLs.MessagingService = os.Getenv(ENV_ADDR_MESSAGING_SERVICE)
 	conf.ServiceURLs.LoggingService = os.Getenv(ENV_ADDR_LOGGING_SERVICE)
 	conf.ServiceURLs.StudyService = os.Getenv(ENV_ADDR_STUDY_SERVICE)
@@ -64,6 +93,7 @@ func InitConfig() Config {
 		logger.Error.Fatal(ENV_SEND_REMINDER_TO_UNVERIFIED_USERS_AFTER + ": " + err.Error())
 	}
 	conf.ReminderToUnverifiedAccountsAfter = int64(reminderToUnverifiedAccountsAfter)
+	conf.ReminderPacing = getReminderPacingConfig()
 
 	notifyInactiveUsersAfter, err := strconv.Atoi(os.Getenv(ENV_NOTIFY_INACTIVE_USERS_AFTER))
 	if err != nil {
@@ -72,6 +102,15 @@ func InitConfig() Config {
 	}
 	conf.NotifyInactiveUsersAfter = int64(notifyInactiveUsersAfter)
 
+	conf.FinalInactivityWarningAfter = defaultFinalInactivityWarningAfter
+	if v := os.Getenv(ENV_FINAL_INACTIVITY_WARNING_AFTER); v != "" {
+		finalInactivityWarningAfter, err := strconv.Atoi(v)
+		if err != nil || finalInactivityWarningAfter < 0 {
+			logger.Error.Fatalf("%s: invalid value %s", ENV_FINAL_INACTIVITY_WARNING_AFTER, v)
+		}
+		conf.FinalInactivityWarningAfter = int64(finalInactivityWarningAfter)
+	}
+
 	deleteAccountAfterNotifyingUser, err := strconv.Atoi(os.Getenv(ENV_DELETE_ACCOUNT_AFTER_NOTIFYING_USER))
 	if err != nil {
 		logger.Info.Printf(ENV_DELETE_ACCOUNT_AFTER_NOTIFYING_USER + ": not provided, inactive users will be ignored")
@@ -79,6 +118,39 @@ func InitConfig() Config {
 	}
 	conf.DeleteAccountAfterNotifyingUser = int64(deleteAccountAfterNotifyingUser)
 
+	conf.DeletionReminderBefore = int64(parseEnvDuration(ENV_DELETION_REMINDER_BEFORE, defaultDeletionReminderBefore, "h").Seconds())
+
+	conf.CleanupDryRun = os.Getenv(ENV_CLEANUP_DRY_RUN) == "true"
+	if conf.CleanupDryRun {
+		logger.Warning.Printf("%s: cleanup jobs only log the accounts they would delete", ENV_CLEANUP_DRY_RUN)
+	}
+
+	conf.TempTokenCleanupInterval = parseEnvDuration(ENV_TEMP_TOKEN_CLEANUP_INTERVAL, defaultTempTokenCleanupInterval, "s")
+	conf.ExtraTempTokenPurposes = getExtraTempTokenPurposes()
+
+	conf.AnonymizeDeletedAccounts = getAnonymizeDeletedAccounts()
+	conf.LoginIPStorage = getLoginIPStorage()
+	conf.LoginHistory = getLoginHistoryConfig()
+	conf.DisposableEmails = getDisposableEmailConfig()
+	conf.EmbedProfilesInToken = os.Getenv(ENV_TOKEN_EMBED_PROFILES) == "true"
+	conf.ManagementInstanceID = os.Getenv(ENV_MANAGEMENT_INSTANCE_ID)
+	conf.JWTAudience = os.Getenv(ENV_JWT_AUDIENCE)
+
+	conf.MaxSessionsPerUser = getMaxSessionsPerUser()
+	conf.PasswordResetTriggerLimit = getPasswordResetTriggerLimit()
+	conf.SignupPerIPLimit = getSignupPerIPLimit()
+	conf.TrustedProxies = getTrustedProxies()
+	conf.MessagingCircuitBreaker = getMessagingCircuitBreakerConfig()
+	conf.LoggingBufferSize = getLoggingBufferSize()
+	conf.LoggingBufferFlushInterval = parseEnvDuration(ENV_LOGGING_BUFFER_FLUSH_INTERVAL, defaultLoggingBufferFlushInterval, "s")
+
+	conf.AuthInterceptorEnabled = os.Getenv(ENV_AUTH_INTERCEPTOR_ENABLED) == "true"
+	conf.AuthPublicMethods = getAuthPublicMethods()
+	conf.RateLimits = getRateLimitConfig()
+	conf.RateLimits.TrustedProxies = conf.TrustedProxies
+	conf.Webhook = getWebhookConfig()
+	conf.EmailRetry = getEmailRetryConfig()
+
 	conf.WeekDayStrategy = GetWeekDayStrategy()
 	return conf
 }
@@ -100,6 +172,246 @@ func GetWeekDayStrategy() utils.WeekDayStrategy {
 	return strategy
 }
 
+func getMaxSessionsPerUser() int64 {
+	v := os.Getenv(ENV_MAX_SESSIONS_PER_USER)
+	if v == "" {
+		return defaultMaxSessionsPerUser
+	}
+	maxSessions, err := strconv.Atoi(v)
+	if err != nil || maxSessions < 0 {
+		logger.Error.Fatalf("%s: should be a positive integer, got '%s'", ENV_MAX_SESSIONS_PER_USER, v)
+	}
+	return int64(maxSessions)
+}
+
+func getPasswordResetTriggerLimit() int64 {
+	v := os.Getenv(ENV_PASSWORD_RESET_TRIGGER_LIMIT)
+	if v == "" {
+		return defaultPasswordResetTriggerLimit
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 {
+		logger.Error.Fatalf("%s: should be a strictly positive integer, got '%s'", ENV_PASSWORD_RESET_TRIGGER_LIMIT, v)
+	}
+	return int64(limit)
+}
+
+func getSignupPerIPLimit() int64 {
+	v := os.Getenv(ENV_SIGNUP_RATE_LIMIT_PER_IP)
+	if v == "" {
+		return defaultSignupPerIPLimit
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		logger.Error.Fatalf("%s: should be a positive integer, got '%s'", ENV_SIGNUP_RATE_LIMIT_PER_IP, v)
+	}
+	return int64(limit)
+}
+
+func getMessagingCircuitBreakerConfig() models.CircuitBreakerConfig {
+	conf := models.CircuitBreakerConfig{
+		FailureThreshold: defaultMessagingBreakerFailureThreshold,
+	}
+	if v := os.Getenv(ENV_MESSAGING_BREAKER_FAILURE_THRESHOLD); v != "" {
+		threshold, err := strconv.Atoi(v)
+		if err != nil || threshold < 0 {
+			logger.Error.Fatalf("%s: should be a positive integer, got '%s'", ENV_MESSAGING_BREAKER_FAILURE_THRESHOLD, v)
+		}
+		conf.FailureThreshold = threshold
+	}
+	conf.OpenDuration = parseEnvDuration(ENV_MESSAGING_BREAKER_OPEN_DURATION, defaultMessagingBreakerOpenDuration, "s")
+	conf.CallTimeout = parseEnvDuration(ENV_MESSAGING_CALL_TIMEOUT, defaultMessagingCallTimeout, "s")
+	return conf
+}
+
+func getReminderPacingConfig() models.SendPacingConfig {
+	conf := models.SendPacingConfig{
+		BatchSize: defaultReminderBatchSize,
+	}
+	if v := os.Getenv(ENV_REMINDER_BATCH_SIZE); v != "" {
+		batchSize, err := strconv.Atoi(v)
+		if err != nil || batchSize < 0 {
+			logger.Error.Fatalf("%s: should be a positive integer, got '%s'", ENV_REMINDER_BATCH_SIZE, v)
+		}
+		conf.BatchSize = batchSize
+	}
+	conf.Interval = parseEnvDuration(ENV_REMINDER_SEND_INTERVAL, defaultReminderSendInterval, "ms")
+	conf.BatchPause = parseEnvDuration(ENV_REMINDER_BATCH_PAUSE, defaultReminderBatchPause, "s")
+	return conf
+}
+
+func getAnonymizeDeletedAccounts() map[string]bool {
+	instances := map[string]bool{}
+	for _, instanceID := range strings.Split(os.Getenv(ENV_ANONYMIZE_DELETED_ACCOUNTS), ",") {
+		instanceID = strings.TrimSpace(instanceID)
+		if instanceID != "" {
+			instances[instanceID] = true
+		}
+	}
+	return instances
+}
+
+func getExtraTempTokenPurposes() map[string]bool {
+	purposes := map[string]bool{}
+	for _, purpose := range strings.Split(os.Getenv(ENV_TEMP_TOKEN_EXTRA_PURPOSES), ",") {
+		purpose = strings.TrimSpace(purpose)
+		if purpose != "" {
+			purposes[purpose] = true
+		}
+	}
+	return purposes
+}
+
+func getAuthPublicMethods() map[string]bool {
+	methods := map[string]bool{}
+	for _, method := range strings.Split(os.Getenv(ENV_AUTH_PUBLIC_METHODS), ",") {
+		method = strings.TrimSpace(method)
+		if method != "" {
+			methods[method] = true
+		}
+	}
+	return methods
+}
+
+func getTrustedProxies() []*net.IPNet {
+	proxies, err := utils.ParseTrustedProxies(os.Getenv(ENV_TRUSTED_PROXIES))
+	if err != nil {
+		logger.Error.Fatalf("%s: %v", ENV_TRUSTED_PROXIES, err)
+	}
+	return proxies
+}
+
+func getRateLimitConfig() models.RateLimitConfig {
+	conf := models.RateLimitConfig{KeyBy: models.RATE_LIMIT_KEY_IP}
+	switch v := os.Getenv(ENV_RATE_LIMIT_KEY); v {
+	case "":
+	case models.RATE_LIMIT_KEY_IP, models.RATE_LIMIT_KEY_USER:
+		conf.KeyBy = v
+	default:
+		logger.Error.Fatalf("%s: should be %s or %s, got '%s'", ENV_RATE_LIMIT_KEY, models.RATE_LIMIT_KEY_IP, models.RATE_LIMIT_KEY_USER, v)
+	}
+	limits, err := parseMethodRateLimits(os.Getenv(ENV_RATE_LIMITS))
+	if err != nil {
+		logger.Error.Fatalf("%s: %v", ENV_RATE_LIMITS, err)
+	}
+	conf.Methods = limits
+	return conf
+}
+
+func getWebhookConfig() models.WebhookConfig {
+	conf := models.WebhookConfig{URL: os.Getenv(ENV_WEBHOOK_URL), MaxAttempts: defaultWebhookMaxAttempts}
+	if conf.URL == "" {
+		return conf
+	}
+	secret, err := readEnvOrFile(ENV_WEBHOOK_SECRET)
+	if err != nil {
+		logger.Error.Fatal(err)
+	}
+	if secret == "" {
+		logger.Error.Fatalf("%s: required if %s is set", ENV_WEBHOOK_SECRET, ENV_WEBHOOK_URL)
+	}
+	conf.Secret = secret
+	if v := os.Getenv(ENV_WEBHOOK_MAX_ATTEMPTS); v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err != nil || attempts < 1 {
+			logger.Error.Fatalf("%s: should be a strictly positive integer, got '%s'", ENV_WEBHOOK_MAX_ATTEMPTS, v)
+		}
+		conf.MaxAttempts = attempts
+	}
+	conf.RetryDelay = parseEnvDuration(ENV_WEBHOOK_RETRY_DELAY, defaultWebhookRetryDelay, "s")
+	conf.Timeout = parseEnvDuration(ENV_WEBHOOK_TIMEOUT, defaultWebhookTimeout, "s")
+	return conf
+}
+
+func getEmailRetryConfig() models.EmailRetryConfig {
+	conf := models.EmailRetryConfig{
+		MaxAttempts: defaultEmailRetryMaxAttempts,
+	}
+	if v := os.Getenv(ENV_EMAIL_RETRY_MAX_ATTEMPTS); v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err != nil || attempts < 1 {
+			logger.Error.Fatalf("%s: should be a positive integer, got '%s'", ENV_EMAIL_RETRY_MAX_ATTEMPTS, v)
+		}
+		conf.MaxAttempts = attempts
+	}
+	conf.Interval = parseEnvDuration(ENV_EMAIL_RETRY_INTERVAL, defaultEmailRetryInterval, "s")
+	conf.Delay = parseEnvDuration(ENV_EMAIL_RETRY_DELAY, defaultEmailRetryDelay, "s")
+	if conf.Delay < time.Second {
+		logger.Error.Fatalf("%s: should be at least 1s, got %s", ENV_EMAIL_RETRY_DELAY, conf.Delay)
+	}
+	return conf
+}
+
+func getLoginIPStorage() string {
+	v := os.Getenv(ENV_LOGIN_IP_STORAGE)
+	switch v {
+	case "":
+		return utils.IPStorageFull
+	case utils.IPStorageFull, utils.IPStorageTruncated, utils.IPStorageNone:
+		return v
+	default:
+		logger.Error.Fatalf("%s: should be one of %s, %s or %s, got '%s'", ENV_LOGIN_IP_STORAGE, utils.IPStorageFull, utils.IPStorageTruncated, utils.IPStorageNone, v)
+	}
+	return v
+}
+
+func getLoginHistoryConfig() models.LoginHistoryConfig {
+	conf := models.LoginHistoryConfig{Size: defaultLoginHistorySize}
+	if v := os.Getenv(ENV_LOGIN_HISTORY_SIZE); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			logger.Error.Fatalf("%s: should be a strictly positive integer, got '%s'", ENV_LOGIN_HISTORY_SIZE, v)
+		}
+		conf.Size = size
+	}
+	conf.Retention = parseEnvDuration(ENV_LOGIN_HISTORY_RETENTION, defaultLoginHistoryRetention, "h")
+	return conf
+}
+
+// getDisposableEmailConfig reads the disposable email domains, one per line, '#' starts a comment
+func getDisposableEmailConfig() models.DisposableEmailConfig {
+	conf := models.DisposableEmailConfig{Action: models.DISPOSABLE_EMAIL_FLAG}
+	switch v := os.Getenv(ENV_DISPOSABLE_EMAIL_ACTION); v {
+	case "":
+	case models.DISPOSABLE_EMAIL_FLAG, models.DISPOSABLE_EMAIL_REJECT:
+		conf.Action = v
+	default:
+		logger.Error.Fatalf("%s: should be %s or %s, got '%s'", ENV_DISPOSABLE_EMAIL_ACTION, models.DISPOSABLE_EMAIL_FLAG, models.DISPOSABLE_EMAIL_REJECT, v)
+	}
+
+	path := os.Getenv(ENV_DISPOSABLE_EMAIL_DOMAINS_FILE)
+	if path == "" {
+		return conf
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		logger.Error.Fatalf("%s: %v", ENV_DISPOSABLE_EMAIL_DOMAINS_FILE, err)
+	}
+	domains := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		domains = append(domains, line)
+	}
+	list := utils.NewDisposableDomainList(domains)
+	logger.Info.Printf("%d disposable email domains loaded, action: %s", len(list), conf.Action)
+	conf.Detector = list
+	return conf
+}
+
+func getLoggingBufferSize() int {
+	v := os.Getenv(ENV_LOGGING_BUFFER_SIZE)
+	if v == "" {
+		return defaultLoggingBufferSize
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil || size < 0 {
+		logger.Error.Fatalf("%s: should be a positive integer, got '%s'", ENV_LOGGING_BUFFER_SIZE, v)
+	}
+	return size
+}
+
 func getLogLevel() logger.LogLevel {
 	switch os.Getenv(ENV_LOG_LEVEL) {
 	case "debug":
@@ -117,8 +429,9 @@ func getLogLevel() logger.LogLevel {
 
 func getIntervalsConfig() models.Intervals {
 	intervals := models.Intervals{
-		TokenExpiryInterval:      time.Minute * time.Duration(defaultTokenExpirationMin),
-		VerificationCodeLifetime: defaultVerificationCodeLifetime,
+		TokenExpiryInterval:            time.Minute * time.Duration(defaultTokenExpirationMin),
+		VerificationCodeLifetime:       defaultVerificationCodeLifetime,
+		VerificationCodeResendCooldown: defaultVerificationCodeResendCooldown,
 	}
 
 	accessTokenExpiration, err := strconv.Atoi(os.Getenv(ENV_TOKEN_EXPIRATION_MIN))
@@ -135,9 +448,53 @@ func getIntervalsConfig() models.Intervals {
 		intervals.VerificationCodeLifetime = int64(newVerificationCodeLifetime)
 	}
 
+	if v := os.Getenv(ENV_VERIFICATION_CODE_RESEND_COOLDOWN); v == "" {
+		logger.Info.Println("using default verification code resend cooldown")
+	} else {
+		resendCooldown, err := strconv.Atoi(v)
+		if err != nil || resendCooldown < 0 {
+			logger.Error.Fatalf("%s: should be a positive integer or 0 to disable the cooldown, got '%s'", ENV_VERIFICATION_CODE_RESEND_COOLDOWN, v)
+		}
+		intervals.VerificationCodeResendCooldown = int64(resendCooldown)
+	}
+
 	intervals.InvitationTokenLifetime = parseEnvDuration(ENV_TOKEN_INVITATION_LIFETIME, defaultInvitationTokenLifetime, "m")
 
 	intervals.ContactVerificationTokenLifetime = parseEnvDuration(ENV_TOKEN_CONTACT_VERIFICATION_LIFETIME, defaultContactVerificationTokenLifetime, "m")
 
+	intervals.ServiceAccountTokenLifetime = parseEnvDuration(ENV_TOKEN_SERVICE_ACCOUNT_LIFETIME, defaultServiceAccountTokenLifetime, "h")
+
+	intervals.PasswordResetTokenLifetime = parseEnvDuration(ENV_TOKEN_PASSWORD_RESET_LIFETIME, defaultPasswordResetTokenLifetime, "m")
+
+	intervals.RefreshTokenLifetime = parseEnvDuration(ENV_REFRESH_TOKEN_LIFETIME, defaultRefreshTokenLifetime, "h")
+
+	intervals.RememberMeRefreshTokenLifetime = parseEnvDuration(ENV_REMEMBER_ME_REFRESH_TOKEN_LIFETIME, defaultRememberMeRefreshTokenLifetime, "h")
+
+	intervals.PasswordResetTriggerWindow = parseEnvDuration(ENV_PASSWORD_RESET_TRIGGER_WINDOW, defaultPasswordResetTriggerWindow, "m")
+
+	intervals.SignupPerIPWindow = parseEnvDuration(ENV_SIGNUP_RATE_LIMIT_PER_IP_WINDOW, defaultSignupPerIPWindow, "m")
+
+	intervals.StepUpAuthMaxAge = parseEnvDuration(ENV_STEP_UP_AUTH_MAX_AGE, defaultStepUpAuthMaxAge, "m")
+
+	intervals.TempTokenCleanupMinInterval = parseEnvDuration(ENV_TEMP_TOKEN_CLEANUP_MIN_INTERVAL, defaultTempTokenCleanupMinInterval, "s")
+
+	intervals.ExpiredTempTokenRetention = parseEnvDuration(ENV_EXPIRED_TEMP_TOKEN_RETENTION, defaultExpiredTempTokenRetention, "s")
+
+	intervals.JWTLeeway = getJWTLeeway()
+
 	return intervals
 }
+
+// getJWTLeeway returns the tolerated clock skew of JWT_LEEWAY, as duration or number of seconds, 0 if not set
+func getJWTLeeway() time.Duration {
+	v := os.Getenv(ENV_JWT_LEEWAY)
+	if v == "" {
+		return 0
+	}
+	leeway, err := parseDuration(v, "s")
+	if err != nil || leeway < 0 {
+		logger.Error.Fatalf("%s: should be a positive duration or number of seconds, got '%s'", ENV_JWT_LEEWAY, v)
+	}
+	logger.Info.Printf("%s : using value %s", ENV_JWT_LEEWAY, leeway)
+	return leeway
+}
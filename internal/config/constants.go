@@ -4,33 +4,120 @@ import "time"
 
 const (
 	ENV_VERIFICATION_CODE_LIFETIME          = "VERIFICATION_CODE_LIFETIME"
+	ENV_VERIFICATION_CODE_RESEND_COOLDOWN   = "VERIFICATION_CODE_RESEND_COOLDOWN"
 	ENV_TOKEN_EXPIRATION_MIN                = "TOKEN_EXPIRATION_MIN"
 	ENV_TOKEN_INVITATION_LIFETIME           = "INVITATION_TOKEN_LIFETIME"
 	ENV_TOKEN_CONTACT_VERIFICATION_LIFETIME = "CONTACT_VERIFICATION_TOKEN_LIFETIME"
+	ENV_TOKEN_SERVICE_ACCOUNT_LIFETIME      = "SERVICE_ACCOUNT_TOKEN_LIFETIME"
+	ENV_TOKEN_PASSWORD_RESET_LIFETIME       = "PASSWORD_RESET_TOKEN_LIFETIME"
+	ENV_REFRESH_TOKEN_LIFETIME              = "REFRESH_TOKEN_LIFETIME"
+	ENV_REMEMBER_ME_REFRESH_TOKEN_LIFETIME  = "REMEMBER_ME_REFRESH_TOKEN_LIFETIME"
+	ENV_TEMP_TOKEN_CLEANUP_MIN_INTERVAL     = "TEMP_TOKEN_CLEANUP_MIN_INTERVAL"
+	ENV_EXPIRED_TEMP_TOKEN_RETENTION        = "EXPIRED_TEMP_TOKEN_RETENTION"
+	ENV_STEP_UP_AUTH_MAX_AGE                = "STEP_UP_AUTH_MAX_AGE"
+	ENV_JWT_LEEWAY                          = "JWT_LEEWAY"
+	ENV_JWT_AUDIENCE                        = "JWT_AUDIENCE"
 
 	ENV_USE_NO_CURSOR_TIMEOUT                   = "USE_NO_CURSOR_TIMEOUT"
 	ENV_SEND_REMINDER_TO_UNVERIFIED_USERS_AFTER = "SEND_REMINDER_TO_UNVERIFIED_USERS_AFTER"
+	ENV_REMINDER_SEND_INTERVAL                  = "REMINDER_SEND_INTERVAL"
+	ENV_REMINDER_BATCH_SIZE                     = "REMINDER_BATCH_SIZE"
+	ENV_REMINDER_BATCH_PAUSE                    = "REMINDER_BATCH_PAUSE"
 	ENV_NOTIFY_INACTIVE_USERS_AFTER             = "NOTIFY_INACTIVE_USERS_AFTER"
+	ENV_FINAL_INACTIVITY_WARNING_AFTER          = "FINAL_INACTIVITY_WARNING_AFTER"
 	ENV_DELETE_ACCOUNT_AFTER_NOTIFYING_USER     = "DELETE_ACCOUNT_AFTER_NOTIFYING_USER"
+	ENV_DELETION_REMINDER_BEFORE                = "DELETION_REMINDER_BEFORE"
+	ENV_CLEANUP_DRY_RUN                         = "CLEANUP_DRY_RUN"
+	ENV_TEMP_TOKEN_CLEANUP_INTERVAL             = "TEMP_TOKEN_CLEANUP_INTERVAL"
+	ENV_TEMP_TOKEN_EXTRA_PURPOSES               = "TEMP_TOKEN_EXTRA_PURPOSES"
+	ENV_ANONYMIZE_DELETED_ACCOUNTS              = "ANONYMIZE_DELETED_ACCOUNTS"
+	ENV_LOGIN_IP_STORAGE                        = "LOGIN_IP_STORAGE"
+	ENV_LOGIN_HISTORY_SIZE                      = "LOGIN_HISTORY_SIZE"
+	ENV_LOGIN_HISTORY_RETENTION                 = "LOGIN_HISTORY_RETENTION"
+	ENV_DISPOSABLE_EMAIL_DOMAINS_FILE           = "DISPOSABLE_EMAIL_DOMAINS_FILE"
+	ENV_DISPOSABLE_EMAIL_ACTION                 = "DISPOSABLE_EMAIL_ACTION"
+	ENV_TOKEN_EMBED_PROFILES                    = "TOKEN_EMBED_PROFILES"
+	ENV_MANAGEMENT_INSTANCE_ID                  = "MANAGEMENT_INSTANCE_ID"
 
 	ENV_WEEKDAY_ASSIGNATION_WEIGHTS = "WEEKDAY_ASSIGNATION_WEIGHTS"
 
 	ENV_USER_MANAGEMENT_LISTEN_PORT = "USER_MANAGEMENT_LISTEN_PORT"
+	ENV_METRICS_LISTEN_PORT         = "METRICS_LISTEN_PORT"
 	ENV_ADDR_MESSAGING_SERVICE      = "ADDR_MESSAGING_SERVICE"
 	ENV_ADDR_LOGGING_SERVICE        = "ADDR_LOGGING_SERVICE"
 	ENV_ADDR_STUDY_SERVICE          = "ADDR_STUDY_SERVICE"
 
 	ENV_NEW_USER_RATE_LIMIT             = "NEW_USER_RATE_LIMIT"
 	ENV_CLEAN_UP_UNVERIFIED_USERS_AFTER = "CLEAN_UP_UNVERIFIED_USERS_AFTER"
+	ENV_MAX_SESSIONS_PER_USER           = "MAX_SESSIONS_PER_USER"
+	ENV_PASSWORD_RESET_TRIGGER_LIMIT    = "PASSWORD_RESET_TRIGGER_LIMIT"
+	ENV_PASSWORD_RESET_TRIGGER_WINDOW   = "PASSWORD_RESET_TRIGGER_WINDOW"
+	ENV_SIGNUP_RATE_LIMIT_PER_IP        = "SIGNUP_RATE_LIMIT_PER_IP"
+	ENV_SIGNUP_RATE_LIMIT_PER_IP_WINDOW = "SIGNUP_RATE_LIMIT_PER_IP_WINDOW"
+	ENV_TRUSTED_PROXIES                 = "TRUSTED_PROXIES"
+
+	ENV_MESSAGING_BREAKER_FAILURE_THRESHOLD = "MESSAGING_BREAKER_FAILURE_THRESHOLD"
+	ENV_MESSAGING_BREAKER_OPEN_DURATION     = "MESSAGING_BREAKER_OPEN_DURATION"
+	ENV_MESSAGING_CALL_TIMEOUT              = "MESSAGING_CALL_TIMEOUT"
+
+	ENV_LOGGING_BUFFER_SIZE           = "LOGGING_BUFFER_SIZE"
+	ENV_LOGGING_BUFFER_FLUSH_INTERVAL = "LOGGING_BUFFER_FLUSH_INTERVAL"
+
+	ENV_AUTH_INTERCEPTOR_ENABLED = "AUTH_INTERCEPTOR_ENABLED"
+	ENV_AUTH_PUBLIC_METHODS      = "AUTH_PUBLIC_METHODS"
+	ENV_RATE_LIMITS              = "RATE_LIMITS"
+	ENV_RATE_LIMIT_KEY           = "RATE_LIMIT_KEY"
+
+	ENV_WEBHOOK_URL          = "WEBHOOK_URL"
+	ENV_WEBHOOK_SECRET       = "WEBHOOK_SECRET"
+	ENV_WEBHOOK_MAX_ATTEMPTS = "WEBHOOK_MAX_ATTEMPTS"
+	ENV_WEBHOOK_RETRY_DELAY  = "WEBHOOK_RETRY_DELAY"
+	ENV_WEBHOOK_TIMEOUT      = "WEBHOOK_TIMEOUT"
+
+	ENV_EMAIL_RETRY_INTERVAL     = "EMAIL_RETRY_INTERVAL"
+	ENV_EMAIL_RETRY_MAX_ATTEMPTS = "EMAIL_RETRY_MAX_ATTEMPTS"
+	ENV_EMAIL_RETRY_DELAY        = "EMAIL_RETRY_DELAY"
 
 	ENV_LOG_LEVEL = "LOG_LEVEL"
 )
 
 const (
 	defaultVerificationCodeLifetime         = 15 * 60 // for 2FA 6 digit code
+	defaultVerificationCodeResendCooldown   = 20      // seconds
 	defaultTokenExpirationMin               = 55
 	defaultInvitationTokenLifetime          = time.Hour * 24 * 7
 	defaultContactVerificationTokenLifetime = time.Hour * 24 * 30
+	defaultServiceAccountTokenLifetime      = time.Hour * 24 * 365
+	defaultPasswordResetTokenLifetime       = time.Hour * 24
+	defaultRefreshTokenLifetime             = time.Hour * 24 * 90
+	defaultRememberMeRefreshTokenLifetime   = time.Hour * 24 * 365
+	defaultTempTokenCleanupMinInterval      = 10 * time.Minute
+	defaultExpiredTempTokenRetention        = time.Hour
 	defaultNotifyInactiveUsersAfter         = 0
+	defaultFinalInactivityWarningAfter      = 0
 	defaultDeleteAccountAfterNotifyingUser  = 0
+	defaultDeletionReminderBefore           = 0 // no reminder
+	defaultMaxSessionsPerUser               = 0 // no limit
+	defaultPasswordResetTriggerLimit        = 5
+	defaultPasswordResetTriggerWindow       = time.Hour
+	defaultSignupPerIPLimit                 = 0 // no limit, behind a proxy not in TRUSTED_PROXIES all clients share its IP
+	defaultSignupPerIPWindow                = time.Hour
+	defaultStepUpAuthMaxAge                 = 15 * time.Minute
+	defaultMessagingBreakerFailureThreshold = 5
+	defaultMessagingBreakerOpenDuration     = 30 * time.Second
+	defaultMessagingCallTimeout             = 10 * time.Second
+	defaultLoggingBufferSize                = 1000
+	defaultLoggingBufferFlushInterval       = 30 * time.Second
+	defaultLoginHistorySize                 = 10
+	defaultLoginHistoryRetention            = time.Hour * 24 * 90
+	defaultTempTokenCleanupInterval         = time.Hour
+	defaultWebhookMaxAttempts               = 3
+	defaultWebhookRetryDelay                = 2 * time.Second
+	defaultWebhookTimeout                   = 10 * time.Second
+	defaultReminderSendInterval             = 100 * time.Millisecond
+	defaultReminderBatchSize                = 0 // no pause
+	defaultReminderBatchPause               = 10 * time.Second
+	defaultEmailRetryInterval               = time.Minute
+	defaultEmailRetryMaxAttempts            = 5
+	defaultEmailRetryDelay                  = 5 * time.Minute
 )
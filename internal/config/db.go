@@ -1,25 +1,24 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/coneno/logger"
 	"github.com/influenzanet/user-management-service/pkg/models"
 )
 
 func GetUserDBConfig() models.DBConfig {
-	connStr := os.Getenv("USER_DB_CONNECTION_STR")
-	username := os.Getenv("USER_DB_USERNAME")
-	password := os.Getenv("USER_DB_PASSWORD")
-	prefix := os.Getenv("USER_DB_CONNECTION_PREFIX") // Used in test mode
-	if connStr == "" || username == "" || password == "" {
-		logger.Error.Fatal("Couldn't read DB credentials.")
+	connStr, username, password, err := getDBCredentials("USER_DB")
+	if err != nil {
+		logger.Error.Fatal(err)
 	}
+	prefix := os.Getenv("USER_DB_CONNECTION_PREFIX") // Used in test mode
 	URI := fmt.Sprintf(`mongodb%s://%s:%s@%s`, prefix, username, password, connStr)
 
-	var err error
 	Timeout, err := strconv.Atoi(os.Getenv("DB_TIMEOUT"))
 	if err != nil {
 		logger.Error.Fatal("DB_TIMEOUT: " + err.Error())
@@ -45,20 +44,21 @@ func GetUserDBConfig() models.DBConfig {
 		NoCursorTimeout: noCursorTimeout,
 		MaxPoolSize:     MaxPoolSize,
 		DBNamePrefix:    DBNamePrefix,
+		TLS:             os.Getenv("USER_DB_TLS") == "true",
+		TLSCAFile:       os.Getenv("USER_DB_TLS_CA_FILE"),
+		ReadPreference:  os.Getenv("DB_READ_PREFERENCE"),
+		WriteConcern:    os.Getenv("DB_WRITE_CONCERN"),
 	}
 }
 
 func GetGlobalDBConfig() models.DBConfig {
-	connStr := os.Getenv("GLOBAL_DB_CONNECTION_STR")
-	username := os.Getenv("GLOBAL_DB_USERNAME")
-	password := os.Getenv("GLOBAL_DB_PASSWORD")
-	prefix := os.Getenv("GLOBAL_DB_CONNECTION_PREFIX") // Used in test mode
-	if connStr == "" || username == "" || password == "" {
-		logger.Error.Fatal("Couldn't read DB credentials.")
+	connStr, username, password, err := getDBCredentials("GLOBAL_DB")
+	if err != nil {
+		logger.Error.Fatal(err)
 	}
+	prefix := os.Getenv("GLOBAL_DB_CONNECTION_PREFIX") // Used in test mode
 	URI := fmt.Sprintf(`mongodb%s://%s:%s@%s`, prefix, username, password, connStr)
 
-	var err error
 	Timeout, err := strconv.Atoi(os.Getenv("DB_TIMEOUT"))
 	if err != nil {
 		logger.Error.Fatal("DB_TIMEOUT: " + err.Error())
@@ -81,5 +81,39 @@ func GetGlobalDBConfig() models.DBConfig {
 		IdleConnTimeout: IdleConnTimeout,
 		MaxPoolSize:     MaxPoolSize,
 		DBNamePrefix:    DBNamePrefix,
+		TLS:             os.Getenv("GLOBAL_DB_TLS") == "true",
+		TLSCAFile:       os.Getenv("GLOBAL_DB_TLS_CA_FILE"),
+		ReadPreference:  os.Getenv("DB_READ_PREFERENCE"),
+		WriteConcern:    os.Getenv("DB_WRITE_CONCERN"),
+	}
+}
+
+// getDBCredentials reads connection string, username and password of the DB with the given env prefix (USER_DB or GLOBAL_DB)
+func getDBCredentials(envPrefix string) (connStr string, username string, password string, err error) {
+	if connStr, err = readEnvOrFile(envPrefix + "_CONNECTION_STR"); err != nil {
+		return
+	}
+	if username, err = readEnvOrFile(envPrefix + "_USERNAME"); err != nil {
+		return
+	}
+	if password, err = readEnvOrFile(envPrefix + "_PASSWORD"); err != nil {
+		return
+	}
+	if connStr == "" || username == "" || password == "" {
+		err = errors.New("couldn't read DB credentials")
+	}
+	return
+}
+
+// readEnvOrFile reads the value from the file given by <name>_FILE (e.g. a mounted secret) if set, otherwise from the env variable <name>
+func readEnvOrFile(name string) (string, error) {
+	fileName := os.Getenv(name + "_FILE")
+	if fileName == "" {
+		return os.Getenv(name), nil
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: %v", name, err)
 	}
+	return strings.TrimSpace(string(content)), nil
 }
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetDBCredentials(t *testing.T) {
+	t.Run("from secret files", func(t *testing.T) {
+		dir := t.TempDir()
+		pwFile := filepath.Join(dir, "password")
+		if err := os.WriteFile(pwFile, []byte("secret-pw\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("TEST_DB_CONNECTION_STR", "localhost:27017")
+		t.Setenv("TEST_DB_USERNAME", "user")
+		t.Setenv("TEST_DB_PASSWORD", "env-pw")
+		t.Setenv("TEST_DB_PASSWORD_FILE", pwFile)
+
+		connStr, username, password, err := getDBCredentials("TEST_DB")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if connStr != "localhost:27017" || username != "user" || password != "secret-pw" {
+			t.Errorf("unexpected credentials: %s, %s, %s", connStr, username, password)
+		}
+	})
+
+	t.Run("from env", func(t *testing.T) {
+		t.Setenv("TEST_DB_CONNECTION_STR", "localhost:27017")
+		t.Setenv("TEST_DB_USERNAME", "user")
+		t.Setenv("TEST_DB_PASSWORD", "env-pw")
+
+		_, username, password, err := getDBCredentials("TEST_DB")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if username != "user" || password != "env-pw" {
+			t.Errorf("unexpected credentials: %s, %s", username, password)
+		}
+	})
+
+	t.Run("with missing secret file", func(t *testing.T) {
+		t.Setenv("TEST_DB_CONNECTION_STR", "localhost:27017")
+		t.Setenv("TEST_DB_USERNAME", "user")
+		t.Setenv("TEST_DB_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+
+		if _, _, _, err := getDBCredentials("TEST_DB"); err == nil {
+			t.Error("error expected")
+		}
+	})
+
+	t.Run("without credentials", func(t *testing.T) {
+		t.Setenv("TEST_DB_CONNECTION_STR", "localhost:27017")
+
+		if _, _, _, err := getDBCredentials("TEST_DB"); err == nil {
+			t.Error("error expected")
+		}
+	})
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coneno/logger"
+	"github.com/influenzanet/user-management-service/pkg/models"
 )
 
 // parseDuration
@@ -37,3 +39,30 @@ func parseEnvDuration(name string, defaultValue time.Duration, defaultUnit strin
 	logger.Info.Printf("%s : using value %s", name, d)
 	return d
 }
+
+// parseMethodRateLimits reads comma separated limits "<method>=<burst>/<window>", e.g. "LoginWithEmail=10/1m",
+// the window is a duration or a number of seconds
+func parseMethodRateLimits(value string) (map[string]models.MethodRateLimit, error) {
+	limits := map[string]models.MethodRateLimit{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		method, limit, ok := strings.Cut(entry, "=")
+		burst, window, ok2 := strings.Cut(limit, "/")
+		if !ok || !ok2 || strings.TrimSpace(method) == "" {
+			return nil, fmt.Errorf("invalid rate limit '%s', expected <method>=<burst>/<window>", entry)
+		}
+		b, err := strconv.Atoi(strings.TrimSpace(burst))
+		if err != nil || b < 1 {
+			return nil, fmt.Errorf("invalid rate limit '%s', burst should be a strictly positive integer", entry)
+		}
+		w, err := parseDuration(strings.TrimSpace(window), "s")
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("invalid rate limit '%s', window should be a positive duration", entry)
+		}
+		limits[strings.TrimSpace(method)] = models.MethodRateLimit{Burst: b, Window: w}
+	}
+	return limits, nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"testing"
 	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
 )
 
 func test_duration(t *testing.T, value string, defaultUnit string, expect time.Duration, expect_err bool) {
@@ -50,3 +52,33 @@ func TestParseEnvDuration(t *testing.T) {
 	test_env_duration(t, "test_env", "", 18*time.Minute, "h", 18*time.Minute)
 	test_env_duration(t, "test_env", "5h", 18*time.Minute, "h", 5*time.Hour)
 }
+
+func TestParseMethodRateLimits(t *testing.T) {
+	t.Run("with valid limits", func(t *testing.T) {
+		limits, err := parseMethodRateLimits("LoginWithEmail=10/1m, SignupWithEmail=5/3600")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(limits) != 2 ||
+			limits["LoginWithEmail"] != (models.MethodRateLimit{Burst: 10, Window: time.Minute}) ||
+			limits["SignupWithEmail"] != (models.MethodRateLimit{Burst: 5, Window: time.Hour}) {
+			t.Errorf("unexpected limits: %v", limits)
+		}
+	})
+
+	t.Run("with empty value", func(t *testing.T) {
+		limits, err := parseMethodRateLimits("")
+		if err != nil || len(limits) != 0 {
+			t.Errorf("unexpected result: %v, %v", limits, err)
+		}
+	})
+
+	for _, value := range []string{"LoginWithEmail", "LoginWithEmail=10", "LoginWithEmail=0/1m", "LoginWithEmail=10/x", "=10/1m"} {
+		t.Run(fmt.Sprintf("with invalid value %s", value), func(t *testing.T) {
+			if _, err := parseMethodRateLimits(value); err == nil {
+				t.Error("error expected")
+			}
+		})
+	}
+}
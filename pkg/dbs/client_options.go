@@ -0,0 +1,75 @@
+package dbs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// ClientOptions builds the mongo client options from the DB config
+func ClientOptions(configs models.DBConfig) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(configs.URI).
+		SetMaxConnIdleTime(time.Duration(configs.IdleConnTimeout) * time.Second).
+		SetMaxPoolSize(configs.MaxPoolSize)
+
+	if configs.TLS {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if configs.TLSCAFile != "" {
+			pem, err := os.ReadFile(configs.TLSCAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("no certificate found in CA file " + configs.TLSCAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	if configs.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(configs.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	}
+
+	if configs.WriteConcern != "" {
+		// number of nodes, or "majority" / a tag set name
+		var w interface{} = configs.WriteConcern
+		if n, err := strconv.Atoi(configs.WriteConcern); err == nil {
+			w = n
+		}
+		opts.SetWriteConcern(&writeconcern.WriteConcern{W: w})
+	}
+
+	if configs.PoolMonitor != nil {
+		opts.SetPoolMonitor(configs.PoolMonitor)
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// SameServer tells if both configs connect to the same server with the same connection settings, so that one client
+// can be used for both, e.g. for a transaction covering collections of both
+func SameServer(a models.DBConfig, b models.DBConfig) bool {
+	return a.URI == b.URI && a.TLS == b.TLS && a.TLSCAFile == b.TLSCAFile &&
+		a.ReadPreference == b.ReadPreference && a.WriteConcern == b.WriteConcern
+}
@@ -0,0 +1,177 @@
+package dbs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func writeTestCAFile(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestClientOptions(t *testing.T) {
+	baseConfig := models.DBConfig{
+		URI:             "mongodb://localhost:27017",
+		MaxPoolSize:     8,
+		IdleConnTimeout: 45,
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		opts, err := ClientOptions(baseConfig)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if opts.TLSConfig != nil || opts.ReadPreference != nil || opts.WriteConcern != nil {
+			t.Error("unexpected options set")
+		}
+		if *opts.MaxPoolSize != 8 || *opts.MaxConnIdleTime != 45*time.Second {
+			t.Errorf("unexpected pool options: %d, %s", *opts.MaxPoolSize, *opts.MaxConnIdleTime)
+		}
+	})
+
+	t.Run("with TLS and system CAs", func(t *testing.T) {
+		conf := baseConfig
+		conf.TLS = true
+		opts, err := ClientOptions(conf)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if opts.TLSConfig == nil || opts.TLSConfig.RootCAs != nil {
+			t.Errorf("unexpected TLS config: %v", opts.TLSConfig)
+		}
+	})
+
+	t.Run("with TLS and CA file", func(t *testing.T) {
+		conf := baseConfig
+		conf.TLS = true
+		conf.TLSCAFile = writeTestCAFile(t)
+		opts, err := ClientOptions(conf)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if opts.TLSConfig == nil || opts.TLSConfig.RootCAs == nil {
+			t.Errorf("CA pool should be set: %v", opts.TLSConfig)
+		}
+	})
+
+	t.Run("with missing CA file", func(t *testing.T) {
+		conf := baseConfig
+		conf.TLS = true
+		conf.TLSCAFile = filepath.Join(t.TempDir(), "missing.pem")
+		if _, err := ClientOptions(conf); err == nil {
+			t.Error("error expected")
+		}
+	})
+
+	t.Run("with invalid CA file", func(t *testing.T) {
+		conf := baseConfig
+		conf.TLS = true
+		conf.TLSCAFile = filepath.Join(t.TempDir(), "invalid.pem")
+		if err := os.WriteFile(conf.TLSCAFile, []byte("not a certificate"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ClientOptions(conf); err == nil {
+			t.Error("error expected")
+		}
+	})
+
+	t.Run("with read preference", func(t *testing.T) {
+		conf := baseConfig
+		conf.ReadPreference = "secondaryPreferred"
+		opts, err := ClientOptions(conf)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if opts.ReadPreference == nil || opts.ReadPreference.Mode() != readpref.SecondaryPreferredMode {
+			t.Errorf("unexpected read preference: %v", opts.ReadPreference)
+		}
+	})
+
+	t.Run("with unknown read preference", func(t *testing.T) {
+		conf := baseConfig
+		conf.ReadPreference = "fastest"
+		if _, err := ClientOptions(conf); err == nil {
+			t.Error("error expected")
+		}
+	})
+
+	t.Run("with majority write concern", func(t *testing.T) {
+		conf := baseConfig
+		conf.WriteConcern = "majority"
+		opts, err := ClientOptions(conf)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if opts.WriteConcern == nil || opts.WriteConcern.W != "majority" {
+			t.Errorf("unexpected write concern: %v", opts.WriteConcern)
+		}
+	})
+
+	t.Run("with numeric write concern", func(t *testing.T) {
+		conf := baseConfig
+		conf.WriteConcern = "2"
+		opts, err := ClientOptions(conf)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if opts.WriteConcern == nil || opts.WriteConcern.W != 2 {
+			t.Errorf("unexpected write concern: %v", opts.WriteConcern)
+		}
+	})
+}
+
+func TestSameServer(t *testing.T) {
+	userDB := models.DBConfig{URI: "mongodb://db:27017", DBNamePrefix: "users_", Timeout: 30}
+	globalDB := models.DBConfig{URI: "mongodb://db:27017", DBNamePrefix: "global_", Timeout: 10}
+	if !SameServer(userDB, globalDB) {
+		t.Error("configs differing in DB names and timeout should use the same server")
+	}
+	globalDB.WriteConcern = "majority"
+	if SameServer(userDB, globalDB) {
+		t.Error("configs with different write concern should not share the client")
+	}
+	globalDB.WriteConcern = ""
+	globalDB.URI = "mongodb://other-db:27017"
+	if SameServer(userDB, globalDB) {
+		t.Error("configs with different URI should not share the client")
+	}
+}
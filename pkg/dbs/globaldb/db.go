@@ -5,9 +5,9 @@ import (
 	"time"
 
 	"github.com/coneno/logger"
+	"github.com/influenzanet/user-management-service/pkg/dbs"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type GlobalDBService struct {
@@ -17,12 +17,11 @@ type GlobalDBService struct {
 }
 
 func NewGlobalDBService(configs models.DBConfig) *GlobalDBService {
-	var err error
-	dbClient, err := mongo.NewClient(
-		options.Client().ApplyURI(configs.URI),
-		options.Client().SetMaxConnIdleTime(time.Duration(configs.IdleConnTimeout)*time.Second),
-		options.Client().SetMaxPoolSize(configs.MaxPoolSize),
-	)
+	clientOptions, err := dbs.ClientOptions(configs)
+	if err != nil {
+		logger.Error.Fatal(err)
+	}
+	dbClient, err := mongo.NewClient(clientOptions)
 	if err != nil {
 		logger.Error.Fatal(err)
 	}
@@ -42,6 +41,11 @@ func NewGlobalDBService(configs models.DBConfig) *GlobalDBService {
 		logger.Error.Fatal("fail to connect to DB: " + err.Error())
 	}
 
+	return NewGlobalDBServiceWithClient(dbClient, configs)
+}
+
+// NewGlobalDBServiceWithClient uses the connected client of another DB service, see dbs.SameServer
+func NewGlobalDBServiceWithClient(dbClient *mongo.Client, configs models.DBConfig) *GlobalDBService {
 	return &GlobalDBService{
 		DBClient:     dbClient,
 		timeout:      configs.Timeout,
@@ -62,7 +66,29 @@ func (dbService *GlobalDBService) collectionRefInstances() *mongo.Collection {
 	return dbService.DBClient.Database(dbService.DBNamePrefix + "global-infos").Collection("instances")
 }
 
+func (dbService *GlobalDBService) collectionRefIdempotencyKeys() *mongo.Collection {
+	return dbService.DBClient.Database(dbService.DBNamePrefix + "global-infos").Collection("idempotency-keys")
+}
+
+func (dbService *GlobalDBService) collectionRefReleasedEmails() *mongo.Collection {
+	return dbService.DBClient.Database(dbService.DBNamePrefix + "global-infos").Collection("released-emails")
+}
+
+func (dbService *GlobalDBService) collectionRefEmailRetries() *mongo.Collection {
+	return dbService.DBClient.Database(dbService.DBNamePrefix + "global-infos").Collection("email-retries")
+}
+
 // DB utils
 func (dbService *GlobalDBService) getContext() (ctx context.Context, cancel context.CancelFunc) {
-	return context.WithTimeout(context.Background(), time.Duration(dbService.timeout)*time.Second)
+	return dbService.getContextFrom(context.Background())
+}
+
+// getContextFrom keeps the session of parent, for the calls made within a transaction
+func (dbService *GlobalDBService) getContextFrom(parent context.Context) (ctx context.Context, cancel context.CancelFunc) {
+	return context.WithTimeout(parent, time.Duration(dbService.timeout)*time.Second)
+}
+
+// Ping checks that the DB server is reachable
+func (dbService *GlobalDBService) Ping(ctx context.Context) error {
+	return dbService.DBClient.Ping(ctx, nil)
 }
@@ -1,15 +1,32 @@
 package globaldb
 
 import (
+	"errors"
+	"time"
+
 	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func (dbService *GlobalDBService) FindAppToken(token string) (appTokenInfos models.AppToken, err error) {
 	ctx, cancel := dbService.getContext()
 	defer cancel()
 
-	filter := bson.M{"tokens": token}
+	filter := bson.M{
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"tokens": token},
+				bson.M{"tokenHash": tokens.HashAppToken(token)},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"expiresAt": bson.M{"$exists": false}},
+				bson.M{"expiresAt": 0},
+				bson.M{"expiresAt": bson.M{"$gt": time.Now().Unix()}},
+			}},
+		},
+	}
 	err = dbService.collectionAppToken().FindOne(ctx, filter).Decode(&appTokenInfos)
 	return
 }
@@ -21,3 +38,73 @@ func (dbService *GlobalDBService) AddAppToken(appToken models.AppToken) (err err
 	_, err = dbService.collectionAppToken().InsertOne(ctx, appToken)
 	return
 }
+
+// CreateAppToken generates a new token for the app and stores only its hash. The plain token is returned
+// and cannot be retrieved later.
+func (dbService *GlobalDBService) CreateAppToken(appToken models.AppToken) (token string, err error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	token, err = tokens.GenerateUniqueTokenString()
+	if err != nil {
+		return "", err
+	}
+	appToken.Tokens = nil
+	appToken.TokenHash = tokens.HashAppToken(token)
+	appToken.CreatedAt = time.Now().Unix()
+
+	_, err = dbService.collectionAppToken().InsertOne(ctx, appToken)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (dbService *GlobalDBService) FindAppTokensForInstance(instanceID string) (appTokens []models.AppToken, err error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	filter := bson.M{"instances": instanceID}
+	cur, err := dbService.collectionAppToken().Find(ctx, filter)
+	if err != nil {
+		return appTokens, err
+	}
+	defer cur.Close(ctx)
+
+	appTokens = []models.AppToken{}
+	err = cur.All(ctx, &appTokens)
+	return appTokens, err
+}
+
+// DeleteAppToken revokes the app token with the given id, if it is scoped to the instance
+func (dbService *GlobalDBService) DeleteAppToken(instanceID string, id string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": _id, "instances": instanceID}
+	res, err := dbService.collectionAppToken().DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount < 1 {
+		return errors.New("document not found")
+	}
+	return nil
+}
+
+// RemoveInstanceFromAppTokens removes the instance from the scope of all app tokens, tokens left without instance are deleted
+func (dbService *GlobalDBService) RemoveInstanceFromAppTokens(instanceID string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionAppToken().UpdateMany(ctx, bson.M{"instances": instanceID}, bson.M{"$pull": bson.M{"instances": instanceID}})
+	if err != nil {
+		return err
+	}
+	_, err = dbService.collectionAppToken().DeleteMany(ctx, bson.M{"instances": bson.M{"$size": 0}})
+	return err
+}
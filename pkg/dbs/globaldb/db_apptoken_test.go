@@ -2,6 +2,7 @@ package globaldb
 
 import (
 	"testing"
+	"time"
 
 	"github.com/influenzanet/user-management-service/pkg/models"
 )
@@ -39,3 +40,73 @@ func TestDbInterfaceMethodsForAppToken(t *testing.T) {
 		}
 	})
 }
+
+func TestDbCreateAndRevokeAppToken(t *testing.T) {
+	var token string
+	t.Run("Create app token", func(t *testing.T) {
+		var err error
+		token, err = testDBService.CreateAppToken(models.AppToken{
+			AppName:   "testapp-hashed",
+			Instances: []string{testInstanceID},
+			Scopes:    []string{"users:read", "users:delete"},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		appTokens, err := testDBService.FindAppTokensForInstance(testInstanceID)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		for _, at := range appTokens {
+			if at.AppName == "testapp-hashed" && (at.TokenHash == token || len(at.Tokens) > 0) {
+				t.Error("token should be stored hashed")
+			}
+		}
+	})
+
+	var appToken models.AppToken
+	t.Run("Validate app token", func(t *testing.T) {
+		var err error
+		appToken, err = testDBService.FindAppToken(token)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if appToken.AppName != "testapp-hashed" || len(appToken.Scopes) != 2 {
+			t.Error("app token object not retrieved correctly")
+		}
+	})
+
+	t.Run("Validate expired app token", func(t *testing.T) {
+		expiredToken, err := testDBService.CreateAppToken(models.AppToken{
+			AppName:   "testapp-expired",
+			Instances: []string{testInstanceID},
+			ExpiresAt: time.Now().Unix() - 10,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, err := testDBService.FindAppToken(expiredToken); err == nil {
+			t.Error("expired token should not be found")
+		}
+	})
+
+	t.Run("Revoke app token of other instance", func(t *testing.T) {
+		if err := testDBService.DeleteAppToken("other-instance", appToken.ID.Hex()); err == nil {
+			t.Error("should not be deleted")
+		}
+	})
+
+	t.Run("Revoke app token", func(t *testing.T) {
+		if err := testDBService.DeleteAppToken(testInstanceID, appToken.ID.Hex()); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, err := testDBService.FindAppToken(token); err == nil {
+			t.Error("revoked token should not be found")
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package globaldb
+
+import (
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (dbService *GlobalDBService) CreateIndexForEmailRetries() error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefEmailRetries().Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "nextAttemptAt", Value: 1},
+			},
+		},
+	)
+	return err
+}
+
+func (dbService *GlobalDBService) AddEmailRetry(e models.EmailRetry) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefEmailRetries().InsertOne(ctx, e)
+	return err
+}
+
+// ClaimDueEmailRetry returns the email with the earliest next attempt before now, and moves its next attempt to
+// leaseUntil so that other replicas don't send it at the same time. Returns mongo.ErrNoDocuments if none is due.
+func (dbService *GlobalDBService) ClaimDueEmailRetry(now int64, leaseUntil int64) (e models.EmailRetry, err error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	err = dbService.collectionRefEmailRetries().FindOneAndUpdate(
+		ctx,
+		bson.M{"nextAttemptAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"nextAttemptAt": leaseUntil}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}),
+	).Decode(&e)
+	return
+}
+
+// UpdateEmailRetryFailure records another failed attempt
+func (dbService *GlobalDBService) UpdateEmailRetryFailure(id primitive.ObjectID, attempts int, nextAttemptAt int64, lastError string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefEmailRetries().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"attempts":      attempts,
+		"nextAttemptAt": nextAttemptAt,
+		"lastError":     lastError,
+	}})
+	return err
+}
+
+func (dbService *GlobalDBService) DeleteEmailRetry(id primitive.ObjectID) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefEmailRetries().DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// DeleteEmailRetriesOfInstance removes the emails of the instance waiting to be sent again, and returns how many
+func (dbService *GlobalDBService) DeleteEmailRetriesOfInstance(instanceID string) (int64, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	res, err := dbService.collectionRefEmailRetries().DeleteMany(ctx, bson.M{"instanceID": instanceID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,40 @@
+package globaldb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDbDeleteEmailRetriesOfInstance(t *testing.T) {
+	instanceID := testInstanceID + "_email_retries_deleted"
+	// not due, so that no other test claims them
+	nextAttemptAt := time.Now().Add(time.Hour).Unix()
+	for _, id := range []string{instanceID, instanceID, testInstanceID} {
+		if err := testDBService.AddEmailRetry(models.EmailRetry{InstanceID: id, To: []string{"test@test.com"}, NextAttemptAt: nextAttemptAt}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	}
+	defer func() {
+		if _, err := testDBService.DeleteEmailRetriesOfInstance(testInstanceID); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	count, err := testDBService.DeleteEmailRetriesOfInstance(instanceID)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if count != 2 {
+		t.Errorf("unexpected number of deleted emails: %d", count)
+	}
+	kept, err := testDBService.collectionRefEmailRetries().CountDocuments(context.Background(), bson.M{"instanceID": testInstanceID})
+	if err != nil || kept != 1 {
+		t.Errorf("email of another instance should be kept: %d, %v", kept, err)
+	}
+}
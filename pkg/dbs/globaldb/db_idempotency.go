@@ -0,0 +1,107 @@
+package globaldb
+
+import (
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateIndexForIdempotencyKeys makes keys unique per instance and lets the DB remove expired keys
+func (dbService *GlobalDBService) CreateIndexForIdempotencyKeys() error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefIdempotencyKeys().Indexes().CreateMany(
+		ctx, []mongo.IndexModel{
+			{
+				Keys: bson.D{
+					{Key: "instanceID", Value: 1},
+					{Key: "key", Value: 1},
+				},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{
+					{Key: "expiresAt", Value: 1},
+				},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+	)
+	return err
+}
+
+// ReserveIdempotencyKey stores the key for a new request. If the key is already used, the existing record is returned instead.
+func (dbService *GlobalDBService) ReserveIdempotencyKey(instanceID string, key string, requestHash string, ttl time.Duration) (*models.IdempotencyRecord, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	now := time.Now()
+	// the TTL monitor runs only every minute, expired keys can still be there
+	if _, err := dbService.collectionRefIdempotencyKeys().DeleteOne(ctx, bson.M{
+		"instanceID": instanceID,
+		"key":        key,
+		"expiresAt":  bson.M{"$lte": now},
+	}); err != nil {
+		return nil, err
+	}
+
+	_, err := dbService.collectionRefIdempotencyKeys().InsertOne(ctx, models.IdempotencyRecord{
+		InstanceID:  instanceID,
+		Key:         key,
+		RequestHash: requestHash,
+		ExpiresAt:   now.Add(ttl),
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var existing models.IdempotencyRecord
+	if err := dbService.collectionRefIdempotencyKeys().FindOne(ctx, bson.M{"instanceID": instanceID, "key": key}).Decode(&existing); err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// SaveIdempotencyResult stores the ID of the user the request the key was reserved for succeeded with
+func (dbService *GlobalDBService) SaveIdempotencyResult(instanceID string, key string, userID string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	res, err := dbService.collectionRefIdempotencyKeys().UpdateOne(
+		ctx,
+		bson.M{"instanceID": instanceID, "key": key},
+		bson.M{"$set": bson.M{"userID": userID}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount < 1 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteIdempotencyKey releases the key, e.g. after the request failed, so it can be retried
+func (dbService *GlobalDBService) DeleteIdempotencyKey(instanceID string, key string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefIdempotencyKeys().DeleteOne(ctx, bson.M{"instanceID": instanceID, "key": key})
+	return err
+}
+
+// DeleteIdempotencyKeysOfInstance removes the idempotency keys of the instance, also the ones not expired yet
+func (dbService *GlobalDBService) DeleteIdempotencyKeysOfInstance(instanceID string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefIdempotencyKeys().DeleteMany(ctx, bson.M{"instanceID": instanceID})
+	return err
+}
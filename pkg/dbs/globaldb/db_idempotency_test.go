@@ -0,0 +1,91 @@
+package globaldb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDbIdempotencyKeys(t *testing.T) {
+	if err := testDBService.CreateIndexForIdempotencyKeys(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	t.Run("reserve new key", func(t *testing.T) {
+		existing, err := testDBService.ReserveIdempotencyKey(testInstanceID, "key-1", "hash-1", time.Minute)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if existing != nil {
+			t.Errorf("unexpected existing record: %v", existing)
+		}
+	})
+
+	t.Run("reserve key in use", func(t *testing.T) {
+		existing, err := testDBService.ReserveIdempotencyKey(testInstanceID, "key-1", "hash-2", time.Minute)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if existing == nil || existing.RequestHash != "hash-1" || existing.UserID != "" {
+			t.Errorf("unexpected existing record: %v", existing)
+		}
+	})
+
+	t.Run("same key in other instance", func(t *testing.T) {
+		existing, err := testDBService.ReserveIdempotencyKey(testInstanceID+"_other", "key-1", "hash-1", time.Minute)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if existing != nil {
+			t.Errorf("unexpected existing record: %v", existing)
+		}
+	})
+
+	t.Run("save result", func(t *testing.T) {
+		if err := testDBService.SaveIdempotencyResult(testInstanceID, "key-1", "user-1"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		existing, err := testDBService.ReserveIdempotencyKey(testInstanceID, "key-1", "hash-1", time.Minute)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if existing == nil || existing.UserID != "user-1" {
+			t.Errorf("unexpected existing record: %v", existing)
+		}
+	})
+
+	t.Run("expired key can be reserved again", func(t *testing.T) {
+		if _, err := testDBService.ReserveIdempotencyKey(testInstanceID, "key-2", "hash-1", -time.Second); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		existing, err := testDBService.ReserveIdempotencyKey(testInstanceID, "key-2", "hash-2", time.Minute)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if existing != nil {
+			t.Errorf("unexpected existing record: %v", existing)
+		}
+	})
+
+	t.Run("delete key", func(t *testing.T) {
+		if err := testDBService.DeleteIdempotencyKey(testInstanceID, "key-1"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		existing, err := testDBService.ReserveIdempotencyKey(testInstanceID, "key-1", "hash-3", time.Minute)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if existing != nil {
+			t.Errorf("unexpected existing record: %v", existing)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package globaldb
+
+import (
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"github.com/influenzanet/user-management-service/pkg/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// releasedEmailHash identifies the normalized address without storing it, it is only compared with the addresses of
+// later signups
+func releasedEmailHash(email string) (string, error) {
+	return tokens.KeyedHash([]byte(utils.NormalizeEmail(email)))
+}
+
+// CreateIndexForReleasedEmails makes addresses unique per instance and lets the DB remove them after the cooldown
+func (dbService *GlobalDBService) CreateIndexForReleasedEmails() error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefReleasedEmails().Indexes().CreateMany(
+		ctx, []mongo.IndexModel{
+			{
+				Keys: bson.D{
+					{Key: "instanceID", Value: 1},
+					{Key: "emailHash", Value: 1},
+				},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{
+					{Key: "expiresAt", Value: 1},
+				},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+	)
+	return err
+}
+
+// AddReleasedEmail records the address as released, it stays blocked for cooldown. Releasing it again restarts the cooldown.
+func (dbService *GlobalDBService) AddReleasedEmail(instanceID string, email string, cooldown time.Duration) error {
+	emailHash, err := releasedEmailHash(email)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err = dbService.collectionRefReleasedEmails().UpdateOne(
+		ctx,
+		bson.M{"instanceID": instanceID, "emailHash": emailHash},
+		bson.M{"$set": bson.M{"expiresAt": time.Now().Add(cooldown)}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// IsEmailReleasedRecently tells if the address was released and its cooldown is not over yet
+func (dbService *GlobalDBService) IsEmailReleasedRecently(instanceID string, email string) (bool, error) {
+	emailHash, err := releasedEmailHash(email)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	// the TTL monitor runs only every minute, expired addresses can still be there
+	count, err := dbService.collectionRefReleasedEmails().CountDocuments(ctx, bson.M{
+		"instanceID": instanceID,
+		"emailHash":  emailHash,
+		"expiresAt":  bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DeleteReleasedEmailsOfInstance removes the released addresses of the instance, also those still in cooldown
+func (dbService *GlobalDBService) DeleteReleasedEmailsOfInstance(instanceID string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefReleasedEmails().DeleteMany(ctx, bson.M{"instanceID": instanceID})
+	return err
+}
@@ -0,0 +1,99 @@
+package globaldb
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDbReleasedEmails(t *testing.T) {
+	t.Setenv("JWT_TOKEN_KEY", b64.StdEncoding.EncodeToString([]byte("test-secret-key-with-at-least-32-bytes")))
+	if err := testDBService.CreateIndexForReleasedEmails(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	t.Run("unknown address", func(t *testing.T) {
+		released, err := testDBService.IsEmailReleasedRecently(testInstanceID, "unknown@test.com")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if released {
+			t.Error("address should not be released")
+		}
+	})
+
+	t.Run("address during cooldown", func(t *testing.T) {
+		if err := testDBService.AddReleasedEmail(testInstanceID, "released@test.com", time.Hour); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		released, err := testDBService.IsEmailReleasedRecently(testInstanceID, "released@test.com")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if !released {
+			t.Error("address should be released")
+		}
+
+		released, err = testDBService.IsEmailReleasedRecently(testInstanceID+"_other", "released@test.com")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if released {
+			t.Error("address should not be released in other instance")
+		}
+	})
+
+	t.Run("address is not stored", func(t *testing.T) {
+		var doc bson.M
+		if err := testDBService.collectionRefReleasedEmails().FindOne(context.Background(), bson.M{"instanceID": testInstanceID}).Decode(&doc); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if _, ok := doc["email"]; ok {
+			t.Errorf("address should not be stored: %v", doc)
+		}
+		var released models.ReleasedEmail
+		if err := testDBService.collectionRefReleasedEmails().FindOne(context.Background(), bson.M{"instanceID": testInstanceID}).Decode(&released); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if released.EmailHash == "" || released.EmailHash == "released@test.com" {
+			t.Errorf("unexpected hash: %s", released.EmailHash)
+		}
+	})
+
+	t.Run("normalized address during cooldown", func(t *testing.T) {
+		released, err := testDBService.IsEmailReleasedRecently(testInstanceID, " Released@Test.com")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if !released {
+			t.Error("address should be released")
+		}
+	})
+
+	t.Run("address after cooldown", func(t *testing.T) {
+		if err := testDBService.AddReleasedEmail(testInstanceID, "released@test.com", -time.Second); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		released, err := testDBService.IsEmailReleasedRecently(testInstanceID, "released@test.com")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if released {
+			t.Error("address should not be released anymore")
+		}
+	})
+}
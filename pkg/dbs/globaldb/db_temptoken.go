@@ -1,21 +1,72 @@
 package globaldb
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/tokens"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func (dbService *GlobalDBService) AddTempToken(t models.TempToken) (token string, err error) {
+// expired temp tokens are kept for this time, in seconds, so they can still be reported as expired
+const tempTokenExpiredRetention = 3600
+
+// CreateIndexForTempTokens creates the TTL index removing temp tokens once expired for tempTokenExpiredRetention.
+// Tokens without expiresAt (created before the index existed) are not removed by it, see MigrateTempTokenExpiresAt.
+func (dbService *GlobalDBService) CreateIndexForTempTokens() error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefTempToken().Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "expiresAt", Value: 1},
+			},
+			Options: options.Index().SetExpireAfterSeconds(tempTokenExpiredRetention),
+		},
+	)
+	return err
+}
+
+// MigrateTempTokenExpiresAt sets expiresAt from expiration for the temp tokens created without it, and returns how many were updated
+func (dbService *GlobalDBService) MigrateTempTokenExpiresAt() (int64, error) {
 	ctx, cancel := dbService.getContext()
 	defer cancel()
 
+	res, err := dbService.collectionRefTempToken().UpdateMany(
+		ctx,
+		bson.M{"expiresAt": bson.M{"$exists": false}, "expiration": bson.M{"$gt": 0}},
+		bson.A{
+			bson.M{"$set": bson.M{"expiresAt": bson.M{"$toDate": bson.M{"$multiply": bson.A{"$expiration", 1000}}}}},
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (dbService *GlobalDBService) AddTempToken(t models.TempToken) (token string, err error) {
+	return dbService.AddTempTokenWithContext(context.Background(), t)
+}
+
+// AddTempTokenWithContext creates the temp token with the session of ctx, e.g. in a transaction of the user DB
+// sharing the client of the global DB
+func (dbService *GlobalDBService) AddTempTokenWithContext(ctx context.Context, t models.TempToken) (token string, err error) {
+	ctx, cancel := dbService.getContextFrom(ctx)
+	defer cancel()
+
 	t.Token, err = tokens.GenerateUniqueTokenString()
 	if err != nil {
 		return token, err
 	}
+	if t.Expiration > 0 {
+		t.ExpiresAt = time.Unix(t.Expiration, 0)
+	}
 
 	_, err = dbService.collectionRefTempToken().InsertOne(ctx, t)
 	if err != nil {
@@ -114,3 +165,60 @@ func (dbService *GlobalDBService) DeleteTempTokensExpireBefore(instanceID string
 	}
 	return nil
 }
+
+// GetTempTokenUserIDs returns the distinct user IDs referenced by the temp tokens of the instance
+func (dbService *GlobalDBService) GetTempTokenUserIDs(instanceID string) ([]string, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	values, err := dbService.collectionRefTempToken().Distinct(ctx, "userID", bson.M{"instanceID": instanceID})
+	if err != nil {
+		return nil, err
+	}
+	userIDs := []string{}
+	for _, v := range values {
+		if id, ok := v.(string); ok && id != "" {
+			userIDs = append(userIDs, id)
+		}
+	}
+	return userIDs, nil
+}
+
+// DeleteTempTokensOfUsers removes the temp tokens of the given users of the instance
+func (dbService *GlobalDBService) DeleteTempTokensOfUsers(instanceID string, userIDs []string) (int64, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	res, err := dbService.collectionRefTempToken().DeleteMany(ctx, bson.M{"instanceID": instanceID, "userID": bson.M{"$in": userIDs}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// DeleteAllTempTokensByPurpose removes the temp tokens of the given purpose for every user of the instance
+func (dbService *GlobalDBService) DeleteAllTempTokensByPurpose(instanceID string, purpose string) (int64, error) {
+	if instanceID == "" || purpose == "" {
+		return 0, errors.New("instanceID and purpose must be set")
+	}
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	res, err := dbService.collectionRefTempToken().DeleteMany(ctx, bson.M{"instanceID": instanceID, "purpose": purpose})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// DeleteAllTempTokensOfInstance removes the temp tokens of every user of the instance
+func (dbService *GlobalDBService) DeleteAllTempTokensOfInstance(instanceID string) (int64, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	res, err := dbService.collectionRefTempToken().DeleteMany(ctx, bson.M{"instanceID": instanceID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
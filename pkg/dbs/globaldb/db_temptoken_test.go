@@ -290,3 +290,177 @@ func TestDBDeleteExpiredTempTokens(t *testing.T) {
 		}
 	})
 }
+
+func TestDbDeleteAllTempTokensOfInstance(t *testing.T) {
+	instanceID := testInstanceID + "_deleted"
+	for _, tt := range []models.TempToken{
+		{UserID: "user_1", Purpose: "test_purpose1", InstanceID: instanceID, Expiration: tokens.GetExpirationTime(10 * time.Second)},
+		{UserID: "user_2", Purpose: "test_purpose2", InstanceID: instanceID, Expiration: tokens.GetExpirationTime(10 * time.Second)},
+	} {
+		if _, err := testDBService.AddTempToken(tt); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	}
+	otherToken, err := testDBService.AddTempToken(models.TempToken{UserID: "user_1", Purpose: "test_purpose1", InstanceID: testInstanceID, Expiration: tokens.GetExpirationTime(10 * time.Second)})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	count, err := testDBService.DeleteAllTempTokensOfInstance(instanceID)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if count != 2 {
+		t.Errorf("unexpected number of deleted tokens: %d", count)
+	}
+	if _, err := testDBService.GetTempToken(otherToken); err != nil {
+		t.Errorf("token of another instance should be kept: %v", err)
+	}
+}
+
+func TestDbDeleteAllTempTokensByPurpose(t *testing.T) {
+	instanceID := testInstanceID + "_by_purpose"
+	defer func() {
+		if _, err := testDBService.DeleteAllTempTokensOfInstance(instanceID); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+	for _, tt := range []models.TempToken{
+		{UserID: "user_1", Purpose: "test_purpose1", InstanceID: instanceID, Expiration: tokens.GetExpirationTime(10 * time.Second)},
+		{UserID: "user_2", Purpose: "test_purpose1", InstanceID: instanceID, Expiration: tokens.GetExpirationTime(10 * time.Second)},
+		{UserID: "user_1", Purpose: "test_purpose2", InstanceID: instanceID, Expiration: tokens.GetExpirationTime(10 * time.Second)},
+	} {
+		if _, err := testDBService.AddTempToken(tt); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	}
+	otherToken, err := testDBService.AddTempToken(models.TempToken{UserID: "user_1", Purpose: "test_purpose1", InstanceID: testInstanceID, Expiration: tokens.GetExpirationTime(10 * time.Second)})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	t.Run("without purpose", func(t *testing.T) {
+		if _, err := testDBService.DeleteAllTempTokensByPurpose(instanceID, ""); err == nil {
+			t.Error("error expected")
+		}
+	})
+
+	t.Run("without instance", func(t *testing.T) {
+		if _, err := testDBService.DeleteAllTempTokensByPurpose("", "test_purpose1"); err == nil {
+			t.Error("error expected")
+		}
+	})
+
+	t.Run("delete one purpose", func(t *testing.T) {
+		count, err := testDBService.DeleteAllTempTokensByPurpose(instanceID, "test_purpose1")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if count != 2 {
+			t.Errorf("unexpected number of deleted tokens: %d", count)
+		}
+		remaining, err := testDBService.GetTempTokenForUser(instanceID, "user_1", "")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(remaining) != 1 || remaining[0].Purpose != "test_purpose2" {
+			t.Errorf("unexpected remaining tokens: %v", remaining)
+		}
+		if _, err := testDBService.GetTempToken(otherToken); err != nil {
+			t.Errorf("token of another instance should be kept: %v", err)
+		}
+	})
+}
+
+func TestDbTempTokenExpiresAt(t *testing.T) {
+	instanceID := testInstanceID + "_expires_at"
+	defer func() {
+		if _, err := testDBService.DeleteAllTempTokensOfInstance(instanceID); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+	expiration := tokens.GetExpirationTime(time.Hour)
+
+	t.Run("index", func(t *testing.T) {
+		if err := testDBService.CreateIndexForTempTokens(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		cur, err := testDBService.collectionRefTempToken().Indexes().List(context.Background())
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		var indexes []bson.M
+		if err := cur.All(context.Background(), &indexes); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		found := false
+		for _, index := range indexes {
+			if index["name"] != "expiresAt_1" {
+				continue
+			}
+			found = true
+			if expireAfter, ok := index["expireAfterSeconds"].(int32); !ok || expireAfter != tempTokenExpiredRetention {
+				t.Errorf("unexpected TTL: %v", index["expireAfterSeconds"])
+			}
+		}
+		if !found {
+			t.Errorf("TTL index not found: %v", indexes)
+		}
+	})
+
+	t.Run("new token", func(t *testing.T) {
+		token, err := testDBService.AddTempToken(models.TempToken{UserID: "user_1", Purpose: "test_purpose1", InstanceID: instanceID, Expiration: expiration})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		tt, err := testDBService.GetTempToken(token)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if tt.ExpiresAt.Unix() != expiration {
+			t.Errorf("unexpected expiresAt: %v", tt.ExpiresAt)
+		}
+	})
+
+	t.Run("migrate token without expiresAt", func(t *testing.T) {
+		_, err := testDBService.collectionRefTempToken().InsertOne(context.Background(), bson.M{
+			"token":      "legacy-token",
+			"expiration": expiration,
+			"purpose":    "test_purpose1",
+			"userID":     "user_2",
+			"instanceID": instanceID,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		count, err := testDBService.MigrateTempTokenExpiresAt()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if count < 1 {
+			t.Errorf("unexpected number of migrated tokens: %d", count)
+		}
+		tt, err := testDBService.GetTempToken("legacy-token")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if tt.ExpiresAt.Unix() != expiration {
+			t.Errorf("unexpected expiresAt: %v", tt.ExpiresAt)
+		}
+	})
+}
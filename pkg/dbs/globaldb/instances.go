@@ -1,10 +1,18 @@
 package globaldb
 
 import (
+	"errors"
+
 	"github.com/influenzanet/go-utils/pkg/global_types"
+	"github.com/influenzanet/user-management-service/pkg/models"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrInstanceExists is returned when creating an instance with an ID already in use
+var ErrInstanceExists = errors.New("instance already exists")
+
 func (dbService *GlobalDBService) GetAllInstances() ([]global_types.Instance, error) {
 	ctx, cancel := dbService.getContext()
 	defer cancel()
@@ -36,3 +44,83 @@ func (dbService *GlobalDBService) GetAllInstances() ([]global_types.Instance, er
 
 	return instances, nil
 }
+
+// CreateInstance adds the instance document, fails with ErrInstanceExists if the instance ID is already used
+func (dbService *GlobalDBService) CreateInstance(instance models.Instance) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	res, err := dbService.collectionRefInstances().UpdateOne(
+		ctx,
+		bson.M{"instanceID": instance.InstanceID},
+		bson.M{"$setOnInsert": instance},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+	if res.UpsertedCount < 1 {
+		return ErrInstanceExists
+	}
+	return nil
+}
+
+// GetInstance returns the instance document, mongo.ErrNoDocuments if it doesn't exist
+func (dbService *GlobalDBService) GetInstance(instanceID string) (models.Instance, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	instance := models.Instance{}
+	err := dbService.collectionRefInstances().FindOne(ctx, bson.M{"instanceID": instanceID}).Decode(&instance)
+	return instance, err
+}
+
+// ListInstances returns the instance documents with their settings, sorted by instance ID
+func (dbService *GlobalDBService) ListInstances() ([]models.Instance, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	cur, err := dbService.collectionRefInstances().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"instanceID": 1}))
+	if err != nil {
+		return nil, err
+	}
+	instances := []models.Instance{}
+	err = cur.All(ctx, &instances)
+	return instances, err
+}
+
+// GetInstanceConfig returns the settings of the instance, an empty config if the instance has none
+func (dbService *GlobalDBService) GetInstanceConfig(instanceID string) (models.InstanceConfig, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	instance := models.Instance{}
+	err := dbService.collectionRefInstances().FindOne(ctx, bson.M{"instanceID": instanceID}).Decode(&instance)
+	if err == mongo.ErrNoDocuments {
+		return models.InstanceConfig{}, nil
+	}
+	return instance.UserManagement, err
+}
+
+// SaveInstanceConfig replaces the settings of the instance, the instance is created if it doesn't exist
+func (dbService *GlobalDBService) SaveInstanceConfig(instanceID string, config models.InstanceConfig) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefInstances().UpdateOne(
+		ctx,
+		bson.M{"instanceID": instanceID},
+		bson.M{"$set": bson.M{"userManagement": config}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// DeleteInstance removes the instance document
+func (dbService *GlobalDBService) DeleteInstance(instanceID string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefInstances().DeleteOne(ctx, bson.M{"instanceID": instanceID})
+	return err
+}
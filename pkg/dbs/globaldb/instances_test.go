@@ -2,6 +2,8 @@ package globaldb
 
 import (
 	"testing"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
 )
 
 func TestDbInterfaceMethods(t *testing.T) {
@@ -20,3 +22,87 @@ func TestDbInterfaceMethods(t *testing.T) {
 		}
 	})
 }
+
+func TestDbInstanceConfig(t *testing.T) {
+	t.Run("instance without config", func(t *testing.T) {
+		conf, err := testDBService.GetInstanceConfig("instance_without_config")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if conf.VerificationCodeLifetime != 0 {
+			t.Errorf("unexpected config: %v", conf)
+		}
+	})
+
+	t.Run("save and read config", func(t *testing.T) {
+		err := testDBService.SaveInstanceConfig("instance_with_config", models.InstanceConfig{VerificationCodeLifetime: 300})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		conf, err := testDBService.GetInstanceConfig("instance_with_config")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if conf.VerificationCodeLifetime != 300 {
+			t.Errorf("unexpected config: %v", conf)
+		}
+	})
+}
+
+func TestDbCreateInstance(t *testing.T) {
+	instance := models.Instance{
+		InstanceID:     "created_instance",
+		UserManagement: models.InstanceConfig{VerificationCodeLifetime: 120},
+	}
+
+	t.Run("create instance", func(t *testing.T) {
+		if err := testDBService.CreateInstance(instance); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("create instance with existing ID", func(t *testing.T) {
+		err := testDBService.CreateInstance(models.Instance{InstanceID: instance.InstanceID})
+		if err != ErrInstanceExists {
+			t.Errorf("unexpected error: %v", err)
+		}
+		conf, err := testDBService.GetInstanceConfig(instance.InstanceID)
+		if err != nil || conf.VerificationCodeLifetime != 120 {
+			t.Errorf("existing instance should be kept: %v, %v", conf, err)
+		}
+	})
+
+	t.Run("get instance", func(t *testing.T) {
+		i, err := testDBService.GetInstance(instance.InstanceID)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if i.InstanceID != instance.InstanceID || i.UserManagement.VerificationCodeLifetime != 120 {
+			t.Errorf("unexpected instance: %v", i)
+		}
+		if _, err := testDBService.GetInstance("unknown_instance"); err == nil {
+			t.Error("unknown instance should not be found")
+		}
+	})
+
+	t.Run("list instances", func(t *testing.T) {
+		instances, err := testDBService.ListInstances()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		found := false
+		for _, i := range instances {
+			if i.InstanceID == instance.InstanceID {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("created instance not listed: %v", instances)
+		}
+	})
+}
@@ -0,0 +1,21 @@
+package dbs
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SupportsTransactions tells if the client is connected to a replica set or to a mongos, where multi-document
+// transactions are available. A standalone server does not support them.
+func SupportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	hello := bson.M{}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	if setName, ok := hello["setName"].(string); ok && setName != "" {
+		return true, nil
+	}
+	return hello["msg"] == "isdbgrid", nil
+}
@@ -2,31 +2,36 @@ package userdb
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/coneno/logger"
+	"github.com/influenzanet/user-management-service/pkg/dbs"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const UserCollection = "users"
 const RenewTokenCollection = "renewTokens"
+const ServiceAccountTokenCollection = "serviceAccountTokens"
+const RoleGuardCollection = "roleGuards"
 
 type UserDBService struct {
 	DBClient        *mongo.Client
 	timeout         int
 	noCursorTimeout bool
 	DBNamePrefix    string
+
+	transactionsMu sync.Mutex
+	transactions   *bool // cached result of SupportsTransactions
 }
 
 func NewUserDBService(configs models.DBConfig) *UserDBService {
-	var err error
-	dbClient, err := mongo.NewClient(
-		options.Client().ApplyURI(configs.URI),
-		options.Client().SetMaxConnIdleTime(time.Duration(configs.IdleConnTimeout)*time.Second),
-		options.Client().SetMaxPoolSize(configs.MaxPoolSize),
-	)
+	clientOptions, err := dbs.ClientOptions(configs)
+	if err != nil {
+		logger.Error.Fatal(err)
+	}
+	dbClient, err := mongo.NewClient(clientOptions)
 	if err != nil {
 		logger.Error.Fatal(err)
 	}
@@ -64,9 +69,21 @@ func (dbSerive *UserDBService) collectionRenewTokens(instanceID string) *mongo.C
 	return dbSerive.DBClient.Database(dbSerive.DBNamePrefix + instanceID + "_users").Collection(RenewTokenCollection)
 }
 
+// collectionServiceAccountTokens get collection for the issued service account tokens
+func (dbService *UserDBService) collectionServiceAccountTokens(instanceID string) *mongo.Collection {
+	return dbService.DBClient.Database(dbService.DBNamePrefix + instanceID + "_users").Collection(ServiceAccountTokenCollection)
+}
+
+// collectionRoleGuards get collection for the documents written by role removals, see RemoveRoleUnlessLastHolder
+func (dbService *UserDBService) collectionRoleGuards(instanceID string) *mongo.Collection {
+	return dbService.DBClient.Database(dbService.DBNamePrefix + instanceID + "_users").Collection(RoleGuardCollection)
+}
+
 // DB utils
-func (dbService *UserDBService) getContext() (ctx context.Context, cancel context.CancelFunc) {
-	return context.WithTimeout(context.Background(), time.Duration(dbService.timeout)*time.Second)
+// getContext derives the context for a DB call from the caller's one: a cancelled request stops the query,
+// and the configured timeout still bounds its duration
+func (dbService *UserDBService) getContext(parent context.Context) (ctx context.Context, cancel context.CancelFunc) {
+	return context.WithTimeout(parent, time.Duration(dbService.timeout)*time.Second)
 }
 
 func (dbService *UserDBService) GetTimeout() time.Duration {
@@ -78,6 +95,53 @@ func (dbService *UserDBService) GetContext() (ctx context.Context, cancel contex
 	return context.WithTimeout(context.Background(), time.Duration(dbService.timeout)*time.Second)
 }
 
+// Ping checks that the DB server is reachable
+func (dbService *UserDBService) Ping(ctx context.Context) error {
+	return dbService.DBClient.Ping(ctx, nil)
+}
+
+// SupportsTransactions tells if multi-document transactions are available on the DB server, the result is kept once known
+func (dbService *UserDBService) SupportsTransactions(ctx context.Context) bool {
+	dbService.transactionsMu.Lock()
+	defer dbService.transactionsMu.Unlock()
+	if dbService.transactions != nil {
+		return *dbService.transactions
+	}
+
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+	supported, err := dbs.SupportsTransactions(ctx, dbService.DBClient)
+	if err != nil {
+		logger.Error.Printf("failed to check transaction support: %v", err)
+		return false
+	}
+	dbService.transactions = &supported
+	return supported
+}
+
+// WithTransaction runs fn in a transaction, the DB calls of fn have to use the given context. fn may run again when
+// the transaction failed with a transient error, e.g. a write conflict with a concurrent transaction.
+func (dbService *UserDBService) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := dbService.DBClient.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// DropInstanceDB removes the users, renew tokens and service account tokens of the instance
+func (dbService *UserDBService) DropInstanceDB(ctx context.Context, instanceID string) error {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	return dbService.DBClient.Database(dbService.DBNamePrefix + instanceID + "_users").Drop(ctx)
+}
+
 // GetCollection from userDb service.
 // Generic public function to be useable in migration scripts
 func (dbService *UserDBService) GetCollection(instanceID string, name string) *mongo.Collection {
@@ -14,8 +14,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func (dbService *UserDBService) AddUser(instanceID string, user models.User) (id string, err error) {
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) AddUser(ctx context.Context, instanceID string, user models.User) (id string, err error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
 	filter := bson.M{"account.accountID": user.Account.AccountID}
@@ -31,7 +31,7 @@ func (dbService *UserDBService) AddUser(instanceID string, user models.User) (id
 	}
 
 	if res.UpsertedCount < 1 {
-		err = errors.New("user already exists")
+		err = ErrUserExists
 		return
 	}
 
@@ -39,42 +39,104 @@ func (dbService *UserDBService) AddUser(instanceID string, user models.User) (id
 	return
 }
 
-// low level find and replace
-func (dbService *UserDBService) _updateUserInDB(orgID string, user models.User) (models.User, error) {
-	ctx, cancel := dbService.getContext()
+// ErrUserExists is returned by AddUser if the account ID is already used
+var ErrUserExists = errors.New("user already exists")
+
+// ErrUserVersionConflict is returned when the user was modified since it has been read, the caller can read it again and retry
+var ErrUserVersionConflict = errors.New("user was modified concurrently")
+
+// ErrUserNotFound is returned when no user exists with the given ID, other errors are failures to reach the DB
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrNoAttemptsLeft is returned by CountVerificationCodeAttempt when the code has no attempts left or was replaced
+var ErrNoAttemptsLeft = errors.New("no attempts left")
+
+// ErrInvalidUserID is returned when the user ID is not a valid ObjectID, instead of querying with the zero ID
+var ErrInvalidUserID = errors.New("invalid user id")
+
+func parseUserID(id string) (primitive.ObjectID, error) {
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return _id, ErrInvalidUserID
+	}
+	return _id, nil
+}
+
+// low level find and replace, only if the stored version is still the one of the given user. The atomic updates of
+// single fields increment the version as well, so that saving a user read before them fails instead of reverting them.
+func (dbService *UserDBService) _updateUserInDB(ctx context.Context, orgID string, user models.User) (models.User, error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
+	filter := bson.M{"_id": user.ID, "version": user.Version}
+	if user.Version == 0 {
+		// documents stored before versioning was introduced
+		filter = bson.M{"_id": user.ID, "$or": bson.A{
+			bson.M{"version": 0},
+			bson.M{"version": bson.M{"$exists": false}},
+		}}
+	}
+	user.Version += 1
+
 	elem := models.User{}
-	filter := bson.M{"_id": user.ID}
 	rd := options.After
 	fro := options.FindOneAndReplaceOptions{
 		ReturnDocument: &rd,
 	}
 	err := dbService.collectionRefUsers(orgID).FindOneAndReplace(ctx, filter, user, &fro).Decode(&elem)
+	if err == mongo.ErrNoDocuments {
+		count, cErr := dbService.collectionRefUsers(orgID).CountDocuments(ctx, bson.M{"_id": user.ID})
+		if cErr == nil && count > 0 {
+			return elem, ErrUserVersionConflict
+		}
+	}
 	return elem, err
 }
 
-func (dbService *UserDBService) UpdateUser(instanceID string, updatedUser models.User) (models.User, error) {
+func (dbService *UserDBService) UpdateUser(ctx context.Context, instanceID string, updatedUser models.User) (models.User, error) {
 	// Set last update time
 	updatedUser.Timestamps.UpdatedAt = time.Now().Unix()
-	return dbService._updateUserInDB(instanceID, updatedUser)
+	return dbService._updateUserInDB(ctx, instanceID, updatedUser)
 }
 
-func (dbService *UserDBService) GetUserByID(instanceID string, id string) (models.User, error) {
-	_id, _ := primitive.ObjectIDFromHex(id)
+func (dbService *UserDBService) GetUserByID(ctx context.Context, instanceID string, id string) (models.User, error) {
+	_id, err := parseUserID(id)
+	if err != nil {
+		return models.User{}, err
+	}
 	filter := bson.M{"_id": _id}
 
-	ctx, cancel := dbService.getContext()
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
 	elem := models.User{}
-	err := dbService.collectionRefUsers(instanceID).FindOne(ctx, filter).Decode(&elem)
+	err = dbService.collectionRefUsers(instanceID).FindOne(ctx, filter).Decode(&elem)
+	if err == mongo.ErrNoDocuments {
+		return elem, ErrUserNotFound
+	}
+	if err != nil {
+		return elem, err
+	}
 
-	return elem, err
+	if changes := elem.Migrate(); changes != nil {
+		// the user is returned migrated even if it could not be saved, a later read retries
+		if err := dbService.saveUserMigration(ctx, instanceID, elem.ID, changes); err != nil {
+			logger.Error.Printf("failed to save migrated user %s: %v", id, err)
+		}
+	}
+	return elem, nil
+}
+
+// saveUserMigration sets the fields changed by a schema migration, unless the document was migrated in the meantime.
+// The version is kept: the migrated user is returned to the caller, who may save it with this version.
+func (dbService *UserDBService) saveUserMigration(ctx context.Context, instanceID string, id primitive.ObjectID, changes map[string]interface{}) error {
+	filter := bson.M{"_id": id, "schemaVersion": bson.M{"$not": bson.M{"$gte": models.CurrentUserSchemaVersion}}}
+	_, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, bson.M{"$set": changes})
+	return err
 }
 
-func (dbService *UserDBService) GetUserByAccountID(instanceID string, username string) (models.User, error) {
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) GetUserByAccountID(ctx context.Context, instanceID string, username string) (models.User, error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
 	elem := models.User{}
@@ -84,53 +146,131 @@ func (dbService *UserDBService) GetUserByAccountID(instanceID string, username s
 	return elem, err
 }
 
-func (dbService *UserDBService) UpdateUserPassword(instanceID string, userID string, newPassword string) error {
-	ctx, cancel := dbService.getContext()
+// GetUserByConfirmedEmail returns the user with the email address as account ID or, if there is none, as confirmed
+// contact email. Returns ErrUserNotFound if no user, or more than one user, has the address as confirmed contact.
+func (dbService *UserDBService) GetUserByConfirmedEmail(ctx context.Context, instanceID string, email string) (models.User, error) {
+	user, err := dbService.GetUserByAccountID(ctx, instanceID, email)
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	filter := bson.M{"contactInfos": bson.M{"$elemMatch": bson.M{
+		"type":        "email",
+		"email":       email,
+		"confirmedAt": bson.M{"$gt": 0},
+	}}}
+	cur, err := dbService.collectionRefUsers(instanceID).Find(ctx, filter, options.Find().SetLimit(2))
+	if err != nil {
+		return models.User{}, err
+	}
+	users := []models.User{}
+	if err := cur.All(ctx, &users); err != nil {
+		return models.User{}, err
+	}
+	if len(users) != 1 {
+		// an address confirmed by several accounts doesn't identify one of them
+		return models.User{}, ErrUserNotFound
+	}
+	return users[0], nil
+}
+
+func (dbService *UserDBService) UpdateUserPassword(ctx context.Context, instanceID string, userID string, newPassword string) error {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
-	_id, _ := primitive.ObjectIDFromHex(userID)
+	_id, err := parseUserID(userID)
+	if err != nil {
+		return err
+	}
 	filter := bson.M{"_id": _id}
-	update := bson.M{"$set": bson.M{"account.password": newPassword, "timestamps.lastPasswordChange": time.Now().Unix()}}
-	_, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	update := bson.M{"$set": bson.M{"account.password": newPassword, "timestamps.lastPasswordChange": time.Now().Unix()}, "$inc": bson.M{"version": 1}}
+	_, err = dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (dbService *UserDBService) SaveFailedLoginAttempt(instanceID string, userID string) error {
-	ctx, cancel := dbService.getContext()
+// CountVerificationCodeAttempt counts an attempt on the pending verification code of the email address, only if the
+// code is still the given one and has less than maxAttempts attempts, and returns the updated user
+func (dbService *UserDBService) CountVerificationCodeAttempt(ctx context.Context, instanceID string, userID string, addr string, code string, maxAttempts int64) (models.User, error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
-	_id, _ := primitive.ObjectIDFromHex(userID)
+	_id, err := parseUserID(userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	filter := bson.M{
+		"_id": _id,
+		"contactInfos": bson.M{"$elemMatch": bson.M{
+			"type":                      "email",
+			"email":                     addr,
+			"verificationCode.code":     code,
+			"verificationCode.attempts": bson.M{"$lt": maxAttempts},
+		}},
+	}
+	// the version is incremented so that a concurrent update of a stale read can't reset the counter
+	update := bson.M{"$inc": bson.M{
+		"contactInfos.$.verificationCode.attempts": 1,
+		"version": 1,
+	}}
+	rd := options.After
+	elem := models.User{}
+	err = dbService.collectionRefUsers(instanceID).FindOneAndUpdate(ctx, filter, update, &options.FindOneAndUpdateOptions{
+		ReturnDocument: &rd,
+	}).Decode(&elem)
+	if err == mongo.ErrNoDocuments {
+		return elem, ErrNoAttemptsLeft
+	}
+	return elem, err
+}
+
+func (dbService *UserDBService) SaveFailedLoginAttempt(ctx context.Context, instanceID string, userID string) error {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	_id, err := parseUserID(userID)
+	if err != nil {
+		return err
+	}
 	filter := bson.M{"_id": _id}
-	update := bson.M{"$push": bson.M{"account.failedLoginAttempts": time.Now().Unix()}}
-	_, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	update := bson.M{"$push": bson.M{"account.failedLoginAttempts": time.Now().Unix()}, "$inc": bson.M{"version": 1}}
+	_, err = dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (dbService *UserDBService) SavePasswordResetTrigger(instanceID string, userID string) error {
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) SavePasswordResetTrigger(ctx context.Context, instanceID string, userID string) error {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
-	_id, _ := primitive.ObjectIDFromHex(userID)
+	_id, err := parseUserID(userID)
+	if err != nil {
+		return err
+	}
 	filter := bson.M{"_id": _id}
-	update := bson.M{"$push": bson.M{"account.passwordResetTriggers": time.Now().Unix()}}
-	_, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	update := bson.M{"$push": bson.M{"account.passwordResetTriggers": time.Now().Unix()}, "$inc": bson.M{"version": 1}}
+	_, err = dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (dbService *UserDBService) UpdateAccountPreferredLang(instanceID string, userID string, lang string) (models.User, error) {
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) UpdateAccountPreferredLang(ctx context.Context, instanceID string, userID string, lang string) (models.User, error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
-	_id, _ := primitive.ObjectIDFromHex(userID)
+	_id, err := parseUserID(userID)
+	if err != nil {
+		return models.User{}, err
+	}
 	filter := bson.M{"_id": _id}
 
 	elem := models.User{}
@@ -139,16 +279,19 @@ func (dbService *UserDBService) UpdateAccountPreferredLang(instanceID string, us
 	fro := options.FindOneAndUpdateOptions{
 		ReturnDocument: &rd,
 	}
-	update := bson.M{"$set": bson.M{"account.preferredLanguage": lang, "timestamps.updatedAt": time.Now().Unix()}}
-	err := dbService.collectionRefUsers(instanceID).FindOneAndUpdate(ctx, filter, update, &fro).Decode(&elem)
+	update := bson.M{"$set": bson.M{"account.preferredLanguage": lang, "timestamps.updatedAt": time.Now().Unix()}, "$inc": bson.M{"version": 1}}
+	err = dbService.collectionRefUsers(instanceID).FindOneAndUpdate(ctx, filter, update, &fro).Decode(&elem)
 	return elem, err
 }
 
-func (dbService *UserDBService) UpdateContactPreferences(instanceID string, userID string, prefs models.ContactPreferences) (models.User, error) {
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) UpdateContactPreferences(ctx context.Context, instanceID string, userID string, prefs models.ContactPreferences) (models.User, error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
-	_id, _ := primitive.ObjectIDFromHex(userID)
+	_id, err := parseUserID(userID)
+	if err != nil {
+		return models.User{}, err
+	}
 	filter := bson.M{"_id": _id}
 
 	elem := models.User{}
@@ -157,51 +300,182 @@ func (dbService *UserDBService) UpdateContactPreferences(instanceID string, user
 	fro := options.FindOneAndUpdateOptions{
 		ReturnDocument: &rd,
 	}
-	update := bson.M{"$set": bson.M{"contactPreferences": prefs, "timestamps.updatedAt": time.Now().Unix()}}
-	err := dbService.collectionRefUsers(instanceID).FindOneAndUpdate(ctx, filter, update, &fro).Decode(&elem)
+	update := bson.M{"$set": bson.M{"contactPreferences": prefs, "timestamps.updatedAt": time.Now().Unix()}, "$inc": bson.M{"version": 1}}
+	err = dbService.collectionRefUsers(instanceID).FindOneAndUpdate(ctx, filter, update, &fro).Decode(&elem)
 	return elem, err
 }
 
-func (dbService *UserDBService) UpdateLoginTime(instanceID string, id string) error {
-	ctx, cancel := dbService.getContext()
+// AddRoleToUser adds the role to the user in a single update, the role is not duplicated if already present
+func (dbService *UserDBService) AddRoleToUser(ctx context.Context, instanceID string, userID string, role string) (models.User, error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
-	_id, _ := primitive.ObjectIDFromHex(id)
+	_id, err := parseUserID(userID)
+	if err != nil {
+		return models.User{}, err
+	}
 	filter := bson.M{"_id": _id}
-	update := bson.M{"$set": bson.M{
-		"timestamps.lastLogin":         time.Now().Unix(),
-		"timestamps.updatedAt":         time.Now().Unix(),
-		"timestamps.markedForDeletion": 0,
-	}}
-	_, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+
+	elem := models.User{}
+
+	rd := options.After
+	fro := options.FindOneAndUpdateOptions{
+		ReturnDocument: &rd,
+	}
+	update := bson.M{"$addToSet": bson.M{"roles": role}, "$set": bson.M{"timestamps.updatedAt": time.Now().Unix()}, "$inc": bson.M{"version": 1}}
+	err = dbService.collectionRefUsers(instanceID).FindOneAndUpdate(ctx, filter, update, &fro).Decode(&elem)
+	return elem, err
+}
+
+// RemoveRoleFromUser removes the role from the user in a single update
+func (dbService *UserDBService) RemoveRoleFromUser(ctx context.Context, instanceID string, userID string, role string) (models.User, error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	_id, err := parseUserID(userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	filter := bson.M{"_id": _id}
+
+	elem := models.User{}
+
+	rd := options.After
+	fro := options.FindOneAndUpdateOptions{
+		ReturnDocument: &rd,
+	}
+	update := bson.M{"$pull": bson.M{"roles": role}, "$set": bson.M{"timestamps.updatedAt": time.Now().Unix()}, "$inc": bson.M{"version": 1}}
+	err = dbService.collectionRefUsers(instanceID).FindOneAndUpdate(ctx, filter, update, &fro).Decode(&elem)
+	return elem, err
+}
+
+// ErrLastRoleHolder is returned by RemoveRoleUnlessLastHolder when no other user of the instance has the role
+var ErrLastRoleHolder = errors.New("no other user has the role")
+
+// RemoveRoleUnlessLastHolder removes the role from the user, unless no other user of the instance has it.
+// With transactions, the check and the removal are one transaction, and concurrent removals of the role conflict on
+// a guard document so that one of them sees the other's result. On a standalone server, the role is given back when
+// no user has it anymore after the removal.
+func (dbService *UserDBService) RemoveRoleUnlessLastHolder(ctx context.Context, instanceID string, userID string, role string) (models.User, error) {
+	_id, err := parseUserID(userID)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if !dbService.SupportsTransactions(ctx) {
+		user, err := dbService.RemoveRoleFromUser(ctx, instanceID, userID, role)
+		if err != nil {
+			return user, err
+		}
+		count, err := dbService.CountUsersWithRole(ctx, instanceID, role)
+		if err == nil && count > 0 {
+			return user, nil
+		}
+		if _, addErr := dbService.AddRoleToUser(ctx, instanceID, userID, role); addErr != nil {
+			logger.Error.Printf("role %s of %s could not be given back: %v", role, userID, addErr)
+		}
+		if err != nil {
+			return user, err
+		}
+		return user, ErrLastRoleHolder
+	}
+
+	user := models.User{}
+	err = dbService.WithTransaction(ctx, func(ctx context.Context) error {
+		gCtx, cancel := dbService.getContext(ctx)
+		defer cancel()
+		_, err := dbService.collectionRoleGuards(instanceID).UpdateOne(gCtx,
+			bson.M{"_id": role},
+			bson.M{"$inc": bson.M{"removals": 1}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+
+		others, err := dbService.collectionRefUsers(instanceID).CountDocuments(gCtx, bson.M{"roles": role, "_id": bson.M{"$ne": _id}})
+		if err != nil {
+			return err
+		}
+		if others == 0 {
+			return ErrLastRoleHolder
+		}
+		user, err = dbService.RemoveRoleFromUser(ctx, instanceID, userID, role)
+		return err
+	})
+	return user, err
+}
+
+func (dbService *UserDBService) CountUsersWithRole(ctx context.Context, instanceID string, role string) (count int64, err error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	filter := bson.M{"roles": role}
+	count, err = dbService.collectionRefUsers(instanceID).CountDocuments(ctx, filter)
+	return
+}
+
+// UpdateLoginTime sets the login time and adds the login to the recent logins of the user, keeping the latest historySize ones
+func (dbService *UserDBService) UpdateLoginTime(ctx context.Context, instanceID string, id string, login models.LoginRecord, historySize int) error {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	_id, err := parseUserID(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": _id}
+	now := time.Now().Unix()
+	if login.Time == 0 {
+		login.Time = now
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"timestamps.lastLogin":         now,
+			"timestamps.updatedAt":         now,
+			"timestamps.markedForDeletion": 0,
+		},
+		"$push": bson.M{"recentLogins": bson.M{
+			"$each":  bson.A{login},
+			"$slice": -historySize,
+		}},
+		"$inc": bson.M{"version": 1},
+	}
+	_, err = dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (dbService *UserDBService) UpdateReminderToConfirmSentAtTime(instanceID string, id string) error {
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) UpdateReminderToConfirmSentAtTime(ctx context.Context, instanceID string, id string) error {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
-	_id, _ := primitive.ObjectIDFromHex(id)
+	_id, err := parseUserID(id)
+	if err != nil {
+		return err
+	}
 	filter := bson.M{"_id": _id}
-	update := bson.M{"$set": bson.M{"timestamps.reminderToConfirmSentAt": time.Now().Unix()}}
-	_, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	update := bson.M{"$set": bson.M{"timestamps.reminderToConfirmSentAt": time.Now().Unix()}, "$inc": bson.M{"version": 1}}
+	_, err = dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (dbService *UserDBService) UpdateMarkedForDeletionTime(instanceID string, id string, dT int64, reset bool) (bool, error) {
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) UpdateMarkedForDeletionTime(ctx context.Context, instanceID string, id string, dT int64, reset bool) (bool, error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
-	_id, _ := primitive.ObjectIDFromHex(id)
+	_id, err := parseUserID(id)
+	if err != nil {
+		return false, err
+	}
 	if reset {
 		filter := bson.M{"_id": _id}
-		update := bson.M{"$set": bson.M{"timestamps.markedForDeletion": 0}}
+		update := bson.M{"$set": bson.M{"timestamps.markedForDeletion": 0}, "$inc": bson.M{"version": 1}}
 		res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
 		if err != nil {
 			return false, err
@@ -211,12 +485,25 @@ func (dbService *UserDBService) UpdateMarkedForDeletionTime(instanceID string, i
 		}
 		return false, nil
 	}
+	return dbService.MarkForDeletionAt(ctx, instanceID, id, time.Now().Unix()+dT)
+}
+
+// MarkForDeletionAt sets the deletion time of the user, false if the user is already marked for deletion or anonymized
+func (dbService *UserDBService) MarkForDeletionAt(ctx context.Context, instanceID string, id string, deletionTime int64) (bool, error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	_id, err := parseUserID(id)
+	if err != nil {
+		return false, err
+	}
 	filter := bson.M{}
 	filter["$and"] = bson.A{
 		bson.M{"_id": _id},
 		bson.M{"timestamps.markedForDeletion": bson.M{"$not": bson.M{"$gt": 0}}},
+		bson.M{"timestamps.anonymizedAt": bson.M{"$not": bson.M{"$gt": 0}}},
 	}
-	update := bson.M{"$set": bson.M{"timestamps.markedForDeletion": time.Now().Unix() + dT}}
+	update := bson.M{"$set": bson.M{"timestamps.markedForDeletion": deletionTime}, "$inc": bson.M{"version": 1}}
 	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
 	if err != nil {
 		return false, err
@@ -227,8 +514,23 @@ func (dbService *UserDBService) UpdateMarkedForDeletionTime(instanceID string, i
 	return false, nil
 }
 
-func (dbService *UserDBService) CountRecentlyCreatedUsers(instanceID string, interval int64) (count int64, err error) {
-	ctx, cancel := dbService.getContext()
+// UpdateInactivityWarningSentAt saves when the user was warned about the deletion of the inactive account
+func (dbService *UserDBService) UpdateInactivityWarningSentAt(ctx context.Context, instanceID string, id string, sentAt int64) error {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	_id, err := parseUserID(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": _id}
+	update := bson.M{"$set": bson.M{"timestamps.inactivityWarningSentAt": sentAt}, "$inc": bson.M{"version": 1}}
+	_, err = dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (dbService *UserDBService) CountRecentlyCreatedUsers(ctx context.Context, instanceID string, interval int64) (count int64, err error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
 	filter := bson.M{"timestamps.createdAt": bson.M{"$gt": time.Now().Unix() - interval}}
@@ -236,11 +538,64 @@ func (dbService *UserDBService) CountRecentlyCreatedUsers(instanceID string, int
 	return
 }
 
-func (dbService *UserDBService) DeleteUser(instanceID string, id string) error {
-	_id, _ := primitive.ObjectIDFromHex(id)
+// UserStats are the user counts of an instance
+type UserStats struct {
+	Total             int64 `bson:"total"`
+	Confirmed         int64 `bson:"confirmed"`
+	Unconfirmed       int64 `bson:"unconfirmed"`
+	Active            int64 `bson:"active"` // logged in or refreshed a token since activeSince
+	MarkedForDeletion int64 `bson:"markedForDeletion"`
+	Anonymized        int64 `bson:"anonymized"`
+}
+
+// GetUserStats counts the users of the instance by state in a single aggregation
+func (dbService *UserDBService) GetUserStats(ctx context.Context, instanceID string, activeSince int64) (stats UserStats, err error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	countIf := func(cond bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{cond, 1, 0}}}
+	}
+	pipeline := bson.A{
+		bson.M{"$group": bson.M{
+			"_id":       nil,
+			"total":     bson.M{"$sum": 1},
+			"confirmed": countIf(bson.M{"$gt": bson.A{"$account.accountConfirmedAt", 0}}),
+			"active": countIf(bson.M{"$or": bson.A{
+				bson.M{"$gt": bson.A{"$timestamps.lastLogin", activeSince}},
+				bson.M{"$gt": bson.A{"$timestamps.lastTokenRefresh", activeSince}},
+			}}),
+			"markedForDeletion": countIf(bson.M{"$gt": bson.A{"$timestamps.markedForDeletion", 0}}),
+			"anonymized":        countIf(bson.M{"$gt": bson.A{"$timestamps.anonymizedAt", 0}}),
+		}},
+	}
+	cur, err := dbService.collectionRefUsers(instanceID).Aggregate(ctx, pipeline)
+	if err != nil {
+		return stats, err
+	}
+	defer cur.Close(ctx)
+
+	// no result without users
+	if cur.Next(ctx) {
+		if err := cur.Decode(&stats); err != nil {
+			return stats, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return stats, err
+	}
+	stats.Unconfirmed = stats.Total - stats.Confirmed
+	return stats, nil
+}
+
+func (dbService *UserDBService) DeleteUser(ctx context.Context, instanceID string, id string) error {
+	_id, err := parseUserID(id)
+	if err != nil {
+		return err
+	}
 	filter := bson.M{"_id": _id}
 
-	ctx, cancel := dbService.getContext()
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 	res, err := dbService.collectionRefUsers(instanceID).DeleteOne(ctx, filter, nil)
 	if err != nil {
@@ -252,14 +607,48 @@ func (dbService *UserDBService) DeleteUser(instanceID string, id string) error {
 	return nil
 }
 
-func (dbService *UserDBService) DeleteUnverfiedUsers(instanceID string, createdBefore int64) (int64, error) {
+// AnonymizeUser removes the personal data of the user but keeps the document, see models.User.Anonymize
+func (dbService *UserDBService) AnonymizeUser(ctx context.Context, instanceID string, id string) (models.User, error) {
+	user, err := dbService.GetUserByID(ctx, instanceID, id)
+	if err != nil {
+		return user, err
+	}
+	user.Anonymize()
+	return dbService.UpdateUser(ctx, instanceID, user)
+}
+
+func unverifiedUsersFilter(createdBefore int64) bson.M {
 	filter := bson.M{}
 	filter["$and"] = bson.A{
 		bson.M{"account.accountConfirmedAt": 0},
 		bson.M{"timestamps.createdAt": bson.M{"$lt": createdBefore}},
+		bson.M{"timestamps.anonymizedAt": bson.M{"$not": bson.M{"$gt": 0}}},
+	}
+	return filter
+}
+
+// FindUnverifiedUsers returns the users DeleteUnverfiedUsers would remove
+func (dbService *UserDBService) FindUnverifiedUsers(ctx context.Context, instanceID string, createdBefore int64) (users []models.User, err error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	cur, err := dbService.collectionRefUsers(instanceID).Find(ctx, unverifiedUsersFilter(createdBefore))
+	if err != nil {
+		return users, err
+	}
+	defer cur.Close(ctx)
+
+	users = []models.User{}
+	if err = cur.All(ctx, &users); err != nil {
+		return users, err
 	}
+	return users, nil
+}
 
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) DeleteUnverfiedUsers(ctx context.Context, instanceID string, createdBefore int64) (int64, error) {
+	filter := unverifiedUsersFilter(createdBefore)
+
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 	res, err := dbService.collectionRefUsers(instanceID).DeleteMany(ctx, filter, nil)
 	if err != nil {
@@ -269,19 +658,73 @@ func (dbService *UserDBService) DeleteUnverfiedUsers(instanceID string, createdB
 	return res.DeletedCount, nil
 }
 
-func (dbService *UserDBService) FindUsersMarkedForDeletion(instanceID string) (users []models.User, err error) {
-	ctx, cancel := dbService.getContext()
-	defer cancel()
-
+func usersMarkedForDeletionFilter() bson.M {
 	filter := bson.M{}
 	filter["$and"] = bson.A{
 		bson.M{"timestamps.markedForDeletion": bson.M{"$gt": 0}},
 		bson.M{"timestamps.markedForDeletion": bson.M{"$lt": time.Now().Unix()}},
 	}
+	return filter
+}
+
+func (dbService *UserDBService) CountUsersMarkedForDeletion(ctx context.Context, instanceID string) (int64, error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+	return dbService.collectionRefUsers(instanceID).CountDocuments(ctx, usersMarkedForDeletionFilter())
+}
+
+// PerformActionForUsersMarkedForDeletion streams the users whose deletion time is reached to the callback.
+// It stops with the context error when ctx is cancelled, or with the first error returned by the callback.
+func (dbService *UserDBService) PerformActionForUsersMarkedForDeletion(
+	ctx context.Context,
+	instanceID string,
+	cbk func(instanceID string, user models.User, args ...interface{}) error,
+	args ...interface{},
+) (err error) {
+	batchSize := int32(32)
+	options := options.FindOptions{
+		NoCursorTimeout: &dbService.noCursorTimeout,
+		BatchSize:       &batchSize,
+	}
 
 	cur, err := dbService.collectionRefUsers(instanceID).Find(
 		ctx,
-		filter,
+		usersMarkedForDeletionFilter(),
+		&options,
+	)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.Background())
+
+	for cur.Next(ctx) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var result models.User
+		err := cur.Decode(&result)
+		if err != nil {
+			logger.Error.Printf("wrong user model %v, %v", result, err)
+			continue
+		}
+
+		if err := cbk(instanceID, result, args...); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return cur.Err()
+}
+
+func (dbService *UserDBService) FindUsersMarkedForDeletion(ctx context.Context, instanceID string) (users []models.User, err error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	cur, err := dbService.collectionRefUsers(instanceID).Find(
+		ctx,
+		usersMarkedForDeletionFilter(),
 	)
 
 	if err != nil {
@@ -306,8 +749,8 @@ func (dbService *UserDBService) FindUsersMarkedForDeletion(instanceID string) (u
 	return users, nil
 }
 
-func (dbService *UserDBService) FindNonParticipantUsers(instanceID string) (users []models.User, err error) {
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) FindNonParticipantUsers(ctx context.Context, instanceID string) (users []models.User, err error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
 	filter := bson.M{
@@ -344,19 +787,175 @@ func (dbService *UserDBService) FindNonParticipantUsers(instanceID string) (user
 	return users, nil
 }
 
-func (dbService *UserDBService) FindInactiveUsers(instanceID string, dT int64) (users []models.User, err error) {
-	ctx, cancel := dbService.getContext()
+// GetExistingUserIDs returns which of the given user IDs belong to a user of the instance
+func (dbService *UserDBService) GetExistingUserIDs(ctx context.Context, instanceID string, userIDs []string) (map[string]bool, error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
+	ids := bson.A{}
+	for _, id := range userIDs {
+		if _id, err := primitive.ObjectIDFromHex(id); err == nil {
+			ids = append(ids, _id)
+		}
+	}
+	existing := map[string]bool{}
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cur, err := dbService.collectionRefUsers(instanceID).Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var result struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		existing[result.ID.Hex()] = true
+	}
+	return existing, cur.Err()
+}
+
+func usersNeverLoggedInFilter(createdBefore int64) bson.M {
 	filter := bson.M{}
 	filter["$and"] = bson.A{
-		bson.M{
-			"roles": bson.M{"$nin": bson.A{
-				constants.USER_ROLE_SERVICE_ACCOUNT,
-				constants.USER_ROLE_RESEARCHER,
-				constants.USER_ROLE_ADMIN,
-			}},
-		},
+		bson.M{"timestamps.lastLogin": bson.M{"$not": bson.M{"$gt": 0}}},
+		bson.M{"timestamps.createdAt": bson.M{"$lt": createdBefore}},
+		bson.M{"timestamps.anonymizedAt": bson.M{"$not": bson.M{"$gt": 0}}},
+	}
+	return filter
+}
+
+// CountUsersNeverLoggedIn counts the users created before createdBefore who never logged in
+func (dbService *UserDBService) CountUsersNeverLoggedIn(ctx context.Context, instanceID string, createdBefore int64) (int64, error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+	return dbService.collectionRefUsers(instanceID).CountDocuments(ctx, usersNeverLoggedInFilter(createdBefore))
+}
+
+// lastActivityExpr is the time of the last login or token refresh of the user, for $expr queries
+var lastActivityExpr = bson.M{"$max": bson.A{
+	bson.M{"$ifNull": bson.A{"$timestamps.lastLogin", 0}},
+	bson.M{"$ifNull": bson.A{"$timestamps.lastTokenRefresh", 0}},
+}}
+
+var inactivityWarningSentAtExpr = bson.M{"$ifNull": bson.A{"$timestamps.inactivityWarningSentAt", 0}}
+
+func (dbService *UserDBService) findUsers(ctx context.Context, instanceID string, filter bson.M) (users []models.User, err error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	cur, err := dbService.collectionRefUsers(instanceID).Find(ctx, filter)
+	if err != nil {
+		return users, err
+	}
+	defer cur.Close(ctx)
+
+	users = []models.User{}
+	if err = cur.All(ctx, &users); err != nil {
+		return users, err
+	}
+	return users, nil
+}
+
+// FindInactiveUsersToWarn returns the users without login or token refresh since inactiveBefore, not marked for
+// deletion, who were not warned about their inactivity since their last activity
+func (dbService *UserDBService) FindInactiveUsersToWarn(ctx context.Context, instanceID string, inactiveBefore int64) (users []models.User, err error) {
+	filter := bson.M{}
+	filter["$and"] = bson.A{
+		inactiveUsersRolesFilter(),
+		bson.M{"timestamps.lastLogin": bson.M{"$lt": inactiveBefore}},
+		bson.M{"timestamps.lastTokenRefresh": bson.M{"$lt": inactiveBefore}},
+		bson.M{"timestamps.markedForDeletion": bson.M{"$not": bson.M{"$gt": 0}}},
+		bson.M{"$expr": bson.M{"$lte": bson.A{inactivityWarningSentAtExpr, lastActivityExpr}}},
+	}
+	return dbService.findUsers(ctx, instanceID, filter)
+}
+
+// FindWarnedInactiveUsers returns the users warned about their inactivity before warnedBefore, who were not active
+// since then and are not marked for deletion yet
+func (dbService *UserDBService) FindWarnedInactiveUsers(ctx context.Context, instanceID string, warnedBefore int64) (users []models.User, err error) {
+	filter := bson.M{}
+	filter["$and"] = bson.A{
+		inactiveUsersRolesFilter(),
+		bson.M{"timestamps.inactivityWarningSentAt": bson.M{"$gt": 0, "$lt": warnedBefore}},
+		bson.M{"timestamps.markedForDeletion": bson.M{"$not": bson.M{"$gt": 0}}},
+		bson.M{"$expr": bson.M{"$gt": bson.A{inactivityWarningSentAtExpr, lastActivityExpr}}},
+	}
+	return dbService.findUsers(ctx, instanceID, filter)
+}
+
+// FindUsersWithoutAcceptedPolicies returns the users who did not accept the current version, given by policy key, of
+// at least one of the policies. Anonymized users are left out.
+func (dbService *UserDBService) FindUsersWithoutAcceptedPolicies(ctx context.Context, instanceID string, currentVersions map[string]string) (users []models.User, err error) {
+	if len(currentVersions) == 0 {
+		return []models.User{}, nil
+	}
+	notAccepted := bson.A{}
+	for key, version := range currentVersions {
+		notAccepted = append(notAccepted, bson.M{"acceptedPolicies." + key + ".version": bson.M{"$ne": version}})
+	}
+	filter := bson.M{}
+	filter["$and"] = bson.A{
+		bson.M{"$or": notAccepted},
+		bson.M{"timestamps.anonymizedAt": bson.M{"$not": bson.M{"$gt": 0}}},
+	}
+	return dbService.findUsers(ctx, instanceID, filter)
+}
+
+// FindUsersToRemindOfDeletion returns the users whose account will be deleted between now and deletedBefore, and who
+// were not reminded of this deletion time yet
+func (dbService *UserDBService) FindUsersToRemindOfDeletion(ctx context.Context, instanceID string, now int64, deletedBefore int64) (users []models.User, err error) {
+	filter := bson.M{}
+	filter["$and"] = bson.A{
+		bson.M{"timestamps.markedForDeletion": bson.M{"$gt": now, "$lte": deletedBefore}},
+		bson.M{"$expr": bson.M{"$ne": bson.A{
+			bson.M{"$ifNull": bson.A{"$timestamps.deletionReminderSentFor", 0}},
+			"$timestamps.markedForDeletion",
+		}}},
+	}
+	return dbService.findUsers(ctx, instanceID, filter)
+}
+
+// UpdateDeletionReminderSentFor saves the deletion time the user was reminded of
+func (dbService *UserDBService) UpdateDeletionReminderSentFor(ctx context.Context, instanceID string, id string, deletionTime int64) error {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	_id, err := parseUserID(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": _id}
+	update := bson.M{"$set": bson.M{"timestamps.deletionReminderSentFor": deletionTime}, "$inc": bson.M{"version": 1}}
+	_, err = dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	return err
+}
+
+// inactiveUsersRolesFilter excludes the accounts not removed for inactivity
+func inactiveUsersRolesFilter() bson.M {
+	return bson.M{
+		"roles": bson.M{"$nin": bson.A{
+			constants.USER_ROLE_SERVICE_ACCOUNT,
+			constants.USER_ROLE_RESEARCHER,
+			constants.USER_ROLE_ADMIN,
+		}},
+	}
+}
+
+func (dbService *UserDBService) FindInactiveUsers(ctx context.Context, instanceID string, dT int64) (users []models.User, err error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	filter := bson.M{}
+	filter["$and"] = bson.A{
+		inactiveUsersRolesFilter(),
 		bson.M{"timestamps.lastLogin": bson.M{"$lt": time.Now().Unix() - dT}},
 		bson.M{"timestamps.lastTokenRefresh": bson.M{"$lt": time.Now().Unix() - dT}},
 		bson.M{"timestamps.markedForDeletion": bson.M{"$not": bson.M{"$gt": 0}}},
@@ -392,6 +991,37 @@ func (dbService *UserDBService) FindInactiveUsers(instanceID string, dT int64) (
 type UserFilter struct {
 	OnlyConfirmed   bool
 	ReminderWeekDay int32
+	// ReminderTime is the time the reminders are sent (default now), its location is taken as the server timezone.
+	// Users with a timezone are selected if their weekday is the one matching ReminderWeekDay in their timezone at this time.
+	ReminderTime time.Time
+	// NeverLoggedInCreatedBefore selects only the users created before this time who never logged in, if set
+	NeverLoggedInCreatedBefore int64
+}
+
+// localWeekday returns the weekday in loc corresponding to the server weekday serverDay at the time at.
+func localWeekday(serverDay int32, at time.Time, loc *time.Location) int32 {
+	sy, sm, sd := at.Date()
+	ly, lm, ld := at.In(loc).Date()
+	shift := time.Date(ly, lm, ld, 0, 0, 0, 0, time.UTC).Sub(time.Date(sy, sm, sd, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour)
+	return ((serverDay+int32(shift))%7 + 7) % 7
+}
+
+func reminderWeekdayFilter(serverDay int32) bson.M {
+	// time zone offsets differ by at most 26 hours, so the local weekday is within two days of the server one
+	localDays := bson.A{}
+	for shift := int32(-2); shift <= 2; shift++ {
+		localDays = append(localDays, ((serverDay+shift)%7+7)%7)
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{
+			"contactPreferences.timezone":                      bson.M{"$in": bson.A{nil, ""}},
+			"contactPreferences.receiveWeeklyMessageDayOfWeek": serverDay,
+		},
+		bson.M{
+			"contactPreferences.timezone":                      bson.M{"$nin": bson.A{nil, ""}},
+			"contactPreferences.receiveWeeklyMessageDayOfWeek": bson.M{"$in": localDays},
+		},
+	}}
 }
 
 func (dbService *UserDBService) PerfomActionForUsers(
@@ -405,8 +1035,16 @@ func (dbService *UserDBService) PerfomActionForUsers(
 	if filters.OnlyConfirmed {
 		filter["account.accountConfirmedAt"] = bson.M{"$gt": 0}
 	}
+	reminderTime := filters.ReminderTime
+	if reminderTime.IsZero() {
+		reminderTime = time.Now()
+	}
+	locations := map[string]*time.Location{}
 	if filters.ReminderWeekDay > -1 {
-		filter["contactPreferences.receiveWeeklyMessageDayOfWeek"] = filters.ReminderWeekDay
+		filter = bson.M{"$and": bson.A{filter, reminderWeekdayFilter(filters.ReminderWeekDay)}}
+	}
+	if filters.NeverLoggedInCreatedBefore > 0 {
+		filter = bson.M{"$and": bson.A{filter, usersNeverLoggedInFilter(filters.NeverLoggedInCreatedBefore)}}
 	}
 
 	batchSize := int32(32)
@@ -437,6 +1075,21 @@ func (dbService *UserDBService) PerfomActionForUsers(
 			continue
 		}
 
+		if tz := result.ContactPreferences.Timezone; filters.ReminderWeekDay > -1 && tz != "" {
+			loc, ok := locations[tz]
+			if !ok {
+				loc, err = time.LoadLocation(tz)
+				if err != nil {
+					logger.Error.Printf("unknown timezone %s of user %s, using server timezone", tz, result.ID.Hex())
+					loc = reminderTime.Location()
+				}
+				locations[tz] = loc
+			}
+			if localWeekday(filters.ReminderWeekDay, reminderTime, loc) != result.ContactPreferences.ReceiveWeeklyMessageDayOfWeek {
+				continue
+			}
+		}
+
 		if err := cbk(instanceID, result, args...); err != nil {
 			logger.Debug.Printf("error in callback: %v", err)
 			return err
@@ -448,6 +1101,17 @@ func (dbService *UserDBService) PerfomActionForUsers(
 	return nil
 }
 
+func reminderToConfirmAccountFilter(createdBefore int64) bson.M {
+	filter := bson.M{}
+	filter["$and"] = bson.A{
+		bson.M{"account.accountConfirmedAt": bson.M{"$lt": 1}},
+		bson.M{"timestamps.reminderToConfirmSentAt": bson.M{"$lt": 1}},
+		bson.M{"timestamps.createdAt": bson.M{"$lt": createdBefore}},
+		bson.M{"timestamps.anonymizedAt": bson.M{"$not": bson.M{"$gt": 0}}},
+	}
+	return filter
+}
+
 func (dbService *UserDBService) SendReminderToConfirmAccountLoop(
 	ctx context.Context,
 	instanceID string,
@@ -455,12 +1119,7 @@ func (dbService *UserDBService) SendReminderToConfirmAccountLoop(
 	cbk func(instanceID string, user models.User, args ...interface{}) error,
 	args ...interface{},
 ) (err error) {
-	filter := bson.M{}
-	filter["$and"] = bson.A{
-		bson.M{"account.accountConfirmedAt": bson.M{"$lt": 1}},
-		bson.M{"timestamps.reminderToConfirmSentAt": bson.M{"$lt": 1}},
-		bson.M{"timestamps.createdAt": bson.M{"$lt": createdBefore}},
-	}
+	filter := reminderToConfirmAccountFilter(createdBefore)
 
 	batchSize := int32(32)
 	options := options.FindOptions{
@@ -495,7 +1154,7 @@ func (dbService *UserDBService) SendReminderToConfirmAccountLoop(
 			continue
 		}
 
-		if err := dbService.UpdateReminderToConfirmSentAtTime(instanceID, result.ID.Hex()); err != nil {
+		if err := dbService.UpdateReminderToConfirmSentAtTime(ctx, instanceID, result.ID.Hex()); err != nil {
 			logger.Error.Printf("unexpected error: %v", err)
 			continue
 		}
@@ -506,8 +1165,28 @@ func (dbService *UserDBService) SendReminderToConfirmAccountLoop(
 	return nil
 }
 
+// MigrateNewsletterTopic copies subscribedToNewsletter into the newsletter topic of users without it, returns the number of migrated users
+func (dbService *UserDBService) MigrateNewsletterTopic(ctx context.Context, instanceID string) (int64, error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	topicField := "contactPreferences.subscribedTopics." + models.TOPIC_NEWSLETTER
+	filter := bson.M{topicField: bson.M{"$exists": false}}
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			topicField: bson.M{"$eq": bson.A{"$contactPreferences.subscribedToNewsletter", true}},
+			"version":  bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 0}}, 1}},
+		}},
+	}
+	res, err := dbService.collectionRefUsers(instanceID).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
 func (dbService *UserDBService) CreateIndexForUser(instanceID string) error {
-	ctx, cancel := dbService.getContext()
+	ctx, cancel := dbService.getContext(context.Background())
 	defer cancel()
 
 	_, err := dbService.collectionRefUsers(instanceID).Indexes().CreateMany(
@@ -528,16 +1207,31 @@ func (dbService *UserDBService) CreateIndexForUser(instanceID string) error {
 				},
 			},
 			{
+				// unverified accounts clean up
 				Keys: bson.D{
 					{Key: "account.accountConfirmedAt", Value: 1},
 					{Key: "timestamps.createdAt", Value: 1},
 				},
 			},
+			{
+				// reminders to confirm the account
+				Keys: bson.D{
+					{Key: "account.accountConfirmedAt", Value: 1},
+					{Key: "timestamps.reminderToConfirmSentAt", Value: 1},
+					{Key: "timestamps.createdAt", Value: 1},
+				},
+			},
 			{
 				Keys: bson.D{
 					{Key: "contactPreferences.receiveWeeklyMessageDayOfWeek", Value: 1},
 				},
 			},
+			{
+				// login with a confirmed contact email
+				Keys: bson.D{
+					{Key: "contactInfos.email", Value: 1},
+				},
+			},
 		},
 	)
 	return err
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/coneno/logger"
 	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -93,7 +95,7 @@ func TestDbInterfaceMethods(t *testing.T) {
 	}
 
 	t.Run("Testing create user", func(t *testing.T) {
-		id, err := testDBService.AddUser(testInstanceID, testUser)
+		id, err := testDBService.AddUser(context.Background(), testInstanceID, testUser)
 		if err != nil {
 			t.Errorf(err.Error())
 			return
@@ -109,12 +111,12 @@ func TestDbInterfaceMethods(t *testing.T) {
 	t.Run("Testing creating existing user", func(t *testing.T) {
 		testUser2 := testUser
 		testUser2.Roles = []string{"TEST2"}
-		_, err := testDBService.AddUser(testInstanceID, testUser2)
+		_, err := testDBService.AddUser(context.Background(), testInstanceID, testUser2)
 		if err == nil {
 			t.Errorf("user already existed, but created again")
 			return
 		}
-		u, e := testDBService.GetUserByAccountID(testInstanceID, testUser2.Account.AccountID)
+		u, e := testDBService.GetUserByAccountID(context.Background(), testInstanceID, testUser2.Account.AccountID)
 		if e != nil {
 			t.Errorf(e.Error())
 			return
@@ -125,7 +127,7 @@ func TestDbInterfaceMethods(t *testing.T) {
 	})
 
 	t.Run("Testing find existing user by id", func(t *testing.T) {
-		user, err := testDBService.GetUserByID(testInstanceID, testUser.ID.Hex())
+		user, err := testDBService.GetUserByID(context.Background(), testInstanceID, testUser.ID.Hex())
 		if err != nil {
 			t.Errorf(err.Error())
 			return
@@ -137,7 +139,7 @@ func TestDbInterfaceMethods(t *testing.T) {
 	})
 
 	t.Run("Testing find not existing user by id", func(t *testing.T) {
-		_, err := testDBService.GetUserByID(testInstanceID, testUser.ID.Hex()+"1")
+		_, err := testDBService.GetUserByID(context.Background(), testInstanceID, testUser.ID.Hex()+"1")
 		if err == nil {
 			t.Errorf("user should not be found")
 			return
@@ -145,7 +147,7 @@ func TestDbInterfaceMethods(t *testing.T) {
 	})
 
 	t.Run("Testing find existing user by email", func(t *testing.T) {
-		user, err := testDBService.GetUserByAccountID(testInstanceID, testUser.Account.AccountID)
+		user, err := testDBService.GetUserByAccountID(context.Background(), testInstanceID, testUser.Account.AccountID)
 		if err != nil {
 			t.Errorf(err.Error())
 			return
@@ -157,7 +159,7 @@ func TestDbInterfaceMethods(t *testing.T) {
 	})
 
 	t.Run("Testing find not existing user by email", func(t *testing.T) {
-		_, err := testDBService.GetUserByAccountID(testInstanceID, testUser.Account.AccountID+"1")
+		_, err := testDBService.GetUserByAccountID(context.Background(), testInstanceID, testUser.Account.AccountID+"1")
 		if err == nil {
 			t.Errorf("user should not be found")
 			return
@@ -166,7 +168,7 @@ func TestDbInterfaceMethods(t *testing.T) {
 
 	t.Run("Testing updating existing user's attributes", func(t *testing.T) {
 		testUser.Account.AccountConfirmedAt = time.Now().Unix()
-		_, err := testDBService.UpdateUser(testInstanceID, testUser)
+		_, err := testDBService.UpdateUser(context.Background(), testInstanceID, testUser)
 		if err != nil {
 			t.Errorf(err.Error())
 			return
@@ -183,7 +185,7 @@ func TestDbInterfaceMethods(t *testing.T) {
 			return
 		}
 		currentUser.ID = id
-		_, err = testDBService.UpdateUser(testInstanceID, currentUser)
+		_, err = testDBService.UpdateUser(context.Background(), testInstanceID, currentUser)
 		if err == nil {
 			t.Errorf("cannot update not existing user")
 			return
@@ -191,7 +193,7 @@ func TestDbInterfaceMethods(t *testing.T) {
 	})
 
 	t.Run("Testing counting recently added users", func(t *testing.T) {
-		count, err := testDBService.CountRecentlyCreatedUsers(testInstanceID, 20)
+		count, err := testDBService.CountRecentlyCreatedUsers(context.Background(), testInstanceID, 20)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -204,7 +206,7 @@ func TestDbInterfaceMethods(t *testing.T) {
 	})
 
 	t.Run("Testing deleting existing user", func(t *testing.T) {
-		err := testDBService.DeleteUser(testInstanceID, testUser.ID.Hex())
+		err := testDBService.DeleteUser(context.Background(), testInstanceID, testUser.ID.Hex())
 		if err != nil {
 			t.Errorf(err.Error())
 			return
@@ -212,7 +214,7 @@ func TestDbInterfaceMethods(t *testing.T) {
 	})
 
 	t.Run("Testing deleting not existing user", func(t *testing.T) {
-		err := testDBService.DeleteUser(testInstanceID, testUser.ID.Hex()+"1")
+		err := testDBService.DeleteUser(context.Background(), testInstanceID, testUser.ID.Hex()+"1")
 		if err == nil {
 			t.Errorf("user should not be found - error expected")
 			return
@@ -227,7 +229,7 @@ func TestDbPerformActionForUsers(t *testing.T) {
 		{Account: models.Account{AccountID: "3"}},
 	}
 	for _, u := range testUsers {
-		_, err := testDBService.AddUser(testInstanceID, u)
+		_, err := testDBService.AddUser(context.Background(), testInstanceID, u)
 		if err != nil {
 			logger.Error.Fatal(err)
 		}
@@ -270,8 +272,273 @@ func TestDbPerformActionForUsers(t *testing.T) {
 	}
 }
 
+func TestDbPerformActionForUsersReminderTimezone(t *testing.T) {
+	instanceID := testInstanceID + "_tz"
+	defer func() {
+		if err := testDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(context.Background()); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	// wednesday 23:30 on the server, already thursday in Berlin and still wednesday in New York
+	serverTime := time.Date(2026, time.October, 14, 23, 30, 0, 0, time.UTC)
+	testUsers := []models.User{
+		{Account: models.Account{AccountID: "server_wed"}, ContactPreferences: models.ContactPreferences{ReceiveWeeklyMessageDayOfWeek: 3}},
+		{Account: models.Account{AccountID: "server_thu"}, ContactPreferences: models.ContactPreferences{ReceiveWeeklyMessageDayOfWeek: 4}},
+		{Account: models.Account{AccountID: "berlin_wed"}, ContactPreferences: models.ContactPreferences{ReceiveWeeklyMessageDayOfWeek: 3, Timezone: "Europe/Berlin"}},
+		{Account: models.Account{AccountID: "berlin_thu"}, ContactPreferences: models.ContactPreferences{ReceiveWeeklyMessageDayOfWeek: 4, Timezone: "Europe/Berlin"}},
+		{Account: models.Account{AccountID: "new_york_wed"}, ContactPreferences: models.ContactPreferences{ReceiveWeeklyMessageDayOfWeek: 3, Timezone: "America/New_York"}},
+		{Account: models.Account{AccountID: "new_york_thu"}, ContactPreferences: models.ContactPreferences{ReceiveWeeklyMessageDayOfWeek: 4, Timezone: "America/New_York"}},
+		{Account: models.Account{AccountID: "unknown_tz_wed"}, ContactPreferences: models.ContactPreferences{ReceiveWeeklyMessageDayOfWeek: 3, Timezone: "Europe/Nowhere"}},
+	}
+	for _, u := range testUsers {
+		_, err := testDBService.AddUser(context.Background(), instanceID, u)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	}
+
+	selected := map[string]bool{}
+	err := testDBService.PerfomActionForUsers(
+		context.Background(),
+		instanceID,
+		UserFilter{
+			ReminderWeekDay: 3,
+			ReminderTime:    serverTime,
+		},
+		func(instanceID string, user models.User, args ...interface{}) error {
+			selected[user.Account.AccountID] = true
+			return nil
+		},
+	)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	expected := []string{"server_wed", "berlin_thu", "new_york_wed", "unknown_tz_wed"}
+	if len(selected) != len(expected) {
+		t.Errorf("unexpected users selected: %v", selected)
+	}
+	for _, accountID := range expected {
+		if !selected[accountID] {
+			t.Errorf("%s should be selected: %v", accountID, selected)
+		}
+	}
+}
+
+func TestDbMigrateNewsletterTopic(t *testing.T) {
+	instanceID := testInstanceID + "_topics"
+	defer func() {
+		if err := testDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(context.Background()); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	testUsers := []models.User{
+		{Account: models.Account{AccountID: "subscribed"}, ContactPreferences: models.ContactPreferences{SubscribedToNewsletter: true}},
+		{Account: models.Account{AccountID: "not_subscribed"}},
+		{Account: models.Account{AccountID: "migrated"}, ContactPreferences: models.ContactPreferences{
+			SubscribedToNewsletter: true,
+			SubscribedTopics:       map[string]bool{models.TOPIC_NEWSLETTER: true, models.TOPIC_SURVEYS: true},
+		}},
+	}
+	for _, u := range testUsers {
+		if _, err := testDBService.AddUser(context.Background(), instanceID, u); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	}
+
+	count, err := testDBService.MigrateNewsletterTopic(context.Background(), instanceID)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if count != 2 {
+		t.Errorf("unexpected number of migrated users: %d", count)
+	}
+
+	for _, u := range testUsers {
+		user, err := testDBService.GetUserByAccountID(context.Background(), instanceID, u.Account.AccountID)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		subscribed, ok := user.ContactPreferences.SubscribedTopics[models.TOPIC_NEWSLETTER]
+		if !ok || subscribed != u.ContactPreferences.SubscribedToNewsletter {
+			t.Errorf("unexpected topics for %s: %v", u.Account.AccountID, user.ContactPreferences.SubscribedTopics)
+		}
+	}
+
+	count, err = testDBService.MigrateNewsletterTopic(context.Background(), instanceID)
+	if err != nil || count != 0 {
+		t.Errorf("second migration should not change users: %d, %v", count, err)
+	}
+}
+
+func TestDbGetUserByIDMigratesOldDocuments(t *testing.T) {
+	// shape of a user document stored before the schema version was introduced
+	res, err := testDBService.collectionRefUsers(testInstanceID).InsertOne(context.Background(), bson.M{
+		"account": bson.M{
+			"type":                models.ACCOUNT_TYPE_EMAIL,
+			"accountID":           "test-old-shape@test.com",
+			"failedLoginAttempts": nil,
+		},
+		"roles":              bson.A{"PARTICIPANT"},
+		"contactPreferences": bson.M{"subscribedToNewsletter": true},
+		"version":            2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	id := res.InsertedID.(primitive.ObjectID)
+
+	user, err := testDBService.GetUserByID(context.Background(), testInstanceID, id.Hex())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.SchemaVersion != models.CurrentUserSchemaVersion {
+		t.Errorf("unexpected schema version: %d", user.SchemaVersion)
+	}
+	if !user.ContactPreferences.IsSubscribedToTopic(models.TOPIC_NEWSLETTER) || user.ContactPreferences.SubscribedTopics == nil {
+		t.Errorf("unexpected topics: %v", user.ContactPreferences.SubscribedTopics)
+	}
+	if user.Account.FailedLoginAttempts == nil || user.Account.PasswordResetTriggers == nil {
+		t.Errorf("histories should be initialised: %+v", user.Account)
+	}
+
+	t.Run("migration is persisted", func(t *testing.T) {
+		topicField := "contactPreferences.subscribedTopics." + models.TOPIC_NEWSLETTER
+		count, err := testDBService.collectionRefUsers(testInstanceID).CountDocuments(context.Background(), bson.M{
+			"_id":                         id,
+			"schemaVersion":               models.CurrentUserSchemaVersion,
+			"version":                     2,
+			topicField:                    true,
+			"account.failedLoginAttempts": bson.M{"$type": "array"},
+		})
+		if err != nil || count != 1 {
+			t.Errorf("migrated fields not stored: %d, %v", count, err)
+		}
+	})
+
+	t.Run("migrated user can be updated", func(t *testing.T) {
+		user.Account.PreferredLanguage = "de"
+		if _, err := testDBService.UpdateUser(context.Background(), testInstanceID, user); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestDbGetUserStats(t *testing.T) {
+	instanceID := testInstanceID + "_stats"
+	defer func() {
+		if err := testDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(context.Background()); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	now := time.Now().Unix()
+	activeSince := now - 30*24*3600
+
+	t.Run("without users", func(t *testing.T) {
+		stats, err := testDBService.GetUserStats(context.Background(), instanceID, activeSince)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if stats != (UserStats{}) {
+			t.Errorf("unexpected stats: %v", stats)
+		}
+	})
+
+	testUsers := []models.User{
+		// confirmed, logged in recently
+		{Account: models.Account{AccountID: "stats_1", AccountConfirmedAt: now - 1000}, Timestamps: models.Timestamps{LastLogin: now - 100}},
+		// confirmed, refreshed a token recently
+		{Account: models.Account{AccountID: "stats_2", AccountConfirmedAt: now - 1000}, Timestamps: models.Timestamps{LastLogin: now - 40*24*3600, LastTokenRefresh: now - 100}},
+		// confirmed, inactive and marked for deletion
+		{Account: models.Account{AccountID: "stats_3", AccountConfirmedAt: now - 1000}, Timestamps: models.Timestamps{LastLogin: now - 40*24*3600, MarkedForDeletion: now + 1000}},
+		// unconfirmed
+		{Account: models.Account{AccountID: "stats_4"}},
+		// anonymized
+		{Account: models.Account{AccountID: "stats_5"}, Timestamps: models.Timestamps{AnonymizedAt: now - 100}},
+	}
+	for _, u := range testUsers {
+		if _, err := testDBService.AddUser(context.Background(), instanceID, u); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	}
+
+	t.Run("with users in various states", func(t *testing.T) {
+		stats, err := testDBService.GetUserStats(context.Background(), instanceID, activeSince)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		expected := UserStats{Total: 5, Confirmed: 3, Unconfirmed: 2, Active: 2, MarkedForDeletion: 1, Anonymized: 1}
+		if stats != expected {
+			t.Errorf("unexpected stats: %+v, expected %+v", stats, expected)
+		}
+	})
+}
+
+func TestDbFindUsersNeverLoggedIn(t *testing.T) {
+	instanceID := testInstanceID + "_never_logged_in"
+	defer func() {
+		if err := testDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(context.Background()); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	now := time.Now().Unix()
+	testUsers := []models.User{
+		{Account: models.Account{AccountID: "never_1"}, Timestamps: models.Timestamps{CreatedAt: now - 1000}},
+		{Account: models.Account{AccountID: "never_2"}, Timestamps: models.Timestamps{CreatedAt: now - 2000}},
+		{Account: models.Account{AccountID: "logged_in"}, Timestamps: models.Timestamps{CreatedAt: now - 1000, LastLogin: now - 500}},
+		{Account: models.Account{AccountID: "never_but_new"}, Timestamps: models.Timestamps{CreatedAt: now}},
+		{Account: models.Account{AccountID: "never_anonymized"}, Timestamps: models.Timestamps{CreatedAt: now - 1000, AnonymizedAt: now - 10}},
+	}
+	for _, u := range testUsers {
+		if _, err := testDBService.AddUser(context.Background(), instanceID, u); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	}
+	createdBefore := now - 100
+
+	t.Run("count", func(t *testing.T) {
+		count, err := testDBService.CountUsersNeverLoggedIn(context.Background(), instanceID, createdBefore)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if count != 2 {
+			t.Errorf("unexpected count: %d", count)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		found := []string{}
+		filter := UserFilter{ReminderWeekDay: -1, NeverLoggedInCreatedBefore: createdBefore}
+		err := testDBService.PerfomActionForUsers(context.Background(), instanceID, filter, func(instanceID string, user models.User, args ...interface{}) error {
+			found = append(found, user.Account.AccountID)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(found) != 2 || (found[0] != "never_1" && found[1] != "never_1") || (found[0] != "never_2" && found[1] != "never_2") {
+			t.Errorf("unexpected users: %v", found)
+		}
+	})
+}
+
 func AssertNumberOfNonParticipantUsers(instanceID string, count int) error {
-	users, err := testDBService.FindNonParticipantUsers(instanceID)
+	users, err := testDBService.FindNonParticipantUsers(context.Background(), instanceID)
 	if err != nil {
 		return err
 	}
@@ -288,14 +555,14 @@ func TestDeleteUnverfiedUsers(t *testing.T) {
 		{Account: models.Account{AccountID: "delete_3"}, Roles: []string{"RESEARCHER"}, Timestamps: models.Timestamps{CreatedAt: time.Now().Unix()}},
 	}
 	for _, u := range testUsers {
-		_, err := testDBService.AddUser(testInstanceID, u)
+		_, err := testDBService.AddUser(context.Background(), testInstanceID, u)
 		if err != nil {
 			logger.Error.Fatal(err)
 		}
 	}
 
 	t.Run("remove any other user not in the test set", func(t *testing.T) {
-		count, err := testDBService.DeleteUnverfiedUsers(testInstanceID, time.Now().Unix()-105)
+		count, err := testDBService.DeleteUnverfiedUsers(context.Background(), testInstanceID, time.Now().Unix()-105)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -308,8 +575,24 @@ func TestDeleteUnverfiedUsers(t *testing.T) {
 		}
 	})
 
+	t.Run("find users to remove without deleting them", func(t *testing.T) {
+		users, err := testDBService.FindUnverifiedUsers(context.Background(), testInstanceID, time.Now().Unix()-55)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(users) != 1 || users[0].Account.AccountID != "delete_1" {
+			t.Errorf("unexpected users: %v", users)
+		}
+		err = AssertNumberOfNonParticipantUsers(testInstanceID, 3)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	})
+
 	t.Run("remove 1 user", func(t *testing.T) {
-		count, err := testDBService.DeleteUnverfiedUsers(testInstanceID, time.Now().Unix()-55)
+		count, err := testDBService.DeleteUnverfiedUsers(context.Background(), testInstanceID, time.Now().Unix()-55)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -323,7 +606,7 @@ func TestDeleteUnverfiedUsers(t *testing.T) {
 	})
 
 	t.Run("remove an other user", func(t *testing.T) {
-		count, err := testDBService.DeleteUnverfiedUsers(testInstanceID, time.Now().Unix()-15)
+		count, err := testDBService.DeleteUnverfiedUsers(context.Background(), testInstanceID, time.Now().Unix()-15)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -359,14 +642,14 @@ func TestFindInactiveUsers(t *testing.T) {
 				MarkedForDeletion: 100}},
 	}
 	for _, u := range testUsers {
-		_, err := testDBService.AddUser(testInstanceID, u)
+		_, err := testDBService.AddUser(context.Background(), testInstanceID, u)
 		if err != nil {
 			logger.Error.Fatal(err)
 		}
 	}
 
 	t.Run("remove any other user not in the test set", func(t *testing.T) {
-		count, err := testDBService.DeleteUnverfiedUsers(testInstanceID, time.Now().Unix()-105)
+		count, err := testDBService.DeleteUnverfiedUsers(context.Background(), testInstanceID, time.Now().Unix()-105)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -375,7 +658,7 @@ func TestFindInactiveUsers(t *testing.T) {
 	})
 
 	t.Run("Testing finding inactive users", func(t *testing.T) {
-		inactiveUsers, err := testDBService.FindInactiveUsers(testInstanceID, notifyAfter)
+		inactiveUsers, err := testDBService.FindInactiveUsers(context.Background(), testInstanceID, notifyAfter)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -396,12 +679,12 @@ func TestFindInactiveUsers(t *testing.T) {
 
 	t.Run("Testing updating markedForDeletionTime", func(t *testing.T) {
 
-		user1, err := testDBService.GetUserByAccountID(testInstanceID, "inactive_2")
+		user1, err := testDBService.GetUserByAccountID(context.Background(), testInstanceID, "inactive_2")
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
 		}
-		success, err := testDBService.UpdateMarkedForDeletionTime(testInstanceID, string(user1.ID.Hex()), int64(deleteAfter), false)
+		success, err := testDBService.UpdateMarkedForDeletionTime(context.Background(), testInstanceID, string(user1.ID.Hex()), int64(deleteAfter), false)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -413,12 +696,12 @@ func TestFindInactiveUsers(t *testing.T) {
 	})
 
 	t.Run("Testing updating and resetting markedForDeletionTime when it is already set", func(t *testing.T) {
-		user2, err := testDBService.GetUserByAccountID(testInstanceID, "inactive_7")
+		user2, err := testDBService.GetUserByAccountID(context.Background(), testInstanceID, "inactive_7")
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
 		}
-		success, err := testDBService.UpdateMarkedForDeletionTime(testInstanceID, string(user2.ID.Hex()), int64(deleteAfter), false)
+		success, err := testDBService.UpdateMarkedForDeletionTime(context.Background(), testInstanceID, string(user2.ID.Hex()), int64(deleteAfter), false)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -427,7 +710,7 @@ func TestFindInactiveUsers(t *testing.T) {
 			t.Errorf("update markedforDeletion timestamp that is already set for user: %s", "inactive_7")
 			return
 		}
-		success, err = testDBService.UpdateMarkedForDeletionTime(testInstanceID, user2.ID.Hex(), int64(deleteAfter), true)
+		success, err = testDBService.UpdateMarkedForDeletionTime(context.Background(), testInstanceID, user2.ID.Hex(), int64(deleteAfter), true)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -436,7 +719,7 @@ func TestFindInactiveUsers(t *testing.T) {
 			t.Errorf("resetting markedforDeletion timestamp for user failed: %s", "inactive_7")
 			return
 		}
-		updatedUser, err := testDBService.GetUserByID(testInstanceID, user2.ID.Hex())
+		updatedUser, err := testDBService.GetUserByID(context.Background(), testInstanceID, user2.ID.Hex())
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -448,7 +731,7 @@ func TestFindInactiveUsers(t *testing.T) {
 	})
 
 	t.Run("Testing finding users marked for deletion", func(t *testing.T) {
-		users, err := testDBService.FindUsersMarkedForDeletion(testInstanceID)
+		users, err := testDBService.FindUsersMarkedForDeletion(context.Background(), testInstanceID)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -465,7 +748,7 @@ func TestFindInactiveUsers(t *testing.T) {
 				CreatedAt:         time.Now().Unix() - 100,
 				MarkedForDeletion: time.Now().Unix() - 10}}
 
-		id, err := testDBService.AddUser(testInstanceID, testUser)
+		id, err := testDBService.AddUser(context.Background(), testInstanceID, testUser)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -477,12 +760,12 @@ func TestFindInactiveUsers(t *testing.T) {
 		_id, _ := primitive.ObjectIDFromHex(id)
 		testUser.ID = _id
 
-		users, err = testDBService.FindUsersMarkedForDeletion(testInstanceID)
+		users, err = testDBService.FindUsersMarkedForDeletion(context.Background(), testInstanceID)
 		if len(users) != 1 {
 			t.Errorf("wrong number of inactive users found: %d instead of %d", len(users), 1)
 			return
 		}
-		success, err := testDBService.UpdateMarkedForDeletionTime(testInstanceID, id, 0, true)
+		success, err := testDBService.UpdateMarkedForDeletionTime(context.Background(), testInstanceID, id, 0, true)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -491,7 +774,7 @@ func TestFindInactiveUsers(t *testing.T) {
 			t.Errorf("could not reset MarkedForDeletionTime: %v", err)
 			return
 		}
-		users, err = testDBService.FindUsersMarkedForDeletion(testInstanceID)
+		users, err = testDBService.FindUsersMarkedForDeletion(context.Background(), testInstanceID)
 		if len(users) != 0 {
 			t.Errorf("wrong number of inactive users found: %d instead of %d", len(users), 0)
 			return
@@ -499,7 +782,7 @@ func TestFindInactiveUsers(t *testing.T) {
 	})
 
 	t.Run("Testing update Login Time", func(t *testing.T) {
-		users, err := testDBService.FindInactiveUsers(testInstanceID, notifyAfter)
+		users, err := testDBService.FindInactiveUsers(context.Background(), testInstanceID, notifyAfter)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -509,8 +792,8 @@ func TestFindInactiveUsers(t *testing.T) {
 			return
 		}
 		id := users[0].ID.Hex()
-		testDBService.UpdateLoginTime(testInstanceID, id)
-		users, err = testDBService.FindInactiveUsers(testInstanceID, notifyAfter)
+		testDBService.UpdateLoginTime(context.Background(), testInstanceID, id, models.LoginRecord{IP: "192.168.1.0", UserAgent: "test"}, 10)
+		users, err = testDBService.FindInactiveUsers(context.Background(), testInstanceID, notifyAfter)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -521,3 +804,545 @@ func TestFindInactiveUsers(t *testing.T) {
 		}
 	})
 }
+
+func TestDbGetUserByConfirmedEmail(t *testing.T) {
+	instanceID := testInstanceID + "_confirmed_email"
+	addUser := func(accountID string, contacts ...models.ContactInfo) string {
+		id, err := testDBService.AddUser(context.Background(), instanceID, models.User{
+			Account:      models.Account{Type: "email", AccountID: accountID},
+			ContactInfos: contacts,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return id
+	}
+	email := func(address string, confirmedAt int64) models.ContactInfo {
+		return models.ContactInfo{ID: primitive.NewObjectID(), Type: "email", Email: address, ConfirmedAt: confirmedAt}
+	}
+
+	primaryID := addUser("primary@test.com",
+		email("primary@test.com", 1),
+		email("secondary@test.com", time.Now().Unix()),
+		email("unconfirmed@test.com", 0),
+	)
+	addUser("shared-1@test.com", email("shared@test.com", 1))
+	addUser("shared-2@test.com", email("shared@test.com", 1))
+
+	for _, tc := range []struct {
+		email  string
+		userID string
+	}{
+		{email: "primary@test.com", userID: primaryID},
+		{email: "secondary@test.com", userID: primaryID},
+		{email: "unconfirmed@test.com"},
+		{email: "shared@test.com"},
+		{email: "unknown@test.com"},
+	} {
+		user, err := testDBService.GetUserByConfirmedEmail(context.Background(), instanceID, tc.email)
+		if tc.userID == "" {
+			if err == nil {
+				t.Errorf("%s: no user expected, got %s", tc.email, user.ID.Hex())
+			}
+			continue
+		}
+		if err != nil || user.ID.Hex() != tc.userID {
+			t.Errorf("%s: unexpected user %s: %v", tc.email, user.ID.Hex(), err)
+		}
+	}
+}
+
+func TestDbMalformedUserID(t *testing.T) {
+	for _, id := range []string{"", "not-an-object-id", primitive.NewObjectID().Hex() + "w"} {
+		if _, err := testDBService.GetUserByID(context.Background(), testInstanceID, id); !errors.Is(err, ErrInvalidUserID) {
+			t.Errorf("GetUserByID(%q): expected invalid user id error, got: %v", id, err)
+		}
+		if err := testDBService.UpdateUserPassword(context.Background(), testInstanceID, id, "pw"); !errors.Is(err, ErrInvalidUserID) {
+			t.Errorf("UpdateUserPassword(%q): expected invalid user id error, got: %v", id, err)
+		}
+		if _, err := testDBService.AddRoleToUser(context.Background(), testInstanceID, id, "ADMIN"); !errors.Is(err, ErrInvalidUserID) {
+			t.Errorf("AddRoleToUser(%q): expected invalid user id error, got: %v", id, err)
+		}
+		if err := testDBService.DeleteUser(context.Background(), testInstanceID, id); !errors.Is(err, ErrInvalidUserID) {
+			t.Errorf("DeleteUser(%q): expected invalid user id error, got: %v", id, err)
+		}
+	}
+}
+
+func TestDbCancelledContext(t *testing.T) {
+	id, err := testDBService.AddUser(context.Background(), testInstanceID, models.User{
+		Account: models.Account{
+			Type:      "email",
+			AccountID: "test_cancelled_ctx@test.com",
+		},
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	t.Run("cancelled before the query", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := testDBService.GetUserByID(ctx, testInstanceID, id)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context canceled error, got: %v", err)
+		}
+		if errors.Is(err, ErrUserNotFound) {
+			t.Error("a failed query should not be reported as not found")
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		_, err := testDBService.GetUserByID(context.Background(), testInstanceID, primitive.NewObjectID().Hex())
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected user not found error, got: %v", err)
+		}
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+		defer cancel()
+		time.Sleep(time.Millisecond)
+		_, err := testDBService.UpdateUser(ctx, testInstanceID, models.User{})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded error, got: %v", err)
+		}
+	})
+
+	t.Run("with active context", func(t *testing.T) {
+		user, err := testDBService.GetUserByID(context.Background(), testInstanceID, id)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if user.Account.AccountID != "test_cancelled_ctx@test.com" {
+			t.Errorf("unexpected user: %v", user)
+		}
+	})
+}
+
+func TestDbUpdateUserVersionConflict(t *testing.T) {
+	id, err := testDBService.AddUser(context.Background(), testInstanceID, models.User{
+		Account: models.Account{
+			Type:      "email",
+			AccountID: "test_version_conflict@test.com",
+		},
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	firstRead, err := testDBService.GetUserByID(context.Background(), testInstanceID, id)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	secondRead := firstRead
+
+	t.Run("first write wins", func(t *testing.T) {
+		firstRead.Account.PreferredLanguage = "de"
+		updated, err := testDBService.UpdateUser(context.Background(), testInstanceID, firstRead)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if updated.Version != secondRead.Version+1 {
+			t.Errorf("version should be incremented: %d", updated.Version)
+		}
+	})
+
+	t.Run("stale write loses the race", func(t *testing.T) {
+		secondRead.Account.PreferredLanguage = "fr"
+		_, err := testDBService.UpdateUser(context.Background(), testInstanceID, secondRead)
+		if err != ErrUserVersionConflict {
+			t.Errorf("expected version conflict, got: %v", err)
+			return
+		}
+		user, err := testDBService.GetUserByID(context.Background(), testInstanceID, id)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if user.Account.PreferredLanguage != "de" {
+			t.Errorf("first write should be kept: %s", user.Account.PreferredLanguage)
+		}
+	})
+
+	t.Run("retry after reading again", func(t *testing.T) {
+		user, err := testDBService.GetUserByID(context.Background(), testInstanceID, id)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		user.Account.PreferredLanguage = "fr"
+		if _, err := testDBService.UpdateUser(context.Background(), testInstanceID, user); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("atomic update in between", func(t *testing.T) {
+		user, err := testDBService.GetUserByID(context.Background(), testInstanceID, id)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if err := testDBService.SaveFailedLoginAttempt(context.Background(), testInstanceID, id); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		user.Account.PreferredLanguage = "it"
+		if _, err := testDBService.UpdateUser(context.Background(), testInstanceID, user); err != ErrUserVersionConflict {
+			t.Errorf("expected version conflict, got: %v", err)
+			return
+		}
+		user, err = testDBService.GetUserByID(context.Background(), testInstanceID, id)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(user.Account.FailedLoginAttempts) != 1 {
+			t.Errorf("failed login attempt should be kept: %v", user.Account.FailedLoginAttempts)
+		}
+	})
+}
+
+func TestDbRemoveRoleUnlessLastHolder(t *testing.T) {
+	// separate instance, so that users with the role from other tests are not counted
+	instanceID := testInstanceID + "_role_holders"
+	defer func() {
+		if err := testDBService.DropInstanceDB(context.Background(), instanceID); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	addHolders := func(n int) ([]string, error) {
+		ids := []string{}
+		for i := 0; i < n; i++ {
+			id, err := testDBService.AddUser(context.Background(), instanceID, models.User{
+				Account: models.Account{
+					Type:      "email",
+					AccountID: fmt.Sprintf("test_role_holder_%d_%d@test.com", n, i),
+				},
+				Roles: []string{"PARTICIPANT", "ADMIN"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	}
+
+	t.Run("last holder keeps the role", func(t *testing.T) {
+		ids, err := addHolders(1)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if _, err := testDBService.RemoveRoleUnlessLastHolder(context.Background(), instanceID, ids[0], "ADMIN"); err != ErrLastRoleHolder {
+			t.Errorf("expected last holder error, got: %v", err)
+		}
+		user, err := testDBService.GetUserByID(context.Background(), instanceID, ids[0])
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if !user.HasRole("ADMIN") {
+			t.Error("role should be kept")
+		}
+		if err := testDBService.DeleteUser(context.Background(), instanceID, ids[0]); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("concurrent removals keep one holder", func(t *testing.T) {
+		ids, err := addHolders(2)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		errs := make(chan error, len(ids))
+		for _, id := range ids {
+			go func(id string) {
+				_, err := testDBService.RemoveRoleUnlessLastHolder(context.Background(), instanceID, id, "ADMIN")
+				errs <- err
+			}(id)
+		}
+		removed := 0
+		for range ids {
+			err := <-errs
+			if err == nil {
+				removed++
+			} else if err != ErrLastRoleHolder {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		if removed > 1 {
+			t.Errorf("only one removal should succeed, got %d", removed)
+		}
+		count, err := testDBService.CountUsersWithRole(context.Background(), instanceID, "ADMIN")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if count < 1 {
+			t.Error("the instance should keep an admin")
+		}
+	})
+}
+
+func TestDbCountVerificationCodeAttempt(t *testing.T) {
+	addr := "test_verification_code_attempts@test.com"
+	id, err := testDBService.AddUser(context.Background(), testInstanceID, models.User{
+		Account: models.Account{
+			Type:      "email",
+			AccountID: addr,
+		},
+		ContactInfos: []models.ContactInfo{
+			{
+				Type:  "email",
+				Email: addr,
+				VerificationCode: &models.VerificationCode{
+					Code:      "123456",
+					ExpiresAt: time.Now().Unix() + 60,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	t.Run("with other code", func(t *testing.T) {
+		_, err := testDBService.CountVerificationCodeAttempt(context.Background(), testInstanceID, id, addr, "654321", 2)
+		if err != ErrNoAttemptsLeft {
+			t.Errorf("expected no attempts left, got: %v", err)
+		}
+	})
+
+	t.Run("until limit", func(t *testing.T) {
+		stale, err := testDBService.GetUserByID(context.Background(), testInstanceID, id)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		for i := 1; i <= 2; i++ {
+			user, err := testDBService.CountVerificationCodeAttempt(context.Background(), testInstanceID, id, addr, "123456", 2)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if user.ContactInfos[0].VerificationCode.Attempts != int64(i) {
+				t.Errorf("unexpected attempts: %d", user.ContactInfos[0].VerificationCode.Attempts)
+			}
+		}
+		_, err = testDBService.CountVerificationCodeAttempt(context.Background(), testInstanceID, id, addr, "123456", 2)
+		if err != ErrNoAttemptsLeft {
+			t.Errorf("expected no attempts left, got: %v", err)
+		}
+
+		// a write of a read from before can't reset the counter
+		if _, err := testDBService.UpdateUser(context.Background(), testInstanceID, stale); err != ErrUserVersionConflict {
+			t.Errorf("expected version conflict, got: %v", err)
+		}
+	})
+}
+
+func TestDbAnonymizeUser(t *testing.T) {
+	profileID := primitive.NewObjectID()
+	id, err := testDBService.AddUser(context.Background(), testInstanceID, models.User{
+		Account: models.Account{
+			Type:              "email",
+			AccountID:         "test_anonymize@test.com",
+			Password:          "hashed",
+			PreferredLanguage: "en",
+		},
+		Profiles: []models.Profile{
+			{ID: profileID, Alias: "test_anonymize@test.com", MainProfile: true},
+		},
+		ContactInfos: []models.ContactInfo{
+			{ID: primitive.NewObjectID(), Type: "email", Email: "test_anonymize@test.com", ConfirmedAt: 1},
+		},
+		ContactPreferences: models.ContactPreferences{SubscribedToNewsletter: true},
+		Timestamps:         models.Timestamps{MarkedForDeletion: time.Now().Unix() - 10},
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	_, err = testDBService.AnonymizeUser(context.Background(), testInstanceID, id)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	user, err := testDBService.GetUserByID(context.Background(), testInstanceID, id)
+	if err != nil {
+		t.Errorf("anonymized user should still resolve: %v", err)
+		return
+	}
+	if user.Account.AccountID == "test_anonymize@test.com" || user.Account.Password != "" {
+		t.Errorf("account not anonymized: %v", user.Account)
+	}
+	if len(user.ContactInfos) != 0 || user.ContactPreferences.SubscribedToNewsletter {
+		t.Errorf("contact data not removed: %v, %v", user.ContactInfos, user.ContactPreferences)
+	}
+	if len(user.Profiles) != 1 || user.Profiles[0].ID != profileID || user.Profiles[0].Alias != "" {
+		t.Errorf("unexpected profiles: %v", user.Profiles)
+	}
+	if !user.IsAnonymized() || user.Timestamps.MarkedForDeletion != 0 {
+		t.Errorf("unexpected timestamps: %v", user.Timestamps)
+	}
+	if _, err := testDBService.GetUserByAccountID(context.Background(), testInstanceID, "test_anonymize@test.com"); err == nil {
+		t.Error("user should not be found by the old account ID")
+	}
+}
+
+func TestDbPerformActionForUsersMarkedForDeletion(t *testing.T) {
+	instanceID := testInstanceID + "_marked_for_deletion"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		_, err := testDBService.AddUser(context.Background(), instanceID, models.User{
+			Account: models.Account{AccountID: fmt.Sprintf("marked_%d", i)},
+			Roles:   []string{"PARTICIPANT"},
+			Timestamps: models.Timestamps{
+				MarkedForDeletion: time.Now().Unix() - 10,
+			},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	}
+
+	t.Run("count users", func(t *testing.T) {
+		count, err := testDBService.CountUsersMarkedForDeletion(context.Background(), instanceID)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if count != 5 {
+			t.Errorf("unexpected count: %d", count)
+		}
+	})
+
+	t.Run("cancellation stops mid-batch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		processed := 0
+		err := testDBService.PerformActionForUsersMarkedForDeletion(ctx, instanceID, func(instanceID string, user models.User, args ...interface{}) error {
+			processed++
+			if processed == 2 {
+				cancel()
+			}
+			return nil
+		})
+		if err != context.Canceled {
+			t.Errorf("expected cancellation error, got: %v", err)
+		}
+		if processed != 2 {
+			t.Errorf("unexpected number of processed users: %d", processed)
+		}
+	})
+
+	t.Run("all users without cancellation", func(t *testing.T) {
+		processed := 0
+		err := testDBService.PerformActionForUsersMarkedForDeletion(context.Background(), instanceID, func(instanceID string, user models.User, args ...interface{}) error {
+			processed++
+			return nil
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if processed != 5 {
+			t.Errorf("unexpected number of processed users: %d", processed)
+		}
+	})
+}
+
+func TestDbFindUsersMarkedForDeletion(t *testing.T) {
+	instanceID := testInstanceID + "_find_marked"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	testUsers := []models.User{
+		{Account: models.Account{AccountID: "marked_in_past"}, Timestamps: models.Timestamps{MarkedForDeletion: time.Now().Unix() - 10}},
+		{Account: models.Account{AccountID: "marked_in_future"}, Timestamps: models.Timestamps{MarkedForDeletion: time.Now().Unix() + 3600}},
+		{Account: models.Account{AccountID: "not_marked"}},
+	}
+	for _, u := range testUsers {
+		if _, err := testDBService.AddUser(context.Background(), instanceID, u); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	}
+
+	users, err := testDBService.FindUsersMarkedForDeletion(context.Background(), instanceID)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if len(users) != 1 || users[0].Account.AccountID != "marked_in_past" {
+		t.Errorf("unexpected users: %v", users)
+	}
+}
+
+func TestDbCleanupQueriesUseIndexes(t *testing.T) {
+	instanceID := testInstanceID + "_indexes"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if _, err := testDBService.AddUser(context.Background(), instanceID, models.User{Account: models.Account{AccountID: "index_test"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if err := testDBService.CreateIndexForUser(instanceID); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	for name, filter := range map[string]bson.M{
+		"unverified users": unverifiedUsersFilter(time.Now().Unix()),
+		"reminder":         reminderToConfirmAccountFilter(time.Now().Unix()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var res bson.M
+			err := testDBService.DBClient.Database(testDBNamePrefix+instanceID+"_users").RunCommand(context.Background(), bson.D{
+				{Key: "explain", Value: bson.D{
+					{Key: "find", Value: UserCollection},
+					{Key: "filter", Value: filter},
+				}},
+			}).Decode(&res)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			queryPlanner, _ := res["queryPlanner"].(bson.M)
+			plan := fmt.Sprintf("%v", queryPlanner["winningPlan"])
+			if !strings.Contains(plan, "IXSCAN") || strings.Contains(plan, "COLLSCAN") {
+				t.Errorf("query should use an index: %s", plan)
+			}
+		})
+	}
+}
@@ -1,10 +1,13 @@
 package userdb
 
 import (
+	"context"
 	"errors"
 	"time"
 
+	"github.com/influenzanet/user-management-service/pkg/models"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -15,7 +18,7 @@ const (
 )
 
 func (dbService *UserDBService) CreateIndexForRenewTokens(instanceID string) error {
-	ctx, cancel := dbService.getContext()
+	ctx, cancel := dbService.getContext(context.Background())
 	defer cancel()
 
 	_, err := dbService.collectionRenewTokens(instanceID).Indexes().CreateMany(
@@ -43,10 +46,10 @@ func (dbService *UserDBService) CreateIndexForRenewTokens(instanceID string) err
 	return err
 }
 
-func (dbService *UserDBService) DeleteRenewTokenByToken(instanceID string, token string) error {
+func (dbService *UserDBService) DeleteRenewTokenByToken(ctx context.Context, instanceID string, token string) error {
 	filter := bson.M{"renewToken": token}
 
-	ctx, cancel := dbService.getContext()
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 	res, err := dbService.collectionRenewTokens(instanceID).DeleteOne(ctx, filter, nil)
 	if err != nil {
@@ -58,10 +61,10 @@ func (dbService *UserDBService) DeleteRenewTokenByToken(instanceID string, token
 	return nil
 }
 
-func (dbService *UserDBService) DeleteRenewTokensForUser(instanceID string, userID string) (int64, error) {
+func (dbService *UserDBService) DeleteRenewTokensForUser(ctx context.Context, instanceID string, userID string) (int64, error) {
 	filter := bson.M{"userID": userID}
 
-	ctx, cancel := dbService.getContext()
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 	res, err := dbService.collectionRenewTokens(instanceID).DeleteMany(ctx, filter, nil)
 	if err != nil {
@@ -70,10 +73,10 @@ func (dbService *UserDBService) DeleteRenewTokensForUser(instanceID string, user
 	return res.DeletedCount, nil
 }
 
-func (dbService *UserDBService) DeleteExpiredRenewTokens(instanceID string) (int64, error) {
+func (dbService *UserDBService) DeleteExpiredRenewTokens(ctx context.Context, instanceID string) (int64, error) {
 	filter := bson.M{"expiresAt": bson.M{"$lt": time.Now().Unix()}}
 
-	ctx, cancel := dbService.getContext()
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 	res, err := dbService.collectionRenewTokens(instanceID).DeleteMany(ctx, filter, nil)
 	if err != nil {
@@ -82,20 +85,93 @@ func (dbService *UserDBService) DeleteExpiredRenewTokens(instanceID string) (int
 	return res.DeletedCount, nil
 }
 
-func (dbService *UserDBService) CreateRenewToken(instanceID string, userID string, renewToken string, expiresAt int64) error {
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) CreateRenewToken(ctx context.Context, instanceID string, userID string, renewToken string, expiresAt int64) error {
+	return dbService.CreateRenewTokenForSession(ctx, instanceID, RenewToken{
+		UserID:     userID,
+		RenewToken: renewToken,
+		ExpiresAt:  expiresAt,
+	})
+}
+
+// CreateRenewTokenForSession stores a renew token together with the session infos. If CreatedAt is not set, the current time is used.
+func (dbService *UserDBService) CreateRenewTokenForSession(ctx context.Context, instanceID string, rt RenewToken) error {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
-	_, err := dbService.collectionRenewTokens(instanceID).InsertOne(ctx, bson.M{
-		"userID":     userID,
-		"renewToken": renewToken,
-		"expiresAt":  expiresAt,
-	})
+	now := time.Now().Unix()
+	if rt.CreatedAt == 0 {
+		rt.CreatedAt = now
+	}
+	doc := bson.M{
+		"userID":     rt.UserID,
+		"renewToken": rt.RenewToken,
+		"expiresAt":  rt.ExpiresAt,
+		"createdAt":  rt.CreatedAt,
+		"lastUsedAt": now,
+	}
+	if rt.UserAgent != "" {
+		doc["userAgent"] = rt.UserAgent
+	}
+	if rt.RememberMe {
+		doc["rememberMe"] = true
+	}
+	_, err := dbService.collectionRenewTokens(instanceID).InsertOne(ctx, doc)
+	return err
+}
+
+// FindSessionsForUser returns the renew tokens of the user which are still valid and were not replaced yet
+func (dbService *UserDBService) FindSessionsForUser(ctx context.Context, instanceID string, userID string) (rts []RenewToken, err error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"userID":    userID,
+		"expiresAt": bson.M{"$gt": time.Now().Unix()},
+		"nextToken": bson.M{"$in": bson.A{nil, ""}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}})
+
+	cur, err := dbService.collectionRenewTokens(instanceID).Find(ctx, filter, opts)
+	if err != nil {
+		return rts, err
+	}
+	defer cur.Close(ctx)
+
+	rts = []RenewToken{}
+	err = cur.All(ctx, &rts)
+	return rts, err
+}
+
+// DeleteSessionForUser removes a single renew token of the user, identified either by its value or by its session id.
+// Tokens that were replaced by the removed one (still in grace period) are removed as well.
+func (dbService *UserDBService) DeleteSessionForUser(ctx context.Context, instanceID string, userID string, tokenOrID string) error {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	selectors := bson.A{bson.M{"renewToken": tokenOrID}}
+	if _id, err := primitive.ObjectIDFromHex(tokenOrID); err == nil {
+		selectors = append(selectors, bson.M{"_id": _id})
+	}
+
+	var rt RenewToken
+	err := dbService.collectionRenewTokens(instanceID).FindOne(ctx, bson.M{"userID": userID, "$or": selectors}).Decode(&rt)
+	if err != nil {
+		return errors.New("no renew token oject found with the given token value")
+	}
+
+	filter := bson.M{
+		"userID": userID,
+		"$or": bson.A{
+			bson.M{"_id": rt.ID},
+			bson.M{"nextToken": rt.RenewToken},
+		},
+	}
+	_, err = dbService.collectionRenewTokens(instanceID).DeleteMany(ctx, filter, nil)
 	return err
 }
 
-func (dbService *UserDBService) FindAndUpdateRenewToken(instanceID string, userID string, renewToken string, nextToken string) (rtObj RenewToken, err error) {
-	ctx, cancel := dbService.getContext()
+func (dbService *UserDBService) FindAndUpdateRenewToken(ctx context.Context, instanceID string, userID string, renewToken string, nextToken string) (rtObj RenewToken, err error) {
+	ctx, cancel := dbService.getContext(ctx)
 	defer cancel()
 
 	filter := bson.M{"userID": userID, "renewToken": renewToken, "expiresAt": bson.M{"$gt": time.Now().Unix()}}
@@ -114,6 +190,7 @@ func (dbService *UserDBService) FindAndUpdateRenewToken(instanceID string, userI
 						"$nextToken",
 					},
 				},
+				"lastUsedAt": time.Now().Unix(),
 				"expiresAt": bson.M{
 					"$cond": bson.A{
 						bson.M{
@@ -136,9 +213,62 @@ func (dbService *UserDBService) FindAndUpdateRenewToken(instanceID string, userI
 	return
 }
 
+// DeleteOldestSessionsForUser removes the oldest sessions of the user so that at most maxSessions remain.
+// Returns the number of removed sessions.
+func (dbService *UserDBService) DeleteOldestSessionsForUser(ctx context.Context, instanceID string, userID string, maxSessions int64) (int64, error) {
+	rts, err := dbService.FindSessionsForUser(ctx, instanceID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(rts)) <= maxSessions {
+		return 0, nil
+	}
+
+	evicted := rts[maxSessions:]
+	ids := make(bson.A, len(evicted))
+	values := make(bson.A, len(evicted))
+	for i, rt := range evicted {
+		ids[i] = rt.ID
+		values[i] = rt.RenewToken
+	}
+
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"userID": userID,
+		"$or": bson.A{
+			bson.M{"_id": bson.M{"$in": ids}},
+			bson.M{"nextToken": bson.M{"$in": values}},
+		},
+	}
+	if _, err := dbService.collectionRenewTokens(instanceID).DeleteMany(ctx, filter, nil); err != nil {
+		return 0, err
+	}
+	return int64(len(evicted)), nil
+}
+
 type RenewToken struct {
-	UserID     string `bson:"userID"`
-	RenewToken string `bson:"renewToken"`
-	ExpiresAt  int64  `bson:"expiresAt"`
-	NextToken  string `bson:"nextToken"` // token that replaces the current renew token
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     string             `bson:"userID"`
+	RenewToken string             `bson:"renewToken"`
+	ExpiresAt  int64              `bson:"expiresAt"`
+	NextToken  string             `bson:"nextToken"` // token that replaces the current renew token
+	CreatedAt  int64              `bson:"createdAt"` // login time of the session, kept when the token is replaced
+	LastUsedAt int64              `bson:"lastUsedAt"`
+	UserAgent  string             `bson:"userAgent"`
+	RememberMe bool               `bson:"rememberMe"` // the session uses the longer refresh token lifetime
+	// set if the session is limited to a child profile, its access tokens are issued for it only
+	ChildProfileID string `bson:"childProfileID,omitempty"`
+}
+
+// ToSession converts the renew token into the session infos shown to the user
+func (rt RenewToken) ToSession() models.Session {
+	return models.Session{
+		ID:         rt.ID.Hex(),
+		CreatedAt:  rt.CreatedAt,
+		LastUsedAt: rt.LastUsedAt,
+		ExpiresAt:  rt.ExpiresAt,
+		UserAgent:  rt.UserAgent,
+	}
 }
@@ -1,6 +1,7 @@
 package userdb
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -17,7 +18,7 @@ func TestRenewTokenDBMethods(t *testing.T) {
 	logger.Debug.Println(testToken)
 
 	t.Run("Testing create token", func(t *testing.T) {
-		err := testDBService.CreateRenewToken(testInstanceID, testToken.UserID, testToken.RenewToken, testToken.ExpiresAt)
+		err := testDBService.CreateRenewToken(context.Background(), testInstanceID, testToken.UserID, testToken.RenewToken, testToken.ExpiresAt)
 		if err != nil {
 			t.Errorf(err.Error())
 			return
@@ -27,7 +28,7 @@ func TestRenewTokenDBMethods(t *testing.T) {
 	firstNextToken := "FIRST_NEXT_TOKEN"
 	secondNextToken := "SECOND_NEXT_TOKEN"
 	t.Run("Testing conditional update with empty nextToken", func(t *testing.T) {
-		rt, err := testDBService.FindAndUpdateRenewToken(testInstanceID, testToken.UserID, testToken.RenewToken, firstNextToken)
+		rt, err := testDBService.FindAndUpdateRenewToken(context.Background(), testInstanceID, testToken.UserID, testToken.RenewToken, firstNextToken)
 		if err != nil {
 			t.Errorf(err.Error())
 			return
@@ -44,7 +45,7 @@ func TestRenewTokenDBMethods(t *testing.T) {
 	})
 
 	t.Run("Testing conditional update with non empty nextToken", func(t *testing.T) {
-		rt, err := testDBService.FindAndUpdateRenewToken(testInstanceID, testToken.UserID, testToken.RenewToken, secondNextToken)
+		rt, err := testDBService.FindAndUpdateRenewToken(context.Background(), testInstanceID, testToken.UserID, testToken.RenewToken, secondNextToken)
 		if err != nil {
 			t.Errorf(err.Error())
 			return
@@ -66,13 +67,13 @@ func TestRenewTokenDBMethods(t *testing.T) {
 
 	t.Run("Testing finding renew token which expired", func(t *testing.T) {
 		tokenValue := "TEST_RENEW_TOKEN_EXPIRED"
-		err := testDBService.CreateRenewToken(testInstanceID, testToken.UserID, tokenValue, time.Now().Unix()-1000)
+		err := testDBService.CreateRenewToken(context.Background(), testInstanceID, testToken.UserID, tokenValue, time.Now().Unix()-1000)
 		if err != nil {
 			t.Errorf(err.Error())
 			return
 		}
 
-		_, err = testDBService.FindAndUpdateRenewToken(testInstanceID, testToken.UserID, tokenValue, secondNextToken)
+		_, err = testDBService.FindAndUpdateRenewToken(context.Background(), testInstanceID, testToken.UserID, tokenValue, secondNextToken)
 		if err == nil {
 			t.Error("should return error")
 			return
@@ -80,3 +81,52 @@ func TestRenewTokenDBMethods(t *testing.T) {
 	})
 
 }
+
+func TestDeleteOldestSessionsForUser(t *testing.T) {
+	userID := "TEST_USER_ID_SESSION_LIMIT"
+	now := time.Now().Unix()
+	tokens := []string{"SESSION_LIMIT_TOKEN_1", "SESSION_LIMIT_TOKEN_2", "SESSION_LIMIT_TOKEN_3", "SESSION_LIMIT_TOKEN_4"}
+	for i, token := range tokens {
+		err := testDBService.CreateRenewTokenForSession(context.Background(), testInstanceID, RenewToken{
+			UserID:     userID,
+			RenewToken: token,
+			ExpiresAt:  now + 1000,
+			CreatedAt:  now - 100 + int64(i),
+		})
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+	}
+
+	t.Run("within limit", func(t *testing.T) {
+		count, err := testDBService.DeleteOldestSessionsForUser(context.Background(), testInstanceID, userID, 4)
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		if count != 0 {
+			t.Errorf("no session should be removed, got %d", count)
+		}
+	})
+
+	t.Run("exceeding limit", func(t *testing.T) {
+		count, err := testDBService.DeleteOldestSessionsForUser(context.Background(), testInstanceID, userID, 2)
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		if count != 2 {
+			t.Errorf("unexpected number of removed sessions: %d", count)
+		}
+
+		rts, err := testDBService.FindSessionsForUser(context.Background(), testInstanceID, userID)
+		if err != nil {
+			t.Errorf(err.Error())
+			return
+		}
+		if len(rts) != 2 || rts[0].RenewToken != tokens[3] || rts[1].RenewToken != tokens[2] {
+			t.Errorf("newest sessions should remain: %v", rts)
+		}
+	})
+}
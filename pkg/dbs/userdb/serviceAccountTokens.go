@@ -0,0 +1,54 @@
+package userdb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (dbService *UserDBService) AddServiceAccountToken(ctx context.Context, instanceID string, t models.ServiceAccountToken) (id string, err error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	res, err := dbService.collectionServiceAccountTokens(instanceID).InsertOne(ctx, t)
+	if err != nil {
+		return
+	}
+	id = res.InsertedID.(primitive.ObjectID).Hex()
+	return
+}
+
+func (dbService *UserDBService) FindServiceAccountToken(ctx context.Context, instanceID string, tokenID string) (t models.ServiceAccountToken, err error) {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	_id, err := primitive.ObjectIDFromHex(tokenID)
+	if err != nil {
+		return
+	}
+	filter := bson.M{"_id": _id}
+	err = dbService.collectionServiceAccountTokens(instanceID).FindOne(ctx, filter).Decode(&t)
+	return
+}
+
+func (dbService *UserDBService) DeleteServiceAccountToken(ctx context.Context, instanceID string, tokenID string) error {
+	ctx, cancel := dbService.getContext(ctx)
+	defer cancel()
+
+	_id, err := primitive.ObjectIDFromHex(tokenID)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": _id}
+	res, err := dbService.collectionServiceAccountTokens(instanceID).DeleteOne(ctx, filter, nil)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount < 1 {
+		return errors.New("no service account token found with the given id")
+	}
+	return nil
+}
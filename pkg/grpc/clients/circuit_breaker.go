@@ -0,0 +1,132 @@
+package clients
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coneno/logger"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCircuitOpen is returned without calling the service while the circuit breaker is open
+var ErrCircuitOpen = status.Error(codes.Unavailable, "circuit breaker open: service unavailable")
+
+// CircuitBreaker stops calling a service after consecutive failures. Once the open duration is over,
+// a single trial call is let through: on success the breaker closes again, otherwise it stays open for another period.
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openDuration     time.Duration
+	failures         int
+	openUntil        time.Time
+	trialRunning     bool
+}
+
+func NewCircuitBreaker(failureThreshold int, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+	}
+}
+
+func (cb *CircuitBreaker) allow() (trial bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures < cb.failureThreshold {
+		return false, nil
+	}
+	if time.Now().Before(cb.openUntil) || cb.trialRunning {
+		return false, ErrCircuitOpen
+	}
+	cb.trialRunning = true
+	return true, nil
+}
+
+func (cb *CircuitBreaker) record(trial bool, failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if trial {
+		cb.trialRunning = false
+	}
+	if !failed {
+		if cb.failures >= cb.failureThreshold {
+			logger.Info.Println("circuit breaker closed: service reachable again")
+		}
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.failures >= cb.failureThreshold {
+		if trial || cb.failures == cb.failureThreshold {
+			logger.Warning.Printf("circuit breaker open for %s after %d failures", cb.openDuration, cb.failures)
+		}
+		cb.openUntil = time.Now().Add(cb.openDuration)
+	}
+}
+
+// Call runs fn if the breaker allows it and records the outcome. Only errors showing that the service is not available count as failures.
+func (cb *CircuitBreaker) Call(fn func() error) error {
+	trial, err := cb.allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	cb.record(trial, isUnavailableError(err))
+	return err
+}
+
+func isUnavailableError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// messagingClientWithBreaker sends messages through the circuit breaker, other methods are passed to the client as is
+type messagingClientWithBreaker struct {
+	messageAPI.MessagingServiceApiClient
+	breaker     *CircuitBreaker
+	callTimeout time.Duration
+}
+
+// NewMessagingClientWithCircuitBreaker wraps the messaging client, so that message sending fails fast while the messaging service is unhealthy
+func NewMessagingClientWithCircuitBreaker(client messageAPI.MessagingServiceApiClient, conf models.CircuitBreakerConfig) messageAPI.MessagingServiceApiClient {
+	return &messagingClientWithBreaker{
+		MessagingServiceApiClient: client,
+		breaker:                   NewCircuitBreaker(conf.FailureThreshold, conf.OpenDuration),
+		callTimeout:               conf.CallTimeout,
+	}
+}
+
+func (c *messagingClientWithBreaker) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.callTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.callTimeout)
+}
+
+func (c *messagingClientWithBreaker) SendInstantEmail(ctx context.Context, in *messageAPI.SendEmailReq, opts ...grpc.CallOption) (resp *messageAPI.ServiceStatus, err error) {
+	err = c.breaker.Call(func() error {
+		ctx, cancel := c.callContext(ctx)
+		defer cancel()
+		resp, err = c.MessagingServiceApiClient.SendInstantEmail(ctx, in, opts...)
+		return err
+	})
+	return resp, err
+}
+
+func (c *messagingClientWithBreaker) QueueEmailTemplateForSending(ctx context.Context, in *messageAPI.SendEmailReq, opts ...grpc.CallOption) (resp *messageAPI.ServiceStatus, err error) {
+	err = c.breaker.Call(func() error {
+		ctx, cancel := c.callContext(ctx)
+		defer cancel()
+		resp, err = c.MessagingServiceApiClient.QueueEmailTemplateForSending(ctx, in, opts...)
+		return err
+	})
+	return resp, err
+}
@@ -0,0 +1,119 @@
+package clients
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMessagingClientWithCircuitBreaker(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+
+	openDuration := 50 * time.Millisecond
+	client := NewMessagingClientWithCircuitBreaker(mockMessagingClient, models.CircuitBreakerConfig{
+		FailureThreshold: 3,
+		OpenDuration:     openDuration,
+	})
+	req := &messageAPI.SendEmailReq{InstanceId: "test", MessageType: "test"}
+
+	t.Run("other errors don't open the breaker", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).
+			Return(nil, status.Error(codes.InvalidArgument, "wrong template")).Times(4)
+		for i := 0; i < 4; i++ {
+			_, err := client.SendInstantEmail(context.Background(), req)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	})
+
+	t.Run("repeated failures open the breaker", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).
+			Return(nil, status.Error(codes.Unavailable, "connection refused")).Times(3)
+		for i := 0; i < 3; i++ {
+			_, err := client.SendInstantEmail(context.Background(), req)
+			if status.Code(err) != codes.Unavailable {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	})
+
+	t.Run("open breaker fails fast", func(t *testing.T) {
+		// no call expected on the mock
+		start := time.Now()
+		_, err := client.SendInstantEmail(context.Background(), req)
+		if err != ErrCircuitOpen {
+			t.Errorf("unexpected error: %v", err)
+		}
+		_, err = client.QueueEmailTemplateForSending(context.Background(), req)
+		if err != ErrCircuitOpen {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if time.Since(start) > 10*time.Millisecond {
+			t.Errorf("calls should fail immediately, took %s", time.Since(start))
+		}
+	})
+
+	t.Run("failed trial call opens the breaker again", func(t *testing.T) {
+		time.Sleep(openDuration)
+		mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).
+			Return(nil, status.Error(codes.DeadlineExceeded, "timeout")).Times(1)
+		_, err := client.SendInstantEmail(context.Background(), req)
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Errorf("unexpected error: %v", err)
+		}
+		_, err = client.SendInstantEmail(context.Background(), req)
+		if err != ErrCircuitOpen {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("successful trial call closes the breaker", func(t *testing.T) {
+		time.Sleep(openDuration)
+		mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).
+			Return(&messageAPI.ServiceStatus{}, nil).Times(2)
+		for i := 0; i < 2; i++ {
+			_, err := client.SendInstantEmail(context.Background(), req)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	})
+}
+
+func TestMessagingClientCallTimeout(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+
+	client := NewMessagingClientWithCircuitBreaker(mockMessagingClient, models.CircuitBreakerConfig{
+		FailureThreshold: 1,
+		OpenDuration:     time.Minute,
+		CallTimeout:      10 * time.Millisecond,
+	})
+	req := &messageAPI.SendEmailReq{InstanceId: "test", MessageType: "test"}
+
+	mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *messageAPI.SendEmailReq, opts ...interface{}) (*messageAPI.ServiceStatus, error) {
+			<-ctx.Done()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}).Times(1)
+
+	_, err := client.SendInstantEmail(context.Background(), req)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = client.SendInstantEmail(context.Background(), req)
+	if err != ErrCircuitOpen {
+		t.Errorf("slow service should open the breaker: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package clients
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coneno/logger"
+	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	"google.golang.org/grpc"
+)
+
+// requestFlushTimeout bounds the flush of the buffered events done before sending a new one, so that the request
+// logging the event doesn't wait long for a logging service which is still unreachable
+const requestFlushTimeout = 2 * time.Second
+
+type bufferedLogEvent struct {
+	event      *loggingAPI.NewLogEvent
+	occurredAt time.Time
+}
+
+// BufferedLoggingClient keeps log events in a bounded in-memory queue while the logging service is unavailable,
+// and sends them once it's reachable again. When the queue is full, the oldest event is dropped.
+type BufferedLoggingClient struct {
+	loggingAPI.LoggingServiceApiClient
+	maxSize int
+
+	mu      sync.Mutex
+	buffer  []*bufferedLogEvent
+	dropped uint64
+
+	flushMu sync.Mutex
+}
+
+func NewBufferedLoggingClient(client loggingAPI.LoggingServiceApiClient, maxSize int) *BufferedLoggingClient {
+	return &BufferedLoggingClient{
+		LoggingServiceApiClient: client,
+		maxSize:                 maxSize,
+	}
+}
+
+// SaveLogEvent sends the event after the buffered ones. If the logging service is unavailable, the event is buffered and no error is returned.
+// The event is also buffered, without waiting, while another request or FlushPeriodically is flushing the buffer.
+func (c *BufferedLoggingClient) SaveLogEvent(ctx context.Context, in *loggingAPI.NewLogEvent, opts ...grpc.CallOption) (*api_types.ServiceStatus, error) {
+	if c.Buffered() > 0 {
+		if !c.flushMu.TryLock() {
+			return c.add(in), nil
+		}
+		// not derived from ctx: a cancelled request must not make the buffered events look rejected and drop them
+		flushCtx, cancel := context.WithTimeout(context.Background(), requestFlushTimeout)
+		err := c.flush(flushCtx)
+		cancel()
+		c.flushMu.Unlock()
+		if err != nil {
+			return c.add(in), nil
+		}
+	}
+	resp, err := c.LoggingServiceApiClient.SaveLogEvent(ctx, in, opts...)
+	if isUnavailableError(err) {
+		logger.Warning.Printf("logging service unavailable, buffering log event: %v", err)
+		return c.add(in), nil
+	}
+	return resp, err
+}
+
+func (c *BufferedLoggingClient) add(in *loggingAPI.NewLogEvent) *api_types.ServiceStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.buffer) >= c.maxSize {
+		oldest := c.buffer[0].event
+		c.buffer = c.buffer[1:]
+		c.dropped++
+		logger.Error.Printf("log buffer full, dropped event %s (%s) of user %s in %s: %s", oldest.EventName, oldest.EventType, oldest.UserId, oldest.InstanceId, oldest.Msg)
+	}
+	c.buffer = append(c.buffer, &bufferedLogEvent{event: in, occurredAt: time.Now()})
+	return &api_types.ServiceStatus{
+		Status: api_types.ServiceStatus_NORMAL,
+		Msg:    "log event buffered",
+	}
+}
+
+// Buffered returns the number of events waiting to be sent
+func (c *BufferedLoggingClient) Buffered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.buffer)
+}
+
+// Dropped returns the number of events lost because the buffer was full or the logging service rejected them
+func (c *BufferedLoggingClient) Dropped() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// Flush sends the buffered events in order. It stops at the first event that can't be sent because the logging service is still unavailable.
+func (c *BufferedLoggingClient) Flush(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	return c.flush(ctx)
+}
+
+// flush sends the buffered events, flushMu has to be held
+func (c *BufferedLoggingClient) flush(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if len(c.buffer) == 0 {
+			c.mu.Unlock()
+			return nil
+		}
+		next := c.buffer[0]
+		c.mu.Unlock()
+
+		// the logging service stamps events when received, so keep the original time in the message
+		event := &loggingAPI.NewLogEvent{
+			InstanceId: next.event.InstanceId,
+			Origin:     next.event.Origin,
+			EventType:  next.event.EventType,
+			EventName:  next.event.EventName,
+			UserId:     next.event.UserId,
+			Msg:        next.event.Msg + " (delayed, occurred at " + next.occurredAt.UTC().Format(time.RFC3339) + ")",
+		}
+		_, err := c.LoggingServiceApiClient.SaveLogEvent(ctx, event)
+		if isUnavailableError(err) {
+			return err
+		}
+
+		c.mu.Lock()
+		if err != nil {
+			c.dropped++
+			logger.Error.Printf("buffered log event %s rejected by the logging service: %v", next.event.EventName, err)
+		}
+		// the event may have been dropped meanwhile if the buffer was full
+		if len(c.buffer) > 0 && c.buffer[0] == next {
+			c.buffer = c.buffer[1:]
+		}
+		c.mu.Unlock()
+	}
+}
+
+// FlushPeriodically retries sending the buffered events at the given interval, so they don't wait for the next event
+func (c *BufferedLoggingClient) FlushPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		if c.Buffered() == 0 {
+			continue
+		}
+		if err := c.Flush(context.Background()); err != nil {
+			logger.Debug.Printf("logging service still unavailable, %d events buffered", c.Buffered())
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package clients
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBufferedLoggingClient(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	client := NewBufferedLoggingClient(mockLoggingClient, 3)
+	newEvent := func(name string) *loggingAPI.NewLogEvent {
+		return &loggingAPI.NewLogEvent{Origin: "user-management", InstanceId: "test", EventName: name, Msg: name}
+	}
+
+	var received []string
+	logServiceUp := func(times int) {
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, in *loggingAPI.NewLogEvent, opts ...grpc.CallOption) (*api_types.ServiceStatus, error) {
+				received = append(received, in.Msg)
+				return &api_types.ServiceStatus{}, nil
+			}).Times(times)
+	}
+	logServiceDown := func(times int) {
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).
+			Return(nil, status.Error(codes.Unavailable, "connection refused")).Times(times)
+	}
+
+	t.Run("logging service available", func(t *testing.T) {
+		logServiceUp(1)
+		_, err := client.SaveLogEvent(context.Background(), newEvent("e1"))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if client.Buffered() != 0 || len(received) != 1 || received[0] != "e1" {
+			t.Errorf("unexpected state: %d buffered, %v received", client.Buffered(), received)
+		}
+		received = nil
+	})
+
+	t.Run("outage", func(t *testing.T) {
+		// first event fails on sending, the next ones on flushing the buffer
+		logServiceDown(4)
+		for _, name := range []string{"e2", "e3", "e4", "e5"} {
+			_, err := client.SaveLogEvent(context.Background(), newEvent(name))
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		if client.Buffered() != 3 {
+			t.Errorf("unexpected buffered count: %d", client.Buffered())
+		}
+		if client.Dropped() != 1 {
+			t.Errorf("unexpected dropped count: %d", client.Dropped())
+		}
+	})
+
+	t.Run("recovery flushes buffered events before the new one", func(t *testing.T) {
+		logServiceUp(4)
+		_, err := client.SaveLogEvent(context.Background(), newEvent("e6"))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if client.Buffered() != 0 {
+			t.Errorf("unexpected buffered count: %d", client.Buffered())
+		}
+		if len(received) != 4 {
+			t.Errorf("unexpected events: %v", received)
+			return
+		}
+		for i, name := range []string{"e3", "e4", "e5"} {
+			if !strings.HasPrefix(received[i], name+" (delayed, occurred at ") {
+				t.Errorf("unexpected event %d: %s", i, received[i])
+			}
+		}
+		if received[3] != "e6" {
+			t.Errorf("unexpected last event: %s", received[3])
+		}
+		received = nil
+	})
+
+	t.Run("periodic flush without new events", func(t *testing.T) {
+		logServiceDown(1)
+		_, _ = client.SaveLogEvent(context.Background(), newEvent("e7"))
+		logServiceUp(1)
+		if err := client.Flush(context.Background()); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if client.Buffered() != 0 || len(received) != 1 {
+			t.Errorf("unexpected state: %d buffered, %v received", client.Buffered(), received)
+		}
+	})
+
+	t.Run("other errors are not buffered", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).
+			Return(nil, status.Error(codes.InvalidArgument, "missing instance")).Times(1)
+		_, err := client.SaveLogEvent(context.Background(), newEvent("e8"))
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if client.Buffered() != 0 {
+			t.Errorf("unexpected buffered count: %d", client.Buffered())
+		}
+	})
+
+	t.Run("no wait for a running flush", func(t *testing.T) {
+		logServiceDown(1)
+		_, _ = client.SaveLogEvent(context.Background(), newEvent("e9"))
+
+		client.flushMu.Lock()
+		_, err := client.SaveLogEvent(context.Background(), newEvent("e10"))
+		client.flushMu.Unlock()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if client.Buffered() != 2 {
+			t.Errorf("unexpected buffered count: %d", client.Buffered())
+		}
+
+		// the flush on the request path has a deadline
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, in *loggingAPI.NewLogEvent, opts ...grpc.CallOption) (*api_types.ServiceStatus, error) {
+				if _, ok := ctx.Deadline(); !ok && in.Msg != "e11" {
+					t.Errorf("flush of %s without deadline", in.Msg)
+				}
+				return &api_types.ServiceStatus{}, nil
+			}).Times(3)
+		if _, err := client.SaveLogEvent(context.Background(), newEvent("e11")); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if client.Buffered() != 0 {
+			t.Errorf("unexpected buffered count: %d", client.Buffered())
+		}
+	})
+	t.Run("cancelled request keeps buffered events", func(t *testing.T) {
+		logServiceDown(1)
+		_, _ = client.SaveLogEvent(context.Background(), newEvent("e12"))
+
+		received = nil
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, in *loggingAPI.NewLogEvent, opts ...grpc.CallOption) (*api_types.ServiceStatus, error) {
+				if ctx.Err() != nil {
+					return nil, status.FromContextError(ctx.Err()).Err()
+				}
+				received = append(received, in.Msg)
+				return &api_types.ServiceStatus{}, nil
+			}).Times(2)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := client.SaveLogEvent(ctx, newEvent("e13"))
+		if status.Code(err) != codes.Canceled {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if client.Buffered() != 0 || len(received) != 1 || !strings.HasPrefix(received[0], "e12 (delayed, occurred at ") {
+			t.Errorf("unexpected state: %d buffered, %v received", client.Buffered(), received)
+		}
+		if client.Dropped() != 1 {
+			t.Errorf("unexpected dropped count: %d", client.Dropped())
+		}
+	})
+}
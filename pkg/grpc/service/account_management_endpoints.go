@@ -2,10 +2,15 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coneno/logger"
+	api_types "github.com/influenzanet/go-utils/pkg/api_types"
 	"github.com/influenzanet/go-utils/pkg/constants"
 	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
 	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
@@ -14,49 +19,117 @@ import (
 	"github.com/influenzanet/user-management-service/pkg/pwhash"
 	"github.com/influenzanet/user-management-service/pkg/tokens"
 	"github.com/influenzanet/user-management-service/pkg/utils"
+	"go.mongodb.org/mongo-driver/mongo"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// GetUser returns the user of the token. Admins can fetch other users of the instance, each access is logged.
 func (s *userManagementServer) GetUser(ctx context.Context, req *api.UserReference) (*api.User, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 
 	if req.UserId == "" {
 		req.UserId = req.Token.Id
 	}
 
-	if req.Token.Id != req.UserId { // Later can be overwritten
+	otherUser := req.Token.Id != req.UserId
+	if otherUser && !tokens.IsAdmin(req.Token.Payload) {
 		logger.Warning.Printf("SECURITY WARNING: not authorized GetUser(): %s tried to access %s", req.Token.Id, req.UserId)
 		return nil, status.Error(codes.PermissionDenied, "not authorized")
 	}
 
-	user, err := s.userDBservice.GetUserByID(req.Token.InstanceId, req.UserId)
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.UserId)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "not found")
+		return nil, userLookupError(err)
+	}
+	if otherUser {
+		logger.Info.Printf("admin %s fetched user %s", req.Token.Id, req.UserId)
+		s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_USER_DATA_ACCESSED, "GetUser: "+req.UserId)
 	}
 	return user.ToAPI(), nil
 }
 
+// GetAccountStatus returns if the account of the user is confirmed, so clients don't have to renew the token to know it,
+// and the policies the client should prompt the user to accept
+func (s *userManagementServer) GetAccountStatus(ctx context.Context, req *api.UserReference) (*models.AccountStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if req.UserId == "" {
+		req.UserId = req.Token.Id
+	}
+	if req.Token.Id != req.UserId {
+		logger.Warning.Printf("SECURITY WARNING: not authorized GetAccountStatus(): %s tried to access %s", req.Token.Id, req.UserId)
+		return nil, status.Error(codes.PermissionDenied, "not authorized")
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.UserId)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+
+	accountStatus := &models.AccountStatus{
+		AccountConfirmed:        user.Account.AccountConfirmedAt > 0,
+		VerificationEmailSentAt: user.Timestamps.ReminderToConfirmSentAt,
+		PendingPolicies:         s.pendingPolicies(req.Token.InstanceId, user),
+	}
+	if accountStatus.AccountConfirmed {
+		accountStatus.AccountConfirmedAt = user.Account.AccountConfirmedAt
+		return accountStatus, nil
+	}
+
+	now := time.Now().Unix()
+	if ci, found := user.FindContactInfoByTypeAndAddr("email", user.Account.AccountID); found {
+		if ci.ConfirmationLinkSentAt > accountStatus.VerificationEmailSentAt {
+			accountStatus.VerificationEmailSentAt = ci.ConfirmationLinkSentAt
+		}
+		if ci.VerificationCode != nil && ci.VerificationCode.ExpiresAt > now && ci.VerificationCode.Attempts < allowedVerificationCodeAttempts {
+			accountStatus.VerificationPending = true
+		}
+	}
+	if !accountStatus.VerificationPending {
+		tts, err := s.globalDBService.GetTempTokenForUser(req.Token.InstanceId, req.UserId, constants.TOKEN_PURPOSE_CONTACT_VERIFICATION)
+		if err != nil {
+			logger.Error.Printf("GetAccountStatus: %v", err)
+		}
+		for _, tt := range tts {
+			if tt.Info["email"] == user.Account.AccountID && tt.Expiration > now {
+				accountStatus.VerificationPending = true
+				break
+			}
+		}
+	}
+	return accountStatus, nil
+}
+
 func (s *userManagementServer) ChangePassword(ctx context.Context, req *api.PasswordChangeMsg) (*api.ServiceStatus, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
 	}
 
-	if !utils.CheckPasswordFormat(req.NewPassword) {
-		return nil, status.Error(codes.InvalidArgument, "new password too weak")
+	if !s.checkPasswordPolicy(req.Token.InstanceId, req.NewPassword) {
+		return nil, errorWithCode(codes.InvalidArgument, "new password too weak", models.ERROR_CODE_PASSWORD_TOO_WEAK, fieldViolation("new_password", "too weak"))
 	}
 
-	user, err := s.userDBservice.GetUserByID(req.Token.InstanceId, req.Token.Id)
+	// same error for unknown user and wrong password
+	wrongPasswordErr := errorWithCode(codes.InvalidArgument, "invalid user and/or password", models.ERROR_CODE_INVALID_CREDENTIALS, fieldViolation("old_password", "invalid user and/or password"))
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, "invalid user and/or password")
+		return nil, wrongPasswordErr
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
 	}
 
 	match, err := pwhash.ComparePasswordWithHash(user.Account.Password, req.OldPassword)
 	if err != nil || !match {
 		s.SaveLogEvent(req.Token.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_AUTH_WRONG_PASSWORD, "change password endpoint")
-		return nil, status.Error(codes.InvalidArgument, "invalid user and/or password")
+		return nil, wrongPasswordErr
 	}
 
 	newHashedPw, err := pwhash.HashPassword(req.NewPassword)
@@ -64,23 +137,20 @@ func (s *userManagementServer) ChangePassword(ctx context.Context, req *api.Pass
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	err = s.userDBservice.UpdateUserPassword(req.Token.InstanceId, req.Token.Id, newHashedPw)
+	err = s.userDBservice.UpdateUserPassword(ctx, req.Token.InstanceId, req.Token.Id, newHashedPw)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	logger.Info.Printf("user %s initiated password change", req.Token.Id)
 
 	// Trigger message sending
-	_, err = s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+	s.sendEmailWithRetry(ctx, "ChangePassword", &messageAPI.SendEmailReq{
 		InstanceId:        req.Token.InstanceId,
-		To:                []string{user.Account.AccountID},
+		To:                []string{user.NotificationEmail()},
 		MessageType:       constants.EMAIL_TYPE_PASSWORD_CHANGED,
 		PreferredLanguage: user.Account.PreferredLanguage,
 		UseLowPrio:        true,
 	})
-	if err != nil {
-		logger.Error.Printf("ChangePassword: %s", err.Error())
-	}
 	// ---
 
 	// remove all temptokens for password reset:
@@ -98,16 +168,32 @@ func (s *userManagementServer) ChangePassword(ctx context.Context, req *api.Pass
 
 func (s *userManagementServer) ChangeAccountIDEmail(ctx context.Context, req *api.EmailChangeMsg) (*api.User, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.NewEmail == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
 	}
 
 	req.NewEmail = utils.SanitizeEmail(req.NewEmail)
 	if !utils.CheckEmailFormat(req.NewEmail) {
-		return nil, status.Error(codes.InvalidArgument, "email not valid")
+		return nil, errorWithCode(codes.InvalidArgument, "email not valid", models.ERROR_CODE_INVALID_EMAIL)
+	}
+	if !s.checkEmailDomainPolicy(req.Token.InstanceId, req.NewEmail) {
+		return nil, errorWithCode(codes.InvalidArgument, "email domain not allowed", models.ERROR_CODE_EMAIL_DOMAIN_NOT_ALLOWED)
 	}
-	user, err := s.userDBservice.GetUserByID(req.Token.InstanceId, req.Token.Id)
+	disposableEmail := s.isDisposableEmail(req.NewEmail)
+	if disposableEmail && s.rejectDisposableEmails() {
+		return nil, errorWithCode(codes.InvalidArgument, "disposable email not allowed", models.ERROR_CODE_DISPOSABLE_EMAIL_NOT_ALLOWED)
+	}
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "user not found")
+		return nil, userLookupError(err)
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
+	}
+	if err := s.requirePolicyAcceptance(req.Token.InstanceId, user); err != nil {
+		return nil, err
 	}
 
 	match, err := pwhash.ComparePasswordWithHash(user.Account.Password, req.Password)
@@ -117,7 +203,7 @@ func (s *userManagementServer) ChangeAccountIDEmail(ctx context.Context, req *ap
 	}
 
 	// is email address still free to use?
-	_, err = s.userDBservice.GetUserByAccountID(req.Token.InstanceId, req.NewEmail)
+	_, err = s.userDBservice.GetUserByAccountID(ctx, req.Token.InstanceId, req.NewEmail)
 	if err == nil {
 		return nil, status.Error(codes.Internal, "action failed")
 	}
@@ -129,43 +215,31 @@ func (s *userManagementServer) ChangeAccountIDEmail(ctx context.Context, req *ap
 	if !oldFound {
 		return nil, status.Error(codes.Internal, "old contact info not found - unexpected error")
 	}
+	knownCI, newEmailKnown := user.FindContactInfoByTypeAndAddr("email", req.NewEmail)
+	// the user may take back an own address they confirmed
+	if !(newEmailKnown && knownCI.ConfirmedAt > 0) && s.isEmailReleasedRecently(req.Token.InstanceId, req.NewEmail) {
+		return nil, errorWithCode(codes.InvalidArgument, emailRecentlyReleasedMsg, models.ERROR_CODE_EMAIL_RECENTLY_RELEASED)
+	}
 
-	if user.Account.AccountConfirmedAt > 0 {
+	oldEmail := user.Account.AccountID
+	oldEmailConfirmed := user.Account.AccountConfirmedAt > 0
+	tempTokens := []models.TempToken{}
+	if oldEmailConfirmed {
 		// Old AccountID already confirmed
 
-		// TempToken for contact verification:
-		tempTokenInfos := models.TempToken{
+		// TempToken to restore the old account ID:
+		tempTokens = append(tempTokens, models.TempToken{
 			UserID:     user.ID.Hex(),
 			InstanceID: req.Token.InstanceId,
 			Purpose:    constants.TOKEN_PURPOSE_RESTORE_ACCOUNT_ID,
 			Info: map[string]string{
-				"oldEmail": user.Account.AccountID,
-				"newEmail": req.NewEmail,
+				"oldEmail":       user.Account.AccountID,
+				"newEmail":       req.NewEmail,
+				"oldConfirmedAt": strconv.FormatInt(user.Account.AccountConfirmedAt, 10),
+				"newEmailAdded":  strconv.FormatBool(!newEmailKnown),
 			},
 			Expiration: tokens.GetExpirationTime(time.Hour * 24 * 7),
-		}
-		tempToken, err := s.globalDBService.AddTempToken(tempTokenInfos)
-		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
-		}
-
-		// ---> Trigger message sending
-		_, err = s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
-			InstanceId:        req.Token.InstanceId,
-			To:                []string{user.Account.AccountID},
-			MessageType:       constants.EMAIL_TYPE_ACCOUNT_ID_CHANGED,
-			PreferredLanguage: user.Account.PreferredLanguage,
-			ContentInfos: map[string]string{
-				"restoreToken": tempToken,
-				"validUntil":   strconv.Itoa(24 * 7 * 60),
-				"newEmail":     req.NewEmail,
-			},
-			UseLowPrio: true,
 		})
-		if err != nil {
-			logger.Error.Printf("ChangeAccountIDEmail: %s", err.Error())
-		}
-		// <---
 	}
 	// if old AccountID was not confirmed probably wrong address used in the first place
 	if user.Profiles[0].Alias == user.Account.AccountID {
@@ -192,9 +266,10 @@ func (s *userManagementServer) ChangeAccountIDEmail(ctx context.Context, req *ap
 	user.ReplaceContactInfoInContactPreferences(oldCI.ID.Hex(), newCI.ID.Hex())
 
 	// start confirmation workflow of necessary:
-	if user.Account.AccountConfirmedAt <= 0 {
+	newEmailNeedsVerification := user.Account.AccountConfirmedAt <= 0
+	if newEmailNeedsVerification {
 		// TempToken for contact verification:
-		tempTokenInfos := models.TempToken{
+		tempTokens = append(tempTokens, models.TempToken{
 			UserID:     user.ID.Hex(),
 			InstanceID: req.Token.InstanceId,
 			Purpose:    constants.TOKEN_PURPOSE_CONTACT_VERIFICATION,
@@ -203,49 +278,293 @@ func (s *userManagementServer) ChangeAccountIDEmail(ctx context.Context, req *ap
 				"email": user.Account.AccountID,
 			},
 			Expiration: tokens.GetExpirationTime(time.Hour * 24 * 30),
-		}
-		tempToken, err := s.globalDBService.AddTempToken(tempTokenInfos)
+		})
+	}
+
+	if !req.KeepOldEmail {
+		err := user.RemoveContactInfo(oldCI.ID.Hex())
 		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+			logger.Error.Println(err.Error())
 		}
+	}
+
+	// Save user together with the tokens:
+	updUser, createdTokens, err := s.saveUserWithTempTokens(ctx, req.Token.InstanceId, user, tempTokens)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
 
+	s.recordReleasedEmail(req.Token.InstanceId, oldEmail)
+
+	// Messages are only sent once the change is saved
+	if oldEmailConfirmed {
 		// ---> Trigger message sending
-		_, err = s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+		err = s.sendInstantEmail(ctx, &messageAPI.SendEmailReq{
 			InstanceId:        req.Token.InstanceId,
-			To:                []string{user.Account.AccountID},
-			MessageType:       constants.EMAIL_TYPE_VERIFY_EMAIL,
-			PreferredLanguage: user.Account.PreferredLanguage,
+			To:                []string{oldEmail},
+			MessageType:       constants.EMAIL_TYPE_ACCOUNT_ID_CHANGED,
+			PreferredLanguage: updUser.Account.PreferredLanguage,
 			ContentInfos: map[string]string{
-				"token": tempToken,
+				"restoreToken": createdTokens[0],
+				"validUntil":   strconv.Itoa(24 * 7 * 60),
+				"newEmail":     req.NewEmail,
 			},
+			UseLowPrio: true,
 		})
 		if err != nil {
 			logger.Error.Printf("ChangeAccountIDEmail: %s", err.Error())
 		}
 		// <---
 	}
+	if newEmailNeedsVerification {
+		// ---> Trigger message sending
+		s.sendEmailWithRetry(ctx, "ChangeAccountIDEmail", &messageAPI.SendEmailReq{
+			InstanceId:        req.Token.InstanceId,
+			To:                []string{updUser.Account.AccountID},
+			MessageType:       constants.EMAIL_TYPE_VERIFY_EMAIL,
+			PreferredLanguage: updUser.Account.PreferredLanguage,
+			ContentInfos: map[string]string{
+				"token": createdTokens[len(createdTokens)-1],
+			},
+		})
+		// <---
+	}
 
-	if !req.KeepOldEmail {
-		err := user.RemoveContactInfo(oldCI.ID.Hex())
-		if err != nil {
-			logger.Error.Println(err.Error())
+	s.SaveLogEvent(req.Token.InstanceId, updUser.ID.Hex(), loggingAPI.LogEventType_LOG, constants.LOG_EVENT_ACCOUNT_ID_CHANGED, updUser.Account.AccountID)
+	if disposableEmail {
+		s.flagDisposableEmail(req.Token.InstanceId, updUser.ID.Hex(), req.NewEmail)
+	}
+
+	return updUser.ToAPI(), nil
+}
+
+// ChangeAccountType switches the account between email and username type. newAccountID is the chosen username
+// (email to username) or one of the confirmed email addresses of the user (username to email).
+func (s *userManagementServer) ChangeAccountType(ctx context.Context, req *AccountTypeChangeMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.Password == "" || req.NewType == "" || req.NewAccountId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
+	}
+
+	req.NewAccountId = utils.SanitizeEmail(req.NewAccountId)
+	switch req.NewType {
+	case models.ACCOUNT_TYPE_USERNAME:
+		if !utils.CheckUsernameFormat(req.NewAccountId) {
+			return nil, errorWithCode(codes.InvalidArgument, "username not valid", models.ERROR_CODE_INVALID_USERNAME, fieldViolation("username", "3 to 32 lower case letters, digits, '.', '_' or '-'"))
 		}
+	case models.ACCOUNT_TYPE_EMAIL:
+		if !utils.CheckEmailFormat(req.NewAccountId) {
+			return nil, errorWithCode(codes.InvalidArgument, "email not valid", models.ERROR_CODE_INVALID_EMAIL)
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "account type not valid")
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
+	}
+	match, err := pwhash.ComparePasswordWithHash(user.Account.Password, req.Password)
+	if err != nil || !match {
+		s.SaveLogEvent(req.Token.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_AUTH_WRONG_PASSWORD, "change account type endpoint")
+		return nil, status.Error(codes.InvalidArgument, "action failed")
+	}
+
+	oldType := user.Account.Type
+	if req.NewType == models.ACCOUNT_TYPE_USERNAME {
+		err = user.SetUsernameAccount(req.NewAccountId)
+	} else {
+		err = user.SetEmailAccount(req.NewAccountId)
+	}
+	if err != nil {
+		if err == models.ErrEmailNotConfirmed {
+			return nil, errorWithCode(codes.FailedPrecondition, "email not confirmed", models.ERROR_CODE_EMAIL_NOT_CONFIRMED)
+		}
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	if _, err := s.userDBservice.GetUserByAccountID(ctx, req.Token.InstanceId, req.NewAccountId); err == nil {
+		return nil, errorWithCode(codes.AlreadyExists, "account id already in use", models.ERROR_CODE_ACCOUNT_ID_IN_USE)
+	}
+
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, updUser.ID.Hex(), loggingAPI.LogEventType_LOG, models.LOG_EVENT_ACCOUNT_TYPE_CHANGED, oldType+" -> "+req.NewType)
+	return updUser.ToAPI(), nil
+}
+
+func (s *userManagementServer) CancelEmailChange(ctx context.Context, req *api.UserReference) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	if req.UserId != "" && req.Token.Id != req.UserId {
+		logger.Warning.Printf("SECURITY WARNING: not authorized CancelEmailChange(): %s tried to access %s", req.Token.Id, req.UserId)
+		return nil, status.Error(codes.PermissionDenied, "not authorized")
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
 	}
 
-	// Save user:
-	updUser, err := s.userDBservice.UpdateUser(req.Token.InstanceId, user)
+	restoreTokens, err := s.globalDBService.GetTempTokenForUser(req.Token.InstanceId, user.ID.Hex(), constants.TOKEN_PURPOSE_RESTORE_ACCOUNT_ID)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	s.SaveLogEvent(req.Token.InstanceId, updUser.ID.Hex(), loggingAPI.LogEventType_LOG, constants.LOG_EVENT_ACCOUNT_ID_CHANGED, updUser.Account.AccountID)
+	var pending *models.TempToken
+	for i, t := range restoreTokens {
+		if t.Info["newEmail"] == user.Account.AccountID && !tokens.ReachedExpirationTime(t.Expiration) {
+			pending = &restoreTokens[i]
+		}
+	}
+	if pending == nil {
+		return nil, status.Error(codes.InvalidArgument, "no pending email change")
+	}
+
+	user, err = s.revertAccountIDChange(ctx, req.Token.InstanceId, user, pending.Info)
+	if err != nil {
+		logger.Warning.Printf("CancelEmailChange: %s", err.Error())
+		if errors.Is(err, errAccountIDRestoreFailed) {
+			return nil, status.Error(codes.Internal, "failed to restore account id")
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
+
+	if err := s.globalDBService.DeleteTempToken(pending.Token); err != nil {
+		logger.Error.Printf("CancelEmailChange: %s", err.Error())
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, updUser.ID.Hex(), loggingAPI.LogEventType_LOG, constants.LOG_EVENT_ACCOUNT_ID_CHANGED, "email change cancelled: "+updUser.Account.AccountID)
 
 	return updUser.ToAPI(), nil
 }
 
+func (s *userManagementServer) RestoreAccountID(ctx context.Context, req *api.TempToken) (*api.ServiceStatus, error) {
+	if req == nil || req.Token == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	tokenInfos, err := s.ValidateTempToken(req.Token, []string{constants.TOKEN_PURPOSE_RESTORE_ACCOUNT_ID})
+	if err != nil {
+		logger.Error.Printf("RestoreAccountID: %s", err.Error())
+		return nil, errorWithCode(codes.InvalidArgument, "wrong token", models.ERROR_CODE_INVALID_TOKEN)
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, tokenInfos.InstanceID, tokenInfos.UserID)
+	if err != nil {
+		logger.Error.Printf("RestoreAccountID: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, "no user found")
+	}
+
+	user, err = s.revertAccountIDChange(ctx, tokenInfos.InstanceID, user, tokenInfos.Info)
+	if err != nil {
+		logger.Warning.Printf("RestoreAccountID: %s", err.Error())
+		if errors.Is(err, errAccountIDRestoreFailed) {
+			return nil, status.Error(codes.Internal, "failed to restore account id")
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user, err = s.userDBservice.UpdateUser(ctx, tokenInfos.InstanceID, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
+
+	if err := s.globalDBService.DeleteAllTempTokenForUser(tokenInfos.InstanceID, tokenInfos.UserID, constants.TOKEN_PURPOSE_RESTORE_ACCOUNT_ID); err != nil {
+		logger.Error.Printf("RestoreAccountID: %s", err.Error())
+	}
+	// the change was not made by the account owner, so existing sessions should not be trusted anymore
+	if _, err := s.userDBservice.DeleteRenewTokensForUser(ctx, tokenInfos.InstanceID, tokenInfos.UserID); err != nil {
+		logger.Error.Printf("RestoreAccountID: %s", err.Error())
+	}
+
+	s.SaveLogEvent(tokenInfos.InstanceID, tokenInfos.UserID, loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_ACCOUNT_ID_CHANGED, "account id restored: "+user.Account.AccountID)
+
+	return &api.ServiceStatus{
+		Status:  api.ServiceStatus_NORMAL,
+		Msg:     "account id restored",
+		Version: apiVersion,
+	}, nil
+}
+
+// errAccountIDRestoreFailed is returned by revertAccountIDChange for unexpected failures, e.g. of the DB
+var errAccountIDRestoreFailed = errors.New("failed to restore account id")
+
+// revertAccountIDChange rolls back an account ID change using the infos stored in the restore account ID token
+func (s *userManagementServer) revertAccountIDChange(ctx context.Context, instanceID string, user models.User, info map[string]string) (models.User, error) {
+	oldEmail, ok1 := info["oldEmail"]
+	newEmail, ok2 := info["newEmail"]
+	if !ok1 || !ok2 || oldEmail == "" {
+		return user, errors.New("missing token info")
+	}
+	if user.Account.Type != models.ACCOUNT_TYPE_EMAIL || user.Account.AccountID != newEmail {
+		return user, errors.New("account id changed in the meantime")
+	}
+
+	otherUser, err := s.userDBservice.GetUserByAccountID(ctx, instanceID, oldEmail)
+	if err == nil && otherUser.ID != user.ID {
+		return user, errors.New("old email address already in use")
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return user, fmt.Errorf("%w: %v", errAccountIDRestoreFailed, err)
+	}
+	if len(user.Profiles) == 0 {
+		return user, fmt.Errorf("%w: user has no profile", errAccountIDRestoreFailed)
+	}
+
+	oldConfirmedAt, _ := strconv.ParseInt(info["oldConfirmedAt"], 10, 64)
+
+	newCI, newFound := user.FindContactInfoByTypeAndAddr("email", newEmail)
+	oldCI, oldFound := user.FindContactInfoByTypeAndAddr("email", oldEmail)
+	if !oldFound {
+		user.AddNewEmail(oldEmail, false)
+		user.ContactInfos[len(user.ContactInfos)-1].ConfirmedAt = oldConfirmedAt
+		oldCI = user.ContactInfos[len(user.ContactInfos)-1]
+	} else if oldConfirmedAt == 0 {
+		oldConfirmedAt = oldCI.ConfirmedAt
+	}
+
+	if user.Profiles[0].Alias == newEmail {
+		user.Profiles[0].Alias = oldEmail
+	}
+	user.Account.AccountID = oldEmail
+	user.Account.AccountConfirmedAt = oldConfirmedAt
+
+	if newFound {
+		user.ReplaceContactInfoInContactPreferences(newCI.ID.Hex(), oldCI.ID.Hex())
+		if info["newEmailAdded"] == "true" {
+			if err := user.RemoveContactInfo(newCI.ID.Hex()); err != nil {
+				logger.Error.Printf("revertAccountIDChange: %s", err.Error())
+			}
+		}
+	}
+	return user, nil
+}
+
 func (s *userManagementServer) DeleteAccount(ctx context.Context, req *api.UserReference) (*api.ServiceStatus, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.UserId == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
 	}
 
 	// TODO: check if user auth is from admin - to remove user by admin
@@ -255,25 +574,35 @@ func (s *userManagementServer) DeleteAccount(ctx context.Context, req *api.UserR
 	}
 	logger.Info.Printf("user %s initiated account removal for user id %s", req.Token.Id, req.UserId)
 
-	user, err := s.userDBservice.GetUserByID(req.Token.InstanceId, req.UserId)
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.UserId)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, userLookupError(err)
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
+	}
+	if err := s.requireRecentAuth(user); err != nil {
+		return nil, err
 	}
 
 	// ---> Trigger message sending
-	_, err = s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+	s.sendEmailWithRetry(ctx, "DeleteAccount", &messageAPI.SendEmailReq{
 		InstanceId:        req.Token.InstanceId,
-		To:                []string{user.Account.AccountID},
+		To:                []string{user.NotificationEmail()},
 		MessageType:       constants.EMAIL_TYPE_ACCOUNT_DELETED,
 		PreferredLanguage: user.Account.PreferredLanguage,
 		UseLowPrio:        true,
 	})
-	if err != nil {
-		logger.Error.Printf("DeleteAccount: %s", err.Error())
-	}
 	// <---
 
-	if err := s.userDBservice.DeleteUser(req.Token.InstanceId, req.UserId); err != nil {
+	if s.anonymizeDeletedAccounts[req.Token.InstanceId] {
+		if _, err := s.userDBservice.AnonymizeUser(ctx, req.Token.InstanceId, req.UserId); err != nil {
+			return nil, userUpdateError(err, err.Error())
+		}
+		if _, err := s.userDBservice.DeleteRenewTokensForUser(ctx, req.Token.InstanceId, req.UserId); err != nil {
+			logger.Error.Printf("error, when trying to remove renew tokens: %s", err.Error())
+		}
+	} else if err := s.userDBservice.DeleteUser(ctx, req.Token.InstanceId, req.UserId); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
@@ -282,7 +611,12 @@ func (s *userManagementServer) DeleteAccount(ctx context.Context, req *api.UserR
 		logger.Error.Printf("error, when trying to remove temp-tokens: %s", err.Error())
 	}
 
+	if user.Account.Type == models.ACCOUNT_TYPE_EMAIL {
+		s.recordReleasedEmail(req.Token.InstanceId, user.Account.AccountID)
+	}
+
 	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_ACCOUNT_DELETED, user.Account.AccountID)
+	s.notifyWebhook(models.WEBHOOK_EVENT_ACCOUNT_DELETED, req.Token.InstanceId, req.UserId)
 
 	logger.Info.Printf("user account with id %s successfully removed", req.UserId)
 	return &api.ServiceStatus{
@@ -293,9 +627,9 @@ func (s *userManagementServer) DeleteAccount(ctx context.Context, req *api.UserR
 
 func (s *userManagementServer) ChangePreferredLanguage(ctx context.Context, req *api.LanguageChangeMsg) (*api.User, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.LanguageCode == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
-	user, err := s.userDBservice.UpdateAccountPreferredLang(req.Token.InstanceId, req.Token.Id, req.LanguageCode)
+	user, err := s.userDBservice.UpdateAccountPreferredLang(ctx, req.Token.InstanceId, req.Token.Id, req.LanguageCode)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -304,12 +638,15 @@ func (s *userManagementServer) ChangePreferredLanguage(ctx context.Context, req
 
 func (s *userManagementServer) SaveProfile(ctx context.Context, req *api.ProfileRequest) (*api.User, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.Profile == nil {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 
-	user, err := s.userDBservice.GetUserByID(req.Token.InstanceId, req.Token.Id)
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "user not found")
+		return nil, userLookupError(err)
+	}
+	if err := s.requirePolicyAcceptance(req.Token.InstanceId, user); err != nil {
+		return nil, err
 	}
 
 	if req.Profile.Id == "" {
@@ -324,10 +661,18 @@ func (s *userManagementServer) SaveProfile(ctx context.Context, req *api.Profile
 			return nil, status.Error(codes.Internal, "profile not found")
 		}
 	}
+	// the saved profile is the added one or the one with the fields kept by UpdateProfile
+	saved := user.Profiles[len(user.Profiles)-1]
+	if req.Profile.Id != "" {
+		saved, _ = user.FindProfile(req.Profile.Id)
+	}
+	if err := saved.Validate(user.ContactPreferences.InTimezone(time.Now())); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
-	updUser, err := s.userDBservice.UpdateUser(req.Token.InstanceId, user)
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, userUpdateError(err, err.Error())
 	}
 
 	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_PROFILE_SAVED, req.Profile.Alias)
@@ -337,12 +682,12 @@ func (s *userManagementServer) SaveProfile(ctx context.Context, req *api.Profile
 
 func (s *userManagementServer) RemoveProfile(ctx context.Context, req *api.ProfileRequest) (*api.User, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.Profile == nil {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 
-	user, err := s.userDBservice.GetUserByID(req.Token.InstanceId, req.Token.Id)
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "user not found")
+		return nil, userLookupError(err)
 	}
 
 	if len(user.Profiles) == 1 {
@@ -352,21 +697,423 @@ func (s *userManagementServer) RemoveProfile(ctx context.Context, req *api.Profi
 	if err := user.RemoveProfile(req.Profile.Id); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	updUser, err := s.userDBservice.UpdateUser(req.Token.InstanceId, user)
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, userUpdateError(err, err.Error())
 	}
 
 	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_PROFILE_REMOVED, "id: "+req.Profile.Id)
 	return updUser.ToAPI(), nil
 }
 
+// ReorderProfiles changes the order of the user's profiles. profileIDs must list all profiles of the user,
+// the first one becomes the main profile.
+func (s *userManagementServer) ReorderProfiles(ctx context.Context, req *ReorderProfilesMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || len(req.ProfileIds) < 1 {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+
+	mainProfileID := ""
+	for _, p := range user.Profiles {
+		if p.MainProfile {
+			mainProfileID = p.ID.Hex()
+		}
+	}
+	if err := user.ReorderProfiles(req.ProfileIds); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the new main profile is the account holder, see models.InstanceConfig.MinimumAge
+	if user.Profiles[0].ID.Hex() != mainProfileID {
+		if err := s.checkMinimumAge(req.Token.InstanceId, user.Profiles[0].Birthdate, user.ContactPreferences.InTimezone(time.Now())); err != nil {
+			return nil, err
+		}
+	}
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
+	return updUser.ToAPI(), nil
+}
+
+// SetProfileAvatarURL sets a custom avatar for the profile, an empty avatarURL removes it
+func (s *userManagementServer) SetProfileAvatarURL(ctx context.Context, req *ProfileAvatarMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.ProfileId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if req.AvatarUrl != "" {
+		u, err := url.Parse(req.AvatarUrl)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, status.Error(codes.InvalidArgument, "avatar url not valid")
+		}
+	}
+
+	return s.updateProfile(ctx, req.Token, req.ProfileId, func(p *models.Profile) {
+		p.AvatarURL = req.AvatarUrl
+	})
+}
+
+// SetProfileConsent records that the consent with the given key and version was confirmed for the profile
+func (s *userManagementServer) SetProfileConsent(ctx context.Context, req *ProfileConsentMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.ProfileId == "" || req.ConsentKey == "" || req.Version == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	return s.updateProfile(ctx, req.Token, req.ProfileId, func(p *models.Profile) {
+		p.SetConsent(req.ConsentKey, req.Version, time.Now().Unix())
+	})
+}
+
+// LinkChildProfile marks the profile childProfileID as a child profile managed by guardianProfileID, e.g. for family
+// studies. The token of a child profile can't change the account email, delete the account or change the links.
+func (s *userManagementServer) LinkChildProfile(ctx context.Context, req *ProfileLinkMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.ChildProfileId == "" || req.GuardianProfileId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
+	}
+	if err := user.LinkChildProfile(req.ChildProfileId, req.GuardianProfileId); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, models.LOG_EVENT_CHILD_PROFILE_LINKED, "id: "+req.ChildProfileId+", guardian: "+req.GuardianProfileId)
+	return updUser.ToAPI(), nil
+}
+
+// UnlinkChildProfile removes the guardian link of a child profile, which then has the same rights as other profiles
+func (s *userManagementServer) UnlinkChildProfile(ctx context.Context, req *ProfileLinkMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.ChildProfileId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
+	}
+	if err := user.UnlinkChildProfile(req.ChildProfileId); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, models.LOG_EVENT_CHILD_PROFILE_UNLINKED, "id: "+req.ChildProfileId)
+	return updUser.ToAPI(), nil
+}
+
+// AcceptPolicy records that the user accepted the version of the policy with the given key (e.g. terms of service).
+// Only the current version set in the instance settings can be accepted.
+func (s *userManagementServer) AcceptPolicy(ctx context.Context, req *PolicyAcceptanceMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.PolicyKey == "" || req.Version == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
+	}
+	currentVersion, ok := s.getInstanceConfig(req.Token.InstanceId).Policies.CurrentVersions[req.PolicyKey]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown policy")
+	}
+	if req.Version != currentVersion {
+		return nil, status.Error(codes.InvalidArgument, "not the current policy version")
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	user.AcceptPolicy(req.PolicyKey, req.Version, time.Now().Unix())
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, models.LOG_EVENT_POLICY_ACCEPTED, req.PolicyKey+": "+req.Version)
+	return updUser.ToAPI(), nil
+}
+
+// SetProfileBirthdate sets the birthdate of the profile, in the format of models.BIRTHDATE_LAYOUT, an empty birthdate
+// removes it. The date must not be in the future for the user's timezone. The minimum age of the instance applies to all
+// profiles except the child profiles linked to a guardian.
+func (s *userManagementServer) SetProfileBirthdate(ctx context.Context, req *ProfileBirthdateMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.ProfileId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	today := user.ContactPreferences.InTimezone(time.Now())
+	if req.Birthdate != "" {
+		if err := models.ValidateBirthdate(req.Birthdate, today); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if _, err := user.FindProfile(req.ProfileId); err == nil && !user.IsChildProfile(req.ProfileId) {
+		if err := s.checkMinimumAge(req.Token.InstanceId, req.Birthdate, today); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.updateProfile(ctx, req.Token, req.ProfileId, func(p *models.Profile) {
+		p.Birthdate = req.Birthdate
+	})
+}
+
+// SetProfilePreferredLanguage sets the language used for messages concerning the profile, an empty
+// languageCode falls back to the account language
+func (s *userManagementServer) SetProfilePreferredLanguage(ctx context.Context, req *ProfileLanguageMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.ProfileId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if req.LanguageCode != "" && !utils.CheckLanguageCode(req.LanguageCode) {
+		return nil, errorWithCode(codes.InvalidArgument, "language code wrong", models.ERROR_CODE_INVALID_LANGUAGE_CODE)
+	}
+
+	return s.updateProfile(ctx, req.Token, req.ProfileId, func(p *models.Profile) {
+		p.PreferredLanguage = req.LanguageCode
+	})
+}
+
+// UndoProfileChange restores the profile as it was before its last change, if the change is not older than
+// profileUndoWindow. Only one change can be undone.
+func (s *userManagementServer) UndoProfileChange(ctx context.Context, req *ProfileReference) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.ProfileId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	profile, err := user.FindProfile(req.ProfileId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "profile not found")
+	}
+	if profile.PreviousVersion == nil || profile.PreviousVersion.ReplacedAt < time.Now().Unix()-profileUndoWindow {
+		return nil, status.Error(codes.FailedPrecondition, "no profile change to undo")
+	}
+	if err := user.RestorePreviousProfileVersion(req.ProfileId); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_PROFILE_SAVED, "undo: "+req.ProfileId)
+	return updUser.ToAPI(), nil
+}
+
+func (s *userManagementServer) updateProfile(ctx context.Context, token *api_types.TokenInfos, profileID string, update func(p *models.Profile)) (*api.User, error) {
+	user, err := s.userDBservice.GetUserByID(ctx, token.InstanceId, token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	found := false
+	for i := range user.Profiles {
+		if user.Profiles[i].ID.Hex() == profileID {
+			previous := user.Profiles[i]
+			update(&user.Profiles[i])
+			user.Profiles[i].KeepPreviousVersion(previous, time.Now().Unix())
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, status.Error(codes.InvalidArgument, "profile not found")
+	}
+
+	updUser, err := s.userDBservice.UpdateUser(ctx, token.InstanceId, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
+	return updUser.ToAPI(), nil
+}
+
 func (s *userManagementServer) UpdateContactPreferences(ctx context.Context, req *api.ContactPreferencesMsg) (*api.User, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.ContactPreferences == nil {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 
-	user, err := s.userDBservice.UpdateContactPreferences(req.Token.InstanceId, req.Token.Id, models.ContactPreferencesFromAPI(req.ContactPreferences))
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+
+	prefs := models.ContactPreferencesFromAPI(req.ContactPreferences)
+	// not part of the api message yet, set with SetTimezone, SetQuietHours and UpdateTopicSubscription
+	prefs.Timezone = user.ContactPreferences.Timezone
+	prefs.QuietHours = user.ContactPreferences.QuietHours
+	prefs.SubscribedTopics = user.ContactPreferences.SubscribedTopics
+	prefs.SetTopicSubscription(models.TOPIC_NEWSLETTER, prefs.SubscribedToNewsletter)
+	s.requestNewsletterConfirmation(ctx, req.Token.InstanceId, user, &prefs)
+	user, err = s.userDBservice.UpdateContactPreferences(ctx, req.Token.InstanceId, req.Token.Id, prefs)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return user.ToAPI(), nil
+}
+
+// requestNewsletterConfirmation keeps a new newsletter subscription pending and sends a confirmation link, if the instance requires double opt-in
+func (s *userManagementServer) requestNewsletterConfirmation(ctx context.Context, instanceID string, user models.User, prefs *models.ContactPreferences) {
+	if !prefs.IsSubscribedToTopic(models.TOPIC_NEWSLETTER) || user.ContactPreferences.IsSubscribedToTopic(models.TOPIC_NEWSLETTER) {
+		return
+	}
+	if !s.getInstanceConfig(instanceID).NewsletterDoubleOptIn {
+		return
+	}
+	prefs.SetTopicSubscription(models.TOPIC_NEWSLETTER, false)
+
+	// only the latest link can be used
+	if err := s.globalDBService.DeleteAllTempTokenForUser(instanceID, user.ID.Hex(), models.TOKEN_PURPOSE_NEWSLETTER_CONFIRMATION); err != nil {
+		logger.Error.Printf("requestNewsletterConfirmation: %v", err)
+	}
+	tempToken, err := s.globalDBService.AddTempToken(models.TempToken{
+		UserID:     user.ID.Hex(),
+		InstanceID: instanceID,
+		Purpose:    models.TOKEN_PURPOSE_NEWSLETTER_CONFIRMATION,
+		Expiration: tokens.GetExpirationTime(newsletterConfirmationTokenLifetime),
+	})
+	if err != nil {
+		logger.Error.Printf("requestNewsletterConfirmation: %v", err)
+		return
+	}
+
+	err = s.sendInstantEmail(ctx, &messageAPI.SendEmailReq{
+		InstanceId:  instanceID,
+		To:          []string{user.NotificationEmail()},
+		MessageType: models.EMAIL_TYPE_NEWSLETTER_CONFIRMATION,
+		ContentInfos: map[string]string{
+			"token": tempToken,
+		},
+		PreferredLanguage: user.Account.PreferredLanguage,
+	})
+	if err != nil {
+		logger.Error.Printf("requestNewsletterConfirmation: %v", err)
+	}
+}
+
+// ConfirmNewsletterSubscription activates the newsletter subscription with the token sent for the double opt-in
+func (s *userManagementServer) ConfirmNewsletterSubscription(ctx context.Context, req *api.TempToken) (*api.ServiceStatus, error) {
+	if req == nil || req.Token == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	tokenInfos, err := s.ValidateTempToken(req.Token, []string{models.TOKEN_PURPOSE_NEWSLETTER_CONFIRMATION})
+	if err != nil {
+		logger.Error.Printf("ConfirmNewsletterSubscription: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, tokenInfos.InstanceID, tokenInfos.UserID)
+	if err != nil {
+		logger.Error.Printf("ConfirmNewsletterSubscription: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user.ContactPreferences.SetTopicSubscription(models.TOPIC_NEWSLETTER, true)
+	_, err = s.userDBservice.UpdateContactPreferences(ctx, tokenInfos.InstanceID, user.ID.Hex(), user.ContactPreferences)
+	if err != nil {
+		logger.Error.Printf("ConfirmNewsletterSubscription: %s", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.globalDBService.DeleteTempToken(req.Token); err != nil {
+		logger.Error.Printf("ConfirmNewsletterSubscription: %s", err.Error())
+	}
+	return &api.ServiceStatus{
+		Status: api.ServiceStatus_NORMAL,
+		Msg:    "newsletter subscription confirmed",
+	}, nil
+}
+
+// SetTimezone sets the timezone (IANA name, empty to use the server timezone) used to select the weekday of the weekly reminder
+func (s *userManagementServer) SetTimezone(ctx context.Context, req *TimezoneMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "unknown timezone")
+		}
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+
+	prefs := user.ContactPreferences
+	prefs.Timezone = req.Timezone
+	user, err = s.userDBservice.UpdateContactPreferences(ctx, req.Token.InstanceId, req.Token.Id, prefs)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return user.ToAPI(), nil
+}
+
+// SetQuietHours sets the daily period during which reminders are not sent to the user, nil to remove it
+func (s *userManagementServer) SetQuietHours(ctx context.Context, req *QuietHoursMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if req.QuietHours != nil {
+		if err := req.QuietHours.Validate(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+
+	prefs := user.ContactPreferences
+	prefs.QuietHours = req.QuietHours
+	user, err = s.userDBservice.UpdateContactPreferences(ctx, req.Token.InstanceId, req.Token.Id, prefs)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return user.ToAPI(), nil
+}
+
+// UpdateTopicSubscription subscribes the user to or unsubscribes from a single message topic
+func (s *userManagementServer) UpdateTopicSubscription(ctx context.Context, req *TopicSubscriptionMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.Topic == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if len(req.Topic) > maxTopicLength || strings.ContainsAny(req.Topic, ".$") {
+		return nil, status.Error(codes.InvalidArgument, "invalid topic")
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+
+	prefs := user.ContactPreferences
+	prefs.SetTopicSubscription(req.Topic, req.Subscribed)
+	s.requestNewsletterConfirmation(ctx, req.Token.InstanceId, user, &prefs)
+	user, err = s.userDBservice.UpdateContactPreferences(ctx, req.Token.InstanceId, req.Token.Id, prefs)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -375,7 +1122,7 @@ func (s *userManagementServer) UpdateContactPreferences(ctx context.Context, req
 
 func (s *userManagementServer) UseUnsubscribeToken(ctx context.Context, req *api.TempToken) (*api.ServiceStatus, error) {
 	if req == nil || req.Token == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 	tokenInfos, err := s.ValidateTempToken(req.Token, []string{constants.TOKEN_PURPOSE_UNSUBSCRIBE_NEWSLETTER})
 	if err != nil {
@@ -383,15 +1130,20 @@ func (s *userManagementServer) UseUnsubscribeToken(ctx context.Context, req *api
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	user, err := s.userDBservice.GetUserByID(tokenInfos.InstanceID, tokenInfos.UserID)
+	user, err := s.userDBservice.GetUserByID(ctx, tokenInfos.InstanceID, tokenInfos.UserID)
 	if err != nil {
 		logger.Error.Printf("UseUnsubscribeToken: %s", err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	user.ContactPreferences.SubscribedToNewsletter = false
+	// tokens without topic are for the newsletter
+	topic := tokenInfos.Info["topic"]
+	if topic == "" {
+		topic = models.TOPIC_NEWSLETTER
+	}
+	user.ContactPreferences.SetTopicSubscription(topic, false)
 
-	_, err = s.userDBservice.UpdateContactPreferences(tokenInfos.InstanceID, user.ID.Hex(), user.ContactPreferences)
+	_, err = s.userDBservice.UpdateContactPreferences(ctx, tokenInfos.InstanceID, user.ID.Hex(), user.ContactPreferences)
 	if err != nil {
 		logger.Error.Printf("UseUnsubscribeToken: %s", err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
@@ -402,9 +1154,42 @@ func (s *userManagementServer) UseUnsubscribeToken(ctx context.Context, req *api
 	}, nil
 }
 
+// UseUnsubscribeAllToken removes all subscriptions of the user, for a global opt-out link. Transactional emails are still sent.
+func (s *userManagementServer) UseUnsubscribeAllToken(ctx context.Context, req *api.TempToken) (*api.ServiceStatus, error) {
+	if req == nil || req.Token == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	tokenInfos, err := s.ValidateTempToken(req.Token, []string{models.TOKEN_PURPOSE_UNSUBSCRIBE_ALL})
+	if err != nil {
+		logger.Error.Printf("UseUnsubscribeAllToken: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, tokenInfos.InstanceID, tokenInfos.UserID)
+	if err != nil {
+		logger.Error.Printf("UseUnsubscribeAllToken: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user.ContactPreferences.UnsubscribeFromAll()
+
+	_, err = s.userDBservice.UpdateContactPreferences(ctx, tokenInfos.InstanceID, user.ID.Hex(), user.ContactPreferences)
+	if err != nil {
+		logger.Error.Printf("UseUnsubscribeAllToken: %s", err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &api.ServiceStatus{
+		Status: api.ServiceStatus_NORMAL,
+		Msg:    "unsubscribed from all",
+	}, nil
+}
+
 func (s *userManagementServer) AddEmail(ctx context.Context, req *api.ContactInfoMsg) (*api.User, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.ContactInfo == nil {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
 	}
 
 	if req.ContactInfo.Type != "email" {
@@ -413,12 +1198,29 @@ func (s *userManagementServer) AddEmail(ctx context.Context, req *api.ContactInf
 
 	email := utils.SanitizeEmail(req.ContactInfo.GetEmail())
 	if !utils.CheckEmailFormat(email) {
-		return nil, status.Error(codes.InvalidArgument, "email not valid")
+		return nil, errorWithCode(codes.InvalidArgument, "email not valid", models.ERROR_CODE_INVALID_EMAIL)
+	}
+	if !s.checkEmailDomainPolicy(req.Token.InstanceId, email) {
+		return nil, errorWithCode(codes.InvalidArgument, "email domain not allowed", models.ERROR_CODE_EMAIL_DOMAIN_NOT_ALLOWED)
+	}
+	disposableEmail := s.isDisposableEmail(email)
+	if disposableEmail && s.rejectDisposableEmails() {
+		return nil, errorWithCode(codes.InvalidArgument, "disposable email not allowed", models.ERROR_CODE_DISPOSABLE_EMAIL_NOT_ALLOWED)
 	}
 
-	user, err := s.userDBservice.GetUserByID(req.Token.InstanceId, req.Token.Id)
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "user not found")
+		return nil, userLookupError(err)
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
+	}
+	if err := s.requirePolicyAcceptance(req.Token.InstanceId, user); err != nil {
+		return nil, err
+	}
+	// the user may add back an own address they confirmed
+	if knownCI, found := user.FindContactInfoByTypeAndAddr("email", email); !(found && knownCI.ConfirmedAt > 0) && s.isEmailReleasedRecently(req.Token.InstanceId, email) {
+		return nil, errorWithCode(codes.InvalidArgument, emailRecentlyReleasedMsg, models.ERROR_CODE_EMAIL_RECENTLY_RELEASED)
 	}
 
 	user.AddNewEmail(email, false)
@@ -441,7 +1243,7 @@ func (s *userManagementServer) AddEmail(ctx context.Context, req *api.ContactInf
 	}
 
 	// ---> Trigger message sending
-	_, err = s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+	s.sendEmailWithRetry(ctx, "AddEmail", &messageAPI.SendEmailReq{
 		InstanceId:  req.Token.InstanceId,
 		To:          []string{user.Account.AccountID},
 		MessageType: constants.EMAIL_TYPE_VERIFY_EMAIL,
@@ -450,14 +1252,15 @@ func (s *userManagementServer) AddEmail(ctx context.Context, req *api.ContactInf
 		},
 		PreferredLanguage: user.Account.PreferredLanguage,
 	})
-	if err != nil {
-		logger.Error.Printf("AddEmail: %s", err.Error())
-	}
 	// <---
+	user.SetContactInfoVerificationSent("email", email)
 
-	updUser, err := s.userDBservice.UpdateUser(req.Token.InstanceId, user)
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, userUpdateError(err, err.Error())
+	}
+	if disposableEmail {
+		s.flagDisposableEmail(req.Token.InstanceId, req.Token.Id, email)
 	}
 
 	return updUser.ToAPI(), nil
@@ -465,20 +1268,51 @@ func (s *userManagementServer) AddEmail(ctx context.Context, req *api.ContactInf
 
 func (s *userManagementServer) RemoveEmail(ctx context.Context, req *api.ContactInfoMsg) (*api.User, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.ContactInfo == nil {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
-	user, err := s.userDBservice.GetUserByID(req.Token.InstanceId, req.Token.Id)
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
+	}
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "user not found")
+		return nil, userLookupError(err)
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
 	}
 
 	err = user.RemoveContactInfo(req.ContactInfo.Id)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	updUser, err := s.userDBservice.UpdateUser(req.Token.InstanceId, user)
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, userUpdateError(err, err.Error())
+	}
+	return updUser.ToAPI(), nil
+}
+
+func (s *userManagementServer) SetPrimaryEmail(ctx context.Context, req *api.ContactInfoMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.ContactInfo == nil || req.ContactInfo.Id == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
+	}
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
+	}
+
+	if err := user.SetPrimaryEmail(req.ContactInfo.Id); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	updUser, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
 	}
 	return updUser.ToAPI(), nil
 }
@@ -2,21 +2,35 @@ package service
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	"github.com/influenzanet/go-utils/pkg/constants"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
 	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/dbs/globaldb"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/pwhash"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"github.com/influenzanet/user-management-service/pkg/utils"
 	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
 	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 func TestGetUserEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
 		globalDBService: testGlobalDBService,
@@ -24,6 +38,9 @@ func TestGetUserEndpoint(t *testing.T) {
 			TokenExpiryInterval:      time.Second * 2,
 			VerificationCodeLifetime: 60,
 		},
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
 	}
 
 	testUsers, err := addTestUsers([]models.User{
@@ -82,11 +99,47 @@ func TestGetUserEndpoint(t *testing.T) {
 			t.Errorf("or response: %s", resp)
 			return
 		}
-		if status.Convert(err).Message() != "not found" {
+		if status.Code(err) != codes.InvalidArgument || status.Convert(err).Message() != "invalid user id" {
 			t.Errorf("wrong error: %s", err.Error())
 		}
 	})
 
+	t.Run("with unknown user id", func(t *testing.T) {
+		unknownID := primitive.NewObjectID().Hex()
+		req := &api.UserReference{
+			Token: &api_types.TokenInfos{
+				Id:         unknownID,
+				InstanceId: testInstanceID,
+			},
+			UserId: unknownID,
+		}
+
+		_, err := s.GetUser(context.Background(), req)
+		if status.Code(err) != codes.NotFound || status.Convert(err).Message() != "user not found" {
+			t.Errorf("wrong error: %v", err)
+		}
+		if ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_USER_NOT_FOUND); !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with failing db", func(t *testing.T) {
+		req := &api.UserReference{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[0].ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+			UserId: testUsers[0].ID.Hex(),
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := s.GetUser(ctx, req)
+		if status.Code(err) != codes.Internal || status.Convert(err).Message() != "failed to read user" {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+
 	t.Run("with other user id", func(t *testing.T) {
 		req := &api.UserReference{
 			Token: &api_types.TokenInfos{
@@ -124,6 +177,160 @@ func TestGetUserEndpoint(t *testing.T) {
 			t.Errorf("wrong response: %s", resp)
 		}
 	})
+
+	t.Run("as admin with other user id", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *loggingAPI.NewLogEvent, opts ...grpc.CallOption) (*api_types.ServiceStatus, error) {
+			if req.EventName != models.LOG_EVENT_USER_DATA_ACCESSED || req.UserId != testUsers[0].ID.Hex() || req.Msg != "GetUser: "+testUsers[1].ID.Hex() {
+				t.Errorf("unexpected log event: %v", req)
+			}
+			return nil, nil
+		})
+
+		req := &api.UserReference{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[0].ID.Hex(),
+				InstanceId: testInstanceID,
+				Payload:    map[string]string{"roles": "PARTICIPANT,ADMIN"},
+			},
+			UserId: testUsers[1].ID.Hex(),
+		}
+
+		resp, err := s.GetUser(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if testUsers[1].Account.AccountID != resp.Account.AccountId || resp.Id != testUsers[1].ID.Hex() {
+			t.Errorf("wrong response: %s", resp)
+		}
+	})
+
+	t.Run("as researcher with other user id", func(t *testing.T) {
+		req := &api.UserReference{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[0].ID.Hex(),
+				InstanceId: testInstanceID,
+				Payload:    map[string]string{"roles": "PARTICIPANT,RESEARCHER"},
+			},
+			UserId: testUsers[1].ID.Hex(),
+		}
+
+		_, err := s.GetUser(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "not authorized")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
+
+func TestGetAccountStatusEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:               "email",
+				AccountID:          "account_status_1@test.com",
+				AccountConfirmedAt: time.Now().Unix() - 10,
+			},
+			Timestamps: models.Timestamps{
+				ReminderToConfirmSentAt: time.Now().Unix() - 20,
+			},
+		},
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "account_status_2@test.com",
+			},
+			ContactInfos: []models.ContactInfo{
+				{
+					Type:                   "email",
+					Email:                  "account_status_2@test.com",
+					ConfirmationLinkSentAt: time.Now().Unix() - 30,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	tokenFor := func(u models.User) *api_types.TokenInfos {
+		return &api_types.TokenInfos{
+			Id:         u.ID.Hex(),
+			InstanceId: testInstanceID,
+		}
+	}
+
+	t.Run("without token", func(t *testing.T) {
+		_, err := s.GetAccountStatus(context.Background(), &api.UserReference{})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("for other user", func(t *testing.T) {
+		_, err := s.GetAccountStatus(context.Background(), &api.UserReference{Token: tokenFor(testUsers[0]), UserId: testUsers[1].ID.Hex()})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "not authorized")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("confirmed account", func(t *testing.T) {
+		accountStatus, err := s.GetAccountStatus(context.Background(), &api.UserReference{Token: tokenFor(testUsers[0])})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if !accountStatus.AccountConfirmed || accountStatus.AccountConfirmedAt != testUsers[0].Account.AccountConfirmedAt ||
+			accountStatus.VerificationPending || accountStatus.VerificationEmailSentAt != testUsers[0].Timestamps.ReminderToConfirmSentAt {
+			t.Errorf("unexpected status: %v", accountStatus)
+		}
+	})
+
+	t.Run("unconfirmed account without pending verification", func(t *testing.T) {
+		accountStatus, err := s.GetAccountStatus(context.Background(), &api.UserReference{Token: tokenFor(testUsers[1]), UserId: testUsers[1].ID.Hex()})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if accountStatus.AccountConfirmed || accountStatus.VerificationPending || accountStatus.VerificationEmailSentAt != testUsers[1].ContactInfos[0].ConfirmationLinkSentAt {
+			t.Errorf("unexpected status: %v", accountStatus)
+		}
+	})
+
+	t.Run("unconfirmed account with pending verification", func(t *testing.T) {
+		_, err := testGlobalDBService.AddTempToken(models.TempToken{
+			UserID:     testUsers[1].ID.Hex(),
+			InstanceID: testInstanceID,
+			Purpose:    constants.TOKEN_PURPOSE_CONTACT_VERIFICATION,
+			Info: map[string]string{
+				"type":  "email",
+				"email": "account_status_2@test.com",
+			},
+			Expiration: time.Now().Add(time.Hour).Unix(),
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		accountStatus, err := s.GetAccountStatus(context.Background(), &api.UserReference{Token: tokenFor(testUsers[1])})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if accountStatus.AccountConfirmed || !accountStatus.VerificationPending {
+			t.Errorf("unexpected status: %v", accountStatus)
+		}
+	})
 }
 
 func TestChangePasswordEndpoint(t *testing.T) {
@@ -135,7 +342,6 @@ func TestChangePasswordEndpoint(t *testing.T) {
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
 		globalDBService: testGlobalDBService,
-		instanceIDs:     []string{testInstanceID},
 		Intervals: models.Intervals{
 			TokenExpiryInterval:      time.Second * 2,
 			VerificationCodeLifetime: 60,
@@ -164,7 +370,7 @@ func TestChangePasswordEndpoint(t *testing.T) {
 		},
 	}
 
-	id, err := testUserDBService.AddUser(testInstanceID, testUser)
+	id, err := testUserDBService.AddUser(context.Background(), testInstanceID, testUser)
 	if err != nil {
 		t.Errorf("error creating users for testing pw change")
 		return
@@ -210,6 +416,12 @@ func TestChangePasswordEndpoint(t *testing.T) {
 			t.Errorf("wrong error: %s", err.Error())
 			t.Errorf("or response: %s", resp)
 		}
+		if ok, msg := shouldHaveFieldViolation(err, "old_password"); !ok {
+			t.Error(msg)
+		}
+		if ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_INVALID_CREDENTIALS); !ok {
+			t.Error(msg)
+		}
 	})
 
 	t.Run("with wrong old password", func(t *testing.T) {
@@ -233,6 +445,12 @@ func TestChangePasswordEndpoint(t *testing.T) {
 			t.Errorf("wrong error: %s", err.Error())
 			t.Errorf("or response: %s", resp)
 		}
+		if ok, msg := shouldHaveFieldViolation(err, "old_password"); !ok {
+			t.Error(msg)
+		}
+		if ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_INVALID_CREDENTIALS); !ok {
+			t.Error(msg)
+		}
 	})
 
 	t.Run("with too weak new password", func(t *testing.T) {
@@ -251,6 +469,12 @@ func TestChangePasswordEndpoint(t *testing.T) {
 			t.Errorf("wrong error: %s", st.Message())
 			t.Errorf("or response: %s", resp)
 		}
+		if ok, msg := shouldHaveFieldViolation(err, "new_password"); !ok {
+			t.Error(msg)
+		}
+		if ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_PASSWORD_TOO_WEAK); !ok {
+			t.Error(msg)
+		}
 	})
 
 	t.Run("with valid data and new password", func(t *testing.T) {
@@ -298,6 +522,44 @@ func TestChangePasswordEndpoint(t *testing.T) {
 			return
 		}
 	})
+
+	t.Run("with messaging service unavailable", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, errors.New("messaging service unavailable"))
+
+		req := &api.PasswordChangeMsg{
+			Token: &api_types.TokenInfos{
+				Id:         id,
+				InstanceId: testInstanceID,
+			},
+			OldPassword: newPassword,
+			NewPassword: oldPassword,
+		}
+
+		resp, err := s.ChangePassword(context.Background(), req)
+		if err != nil || resp == nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+
+		now := time.Now().Unix()
+		queued, err := testGlobalDBService.ClaimDueEmailRetry(now+1, now+3600)
+		if err != nil {
+			t.Errorf("email not queued for retry: %v", err)
+			return
+		}
+		defer testGlobalDBService.DeleteEmailRetry(queued.ID)
+		if queued.MessageType != constants.EMAIL_TYPE_PASSWORD_CHANGED || len(queued.To) != 1 || queued.To[0] != testUser.Account.AccountID || queued.Attempts != 1 {
+			t.Errorf("unexpected queued email: %v", queued)
+		}
+	})
 }
 
 func TestChangeAccountIDEmailEndpoint(t *testing.T) {
@@ -563,39 +825,111 @@ func TestChangeAccountIDEmailEndpoint(t *testing.T) {
 			return
 		}
 	})
+
+	t.Run("with the global DB sharing the client of the user DB", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		// in a transaction if the test DB supports it
+		sharedClient := userManagementServer{
+			userDBservice: testUserDBService,
+			globalDBService: globaldb.NewGlobalDBServiceWithClient(testUserDBService.DBClient, models.DBConfig{
+				Timeout:      int(testUserDBService.GetTimeout().Seconds()),
+				DBNamePrefix: testDBNamePrefix,
+			}),
+			Intervals: s.Intervals,
+			clients:   s.clients,
+		}
+		req := &api.EmailChangeMsg{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[3].ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+			NewEmail: "newemail3@test.com",
+			Password: testPw,
+		}
+		resp, err := sharedClient.ChangeAccountIDEmail(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.Account.AccountId != req.NewEmail {
+			t.Errorf("unexpected accountID: %s", resp.Account.AccountId)
+			return
+		}
+		tts, err := testGlobalDBService.GetTempTokenForUser(testInstanceID, testUsers[3].ID.Hex(), constants.TOKEN_PURPOSE_CONTACT_VERIFICATION)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		found := false
+		for _, tt := range tts {
+			found = found || tt.Info["email"] == req.NewEmail
+		}
+		if !found {
+			t.Errorf("verification token not saved: %v", tts)
+		}
+	})
 }
 
-func TestDeleteAccountEndpoint(t *testing.T) {
+func TestChangeAccountTypeEndpoint(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
-	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
 	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
 
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
 		globalDBService: testGlobalDBService,
-		Intervals: models.Intervals{
-			TokenExpiryInterval:      time.Second * 2,
-			VerificationCodeLifetime: 60,
-		},
 		clients: &models.APIClients{
-			MessagingService: mockMessagingClient,
-			LoggingService:   mockLoggingClient,
+			LoggingService: mockLoggingClient,
 		},
 	}
 
-	// Create Test User
+	testPw := "test234-TESt??"
+	hashPw, _ := pwhash.HashPassword(testPw)
 	testUsers, err := addTestUsers([]models.User{
 		{
 			Account: models.Account{
-				Type:      "email",
-				AccountID: "delete_user_1@test.com",
+				Type:               models.ACCOUNT_TYPE_EMAIL,
+				AccountID:          "change_account_type_0@test.com",
+				AccountConfirmedAt: 1231239192,
+				Password:           hashPw,
+			},
+			Profiles: []models.Profile{
+				{ID: primitive.NewObjectID(), Alias: "change_account_type_0@test.com"},
+			},
+			ContactInfos: []models.ContactInfo{
+				{ID: primitive.NewObjectID(), Type: "email", Email: "change_account_type_0@test.com", ConfirmedAt: 1231239192},
 			},
 		},
 		{
 			Account: models.Account{
-				Type:      "email",
-				AccountID: "delete_user_2@test.com",
+				Type:               models.ACCOUNT_TYPE_USERNAME,
+				AccountID:          "change_type_user1",
+				AccountConfirmedAt: 1231239192,
+				Password:           hashPw,
+			},
+			Profiles: []models.Profile{
+				{ID: primitive.NewObjectID(), Alias: "change_type_user1"},
+			},
+			ContactInfos: []models.ContactInfo{
+				{ID: primitive.NewObjectID(), Type: "email", Email: "change_account_type_1@test.com", ConfirmedAt: 1231239200},
+				{ID: primitive.NewObjectID(), Type: "email", Email: "change_account_type_1_unconfirmed@test.com"},
+				{ID: primitive.NewObjectID(), Type: "email", Email: "change_account_type_2@test.com", ConfirmedAt: 1231239200},
+			},
+		},
+		{
+			Account: models.Account{
+				Type:               models.ACCOUNT_TYPE_EMAIL,
+				AccountID:          "change_account_type_2@test.com",
+				AccountConfirmedAt: 1231239192,
+				Password:           hashPw,
 			},
 		},
 	})
@@ -603,142 +937,122 @@ func TestDeleteAccountEndpoint(t *testing.T) {
 		t.Errorf("failed to create testusers: %s", err.Error())
 		return
 	}
+	tokenOf := func(u models.User) *api_types.TokenInfos {
+		return &api_types.TokenInfos{Id: u.ID.Hex(), InstanceId: testInstanceID}
+	}
 
-	t.Run("without payload", func(t *testing.T) {
-		resp, err := s.DeleteAccount(context.Background(), nil)
-		if err == nil {
-			t.Error("should return error")
-			return
-		}
-		if status.Convert(err).Message() != "missing argument" || resp != nil {
-			t.Errorf("wrong error: %s", err.Error())
-			t.Errorf("or response: %s", resp)
-		}
-	})
-
-	t.Run("with empty payload", func(t *testing.T) {
-		req := &api.UserReference{}
-		_, err := s.DeleteAccount(context.Background(), req)
+	t.Run("with missing arguments", func(t *testing.T) {
+		_, err := s.ChangeAccountType(context.Background(), &AccountTypeChangeMsg{Token: tokenOf(testUsers[0]), Password: testPw, NewType: models.ACCOUNT_TYPE_USERNAME})
 		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	t.Run("with other user", func(t *testing.T) {
-		req := &api.UserReference{
-			Token: &api_types.TokenInfos{
-				Id:         testUsers[0].ID.Hex(),
-				InstanceId: testInstanceID,
-			},
-			UserId: testUsers[1].ID.Hex(),
-		}
-
-		resp, err := s.DeleteAccount(context.Background(), req)
-		if err == nil {
-			t.Error("should return error")
-			return
+	t.Run("with invalid username", func(t *testing.T) {
+		_, err := s.ChangeAccountType(context.Background(), &AccountTypeChangeMsg{Token: tokenOf(testUsers[0]), Password: testPw, NewType: models.ACCOUNT_TYPE_USERNAME, NewAccountId: "a@b"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "username not valid")
+		if !ok {
+			t.Error(msg)
 		}
-		if status.Convert(err).Message() != "not authorized" || resp != nil {
-			t.Errorf("wrong error: %s", err.Error())
-			t.Errorf("or response: %s", resp)
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_INVALID_USERNAME)
+		if !ok {
+			t.Error(msg)
 		}
 	})
 
-	t.Run("with same user", func(t *testing.T) {
+	t.Run("with wrong password", func(t *testing.T) {
 		mockLoggingClient.EXPECT().SaveLogEvent(
 			gomock.Any(),
 			gomock.Any(),
 		).Return(nil, nil)
+		_, err := s.ChangeAccountType(context.Background(), &AccountTypeChangeMsg{Token: tokenOf(testUsers[0]), Password: "wrong", NewType: models.ACCOUNT_TYPE_USERNAME, NewAccountId: "change_type_user0"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "action failed")
+		if !ok {
+			t.Error(msg)
+		}
+	})
 
-		mockMessagingClient.EXPECT().SendInstantEmail(
+	t.Run("email to username already in use", func(t *testing.T) {
+		_, err := s.ChangeAccountType(context.Background(), &AccountTypeChangeMsg{Token: tokenOf(testUsers[0]), Password: testPw, NewType: models.ACCOUNT_TYPE_USERNAME, NewAccountId: "Change_Type_User1"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "account id already in use")
+		if !ok {
+			t.Error(msg)
+		}
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_ACCOUNT_ID_IN_USE)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("email to username", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
 			gomock.Any(),
 			gomock.Any(),
 		).Return(nil, nil)
-
-		req := &api.UserReference{
-			Token: &api_types.TokenInfos{
-				Id:         testUsers[0].ID.Hex(),
-				InstanceId: testInstanceID,
-			},
-			UserId: testUsers[0].ID.Hex(),
+		_, err := s.ChangeAccountType(context.Background(), &AccountTypeChangeMsg{Token: tokenOf(testUsers[0]), Password: testPw, NewType: models.ACCOUNT_TYPE_USERNAME, NewAccountId: "change_type_user0"})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
 		}
-		_, err := s.DeleteAccount(context.Background(), req)
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
 		if err != nil {
 			t.Errorf("unexpected error: %s", err.Error())
 			return
 		}
-		_, err = testUserDBService.GetUserByID(testInstanceID, testUsers[0].ID.Hex())
-		if err == nil {
-			t.Error("user should not exist")
+		if user.Account.Type != models.ACCOUNT_TYPE_USERNAME || user.Account.AccountID != "change_type_user0" || user.Profiles[0].Alias != "change_type_user0" {
+			t.Errorf("unexpected account: %v, alias %s", user.Account, user.Profiles[0].Alias)
+		}
+		if !user.IsEmailConfirmed("change_account_type_0@test.com") {
+			t.Error("email should be kept as contact")
 		}
 	})
-}
-
-func TestChangePreferredLanguageEndpoint(t *testing.T) {
-	s := userManagementServer{
-		userDBservice:   testUserDBService,
-		globalDBService: testGlobalDBService,
-		Intervals: models.Intervals{
-			TokenExpiryInterval:      time.Second * 2,
-			VerificationCodeLifetime: 60,
-		},
-	}
-	testUsers, err := addTestUsers([]models.User{
-		{
-			Account: models.Account{
-				Type:              "email",
-				AccountID:         "test_for_change_preferred_lang@test.com",
-				PreferredLanguage: "de",
-			},
-		},
-	})
-	if err != nil {
-		t.Errorf("failed to create testusers: %s", err.Error())
-		return
-	}
 
-	t.Run("without payload", func(t *testing.T) {
-		_, err := s.ChangePreferredLanguage(context.Background(), nil)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+	t.Run("username to unconfirmed email", func(t *testing.T) {
+		_, err := s.ChangeAccountType(context.Background(), &AccountTypeChangeMsg{Token: tokenOf(testUsers[1]), Password: testPw, NewType: models.ACCOUNT_TYPE_EMAIL, NewAccountId: "change_account_type_1_unconfirmed@test.com"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "email not confirmed")
+		if !ok {
+			t.Error(msg)
+		}
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_EMAIL_NOT_CONFIRMED)
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	t.Run("with empty payload", func(t *testing.T) {
-		req := &api.LanguageChangeMsg{}
-		_, err := s.ChangePreferredLanguage(context.Background(), req)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+	t.Run("username to email of another account", func(t *testing.T) {
+		_, err := s.ChangeAccountType(context.Background(), &AccountTypeChangeMsg{Token: tokenOf(testUsers[1]), Password: testPw, NewType: models.ACCOUNT_TYPE_EMAIL, NewAccountId: "change_account_type_2@test.com"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "account id already in use")
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	token := api_types.TokenInfos{
-		Id:         testUsers[0].ID.Hex(),
-		InstanceId: testInstanceID,
-	}
-
-	t.Run("with normal payload", func(t *testing.T) {
-		req := &api.LanguageChangeMsg{
-			Token:        &token,
-			LanguageCode: "fr",
+	t.Run("username to email", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+		_, err := s.ChangeAccountType(context.Background(), &AccountTypeChangeMsg{Token: tokenOf(testUsers[1]), Password: testPw, NewType: models.ACCOUNT_TYPE_EMAIL, NewAccountId: "change_account_type_1@test.com"})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
 		}
-		resp, err := s.ChangePreferredLanguage(context.Background(), req)
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[1].ID.Hex())
 		if err != nil {
 			t.Errorf("unexpected error: %s", err.Error())
 			return
 		}
-		if resp.Account.PreferredLanguage != "fr" {
-			t.Errorf("unexpected language code: %s", resp.Account.PreferredLanguage)
+		if user.Account.Type != models.ACCOUNT_TYPE_EMAIL || user.Account.AccountID != "change_account_type_1@test.com" || user.Account.AccountConfirmedAt != 1231239200 {
+			t.Errorf("unexpected account: %v", user.Account)
 		}
 	})
 }
 
-func TestSaveProfileEndpoint(t *testing.T) {
+func TestCancelEmailChangeEndpoint(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
 	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
 
 	s := userManagementServer{
@@ -749,21 +1063,47 @@ func TestSaveProfileEndpoint(t *testing.T) {
 			VerificationCodeLifetime: 60,
 		},
 		clients: &models.APIClients{
-			LoggingService: mockLoggingClient,
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
 		},
 	}
+
+	testPw := "test234-TESt??"
+	hashPw, _ := pwhash.HashPassword(testPw)
+	oldEmailContactID := primitive.NewObjectID()
 	testUsers, err := addTestUsers([]models.User{
 		{
 			Account: models.Account{
-				Type:      "email",
-				AccountID: "test_for_save_profile@test.com",
+				Type:               "email",
+				AccountID:          "cancel_email_change_1@test.com",
+				AccountConfirmedAt: 1231239192,
+				Password:           hashPw,
 			},
 			Profiles: []models.Profile{
+				{ID: primitive.NewObjectID(), Alias: "cancel_email_change_1@test.com"},
+			},
+			ContactInfos: []models.ContactInfo{
 				{
-					ID:    primitive.NewObjectID(),
-					Alias: "main",
+					ID:          oldEmailContactID,
+					Type:        "email",
+					Email:       "cancel_email_change_1@test.com",
+					ConfirmedAt: 1231239192,
 				},
 			},
+			ContactPreferences: models.ContactPreferences{
+				SendNewsletterTo: []string{oldEmailContactID.Hex()},
+			},
+		},
+		{
+			Account: models.Account{
+				Type:               "email",
+				AccountID:          "cancel_email_change_2@test.com",
+				AccountConfirmedAt: 1231239192,
+				Password:           hashPw,
+			},
+			Profiles: []models.Profile{
+				{ID: primitive.NewObjectID(), Alias: "test"},
+			},
 		},
 	})
 	if err != nil {
@@ -772,67 +1112,107 @@ func TestSaveProfileEndpoint(t *testing.T) {
 	}
 
 	t.Run("without payload", func(t *testing.T) {
-		_, err := s.SaveProfile(context.Background(), nil)
+		_, err := s.CancelEmailChange(context.Background(), nil)
 		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	token := api_types.TokenInfos{
-		Id:         testUsers[0].ID.Hex(),
-		InstanceId: testInstanceID,
-	}
+	t.Run("with other user", func(t *testing.T) {
+		req := &api.UserReference{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[1].ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+			UserId: testUsers[0].ID.Hex(),
+		}
+		_, err := s.CancelEmailChange(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "not authorized")
+		if !ok {
+			t.Error(msg)
+		}
+	})
 
-	t.Run("with add profile", func(t *testing.T) {
+	t.Run("without pending change", func(t *testing.T) {
+		req := &api.UserReference{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[1].ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+		}
+		_, err := s.CancelEmailChange(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "no pending email change")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("change email then cancel", func(t *testing.T) {
 		mockLoggingClient.EXPECT().SaveLogEvent(
 			gomock.Any(),
 			gomock.Any(),
-		).Return(nil, nil)
+		).Return(nil, nil).Times(2)
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil).Times(2)
 
-		req := &api.ProfileRequest{
-			Token: &token,
-			Profile: &api.Profile{
-				Alias: "new test",
-			},
+		token := &api_types.TokenInfos{
+			Id:         testUsers[0].ID.Hex(),
+			InstanceId: testInstanceID,
 		}
-		resp, err := s.SaveProfile(context.Background(), req)
+		changed, err := s.ChangeAccountIDEmail(context.Background(), &api.EmailChangeMsg{
+			Token:    token,
+			NewEmail: "cancel_email_change_1_new@test.com",
+			Password: testPw,
+		})
 		if err != nil {
 			t.Errorf("unexpected error: %s", err.Error())
 			return
 		}
-		if len(resp.Profiles) != 2 || resp.Profiles[1].Alias != "new test" {
-			t.Errorf("unexpected response code: %s", resp)
+		if changed.Account.AccountId != "cancel_email_change_1_new@test.com" {
+			t.Errorf("unexpected account id: %s", changed.Account.AccountId)
+			return
 		}
-	})
-
-	t.Run("with update profile", func(t *testing.T) {
-		mockLoggingClient.EXPECT().SaveLogEvent(
-			gomock.Any(),
-			gomock.Any(),
-		).Return(nil, nil)
 
-		req := &api.ProfileRequest{
-			Token: &token,
-			Profile: &api.Profile{
-				Id:    testUsers[0].Profiles[0].ID.Hex(),
-				Alias: "renamed",
-			},
+		resp, err := s.CancelEmailChange(context.Background(), &api.UserReference{Token: token})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
 		}
-		resp, err := s.SaveProfile(context.Background(), req)
+		if resp.Account.AccountId != testUsers[0].Account.AccountID {
+			t.Errorf("unexpected account id: %s", resp.Account.AccountId)
+		}
+		if resp.Account.AccountConfirmedAt != testUsers[0].Account.AccountConfirmedAt {
+			t.Errorf("unexpected AccountConfirmedAt: %d", resp.Account.AccountConfirmedAt)
+		}
+		if resp.Profiles[0].Alias != testUsers[0].Account.AccountID {
+			t.Errorf("unexpected alias: %s", resp.Profiles[0].Alias)
+		}
+		if len(resp.ContactInfos) != 1 || resp.ContactInfos[0].GetEmail() != testUsers[0].Account.AccountID {
+			t.Errorf("unexpected contact infos: %v", resp.ContactInfos)
+			return
+		}
+		if len(resp.ContactPreferences.SendNewsletterTo) != 1 || resp.ContactPreferences.SendNewsletterTo[0] != resp.ContactInfos[0].Id {
+			t.Errorf("unexpected contact preferences: %v", resp.ContactPreferences.SendNewsletterTo)
+		}
+
+		restoreTokens, err := testGlobalDBService.GetTempTokenForUser(testInstanceID, testUsers[0].ID.Hex(), constants.TOKEN_PURPOSE_RESTORE_ACCOUNT_ID)
 		if err != nil {
 			t.Errorf("unexpected error: %s", err.Error())
 			return
 		}
-		if len(resp.Profiles) != 2 || resp.Profiles[0].Alias != "renamed" {
-			t.Errorf("unexpected response code: %s", resp)
+		if len(restoreTokens) > 0 {
+			t.Errorf("restore token should be deleted: %v", restoreTokens)
 		}
 	})
 }
 
-func TestRemoveProfileEndpoint(t *testing.T) {
+func TestRestoreAccountIDEndpoint(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
 	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
 
 	s := userManagementServer{
@@ -843,23 +1223,49 @@ func TestRemoveProfileEndpoint(t *testing.T) {
 			VerificationCodeLifetime: 60,
 		},
 		clients: &models.APIClients{
-			LoggingService: mockLoggingClient,
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
 		},
 	}
+
+	testPw := "test234-TESt??"
+	hashPw, _ := pwhash.HashPassword(testPw)
 	testUsers, err := addTestUsers([]models.User{
 		{
 			Account: models.Account{
-				Type:      "email",
-				AccountID: "test_for_remove_profile@test.com",
+				Type:               "email",
+				AccountID:          "restore_account_id_1@test.com",
+				AccountConfirmedAt: 1231239192,
+				Password:           hashPw,
 			},
 			Profiles: []models.Profile{
+				{ID: primitive.NewObjectID(), Alias: "test"},
+			},
+			ContactInfos: []models.ContactInfo{
 				{
-					ID:    primitive.NewObjectID(),
-					Alias: "main",
+					ID:          primitive.NewObjectID(),
+					Type:        "email",
+					Email:       "restore_account_id_1@test.com",
+					ConfirmedAt: 1231239192,
 				},
+			},
+		},
+		{
+			Account: models.Account{
+				Type:               "email",
+				AccountID:          "restore_account_id_2@test.com",
+				AccountConfirmedAt: 1231239192,
+				Password:           hashPw,
+			},
+			Profiles: []models.Profile{
+				{ID: primitive.NewObjectID(), Alias: "test"},
+			},
+			ContactInfos: []models.ContactInfo{
 				{
-					ID:    primitive.NewObjectID(),
-					Alias: "new1",
+					ID:          primitive.NewObjectID(),
+					Type:        "email",
+					Email:       "restore_account_id_2@test.com",
+					ConfirmedAt: 1231239192,
 				},
 			},
 		},
@@ -869,17 +1275,1546 @@ func TestRemoveProfileEndpoint(t *testing.T) {
 		return
 	}
 
+	changeEmail := func(user models.User, newEmail string) (string, error) {
+		_, err := s.ChangeAccountIDEmail(context.Background(), &api.EmailChangeMsg{
+			Token: &api_types.TokenInfos{
+				Id:         user.ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+			NewEmail: newEmail,
+			Password: testPw,
+		})
+		if err != nil {
+			return "", err
+		}
+		restoreTokens, err := testGlobalDBService.GetTempTokenForUser(testInstanceID, user.ID.Hex(), constants.TOKEN_PURPOSE_RESTORE_ACCOUNT_ID)
+		if err != nil || len(restoreTokens) != 1 {
+			return "", errors.New("restore token not found")
+		}
+		return restoreTokens[0].Token, nil
+	}
+
 	t.Run("without payload", func(t *testing.T) {
-		_, err := s.RemoveProfile(context.Background(), nil)
+		_, err := s.RestoreAccountID(context.Background(), nil)
 		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	t.Run("with empty payload", func(t *testing.T) {
-		req := &api.ProfileRequest{}
+	t.Run("with wrong token", func(t *testing.T) {
+		_, err := s.RestoreAccountID(context.Background(), &api.TempToken{Token: "wrong"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "wrong token")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("restore old email", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil).Times(2)
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil).Times(2)
+
+		restoreToken, err := changeEmail(testUsers[0], "restore_account_id_1_new@test.com")
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		_, err = s.RestoreAccountID(context.Background(), &api.TempToken{Token: restoreToken})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Account.AccountID != testUsers[0].Account.AccountID {
+			t.Errorf("unexpected account id: %s", user.Account.AccountID)
+		}
+		if user.Account.AccountConfirmedAt != testUsers[0].Account.AccountConfirmedAt {
+			t.Errorf("unexpected AccountConfirmedAt: %d", user.Account.AccountConfirmedAt)
+		}
+		if _, found := user.FindContactInfoByTypeAndAddr("email", "restore_account_id_1_new@test.com"); found {
+			t.Error("new email should be removed from contact infos")
+		}
+		if _, err := testGlobalDBService.GetTempToken(restoreToken); err == nil {
+			t.Error("restore token should be deleted")
+		}
+	})
+
+	t.Run("old email taken by another user", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil).Times(2)
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil).Times(4)
+
+		restoreToken, err := changeEmail(testUsers[1], "restore_account_id_2_new@test.com")
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		// other user now uses the released address
+		_, err = s.ChangeAccountIDEmail(context.Background(), &api.EmailChangeMsg{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[0].ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+			NewEmail: testUsers[1].Account.AccountID,
+			Password: testPw,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		_, err = s.RestoreAccountID(context.Background(), &api.TempToken{Token: restoreToken})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "old email address already in use")
+		if !ok {
+			t.Error(msg)
+		}
+
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[1].ID.Hex())
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Account.AccountID != "restore_account_id_2_new@test.com" {
+			t.Errorf("account id should not change: %s", user.Account.AccountID)
+		}
+	})
+}
+
+func TestRevertAccountIDChange(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+	info := map[string]string{
+		"oldEmail": "revert_account_id_old@test.com",
+		"newEmail": "revert_account_id_new@test.com",
+	}
+
+	t.Run("user without profile", func(t *testing.T) {
+		user := models.User{
+			ID: primitive.NewObjectID(),
+			Account: models.Account{
+				Type:      models.ACCOUNT_TYPE_EMAIL,
+				AccountID: "revert_account_id_new@test.com",
+			},
+		}
+		_, err := s.revertAccountIDChange(context.Background(), testInstanceID, user, info)
+		if !errors.Is(err, errAccountIDRestoreFailed) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("with canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		user := models.User{
+			ID: primitive.NewObjectID(),
+			Account: models.Account{
+				Type:      models.ACCOUNT_TYPE_EMAIL,
+				AccountID: "revert_account_id_new@test.com",
+			},
+			Profiles: []models.Profile{{ID: primitive.NewObjectID()}},
+		}
+		_, err := s.revertAccountIDChange(ctx, testInstanceID, user, info)
+		if !errors.Is(err, errAccountIDRestoreFailed) {
+			t.Errorf("a failed lookup of the old address should not allow the restore: %v", err)
+		}
+	})
+}
+
+func TestDeleteAccountEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
+		},
+	}
+
+	// Create Test User
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "delete_user_1@test.com",
+			},
+		},
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "delete_user_2@test.com",
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		resp, err := s.DeleteAccount(context.Background(), nil)
+		if err == nil {
+			t.Error("should return error")
+			return
+		}
+		if status.Convert(err).Message() != "missing argument" || resp != nil {
+			t.Errorf("wrong error: %s", err.Error())
+			t.Errorf("or response: %s", resp)
+		}
+	})
+
+	t.Run("with empty payload", func(t *testing.T) {
+		req := &api.UserReference{}
+		_, err := s.DeleteAccount(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with other user", func(t *testing.T) {
+		req := &api.UserReference{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[0].ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+			UserId: testUsers[1].ID.Hex(),
+		}
+
+		resp, err := s.DeleteAccount(context.Background(), req)
+		if err == nil {
+			t.Error("should return error")
+			return
+		}
+		if status.Convert(err).Message() != "not authorized" || resp != nil {
+			t.Errorf("wrong error: %s", err.Error())
+			t.Errorf("or response: %s", resp)
+		}
+	})
+
+	t.Run("with same user", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		req := &api.UserReference{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[0].ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+			UserId: testUsers[0].ID.Hex(),
+		}
+		_, err := s.DeleteAccount(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		_, err = testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
+		if err == nil {
+			t.Error("user should not exist")
+		}
+	})
+}
+
+func TestChangePreferredLanguageEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:              "email",
+				AccountID:         "test_for_change_preferred_lang@test.com",
+				PreferredLanguage: "de",
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.ChangePreferredLanguage(context.Background(), nil)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with empty payload", func(t *testing.T) {
+		req := &api.LanguageChangeMsg{}
+		_, err := s.ChangePreferredLanguage(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	token := api_types.TokenInfos{
+		Id:         testUsers[0].ID.Hex(),
+		InstanceId: testInstanceID,
+	}
+
+	t.Run("with normal payload", func(t *testing.T) {
+		req := &api.LanguageChangeMsg{
+			Token:        &token,
+			LanguageCode: "fr",
+		}
+		resp, err := s.ChangePreferredLanguage(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.Account.PreferredLanguage != "fr" {
+			t.Errorf("unexpected language code: %s", resp.Account.PreferredLanguage)
+		}
+	})
+}
+
+func TestSaveProfileEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_save_profile@test.com",
+			},
+			Profiles: []models.Profile{
+				{
+					ID:    primitive.NewObjectID(),
+					Alias: "main",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.SaveProfile(context.Background(), nil)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	token := api_types.TokenInfos{
+		Id:         testUsers[0].ID.Hex(),
+		InstanceId: testInstanceID,
+	}
+
+	t.Run("with add profile", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		req := &api.ProfileRequest{
+			Token: &token,
+			Profile: &api.Profile{
+				Alias: "new test",
+			},
+		}
+		resp, err := s.SaveProfile(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(resp.Profiles) != 2 || resp.Profiles[1].Alias != "new test" {
+			t.Errorf("unexpected response code: %s", resp)
+		}
+	})
+
+	t.Run("with update profile", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		req := &api.ProfileRequest{
+			Token: &token,
+			Profile: &api.Profile{
+				Id:    testUsers[0].Profiles[0].ID.Hex(),
+				Alias: "renamed",
+			},
+		}
+		resp, err := s.SaveProfile(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(resp.Profiles) != 2 || resp.Profiles[0].Alias != "renamed" {
+			t.Errorf("unexpected response code: %s", resp)
+		}
+	})
+}
+
+func TestRemoveProfileEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_remove_profile@test.com",
+			},
+			Profiles: []models.Profile{
+				{
+					ID:    primitive.NewObjectID(),
+					Alias: "main",
+				},
+				{
+					ID:    primitive.NewObjectID(),
+					Alias: "new1",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.RemoveProfile(context.Background(), nil)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with empty payload", func(t *testing.T) {
+		req := &api.ProfileRequest{}
+		_, err := s.RemoveProfile(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	token := api_types.TokenInfos{
+		Id:         testUsers[0].ID.Hex(),
+		InstanceId: testInstanceID,
+	}
+	t.Run("with wrong id", func(t *testing.T) {
+		req := &api.ProfileRequest{
+			Token: &token,
+			Profile: &api.Profile{
+				Id: "wrong id",
+			},
+		}
+		_, err := s.RemoveProfile(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "profile with given ID not found")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with correct id", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		req := &api.ProfileRequest{
+			Token: &token,
+			Profile: &api.Profile{
+				Id: testUsers[0].Profiles[0].ID.Hex(),
+			},
+		}
+		resp, err := s.RemoveProfile(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(resp.Profiles) != 1 || resp.Profiles[0].Alias == "main" {
+			t.Errorf("wrong response: %s", resp)
+		}
+	})
+
+	t.Run("last one", func(t *testing.T) {
+		req := &api.ProfileRequest{
+			Token: &token,
+			Profile: &api.Profile{
+				Id: testUsers[0].Profiles[1].ID.Hex(),
+			},
+		}
 		_, err := s.RemoveProfile(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "can't delete last profile")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
+
+func TestReorderProfilesEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_reorder_profiles@test.com",
+			},
+			Profiles: []models.Profile{
+				{
+					ID:          primitive.NewObjectID(),
+					Alias:       "main",
+					MainProfile: true,
+				},
+				{
+					ID:    primitive.NewObjectID(),
+					Alias: "second",
+				},
+				{
+					ID:    primitive.NewObjectID(),
+					Alias: "third",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	token := &api_types.TokenInfos{
+		Id:         testUsers[0].ID.Hex(),
+		InstanceId: testInstanceID,
+	}
+	profiles := testUsers[0].Profiles
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.ReorderProfiles(context.Background(), &ReorderProfilesMsg{})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with missing profile", func(t *testing.T) {
+		_, err := s.ReorderProfiles(context.Background(), &ReorderProfilesMsg{Token: token, ProfileIds: []string{
+			profiles[1].ID.Hex(),
+			profiles[0].ID.Hex(),
+		}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "profile ids do not match")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with duplicate profile", func(t *testing.T) {
+		_, err := s.ReorderProfiles(context.Background(), &ReorderProfilesMsg{Token: token, ProfileIds: []string{
+			profiles[1].ID.Hex(),
+			profiles[0].ID.Hex(),
+			profiles[1].ID.Hex(),
+		}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "profile ids do not match")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with unknown profile", func(t *testing.T) {
+		_, err := s.ReorderProfiles(context.Background(), &ReorderProfilesMsg{Token: token, ProfileIds: []string{
+			profiles[1].ID.Hex(),
+			profiles[0].ID.Hex(),
+			primitive.NewObjectID().Hex(),
+		}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "profile ids do not match")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with all profiles", func(t *testing.T) {
+		newOrder := []string{
+			profiles[2].ID.Hex(),
+			profiles[0].ID.Hex(),
+			profiles[1].ID.Hex(),
+		}
+		resp, err := s.ReorderProfiles(context.Background(), &ReorderProfilesMsg{Token: token, ProfileIds: newOrder})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(resp.Profiles) != 3 || resp.Profiles[0].Alias != "third" {
+			t.Errorf("unexpected profiles: %v", resp.Profiles)
+		}
+
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		for i, p := range user.Profiles {
+			if p.ID.Hex() != newOrder[i] {
+				t.Errorf("unexpected profile at %d: %s", i, p.ID.Hex())
+			}
+		}
+		mainID, otherIDs := utils.GetMainAndOtherProfiles(user)
+		if mainID != newOrder[0] {
+			t.Errorf("unexpected main profile: %s", mainID)
+		}
+		if len(otherIDs) != 2 || otherIDs[0] != newOrder[1] || otherIDs[1] != newOrder[2] {
+			t.Errorf("unexpected other profiles: %v", otherIDs)
+		}
+	})
+}
+
+func TestChildProfileEndpoints(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval: time.Minute,
+		},
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+	mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_child_profiles@test.com",
+			},
+			Profiles: []models.Profile{
+				{
+					ID:          primitive.NewObjectID(),
+					Alias:       "guardian",
+					MainProfile: true,
+				},
+				{
+					ID:    primitive.NewObjectID(),
+					Alias: "child",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	userID := testUsers[0].ID.Hex()
+	guardianID := testUsers[0].Profiles[0].ID.Hex()
+	childID := testUsers[0].Profiles[1].ID.Hex()
+	guardianToken := &api_types.TokenInfos{
+		Id:         userID,
+		InstanceId: testInstanceID,
+		ProfilId:   guardianID,
+	}
+	guardianAccessToken, err := tokens.GenerateNewToken(userID, true, guardianID, []string{constants.USER_ROLE_PARTICIPANT}, testInstanceID, time.Minute, "", nil, []string{childID}, nil)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	refreshToken := "TEST-CHILD-PROFILE-REFRESH-TOKEN"
+	if err := testUserDBService.CreateRenewToken(context.Background(), testInstanceID, userID, refreshToken, time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	selectProfile := func(profileID string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(profileMetadataKey, profileID))
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.LinkChildProfile(context.Background(), &ProfileLinkMsg{Token: guardianToken, ChildProfileId: childID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("main profile as child", func(t *testing.T) {
+		_, err := s.LinkChildProfile(context.Background(), &ProfileLinkMsg{Token: guardianToken, ChildProfileId: guardianID, GuardianProfileId: childID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "main profile can't be a child profile")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("link child profile", func(t *testing.T) {
+		_, err := s.LinkChildProfile(context.Background(), &ProfileLinkMsg{Token: guardianToken, ChildProfileId: childID, GuardianProfileId: guardianID})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, guardianToken.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Profiles[1].GuardianProfileID != guardianID || user.Profiles[0].IsChild() {
+			t.Errorf("unexpected profiles: %+v", user.Profiles)
+		}
+	})
+
+	// token of the child's context, as the gateway gets it
+	childToken := &api_types.TokenInfos{}
+	var childRefreshToken string
+	t.Run("select child profile", func(t *testing.T) {
+		resp, err := s.RenewJWT(selectProfile(childID), &api.RefreshJWTRequest{
+			AccessToken:  guardianAccessToken,
+			RefreshToken: refreshToken,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.SelectedProfileId != childID || len(resp.Profiles) != 1 {
+			t.Errorf("unexpected response: %s", resp)
+		}
+		childRefreshToken = resp.RefreshToken
+		childToken, err = s.ValidateJWT(context.Background(), &api.JWTRequest{Token: resp.AccessToken})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if childToken.ProfilId != childID || len(childToken.OtherProfileIds) != 0 {
+			t.Errorf("unexpected token: %v", childToken)
+		}
+	})
+
+	t.Run("child session can't select the guardian", func(t *testing.T) {
+		// even with an older access token of the guardian
+		_, err := s.RenewJWT(selectProfile(guardianID), &api.RefreshJWTRequest{
+			AccessToken:  guardianAccessToken,
+			RefreshToken: childRefreshToken,
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, childProfileNotAllowedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("child session stays limited to the child", func(t *testing.T) {
+		resp, err := s.RenewJWT(context.Background(), &api.RefreshJWTRequest{
+			AccessToken:  guardianAccessToken,
+			RefreshToken: childRefreshToken,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.SelectedProfileId != childID {
+			t.Errorf("unexpected response: %s", resp)
+		}
+	})
+
+	t.Run("child can't change the account email", func(t *testing.T) {
+		_, err := s.ChangeAccountIDEmail(context.Background(), &api.EmailChangeMsg{
+			Token:    childToken,
+			NewEmail: "test_for_child_profiles_new@test.com",
+			Password: "SuperSecurePassword123!§$",
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, childProfileNotAllowedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_NOT_ALLOWED_FOR_CHILD_PROFILE)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("child can't change the account type", func(t *testing.T) {
+		_, err := s.ChangeAccountType(context.Background(), &AccountTypeChangeMsg{Token: childToken, Password: "SuperSecurePassword123!§$", NewType: models.ACCOUNT_TYPE_USERNAME, NewAccountId: "child_username"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, childProfileNotAllowedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("child can't change the password", func(t *testing.T) {
+		_, err := s.ChangePassword(context.Background(), &api.PasswordChangeMsg{
+			Token:       childToken,
+			OldPassword: "SuperSecurePassword123!§$",
+			NewPassword: "SuperSecurePassword123!§$new",
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, childProfileNotAllowedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("child can't set the primary email", func(t *testing.T) {
+		_, err := s.SetPrimaryEmail(context.Background(), &api.ContactInfoMsg{
+			Token:       childToken,
+			ContactInfo: &api.ContactInfo{Id: primitive.NewObjectID().Hex(), Type: "email"},
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, childProfileNotAllowedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("child can't delete the account", func(t *testing.T) {
+		_, err := s.DeleteAccount(context.Background(), &api.UserReference{
+			Token:  childToken,
+			UserId: childToken.Id,
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, childProfileNotAllowedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+		if _, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, childToken.Id); err != nil {
+			t.Errorf("user should not be deleted: %s", err.Error())
+		}
+	})
+
+	t.Run("child can't unlink itself", func(t *testing.T) {
+		_, err := s.UnlinkChildProfile(context.Background(), &ProfileLinkMsg{Token: childToken, ChildProfileId: childID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, childProfileNotAllowedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("guardian unlinks child profile", func(t *testing.T) {
+		resp, err := s.UnlinkChildProfile(context.Background(), &ProfileLinkMsg{Token: guardianToken, ChildProfileId: childID})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(resp.Profiles) != 2 {
+			t.Errorf("unexpected profiles: %v", resp.Profiles)
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, guardianToken.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.IsChildProfile(childID) {
+			t.Errorf("unexpected profiles: %+v", user.Profiles)
+		}
+	})
+}
+
+func TestProfileAvatarAndConsents(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_profile_consents@test.com",
+			},
+			Profiles: []models.Profile{
+				{
+					ID:          primitive.NewObjectID(),
+					Alias:       "main",
+					MainProfile: true,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	token := &api_types.TokenInfos{
+		Id:         testUsers[0].ID.Hex(),
+		InstanceId: testInstanceID,
+	}
+	profileID := testUsers[0].Profiles[0].ID.Hex()
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.SetProfileConsent(context.Background(), &ProfileConsentMsg{Token: token, ProfileId: profileID, Version: "v1"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with unknown profile", func(t *testing.T) {
+		_, err := s.SetProfileConsent(context.Background(), &ProfileConsentMsg{Token: token, ProfileId: primitive.NewObjectID().Hex(), ConsentKey: "data-donation", Version: "v1"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "profile not found")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with wrong avatar url", func(t *testing.T) {
+		_, err := s.SetProfileAvatarURL(context.Background(), &ProfileAvatarMsg{Token: token, ProfileId: profileID, AvatarUrl: "javascript:alert(1)"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "avatar url not valid")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("set avatar and consents", func(t *testing.T) {
+		if _, err := s.SetProfileAvatarURL(context.Background(), &ProfileAvatarMsg{Token: token, ProfileId: profileID, AvatarUrl: "https://example.com/avatar.png"}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, err := s.SetProfileConsent(context.Background(), &ProfileConsentMsg{Token: token, ProfileId: profileID, ConsentKey: "data-donation", Version: "v1"}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, err := s.SetProfileConsent(context.Background(), &ProfileConsentMsg{Token: token, ProfileId: profileID, ConsentKey: "data-donation", Version: "v2"}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, err := s.SetProfileConsent(context.Background(), &ProfileConsentMsg{Token: token, ProfileId: profileID, ConsentKey: "contact", Version: "v1"}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		p := user.Profiles[0]
+		if p.AvatarURL != "https://example.com/avatar.png" {
+			t.Errorf("unexpected avatar url: %s", p.AvatarURL)
+		}
+		if len(p.Consents) != 2 || p.Consents["data-donation"].Version != "v2" || p.Consents["contact"].ConfirmedAt < 1 {
+			t.Errorf("unexpected consents: %v", p.Consents)
+		}
+	})
+
+	t.Run("save profile keeps avatar and consents", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		_, err := s.SaveProfile(context.Background(), &api.ProfileRequest{
+			Token: token,
+			Profile: &api.Profile{
+				Id:       profileID,
+				Alias:    "renamed",
+				AvatarId: "cat",
+			},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		p := user.Profiles[0]
+		if p.Alias != "renamed" || p.AvatarID != "cat" || !p.MainProfile {
+			t.Errorf("unexpected profile: %v", p)
+		}
+		if p.AvatarURL != "https://example.com/avatar.png" || len(p.Consents) != 2 || p.Consents["data-donation"].Version != "v2" {
+			t.Errorf("avatar url and consents should be kept: %v", p)
+		}
+	})
+
+	t.Run("remove avatar url", func(t *testing.T) {
+		resp, err := s.SetProfileAvatarURL(context.Background(), &ProfileAvatarMsg{Token: token, ProfileId: profileID})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.Profiles[0].Alias != "renamed" {
+			t.Errorf("unexpected profile: %v", resp.Profiles[0])
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Profiles[0].AvatarURL != "" || len(user.Profiles[0].Consents) != 2 {
+			t.Errorf("unexpected profile: %v", user.Profiles[0])
+		}
+	})
+}
+
+func TestPolicyAcceptance(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+	mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		instanceConfigs: newInstanceConfigCache(time.Minute),
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+
+	policyInstance := testInstanceID + "_policies"
+	if err := testGlobalDBService.SaveInstanceConfig(policyInstance, models.InstanceConfig{
+		Policies: models.PolicyConfig{
+			CurrentVersions:   map[string]string{"terms": "2024-05", "privacy": "3"},
+			RequireAcceptance: true,
+		},
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	userIDs := []string{}
+	for _, email := range []string{"test_for_policies_1@test.com", "test_for_policies_2@test.com"} {
+		id, err := testUserDBService.AddUser(context.Background(), policyInstance, models.User{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: email,
+			},
+			AcceptedPolicies: map[string]models.PolicyAcceptance{
+				"terms": {Version: "2023-01", AcceptedAt: time.Now().Unix() - 3600},
+			},
+		})
+		if err != nil {
+			t.Errorf("failed to create testusers: %s", err.Error())
+			return
+		}
+		userIDs = append(userIDs, id)
+	}
+	token := &api_types.TokenInfos{
+		Id:         userIDs[0],
+		InstanceId: policyInstance,
+	}
+	adminToken := &api_types.TokenInfos{
+		Id:         "testadmin",
+		InstanceId: policyInstance,
+		Payload: map[string]string{
+			"roles": "ADMIN",
+		},
+	}
+	nonAccepters := func() []string {
+		resp, err := s.FindUsersWithoutPolicyAcceptance(context.Background(), &api.UserReference{Token: adminToken})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return nil
+		}
+		ids := []string{}
+		for _, u := range resp.Users {
+			ids = append(ids, u.Id)
+		}
+		return ids
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.AcceptPolicy(context.Background(), &PolicyAcceptanceMsg{Token: token, PolicyKey: "terms"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with unknown policy", func(t *testing.T) {
+		_, err := s.AcceptPolicy(context.Background(), &PolicyAcceptanceMsg{Token: token, PolicyKey: "cookies", Version: "1"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "unknown policy")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with outdated version", func(t *testing.T) {
+		_, err := s.AcceptPolicy(context.Background(), &PolicyAcceptanceMsg{Token: token, PolicyKey: "terms", Version: "2023-01"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "not the current policy version")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("find non accepters as non admin", func(t *testing.T) {
+		_, err := s.FindUsersWithoutPolicyAcceptance(context.Background(), &api.UserReference{Token: token})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("sensitive action before acceptance", func(t *testing.T) {
+		_, err := s.SaveProfile(context.Background(), &api.ProfileRequest{
+			Token:   token,
+			Profile: &api.Profile{Alias: "new profile"},
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, policyAcceptanceRequiredMsg)
+		if !ok {
+			t.Error(msg)
+		}
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_POLICY_ACCEPTANCE_REQUIRED)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("account status with outdated version", func(t *testing.T) {
+		accountStatus, err := s.GetAccountStatus(context.Background(), &api.UserReference{Token: token})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(accountStatus.PendingPolicies) != 2 || accountStatus.PendingPolicies["terms"] != "2024-05" || accountStatus.PendingPolicies["privacy"] != "3" {
+			t.Errorf("unexpected pending policies: %v", accountStatus.PendingPolicies)
+		}
+	})
+
+	t.Run("accept current versions", func(t *testing.T) {
+		if ids := nonAccepters(); len(ids) != 2 {
+			t.Errorf("unexpected users without acceptance: %v", ids)
+			return
+		}
+		if _, err := s.AcceptPolicy(context.Background(), &PolicyAcceptanceMsg{Token: token, PolicyKey: "terms", Version: "2024-05"}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if ids := nonAccepters(); len(ids) != 2 {
+			t.Errorf("privacy policy not accepted yet: %v", ids)
+			return
+		}
+		user, err := s.AcceptPolicy(context.Background(), &PolicyAcceptanceMsg{Token: token, PolicyKey: "privacy", Version: "3"})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Id != userIDs[0] {
+			t.Errorf("unexpected user: %s", user.Id)
+		}
+		if ids := nonAccepters(); len(ids) != 1 || ids[0] != userIDs[1] {
+			t.Errorf("unexpected users without acceptance: %v", ids)
+		}
+
+		stored, err := testUserDBService.GetUserByID(context.Background(), policyInstance, userIDs[0])
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if a := stored.AcceptedPolicies["terms"]; a.Version != "2024-05" || a.AcceptedAt < time.Now().Unix()-10 {
+			t.Errorf("unexpected acceptance: %v", a)
+		}
+	})
+
+	t.Run("account status with current versions", func(t *testing.T) {
+		accountStatus, err := s.GetAccountStatus(context.Background(), &api.UserReference{Token: token})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if accountStatus.PendingPolicies != nil {
+			t.Errorf("unexpected pending policies: %v", accountStatus.PendingPolicies)
+		}
+	})
+
+	t.Run("sensitive action after acceptance", func(t *testing.T) {
+		_, err := s.SaveProfile(context.Background(), &api.ProfileRequest{
+			Token:   token,
+			Profile: &api.Profile{Alias: "new profile"},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	})
+}
+
+func TestSetProfilePreferredLanguageEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:              "email",
+				AccountID:         "test_for_profile_language@test.com",
+				PreferredLanguage: "de",
+			},
+			Profiles: []models.Profile{
+				{
+					ID:          primitive.NewObjectID(),
+					Alias:       "main",
+					MainProfile: true,
+				},
+				{
+					ID:    primitive.NewObjectID(),
+					Alias: "child",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	token := &api_types.TokenInfos{
+		Id:         testUsers[0].ID.Hex(),
+		InstanceId: testInstanceID,
+	}
+	mainID := testUsers[0].Profiles[0].ID.Hex()
+	childID := testUsers[0].Profiles[1].ID.Hex()
+
+	t.Run("with wrong language code", func(t *testing.T) {
+		_, err := s.SetProfilePreferredLanguage(context.Background(), &ProfileLanguageMsg{Token: token, ProfileId: childID, LanguageCode: "french"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "language code wrong")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("set and remove override", func(t *testing.T) {
+		if _, err := s.SetProfilePreferredLanguage(context.Background(), &ProfileLanguageMsg{Token: token, ProfileId: childID, LanguageCode: "fr"}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if lang := user.PreferredLanguageForProfile(childID); lang != "fr" {
+			t.Errorf("unexpected language for child profile: %s", lang)
+		}
+		if lang := user.PreferredLanguageForProfile(mainID); lang != "de" {
+			t.Errorf("unexpected language for main profile: %s", lang)
+		}
+
+		if _, err := s.SetProfilePreferredLanguage(context.Background(), &ProfileLanguageMsg{Token: token, ProfileId: childID}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		user, err = testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if lang := user.PreferredLanguageForProfile(childID); lang != "de" {
+			t.Errorf("should fall back to account language: %s", lang)
+		}
+	})
+}
+
+func TestSetProfileBirthdateEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_profile_birthdate@test.com",
+			},
+			Profiles: []models.Profile{
+				{
+					ID:          primitive.NewObjectID(),
+					Alias:       "main",
+					MainProfile: true,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	token := &api_types.TokenInfos{
+		Id:         testUsers[0].ID.Hex(),
+		InstanceId: testInstanceID,
+	}
+	profileID := testUsers[0].Profiles[0].ID.Hex()
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.SetProfileBirthdate(context.Background(), &ProfileBirthdateMsg{Token: token, Birthdate: "1990-06-15"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with future date", func(t *testing.T) {
+		tomorrow := time.Now().AddDate(0, 0, 2).Format(models.BIRTHDATE_LAYOUT)
+		_, err := s.SetProfileBirthdate(context.Background(), &ProfileBirthdateMsg{Token: token, ProfileId: profileID, Birthdate: tomorrow})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "birthdate is in the future")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with valid birthdate", func(t *testing.T) {
+		if _, err := s.SetProfileBirthdate(context.Background(), &ProfileBirthdateMsg{Token: token, ProfileId: profileID, Birthdate: "1990-06-15"}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Profiles[0].Birthdate != "1990-06-15" {
+			t.Errorf("unexpected birthdate: %s", user.Profiles[0].Birthdate)
+		}
+		if age, ok := user.Profiles[0].AgeAt(time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)); !ok || age != 34 {
+			t.Errorf("unexpected age: %d", age)
+		}
+	})
+
+	t.Run("kept by SaveProfile", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		user, err := s.SaveProfile(context.Background(), &api.ProfileRequest{
+			Token:   token,
+			Profile: &api.Profile{Id: profileID, Alias: "renamed"},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		stored, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, user.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if stored.Profiles[0].Birthdate != "1990-06-15" {
+			t.Errorf("unexpected birthdate: %s", stored.Profiles[0].Birthdate)
+		}
+	})
+}
+
+func TestUndoProfileChangeEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_undo_profile@test.com",
+			},
+			Profiles: []models.Profile{
+				{
+					ID:                 primitive.NewObjectID(),
+					Alias:              "main",
+					AvatarID:           "cat",
+					ConsentConfirmedAt: 1000,
+					MainProfile:        true,
+				},
+				{
+					ID:    primitive.NewObjectID(),
+					Alias: "child",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	token := &api_types.TokenInfos{
+		Id:         testUsers[0].ID.Hex(),
+		InstanceId: testInstanceID,
+	}
+	mainID := testUsers[0].Profiles[0].ID.Hex()
+	childID := testUsers[0].Profiles[1].ID.Hex()
+
+	t.Run("with missing arguments", func(t *testing.T) {
+		_, err := s.UndoProfileChange(context.Background(), &ProfileReference{Token: token})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("without change", func(t *testing.T) {
+		_, err := s.UndoProfileChange(context.Background(), &ProfileReference{Token: token, ProfileId: childID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "no profile change to undo")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("edit then undo", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil)
+		_, err := s.SaveProfile(context.Background(), &api.ProfileRequest{
+			Token:   token,
+			Profile: &api.Profile{Id: mainID, Alias: "overwritten", AvatarId: "dog"},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil)
+		resp, err := s.UndoProfileChange(context.Background(), &ProfileReference{Token: token, ProfileId: mainID})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		p := resp.Profiles[0]
+		if p.Alias != "main" || p.AvatarId != "cat" || p.ConsentConfirmedAt != 1000 || !p.MainProfile {
+			t.Errorf("profile not restored: %v", p)
+		}
+
+		// only the last change can be undone
+		_, err = s.UndoProfileChange(context.Background(), &ProfileReference{Token: token, ProfileId: mainID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "no profile change to undo")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("after the undo window", func(t *testing.T) {
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		previous := user.Profiles[1]
+		user.Profiles[1].Alias = "renamed"
+		user.Profiles[1].KeepPreviousVersion(previous, time.Now().Unix()-profileUndoWindow-10)
+		if _, err := testUserDBService.UpdateUser(context.Background(), testInstanceID, user); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		_, err = s.UndoProfileChange(context.Background(), &ProfileReference{Token: token, ProfileId: childID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "no profile change to undo")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
+
+func TestUpdateContactPreferencesEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_update_contact_prefs@test.com",
+			},
+			ContactPreferences: models.ContactPreferences{
+				SubscribedToNewsletter: true,
+				SendNewsletterTo:       []string{"addr_id_1", "addr_id_2"},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.UpdateContactPreferences(context.Background(), nil)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with empty payload", func(t *testing.T) {
+		req := &api.ContactPreferencesMsg{}
+		_, err := s.UpdateContactPreferences(context.Background(), req)
 		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
 		if !ok {
 			t.Error(msg)
@@ -890,214 +2825,507 @@ func TestRemoveProfileEndpoint(t *testing.T) {
 		Id:         testUsers[0].ID.Hex(),
 		InstanceId: testInstanceID,
 	}
-	t.Run("with wrong id", func(t *testing.T) {
-		req := &api.ProfileRequest{
+
+	t.Run("update address list and subscription", func(t *testing.T) {
+		req := &api.ContactPreferencesMsg{
 			Token: &token,
-			Profile: &api.Profile{
-				Id: "wrong id",
+			ContactPreferences: &api.ContactPreferences{
+				SubscribedToNewsletter: false,
+				SendNewsletterTo:       []string{"only_here_id"},
 			},
 		}
-		_, err := s.RemoveProfile(context.Background(), req)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "profile with given ID not found")
+		resp, err := s.UpdateContactPreferences(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(resp.ContactPreferences.SendNewsletterTo) != 1 || resp.ContactPreferences.SubscribedToNewsletter {
+			t.Errorf("wrong response: %s", resp)
+		}
+	})
+
+	t.Run("set unknown timezone", func(t *testing.T) {
+		_, err := s.SetTimezone(context.Background(), &TimezoneMsg{Token: &token, Timezone: "Europe/Nowhere"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "unknown timezone")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("timezone is kept when updating contact preferences", func(t *testing.T) {
+		_, err := s.SetTimezone(context.Background(), &TimezoneMsg{Token: &token, Timezone: "Europe/Berlin"})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		req := &api.ContactPreferencesMsg{
+			Token: &token,
+			ContactPreferences: &api.ContactPreferences{
+				SubscribedToWeekly:            true,
+				ReceiveWeeklyMessageDayOfWeek: 2,
+			},
+		}
+		_, err = s.UpdateContactPreferences(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.ContactPreferences.Timezone != "Europe/Berlin" || user.ContactPreferences.ReceiveWeeklyMessageDayOfWeek != 2 {
+			t.Errorf("unexpected contact preferences: %v", user.ContactPreferences)
+		}
+	})
+
+	t.Run("set invalid quiet hours", func(t *testing.T) {
+		_, err := s.SetQuietHours(context.Background(), &QuietHoursMsg{Token: &token, QuietHours: &models.QuietHours{Start: "22:00", End: "7"}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "time of day must be formatted as HH:MM")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("set quiet hours", func(t *testing.T) {
+		_, err := s.SetQuietHours(context.Background(), &QuietHoursMsg{Token: &token, QuietHours: &models.QuietHours{Start: "22:00", End: "07:00"}})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.ContactPreferences.QuietHours == nil || user.ContactPreferences.QuietHours.Start != "22:00" || user.ContactPreferences.Timezone != "Europe/Berlin" {
+			t.Errorf("unexpected contact preferences: %v", user.ContactPreferences)
+		}
+	})
+}
+
+func TestUseUnsubscribeTokenEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_unsubscribe_for_newletter@test.com",
+			},
+			ContactPreferences: models.ContactPreferences{
+				SubscribedToNewsletter: true,
+			},
+			ContactInfos: []models.ContactInfo{
+				{
+					ID:          primitive.NewObjectID(),
+					Type:        "email",
+					Email:       "test_unsubscribe_for_newletter@test.com",
+					ConfirmedAt: time.Now().Unix(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+
+	unsubscribeTokenInfos := models.TempToken{
+		InstanceID: testInstanceID,
+		UserID:     testUsers[0].ID.Hex(),
+		Purpose:    "unsubscribe-newsletter",
+		Expiration: time.Now().Unix() + 5000000,
+	}
+	unsubscribeToken, err := s.globalDBService.AddTempToken(unsubscribeTokenInfos)
+	if err != nil {
+		t.Errorf("failed to create test token: %s", err.Error())
+		return
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.UseUnsubscribeToken(context.Background(), nil)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with empty payload", func(t *testing.T) {
+		req := &api.TempToken{}
+		_, err := s.UseUnsubscribeToken(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with wrong token", func(t *testing.T) {
+		req := &api.TempToken{
+			Token: "wrong",
+		}
+		_, err := s.UseUnsubscribeToken(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "wrong token")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with valid token", func(t *testing.T) {
+		req := &api.TempToken{
+			Token: unsubscribeToken,
+		}
+		_, err := s.UseUnsubscribeToken(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+
+		user, err := s.userDBservice.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
+		if err != nil {
+			t.Errorf("unexpected token: %v", err)
+			return
+		}
+		if user.ContactPreferences.SubscribedToNewsletter || user.ContactPreferences.IsSubscribedToTopic(models.TOPIC_NEWSLETTER) {
+			t.Error("should be unsubscribed")
+		}
+	})
+
+	t.Run("with token for a topic", func(t *testing.T) {
+		token := &api_types.TokenInfos{Id: testUsers[0].ID.Hex(), InstanceId: testInstanceID}
+		for _, topic := range []string{models.TOPIC_NEWSLETTER, models.TOPIC_SURVEYS, models.TOPIC_STUDY_REMINDERS} {
+			if _, err := s.UpdateTopicSubscription(context.Background(), &TopicSubscriptionMsg{Token: token, Topic: topic, Subscribed: true}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+		}
+
+		topicToken, err := s.globalDBService.AddTempToken(models.TempToken{
+			InstanceID: testInstanceID,
+			UserID:     testUsers[0].ID.Hex(),
+			Purpose:    "unsubscribe-newsletter",
+			Info:       map[string]string{"topic": models.TOPIC_SURVEYS},
+			Expiration: time.Now().Unix() + 5000000,
+		})
+		if err != nil {
+			t.Errorf("failed to create test token: %s", err.Error())
+			return
+		}
+		if _, err := s.UseUnsubscribeToken(context.Background(), &api.TempToken{Token: topicToken}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+
+		user, err := s.userDBservice.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		prefs := user.ContactPreferences
+		if prefs.IsSubscribedToTopic(models.TOPIC_SURVEYS) {
+			t.Error("should be unsubscribed from surveys")
+		}
+		if !prefs.IsSubscribedToTopic(models.TOPIC_STUDY_REMINDERS) || !prefs.IsSubscribedToTopic(models.TOPIC_NEWSLETTER) || !prefs.SubscribedToNewsletter {
+			t.Errorf("other topics should stay subscribed: %v", prefs)
+		}
+	})
+}
+
+func TestUseUnsubscribeAllTokenEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_unsubscribe_all@test.com",
+			},
+			ContactPreferences: models.ContactPreferences{
+				SubscribedToNewsletter: true,
+				SubscribedToWeekly:     true,
+				SubscribedTopics: map[string]bool{
+					models.TOPIC_NEWSLETTER:      true,
+					models.TOPIC_STUDY_REMINDERS: true,
+					models.TOPIC_SURVEYS:         true,
+				},
+			},
+			ContactInfos: []models.ContactInfo{
+				{
+					ID:          primitive.NewObjectID(),
+					Type:        "email",
+					Email:       "test_unsubscribe_all@test.com",
+					ConfirmedAt: time.Now().Unix(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+
+	newsletterToken, err := s.globalDBService.AddTempToken(models.TempToken{
+		InstanceID: testInstanceID,
+		UserID:     testUsers[0].ID.Hex(),
+		Purpose:    "unsubscribe-newsletter",
+		Expiration: time.Now().Unix() + 5000000,
+	})
+	if err != nil {
+		t.Errorf("failed to create test token: %s", err.Error())
+		return
+	}
+	unsubscribeAllToken, err := s.globalDBService.AddTempToken(models.TempToken{
+		InstanceID: testInstanceID,
+		UserID:     testUsers[0].ID.Hex(),
+		Purpose:    models.TOKEN_PURPOSE_UNSUBSCRIBE_ALL,
+		Expiration: time.Now().Unix() + 5000000,
+	})
+	if err != nil {
+		t.Errorf("failed to create test token: %s", err.Error())
+		return
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.UseUnsubscribeAllToken(context.Background(), nil)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	t.Run("with correct id", func(t *testing.T) {
-		mockLoggingClient.EXPECT().SaveLogEvent(
-			gomock.Any(),
-			gomock.Any(),
-		).Return(nil, nil)
+	t.Run("with newsletter token", func(t *testing.T) {
+		_, err := s.UseUnsubscribeAllToken(context.Background(), &api.TempToken{Token: newsletterToken})
+		if err == nil {
+			t.Error("should fail with a token of another purpose")
+		}
+	})
 
-		req := &api.ProfileRequest{
-			Token: &token,
-			Profile: &api.Profile{
-				Id: testUsers[0].Profiles[0].ID.Hex(),
-			},
+	t.Run("with valid token", func(t *testing.T) {
+		_, err := s.UseUnsubscribeAllToken(context.Background(), &api.TempToken{Token: unsubscribeAllToken})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
 		}
-		resp, err := s.RemoveProfile(context.Background(), req)
+
+		user, err := s.userDBservice.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
 		if err != nil {
-			t.Errorf("unexpected error: %s", err.Error())
+			t.Errorf("unexpected error: %v", err)
 			return
 		}
-		if len(resp.Profiles) != 1 || resp.Profiles[0].Alias == "main" {
-			t.Errorf("wrong response: %s", resp)
+		for _, topic := range []string{models.TOPIC_NEWSLETTER, models.TOPIC_STUDY_REMINDERS, models.TOPIC_SURVEYS} {
+			if user.ContactPreferences.IsSubscribedToTopic(topic) {
+				t.Errorf("should be unsubscribed from %s", topic)
+			}
 		}
-	})
-
-	t.Run("last one", func(t *testing.T) {
-		req := &api.ProfileRequest{
-			Token: &token,
-			Profile: &api.Profile{
-				Id: testUsers[0].Profiles[1].ID.Hex(),
-			},
+		if user.ContactPreferences.SubscribedToNewsletter || user.ContactPreferences.SubscribedToWeekly {
+			t.Errorf("unexpected contact preferences: %v", user.ContactPreferences)
 		}
-		_, err := s.RemoveProfile(context.Background(), req)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "can't delete last profile")
-		if !ok {
-			t.Error(msg)
+		// transactional emails are sent to the account and confirmed contacts
+		if notificationEmail := user.NotificationEmail(); notificationEmail != "test_unsubscribe_all@test.com" || !user.IsEmailConfirmed(notificationEmail) {
+			t.Errorf("transactional emails should still be delivered: %v", user.ContactInfos)
 		}
 	})
 }
 
-func TestUpdateContactPreferencesEndpoint(t *testing.T) {
+func TestNewsletterDoubleOptIn(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
 		globalDBService: testGlobalDBService,
-		Intervals: models.Intervals{
-			TokenExpiryInterval:      time.Second * 2,
-			VerificationCodeLifetime: 60,
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
 		},
 	}
-	testUsers, err := addTestUsers([]models.User{
-		{
-			Account: models.Account{
-				Type:      "email",
-				AccountID: "test_for_update_contact_prefs@test.com",
-			},
-			ContactPreferences: models.ContactPreferences{
-				SubscribedToNewsletter: true,
-				SendNewsletterTo:       []string{"addr_id_1", "addr_id_2"},
-			},
+
+	instanceID := testInstanceID + "_double_opt_in"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testUserDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err := testGlobalDBService.SaveInstanceConfig(instanceID, models.InstanceConfig{NewsletterDoubleOptIn: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	userID, err := testUserDBService.AddUser(context.Background(), instanceID, models.User{
+		Account: models.Account{
+			Type:              "email",
+			AccountID:         "test_double_opt_in@test.com",
+			PreferredLanguage: "en",
 		},
 	})
 	if err != nil {
-		t.Errorf("failed to create testusers: %s", err.Error())
+		t.Errorf("failed to create testuser: %s", err.Error())
 		return
 	}
+	token := api_types.TokenInfos{Id: userID, InstanceId: instanceID}
 
-	t.Run("without payload", func(t *testing.T) {
-		_, err := s.UpdateContactPreferences(context.Background(), nil)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+	var sentEmail *messageAPI.SendEmailReq
+	t.Run("subscription is pending until confirmed", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			sentEmail = req
+			return nil, nil
+		})
+
+		resp, err := s.UpdateContactPreferences(context.Background(), &api.ContactPreferencesMsg{
+			Token: &token,
+			ContactPreferences: &api.ContactPreferences{
+				SubscribedToNewsletter: true,
+				SubscribedToWeekly:     true,
+			},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if resp.ContactPreferences.SubscribedToNewsletter || !resp.ContactPreferences.SubscribedToWeekly {
+			t.Errorf("newsletter subscription should be pending: %v", resp.ContactPreferences)
+		}
+		if sentEmail == nil || sentEmail.MessageType != models.EMAIL_TYPE_NEWSLETTER_CONFIRMATION || sentEmail.To[0] != "test_double_opt_in@test.com" || sentEmail.ContentInfos["token"] == "" {
+			t.Errorf("unexpected confirmation email: %v", sentEmail)
+		}
+	})
+
+	t.Run("with wrong token", func(t *testing.T) {
+		_, err := s.ConfirmNewsletterSubscription(context.Background(), &api.TempToken{Token: "wrong"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "wrong token")
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	t.Run("with empty payload", func(t *testing.T) {
-		req := &api.ContactPreferencesMsg{}
-		_, err := s.UpdateContactPreferences(context.Background(), req)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+	t.Run("confirm subscription", func(t *testing.T) {
+		if sentEmail == nil {
+			t.Error("no confirmation email")
+			return
+		}
+		_, err := s.ConfirmNewsletterSubscription(context.Background(), &api.TempToken{Token: sentEmail.ContentInfos["token"]})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), instanceID, userID)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if !user.ContactPreferences.SubscribedToNewsletter || !user.ContactPreferences.IsSubscribedToTopic(models.TOPIC_NEWSLETTER) {
+			t.Errorf("newsletter subscription should be active: %v", user.ContactPreferences)
+		}
+
+		_, err = s.ConfirmNewsletterSubscription(context.Background(), &api.TempToken{Token: sentEmail.ContentInfos["token"]})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "wrong token")
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	token := api_types.TokenInfos{
-		Id:         testUsers[0].ID.Hex(),
-		InstanceId: testInstanceID,
-	}
-
-	t.Run("update address list and subscription", func(t *testing.T) {
-		req := &api.ContactPreferencesMsg{
+	t.Run("no confirmation when already subscribed", func(t *testing.T) {
+		resp, err := s.UpdateContactPreferences(context.Background(), &api.ContactPreferencesMsg{
 			Token: &token,
 			ContactPreferences: &api.ContactPreferences{
-				SubscribedToNewsletter: false,
-				SendNewsletterTo:       []string{"only_here_id"},
+				SubscribedToNewsletter: true,
 			},
-		}
-		resp, err := s.UpdateContactPreferences(context.Background(), req)
+		})
 		if err != nil {
-			t.Errorf("unexpected error: %s", err.Error())
+			t.Errorf("unexpected error: %v", err)
 			return
 		}
-		if len(resp.ContactPreferences.SendNewsletterTo) != 1 || resp.ContactPreferences.SubscribedToNewsletter {
-			t.Errorf("wrong response: %s", resp)
+		if !resp.ContactPreferences.SubscribedToNewsletter {
+			t.Error("should stay subscribed")
 		}
 	})
 }
 
-func TestUseUnsubscribeTokenEndpoint(t *testing.T) {
+func TestUpdateTopicSubscriptionEndpoint(t *testing.T) {
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
 		globalDBService: testGlobalDBService,
-		Intervals: models.Intervals{
-			TokenExpiryInterval:      time.Second * 2,
-			VerificationCodeLifetime: 60,
-		},
 	}
 	testUsers, err := addTestUsers([]models.User{
 		{
 			Account: models.Account{
 				Type:      "email",
-				AccountID: "test_unsubscribe_for_newletter@test.com",
-			},
-			ContactPreferences: models.ContactPreferences{
-				SubscribedToNewsletter: true,
-			},
-			ContactInfos: []models.ContactInfo{
-				{
-					ID:          primitive.NewObjectID(),
-					Type:        "email",
-					Email:       "test_unsubscribe_for_newletter@test.com",
-					ConfirmedAt: time.Now().Unix(),
-				},
+				AccountID: "test_topic_subscription@test.com",
 			},
+			ContactPreferences: models.ContactPreferences{SubscribedToNewsletter: true},
 		},
 	})
 	if err != nil {
 		t.Errorf("failed to create testusers: %s", err.Error())
 		return
 	}
+	token := &api_types.TokenInfos{Id: testUsers[0].ID.Hex(), InstanceId: testInstanceID}
 
-	unsubscribeTokenInfos := models.TempToken{
-		InstanceID: testInstanceID,
-		UserID:     testUsers[0].ID.Hex(),
-		Purpose:    "unsubscribe-newsletter",
-		Expiration: time.Now().Unix() + 5000000,
-	}
-	unsubscribeToken, err := s.globalDBService.AddTempToken(unsubscribeTokenInfos)
-	if err != nil {
-		t.Errorf("failed to create test token: %s", err.Error())
-		return
-	}
-
-	t.Run("without payload", func(t *testing.T) {
-		_, err := s.UseUnsubscribeToken(context.Background(), nil)
+	t.Run("without topic", func(t *testing.T) {
+		_, err := s.UpdateTopicSubscription(context.Background(), &TopicSubscriptionMsg{Token: token, Subscribed: true})
 		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	t.Run("with empty payload", func(t *testing.T) {
-		req := &api.TempToken{}
-		_, err := s.UseUnsubscribeToken(context.Background(), req)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+	t.Run("with invalid topic", func(t *testing.T) {
+		_, err := s.UpdateTopicSubscription(context.Background(), &TopicSubscriptionMsg{Token: token, Topic: "news.letter", Subscribed: true})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid topic")
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	t.Run("with wrong token", func(t *testing.T) {
-		req := &api.TempToken{
-			Token: "wrong",
+	t.Run("subscribe to a topic", func(t *testing.T) {
+		resp, err := s.UpdateTopicSubscription(context.Background(), &TopicSubscriptionMsg{Token: token, Topic: models.TOPIC_STUDY_REMINDERS, Subscribed: true})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
 		}
-		_, err := s.UseUnsubscribeToken(context.Background(), req)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "wrong token")
-		if !ok {
-			t.Error(msg)
+		if !resp.ContactPreferences.SubscribedToNewsletter {
+			t.Error("newsletter subscription should be kept")
+		}
+		user, err := s.userDBservice.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if !user.ContactPreferences.IsSubscribedToTopic(models.TOPIC_STUDY_REMINDERS) || !user.ContactPreferences.IsSubscribedToTopic(models.TOPIC_NEWSLETTER) {
+			t.Errorf("unexpected topics: %v", user.ContactPreferences.SubscribedTopics)
 		}
 	})
 
-	t.Run("with valid token", func(t *testing.T) {
-		req := &api.TempToken{
-			Token: unsubscribeToken,
-		}
-		_, err := s.UseUnsubscribeToken(context.Background(), req)
+	t.Run("unsubscribe from the newsletter topic", func(t *testing.T) {
+		resp, err := s.UpdateTopicSubscription(context.Background(), &TopicSubscriptionMsg{Token: token, Topic: models.TOPIC_NEWSLETTER})
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
 		}
-
-		user, err := s.userDBservice.GetUserByID(testInstanceID, testUsers[0].ID.Hex())
+		if resp.ContactPreferences.SubscribedToNewsletter {
+			t.Error("should be unsubscribed from the newsletter")
+		}
+		user, err := s.userDBservice.GetUserByID(context.Background(), testInstanceID, token.Id)
 		if err != nil {
-			t.Errorf("unexpected token: %v", err)
+			t.Errorf("unexpected error: %v", err)
 			return
 		}
-		if user.ContactPreferences.SubscribedToNewsletter {
-			t.Error("should be unsubscribed")
+		if user.ContactPreferences.IsSubscribedToTopic(models.TOPIC_NEWSLETTER) || !user.ContactPreferences.IsSubscribedToTopic(models.TOPIC_STUDY_REMINDERS) {
+			t.Errorf("unexpected topics: %v", user.ContactPreferences.SubscribedTopics)
 		}
 	})
 }
@@ -1309,3 +3537,119 @@ func TestRemoveEmailEndpoint(t *testing.T) {
 		}
 	})
 }
+
+func TestSetPrimaryEmailEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_primary_email@test.com",
+			},
+			ContactInfos: []models.ContactInfo{
+				{
+					ID:          primitive.NewObjectID(),
+					Type:        "email",
+					Email:       "test_for_primary_email@test.com",
+					ConfirmedAt: time.Now().Unix(),
+				},
+				{
+					ID:          primitive.NewObjectID(),
+					Type:        "email",
+					Email:       "test_for_primary_email1@test.com",
+					ConfirmedAt: time.Now().Unix(),
+				},
+				{
+					ID:          primitive.NewObjectID(),
+					Type:        "email",
+					Email:       "test_for_primary_email2@test.com",
+					ConfirmedAt: time.Now().Unix(),
+				},
+				{
+					ID:    primitive.NewObjectID(),
+					Type:  "email",
+					Email: "test_for_primary_email_unconfirmed@test.com",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	token := &api_types.TokenInfos{
+		Id:         testUsers[0].ID.Hex(),
+		InstanceId: testInstanceID,
+	}
+	contactInfos := testUsers[0].ContactInfos
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.SetPrimaryEmail(context.Background(), nil)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with unconfirmed address", func(t *testing.T) {
+		_, err := s.SetPrimaryEmail(context.Background(), &api.ContactInfoMsg{
+			Token:       token,
+			ContactInfo: contactInfos[3].ToAPI(),
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "contact not confirmed")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	checkPrimary := func(expected models.ContactInfo) {
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, token.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		primaryCount := 0
+		for _, ci := range user.ContactInfos {
+			if ci.Primary {
+				primaryCount++
+			}
+		}
+		if primaryCount != 1 {
+			t.Errorf("expected exactly one primary address, got %d", primaryCount)
+		}
+		if user.NotificationEmail() != expected.Email {
+			t.Errorf("unexpected notification email: %s", user.NotificationEmail())
+		}
+	}
+
+	t.Run("set primary", func(t *testing.T) {
+		_, err := s.SetPrimaryEmail(context.Background(), &api.ContactInfoMsg{
+			Token:       token,
+			ContactInfo: contactInfos[1].ToAPI(),
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		checkPrimary(contactInfos[1])
+	})
+
+	t.Run("change primary", func(t *testing.T) {
+		_, err := s.SetPrimaryEmail(context.Background(), &api.ContactInfoMsg{
+			Token:       token,
+			ContactInfo: contactInfos[2].ToAPI(),
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		checkPrimary(contactInfos[2])
+	})
+}
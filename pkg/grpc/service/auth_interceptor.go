@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/influenzanet/go-utils/pkg/api_types"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/dbs/userdb"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// metadata key of the access token, as "Bearer <jwt>"
+const authorizationMetadataKey = "authorization"
+
+type tokenInfosContextKey struct{}
+
+// requests of the endpoints requiring an access token carry the token infos
+type tokenInfosRequest interface {
+	GetToken() *api_types.TokenInfos
+}
+
+// TokenInfosFromContext returns the token infos verified by the auth interceptor, nil if the call went
+// through without (public method or request without token infos).
+func TokenInfosFromContext(ctx context.Context) *api_types.TokenInfos {
+	tokenInfos, _ := ctx.Value(tokenInfosContextKey{}).(*api_types.TokenInfos)
+	return tokenInfos
+}
+
+// NewAuthUnaryInterceptor returns an interceptor validating the access token of the calls to endpoints
+// requiring one, before they reach the handler. The token infos of the request are replaced by the ones of
+// the verified token, so that handlers do not rely on what the caller sent. Methods in publicMethods (short
+// names, e.g. "LoginWithEmail") are not checked, defaultPublicMethods are used if empty.
+func NewAuthUnaryInterceptor(userDBservice *userdb.UserDBService, publicMethods map[string]bool) grpc.UnaryServerInterceptor {
+	if len(publicMethods) == 0 {
+		publicMethods = map[string]bool{}
+		for _, m := range defaultPublicMethods {
+			publicMethods[m] = true
+		}
+	}
+	s := &userManagementServer{userDBservice: userDBservice}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if publicMethods[info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]] {
+			return handler(ctx, req)
+		}
+		if _, ok := req.(tokenInfosRequest); !ok {
+			// authenticated by other means, e.g. temp token or refresh token
+			return handler(ctx, req)
+		}
+
+		jwt := accessTokenFromMetadata(ctx)
+		if jwt == "" {
+			return nil, errorWithCode(codes.Unauthenticated, "missing access token", models.ERROR_CODE_UNAUTHENTICATED)
+		}
+		tokenInfos, err := s.ValidateJWT(ctx, &api.JWTRequest{Token: jwt})
+		if err != nil {
+			return nil, errorWithCode(codes.Unauthenticated, "invalid token", models.ERROR_CODE_UNAUTHENTICATED)
+		}
+
+		setRequestTokenInfos(req, tokenInfos)
+		return handler(context.WithValue(ctx, tokenInfosContextKey{}, tokenInfos), req)
+	}
+}
+
+func accessTokenFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, v := range md.Get(authorizationMetadataKey) {
+		if strings.HasPrefix(v, "Bearer ") {
+			return strings.TrimSpace(strings.TrimPrefix(v, "Bearer "))
+		}
+	}
+	return ""
+}
+
+// setRequestTokenInfos overwrites the "token" field of the request with the verified token infos
+func setRequestTokenInfos(req interface{}, tokenInfos *api_types.TokenInfos) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return
+	}
+	m := msg.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName("token")
+	if fd == nil || fd.Message() == nil || fd.Message().FullName() != tokenInfos.ProtoReflect().Descriptor().FullName() {
+		return
+	}
+	m.Set(fd, protoreflect.ValueOfMessage(tokenInfos.ProtoReflect()))
+}
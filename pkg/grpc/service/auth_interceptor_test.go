@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/influenzanet/go-utils/pkg/api_types"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthUnaryInterceptor(t *testing.T) {
+	interceptor := NewAuthUnaryInterceptor(testUserDBService, nil)
+
+	userToken, err := tokens.GenerateNewToken("test-user-id", true, "testprofid", []string{"PARTICIPANT"}, testInstanceID, time.Minute, "", nil, []string{}, nil)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	var handlerCalled bool
+	var handlerCtx context.Context
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCalled = true
+		handlerCtx = ctx
+		return "ok", nil
+	}
+	getUserInfo := &grpc.UnaryServerInfo{FullMethod: "/influenzanet.user_management_api.UserManagementApi/GetUser"}
+
+	t.Run("without access token", func(t *testing.T) {
+		handlerCalled = false
+		req := &api.UserReference{Token: &api_types.TokenInfos{Id: "test-user-id", InstanceId: testInstanceID}}
+		_, err := interceptor(context.Background(), req, getUserInfo, handler)
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("unexpected error: %v", err)
+		}
+		ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_UNAUTHENTICATED)
+		if !ok {
+			t.Error(msg)
+		}
+		if handlerCalled {
+			t.Error("handler should not be called")
+		}
+	})
+
+	t.Run("with wrong access token", func(t *testing.T) {
+		handlerCalled = false
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+userToken+"x"))
+		_, err := interceptor(ctx, &api.UserReference{}, getUserInfo, handler)
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if handlerCalled {
+			t.Error("handler should not be called")
+		}
+	})
+
+	t.Run("with valid access token", func(t *testing.T) {
+		handlerCalled = false
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+userToken))
+		// roles sent by the caller are not trusted
+		req := &api.UserReference{Token: &api_types.TokenInfos{Id: "other-id", Payload: map[string]string{"roles": "ADMIN"}}}
+		_, err := interceptor(ctx, req, getUserInfo, handler)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if !handlerCalled {
+			t.Error("handler should be called")
+			return
+		}
+		if req.Token.Id != "test-user-id" || tokens.IsAdmin(req.Token.Payload) {
+			t.Errorf("unexpected token infos in request: %s", req.Token)
+		}
+		tokenInfos := TokenInfosFromContext(handlerCtx)
+		if tokenInfos == nil || tokenInfos.Id != "test-user-id" || tokenInfos.InstanceId != testInstanceID {
+			t.Errorf("unexpected token infos in context: %s", tokenInfos)
+		}
+	})
+
+	t.Run("public method without access token", func(t *testing.T) {
+		handlerCalled = false
+		info := &grpc.UnaryServerInfo{FullMethod: "/influenzanet.user_management_api.UserManagementApi/LoginWithEmail"}
+		_, err := interceptor(context.Background(), &api.LoginWithEmailMsg{}, info, handler)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+		if !handlerCalled {
+			t.Error("handler should be called")
+		}
+	})
+}
@@ -1,17 +1,93 @@
 package service
 
+import (
+	"time"
+
+	"github.com/influenzanet/go-utils/pkg/constants"
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
 const (
 	contactVerificationMessageCooldown = 1 * 60 // Minimum delay between 2 verification code sending for a new contact, seconds
-	loginVerificationCodeCooldown      = 20 // Minimum delay between 2 verification code sending for a new login, in seconds
+	loginVerificationCodeCooldown      = 20 // Default minimum delay between 2 verification code sending for a new login, in seconds
 
 	// Window time period to count event and limit rrate
 	signupRateLimitWindow           = 5 * 60  // to count the new signup, seconds
 	loginFailedAttemptWindow        = 5 * 50  // to count the login failure, seconds
-	passwordResetAttemptWindow      = 60 * 60 // to count the password failure, in seconds, default=1 hour
+	passwordResetAttemptWindow      = 60 * 60 // to count the password reset requests, in seconds, used if not configured
+	allowedPasswordResetTriggers    = 5       // password reset emails within the window, used if not configured
+	passwordResetMinResponseTime    = 2       // seconds, InitiatePasswordReset answers not faster, whether the account exists or not
 	allowedPasswordAttempts         = 10
 	allowedVerificationCodeAttempts = 3
 
+	rateLimiterMaxKeys = 100000 // clients tracked by an in-memory rate limiter, the least recently seen one is dropped above
+
 	userCreationTimestampOffset = 7 * 24 * 3600 // consider user deletion only after this time, when created by admin
 
 	maximumProfilesAllowed = 6
+	profileUndoWindow      = 15 * 60 // seconds, the last change of a profile can be undone within this period
+
+	maxTopicLength = 64 // characters of a message topic name
+
+	maxTempTokenInfoEntries = 16   // entries of the info map of a temp token
+	maxTempTokenInfoSize    = 4096 // bytes, total length of the keys and values of the info map of a temp token
+
+	userStatsActiveWindow = 30 * 24 * 3600 // seconds, users who logged in within this window count as active
+
+	newsletterConfirmationTokenLifetime = 7 * 24 * time.Hour
+
+	readinessCheckTimeout = 2 // seconds, to reach the DBs in the readiness check
+
+	instanceConfigCacheTTL     = 60 // seconds, instance settings changes apply after this delay
+	instanceIDsRefreshInterval = 60 // seconds, instances added directly in the DB are accepted after this delay
+
+	defaultLoginHistorySize = 10 // logins kept per user, used if not configured
+
+	defaultTempTokenCleanupMinInterval = 10 * time.Minute // used if not configured
+	defaultExpiredTempTokenRetention   = time.Hour        // used if not configured
+
+	impersonationTokenLifetime = 15 * time.Minute // no refresh token is issued for impersonation
+
+	idempotencyKeyTTL       = 10 * time.Minute // a retry with the same key within this time gets the first response
+	maxIdempotencyKeyLength = 128
+
+	maxAuditLogEvents = 1000 // returned by GetUserAuditLog
+
+	rememberMeMetadataKey = "remember-me" // request metadata of logins asking for the longer refresh token lifetime
+	birthdateMetadataKey  = "birthdate"   // request metadata of signups with the birthdate of the account holder
+	profileMetadataKey    = "profile-id"  // request metadata of token renewals selecting the profile of the new token
 )
+
+// roles that can be assigned to a user through the service
+var knownUserRoles = []string{
+	constants.USER_ROLE_PARTICIPANT,
+	constants.USER_ROLE_RESEARCHER,
+	constants.USER_ROLE_ADMIN,
+	constants.USER_ROLE_SERVICE_ACCOUNT,
+}
+
+// methods callable without access token when the auth interceptor is enabled, used if not configured
+var defaultPublicMethods = []string{
+	"Status",
+	"LoginWithEmail",
+	"LoginWithExternalIDP",
+	"SignupWithEmail",
+	"InitiatePasswordReset",
+	"GetInfosForPasswordReset",
+	"ResetPassword",
+	"RenewJWT",
+	"ValidateJWT",
+}
+
+// purposes of the temp tokens created by this service or requested by the other services
+var knownTempTokenPurposes = []string{
+	constants.TOKEN_PURPOSE_INVITATION,
+	constants.TOKEN_PURPOSE_PASSWORD_RESET,
+	constants.TOKEN_PURPOSE_CONTACT_VERIFICATION,
+	constants.TOKEN_PURPOSE_SURVEY_LOGIN,
+	constants.TOKEN_PURPOSE_UNSUBSCRIBE_NEWSLETTER,
+	constants.TOKEN_PURPOSE_RESTORE_ACCOUNT_ID,
+	constants.TOKEN_PURPOSE_INACTIVE_USER_NOTIFICATION,
+	models.TOKEN_PURPOSE_UNSUBSCRIBE_ALL,
+	models.TOKEN_PURPOSE_NEWSLETTER_CONFIRMATION,
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/coneno/logger"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
+// sendEmailWithRetry sends an email that must not be lost. If the messaging service fails, the email is queued for the
+// retry job of the timer service instead, the response of the endpoint doesn't depend on it.
+func (s *userManagementServer) sendEmailWithRetry(ctx context.Context, caller string, req *messageAPI.SendEmailReq) {
+	err := s.sendInstantEmail(ctx, req)
+	if err == nil {
+		return
+	}
+	logger.Error.Printf("%s: %s", caller, err.Error())
+
+	now := time.Now().Unix()
+	if err := s.globalDBService.AddEmailRetry(models.EmailRetryFromRequest(req, err, now, now)); err != nil {
+		logger.Error.Printf("%s: failed to queue the email for retry: %v", caller, err)
+	}
+}
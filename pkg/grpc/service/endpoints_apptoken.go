@@ -2,8 +2,14 @@ package service
 
 import (
 	"context"
+	"strings"
 
+	"github.com/coneno/logger"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"github.com/influenzanet/user-management-service/pkg/utils"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -20,3 +26,89 @@ func (s *userManagementServer) ValidateAppToken(ctx context.Context, req *api.Ap
 		Instances: tokenInfos.Instances,
 	}, nil
 }
+
+// ValidateAppTokenScopes validates the app token like ValidateAppToken and returns the granted scopes as well,
+// so that callers can check them with utils.HasScope
+func (s *userManagementServer) ValidateAppTokenScopes(ctx context.Context, req *api.AppTokenRequest) (*models.AppTokenGrant, error) {
+	if req == nil || req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid app token")
+	}
+	tokenInfos, err := s.globalDBService.FindAppToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid app token")
+	}
+	scopes := tokenInfos.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return &models.AppTokenGrant{
+		Instances: tokenInfos.Instances,
+		Scopes:    scopes,
+	}, nil
+}
+
+// CreateAppToken creates a new app token scoped to the admin's instance with the granted scopes. expiresAt is optional (0 means no expiration).
+// The returned token is only available at this point, it is stored hashed.
+func (s *userManagementServer) CreateAppToken(ctx context.Context, req *CreateAppTokenReq) (*CreateAppTokenResponse, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.AppName == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	appToken, err := s.globalDBService.CreateAppToken(models.AppToken{
+		AppName:   req.AppName,
+		Instances: []string{req.Token.InstanceId},
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		logger.Error.Printf("CreateAppToken: %v", err)
+		return nil, status.Error(codes.Internal, "token generation error")
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_APP_TOKEN_CREATED, req.AppName+" ("+strings.Join(req.Scopes, ",")+")")
+	return &CreateAppTokenResponse{AppToken: appToken}, nil
+}
+
+func (s *userManagementServer) ListAppTokens(ctx context.Context, req *api.UserReference) (*AppTokenList, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	appTokens, err := s.globalDBService.FindAppTokensForInstance(req.Token.InstanceId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &AppTokenList{AppTokens: make([]*models.AppToken, len(appTokens))}
+	for i := range appTokens {
+		// never expose plain text tokens of legacy entries
+		appTokens[i].Tokens = nil
+		resp.AppTokens[i] = &appTokens[i]
+	}
+	return resp, nil
+}
+
+func (s *userManagementServer) RevokeAppToken(ctx context.Context, req *AppTokenReference) (*api.ServiceStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.AppTokenId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	if err := s.globalDBService.DeleteAppToken(req.Token.InstanceId, req.AppTokenId); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "app token not found")
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_APP_TOKEN_REVOKED, req.AppTokenId)
+	return &api.ServiceStatus{
+		Status:  api.ServiceStatus_NORMAL,
+		Msg:     "app token revoked",
+		Version: apiVersion,
+	}, nil
+}
@@ -5,8 +5,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang/mock/gomock"
+	api_types "github.com/influenzanet/go-utils/pkg/api_types"
 	"github.com/influenzanet/user-management-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/utils"
+	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
 	"google.golang.org/grpc/status"
 )
 
@@ -79,3 +83,113 @@ func TestValidateAppTokenEndpoint(t *testing.T) {
 		}
 	})
 }
+
+func TestAppTokenManagementEndpoints(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+	adminToken := &api_types.TokenInfos{
+		Id:         "test-admin-id",
+		InstanceId: testInstanceID,
+		Payload: map[string]string{
+			"roles": "PARTICIPANT,ADMIN",
+		},
+	}
+
+	t.Run("create with non admin user", func(t *testing.T) {
+		_, err := s.CreateAppToken(context.Background(), &CreateAppTokenReq{Token: &api_types.TokenInfos{
+			Id:         "test-user-id",
+			InstanceId: testInstanceID,
+			Payload: map[string]string{
+				"roles": "PARTICIPANT",
+			},
+		}, AppName: "testapp-managed", Scopes: []string{"users:read"}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	var appToken string
+	t.Run("create app token", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		created, err := s.CreateAppToken(context.Background(), &CreateAppTokenReq{Token: adminToken, AppName: "testapp-managed", Scopes: []string{"users:read"}})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		appToken = created.AppToken
+		resp, err := s.ValidateAppToken(context.Background(), &api.AppTokenRequest{Token: appToken})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(resp.Instances) != 1 || resp.Instances[0] != testInstanceID {
+			t.Errorf("unexpected response: %s", resp)
+		}
+	})
+
+	t.Run("validate scopes", func(t *testing.T) {
+		grant, err := s.ValidateAppTokenScopes(context.Background(), &api.AppTokenRequest{Token: appToken})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(grant.Scopes) != 1 || !utils.HasScope(grant.Scopes, "users:read") {
+			t.Errorf("unexpected scopes: %v", grant.Scopes)
+		}
+		if utils.HasScope(grant.Scopes, "users:delete") {
+			t.Error("operation outside of the token's scopes should be rejected")
+		}
+	})
+
+	var appTokenID string
+	t.Run("list app tokens", func(t *testing.T) {
+		appTokens, err := s.ListAppTokens(context.Background(), &api.UserReference{Token: adminToken})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		for _, at := range appTokens.AppTokens {
+			if len(at.Tokens) > 0 {
+				t.Error("plain tokens should not be listed")
+			}
+			if at.AppName == "testapp-managed" {
+				appTokenID = at.ID.Hex()
+			}
+		}
+		if appTokenID == "" {
+			t.Error("created app token not listed")
+		}
+	})
+
+	t.Run("revoke app token", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		_, err := s.RevokeAppToken(context.Background(), &AppTokenReference{Token: adminToken, AppTokenId: appTokenID})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		_, err = s.ValidateAppToken(context.Background(), &api.AppTokenRequest{Token: appToken})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid app token")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
 	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
@@ -33,9 +34,29 @@ func (s *userManagementServer) Status(ctx context.Context, _ *empty.Empty) (*api
 	}, nil
 }
 
+// Readiness checks that the service can serve requests: unlike Status, both DBs are pinged
+func (s *userManagementServer) Readiness(ctx context.Context, _ *empty.Empty) (*api.ServiceStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout*time.Second)
+	defer cancel()
+
+	if err := s.userDBservice.Ping(ctx); err != nil {
+		logger.Error.Printf("Readiness: user DB not reachable: %v", err)
+		return nil, status.Error(codes.Unavailable, "user DB not reachable")
+	}
+	if err := s.globalDBService.Ping(ctx); err != nil {
+		logger.Error.Printf("Readiness: global DB not reachable: %v", err)
+		return nil, status.Error(codes.Unavailable, "global DB not reachable")
+	}
+	return &api.ServiceStatus{
+		Status:  api.ServiceStatus_NORMAL,
+		Msg:     "service ready",
+		Version: apiVersion,
+	}, nil
+}
+
 func (s *userManagementServer) SendVerificationCode(ctx context.Context, req *api.SendVerificationCodeReq) (*api.ServiceStatus, error) {
 	if req == nil || req.Email == "" || req.Password == "" {
-		return nil, status.Error(codes.InvalidArgument, "invalid username and/or password")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid username and/or password", models.ERROR_CODE_INVALID_CREDENTIALS)
 	}
 
 	if req.InstanceId == "" {
@@ -48,36 +69,36 @@ func (s *userManagementServer) SendVerificationCode(ctx context.Context, req *ap
 	}
 
 	req.Email = utils.SanitizeEmail(req.Email)
-	user, err := s.userDBservice.GetUserByAccountID(req.InstanceId, req.Email)
+	user, err := s.userDBservice.GetUserByConfirmedEmail(ctx, req.InstanceId, req.Email)
 	if err != nil {
 		logger.Warning.Printf("SECURITY WARNING: login step 1 attempt with wrong email address for %s", req.Email)
-		return nil, status.Error(codes.InvalidArgument, "invalid username and/or password")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid username and/or password", models.ERROR_CODE_INVALID_CREDENTIALS)
 	}
 
 	if utils.HasMoreAttemptsRecently(user.Account.FailedLoginAttempts, allowedPasswordAttempts, loginFailedAttemptWindow) {
 		s.SaveLogEvent(req.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_LOGIN_ATTEMPT_ON_BLOCKED_ACCOUNT, "send verification code endpoint")
 		logger.Warning.Printf("SECURITY WARNING: login attempt blocked for email address for %s - too many wrong tries recently", user.ID.Hex())
 		time.Sleep(time.Duration(rand.Intn(10)) * time.Second)
-		return nil, status.Error(codes.InvalidArgument, "invalid username and/or password")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid username and/or password", models.ERROR_CODE_INVALID_CREDENTIALS)
 	}
 
-	if user.Account.VerificationCode.CreatedAt > time.Now().Unix()-loginVerificationCodeCooldown {
+	if s.isVerificationCodeCooldownActive(user.Account.VerificationCode) {
 		s.SaveLogEvent(req.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_LOGIN_ATTEMPT_ON_BLOCKED_ACCOUNT, "try resending verification code too often")
 		logger.Warning.Printf("SECURITY WARNING: resend verification code %s - too many wrong tries recently", req.Email)
-		return nil, status.Error(codes.InvalidArgument, "cannot generate verification code so often")
+		return nil, errorWithCode(codes.InvalidArgument, verificationCodeCooldownMsg, models.ERROR_CODE_VERIFICATION_CODE_COOLDOWN)
 	}
 
 	match, err := pwhash.ComparePasswordWithHash(user.Account.Password, req.Password)
 	if err != nil || !match {
 		logger.Warning.Printf("SECURITY WARNING: login step 1 attempt with wrong password for %s", user.ID.Hex())
-		if err2 := s.userDBservice.SaveFailedLoginAttempt(req.InstanceId, user.ID.Hex()); err != nil {
+		if err2 := s.userDBservice.SaveFailedLoginAttempt(ctx, req.InstanceId, user.ID.Hex()); err != nil {
 			logger.Error.Printf("DB ERROR: unexpected error when updating user: %s ", err2.Error())
 		}
 		s.SaveLogEvent(req.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_AUTH_WRONG_PASSWORD, "send verification code endpoint")
-		return nil, status.Error(codes.InvalidArgument, "invalid username and/or password")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid username and/or password", models.ERROR_CODE_INVALID_CREDENTIALS)
 	}
 
-	err = s.generateAndSendVerificationCode(req.InstanceId, user)
+	err = s.sendExistingOrNewVerificationCode(ctx, req.InstanceId, user)
 	if err != nil {
 		return nil, err
 	}
@@ -91,7 +112,7 @@ func (s *userManagementServer) SendVerificationCode(ctx context.Context, req *ap
 
 func (s *userManagementServer) AutoValidateTempToken(ctx context.Context, req *api.AutoValidateReq) (*api.AutoValidateResponse, error) {
 	if req == nil || req.TempToken == "" {
-		return nil, status.Error(codes.InvalidArgument, "invalid token")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid token", models.ERROR_CODE_INVALID_TOKEN)
 	}
 
 	tokenInfos, err := s.ValidateTempToken(req.TempToken,
@@ -112,10 +133,10 @@ func (s *userManagementServer) AutoValidateTempToken(ctx context.Context, req *a
 		} else {
 			logger.Warning.Printf("SECURITY WARNING: unexpected error for autovalidating temp token - by user %s in instance %s", tokenInfos.UserID, tokenInfos.InstanceID)
 		}
-		return nil, status.Error(codes.InvalidArgument, "invalid token")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid token", models.ERROR_CODE_INVALID_TOKEN)
 	}
 
-	user, err := s.userDBservice.GetUserByID(tokenInfos.InstanceID, tokenInfos.UserID)
+	user, err := s.userDBservice.GetUserByID(ctx, tokenInfos.InstanceID, tokenInfos.UserID)
 	if err != nil {
 		logger.Error.Printf("unexpected error when retrieving user: %v", err)
 		return nil, status.Error(codes.InvalidArgument, "user not found")
@@ -132,7 +153,7 @@ func (s *userManagementServer) AutoValidateTempToken(ctx context.Context, req *a
 		}
 	}
 
-	if user.Account.VerificationCode.ExpiresAt > time.Now().Unix()+loginVerificationCodeCooldown {
+	if user.Account.VerificationCode.ExpiresAt > time.Now().Unix()+s.verificationCodeResendCooldown() {
 		logger.Debug.Printf("AutoValidateTempToken: verification code re-used for %s", user.ID.Hex())
 		return &api.AutoValidateResponse{AccountId: user.Account.AccountID, IsSameUser: sameUser, VerificationCode: user.Account.VerificationCode.Code, InstanceId: tokenInfos.InstanceID}, nil
 	}
@@ -145,12 +166,12 @@ func (s *userManagementServer) AutoValidateTempToken(ctx context.Context, req *a
 
 	user.Account.VerificationCode = models.VerificationCode{
 		Code:      vc,
-		ExpiresAt: time.Now().Unix() + s.Intervals.VerificationCodeLifetime,
+		ExpiresAt: time.Now().Unix() + s.verificationCodeLifetime(tokenInfos.InstanceID),
 	}
-	user, err = s.userDBservice.UpdateUser(tokenInfos.InstanceID, user)
+	user, err = s.userDBservice.UpdateUser(ctx, tokenInfos.InstanceID, user)
 	if err != nil {
 		logger.Error.Printf("AutoValidateTempToken: unexpected error when saving user [%s] -> %v", user.ID.Hex(), err)
-		return nil, status.Error(codes.Internal, "user couldn't be updated")
+		return nil, userUpdateError(err, "user couldn't be updated")
 	}
 
 	if err := s.globalDBService.DeleteAllTempTokenForUser(tokenInfos.InstanceID, user.ID.Hex(), constants.TOKEN_PURPOSE_INVITATION); err != nil {
@@ -165,7 +186,7 @@ func (s *userManagementServer) AutoValidateTempToken(ctx context.Context, req *a
 
 func (s *userManagementServer) LoginWithEmail(ctx context.Context, req *api.LoginWithEmailMsg) (*api.LoginResponse, error) {
 	if req == nil || req.Email == "" || req.Password == "" {
-		return nil, status.Error(codes.InvalidArgument, "invalid username and/or password")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid username and/or password", models.ERROR_CODE_INVALID_CREDENTIALS)
 	}
 
 	if req.InstanceId == "" {
@@ -178,50 +199,50 @@ func (s *userManagementServer) LoginWithEmail(ctx context.Context, req *api.Logi
 	}
 
 	req.Email = utils.SanitizeEmail(req.Email)
-	user, err := s.userDBservice.GetUserByAccountID(req.InstanceId, req.Email)
+	user, err := s.userDBservice.GetUserByConfirmedEmail(ctx, req.InstanceId, req.Email)
 	if err != nil {
 		logger.Warning.Printf("SECURITY WARNING: login attempt with wrong email address for %s", req.Email)
 		s.SaveLogEvent(req.InstanceId, "", loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_AUTH_WRONG_ACCOUNT_ID, req.Email)
-		return nil, status.Error(codes.InvalidArgument, "invalid username and/or password")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid username and/or password", models.ERROR_CODE_INVALID_CREDENTIALS)
 	}
 
 	if utils.HasMoreAttemptsRecently(user.Account.FailedLoginAttempts, allowedPasswordAttempts, loginFailedAttemptWindow) {
 		logger.Warning.Printf("SECURITY WARNING: login attempt blocked for email address for %s - too many wrong tries recently", req.Email)
 
 		s.SaveLogEvent(req.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_LOGIN_ATTEMPT_ON_BLOCKED_ACCOUNT, "")
-		if err2 := s.userDBservice.SaveFailedLoginAttempt(req.InstanceId, user.ID.Hex()); err != nil {
+		if err2 := s.userDBservice.SaveFailedLoginAttempt(ctx, req.InstanceId, user.ID.Hex()); err != nil {
 			logger.Error.Printf("DB ERROR: unexpected error when updating user: %s ", err2.Error())
 		}
 		time.Sleep(time.Duration(rand.Intn(10)) * time.Second)
-		return nil, status.Error(codes.InvalidArgument, "invalid username and/or password")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid username and/or password", models.ERROR_CODE_INVALID_CREDENTIALS)
 	}
 
 	if user.Account.Type == models.ACCOUNT_TYPE_EXTERNAL {
 		logger.Warning.Printf("[SECURITY WARNING]: invalid login attempt for external account (%s)", req.Email)
 		s.SaveLogEvent(req.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_AUTH_WRONG_ACCOUNT_ID, "reason: account id used for external user")
-		return nil, status.Error(codes.InvalidArgument, "invalid username and/or password")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid username and/or password", models.ERROR_CODE_INVALID_CREDENTIALS)
 	}
 
 	match, err := pwhash.ComparePasswordWithHash(user.Account.Password, req.Password)
 	if err != nil || !match {
 		logger.Warning.Printf("SECURITY WARNING: login attempt with wrong password for %s", user.ID.Hex())
 		s.SaveLogEvent(req.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_AUTH_WRONG_PASSWORD, "")
-		if err2 := s.userDBservice.SaveFailedLoginAttempt(req.InstanceId, user.ID.Hex()); err != nil {
+		if err2 := s.userDBservice.SaveFailedLoginAttempt(ctx, req.InstanceId, user.ID.Hex()); err != nil {
 			logger.Error.Printf("DB ERROR: unexpected error when updating user: %s ", err2.Error())
 		}
-		return nil, status.Error(codes.InvalidArgument, "invalid username and/or password")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid username and/or password", models.ERROR_CODE_INVALID_CREDENTIALS)
 	}
 
 	if user.Account.AuthType == "2FA" {
 		if req.VerificationCode == "" {
 			// user tries first step
 			if user.Account.VerificationCode.Code == "" || user.Account.VerificationCode.CreatedAt == 0 || user.Account.VerificationCode.ExpiresAt < time.Now().Unix() {
-				if user.Account.VerificationCode.CreatedAt > time.Now().Unix()-loginVerificationCodeCooldown {
+				if s.isVerificationCodeCooldownActive(user.Account.VerificationCode) {
 					s.SaveLogEvent(req.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_LOGIN_ATTEMPT_ON_BLOCKED_ACCOUNT, "try resending verification code too often")
 					logger.Warning.Printf("SECURITY WARNING: resend verification code %s - too many wrong tries recently", user.ID.Hex())
-					return nil, status.Error(codes.InvalidArgument, "cannot generate verification code so often")
+					return nil, errorWithCode(codes.InvalidArgument, verificationCodeCooldownMsg, models.ERROR_CODE_VERIFICATION_CODE_COOLDOWN)
 				}
-				err = s.generateAndSendVerificationCode(req.InstanceId, user)
+				err = s.generateAndSendVerificationCode(ctx, req.InstanceId, user)
 				if err != nil {
 					logger.Error.Printf("login: unexpected error %v", err)
 					return nil, status.Error(codes.InvalidArgument, "code generation error")
@@ -238,27 +259,30 @@ func (s *userManagementServer) LoginWithEmail(ctx context.Context, req *api.Logi
 			}, nil
 		} else {
 			// user tries second step
-			if user.Account.VerificationCode.ExpiresAt < time.Now().Unix() || user.Account.VerificationCode.Code != req.VerificationCode {
+			if !tokens.CompareVerificationCode(user.Account.VerificationCode, req.VerificationCode) {
 				logger.Warning.Printf("SECURITY WARNING: login attempt with wrong or expired verification code for %s", user.ID.Hex())
 				s.SaveLogEvent(req.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_AUTH_WRONG_VERIFICATION_CODE, "")
-				if err2 := s.userDBservice.SaveFailedLoginAttempt(req.InstanceId, user.ID.Hex()); err != nil {
+				if err2 := s.userDBservice.SaveFailedLoginAttempt(ctx, req.InstanceId, user.ID.Hex()); err2 != nil {
 					logger.Error.Printf("DB ERROR: unexpected error when updating user: %s ", err2.Error())
+				} else if updated, err2 := s.userDBservice.GetUserByID(ctx, req.InstanceId, user.ID.Hex()); err2 == nil {
+					// saving the attempt changed the version, the user is saved again below
+					user = updated
 				}
 
 				if user.Account.VerificationCode.Attempts <= allowedVerificationCodeAttempts {
 					user.Account.VerificationCode.Attempts += 1
-					user, err = s.userDBservice.UpdateUser(req.InstanceId, user)
+					user, err = s.userDBservice.UpdateUser(ctx, req.InstanceId, user)
 					if err != nil {
 						logger.Error.Printf("LoginWithEmail: unexpected error when saving user -> %v", err)
 					}
-					return nil, status.Error(codes.InvalidArgument, "wrong verfication code")
+					return nil, errorWithCode(codes.InvalidArgument, "wrong verfication code", models.ERROR_CODE_INVALID_VERIFICATION_CODE)
 				} else {
-					if user.Account.VerificationCode.CreatedAt > time.Now().Unix()-loginVerificationCodeCooldown {
+					if s.isVerificationCodeCooldownActive(user.Account.VerificationCode) {
 						s.SaveLogEvent(req.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_LOGIN_ATTEMPT_ON_BLOCKED_ACCOUNT, "try resending verification code too often")
 						logger.Warning.Printf("SECURITY WARNING: resend verification code %s - too many wrong tries recently", user.ID.Hex())
-						return nil, status.Error(codes.InvalidArgument, "cannot generate verification code so often")
+						return nil, errorWithCode(codes.InvalidArgument, verificationCodeCooldownMsg, models.ERROR_CODE_VERIFICATION_CODE_COOLDOWN)
 					}
-					err = s.generateAndSendVerificationCode(req.InstanceId, user)
+					err = s.generateAndSendVerificationCode(ctx, req.InstanceId, user)
 					if err != nil {
 						logger.Error.Printf("login: unexpected error %v", err)
 						return nil, status.Error(codes.InvalidArgument, "code generation error")
@@ -294,6 +318,7 @@ func (s *userManagementServer) LoginWithEmail(ctx context.Context, req *api.Logi
 		username,
 		nil,
 		otherProfileIDs,
+		s.tokenProfiles(user),
 	)
 	if err != nil {
 		logger.Error.Printf("LoginWithEmail: unexpected error during token generation -> %v", err)
@@ -306,22 +331,28 @@ func (s *userManagementServer) LoginWithEmail(ctx context.Context, req *api.Logi
 		logger.Error.Printf("LoginWithEmail: unexpected error during refresh token generation -> %v", err)
 		return nil, status.Error(codes.Internal, "token generation error")
 	}
-	err = s.userDBservice.CreateRenewToken(req.InstanceId, user.ID.Hex(), rt, time.Now().Unix()+userdb.RENEW_TOKEN_DEFAULT_LIFETIME)
+	err = s.createRenewTokenForSession(ctx, req.InstanceId, user.ID.Hex(), rt)
 	if err != nil {
 		logger.Error.Printf("LoginWithEmail: unexpected error during refresh token creation -> %v", err)
 		return nil, status.Error(codes.Internal, "token generation error")
 	}
 
+	markedForDeletion := user.Timestamps.MarkedForDeletion
 	user.Timestamps.LastLogin = time.Now().Unix()
+	user.Timestamps.LastStrongAuth = user.Timestamps.LastLogin
 	user.Timestamps.MarkedForDeletion = 0
+	s.addLoginRecord(ctx, &user)
 	user.Account.VerificationCode = models.VerificationCode{}
 	user.Account.FailedLoginAttempts = utils.RemoveAttemptsOlderThan(user.Account.FailedLoginAttempts, 3600)
 	user.Account.PasswordResetTriggers = utils.RemoveAttemptsOlderThan(user.Account.PasswordResetTriggers, 7200)
 
-	user, err = s.userDBservice.UpdateUser(req.InstanceId, user)
+	user, err = s.userDBservice.UpdateUser(ctx, req.InstanceId, user)
 	if err != nil {
 		logger.Error.Printf("LoginWithEmail: unexpected error when saving user -> %v", err)
-		return nil, status.Error(codes.Internal, "user couldn't be updated")
+		return nil, userUpdateError(err, "user couldn't be updated")
+	}
+	if markedForDeletion > 0 {
+		s.accountReactivated(ctx, req.InstanceId, user, markedForDeletion)
 	}
 
 	// remove all temptokens for password reset:
@@ -358,9 +389,13 @@ func (s *userManagementServer) LoginWithExternalIDP(ctx context.Context, req *ap
 	}
 
 	req.Email = utils.SanitizeEmail(req.Email)
-	user, err := s.userDBservice.GetUserByAccountID(req.InstanceId, req.Email)
+	user, err := s.userDBservice.GetUserByAccountID(ctx, req.InstanceId, req.Email)
 	if err != nil {
-		// user does not exists - create user
+		// user does not exists - create user, the IdP doesn't vouch for the age of the account holder
+		birthdate, err := s.accountHolderBirthdate(ctx, req.InstanceId)
+		if err != nil {
+			return nil, err
+		}
 		randomPW, err := tokens.GenerateUniqueTokenString()
 		if err != nil {
 			logger.Error.Printf("[ERROR] LoginWithExternalIDP: random pw error - %v", err)
@@ -384,6 +419,7 @@ func (s *userManagementServer) LoginWithExternalIDP(ctx context.Context, req *ap
 					ConsentConfirmedAt: time.Now().Unix(),
 					AvatarID:           "default",
 					MainProfile:        true,
+					Birthdate:          birthdate,
 				},
 			},
 			Timestamps: models.Timestamps{
@@ -393,19 +429,20 @@ func (s *userManagementServer) LoginWithExternalIDP(ctx context.Context, req *ap
 		user.AddNewEmail(req.Email, false)
 
 		user.Account.AuthType = req.Customer
-		user.ContactPreferences.SubscribedToNewsletter = false
+		user.ContactPreferences.SetTopicSubscription(models.TOPIC_NEWSLETTER, false)
 		user.ContactPreferences.SendNewsletterTo = []string{user.ContactInfos[0].ID.Hex()}
 
 		// on which weekday the user will receive the reminder emails
 		user.ContactPreferences.SubscribedToWeekly = false
 		user.ContactPreferences.ReceiveWeeklyMessageDayOfWeek = int32(s.weekdayStrategy.Weekday())
 
-		id, err := s.userDBservice.AddUser(req.InstanceId, user)
+		id, err := s.userDBservice.AddUser(ctx, req.InstanceId, user)
 		if err != nil {
 			logger.Error.Printf("ERROR: when creating new user: %s", err.Error())
 			return nil, status.Error(codes.Internal, "user creation failed")
 		}
 		user.ID, _ = primitive.ObjectIDFromHex(id)
+		s.notifyWebhook(models.WEBHOOK_EVENT_ACCOUNT_CREATED, req.InstanceId, id)
 
 	} else {
 		if user.Account.Type != models.ACCOUNT_TYPE_EXTERNAL {
@@ -437,6 +474,7 @@ func (s *userManagementServer) LoginWithExternalIDP(ctx context.Context, req *ap
 		username,
 		nil,
 		otherProfileIDs,
+		s.tokenProfiles(user),
 	)
 	if err != nil {
 		logger.Error.Printf("[ERROR] LoginWithExternalIDP: unexpected error during token generation -> %v", err)
@@ -449,22 +487,28 @@ func (s *userManagementServer) LoginWithExternalIDP(ctx context.Context, req *ap
 		logger.Error.Printf("[ERROR] LoginWithExternalIDP: unexpected error during refresh token generation -> %v", err)
 		return nil, status.Error(codes.Internal, "token generation error")
 	}
-	err = s.userDBservice.CreateRenewToken(req.InstanceId, user.ID.Hex(), rt, time.Now().Unix()+userdb.RENEW_TOKEN_DEFAULT_LIFETIME)
+	err = s.createRenewTokenForSession(ctx, req.InstanceId, user.ID.Hex(), rt)
 	if err != nil {
 		logger.Error.Printf("LoginWithEmail: unexpected error during refresh token creation -> %v", err)
 		return nil, status.Error(codes.Internal, "token generation error")
 	}
 
+	markedForDeletion := user.Timestamps.MarkedForDeletion
 	user.Timestamps.LastLogin = time.Now().Unix()
+	user.Timestamps.LastStrongAuth = user.Timestamps.LastLogin
 	user.Timestamps.MarkedForDeletion = 0
+	s.addLoginRecord(ctx, &user)
 	user.Account.VerificationCode = models.VerificationCode{}
 	user.Account.FailedLoginAttempts = utils.RemoveAttemptsOlderThan(user.Account.FailedLoginAttempts, 3600)
 	user.Account.PasswordResetTriggers = utils.RemoveAttemptsOlderThan(user.Account.PasswordResetTriggers, 7200)
 
-	user, err = s.userDBservice.UpdateUser(req.InstanceId, user)
+	user, err = s.userDBservice.UpdateUser(ctx, req.InstanceId, user)
 	if err != nil {
 		logger.Error.Printf("[ERROR] LoginWithExternalIDP: unexpected error when saving user -> %v", err)
-		return nil, status.Error(codes.Internal, "user couldn't be updated")
+		return nil, userUpdateError(err, "user couldn't be updated")
+	}
+	if markedForDeletion > 0 {
+		s.accountReactivated(ctx, req.InstanceId, user, markedForDeletion)
 	}
 
 	// remove all temptokens for password reset:
@@ -489,20 +533,105 @@ func (s *userManagementServer) LoginWithExternalIDP(ctx context.Context, req *ap
 	return response, nil
 }
 
+// Reauthenticate confirms the password of the logged in user, so that sensitive operations are allowed again
+// for Intervals.StepUpAuthMaxAge, see requireRecentAuth.
+func (s *userManagementServer) Reauthenticate(ctx context.Context, req *ReauthenticateMsg) (*api.ServiceStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.Password == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
+	}
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	if user.Account.Type == models.ACCOUNT_TYPE_EXTERNAL {
+		return nil, status.Error(codes.FailedPrecondition, "reauthenticate with the external identity provider")
+	}
+	if utils.HasMoreAttemptsRecently(user.Account.FailedLoginAttempts, allowedPasswordAttempts, loginFailedAttemptWindow) {
+		logger.Warning.Printf("SECURITY WARNING: reauthentication blocked for %s - too many wrong tries recently", user.ID.Hex())
+		s.SaveLogEvent(req.Token.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_LOGIN_ATTEMPT_ON_BLOCKED_ACCOUNT, "reauthenticate endpoint")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid password", models.ERROR_CODE_INVALID_CREDENTIALS)
+	}
+
+	match, err := pwhash.ComparePasswordWithHash(user.Account.Password, req.Password)
+	if err != nil || !match {
+		s.SaveLogEvent(req.Token.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_AUTH_WRONG_PASSWORD, "reauthenticate endpoint")
+		if err := s.userDBservice.SaveFailedLoginAttempt(ctx, req.Token.InstanceId, user.ID.Hex()); err != nil {
+			logger.Error.Printf("Reauthenticate: %s", err.Error())
+		}
+		return nil, errorWithCode(codes.InvalidArgument, "invalid password", models.ERROR_CODE_INVALID_CREDENTIALS)
+	}
+
+	user.Timestamps.LastStrongAuth = time.Now().Unix()
+	if _, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user); err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
+	return &api.ServiceStatus{
+		Version: apiVersion,
+		Status:  api.ServiceStatus_NORMAL,
+		Msg:     "reauthenticated",
+	}, nil
+}
+
+// SignupWithEmail creates a new account. With an idempotency key in the request metadata, a retry of the same signup
+// returns the response of the first one.
 func (s *userManagementServer) SignupWithEmail(ctx context.Context, req *api.SignupWithEmailMsg) (*api.TokenResponse, error) {
+	key := idempotencyKeyFromContext(ctx)
+	if req == nil || key == "" {
+		response, _, err := s.signupWithEmail(ctx, req)
+		return response, err
+	}
+
+	instanceID := req.InstanceId
+	if instanceID == "" {
+		instanceID = "default"
+	}
+	// the password is checked against the created account instead
+	fingerprint := proto.Clone(req).(*api.SignupWithEmailMsg)
+	fingerprint.Password = ""
+	userID, err := s.beginIdempotentRequest(instanceID, key, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if userID != "" {
+		return s.replaySignup(ctx, instanceID, userID, req.Password)
+	}
+	response, userID, err := s.signupWithEmail(ctx, req)
+	s.endIdempotentRequest(instanceID, key, userID, err)
+	return response, err
+}
+
+// replaySignup answers a retried signup with new tokens for the account the first request created
+func (s *userManagementServer) replaySignup(ctx context.Context, instanceID string, userID string, password string) (*api.TokenResponse, error) {
+	user, err := s.userDBservice.GetUserByID(ctx, instanceID, userID)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	match, err := pwhash.ComparePasswordWithHash(user.Account.Password, password)
+	if err != nil || !match {
+		return nil, status.Error(codes.InvalidArgument, idempotencyKeyUsedMsg)
+	}
+	response, _, err := s.issueSignupTokens(ctx, instanceID, user)
+	return response, err
+}
+
+// signupWithEmail creates the account and returns its tokens and the ID of the new user
+func (s *userManagementServer) signupWithEmail(ctx context.Context, req *api.SignupWithEmailMsg) (*api.TokenResponse, string, error) {
 	if req == nil {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, "", errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 
 	req.Email = utils.SanitizeEmail(req.Email)
 	if !utils.CheckEmailFormat(req.Email) {
-		return nil, status.Error(codes.InvalidArgument, "email not valid")
+		return nil, "", errorWithCode(codes.InvalidArgument, "email not valid", models.ERROR_CODE_INVALID_EMAIL, fieldViolation("email", "not a valid email address"))
 	}
 	if !utils.CheckLanguageCode(req.PreferredLanguage) {
-		return nil, status.Error(codes.InvalidArgument, "language code wrong")
+		return nil, "", errorWithCode(codes.InvalidArgument, "language code wrong", models.ERROR_CODE_INVALID_LANGUAGE_CODE, fieldViolation("preferred_language", "not a valid language code"))
 	}
-	if !utils.CheckPasswordFormat(req.Password) {
-		return nil, status.Error(codes.InvalidArgument, "password too weak")
+	if !s.checkPasswordPolicy(req.InstanceId, req.Password) {
+		return nil, "", errorWithCode(codes.InvalidArgument, "password too weak", models.ERROR_CODE_PASSWORD_TOO_WEAK, fieldViolation("password", "too weak"))
 	}
 
 	if req.InstanceId == "" {
@@ -511,24 +640,46 @@ func (s *userManagementServer) SignupWithEmail(ctx context.Context, req *api.Sig
 
 	if !s.isInstanceIDAllowed(req.InstanceId) {
 		logger.Warning.Printf("SignupWithEmail: instance ID not allowed: %s", req.InstanceId)
-		return nil, status.Error(codes.InvalidArgument, "invalid instance ID")
+		return nil, "", status.Error(codes.InvalidArgument, "invalid instance ID")
+	}
+	if !s.checkEmailDomainPolicy(req.InstanceId, req.Email) {
+		return nil, "", errorWithCode(codes.InvalidArgument, "email domain not allowed", models.ERROR_CODE_EMAIL_DOMAIN_NOT_ALLOWED)
+	}
+	if s.isEmailReleasedRecently(req.InstanceId, req.Email) {
+		return nil, "", errorWithCode(codes.InvalidArgument, emailRecentlyReleasedMsg, models.ERROR_CODE_EMAIL_RECENTLY_RELEASED)
+	}
+	disposableEmail := s.isDisposableEmail(req.Email)
+	if disposableEmail && s.rejectDisposableEmails() {
+		return nil, "", errorWithCode(codes.InvalidArgument, "disposable email not allowed", models.ERROR_CODE_DISPOSABLE_EMAIL_NOT_ALLOWED)
+	}
+	birthdate, err := s.accountHolderBirthdate(ctx, req.InstanceId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !s.allowSignupFromClientIP(ctx) {
+		logger.Warning.Printf("SignupWithEmail: too many signups from %s", clientIPFromContext(ctx, s.trustedProxies))
+		return nil, "", errorWithCode(codes.ResourceExhausted, "too many signups, please try again later", models.ERROR_CODE_TOO_MANY_REQUESTS)
 	}
 
-	newUserCount, err := s.userDBservice.CountRecentlyCreatedUsers(req.InstanceId, signupRateLimitWindow)
+	newUserCount, err := s.userDBservice.CountRecentlyCreatedUsers(ctx, req.InstanceId, signupRateLimitWindow)
 	if err != nil {
 		logger.Error.Printf("ERROR: signup - unexpected error when counting: %v", err)
 	} else {
 		if newUserCount > s.newUserCountLimit {
 			logger.Warning.Println("ERROR: user creation blocked due to too many registations")
-			return nil, status.Error(codes.Internal, "user creation failed, please try in some minutes again")
+			return nil, "", status.Error(codes.Internal, "user creation failed, please try in some minutes again")
 		}
 	}
 
 	password, err := pwhash.HashPassword(req.Password)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, "", status.Error(codes.Internal, err.Error())
 	}
 
+	// addresses of trusted domains don't need to be verified
+	trustedDomain := s.isTrustedEmailDomain(req.InstanceId, req.Email)
+
 	// Create user DB object from request:
 	newUser := models.User{
 		Account: models.Account{
@@ -548,66 +699,84 @@ func (s *userManagementServer) SignupWithEmail(ctx context.Context, req *api.Sig
 				ConsentConfirmedAt: time.Now().Unix(),
 				AvatarID:           "default",
 				MainProfile:        true,
+				Birthdate:          birthdate,
 			},
 		},
 		Timestamps: models.Timestamps{
 			CreatedAt: time.Now().Unix(),
 		},
 	}
-	newUser.AddNewEmail(req.Email, false)
+	newUser.AddNewEmail(req.Email, trustedDomain)
+	if trustedDomain {
+		newUser.Account.AccountConfirmedAt = newUser.ContactInfos[0].ConfirmedAt
+	}
 	if req.Use_2Fa {
 		newUser.Account.AuthType = "2FA"
 	}
 
+	newUser.ContactPreferences.SetTopicSubscription(models.TOPIC_NEWSLETTER, req.WantsNewsletter)
 	if req.WantsNewsletter {
-		newUser.ContactPreferences.SubscribedToNewsletter = true
 		newUser.ContactPreferences.SendNewsletterTo = []string{newUser.ContactInfos[0].ID.Hex()}
 	}
 	// on which weekday the user will receive the reminder emails
 	newUser.ContactPreferences.SubscribedToWeekly = true
 	newUser.ContactPreferences.ReceiveWeeklyMessageDayOfWeek = int32(s.weekdayStrategy.Weekday())
 
-	id, err := s.userDBservice.AddUser(req.InstanceId, newUser)
+	id, err := s.userDBservice.AddUser(ctx, req.InstanceId, newUser)
 	if err != nil {
 		logger.Error.Printf("ERROR: when creating new user: %s", err.Error())
-		return nil, status.Error(codes.Internal, "user creation failed")
+		return nil, "", status.Error(codes.Internal, "user creation failed")
 	}
 	newUser.ID, _ = primitive.ObjectIDFromHex(id)
 
-	// TempToken for contact verification:
-	tempTokenInfos := models.TempToken{
-		UserID:     id,
-		InstanceID: req.InstanceId,
-		Purpose:    constants.TOKEN_PURPOSE_CONTACT_VERIFICATION,
-		Info: map[string]string{
-			"type":  models.ACCOUNT_TYPE_EMAIL,
-			"email": newUser.Account.AccountID,
-		},
-		Expiration: tokens.GetExpirationTime(s.Intervals.ContactVerificationTokenLifetime),
+	if !trustedDomain {
+		// TempToken for contact verification:
+		tempTokenInfos := models.TempToken{
+			UserID:     id,
+			InstanceID: req.InstanceId,
+			Purpose:    constants.TOKEN_PURPOSE_CONTACT_VERIFICATION,
+			Info: map[string]string{
+				"type":  models.ACCOUNT_TYPE_EMAIL,
+				"email": newUser.Account.AccountID,
+			},
+			Expiration: tokens.GetExpirationTime(s.Intervals.ContactVerificationTokenLifetime),
+		}
+		tempToken, err := s.globalDBService.AddTempToken(tempTokenInfos)
+		if err != nil {
+			logger.Error.Printf("ERROR: signup method failed to create verification token: %s", err.Error())
+			return nil, "", status.Error(codes.Internal, "failed to create verification token")
+		}
+
+		// ---> Trigger message sending
+		go func(instanceID string, accountID string, tempToken string, preferredLang string) {
+			s.sendEmailWithRetry(context.TODO(), "SignupWithEmail", &messageAPI.SendEmailReq{
+				InstanceId:  instanceID,
+				To:          []string{accountID},
+				MessageType: constants.EMAIL_TYPE_REGISTRATION,
+				ContentInfos: map[string]string{
+					"token": tempToken,
+				},
+				PreferredLanguage: preferredLang,
+			})
+		}(req.InstanceId, newUser.Account.AccountID, tempToken, newUser.Account.PreferredLanguage)
+		// <---
 	}
-	tempToken, err := s.globalDBService.AddTempToken(tempTokenInfos)
+
+	response, newUser, err := s.issueSignupTokens(ctx, req.InstanceId, newUser)
 	if err != nil {
-		logger.Error.Printf("ERROR: signup method failed to create verification token: %s", err.Error())
-		return nil, status.Error(codes.Internal, "failed to create verification token")
+		return nil, "", err
 	}
 
-	// ---> Trigger message sending
-	go func(instanceID string, accountID string, tempToken string, preferredLang string) {
-		_, err = s.clients.MessagingService.SendInstantEmail(context.TODO(), &messageAPI.SendEmailReq{
-			InstanceId:  instanceID,
-			To:          []string{accountID},
-			MessageType: constants.EMAIL_TYPE_REGISTRATION,
-			ContentInfos: map[string]string{
-				"token": tempToken,
-			},
-			PreferredLanguage: preferredLang,
-		})
-		if err != nil {
-			logger.Error.Printf("SignupWithEmail: %s", err.Error())
-		}
-	}(req.InstanceId, newUser.Account.AccountID, tempToken, newUser.Account.PreferredLanguage)
-	// <---
+	s.SaveLogEvent(req.InstanceId, newUser.ID.Hex(), loggingAPI.LogEventType_LOG, constants.LOG_EVENT_ACCOUNT_CREATED, newUser.Account.AccountID)
+	s.notifyWebhook(models.WEBHOOK_EVENT_ACCOUNT_CREATED, req.InstanceId, newUser.ID.Hex())
+	if disposableEmail {
+		s.flagDisposableEmail(req.InstanceId, newUser.ID.Hex(), newUser.Account.AccountID)
+	}
+	return response, newUser.ID.Hex(), nil
+}
 
+// issueSignupTokens creates the access and refresh token for the newly created user
+func (s *userManagementServer) issueSignupTokens(ctx context.Context, instanceID string, newUser models.User) (*api.TokenResponse, models.User, error) {
 	var username string
 	if len(newUser.Roles) > 1 || len(newUser.Roles) == 1 && newUser.Roles[0] != "PARTICIPANT" {
 		username = newUser.Account.AccountID
@@ -620,39 +789,39 @@ func (s *userManagementServer) SignupWithEmail(ctx context.Context, req *api.Sig
 		apiUser.Account.AccountConfirmedAt > 0,
 		apiUser.Profiles[0].Id,
 		newUser.Roles,
-		req.InstanceId,
+		instanceID,
 		s.Intervals.TokenExpiryInterval,
 		username,
 		nil,
 		[]string{},
+		s.tokenProfiles(newUser),
 	)
 	if err != nil {
 		logger.Error.Printf("ERROR: signup method failed to generate jwt: %s", err.Error())
-		return nil, status.Error(codes.Internal, "token creation failed")
+		return nil, newUser, status.Error(codes.Internal, "token creation failed")
 	}
 
 	// Refresh Token
 	rt, err := tokens.GenerateUniqueTokenString()
 	if err != nil {
 		logger.Error.Printf("ERROR: signup method failed to generate refresh token: %s", err.Error())
-		return nil, status.Error(codes.Internal, "token creation failed")
+		return nil, newUser, status.Error(codes.Internal, "token creation failed")
 	}
-	err = s.userDBservice.CreateRenewToken(req.InstanceId, newUser.ID.Hex(), rt, time.Now().Unix()+userdb.RENEW_TOKEN_DEFAULT_LIFETIME)
+	err = s.createRenewTokenForSession(ctx, instanceID, newUser.ID.Hex(), rt)
 	if err != nil {
 		logger.Error.Printf("LoginWithEmail: unexpected error during refresh token creation -> %v", err)
-		return nil, status.Error(codes.Internal, "token generation error")
+		return nil, newUser, status.Error(codes.Internal, "token generation error")
 	}
 
 	newUser.Timestamps.LastLogin = time.Now().Unix()
+	newUser.Timestamps.LastStrongAuth = newUser.Timestamps.LastLogin
 
-	newUser, err = s.userDBservice.UpdateUser(req.InstanceId, newUser)
+	newUser, err = s.userDBservice.UpdateUser(ctx, instanceID, newUser)
 	if err != nil {
 		logger.Error.Printf("ERROR: signup method failed to save refresh token: %s", err.Error())
-		return nil, status.Error(codes.Internal, "user created, but token could not be saved")
+		return nil, newUser, userUpdateError(err, "user created, but token could not be saved")
 	}
 
-	s.SaveLogEvent(req.InstanceId, newUser.ID.Hex(), loggingAPI.LogEventType_LOG, constants.LOG_EVENT_ACCOUNT_CREATED, newUser.Account.AccountID)
-
 	response := &api.TokenResponse{
 		AccessToken:       token,
 		RefreshToken:      rt,
@@ -661,12 +830,12 @@ func (s *userManagementServer) SignupWithEmail(ctx context.Context, req *api.Sig
 		SelectedProfileId: apiUser.Profiles[0].Id,
 		PreferredLanguage: apiUser.Account.PreferredLanguage,
 	}
-	return response, nil
+	return response, newUser, nil
 }
 
 func (s *userManagementServer) VerifyContact(ctx context.Context, req *api.TempToken) (*api.User, error) {
 	if req == nil || req.Token == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 
 	tokenInfos, err := s.ValidateTempToken(req.Token, []string{
@@ -678,7 +847,7 @@ func (s *userManagementServer) VerifyContact(ctx context.Context, req *api.TempT
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	user, err := s.userDBservice.GetUserByID(tokenInfos.InstanceID, tokenInfos.UserID)
+	user, err := s.userDBservice.GetUserByID(ctx, tokenInfos.InstanceID, tokenInfos.UserID)
 	if err != nil {
 		logger.Error.Printf("VerifyContact: %s", err.Error())
 		return nil, status.Error(codes.InvalidArgument, "no user found")
@@ -698,50 +867,191 @@ func (s *userManagementServer) VerifyContact(ctx context.Context, req *api.TempT
 	if user.Account.Type == models.ACCOUNT_TYPE_EMAIL && user.Account.AccountID == email {
 		user.Account.AccountConfirmedAt = time.Now().Unix()
 	}
-	user, err = s.userDBservice.UpdateUser(tokenInfos.InstanceID, user)
+	user, err = s.userDBservice.UpdateUser(ctx, tokenInfos.InstanceID, user)
+	if err != nil {
+		return nil, userUpdateError(err, err.Error())
+	}
 
 	s.SaveLogEvent(tokenInfos.InstanceID, tokenInfos.UserID, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_CONTACT_VERIFIED, email)
-	return user.ToAPI(), err
+	if cType == "email" {
+		s.notifyWebhook(models.WEBHOOK_EVENT_EMAIL_CONFIRMED, tokenInfos.InstanceID, tokenInfos.UserID)
+	}
+	return user.ToAPI(), nil
 }
 
-func (s *userManagementServer) ResendContactVerification(ctx context.Context, req *api.ResendContactVerificationReq) (*api.ServiceStatus, error) {
-	if req == nil || utils.IsTokenEmpty(req.Token) || req.Address == "" || req.Type == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+// SendContactVerificationCode emails a short code to an unconfirmed email address of the user, to be entered with
+// ConfirmContactWithCode, for clients that can't handle the verification link
+func (s *userManagementServer) SendContactVerificationCode(ctx context.Context, req *ContactVerificationCodeMsg) (*api.ServiceStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.Address == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
+	req.Address = utils.SanitizeEmail(req.Address)
 
-	user, err := s.userDBservice.GetUserByID(req.Token.InstanceId, req.Token.Id)
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
 	if err != nil {
-		logger.Error.Printf("ResendContactVerification: %s", err.Error())
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		logger.Error.Printf("SendContactVerificationCode: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, "no user found")
+	}
+	ci, found := user.FindContactInfoByTypeAndAddr("email", req.Address)
+	if !found {
+		return nil, status.Error(codes.InvalidArgument, "address not found")
+	}
+	if ci.ConfirmedAt > 0 {
+		return nil, status.Error(codes.InvalidArgument, "address already confirmed")
+	}
+	if ci.VerificationCode != nil && s.isVerificationCodeCooldownActive(*ci.VerificationCode) {
+		return nil, errorWithCode(codes.InvalidArgument, verificationCodeCooldownMsg, models.ERROR_CODE_VERIFICATION_CODE_COOLDOWN)
 	}
 
+	vc, err := tokens.GenerateVerificationCode(6)
+	if err != nil {
+		logger.Error.Printf("SendContactVerificationCode: %v", err)
+		return nil, status.Error(codes.Internal, "error while generating verification code")
+	}
+	now := time.Now().Unix()
+	user.SetEmailVerificationCode(req.Address, &models.VerificationCode{
+		Code:      vc,
+		CreatedAt: now,
+		ExpiresAt: now + s.verificationCodeLifetime(req.Token.InstanceId),
+	})
+	if _, err := s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user); err != nil {
+		logger.Error.Printf("SendContactVerificationCode: %s", err.Error())
+		return nil, userUpdateError(err, "user couldn't be updated")
+	}
+
+	// ---> Trigger message sending
+	s.sendEmailWithRetry(ctx, "SendContactVerificationCode", &messageAPI.SendEmailReq{
+		InstanceId:  req.Token.InstanceId,
+		To:          []string{req.Address},
+		MessageType: models.EMAIL_TYPE_VERIFY_EMAIL_CODE,
+		ContentInfos: map[string]string{
+			"verificationCode": formatVerificationCode(vc),
+		},
+		PreferredLanguage: user.Account.PreferredLanguage,
+	})
+	// <---
+
+	return &api.ServiceStatus{
+		Status:  api.ServiceStatus_NORMAL,
+		Msg:     "message sent",
+		Version: apiVersion,
+	}, nil
+}
+
+// ConfirmContactWithCode confirms the email address with the code sent by SendContactVerificationCode. After
+// allowedVerificationCodeAttempts wrong codes, a new code has to be requested.
+func (s *userManagementServer) ConfirmContactWithCode(ctx context.Context, req *ContactVerificationCodeMsg) (*api.User, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.Address == "" || req.Code == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	req.Address = utils.SanitizeEmail(req.Address)
+	// the code is sent formatted as "123-456"
+	req.Code = strings.ReplaceAll(strings.TrimSpace(req.Code), "-", "")
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		logger.Error.Printf("ConfirmContactWithCode: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, "no user found")
+	}
 	ci, found := user.FindContactInfoByTypeAndAddr("email", req.Address)
 	if !found {
 		return nil, status.Error(codes.InvalidArgument, "address not found")
 	}
+	if ci.ConfirmedAt > 0 {
+		return nil, status.Error(codes.InvalidArgument, "address already confirmed")
+	}
+	vc := ci.VerificationCode
+	if vc == nil || vc.Code == "" || vc.ExpiresAt < time.Now().Unix() {
+		return nil, errorWithCode(codes.InvalidArgument, "verification code expired", models.ERROR_CODE_INVALID_VERIFICATION_CODE)
+	}
+	// the attempt is counted before comparing the code, so that concurrent requests can't exceed the limit
+	user, err = s.userDBservice.CountVerificationCodeAttempt(ctx, req.Token.InstanceId, req.Token.Id, req.Address, vc.Code, allowedVerificationCodeAttempts)
+	if err == userdb.ErrNoAttemptsLeft {
+		return nil, errorWithCode(codes.ResourceExhausted, "too many attempts, please request a new code", models.ERROR_CODE_TOO_MANY_REQUESTS)
+	}
+	if err != nil {
+		logger.Error.Printf("ConfirmContactWithCode: %s", err.Error())
+		return nil, status.Error(codes.Internal, "user couldn't be updated")
+	}
 
-	if ci.ConfirmationLinkSentAt > time.Now().Unix()-contactVerificationMessageCooldown {
-		return nil, status.Error(codes.InvalidArgument, "cannot send verification so often")
+	if !tokens.CompareVerificationCode(*vc, req.Code) {
+		logger.Warning.Printf("SECURITY WARNING: contact verification with wrong code for %s", user.ID.Hex())
+		s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_AUTH_WRONG_VERIFICATION_CODE, "contact verification")
+		return nil, errorWithCode(codes.InvalidArgument, "wrong verification code", models.ERROR_CODE_INVALID_VERIFICATION_CODE)
+	}
+
+	if err := user.ConfirmContactInfo("email", req.Address); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if user.Account.Type == models.ACCOUNT_TYPE_EMAIL && user.Account.AccountID == req.Address {
+		user.Account.AccountConfirmedAt = time.Now().Unix()
+	}
+	user, err = s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
+	if err != nil {
+		logger.Error.Printf("ConfirmContactWithCode: %s", err.Error())
+		return nil, userUpdateError(err, "user couldn't be updated")
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_CONTACT_VERIFIED, req.Address)
+	s.notifyWebhook(models.WEBHOOK_EVENT_EMAIL_CONFIRMED, req.Token.InstanceId, req.Token.Id)
+	return user.ToAPI(), nil
+}
+
+// getOrCreateContactVerificationToken reuses a still valid verification token for the address, so that a link sent before keeps working
+func (s *userManagementServer) getOrCreateContactVerificationToken(instanceID string, userID string, email string) (string, error) {
+	existing, err := s.globalDBService.GetTempTokenForUser(instanceID, userID, constants.TOKEN_PURPOSE_CONTACT_VERIFICATION)
+	if err != nil {
+		logger.Error.Printf("getOrCreateContactVerificationToken: %s", err.Error())
+	}
+	for _, t := range existing {
+		if t.Info["email"] == email && !tokens.ReachedExpirationTime(t.Expiration) {
+			return t.Token, nil
+		}
 	}
 
-	// TempToken for contact verification:
 	tempTokenInfos := models.TempToken{
-		UserID:     req.Token.Id,
-		InstanceID: req.Token.InstanceId,
+		UserID:     userID,
+		InstanceID: instanceID,
 		Purpose:    constants.TOKEN_PURPOSE_CONTACT_VERIFICATION,
 		Info: map[string]string{
 			"type":  models.ACCOUNT_TYPE_EMAIL,
-			"email": ci.Email,
+			"email": email,
 		},
 		Expiration: tokens.GetExpirationTime(s.Intervals.ContactVerificationTokenLifetime),
 	}
-	tempToken, err := s.globalDBService.AddTempToken(tempTokenInfos)
+	return s.globalDBService.AddTempToken(tempTokenInfos)
+}
+
+func (s *userManagementServer) ResendContactVerification(ctx context.Context, req *api.ResendContactVerificationReq) (*api.ServiceStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.Address == "" || req.Type == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		logger.Error.Printf("ResendContactVerification: %s", err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ci, found := user.FindContactInfoByTypeAndAddr("email", req.Address)
+	if !found {
+		return nil, status.Error(codes.InvalidArgument, "address not found")
+	}
+	if ci.ConfirmedAt > 0 {
+		return nil, status.Error(codes.InvalidArgument, "address already confirmed")
+	}
+
+	if ci.ConfirmationLinkSentAt > time.Now().Unix()-contactVerificationMessageCooldown {
+		return nil, status.Error(codes.InvalidArgument, "cannot send verification so often")
+	}
+
+	tempToken, err := s.getOrCreateContactVerificationToken(req.Token.InstanceId, req.Token.Id, ci.Email)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	// ---> Trigger message sending
-	_, err = s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+	s.sendEmailWithRetry(ctx, "ResendContactVerification", &messageAPI.SendEmailReq{
 		InstanceId:  req.Token.InstanceId,
 		To:          []string{req.Address},
 		MessageType: constants.EMAIL_TYPE_VERIFY_EMAIL,
@@ -750,14 +1060,11 @@ func (s *userManagementServer) ResendContactVerification(ctx context.Context, re
 		},
 		PreferredLanguage: user.Account.PreferredLanguage,
 	})
-	if err != nil {
-		logger.Error.Printf("ResendContactVerification: %s", err.Error())
-	}
 	// <---
 
 	// update last verification email sent time:
 	user.SetContactInfoVerificationSent("email", req.Address)
-	_, err = s.userDBservice.UpdateUser(req.Token.InstanceId, user)
+	_, err = s.userDBservice.UpdateUser(ctx, req.Token.InstanceId, user)
 	if err != nil {
 		logger.Error.Printf("ResendContactVerification: %s", err.Error())
 	}
@@ -2,12 +2,18 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/ptypes/empty"
 	api_types "github.com/influenzanet/go-utils/pkg/api_types"
 	"github.com/influenzanet/go-utils/pkg/constants"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
 	"github.com/influenzanet/user-management-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/pwhash"
@@ -15,9 +21,42 @@ import (
 	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
 	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+func TestStatus(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	t.Run("liveness", func(t *testing.T) {
+		// must not depend on the DBs
+		resp, err := (&userManagementServer{}).Status(context.Background(), &empty.Empty{})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.Status != api.ServiceStatus_NORMAL || resp.Version != apiVersion {
+			t.Errorf("unexpected response: %s", resp)
+		}
+	})
+
+	t.Run("readiness with reachable DBs", func(t *testing.T) {
+		resp, err := s.Readiness(context.Background(), &empty.Empty{})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.Status != api.ServiceStatus_NORMAL || resp.Version != apiVersion {
+			t.Errorf("unexpected response: %s", resp)
+		}
+	})
+}
+
 func TestSendVerificationCode(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -27,7 +66,6 @@ func TestSendVerificationCode(t *testing.T) {
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
 		globalDBService: testGlobalDBService,
-		instanceIDs:     []string{testInstanceID},
 		clients: &models.APIClients{
 			MessagingService: mockMessagingClient,
 			LoggingService:   mockLoggingClient,
@@ -61,7 +99,7 @@ func TestSendVerificationCode(t *testing.T) {
 		},
 	}
 
-	_, err = testUserDBService.AddUser(testInstanceID, testUser)
+	_, err = testUserDBService.AddUser(context.Background(), testInstanceID, testUser)
 	if err != nil {
 		t.Errorf("unexpected error while creating user: %v", err)
 		return
@@ -114,6 +152,202 @@ func TestSendVerificationCode(t *testing.T) {
 	*/
 }
 
+func TestSendVerificationCodeCooldown(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
+		},
+		Intervals: models.Intervals{
+			TokenExpiryInterval:            time.Second * 2,
+			VerificationCodeLifetime:       60,
+			VerificationCodeResendCooldown: 2,
+		},
+	}
+
+	currentPw := "SuperSecurePassword123!§$"
+	hashedPw, err := pwhash.HashPassword(currentPw)
+	if err != nil {
+		t.Errorf("error creating user for testing login")
+		return
+	}
+
+	testUser := models.User{
+		Account: models.Account{
+			Type:               "email",
+			AccountID:          "test-send-verification-code-cooldown@test.com",
+			AccountConfirmedAt: time.Now().Unix(),
+			AuthType:           "2FA",
+			Password:           hashedPw,
+			PreferredLanguage:  "de",
+		},
+		Roles: []string{"PARTICIPANT"},
+		Profiles: []models.Profile{
+			{ID: primitive.NewObjectID()},
+		},
+	}
+	id, err := testUserDBService.AddUser(context.Background(), testInstanceID, testUser)
+	if err != nil {
+		t.Errorf("unexpected error while creating user: %v", err)
+		return
+	}
+
+	mockMessagingClient.EXPECT().SendInstantEmail(
+		gomock.Any(),
+		gomock.Any(),
+	).Return(nil, nil).AnyTimes()
+
+	req := &api.SendVerificationCodeReq{
+		InstanceId: testInstanceID,
+		Email:      testUser.Account.AccountID,
+		Password:   currentPw,
+	}
+
+	firstCode := ""
+	t.Run("request a code", func(t *testing.T) {
+		_, err := s.SendVerificationCode(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, id)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		firstCode = user.Account.VerificationCode.Code
+		if len(firstCode) != 6 {
+			t.Errorf("unexpected verification code: %s", firstCode)
+		}
+	})
+
+	t.Run("request again immediately", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+		_, err := s.SendVerificationCode(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, verificationCodeCooldownMsg)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("request after cooldown", func(t *testing.T) {
+		time.Sleep(3 * time.Second)
+		_, err := s.SendVerificationCode(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, id)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if user.Account.VerificationCode.Code != firstCode {
+			t.Errorf("still valid code should be reused: %s, %s", user.Account.VerificationCode.Code, firstCode)
+		}
+	})
+
+	t.Run("with cooldown disabled", func(t *testing.T) {
+		s.Intervals.VerificationCodeResendCooldown = 0
+		defer func() { s.Intervals.VerificationCodeResendCooldown = 2 }()
+		_, err := s.SendVerificationCode(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("with unset cooldown", func(t *testing.T) {
+		s.Intervals.VerificationCodeResendCooldown = -1
+		defer func() { s.Intervals.VerificationCodeResendCooldown = 2 }()
+		if s.verificationCodeResendCooldown() != loginVerificationCodeCooldown {
+			t.Errorf("unexpected cooldown: %d", s.verificationCodeResendCooldown())
+		}
+	})
+}
+
+func TestVerificationCodeLifetimePerInstance(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+		},
+		Intervals: models.Intervals{
+			VerificationCodeLifetime: 60,
+		},
+	}
+	mockMessagingClient.EXPECT().SendInstantEmail(
+		gomock.Any(),
+		gomock.Any(),
+	).Return(nil, nil).AnyTimes()
+
+	instanceWithOverride := testInstanceID + "_vc_lifetime"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testUserDBService.DBClient.Database(testDBNamePrefix + instanceWithOverride + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err := testGlobalDBService.SaveInstanceConfig(instanceWithOverride, models.InstanceConfig{VerificationCodeLifetime: 600}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	for _, tc := range []struct {
+		instanceID string
+		lifetime   int64
+	}{
+		{instanceID: testInstanceID, lifetime: 60},
+		{instanceID: instanceWithOverride, lifetime: 600},
+	} {
+		t.Run(tc.instanceID, func(t *testing.T) {
+			id, err := testUserDBService.AddUser(context.Background(), tc.instanceID, models.User{
+				Account: models.Account{
+					Type:      "email",
+					AccountID: "test-vc-lifetime@test.com",
+				},
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			user, err := testUserDBService.GetUserByID(context.Background(), tc.instanceID, id)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if err := s.generateAndSendVerificationCode(context.Background(), tc.instanceID, user); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			user, err = testUserDBService.GetUserByID(context.Background(), tc.instanceID, id)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			vc := user.Account.VerificationCode
+			if vc.ExpiresAt-vc.CreatedAt != tc.lifetime {
+				t.Errorf("unexpected lifetime: %d instead of %d", vc.ExpiresAt-vc.CreatedAt, tc.lifetime)
+			}
+		})
+	}
+}
+
 func TestAutoValidateTempToken(t *testing.T) {
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
@@ -146,7 +380,7 @@ func TestAutoValidateTempToken(t *testing.T) {
 		},
 	}
 
-	id, err := testUserDBService.AddUser(testInstanceID, testUser)
+	id, err := testUserDBService.AddUser(context.Background(), testInstanceID, testUser)
 	if err != nil {
 		t.Errorf("error creating user for testing login")
 		return
@@ -260,7 +494,7 @@ func TestAutoValidateTempToken(t *testing.T) {
 
 	t.Run("correct temptoken with access token same user", func(t *testing.T) {
 		accessToken, err := tokens.GenerateNewToken(
-			testUser.ID.Hex(), true, "profid", []string{}, testInstanceID, s.Intervals.TokenExpiryInterval, "", nil, []string{},
+			testUser.ID.Hex(), true, "profid", []string{}, testInstanceID, s.Intervals.TokenExpiryInterval, "", nil, []string{}, nil,
 		)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
@@ -287,7 +521,7 @@ func TestAutoValidateTempToken(t *testing.T) {
 
 	t.Run("correct temptoken with access token different user", func(t *testing.T) {
 		accessToken, err := tokens.GenerateNewToken(
-			"different", true, "profid", []string{}, testInstanceID, s.Intervals.TokenExpiryInterval, "", nil, []string{},
+			"different", true, "profid", []string{}, testInstanceID, s.Intervals.TokenExpiryInterval, "", nil, []string{}, nil,
 		)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
@@ -321,7 +555,6 @@ func TestLogin(t *testing.T) {
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
 		globalDBService: testGlobalDBService,
-		instanceIDs:     []string{testInstanceID},
 		Intervals: models.Intervals{
 			TokenExpiryInterval:      time.Second * 2,
 			VerificationCodeLifetime: 60,
@@ -351,9 +584,14 @@ func TestLogin(t *testing.T) {
 		Profiles: []models.Profile{
 			{ID: primitive.NewObjectID()},
 		},
+		ContactInfos: []models.ContactInfo{
+			{ID: primitive.NewObjectID(), Type: "email", Email: "test-login@test.com", ConfirmedAt: time.Now().Unix()},
+			{ID: primitive.NewObjectID(), Type: "email", Email: "test-login-secondary@test.com", ConfirmedAt: time.Now().Unix()},
+			{ID: primitive.NewObjectID(), Type: "email", Email: "test-login-unconfirmed@test.com"},
+		},
 	}
 
-	id, err := testUserDBService.AddUser(testInstanceID, testUser1)
+	id, err := testUserDBService.AddUser(context.Background(), testInstanceID, testUser1)
 	if err != nil {
 		t.Errorf("error creating user for testing login")
 		return
@@ -383,7 +621,7 @@ func TestLogin(t *testing.T) {
 		},
 	}
 
-	id, err = testUserDBService.AddUser(testInstanceID, testUser2)
+	id, err = testUserDBService.AddUser(context.Background(), testInstanceID, testUser2)
 	if err != nil {
 		t.Errorf("error creating user 2 for testing login")
 		return
@@ -491,103 +729,400 @@ func TestLogin(t *testing.T) {
 		}
 	})
 
-	// 2FA tests
-	t.Run("with wrong verification code", func(t *testing.T) {
+	t.Run("with confirmed secondary email", func(t *testing.T) {
 		mockLoggingClient.EXPECT().SaveLogEvent(
 			gomock.Any(),
 			gomock.Any(),
 		).Return(nil, nil)
 
 		req := &api.LoginWithEmailMsg{
-			Email:            testUser2.Account.AccountID,
-			Password:         currentPw,
-			InstanceId:       testInstanceID,
-			AsParticipant:    true,
-			VerificationCode: "234855",
+			Email:         "Test-Login-Secondary@test.com",
+			Password:      currentPw,
+			InstanceId:    testInstanceID,
+			AsParticipant: true,
 		}
-		_, err := s.LoginWithEmail(context.Background(), req)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "wrong verfication code")
-		if !ok {
+		resp, err := s.LoginWithEmail(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		claims, ok, err := tokens.ValidateToken(resp.Token.AccessToken)
+		if err != nil || !ok || claims.ID != testUser1.ID.Hex() {
+			t.Errorf("unexpected token: %v, %v", claims, err)
+		}
+	})
+
+	t.Run("with unconfirmed secondary email", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		req := &api.LoginWithEmailMsg{
+			Email:      "test-login-unconfirmed@test.com",
+			Password:   currentPw,
+			InstanceId: testInstanceID,
+		}
+		resp, err := s.LoginWithEmail(context.Background(), req)
+		if err == nil || resp != nil {
+			t.Errorf("wrong response: %s", resp)
+			return
+		}
+		if ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_INVALID_CREDENTIALS); !ok {
 			t.Error(msg)
 		}
 	})
 
-	_, err = testUserDBService.UpdateUser(testInstanceID, testUser2)
-	if err != nil {
-		t.Errorf("error updating user 2 for testing login")
-		return
-	}
+	t.Run("login with profiles in token", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil).Times(2)
 
-	t.Run("with valid fields for 2FA", func(t *testing.T) {
+		req := &api.LoginWithEmailMsg{
+			Email:         testUser1.Account.AccountID,
+			Password:      currentPw,
+			InstanceId:    testInstanceID,
+			AsParticipant: true,
+		}
+		profilesInToken := func() []models.TokenProfile {
+			resp, err := s.LoginWithEmail(context.Background(), req)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			claims, ok, err := tokens.ValidateToken(resp.Token.AccessToken)
+			if err != nil || !ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return tokens.GetProfilesFromPayload(claims.Payload)
+		}
+
+		if profiles := profilesInToken(); profiles != nil {
+			t.Errorf("profiles should not be embedded by default: %v", profiles)
+		}
+
+		s.embedProfilesInToken = true
+		defer func() { s.embedProfilesInToken = false }()
+		profiles := profilesInToken()
+		if len(profiles) != 1 || profiles[0].ID != testUser1.Profiles[0].ID.Hex() {
+			t.Errorf("unexpected profiles in token: %v", profiles)
+		}
+	})
+
+	t.Run("login records client IP and user agent", func(t *testing.T) {
 		mockLoggingClient.EXPECT().SaveLogEvent(
 			gomock.Any(),
 			gomock.Any(),
 		).Return(nil, nil)
 
+		s.loginIPStorage = "truncated"
+		s.trustedProxies = testTrustedProxies
+		defer func() {
+			s.loginIPStorage = ""
+			s.trustedProxies = nil
+		}()
+
+		ctx := metadata.NewIncomingContext(contextFromPeer("10.0.0.2"), metadata.Pairs(
+			"x-forwarded-for", "203.0.113.57, 10.0.0.1",
+			"grpcgateway-user-agent", "test-browser/1.0",
+		))
 		req := &api.LoginWithEmailMsg{
-			Email:            testUser2.Account.AccountID,
-			Password:         currentPw,
-			InstanceId:       testInstanceID,
-			VerificationCode: testUser2.Account.VerificationCode.Code,
-			AsParticipant:    true,
+			Email:         testUser1.Account.AccountID,
+			Password:      currentPw,
+			InstanceId:    testInstanceID,
+			AsParticipant: true,
+		}
+		if _, err := s.LoginWithEmail(ctx, req); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
 		}
 
-		resp, err := s.LoginWithEmail(context.Background(), req)
-
+		logins, err := s.GetLoginHistory(context.Background(), &api.UserReference{
+			Token: &api_types.TokenInfos{Id: testUser1.ID.Hex(), InstanceId: testInstanceID},
+		})
 		if err != nil {
 			t.Errorf("unexpected error: %s", err.Error())
 			return
 		}
-		if resp == nil || len(resp.Token.AccessToken) < 1 || len(resp.Token.RefreshToken) < 1 {
-			t.Errorf("unexpected response: %s", resp)
+		if len(logins.Logins) == 0 {
+			t.Error("login not recorded")
 			return
 		}
-
-		if resp.Token.PreferredLanguage != "de" || resp.Token.SelectedProfileId != testUser2.Profiles[0].ID.Hex() {
-			t.Errorf("unexpected PreferredLanguage or AccountConfirmed: %s", resp)
-			return
+		last := logins.Logins[0]
+		if last.IP != "203.0.113.0" || last.UserAgent != "test-browser/1.0" || last.Time < time.Now().Unix()-5 {
+			t.Errorf("unexpected login record: %v", last)
 		}
 	})
 
-	t.Run("with brute force attack", func(t *testing.T) {
+	t.Run("login history keeps the latest logins", func(t *testing.T) {
 		mockLoggingClient.EXPECT().SaveLogEvent(
 			gomock.Any(),
 			gomock.Any(),
-		).Times(12).Return(nil, nil)
+		).Return(nil, nil).Times(4)
+
+		s.loginHistory = models.LoginHistoryConfig{Size: 3}
+		defer func() { s.loginHistory = models.LoginHistoryConfig{} }()
 
 		req := &api.LoginWithEmailMsg{
-			Email:         testUser2.Account.AccountID,
-			Password:      currentPw + "w",
+			Email:         testUser1.Account.AccountID,
+			Password:      currentPw,
 			InstanceId:    testInstanceID,
 			AsParticipant: true,
 		}
-		for i := 0; i < 11; i++ {
-			_, err := s.LoginWithEmail(context.Background(), req)
-			ok, msg := shouldHaveGrpcErrorStatus(err, "invalid username and/or password")
-			if !ok {
-				t.Error(msg)
+		for _, userAgent := range []string{"agent-1", "agent-2", "agent-3", "agent-4"} {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", userAgent))
+			if _, err := s.LoginWithEmail(ctx, req); err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+				return
 			}
 		}
 
-		_, err := s.LoginWithEmail(context.Background(), req)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid username and/or password")
-		if !ok {
-			t.Error(msg)
+		logins, err := s.GetLoginHistory(context.Background(), &api.UserReference{
+			Token: &api_types.TokenInfos{Id: testUser1.ID.Hex(), InstanceId: testInstanceID},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(logins.Logins) != 3 {
+			t.Errorf("unexpected number of logins.Logins: %d", len(logins.Logins))
 			return
 		}
+		for i, userAgent := range []string{"agent-4", "agent-3", "agent-2"} {
+			if logins.Logins[i].UserAgent != userAgent {
+				t.Errorf("unexpected login at %d: %v", i, logins.Logins[i])
+			}
+		}
 	})
-}
 
-func TestSignupWithEmail(t *testing.T) {
-	mockCtrl := gomock.NewController(t)
-	defer mockCtrl.Finish()
-	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	// 2FA tests
+	t.Run("with wrong verification code", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		req := &api.LoginWithEmailMsg{
+			Email:            testUser2.Account.AccountID,
+			Password:         currentPw,
+			InstanceId:       testInstanceID,
+			AsParticipant:    true,
+			VerificationCode: "234855",
+		}
+		_, err := s.LoginWithEmail(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "wrong verfication code")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	// the login attempts above updated the stored user
+	currentUser2, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUser2.ID.Hex())
+	if err != nil {
+		t.Errorf("error reading user 2 for testing login")
+		return
+	}
+	testUser2.Version = currentUser2.Version
+	_, err = testUserDBService.UpdateUser(context.Background(), testInstanceID, testUser2)
+	if err != nil {
+		t.Errorf("error updating user 2 for testing login")
+		return
+	}
+
+	t.Run("with valid fields for 2FA", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		req := &api.LoginWithEmailMsg{
+			Email:            testUser2.Account.AccountID,
+			Password:         currentPw,
+			InstanceId:       testInstanceID,
+			VerificationCode: testUser2.Account.VerificationCode.Code,
+			AsParticipant:    true,
+		}
+
+		resp, err := s.LoginWithEmail(context.Background(), req)
+
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp == nil || len(resp.Token.AccessToken) < 1 || len(resp.Token.RefreshToken) < 1 {
+			t.Errorf("unexpected response: %s", resp)
+			return
+		}
+
+		if resp.Token.PreferredLanguage != "de" || resp.Token.SelectedProfileId != testUser2.Profiles[0].ID.Hex() {
+			t.Errorf("unexpected PreferredLanguage or AccountConfirmed: %s", resp)
+			return
+		}
+	})
+
+	t.Run("with brute force attack", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Times(12).Return(nil, nil)
+
+		req := &api.LoginWithEmailMsg{
+			Email:         testUser2.Account.AccountID,
+			Password:      currentPw + "w",
+			InstanceId:    testInstanceID,
+			AsParticipant: true,
+		}
+		for i := 0; i < 11; i++ {
+			_, err := s.LoginWithEmail(context.Background(), req)
+			ok, msg := shouldHaveGrpcErrorStatus(err, "invalid username and/or password")
+			if !ok {
+				t.Error(msg)
+			}
+		}
+
+		_, err := s.LoginWithEmail(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid username and/or password")
+		if !ok {
+			t.Error(msg)
+			return
+		}
+	})
+}
+
+func TestLoginReactivation(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval: time.Second * 2,
+		},
+		clients: &models.APIClients{
+			LoggingService:   mockLoggingClient,
+			MessagingService: mockMessagingClient,
+		},
+	}
+
+	instanceID := testInstanceID + "_reactivation"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testUserDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+		if err := testGlobalDBService.DeleteInstance(instanceID); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err := testGlobalDBService.SaveInstanceConfig(instanceID, models.InstanceConfig{SendReactivationEmail: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	currentPw := "SuperSecurePassword123!§$"
+	hashedPw, err := pwhash.HashPassword(currentPw)
+	if err != nil {
+		t.Errorf("error creating user for testing login")
+		return
+	}
+	testUser := models.User{
+		Account: models.Account{
+			Type:               "email",
+			AccountID:          "test-reactivation@test.com",
+			AccountConfirmedAt: time.Now().Unix(),
+			Password:           hashedPw,
+			PreferredLanguage:  "de",
+		},
+		Roles: []string{"PARTICIPANT"},
+		Profiles: []models.Profile{
+			{ID: primitive.NewObjectID()},
+		},
+		Timestamps: models.Timestamps{
+			MarkedForDeletion: time.Now().Unix() + 3600,
+		},
+	}
+	if _, err := testUserDBService.AddUser(context.Background(), instanceID, testUser); err != nil {
+		t.Errorf("error creating user for testing login")
+		return
+	}
+
+	req := &api.LoginWithEmailMsg{
+		Email:         testUser.Account.AccountID,
+		Password:      currentPw,
+		InstanceId:    instanceID,
+		AsParticipant: true,
+	}
+
+	t.Run("login of user marked for deletion", func(t *testing.T) {
+		events := []string{}
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *loggingAPI.NewLogEvent, opts ...grpc.CallOption) (*api_types.ServiceStatus, error) {
+			events = append(events, req.EventName)
+			return nil, nil
+		}).Times(2)
+		var sentEmail *messageAPI.SendEmailReq
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			sentEmail = req
+			return nil, nil
+		})
+
+		if _, err := s.LoginWithEmail(context.Background(), req); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(events) != 2 || events[0] != models.LOG_EVENT_ACCOUNT_REACTIVATED || events[1] != constants.LOG_EVENT_LOGIN_SUCCESS {
+			t.Errorf("unexpected log events: %v", events)
+		}
+		if sentEmail == nil || sentEmail.MessageType != models.EMAIL_TYPE_ACCOUNT_REACTIVATED || sentEmail.To[0] != testUser.Account.AccountID {
+			t.Errorf("unexpected email: %v", sentEmail)
+		}
+
+		user, err := testUserDBService.GetUserByAccountID(context.Background(), instanceID, testUser.Account.AccountID)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Timestamps.MarkedForDeletion != 0 {
+			t.Errorf("deletion mark not cleared: %d", user.Timestamps.MarkedForDeletion)
+		}
+	})
+
+	t.Run("normal login", func(t *testing.T) {
+		// no reactivation event and no email
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *loggingAPI.NewLogEvent, opts ...grpc.CallOption) (*api_types.ServiceStatus, error) {
+			if req.EventName != constants.LOG_EVENT_LOGIN_SUCCESS {
+				t.Errorf("unexpected log event: %s", req.EventName)
+			}
+			return nil, nil
+		})
+
+		if _, err := s.LoginWithEmail(context.Background(), req); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+	})
+}
+
+func TestSignupWithEmail(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
 	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
 
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
 		globalDBService: testGlobalDBService,
-		instanceIDs:     []string{testInstanceID},
 		Intervals: models.Intervals{
 			TokenExpiryInterval:      time.Second * 2,
 			VerificationCodeLifetime: 60,
@@ -642,73 +1177,694 @@ func TestSignupWithEmail(t *testing.T) {
 		if !ok {
 			t.Error(msg)
 		}
+		ok, msg = shouldHaveFieldViolation(err, "email")
+		if !ok {
+			t.Error(msg)
+		}
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_INVALID_EMAIL)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with wrong password format", func(t *testing.T) {
+		_, err := s.SignupWithEmail(context.Background(), wrongPasswordFormatNewUserReq)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "password too weak")
+		if !ok {
+			t.Error(msg)
+		}
+		ok, msg = shouldHaveFieldViolation(err, "password")
+		if !ok {
+			t.Error(msg)
+		}
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_PASSWORD_TOO_WEAK)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with valid fields", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		resp, err := s.SignupWithEmail(context.Background(), validNewUserReq)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(resp.AccessToken) < 1 || len(resp.RefreshToken) < 1 {
+			t.Errorf("unexpected response: %s", resp)
+			return
+		}
+		if len(resp.SelectedProfileId) < 1 {
+			t.Errorf("unexpected selected profile: %s", resp.SelectedProfileId)
+			return
+		}
+		if len(resp.Profiles) != 1 {
+			t.Errorf("unexpected number of profiles: %d", len(resp.Profiles))
+			return
+		}
+	})
+
+	t.Run("with duplicate user (same email)", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		req := &api.SignupWithEmailMsg{
+			Email:             "test-signup-1@test.com",
+			Password:          "SuperSecurePassword123!§$",
+			InstanceId:        testInstanceID,
+			PreferredLanguage: "en",
+		}
+		_, err := s.SignupWithEmail(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		// Try to signup again:
+		resp, err := s.SignupWithEmail(context.Background(), req)
+		if err == nil || resp != nil {
+			t.Errorf("should fail, when user exists already, wrong response: %s", resp)
+			return
+		}
+	})
+
+	t.Run("retry with idempotency key", func(t *testing.T) {
+		// the signup runs once: a second email or log event would fail the mocks
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("idempotency-key", "signup-key-1"))
+		newReq := func() *api.SignupWithEmailMsg {
+			return &api.SignupWithEmailMsg{
+				Email:             "test-signup-idempotent@test.com",
+				Password:          "SuperSecurePassword123!§$",
+				InstanceId:        testInstanceID,
+				PreferredLanguage: "en",
+			}
+		}
+		resp1, err := s.SignupWithEmail(ctx, newReq())
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		resp2, err := s.SignupWithEmail(ctx, newReq())
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		// tokens are not stored with the idempotency key, the retry gets new ones for the same account
+		if resp2.AccessToken == "" || resp2.RefreshToken == resp1.RefreshToken || resp2.SelectedProfileId != resp1.SelectedProfileId {
+			t.Errorf("unexpected response: %s, first one: %s", resp2, resp1)
+		}
+
+		if _, err := testUserDBService.GetUserByAccountID(context.Background(), testInstanceID, "test-signup-idempotent@test.com"); err != nil {
+			t.Errorf("user not created: %s", err.Error())
+		}
+
+		req := newReq()
+		req.Password = "OtherSecurePassword123!§$"
+		_, err = s.SignupWithEmail(ctx, req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, idempotencyKeyUsedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("idempotency key used for another signup", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("idempotency-key", "signup-key-1"))
+		_, err := s.SignupWithEmail(ctx, &api.SignupWithEmailMsg{
+			Email:             "test-signup-idempotent-2@test.com",
+			Password:          "SuperSecurePassword123!§$",
+			InstanceId:        testInstanceID,
+			PreferredLanguage: "en",
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "idempotency key already used for another request")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("failed signup releases the idempotency key", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("idempotency-key", "signup-key-2"))
+		_, err := s.SignupWithEmail(ctx, wrongPasswordFormatNewUserReq)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "password too weak")
+		if !ok {
+			t.Error(msg)
+			return
+		}
+		_, err = s.SignupWithEmail(ctx, wrongPasswordFormatNewUserReq)
+		ok, msg = shouldHaveGrpcErrorStatus(err, "password too weak")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
+
+func TestIPRateLimiter(t *testing.T) {
+	l := newIPRateLimiter()
+	now := time.Now().Unix()
+
+	for i := 0; i < 2; i++ {
+		if !l.allow("10.0.0.1", now, 2, 60) {
+			t.Errorf("request %d should be allowed", i)
+		}
+	}
+	if l.allow("10.0.0.1", now, 2, 60) {
+		t.Error("request over the limit should be refused")
+	}
+	if !l.allow("10.0.0.2", now, 2, 60) {
+		t.Error("request of another IP should be allowed")
+	}
+	if !l.allow("10.0.0.1", now+61, 2, 60) {
+		t.Error("request after the window should be allowed")
+	}
+	if _, ok := l.attempts.entries["10.0.0.2"]; ok {
+		t.Error("IP without recent requests should be removed")
+	}
+}
+
+func TestIPRateLimiterMaxKeys(t *testing.T) {
+	l := newIPRateLimiter()
+	l.attempts.maxKeys = 3
+	now := time.Now().Unix()
+
+	for i := 1; i < 10; i++ {
+		if !l.allow(fmt.Sprintf("10.0.0.%d", i), now, 1, 60) {
+			t.Errorf("first request of IP %d should be allowed", i)
+		}
+		if len(l.attempts.entries) > 3 || l.attempts.lru.Len() > 3 {
+			t.Errorf("too many IPs tracked: %d", len(l.attempts.entries))
+			return
+		}
+	}
+	if _, ok := l.attempts.entries["10.0.0.6"]; ok {
+		t.Error("least recently seen IP should be dropped")
+	}
+	if l.allow("10.0.0.9", now, 1, 60) {
+		t.Error("request of a tracked IP over the limit should be refused")
+	}
+}
+
+func TestSignupRateLimitPerIP(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+			SignupPerIPWindow:        time.Minute,
+		},
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
+		},
+		newUserCountLimit: 100,
+		signupPerIPLimit:  2,
+		signupLimiter:     newIPRateLimiter(),
+		trustedProxies:    testTrustedProxies,
+	}
+	mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+
+	signupFrom := func(ctx context.Context, email string) error {
+		_, err := s.SignupWithEmail(ctx, &api.SignupWithEmailMsg{
+			Email:             email,
+			Password:          "SuperSecurePassword123!§$",
+			InstanceId:        testInstanceID,
+			PreferredLanguage: "en",
+		})
+		return err
+	}
+	signup := func(ip string, email string) error {
+		return signupFrom(contextFromProxy(ip), email)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := signup("192.0.2.1", fmt.Sprintf("signup-ip-limit-%d@test.com", i)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+	}
+
+	t.Run("over the limit from the same IP", func(t *testing.T) {
+		err := signup("192.0.2.1", "signup-ip-limit-2@test.com")
+		ok, msg := shouldHaveGrpcErrorStatus(err, "too many signups, please try again later")
+		if !ok {
+			t.Error(msg)
+		}
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("unexpected code: %v", status.Code(err))
+		}
+		if _, err := testUserDBService.GetUserByAccountID(context.Background(), testInstanceID, "signup-ip-limit-2@test.com"); err == nil {
+			t.Error("user should not be created")
+		}
+	})
+
+	t.Run("with spoofed forwarded IP", func(t *testing.T) {
+		err := signup("192.0.2.99, 192.0.2.1", "signup-ip-limit-4@test.com")
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("with forwarded IP from untrusted client", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(contextFromPeer("192.0.2.1"), metadata.Pairs("x-forwarded-for", "192.0.2.98"))
+		err := signupFrom(ctx, "signup-ip-limit-5@test.com")
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("from another IP", func(t *testing.T) {
+		if err := signup("192.0.2.2", "signup-ip-limit-3@test.com"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestMinimumAge(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		instanceConfigs: newInstanceConfigCache(time.Minute),
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
+		},
+		newUserCountLimit: 100,
+	}
+	mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+
+	ageGatedInstance := testInstanceID + "_minimum_age"
+	if err := testGlobalDBService.SaveInstanceConfig(ageGatedInstance, models.InstanceConfig{MinimumAge: 16}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	underage := time.Now().AddDate(-15, 0, 0).Format(models.BIRTHDATE_LAYOUT)
+	adult := time.Now().AddDate(-30, 0, 0).Format(models.BIRTHDATE_LAYOUT)
+
+	signup := func(email string, birthdate string) error {
+		ctx := context.Background()
+		if birthdate != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(birthdateMetadataKey, birthdate))
+		}
+		_, err := s.SignupWithEmail(ctx, &api.SignupWithEmailMsg{
+			Email:             email,
+			Password:          "SuperSecurePassword123!§$",
+			InstanceId:        ageGatedInstance,
+			PreferredLanguage: "en",
+		})
+		return err
+	}
+
+	t.Run("signup without birthdate", func(t *testing.T) {
+		err := signup("minimum-age-missing@test.com", "")
+		ok, msg := shouldHaveGrpcErrorStatus(err, "birthdate required")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("signup under the minimum age", func(t *testing.T) {
+		err := signup("minimum-age-underage@test.com", underage)
+		ok, msg := shouldHaveGrpcErrorStatus(err, minimumAgeNotReachedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_MINIMUM_AGE_NOT_REACHED)
+		if !ok {
+			t.Error(msg)
+		}
+		if _, err := testUserDBService.GetUserByAccountID(context.Background(), ageGatedInstance, "minimum-age-underage@test.com"); err == nil {
+			t.Error("user should not be created")
+		}
+	})
+
+	t.Run("external IdP login of a new user without birthdate", func(t *testing.T) {
+		_, err := s.LoginWithExternalIDP(context.Background(), &api.LoginWithExternalIDPMsg{
+			InstanceId: ageGatedInstance,
+			Email:      "minimum-age-external@test.com",
+			Role:       constants.USER_ROLE_PARTICIPANT,
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "birthdate required")
+		if !ok {
+			t.Error(msg)
+		}
+		if _, err := testUserDBService.GetUserByAccountID(context.Background(), ageGatedInstance, "minimum-age-external@test.com"); err == nil {
+			t.Error("user should not be created")
+		}
+	})
+
+	var user models.User
+	t.Run("signup of an adult", func(t *testing.T) {
+		if err := signup("minimum-age-adult@test.com", adult); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		var err error
+		user, err = testUserDBService.GetUserByAccountID(context.Background(), ageGatedInstance, "minimum-age-adult@test.com")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(user.Profiles) != 1 || user.Profiles[0].Birthdate != adult {
+			t.Errorf("unexpected profiles: %v", user.Profiles)
+		}
+	})
+	if user.ID.IsZero() {
+		return
+	}
+	token := &api_types.TokenInfos{
+		Id:         user.ID.Hex(),
+		InstanceId: ageGatedInstance,
+	}
+
+	t.Run("underage birthdate for the main profile", func(t *testing.T) {
+		_, err := s.SetProfileBirthdate(context.Background(), &ProfileBirthdateMsg{Token: token, ProfileId: user.Profiles[0].ID.Hex(), Birthdate: underage})
+		ok, msg := shouldHaveGrpcErrorStatus(err, minimumAgeNotReachedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("child profile is exempt", func(t *testing.T) {
+		updUser, err := s.SaveProfile(context.Background(), &api.ProfileRequest{
+			Token:   token,
+			Profile: &api.Profile{Alias: "child"},
+		})
+		if err != nil || len(updUser.Profiles) != 2 {
+			t.Errorf("unexpected result: %v - %v", updUser, err)
+			return
+		}
+		childID := updUser.Profiles[1].Id
+
+		// not yet linked to a guardian
+		_, err = s.SetProfileBirthdate(context.Background(), &ProfileBirthdateMsg{Token: token, ProfileId: childID, Birthdate: underage})
+		ok, msg := shouldHaveGrpcErrorStatus(err, minimumAgeNotReachedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+
+		if _, err := s.LinkChildProfile(context.Background(), &ProfileLinkMsg{Token: token, ChildProfileId: childID, GuardianProfileId: user.Profiles[0].ID.Hex()}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if _, err := s.SetProfileBirthdate(context.Background(), &ProfileBirthdateMsg{Token: token, ProfileId: childID, Birthdate: underage}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+
+		// the child can't become the account holder, even once unlinked
+		if _, err := s.UnlinkChildProfile(context.Background(), &ProfileLinkMsg{Token: token, ChildProfileId: childID}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		_, err = s.ReorderProfiles(context.Background(), &ReorderProfilesMsg{Token: token, ProfileIds: []string{childID, user.Profiles[0].ID.Hex()}})
+		ok, msg = shouldHaveGrpcErrorStatus(err, minimumAgeNotReachedMsg)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
+
+func TestStepUpAuthentication(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			StepUpAuthMaxAge: 5 * time.Minute,
+		},
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
+		},
+	}
+	mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+
+	password := "SuperSecurePassword123!§$"
+	hashedPw, err := pwhash.HashPassword(password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now := time.Now().Unix()
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account:    models.Account{Type: models.ACCOUNT_TYPE_EMAIL, AccountID: "step_up_fresh@test.com", Password: hashedPw},
+			Timestamps: models.Timestamps{LastStrongAuth: now - 60},
+		},
+		{
+			Account:    models.Account{Type: models.ACCOUNT_TYPE_EMAIL, AccountID: "step_up_stale@test.com", Password: hashedPw},
+			Timestamps: models.Timestamps{LastStrongAuth: now - 3600, LastTokenRefresh: now},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	freshUser, staleUser := testUsers[0], testUsers[1]
+	tokenOf := func(u models.User) *api_types.TokenInfos {
+		return &api_types.TokenInfos{Id: u.ID.Hex(), InstanceId: testInstanceID, IssuedAt: now}
+	}
+
+	t.Run("fresh authentication", func(t *testing.T) {
+		_, err := s.DeleteAccount(context.Background(), &api.UserReference{Token: tokenOf(freshUser), UserId: freshUser.ID.Hex()})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if _, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, freshUser.ID.Hex()); err == nil {
+			t.Error("user should be deleted")
+		}
+	})
+
+	t.Run("stale authentication with recent token", func(t *testing.T) {
+		_, err := s.DeleteAccount(context.Background(), &api.UserReference{Token: tokenOf(staleUser), UserId: staleUser.ID.Hex()})
+		ok, msg := shouldHaveGrpcErrorStatus(err, reauthenticationRequiredMsg)
+		if !ok {
+			t.Error(msg)
+		}
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_REAUTHENTICATION_REQUIRED)
+		if !ok {
+			t.Error(msg)
+		}
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("unexpected code: %v", status.Code(err))
+		}
+		if _, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, staleUser.ID.Hex()); err != nil {
+			t.Errorf("user should not be deleted: %v", err)
+		}
+	})
+
+	t.Run("reauthenticate with wrong password", func(t *testing.T) {
+		_, err := s.Reauthenticate(context.Background(), &ReauthenticateMsg{Token: tokenOf(staleUser), Password: "wrong-password"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid password")
+		if !ok {
+			t.Error(msg)
+		}
+		_, err = s.DeleteAccount(context.Background(), &api.UserReference{Token: tokenOf(staleUser), UserId: staleUser.ID.Hex()})
+		ok, msg = shouldHaveGrpcErrorStatus(err, reauthenticationRequiredMsg)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("reauthenticate with password", func(t *testing.T) {
+		if _, err := s.Reauthenticate(context.Background(), &ReauthenticateMsg{Token: tokenOf(staleUser), Password: password}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if _, err := s.DeleteAccount(context.Background(), &api.UserReference{Token: tokenOf(staleUser), UserId: staleUser.ID.Hex()}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("check disabled", func(t *testing.T) {
+		s.Intervals.StepUpAuthMaxAge = 0
+		if err := s.requireRecentAuth(models.User{}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+type fakeDisposableEmailDetector struct {
+	disposable bool
+	err        error
+}
+
+func (d fakeDisposableEmailDetector) IsDisposable(email string) (bool, error) {
+	return d.disposable, d.err
+}
+
+func TestDisposableEmailCheck(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
+		},
+		newUserCountLimit: 100,
+	}
+	mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+
+	signupReq := func(email string) *api.SignupWithEmailMsg {
+		return &api.SignupWithEmailMsg{
+			Email:             email,
+			Password:          "SuperSecurePassword123!§$",
+			InstanceId:        testInstanceID,
+			PreferredLanguage: "en",
+		}
+	}
+	expectLogEvents := func() *[]string {
+		events := []string{}
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, req *loggingAPI.NewLogEvent, opts ...grpc.CallOption) (*api_types.ServiceStatus, error) {
+				events = append(events, req.EventName)
+				return nil, nil
+			}).AnyTimes()
+		return &events
+	}
+
+	t.Run("detector result", func(t *testing.T) {
+		s.disposableEmails = models.DisposableEmailConfig{}
+		if s.isDisposableEmail("test@trashmail.com") {
+			t.Error("without detector no address should be disposable")
+		}
+		s.disposableEmails.Detector = fakeDisposableEmailDetector{disposable: true, err: errors.New("detector unavailable")}
+		if s.isDisposableEmail("test@trashmail.com") {
+			t.Error("address should be accepted when the detector fails")
+		}
+		s.disposableEmails.Detector = fakeDisposableEmailDetector{disposable: true}
+		if !s.isDisposableEmail("test@trashmail.com") {
+			t.Error("address should be disposable")
+		}
+	})
+
+	t.Run("reject disposable address at signup", func(t *testing.T) {
+		s.disposableEmails = models.DisposableEmailConfig{
+			Detector: fakeDisposableEmailDetector{disposable: true},
+			Action:   models.DISPOSABLE_EMAIL_REJECT,
+		}
+		_, err := s.SignupWithEmail(context.Background(), signupReq("disposable-rejected@trashmail.com"))
+		ok, msg := shouldHaveGrpcErrorStatus(err, "disposable email not allowed")
+		if !ok {
+			t.Error(msg)
+		}
+		if _, err := testUserDBService.GetUserByAccountID(context.Background(), testInstanceID, "disposable-rejected@trashmail.com"); err == nil {
+			t.Error("user should not be created")
+		}
 	})
 
-	t.Run("with wrong password format", func(t *testing.T) {
-		_, err := s.SignupWithEmail(context.Background(), wrongPasswordFormatNewUserReq)
-		ok, msg := shouldHaveGrpcErrorStatus(err, "password too weak")
+	t.Run("reject disposable address when adding an email", func(t *testing.T) {
+		s.disposableEmails = models.DisposableEmailConfig{
+			Detector: fakeDisposableEmailDetector{disposable: true},
+			Action:   models.DISPOSABLE_EMAIL_REJECT,
+		}
+		_, err := s.AddEmail(context.Background(), &api.ContactInfoMsg{
+			Token: &api_types.TokenInfos{Id: primitive.NewObjectID().Hex(), InstanceId: testInstanceID},
+			ContactInfo: &api.ContactInfo{
+				Type:    "email",
+				Address: &api.ContactInfo_Email{Email: "disposable@trashmail.com"},
+			},
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "disposable email not allowed")
 		if !ok {
 			t.Error(msg)
 		}
 	})
 
-	t.Run("with valid fields", func(t *testing.T) {
-		mockMessagingClient.EXPECT().SendInstantEmail(
-			gomock.Any(),
-			gomock.Any(),
-		).Return(nil, nil)
-
-		mockLoggingClient.EXPECT().SaveLogEvent(
-			gomock.Any(),
-			gomock.Any(),
-		).Return(nil, nil)
-
-		resp, err := s.SignupWithEmail(context.Background(), validNewUserReq)
-		if err != nil {
-			t.Errorf("unexpected error: %s", err.Error())
-			return
-		}
-		if len(resp.AccessToken) < 1 || len(resp.RefreshToken) < 1 {
-			t.Errorf("unexpected response: %s", resp)
-			return
+	t.Run("accept other addresses when rejecting", func(t *testing.T) {
+		s.disposableEmails = models.DisposableEmailConfig{
+			Detector: fakeDisposableEmailDetector{disposable: false},
+			Action:   models.DISPOSABLE_EMAIL_REJECT,
 		}
-		if len(resp.SelectedProfileId) < 1 {
-			t.Errorf("unexpected selected profile: %s", resp.SelectedProfileId)
+		events := expectLogEvents()
+		_, err := s.SignupWithEmail(context.Background(), signupReq("not-disposable@test.com"))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
 			return
 		}
-		if len(resp.Profiles) != 1 {
-			t.Errorf("unexpected number of profiles: %d", len(resp.Profiles))
-			return
+		for _, e := range *events {
+			if e == models.LOG_EVENT_DISPOSABLE_EMAIL {
+				t.Error("address should not be flagged")
+			}
 		}
 	})
 
-	t.Run("with duplicate user (same email)", func(t *testing.T) {
-		mockMessagingClient.EXPECT().SendInstantEmail(
-			gomock.Any(),
-			gomock.Any(),
-		).Return(nil, nil)
-		mockLoggingClient.EXPECT().SaveLogEvent(
-			gomock.Any(),
-			gomock.Any(),
-		).Return(nil, nil)
-
-		req := &api.SignupWithEmailMsg{
-			Email:             "test-signup-1@test.com",
-			Password:          "SuperSecurePassword123!§$",
-			InstanceId:        testInstanceID,
-			PreferredLanguage: "en",
+	t.Run("flag disposable address at signup", func(t *testing.T) {
+		s.disposableEmails = models.DisposableEmailConfig{
+			Detector: fakeDisposableEmailDetector{disposable: true},
+			Action:   models.DISPOSABLE_EMAIL_FLAG,
 		}
-		_, err := s.SignupWithEmail(context.Background(), req)
+		events := expectLogEvents()
+		_, err := s.SignupWithEmail(context.Background(), signupReq("disposable-flagged@trashmail.com"))
 		if err != nil {
-			t.Errorf("unexpected error: %s", err.Error())
+			t.Errorf("unexpected error: %v", err)
 			return
 		}
-
-		// Try to signup again:
-		resp, err := s.SignupWithEmail(context.Background(), req)
-		if err == nil || resp != nil {
-			t.Errorf("should fail, when user exists already, wrong response: %s", resp)
-			return
+		if _, err := testUserDBService.GetUserByAccountID(context.Background(), testInstanceID, "disposable-flagged@trashmail.com"); err != nil {
+			t.Errorf("user should be created: %v", err)
+		}
+		flagged := false
+		for _, e := range *events {
+			if e == models.LOG_EVENT_DISPOSABLE_EMAIL {
+				flagged = true
+			}
+		}
+		if !flagged {
+			t.Error("address should be flagged")
 		}
 	})
 }
@@ -902,8 +2058,9 @@ func TestResendContactVerificationEndpoint(t *testing.T) {
 		userDBservice:   testUserDBService,
 		globalDBService: testGlobalDBService,
 		Intervals: models.Intervals{
-			TokenExpiryInterval:      time.Second * 2,
-			VerificationCodeLifetime: 60,
+			TokenExpiryInterval:              time.Second * 2,
+			VerificationCodeLifetime:         60,
+			ContactVerificationTokenLifetime: time.Hour,
 		},
 		clients: &models.APIClients{
 			MessagingService: mockMessagingClient,
@@ -988,4 +2145,293 @@ func TestResendContactVerificationEndpoint(t *testing.T) {
 			return
 		}
 	})
+
+	t.Run("during cooldown", func(t *testing.T) {
+		req := &api.ResendContactVerificationReq{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[0].ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+			Address: "test_for_resend_verify_contact@test.com",
+			Type:    "email",
+		}
+		_, err := s.ResendContactVerification(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "cannot send verification so often")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("after cooldown reuses token", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		user.ContactInfos[0].ConfirmationLinkSentAt = time.Now().Unix() - contactVerificationMessageCooldown - 1
+		if _, err := testUserDBService.UpdateUser(context.Background(), testInstanceID, user); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		req := &api.ResendContactVerificationReq{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[0].ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+			Address: "test_for_resend_verify_contact@test.com",
+			Type:    "email",
+		}
+		_, err = s.ResendContactVerification(context.Background(), req)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		tts, err := testGlobalDBService.GetTempTokenForUser(testInstanceID, testUsers[0].ID.Hex(), constants.TOKEN_PURPOSE_CONTACT_VERIFICATION)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(tts) != 1 {
+			t.Errorf("token should be reused, found %d tokens", len(tts))
+		}
+	})
+
+	t.Run("with already confirmed address", func(t *testing.T) {
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		user.ContactInfos[0].ConfirmedAt = time.Now().Unix()
+		if _, err := testUserDBService.UpdateUser(context.Background(), testInstanceID, user); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		req := &api.ResendContactVerificationReq{
+			Token: &api_types.TokenInfos{
+				Id:         testUsers[0].ID.Hex(),
+				InstanceId: testInstanceID,
+			},
+			Address: "test_for_resend_verify_contact@test.com",
+			Type:    "email",
+		}
+		_, err = s.ResendContactVerification(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "address already confirmed")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
+
+func TestConfirmContactWithCodeEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:            time.Second * 2,
+			VerificationCodeLifetime:       60,
+			VerificationCodeResendCooldown: -1,
+		},
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
+		},
+	}
+
+	addr := "test_for_confirm_contact_code@test.com"
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: addr,
+			},
+			Profiles: []models.Profile{
+				{
+					ID:    primitive.NewObjectID(),
+					Alias: "main",
+				},
+			},
+			ContactInfos: []models.ContactInfo{
+				{
+					Type:  "email",
+					Email: addr,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	token := &api_types.TokenInfos{
+		Id:         testUsers[0].ID.Hex(),
+		InstanceId: testInstanceID,
+	}
+	setCode := func(vc *models.VerificationCode) error {
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
+		if err != nil {
+			return err
+		}
+		user.SetEmailVerificationCode(addr, vc)
+		_, err = testUserDBService.UpdateUser(context.Background(), testInstanceID, user)
+		return err
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.ConfirmContactWithCode(context.Background(), &ContactVerificationCodeMsg{})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("send code", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		_, err := s.SendContactVerificationCode(context.Background(), &ContactVerificationCodeMsg{Token: token, Address: addr})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		vc := user.ContactInfos[0].VerificationCode
+		if vc == nil || len(vc.Code) != 6 || vc.ExpiresAt <= time.Now().Unix() {
+			t.Errorf("unexpected verification code: %v", vc)
+		}
+
+		_, err = s.SendContactVerificationCode(context.Background(), &ContactVerificationCodeMsg{Token: token, Address: addr})
+		ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_VERIFICATION_CODE_COOLDOWN)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with expired code", func(t *testing.T) {
+		if err := setCode(&models.VerificationCode{Code: "123456", CreatedAt: time.Now().Unix() - 120, ExpiresAt: time.Now().Unix() - 60}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		_, err := s.ConfirmContactWithCode(context.Background(), &ContactVerificationCodeMsg{Token: token, Address: addr, Code: "123456"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "verification code expired")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with wrong code until limit", func(t *testing.T) {
+		if err := setCode(&models.VerificationCode{Code: "123456", CreatedAt: time.Now().Unix(), ExpiresAt: time.Now().Unix() + 60}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		for i := 0; i < allowedVerificationCodeAttempts; i++ {
+			mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil)
+			_, err := s.ConfirmContactWithCode(context.Background(), &ContactVerificationCodeMsg{Token: token, Address: addr, Code: "654321"})
+			ok, msg := shouldHaveGrpcErrorStatus(err, "wrong verification code")
+			if !ok {
+				t.Error(msg)
+				return
+			}
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.ContactInfos[0].VerificationCode.Attempts != allowedVerificationCodeAttempts {
+			t.Errorf("unexpected attempts: %d", user.ContactInfos[0].VerificationCode.Attempts)
+		}
+
+		// the correct code is rejected too once the limit is reached
+		_, err = s.ConfirmContactWithCode(context.Background(), &ContactVerificationCodeMsg{Token: token, Address: addr, Code: "123456"})
+		ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_TOO_MANY_REQUESTS)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with concurrent wrong codes", func(t *testing.T) {
+		if err := setCode(&models.VerificationCode{Code: "123456", CreatedAt: time.Now().Unix(), ExpiresAt: time.Now().Unix() + 60}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil).Times(allowedVerificationCodeAttempts)
+
+		n := allowedVerificationCodeAttempts * 3
+		errs := make(chan error, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.ConfirmContactWithCode(context.Background(), &ContactVerificationCodeMsg{Token: token, Address: addr, Code: "654321"})
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		wrongCode := 0
+		for err := range errs {
+			if ok, _ := shouldHaveErrorCode(err, models.ERROR_CODE_TOO_MANY_REQUESTS); ok {
+				continue
+			}
+			if ok, msg := shouldHaveGrpcErrorStatus(err, "wrong verification code"); !ok {
+				t.Error(msg)
+				continue
+			}
+			wrongCode++
+		}
+		if wrongCode != allowedVerificationCodeAttempts {
+			t.Errorf("unexpected number of checked codes: %d", wrongCode)
+		}
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.ContactInfos[0].VerificationCode.Attempts != allowedVerificationCodeAttempts {
+			t.Errorf("unexpected attempts: %d", user.ContactInfos[0].VerificationCode.Attempts)
+		}
+	})
+
+	t.Run("with correct code", func(t *testing.T) {
+		if err := setCode(&models.VerificationCode{Code: "123456", CreatedAt: time.Now().Unix(), ExpiresAt: time.Now().Unix() + 60}); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil)
+		user, err := s.ConfirmContactWithCode(context.Background(), &ContactVerificationCodeMsg{Token: token, Address: addr, Code: "123-456"})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.ContactInfos[0].ConfirmedAt <= 0 || user.Account.AccountConfirmedAt <= 0 {
+			t.Errorf("contact should be confirmed: %v", user)
+		}
+
+		_, err = s.ConfirmContactWithCode(context.Background(), &ContactVerificationCodeMsg{Token: token, Address: addr, Code: "123456"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "address already confirmed")
+		if !ok {
+			t.Error(msg)
+		}
+	})
 }
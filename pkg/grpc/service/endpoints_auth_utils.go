@@ -1,50 +1,127 @@
 package service
 
 import (
+	"container/list"
 	"context"
 	"fmt"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/coneno/logger"
+	api_types "github.com/influenzanet/go-utils/pkg/api_types"
 	constants "github.com/influenzanet/go-utils/pkg/constants"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
 	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/dbs/userdb"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"github.com/influenzanet/user-management-service/pkg/utils"
+	"go.mongodb.org/mongo-driver/mongo"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
-func (s *userManagementServer) generateAndSendVerificationCode(instanceID string, user models.User) error {
+const verificationCodeCooldownMsg = "please wait before requesting a new verification code"
+
+// returned with FailedPrecondition by sensitive operations, the client should ask for the password and call Reauthenticate
+const reauthenticationRequiredMsg = "reauthentication required"
+
+const policyAcceptanceRequiredMsg = "policy acceptance required"
+
+const minimumAgeNotReachedMsg = "minimum age not reached"
+
+// returned with PermissionDenied by self-service operations an admin must not do with an impersonation token
+const impersonationNotAllowedMsg = "not allowed while impersonating"
+
+// returned with PermissionDenied by account-wide operations requested with the token of a child profile
+const childProfileNotAllowedMsg = "not allowed for child profiles"
+
+// returned for email addresses in the released email cooldown of the instance
+const emailRecentlyReleasedMsg = "email address not available yet, please try again later"
+
+func (s *userManagementServer) generateAndSendVerificationCode(ctx context.Context, instanceID string, user models.User) error {
 	vc, err := tokens.GenerateVerificationCode(6)
 	if err != nil {
 		logger.Error.Printf("unexpected error while generating verification code: %v", err)
 		return status.Error(codes.Internal, "error while generating verification code")
 	}
 
+	now := time.Now().Unix()
 	user.Account.VerificationCode = models.VerificationCode{
 		Code:      vc,
 		Attempts:  0,
-		CreatedAt: time.Now().Unix(),
-		ExpiresAt: time.Now().Unix() + s.Intervals.VerificationCodeLifetime,
+		CreatedAt: now,
+		ExpiresAt: now + s.verificationCodeLifetime(instanceID),
 	}
-	user, err = s.userDBservice.UpdateUser(instanceID, user)
+	user, err = s.userDBservice.UpdateUser(ctx, instanceID, user)
 	if err != nil {
 		logger.Error.Printf("generateAndSendVerificationCode: unexpected error when saving user -> %v", err)
-		return status.Error(codes.Internal, "user couldn't be updated")
+		return userUpdateError(err, "user couldn't be updated")
 	}
 
 	// ---> Trigger message sending
-	half := len(vc) / 2
-	formattedCode := fmt.Sprintf("%s-%s", vc[:half], vc[half:])
-	go s.sendVerificationEmail(instanceID, user.Account.AccountID, formattedCode, user.Account.PreferredLanguage)
+	go s.sendVerificationEmail(instanceID, user.Account.AccountID, formatVerificationCode(vc), user.Account.PreferredLanguage)
 	return nil
 }
 
+// sendExistingOrNewVerificationCode re-sends the current verification code while it is still valid, otherwise a new one is generated.
+// The cooldown is enforced on both paths, so CreatedAt is refreshed when an existing code is sent again.
+func (s *userManagementServer) sendExistingOrNewVerificationCode(ctx context.Context, instanceID string, user models.User) error {
+	if s.isVerificationCodeCooldownActive(user.Account.VerificationCode) {
+		return errorWithCode(codes.InvalidArgument, verificationCodeCooldownMsg, models.ERROR_CODE_VERIFICATION_CODE_COOLDOWN)
+	}
+
+	vc := user.Account.VerificationCode
+	if vc.Code == "" || vc.ExpiresAt < time.Now().Unix() {
+		return s.generateAndSendVerificationCode(ctx, instanceID, user)
+	}
+
+	user.Account.VerificationCode.CreatedAt = time.Now().Unix()
+	user, err := s.userDBservice.UpdateUser(ctx, instanceID, user)
+	if err != nil {
+		logger.Error.Printf("sendExistingOrNewVerificationCode: unexpected error when saving user -> %v", err)
+		return userUpdateError(err, "user couldn't be updated")
+	}
+
+	// ---> Trigger message sending
+	go s.sendVerificationEmail(instanceID, user.Account.AccountID, formatVerificationCode(vc.Code), user.Account.PreferredLanguage)
+	return nil
+}
+
+// verificationCodeLifetime returns the lifetime in seconds of new verification codes, the instance setting takes precedence over the global one
+func (s *userManagementServer) verificationCodeLifetime(instanceID string) int64 {
+	conf := s.getInstanceConfig(instanceID)
+	if conf.VerificationCodeLifetime > 0 {
+		return conf.VerificationCodeLifetime
+	}
+	return s.Intervals.VerificationCodeLifetime
+}
+
+// verificationCodeResendCooldown is the configured cooldown, the default one if it is unset (negative), 0 disables it
+func (s *userManagementServer) verificationCodeResendCooldown() int64 {
+	if s.Intervals.VerificationCodeResendCooldown < 0 {
+		return loginVerificationCodeCooldown
+	}
+	return s.Intervals.VerificationCodeResendCooldown
+}
+
+func (s *userManagementServer) isVerificationCodeCooldownActive(vc models.VerificationCode) bool {
+	return vc.CreatedAt > time.Now().Unix()-s.verificationCodeResendCooldown()
+}
+
+func formatVerificationCode(code string) string {
+	half := len(code) / 2
+	return fmt.Sprintf("%s-%s", code[:half], code[half:])
+}
+
 func (s *userManagementServer) sendVerificationEmail(instanceID string, accountID string, code string, preferredLang string) {
 	if s.clients.MessagingService == nil {
 		return
 	}
-	_, err := s.clients.MessagingService.SendInstantEmail(context.TODO(), &messageAPI.SendEmailReq{
+	err := s.sendInstantEmail(context.TODO(), &messageAPI.SendEmailReq{
 		InstanceId:  instanceID,
 		To:          []string{accountID},
 		MessageType: constants.EMAIL_TYPE_AUTH_VERIFICATION_CODE,
@@ -58,11 +135,373 @@ func (s *userManagementServer) sendVerificationEmail(instanceID string, accountI
 	}
 }
 
+// requireRecentAuth checks that the user authenticated with password or external IdP within Intervals.StepUpAuthMaxAge.
+// A valid access token is not enough, as it can be renewed with the refresh token without authenticating.
+func (s *userManagementServer) requireRecentAuth(user models.User) error {
+	maxAge := int64(s.Intervals.StepUpAuthMaxAge.Seconds())
+	if maxAge <= 0 {
+		return nil
+	}
+	if user.Timestamps.LastStrongAuth < time.Now().Unix()-maxAge {
+		return errorWithCode(codes.FailedPrecondition, reauthenticationRequiredMsg, models.ERROR_CODE_REAUTHENTICATION_REQUIRED)
+	}
+	return nil
+}
+
+// rejectChildProfile denies actions on the whole account when the token was issued for a child profile, which only the
+// guardian's context may perform
+func rejectChildProfile(token *api_types.TokenInfos, user models.User) error {
+	if token.ProfilId != "" && user.IsChildProfile(token.ProfilId) {
+		return errorWithCode(codes.PermissionDenied, childProfileNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_FOR_CHILD_PROFILE)
+	}
+	return nil
+}
+
+// tokenProfileScope returns the profile a renewed access token is issued for, selectedProfileID or by default the main
+// profile, and the other profiles it gives access to. The token of a child profile stays limited to it and can't select
+// another profile, the guardian has to log in again.
+func tokenProfileScope(user models.User, currentProfileID string, selectedProfileID string) (string, []string, error) {
+	if currentProfileID != "" {
+		if _, err := user.FindProfile(currentProfileID); err != nil {
+			// removed meanwhile, maybe a child profile
+			return "", nil, errorWithCode(codes.PermissionDenied, "refresh token error", models.ERROR_CODE_INVALID_REFRESH_TOKEN)
+		}
+	}
+	if user.IsChildProfile(currentProfileID) {
+		if selectedProfileID != "" && selectedProfileID != currentProfileID {
+			return "", nil, errorWithCode(codes.PermissionDenied, childProfileNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_FOR_CHILD_PROFILE)
+		}
+		return currentProfileID, []string{}, nil
+	}
+
+	mainProfileID, otherProfileIDs := utils.GetMainAndOtherProfiles(user)
+	if selectedProfileID == "" || selectedProfileID == mainProfileID {
+		return mainProfileID, otherProfileIDs, nil
+	}
+	selected, err := user.FindProfile(selectedProfileID)
+	if err != nil {
+		return "", nil, status.Error(codes.InvalidArgument, "profile not found")
+	}
+	if selected.IsChild() {
+		return selectedProfileID, []string{}, nil
+	}
+	others := []string{mainProfileID}
+	for _, id := range otherProfileIDs {
+		if id != selectedProfileID {
+			others = append(others, id)
+		}
+	}
+	return selectedProfileID, others, nil
+}
+
+// requirePolicyAcceptance checks that the user accepted the current versions of the instance's policies, if the
+// instance requires it for sensitive actions
+func (s *userManagementServer) requirePolicyAcceptance(instanceID string, user models.User) error {
+	policies := s.getInstanceConfig(instanceID).Policies
+	if !policies.RequireAcceptance {
+		return nil
+	}
+	if missing := user.MissingPolicyAcceptances(policies.CurrentVersions); len(missing) > 0 {
+		return errorWithCode(codes.FailedPrecondition, policyAcceptanceRequiredMsg, models.ERROR_CODE_POLICY_ACCEPTANCE_REQUIRED)
+	}
+	return nil
+}
+
+// pendingPolicies returns the current versions, by policy key, of the instance's policies the user did not accept.
+// Nil if there are none.
+func (s *userManagementServer) pendingPolicies(instanceID string, user models.User) map[string]string {
+	currentVersions := s.getInstanceConfig(instanceID).Policies.CurrentVersions
+	missing := user.MissingPolicyAcceptances(currentVersions)
+	if len(missing) == 0 {
+		return nil
+	}
+	pending := make(map[string]string, len(missing))
+	for _, key := range missing {
+		pending[key] = currentVersions[key]
+	}
+	return pending
+}
+
+// isDisposableEmail checks the address with the configured detector. If the detector fails, the address is accepted.
+func (s *userManagementServer) isDisposableEmail(email string) bool {
+	if s.disposableEmails.Detector == nil {
+		return false
+	}
+	disposable, err := s.disposableEmails.Detector.IsDisposable(email)
+	if err != nil {
+		logger.Error.Printf("isDisposableEmail: %v", err)
+		return false
+	}
+	return disposable
+}
+
+// rejectDisposableEmails tells if disposable addresses are refused, otherwise they are only flagged
+func (s *userManagementServer) rejectDisposableEmails() bool {
+	return s.disposableEmails.Action == models.DISPOSABLE_EMAIL_REJECT
+}
+
+// flagDisposableEmail records that the user added a disposable email address
+func (s *userManagementServer) flagDisposableEmail(instanceID string, userID string, email string) {
+	logger.Warning.Printf("disposable email address %s used by %s in instance %s", email, userID, instanceID)
+	s.SaveLogEvent(instanceID, userID, loggingAPI.LogEventType_LOG, models.LOG_EVENT_DISPOSABLE_EMAIL, email)
+}
+
+// isInstanceIDAllowed checks that the instance exists in the global DB, with the cached instance IDs if available
 func (s *userManagementServer) isInstanceIDAllowed(instanceID string) bool {
-	for _, id := range s.instanceIDs {
-		if id == instanceID {
-			return true
+	if instanceID == "" {
+		return false
+	}
+	if s.instanceIDs != nil {
+		if s.instanceIDs.isStale() {
+			if err := s.refreshInstanceIDs(); err != nil {
+				// keep the previous IDs until the next try
+				logger.Error.Printf("isInstanceIDAllowed: %v", err)
+			}
+		}
+		return s.instanceIDs.contains(instanceID)
+	}
+	_, err := s.globalDBService.GetInstance(instanceID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		logger.Error.Printf("isInstanceIDAllowed: %v", err)
+	}
+	return err == nil
+}
+
+// createRenewTokenForSession stores the refresh token of a new login together with the client's user agent.
+// If the client asked to be remembered, the token gets the longer lifetime.
+// If the user has more sessions than allowed afterwards, the oldest ones are removed.
+func (s *userManagementServer) createRenewTokenForSession(ctx context.Context, instanceID string, userID string, renewToken string) error {
+	rememberMe := rememberMeFromContext(ctx)
+	err := s.userDBservice.CreateRenewTokenForSession(ctx, instanceID, userdb.RenewToken{
+		UserID:     userID,
+		RenewToken: renewToken,
+		ExpiresAt:  time.Now().Unix() + s.renewTokenLifetime(rememberMe),
+		UserAgent:  userAgentFromContext(ctx),
+		RememberMe: rememberMe,
+	})
+	if err != nil || s.maxSessionsPerUser < 1 {
+		return err
+	}
+
+	count, err := s.userDBservice.DeleteOldestSessionsForUser(ctx, instanceID, userID, s.maxSessionsPerUser)
+	if err != nil {
+		// new session is valid anyway, limit will be applied on next login
+		logger.Error.Printf("failed to remove oldest sessions for user %s: %v", userID, err)
+		return nil
+	}
+	if count > 0 {
+		logger.Debug.Printf("removed %d oldest sessions for user %s", count, userID)
+	}
+	return nil
+}
+
+// renewTokenLifetime returns the lifetime of new refresh tokens in seconds
+func (s *userManagementServer) renewTokenLifetime(rememberMe bool) int64 {
+	if rememberMe && s.Intervals.RememberMeRefreshTokenLifetime > 0 {
+		return int64(s.Intervals.RememberMeRefreshTokenLifetime.Seconds())
+	}
+	if s.Intervals.RefreshTokenLifetime > 0 {
+		return int64(s.Intervals.RefreshTokenLifetime.Seconds())
+	}
+	return userdb.RENEW_TOKEN_DEFAULT_LIFETIME
+}
+
+// rememberMeFromContext reports if the client sent the "remember-me" metadata, through the grpc-gateway
+// as "Grpc-Metadata-Remember-Me" header
+func rememberMeFromContext(ctx context.Context) bool {
+	return metadataValueFromContext(ctx, rememberMeMetadataKey) == "true"
+}
+
+// metadataValueFromContext returns the first value of the request metadata key, empty if not sent
+func metadataValueFromContext(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func userAgentFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	// header forwarded by the grpc-gateway takes precedence over the one of the direct grpc client
+	for _, key := range []string{"grpcgateway-user-agent", "user-agent"} {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// clientIPFromContext returns the address of the client, see utils.ClientIP for the use of the x-forwarded-for
+// header set by the grpc-gateway and other trusted proxies
+func clientIPFromContext(ctx context.Context, trustedProxies []*net.IPNet) string {
+	peerIP := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		peerIP = host
+	}
+	var forwardedFor []string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		forwardedFor = md.Get("x-forwarded-for")
+	}
+	return utils.ClientIP(peerIP, forwardedFor, trustedProxies)
+}
+
+// recentKeys keeps the state of a rate limiter per key, for at most maxKeys keys: the least recently used key is
+// dropped for a new one above. Not safe for concurrent use, the rate limiters hold their lock.
+type recentKeys struct {
+	maxKeys int
+	entries map[string]*list.Element // of lru
+	lru     *list.List               // *recentKey, the least recently used at the back
+}
+
+type recentKey struct {
+	key   string
+	value interface{}
+}
+
+func newRecentKeys(maxKeys int) *recentKeys {
+	return &recentKeys{maxKeys: maxKeys, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+// get returns the state of key, or stores newValue() for it if not found, and marks it as the most recently used.
+// The keys whose state is expired are forgotten before, they are the least recently used ones.
+func (k *recentKeys) get(key string, expired func(value interface{}) bool, newValue func() interface{}) (value interface{}, found bool) {
+	for e := k.lru.Back(); e != nil && expired(e.Value.(*recentKey).value); e = k.lru.Back() {
+		k.remove(e)
+	}
+
+	e, found := k.entries[key]
+	if !found {
+		if k.lru.Len() >= k.maxKeys {
+			k.remove(k.lru.Back())
+		}
+		e = k.lru.PushFront(&recentKey{key: key, value: newValue()})
+		k.entries[key] = e
+	} else {
+		k.lru.MoveToFront(e)
+	}
+	return e.Value.(*recentKey).value, found
+}
+
+func (k *recentKeys) remove(e *list.Element) {
+	k.lru.Remove(e)
+	delete(k.entries, e.Value.(*recentKey).key)
+}
+
+// ipRateLimiter counts requests per client IP within a sliding window, safe for concurrent requests.
+// The counts are kept in memory, so with several replicas each one applies the limit on its own. At most
+// rateLimiterMaxKeys IPs are tracked, see recentKeys.
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	attempts *recentKeys // of *ipAttempts
+}
+
+type ipAttempts struct {
+	times []int64 // unix times of the recent requests
+}
+
+func newIPRateLimiter() *ipRateLimiter {
+	return &ipRateLimiter{attempts: newRecentKeys(rateLimiterMaxKeys)}
+}
+
+// allow records a request of ip at now, unless limit requests of this ip were already recorded within the last window seconds
+func (l *ipRateLimiter) allow(ip string, now int64, limit int64, window int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// IPs without recent requests are forgotten
+	v, _ := l.attempts.get(ip, func(v interface{}) bool {
+		times := v.(*ipAttempts).times
+		return len(times) == 0 || times[len(times)-1] <= now-window
+	}, func() interface{} { return &ipAttempts{} })
+	a := v.(*ipAttempts)
+
+	recent := []int64{}
+	for _, ts := range a.times {
+		if ts > now-window {
+			recent = append(recent, ts)
+		}
+	}
+	if int64(len(recent)) >= limit {
+		a.times = recent
+		return false
+	}
+	a.times = append(recent, now)
+	return true
+}
+
+// allowSignupFromClientIP applies signupPerIPLimit to the client IP of the request. Signups without known IP are not limited.
+func (s *userManagementServer) allowSignupFromClientIP(ctx context.Context) bool {
+	if s.signupPerIPLimit <= 0 || s.signupLimiter == nil {
+		return true
+	}
+	ip := clientIPFromContext(ctx, s.trustedProxies)
+	if ip == "" {
+		return true
+	}
+	window := int64(s.Intervals.SignupPerIPWindow.Seconds())
+	if window < 1 {
+		window = signupRateLimitWindow
+	}
+	return s.signupLimiter.allow(ip, time.Now().Unix(), s.signupPerIPLimit, window)
+}
+
+// addLoginRecord adds the current login to the login history of the user, with the client IP stored as configured
+func (s *userManagementServer) addLoginRecord(ctx context.Context, user *models.User) {
+	size := s.loginHistory.Size
+	if size <= 0 {
+		size = defaultLoginHistorySize
+	}
+	user.AddLoginRecord(models.LoginRecord{
+		Time:      time.Now().Unix(),
+		IP:        utils.AnonymizeIP(clientIPFromContext(ctx, s.trustedProxies), s.loginIPStorage),
+		UserAgent: userAgentFromContext(ctx),
+	}, size, s.loginHistory.Retention)
+}
+
+// accountReactivated is called after a login or token refresh cleared the deletion mark of the user.
+// The reactivation is logged and, if configured for the instance, the user is told by email that the deletion is cancelled.
+func (s *userManagementServer) accountReactivated(ctx context.Context, instanceID string, user models.User, markedForDeletion int64) {
+	s.SaveLogEvent(instanceID, user.ID.Hex(), loggingAPI.LogEventType_LOG, models.LOG_EVENT_ACCOUNT_REACTIVATED, fmt.Sprintf("deletion scheduled at %d cancelled", markedForDeletion))
+
+	if !s.getInstanceConfig(instanceID).SendReactivationEmail {
+		return
+	}
+	err := s.sendInstantEmail(ctx, &messageAPI.SendEmailReq{
+		InstanceId:        instanceID,
+		To:                []string{user.NotificationEmail()},
+		MessageType:       models.EMAIL_TYPE_ACCOUNT_REACTIVATED,
+		PreferredLanguage: user.Account.PreferredLanguage,
+		UseLowPrio:        true,
+	})
+	if err != nil {
+		logger.Error.Printf("accountReactivated: %s", err.Error())
+	}
+}
+
+// tokenProfiles returns the profiles to embed in the access token of the user, main profile first, nil if disabled
+func (s *userManagementServer) tokenProfiles(user models.User) []models.TokenProfile {
+	if !s.embedProfilesInToken || len(user.Profiles) == 0 {
+		return nil
+	}
+	profiles := make([]models.TokenProfile, 0, len(user.Profiles))
+	for _, p := range user.Profiles {
+		tp := models.TokenProfile{ID: p.ID.Hex(), Alias: p.Alias}
+		if p.MainProfile {
+			profiles = append([]models.TokenProfile{tp}, profiles...)
+		} else {
+			profiles = append(profiles, tp)
 		}
 	}
-	return false
+	return profiles
 }
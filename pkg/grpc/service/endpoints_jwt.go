@@ -2,13 +2,16 @@ package service
 
 import (
 	"context"
-	"strings"
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/coneno/logger"
+	jwt "github.com/golang-jwt/jwt/v4"
 	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/dbs/userdb"
+	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/tokens"
 	"github.com/influenzanet/user-management-service/pkg/utils"
 	"google.golang.org/grpc/codes"
@@ -20,12 +23,32 @@ import (
 
 func (s *userManagementServer) ValidateJWT(ctx context.Context, req *api.JWTRequest) (*api_types.TokenInfos, error) {
 	if req == nil || req.Token == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 	// Parse and validate token
 	parsedToken, ok, err := tokens.ValidateToken(req.Token)
 	if err != nil || !ok {
-		return nil, status.Error(codes.InvalidArgument, "invalid token")
+		return nil, errorWithCode(codes.InvalidArgument, "invalid token", models.ERROR_CODE_INVALID_TOKEN)
+	}
+	if parsedToken.Id != "" {
+		// service account token, valid until revoked
+		sat, err := s.userDBservice.FindServiceAccountToken(ctx, parsedToken.InstanceID, parsedToken.Id)
+		if err != nil {
+			return nil, errorWithCode(codes.InvalidArgument, "invalid token", models.ERROR_CODE_INVALID_TOKEN)
+		}
+		if sat.UserID != parsedToken.ID {
+			logger.Warning.Printf("ValidateJWT: service account token %s of %s used for %s", parsedToken.Id, sat.UserID, parsedToken.ID)
+			return nil, errorWithCode(codes.InvalidArgument, "invalid token", models.ERROR_CODE_INVALID_TOKEN)
+		}
+	}
+
+	// TokenInfos has no field for the expiration, it is passed in the payload
+	payload := map[string]string{}
+	for k, v := range parsedToken.Payload {
+		payload[k] = v
+	}
+	if parsedToken.ExpiresAt > 0 {
+		payload[models.TOKEN_PAYLOAD_EXPIRES_AT] = strconv.FormatInt(parsedToken.ExpiresAt, 10)
 	}
 
 	return &api_types.TokenInfos{
@@ -33,7 +56,7 @@ func (s *userManagementServer) ValidateJWT(ctx context.Context, req *api.JWTRequ
 		InstanceId:       parsedToken.InstanceID,
 		IssuedAt:         parsedToken.IssuedAt,
 		AccountConfirmed: parsedToken.AccountConfirmed,
-		Payload:          parsedToken.Payload,
+		Payload:          payload,
 		ProfilId:         parsedToken.ProfileID,
 		OtherProfileIds:  parsedToken.OtherProfileIDs,
 		TempToken:        parsedToken.TempTokenInfos.ToAPI(),
@@ -42,47 +65,72 @@ func (s *userManagementServer) ValidateJWT(ctx context.Context, req *api.JWTRequ
 
 func (s *userManagementServer) RenewJWT(ctx context.Context, req *api.RefreshJWTRequest) (*api.TokenResponse, error) {
 	if req == nil || req.AccessToken == "" || req.RefreshToken == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 
 	// Parse and validate token
 	parsedToken, _, err := tokens.ValidateToken(req.AccessToken)
-	if err != nil && !strings.Contains(err.Error(), "token is expired by") {
+	if err != nil && !errors.Is(err, jwt.ErrTokenExpired) {
 		logger.Error.Printf("token refresh -> issue with acces token: %v", err.Error())
-		return nil, status.Error(codes.PermissionDenied, "refresh token error")
+		return nil, errorWithCode(codes.PermissionDenied, "refresh token error", models.ERROR_CODE_INVALID_REFRESH_TOKEN)
+	}
+	if parsedToken.Payload[models.TOKEN_PAYLOAD_IMPERSONATED_BY] != "" {
+		logger.Warning.Printf("token refresh -> impersonation token of %s used", parsedToken.Payload[models.TOKEN_PAYLOAD_IMPERSONATED_BY])
+		return nil, errorWithCode(codes.PermissionDenied, "refresh token error", models.ERROR_CODE_INVALID_REFRESH_TOKEN)
 	}
 
 	// Trigger cleanup of expired renew tokens
-	go s.userDBservice.DeleteExpiredRenewTokens(parsedToken.InstanceID)
+	go s.userDBservice.DeleteExpiredRenewTokens(context.Background(), parsedToken.InstanceID) // must outlive the request
 
 	// Check if user exists
-	user, err := s.userDBservice.GetUserByID(parsedToken.InstanceID, parsedToken.ID)
+	user, err := s.userDBservice.GetUserByID(ctx, parsedToken.InstanceID, parsedToken.ID)
 	if err != nil {
 		logger.Error.Printf("token refresh -> retrieving user failed with: %v", err.Error())
-		return nil, status.Error(codes.Internal, "refresh token error")
+		return nil, errorWithCode(codes.Internal, "refresh token error", models.ERROR_CODE_INVALID_REFRESH_TOKEN)
 	}
 
 	// Generate new refresh token:
 	newRefreshToken, err := tokens.GenerateUniqueTokenString()
 	if err != nil {
 		logger.Error.Printf("token refresh -> cannot generate new refresh token: %v", err.Error())
-		return nil, status.Error(codes.Internal, "refresh token error")
+		return nil, errorWithCode(codes.Internal, "refresh token error", models.ERROR_CODE_INVALID_REFRESH_TOKEN)
 	}
 
 	// Check if refresh token is valid
-	rt, err := s.userDBservice.FindAndUpdateRenewToken(parsedToken.InstanceID, user.ID.Hex(), req.RefreshToken, newRefreshToken)
+	rt, err := s.userDBservice.FindAndUpdateRenewToken(ctx, parsedToken.InstanceID, user.ID.Hex(), req.RefreshToken, newRefreshToken)
 	if err != nil {
 		logger.Error.Printf("token refresh -> failed to validate renew token (%s): %v", req.RefreshToken, err.Error())
 		s.SaveLogEvent(parsedToken.InstanceID, parsedToken.ID, loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_TOKEN_REFRESH_FAILED, "wrong refresh token, cannot renew")
-		return nil, status.Error(codes.Internal, "refresh token error")
+		return nil, errorWithCode(codes.Internal, "refresh token error", models.ERROR_CODE_INVALID_REFRESH_TOKEN)
+	}
+	// the scope of a child session is kept with the refresh token, older access tokens can't widen it
+	currentProfileID := parsedToken.ProfileID
+	if rt.ChildProfileID != "" {
+		currentProfileID = rt.ChildProfileID
+	}
+	profileID, otherProfileIDs, err := tokenProfileScope(user, currentProfileID, metadataValueFromContext(ctx, profileMetadataKey))
+	if err != nil {
+		return nil, err
+	}
+	childProfileID := ""
+	if user.IsChildProfile(profileID) {
+		childProfileID = profileID
 	}
 
 	if rt.NextToken == newRefreshToken {
 		// this is the first time the refresh token is used
-		err := s.userDBservice.CreateRenewToken(parsedToken.InstanceID, user.ID.Hex(), newRefreshToken, time.Now().Unix()+userdb.RENEW_TOKEN_DEFAULT_LIFETIME)
+		err := s.userDBservice.CreateRenewTokenForSession(ctx, parsedToken.InstanceID, userdb.RenewToken{
+			UserID:         user.ID.Hex(),
+			RenewToken:     newRefreshToken,
+			ExpiresAt:      time.Now().Unix() + s.renewTokenLifetime(rt.RememberMe),
+			CreatedAt:      rt.CreatedAt,
+			UserAgent:      rt.UserAgent,
+			RememberMe:     rt.RememberMe,
+			ChildProfileID: childProfileID,
+		})
 		if err != nil {
 			logger.Error.Printf("token refresh -> failed to create new renew token object: %v", err.Error())
-			return nil, status.Error(codes.Internal, "refresh token error")
+			return nil, errorWithCode(codes.Internal, "refresh token error", models.ERROR_CODE_INVALID_REFRESH_TOKEN)
 		}
 	} else {
 		newRefreshToken = rt.NextToken
@@ -92,20 +140,41 @@ func (s *userManagementServer) RenewJWT(ctx context.Context, req *api.RefreshJWT
 	roles := tokens.GetRolesFromPayload(parsedToken.Payload)
 	username := tokens.GetUsernameFromPayload(parsedToken.Payload)
 
-	mainProfileID, otherProfileIDs := utils.GetMainAndOtherProfiles(user)
+	apiProfiles := user.ToAPI().Profiles
+	tokenProfiles := s.tokenProfiles(user)
+	if childProfileID != "" {
+		// the other profiles of the account are not shown in the child's context
+		apiProfiles = nil
+		for _, p := range user.ToAPI().Profiles {
+			if p.Id == profileID {
+				apiProfiles = append(apiProfiles, p)
+			}
+		}
+		var childProfiles []models.TokenProfile
+		for _, p := range tokenProfiles {
+			if p.ID == profileID {
+				childProfiles = append(childProfiles, p)
+			}
+		}
+		tokenProfiles = childProfiles
+	}
 
 	// Generate new access token:
-	newToken, err := tokens.GenerateNewToken(parsedToken.ID, user.Account.AccountConfirmedAt > 0, mainProfileID, roles, parsedToken.InstanceID, s.Intervals.TokenExpiryInterval, username, nil, otherProfileIDs)
+	newToken, err := tokens.GenerateNewToken(parsedToken.ID, user.Account.AccountConfirmedAt > 0, profileID, roles, parsedToken.InstanceID, s.Intervals.TokenExpiryInterval, username, nil, otherProfileIDs, tokenProfiles)
 	if err != nil {
 		logger.Error.Printf("renew token error: %v", err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	//reset markedForDeletionTime
+	markedForDeletion := user.Timestamps.MarkedForDeletion
 	user.Timestamps.MarkedForDeletion = 0
-	user, err = s.userDBservice.UpdateUser(parsedToken.InstanceID, user)
+	user, err = s.userDBservice.UpdateUser(ctx, parsedToken.InstanceID, user)
 	if err != nil {
 		logger.Error.Printf("renew token error: %v", err.Error())
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, userUpdateError(err, err.Error())
+	}
+	if markedForDeletion > 0 {
+		s.accountReactivated(ctx, parsedToken.InstanceID, user, markedForDeletion)
 	}
 
 	s.SaveLogEvent(parsedToken.InstanceID, parsedToken.ID, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_TOKEN_REFRESH_SUCCESS, "")
@@ -115,23 +184,161 @@ func (s *userManagementServer) RenewJWT(ctx context.Context, req *api.RefreshJWT
 		RefreshToken:      newRefreshToken,
 		AccountConfirmed:  user.Account.AccountConfirmedAt > 0,
 		ExpiresIn:         int32(s.Intervals.TokenExpiryInterval / time.Minute),
-		SelectedProfileId: parsedToken.ProfileID,
-		Profiles:          user.ToAPI().Profiles,
+		SelectedProfileId: profileID,
+		Profiles:          apiProfiles,
+		PreferredLanguage: user.Account.PreferredLanguage,
+	}, nil
+}
+
+// CreateServiceAccountToken issues a long lived access token for a user with the service account role. Admin only.
+func (s *userManagementServer) CreateServiceAccountToken(ctx context.Context, req *api.UserReference) (*api.TokenResponse, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.UserId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	instanceID := req.Token.InstanceId
+	user, err := s.userDBservice.GetUserByID(ctx, instanceID, req.UserId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "user not found")
+	}
+	if !user.HasRole(constants.USER_ROLE_SERVICE_ACCOUNT) {
+		return nil, status.Error(codes.InvalidArgument, "user is not a service account")
+	}
+
+	issuedAt := time.Now()
+	tokenID, err := s.userDBservice.AddServiceAccountToken(ctx, instanceID, models.ServiceAccountToken{
+		UserID:    user.ID.Hex(),
+		IssuedBy:  req.Token.Id,
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: issuedAt.Add(s.Intervals.ServiceAccountTokenLifetime).Unix(),
+	})
+	if err != nil {
+		logger.Error.Printf("CreateServiceAccountToken: %v", err)
+		return nil, status.Error(codes.Internal, "token generation error")
+	}
+
+	token, err := tokens.GenerateNewServiceAccountToken(user.ID.Hex(), user.Roles, instanceID, s.Intervals.ServiceAccountTokenLifetime, tokenID)
+	if err != nil {
+		logger.Error.Printf("CreateServiceAccountToken: %v", err)
+		return nil, status.Error(codes.Internal, "token generation error")
+	}
+
+	s.SaveLogEvent(instanceID, req.Token.Id, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_SERVICE_ACCOUNT_TOKEN_ISSUED, tokenID+" for "+user.ID.Hex())
+
+	return &api.TokenResponse{
+		AccessToken:      token,
+		AccountConfirmed: true,
+		ExpiresIn:        int32(s.Intervals.ServiceAccountTokenLifetime / time.Minute),
+	}, nil
+}
+
+// RevokeServiceAccountToken invalidates the given service account token
+func (s *userManagementServer) RevokeServiceAccountToken(ctx context.Context, req *api.JWTRequest) (*api.ServiceStatus, error) {
+	if req == nil || req.Token == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	parsedToken, ok, err := tokens.ValidateToken(req.Token)
+	if err != nil || !ok || parsedToken.Id == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "invalid token", models.ERROR_CODE_INVALID_TOKEN)
+	}
+	if err := s.userDBservice.DeleteServiceAccountToken(ctx, parsedToken.InstanceID, parsedToken.Id); err != nil {
+		return nil, errorWithCode(codes.InvalidArgument, "invalid token", models.ERROR_CODE_INVALID_TOKEN)
+	}
+
+	s.SaveLogEvent(parsedToken.InstanceID, parsedToken.ID, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_SERVICE_ACCOUNT_TOKEN_REVOKED, parsedToken.Id)
+
+	return &api.ServiceStatus{
+		Status:  api.ServiceStatus_NORMAL,
+		Msg:     "service account token revoked",
+		Version: apiVersion,
+	}, nil
+}
+
+// RevokeServiceAccountTokenByID invalidates a service account token of the admin's instance by its id, for
+// tokens that are lost or leaked and not at hand anymore. Admin only.
+func (s *userManagementServer) RevokeServiceAccountTokenByID(ctx context.Context, req *ServiceAccountTokenReference) (*api.ServiceStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.TokenId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if !tokens.IsAdmin(req.Token.Payload) || utils.IsImpersonationToken(req.Token) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	if err := s.userDBservice.DeleteServiceAccountToken(ctx, req.Token.InstanceId, req.TokenId); err != nil {
+		return nil, status.Error(codes.NotFound, "service account token not found")
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_SERVICE_ACCOUNT_TOKEN_REVOKED, req.TokenId)
+
+	return &api.ServiceStatus{
+		Status:  api.ServiceStatus_NORMAL,
+		Msg:     "service account token revoked",
+		Version: apiVersion,
+	}, nil
+}
+
+// ImpersonateUser issues a short lived access token for an admin to act as the user, e.g. to reproduce an issue.
+// No refresh token is issued, and self-service actions like account deletion are refused with this token.
+func (s *userManagementServer) ImpersonateUser(ctx context.Context, req *api.UserReference) (*api.TokenResponse, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.UserId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if !tokens.IsAdmin(req.Token.Payload) || utils.IsImpersonationToken(req.Token) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	instanceID := req.Token.InstanceId
+	user, err := s.userDBservice.GetUserByID(ctx, instanceID, req.UserId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "user not found")
+	}
+	// the token would carry the roles of the user
+	if user.HasRole(constants.USER_ROLE_ADMIN) || user.HasRole(constants.USER_ROLE_SERVICE_ACCOUNT) || len(user.Profiles) < 1 {
+		return nil, status.Error(codes.PermissionDenied, "user can not be impersonated")
+	}
+
+	mainProfileID, otherProfileIDs := utils.GetMainAndOtherProfiles(user)
+	token, err := tokens.GenerateNewImpersonationToken(user.ID.Hex(), user.Account.AccountConfirmedAt > 0, mainProfileID, otherProfileIDs, user.Roles, instanceID, impersonationTokenLifetime, req.Token.Id)
+	if err != nil {
+		logger.Error.Printf("ImpersonateUser: %v", err)
+		return nil, status.Error(codes.Internal, "token generation error")
+	}
+
+	logger.Info.Printf("admin %s impersonates user %s", req.Token.Id, user.ID.Hex())
+	s.SaveLogEvent(instanceID, req.Token.Id, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_USER_IMPERSONATED, "impersonating "+user.ID.Hex())
+
+	apiUser := user.ToAPI()
+	return &api.TokenResponse{
+		AccessToken:       token,
+		AccountConfirmed:  user.Account.AccountConfirmedAt > 0,
+		ExpiresIn:         int32(impersonationTokenLifetime / time.Minute),
+		SelectedProfileId: mainProfileID,
+		Profiles:          apiUser.Profiles,
 		PreferredLanguage: user.Account.PreferredLanguage,
 	}, nil
 }
 
 func (s *userManagementServer) RevokeAllRefreshTokens(ctx context.Context, req *api.RevokeRefreshTokensReq) (*api.ServiceStatus, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) {
-		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
 	}
 
-	_, err := s.userDBservice.GetUserByID(req.Token.InstanceId, req.Token.Id)
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, req.Token.Id)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "user not found")
+		return nil, userLookupError(err)
+	}
+	if err := rejectChildProfile(req.Token, user); err != nil {
+		return nil, err
 	}
 
-	count, err := s.userDBservice.DeleteRenewTokensForUser(req.Token.InstanceId, req.Token.Id)
+	count, err := s.userDBservice.DeleteRenewTokensForUser(ctx, req.Token.InstanceId, req.Token.Id)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "failed to delete tokens")
 	}
@@ -143,3 +350,76 @@ func (s *userManagementServer) RevokeAllRefreshTokens(ctx context.Context, req *
 		Version: apiVersion,
 	}, nil
 }
+
+func (s *userManagementServer) ListSessions(ctx context.Context, req *api.UserReference) (*SessionList, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	rts, err := s.userDBservice.FindSessionsForUser(ctx, req.Token.InstanceId, req.Token.Id)
+	if err != nil {
+		logger.Error.Printf("ListSessions: %v", err)
+		return nil, status.Error(codes.Internal, "failed to fetch sessions")
+	}
+
+	resp := &SessionList{Sessions: make([]*models.Session, len(rts))}
+	for i, rt := range rts {
+		session := rt.ToSession()
+		resp.Sessions[i] = &session
+	}
+	return resp, nil
+}
+
+// RevokeSession removes a single refresh token of the authenticated user. The session can be referenced by the refresh token itself or by the session id returned by ListSessions.
+func (s *userManagementServer) RevokeSession(ctx context.Context, req *RevokeSessionReq) (*api.ServiceStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.SessionId == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if utils.IsImpersonationToken(req.Token) {
+		return nil, errorWithCode(codes.PermissionDenied, impersonationNotAllowedMsg, models.ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING)
+	}
+
+	if err := s.userDBservice.DeleteSessionForUser(ctx, req.Token.InstanceId, req.Token.Id, req.SessionId); err != nil {
+		logger.Debug.Printf("RevokeSession: %v", err)
+		return nil, status.Error(codes.InvalidArgument, "session not found")
+	}
+
+	return &api.ServiceStatus{
+		Status:  api.ServiceStatus_NORMAL,
+		Msg:     "session revoked",
+		Version: apiVersion,
+	}, nil
+}
+
+// GetLoginHistory returns time, client IP and user agent of the latest logins of the user, newest first.
+// Admins can read the login history of another user of the instance.
+func (s *userManagementServer) GetLoginHistory(ctx context.Context, req *api.UserReference) (*LoginHistory, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	userID := req.Token.Id
+	if req.UserId != "" && req.UserId != req.Token.Id {
+		if !tokens.IsAdmin(req.Token.Payload) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		userID = req.UserId
+	}
+
+	user, err := s.userDBservice.GetUserByID(ctx, req.Token.InstanceId, userID)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+
+	minTime := int64(0)
+	if s.loginHistory.Retention > 0 {
+		minTime = time.Now().Add(-s.loginHistory.Retention).Unix()
+	}
+	resp := &LoginHistory{Logins: []*models.LoginRecord{}}
+	for i := len(user.RecentLogins) - 1; i >= 0; i-- {
+		if user.RecentLogins[i].Time >= minTime {
+			resp.Logins = append(resp.Logins, &user.RecentLogins[i])
+		}
+	}
+	return resp, nil
+}
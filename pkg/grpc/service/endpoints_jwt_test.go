@@ -2,16 +2,20 @@ package service
 
 import (
 	"context"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/tokens"
 	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 func TestValidateJWT(t *testing.T) {
@@ -41,7 +45,7 @@ func TestValidateJWT(t *testing.T) {
 		}
 	})
 
-	adminToken, err1 := tokens.GenerateNewToken("test-admin-id", true, "testprofid", []string{"PARTICIPANT", "ADMIN"}, testInstanceID, s.Intervals.TokenExpiryInterval, "", nil, []string{})
+	adminToken, err1 := tokens.GenerateNewToken("test-admin-id", true, "testprofid", []string{"PARTICIPANT", "ADMIN"}, testInstanceID, s.Intervals.TokenExpiryInterval, "", nil, []string{}, nil)
 	userToken, err2 := tokens.GenerateNewToken(
 		"test-user-id",
 		true,
@@ -52,6 +56,7 @@ func TestValidateJWT(t *testing.T) {
 		"",
 		&models.TempToken{UserID: "test-user-id", Purpose: "testpurpose"},
 		[]string{},
+		nil,
 	)
 	if err1 != nil || err2 != nil {
 		t.Errorf("unexpected error: %s or %s", err1, err2)
@@ -68,6 +73,10 @@ func TestValidateJWT(t *testing.T) {
 		if !ok {
 			t.Error(msg)
 		}
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_INVALID_TOKEN)
+		if !ok {
+			t.Error(msg)
+		}
 	})
 
 	t.Run("with normal user token", func(t *testing.T) {
@@ -89,6 +98,16 @@ func TestValidateJWT(t *testing.T) {
 			t.Errorf("unexpected temptoken in response: %s", resp.TempToken)
 			return
 		}
+		expiresAt, err := strconv.ParseInt(resp.Payload[models.TOKEN_PAYLOAD_EXPIRES_AT], 10, 64)
+		if err != nil {
+			t.Errorf("missing expiration in payload: %v", resp.Payload)
+			return
+		}
+		// issued and expiration times are read separately, they can be a second apart
+		expected := resp.IssuedAt + int64(s.Intervals.TokenExpiryInterval.Seconds())
+		if expiresAt < expected-1 || expiresAt > expected {
+			t.Errorf("unexpected expiration: %d, issued at %d", expiresAt, resp.IssuedAt)
+		}
 	})
 
 	t.Run("with admin token", func(t *testing.T) {
@@ -161,9 +180,9 @@ func TestRenewJWT(t *testing.T) {
 		return
 	}
 
-	testUserDBService.CreateRenewToken(testInstanceID, testUsers[0].ID.Hex(), refreshToken, time.Now().Add(time.Hour).Unix())
+	testUserDBService.CreateRenewToken(context.Background(), testInstanceID, testUsers[0].ID.Hex(), refreshToken, time.Now().Add(time.Hour).Unix())
 
-	userToken, err := tokens.GenerateNewToken(testUsers[0].ID.Hex(), true, "testprofid", []string{"PARTICIPANT"}, testInstanceID, s.Intervals.TokenExpiryInterval, "", nil, []string{})
+	userToken, err := tokens.GenerateNewToken(testUsers[0].ID.Hex(), true, testUsers[0].Profiles[0].ID.Hex(), []string{"PARTICIPANT"}, testInstanceID, s.Intervals.TokenExpiryInterval, "", nil, []string{}, nil)
 	if err != nil {
 		t.Errorf("unexpected error: %s", err)
 		return
@@ -223,7 +242,7 @@ func TestRenewJWT(t *testing.T) {
 		).Return(nil, nil)
 
 		//test if MarkedForDeletionTime is updated
-		succ, err := testUserDBService.UpdateMarkedForDeletionTime(testInstanceID, testUsers[0].ID.Hex(), 100, false)
+		succ, err := testUserDBService.UpdateMarkedForDeletionTime(context.Background(), testInstanceID, testUsers[0].ID.Hex(), 100, false)
 		if succ != true {
 			t.Errorf("could not update markedForDeletion Time")
 			return
@@ -245,7 +264,7 @@ func TestRenewJWT(t *testing.T) {
 			t.Errorf("unexpected response: %s", resp)
 			return
 		}
-		user, err := testUserDBService.GetUserByID(testInstanceID, testUsers[0].ID.Hex())
+		user, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, testUsers[0].ID.Hex())
 		if err != nil {
 			t.Errorf("unexpected error: %s", err.Error())
 			return
@@ -313,7 +332,7 @@ func TestRevokeAllRefreshTokens(t *testing.T) {
 		t.Errorf("failed to create testusers: %s", err.Error())
 		return
 	}
-	testUserDBService.CreateRenewToken(testInstanceID, testUsers[0].ID.Hex(), refreshToken, time.Now().Add(time.Hour).Unix())
+	testUserDBService.CreateRenewToken(context.Background(), testInstanceID, testUsers[0].ID.Hex(), refreshToken, time.Now().Add(time.Hour).Unix())
 
 	t.Run("Testing token refresh without token", func(t *testing.T) {
 		_, err := s.RevokeAllRefreshTokens(context.Background(), nil)
@@ -346,10 +365,617 @@ func TestRevokeAllRefreshTokens(t *testing.T) {
 			t.Errorf("unexpected error: %s", err.Error())
 			return
 		}
-		_, err = s.userDBservice.FindAndUpdateRenewToken(testInstanceID, testUsers[0].ID.Hex(), refreshToken, "test")
+		_, err = s.userDBservice.FindAndUpdateRenewToken(context.Background(), testInstanceID, testUsers[0].ID.Hex(), refreshToken, "test")
 		if err == nil {
 			t.Error("token should be revoked")
 			return
 		}
 	})
 }
+
+func TestListAndRevokeSessions(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_sessions@test.com",
+			},
+			Profiles: []models.Profile{
+				{
+					ID:    primitive.NewObjectID(),
+					Alias: "main",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	userID := testUsers[0].ID.Hex()
+	refreshTokens := []string{"TEST-SESSION-TOKEN-1", "TEST-SESSION-TOKEN-2", "TEST-SESSION-TOKEN-3"}
+	for _, rt := range refreshTokens {
+		if err := s.createRenewTokenForSession(context.Background(), testInstanceID, userID, rt); err != nil {
+			t.Errorf("failed to create renew token: %s", err.Error())
+			return
+		}
+	}
+	tokenInfos := &api_types.TokenInfos{
+		InstanceId: testInstanceID,
+		Id:         userID,
+	}
+
+	t.Run("list without token", func(t *testing.T) {
+		_, err := s.ListSessions(context.Background(), nil)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing arguments")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("list sessions", func(t *testing.T) {
+		sessions, err := s.ListSessions(context.Background(), &api.UserReference{Token: tokenInfos})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(sessions.Sessions) != len(refreshTokens) {
+			t.Errorf("unexpected number of sessions.Sessions: %d", len(sessions.Sessions))
+		}
+	})
+
+	t.Run("revoke without token", func(t *testing.T) {
+		_, err := s.RevokeSession(context.Background(), &RevokeSessionReq{SessionId: refreshTokens[0]})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing arguments")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("revoke session of another user", func(t *testing.T) {
+		otherUser := &api_types.TokenInfos{
+			InstanceId: testInstanceID,
+			Id:         primitive.NewObjectID().Hex(),
+		}
+		_, err := s.RevokeSession(context.Background(), &RevokeSessionReq{Token: otherUser, SessionId: refreshTokens[0]})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "session not found")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("revoke unknown session", func(t *testing.T) {
+		_, err := s.RevokeSession(context.Background(), &RevokeSessionReq{Token: tokenInfos, SessionId: "wrong"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "session not found")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("revoke session by token", func(t *testing.T) {
+		_, err := s.RevokeSession(context.Background(), &RevokeSessionReq{Token: tokenInfos, SessionId: refreshTokens[0]})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, err := s.userDBservice.FindAndUpdateRenewToken(context.Background(), testInstanceID, userID, refreshTokens[0], "test"); err == nil {
+			t.Error("token should be revoked")
+		}
+		if _, err := s.userDBservice.FindAndUpdateRenewToken(context.Background(), testInstanceID, userID, refreshTokens[1], "TEST-SESSION-NEXT-TOKEN"); err != nil {
+			t.Errorf("other session should remain valid: %s", err.Error())
+		}
+	})
+
+	t.Run("revoke session by id", func(t *testing.T) {
+		sessions, err := s.ListSessions(context.Background(), &api.UserReference{Token: tokenInfos})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		// second token was replaced in the previous step, so only the third one is listed
+		if len(sessions.Sessions) != 1 {
+			t.Errorf("unexpected number of sessions.Sessions: %d", len(sessions.Sessions))
+			return
+		}
+		_, err = s.RevokeSession(context.Background(), &RevokeSessionReq{Token: tokenInfos, SessionId: sessions.Sessions[0].ID})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, err := s.userDBservice.FindAndUpdateRenewToken(context.Background(), testInstanceID, userID, refreshTokens[2], "test"); err == nil {
+			t.Error("token should be revoked")
+		}
+		if _, err := s.userDBservice.FindAndUpdateRenewToken(context.Background(), testInstanceID, userID, refreshTokens[1], "test"); err != nil {
+			t.Errorf("other session should remain valid: %s", err.Error())
+		}
+	})
+}
+
+func TestSessionLimit(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:      time.Second * 2,
+			VerificationCodeLifetime: 60,
+		},
+		maxSessionsPerUser: 2,
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_session_limit@test.com",
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	userID := testUsers[0].ID.Hex()
+	refreshTokens := []string{"TEST-LIMIT-TOKEN-1", "TEST-LIMIT-TOKEN-2", "TEST-LIMIT-TOKEN-3", "TEST-LIMIT-TOKEN-4"}
+	for _, rt := range refreshTokens {
+		if err := s.createRenewTokenForSession(context.Background(), testInstanceID, userID, rt); err != nil {
+			t.Errorf("failed to create renew token: %s", err.Error())
+			return
+		}
+	}
+
+	sessions, err := testUserDBService.FindSessionsForUser(context.Background(), testInstanceID, userID)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	if len(sessions) != 2 {
+		t.Errorf("unexpected number of sessions: %d", len(sessions))
+	}
+	for _, rt := range refreshTokens[:2] {
+		if _, err := testUserDBService.FindAndUpdateRenewToken(context.Background(), testInstanceID, userID, rt, "test"); err == nil {
+			t.Errorf("oldest token %s should be removed", rt)
+		}
+	}
+	for _, rt := range refreshTokens[2:] {
+		if _, err := testUserDBService.FindAndUpdateRenewToken(context.Background(), testInstanceID, userID, rt, rt+"-NEXT"); err != nil {
+			t.Errorf("newest token %s should remain: %s", rt, err.Error())
+		}
+	}
+}
+
+func TestRememberMeRefreshTokenLifetime(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:            time.Second * 2,
+			VerificationCodeLifetime:       60,
+			RefreshTokenLifetime:           time.Hour,
+			RememberMeRefreshTokenLifetime: time.Hour * 24 * 30,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_remember_me_1@test.com",
+			},
+		},
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_remember_me_2@test.com",
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+
+	t.Run("without remember me", func(t *testing.T) {
+		userID := testUsers[0].ID.Hex()
+		if err := s.createRenewTokenForSession(context.Background(), testInstanceID, userID, "TEST-REMEMBER-ME-TOKEN-1"); err != nil {
+			t.Errorf("failed to create renew token: %s", err.Error())
+			return
+		}
+		sessions, err := testUserDBService.FindSessionsForUser(context.Background(), testInstanceID, userID)
+		if err != nil || len(sessions) != 1 {
+			t.Errorf("unexpected sessions: %v - %v", sessions, err)
+			return
+		}
+		expected := time.Now().Add(time.Hour).Unix()
+		if sessions[0].RememberMe || sessions[0].ExpiresAt > expected || sessions[0].ExpiresAt < expected-5 {
+			t.Errorf("unexpected session: %v", sessions[0])
+		}
+	})
+
+	t.Run("with remember me", func(t *testing.T) {
+		userID := testUsers[1].ID.Hex()
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(rememberMeMetadataKey, "true"))
+		if err := s.createRenewTokenForSession(ctx, testInstanceID, userID, "TEST-REMEMBER-ME-TOKEN-2"); err != nil {
+			t.Errorf("failed to create renew token: %s", err.Error())
+			return
+		}
+		sessions, err := testUserDBService.FindSessionsForUser(context.Background(), testInstanceID, userID)
+		if err != nil || len(sessions) != 1 {
+			t.Errorf("unexpected sessions: %v - %v", sessions, err)
+			return
+		}
+		expected := time.Now().Add(time.Hour * 24 * 30).Unix()
+		if !sessions[0].RememberMe || sessions[0].ExpiresAt > expected || sessions[0].ExpiresAt < expected-5 {
+			t.Errorf("unexpected session: %v", sessions[0])
+		}
+	})
+}
+
+func TestServiceAccountToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TokenExpiryInterval:         time.Second * 2,
+			ServiceAccountTokenLifetime: time.Hour * 24 * 30,
+		},
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_service_account_token@test.com",
+			},
+			Roles: []string{"SERVICE"},
+		},
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_service_account_token_2@test.com",
+			},
+			Roles: []string{"PARTICIPANT"},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	adminToken := &api_types.TokenInfos{
+		Id:         "test-admin-id",
+		InstanceId: testInstanceID,
+		Payload: map[string]string{
+			"roles": "PARTICIPANT,ADMIN",
+		},
+	}
+
+	t.Run("with non admin user", func(t *testing.T) {
+		_, err := s.CreateServiceAccountToken(context.Background(), &api.UserReference{
+			Token: &api_types.TokenInfos{
+				Id:         "test-user-id",
+				InstanceId: testInstanceID,
+				Payload: map[string]string{
+					"roles": "PARTICIPANT",
+				},
+			},
+			UserId: testUsers[0].ID.Hex(),
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("for user without service account role", func(t *testing.T) {
+		_, err := s.CreateServiceAccountToken(context.Background(), &api.UserReference{
+			Token:  adminToken,
+			UserId: testUsers[1].ID.Hex(),
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "user is not a service account")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	var serviceToken string
+	t.Run("for service account", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		resp, err := s.CreateServiceAccountToken(context.Background(), &api.UserReference{
+			Token:  adminToken,
+			UserId: testUsers[0].ID.Hex(),
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		serviceToken = resp.AccessToken
+
+		claims, ok, err := tokens.ValidateToken(serviceToken)
+		if err != nil || !ok {
+			t.Errorf("token should be valid: %v", err)
+			return
+		}
+		expectedExpiry := time.Now().Add(s.Intervals.ServiceAccountTokenLifetime).Unix()
+		if claims.ExpiresAt < expectedExpiry-5 || claims.ExpiresAt > expectedExpiry {
+			t.Errorf("unexpected expiry: %d (expected %d)", claims.ExpiresAt, expectedExpiry)
+		}
+
+		infos, err := s.ValidateJWT(context.Background(), &api.JWTRequest{Token: serviceToken})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if infos.Id != testUsers[0].ID.Hex() {
+			t.Errorf("unexpected token infos: %s", infos)
+		}
+	})
+
+	t.Run("token id of another user", func(t *testing.T) {
+		claims, _, err := tokens.ValidateToken(serviceToken)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		otherToken, err := tokens.GenerateNewServiceAccountToken(testUsers[1].ID.Hex(), []string{"SERVICE"}, testInstanceID, time.Hour, claims.Id)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		_, err = s.ValidateJWT(context.Background(), &api.JWTRequest{Token: otherToken})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid token")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("revoke service account token", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		_, err := s.RevokeServiceAccountToken(context.Background(), &api.JWTRequest{Token: serviceToken})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		_, err = s.ValidateJWT(context.Background(), &api.JWTRequest{Token: serviceToken})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid token")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("revoke service account token by id", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil).Times(2)
+
+		resp, err := s.CreateServiceAccountToken(context.Background(), &api.UserReference{
+			Token:  adminToken,
+			UserId: testUsers[0].ID.Hex(),
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		claims, _, err := tokens.ValidateToken(resp.AccessToken)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+
+		_, err = s.RevokeServiceAccountTokenByID(context.Background(), &ServiceAccountTokenReference{
+			Token: &api_types.TokenInfos{
+				Id:         "test-user-id",
+				InstanceId: testInstanceID,
+				Payload: map[string]string{
+					"roles": "PARTICIPANT",
+				},
+			},
+			TokenId: claims.Id,
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+
+		_, err = s.RevokeServiceAccountTokenByID(context.Background(), &ServiceAccountTokenReference{Token: adminToken, TokenId: claims.Id})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		_, err = s.ValidateJWT(context.Background(), &api.JWTRequest{Token: resp.AccessToken})
+		ok, msg = shouldHaveGrpcErrorStatus(err, "invalid token")
+		if !ok {
+			t.Error(msg)
+		}
+
+		_, err = s.RevokeServiceAccountTokenByID(context.Background(), &ServiceAccountTokenReference{Token: adminToken, TokenId: claims.Id})
+		ok, msg = shouldHaveGrpcErrorStatus(err, "service account token not found")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
+
+func TestImpersonateUser(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:               "email",
+				AccountID:          "test_for_impersonation@test.com",
+				AccountConfirmedAt: time.Now().Unix(),
+			},
+			Roles: []string{"PARTICIPANT"},
+			Profiles: []models.Profile{
+				{ID: primitive.NewObjectID(), MainProfile: true},
+			},
+		},
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_impersonation_admin@test.com",
+			},
+			Roles: []string{"PARTICIPANT", "ADMIN"},
+			Profiles: []models.Profile{
+				{ID: primitive.NewObjectID(), MainProfile: true},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	adminToken := &api_types.TokenInfos{
+		Id:         "test-admin-id",
+		InstanceId: testInstanceID,
+		Payload: map[string]string{
+			"roles": "PARTICIPANT,ADMIN",
+		},
+	}
+
+	t.Run("with non admin user", func(t *testing.T) {
+		_, err := s.ImpersonateUser(context.Background(), &api.UserReference{
+			Token: &api_types.TokenInfos{
+				Id:         "test-user-id",
+				InstanceId: testInstanceID,
+				Payload: map[string]string{
+					"roles": "PARTICIPANT",
+				},
+			},
+			UserId: testUsers[0].ID.Hex(),
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("for admin", func(t *testing.T) {
+		_, err := s.ImpersonateUser(context.Background(), &api.UserReference{
+			Token:  adminToken,
+			UserId: testUsers[1].ID.Hex(),
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "user can not be impersonated")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	var impersonationToken *api_types.TokenInfos
+	t.Run("for participant", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *loggingAPI.NewLogEvent, opts ...grpc.CallOption) (*api_types.ServiceStatus, error) {
+			if req.EventType != loggingAPI.LogEventType_SECURITY || req.EventName != models.LOG_EVENT_USER_IMPERSONATED {
+				t.Errorf("unexpected log event: %v", req)
+			}
+			if req.UserId != adminToken.Id || req.Msg != "impersonating "+testUsers[0].ID.Hex() {
+				t.Errorf("log event should record the acting admin: %v", req)
+			}
+			return nil, nil
+		})
+
+		resp, err := s.ImpersonateUser(context.Background(), &api.UserReference{
+			Token:  adminToken,
+			UserId: testUsers[0].ID.Hex(),
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.RefreshToken != "" || resp.ExpiresIn != 15 || resp.SelectedProfileId != testUsers[0].Profiles[0].ID.Hex() {
+			t.Errorf("unexpected response: %v", resp)
+		}
+
+		impersonationToken, err = s.ValidateJWT(context.Background(), &api.JWTRequest{Token: resp.AccessToken})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if impersonationToken.Id != testUsers[0].ID.Hex() || impersonationToken.Payload["impersonated_by"] != adminToken.Id {
+			t.Errorf("unexpected token infos: %v", impersonationToken)
+		}
+		if impersonationToken.Payload["roles"] != "PARTICIPANT" {
+			t.Errorf("token should carry the roles of the user: %v", impersonationToken.Payload)
+		}
+	})
+
+	t.Run("self-service actions are blocked", func(t *testing.T) {
+		if impersonationToken == nil {
+			t.Skip("no impersonation token")
+		}
+		_, err := s.DeleteAccount(context.Background(), &api.UserReference{
+			Token:  impersonationToken,
+			UserId: impersonationToken.Id,
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "not allowed while impersonating")
+		if !ok {
+			t.Error(msg)
+		}
+
+		_, err = s.ChangePassword(context.Background(), &api.PasswordChangeMsg{
+			Token:       impersonationToken,
+			OldPassword: "old",
+			NewPassword: "SuperSecurePassword123!§$",
+		})
+		ok, msg = shouldHaveGrpcErrorStatus(err, "not allowed while impersonating")
+		if !ok {
+			t.Error(msg)
+		}
+
+		_, err = s.SetPrimaryEmail(context.Background(), &api.ContactInfoMsg{
+			Token:       impersonationToken,
+			ContactInfo: &api.ContactInfo{Id: primitive.NewObjectID().Hex(), Type: "email"},
+		})
+		ok, msg = shouldHaveGrpcErrorStatus(err, "not allowed while impersonating")
+		if !ok {
+			t.Error(msg)
+		}
+
+		_, err = s.RevokeAllRefreshTokens(context.Background(), &api.RevokeRefreshTokensReq{Token: impersonationToken})
+		ok, msg = shouldHaveGrpcErrorStatus(err, "not allowed while impersonating")
+		if !ok {
+			t.Error(msg)
+		}
+
+		_, err = s.ImpersonateUser(context.Background(), &api.UserReference{
+			Token:  impersonationToken,
+			UserId: testUsers[0].ID.Hex(),
+		})
+		ok, msg = shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
@@ -2,33 +2,31 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/coneno/logger"
 	api_types "github.com/influenzanet/go-utils/pkg/api_types"
 	"github.com/influenzanet/user-management-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"github.com/influenzanet/user-management-service/pkg/utils"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
-const deleteTempTokensMinInterval = 10 * 60
-
-var (
-	lastTempTokenDeleteTime int64
-)
-
 func (s *userManagementServer) GetOrCreateTemptoken(ctx context.Context, t *api_types.TempTokenInfo) (*api.TempToken, error) {
 	if t == nil || t.Purpose == "" || t.UserId == "" || t.InstanceId == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing argument")
 	}
-
-	// Cleanup temptokens if this was not done recently:
-	now := time.Now().Unix()
-	if lastTempTokenDeleteTime+deleteTempTokensMinInterval < now {
-		go s.CleanExpiredTemptokens(3600)
-		lastTempTokenDeleteTime = now
+	if !s.isTempTokenPurposeAllowed(t.Purpose) {
+		return nil, status.Error(codes.InvalidArgument, "unknown token purpose")
 	}
+	if !checkTempTokenInfo(t.Info) {
+		return nil, status.Error(codes.InvalidArgument, "token info too large")
+	}
+
+	s.cleanExpiredTemptokensIfDue()
 
 	tList, err := s.globalDBService.GetTempTokenForUser(t.InstanceId, t.UserId, t.Purpose)
 	if err != nil {
@@ -65,13 +63,14 @@ func (s *userManagementServer) GenerateTempToken(ctx context.Context, t *api_typ
 	if t == nil || t.Purpose == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing argument")
 	}
-
-	// Cleanup temptokens if this was not done recently:
-	now := time.Now().Unix()
-	if lastTempTokenDeleteTime+deleteTempTokensMinInterval < now {
-		go s.CleanExpiredTemptokens(3600)
-		lastTempTokenDeleteTime = now
+	if !s.isTempTokenPurposeAllowed(t.Purpose) {
+		return nil, status.Error(codes.InvalidArgument, "unknown token purpose")
 	}
+	if !checkTempTokenInfo(t.Info) {
+		return nil, status.Error(codes.InvalidArgument, "token info too large")
+	}
+
+	s.cleanExpiredTemptokensIfDue()
 
 	tempToken := models.TempToken{
 		UserID:     t.UserId,
@@ -108,6 +107,29 @@ func (s *userManagementServer) GetTempTokens(ctx context.Context, t *api_types.T
 	return tokens.ToAPI(), nil
 }
 
+// ListUserTempTokens returns the temp tokens of a user of the instance for admins, flagged as expired or not.
+// The token strings of valid tokens are not returned.
+func (s *userManagementServer) ListUserTempTokens(ctx context.Context, req *api.UserReference) (*TempTokenOverviewList, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing argument")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	tempTokens, err := s.globalDBService.GetTempTokenForUser(req.Token.InstanceId, req.UserId, "")
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	now := time.Now().Unix()
+	resp := &TempTokenOverviewList{TempTokens: make([]*models.TempTokenOverview, len(tempTokens))}
+	for i, t := range tempTokens {
+		overview := t.Overview(now)
+		resp.TempTokens[i] = &overview
+	}
+	return resp, nil
+}
+
 func (s *userManagementServer) DeleteTempToken(ctx context.Context, t *api.TempToken) (*api.ServiceStatus, error) {
 	if t == nil || t.Token == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing argument")
@@ -123,6 +145,27 @@ func (s *userManagementServer) DeleteTempToken(ctx context.Context, t *api.TempT
 	}, nil
 }
 
+// DeleteAllTempTokensByPurpose invalidates the temp tokens of a purpose for all users of the admin's instance,
+// e.g. after changing the format of a link.
+func (s *userManagementServer) DeleteAllTempTokensByPurpose(ctx context.Context, req *TempTokenPurposeMsg) (*api.ServiceStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.Purpose == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing argument")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	count, err := s.globalDBService.DeleteAllTempTokensByPurpose(req.Token.InstanceId, req.Purpose)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	logger.Info.Printf("%d temp tokens with purpose '%s' deleted in instance %s by %s", count, req.Purpose, req.Token.InstanceId, req.Token.Id)
+	return &api.ServiceStatus{
+		Status:  api.ServiceStatus_NORMAL,
+		Msg:     fmt.Sprintf("%d tokens deleted", count),
+		Version: apiVersion,
+	}, nil
+}
+
 func (s *userManagementServer) PurgeUserTempTokens(ctx context.Context, t *api_types.TempTokenInfo) (*api.ServiceStatus, error) {
 	if t == nil || t.UserId == "" || t.InstanceId == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing argument")
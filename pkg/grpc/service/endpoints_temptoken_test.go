@@ -2,10 +2,15 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	"github.com/influenzanet/go-utils/pkg/constants"
 	"github.com/influenzanet/user-management-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/tokens"
@@ -20,6 +25,7 @@ func TestGetOrCreateTemptokenEndpoint(t *testing.T) {
 			TokenExpiryInterval:      time.Second * 2,
 			VerificationCodeLifetime: 60,
 		},
+		extraTempTokenPurposes: map[string]bool{"test_purpose_get_or_create_token": true},
 	}
 
 	testTempToken := models.TempToken{
@@ -100,7 +106,7 @@ func TestGenerateTempTokenEndpoint(t *testing.T) {
 	testTempToken := &api_types.TempTokenInfo{
 		UserId:     "test_user_id",
 		InstanceId: testInstanceID,
-		Purpose:    "test_purpose",
+		Purpose:    constants.TOKEN_PURPOSE_SURVEY_LOGIN,
 		Info: map[string]string{
 			"key": "test_info",
 		},
@@ -138,6 +144,196 @@ func TestGenerateTempTokenEndpoint(t *testing.T) {
 			t.Errorf("wrong response: %s", resp)
 		}
 	})
+
+	t.Run("with unknown purpose", func(t *testing.T) {
+		resp, err := s.GenerateTempToken(context.Background(), &api_types.TempTokenInfo{
+			UserId:     "test_user_id",
+			InstanceId: testInstanceID,
+			Purpose:    "test_purpose_unknown",
+		})
+		if err == nil {
+			t.Errorf("or response: %s", resp)
+			return
+		}
+		if status.Convert(err).Message() != "unknown token purpose" {
+			t.Errorf("wrong error: %s", err.Error())
+		}
+	})
+
+	t.Run("with signup invitation purpose", func(t *testing.T) {
+		_, err := s.GenerateTempToken(context.Background(), &api_types.TempTokenInfo{
+			UserId:     "test_user_id",
+			InstanceId: testInstanceID,
+			Purpose:    models.TOKEN_PURPOSE_SIGNUP_INVITATION,
+			Info:       map[string]string{"email": "someone@test.com"},
+		})
+		if status.Convert(err).Message() != "unknown token purpose" {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+
+	t.Run("with too many info entries", func(t *testing.T) {
+		info := map[string]string{}
+		for i := 0; i <= maxTempTokenInfoEntries; i++ {
+			info[fmt.Sprintf("key%d", i)] = "value"
+		}
+		resp, err := s.GenerateTempToken(context.Background(), &api_types.TempTokenInfo{
+			UserId:     "test_user_id",
+			InstanceId: testInstanceID,
+			Purpose:    constants.TOKEN_PURPOSE_SURVEY_LOGIN,
+			Info:       info,
+		})
+		if err == nil {
+			t.Errorf("or response: %s", resp)
+			return
+		}
+		if status.Convert(err).Message() != "token info too large" {
+			t.Errorf("wrong error: %s", err.Error())
+		}
+	})
+
+	t.Run("with too large info", func(t *testing.T) {
+		resp, err := s.GenerateTempToken(context.Background(), &api_types.TempTokenInfo{
+			UserId:     "test_user_id",
+			InstanceId: testInstanceID,
+			Purpose:    constants.TOKEN_PURPOSE_SURVEY_LOGIN,
+			Info:       map[string]string{"key": strings.Repeat("x", maxTempTokenInfoSize)},
+		})
+		if err == nil {
+			t.Errorf("or response: %s", resp)
+			return
+		}
+		if status.Convert(err).Message() != "token info too large" {
+			t.Errorf("wrong error: %s", err.Error())
+		}
+	})
+
+	t.Run("with info within the limits", func(t *testing.T) {
+		info := map[string]string{}
+		for i := 0; i < maxTempTokenInfoEntries; i++ {
+			info[fmt.Sprintf("key%d", i)] = strings.Repeat("x", 100)
+		}
+		resp, err := s.GenerateTempToken(context.Background(), &api_types.TempTokenInfo{
+			UserId:     "test_user_id",
+			InstanceId: testInstanceID,
+			Purpose:    constants.TOKEN_PURPOSE_SURVEY_LOGIN,
+			Info:       info,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.Token == "" {
+			t.Errorf("wrong response: %s", resp)
+		}
+	})
+
+	t.Run("with explicitly permitted purpose", func(t *testing.T) {
+		s.extraTempTokenPurposes = map[string]bool{"test_purpose_permitted": true}
+		defer func() { s.extraTempTokenPurposes = nil }()
+
+		resp, err := s.GenerateTempToken(context.Background(), &api_types.TempTokenInfo{
+			UserId:     "test_user_id",
+			InstanceId: testInstanceID,
+			Purpose:    "test_purpose_permitted",
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.Token == "" {
+			t.Errorf("wrong response: %s", resp)
+		}
+	})
+}
+
+func TestTempTokenCleanupThrottle(t *testing.T) {
+	throttle := tempTokenCleanupThrottle{}
+	now := time.Now().Unix()
+
+	if !throttle.shouldRun(now, 600) {
+		t.Error("first cleanup should run")
+	}
+	if throttle.shouldRun(now+600, 600) {
+		t.Error("cleanup should not run within the interval")
+	}
+	if !throttle.shouldRun(now+601, 600) {
+		t.Error("cleanup should run after the interval")
+	}
+
+	t.Run("concurrent requests", func(t *testing.T) {
+		throttle := tempTokenCleanupThrottle{}
+		var runs int32
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if throttle.shouldRun(now, 600) {
+					atomic.AddInt32(&runs, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		if runs != 1 {
+			t.Errorf("unexpected number of cleanups: %d", runs)
+		}
+	})
+}
+
+func TestGenerateTempTokenCleanupInterval(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			TempTokenCleanupMinInterval: time.Hour,
+			ExpiredTempTokenRetention:   time.Minute,
+		},
+		extraTempTokenPurposes: map[string]bool{"test_purpose_cleanup_interval": true},
+	}
+	newToken := &api_types.TempTokenInfo{
+		UserId:     "test_user_id",
+		InstanceId: testInstanceID,
+		Purpose:    "test_purpose_cleanup_interval",
+	}
+
+	expiredToken, err := testGlobalDBService.AddTempToken(models.TempToken{
+		UserID:     "test_user_id",
+		InstanceID: testInstanceID,
+		Purpose:    "test_purpose_cleanup_interval",
+		Expiration: time.Now().Unix() - 120,
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	t.Run("within the configured interval", func(t *testing.T) {
+		s.tempTokenCleanup.lastRun = time.Now().Unix() - 1800
+		if _, err := s.GenerateTempToken(context.Background(), newToken); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+		if _, err := testGlobalDBService.GetTempToken(expiredToken); err != nil {
+			t.Errorf("expired token should not be removed yet: %s", err.Error())
+		}
+	})
+
+	t.Run("after the configured interval", func(t *testing.T) {
+		s.tempTokenCleanup.lastRun = time.Now().Unix() - 3601
+		if _, err := s.GenerateTempToken(context.Background(), newToken); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		for i := 0; i < 20; i++ {
+			if _, err := testGlobalDBService.GetTempToken(expiredToken); err != nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Error("expired token should be removed")
+	})
 }
 
 func TestGetTempTokensEndpoint(t *testing.T) {
@@ -409,3 +605,269 @@ func TestPurgeUserTempTokensEndpoint(t *testing.T) {
 		}
 	})
 }
+
+func TestListUserTempTokensEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	userID := "test_user_id_listing"
+	validToken, err := testGlobalDBService.AddTempToken(models.TempToken{
+		UserID:     userID,
+		InstanceID: testInstanceID,
+		Purpose:    constants.TOKEN_PURPOSE_PASSWORD_RESET,
+		Expiration: tokens.GetExpirationTime(10 * time.Second),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expiredToken, err := testGlobalDBService.AddTempToken(models.TempToken{
+		UserID:     userID,
+		InstanceID: testInstanceID,
+		Purpose:    constants.TOKEN_PURPOSE_CONTACT_VERIFICATION,
+		Expiration: time.Now().Unix() - 10,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	adminToken := &api_types.TokenInfos{
+		Id:         "admin_id",
+		InstanceId: testInstanceID,
+		Payload:    map[string]string{"roles": "PARTICIPANT,ADMIN"},
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.ListUserTempTokens(context.Background(), &api.UserReference{UserId: userID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("without user id", func(t *testing.T) {
+		_, err := s.ListUserTempTokens(context.Background(), &api.UserReference{Token: adminToken})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as participant", func(t *testing.T) {
+		_, err := s.ListUserTempTokens(context.Background(), &api.UserReference{Token: &api_types.TokenInfos{
+			Id:         userID,
+			InstanceId: testInstanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT"},
+		}, UserId: userID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as admin", func(t *testing.T) {
+		resp, err := s.ListUserTempTokens(context.Background(), &api.UserReference{Token: adminToken, UserId: userID})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(resp.TempTokens) != 2 {
+			t.Errorf("unexpected number of tokens: %d", len(resp.TempTokens))
+			return
+		}
+		for _, o := range resp.TempTokens {
+			switch o.Purpose {
+			case constants.TOKEN_PURPOSE_PASSWORD_RESET:
+				if o.Expired || o.Token != "" {
+					t.Errorf("valid token should not be expired or show token string: %v", o)
+				}
+				if o.Token == validToken {
+					t.Error("valid token string returned")
+				}
+			case constants.TOKEN_PURPOSE_CONTACT_VERIFICATION:
+				if !o.Expired || o.Token != expiredToken {
+					t.Errorf("expired token should be flagged and show token string: %v", o)
+				}
+			default:
+				t.Errorf("unexpected purpose: %s", o.Purpose)
+			}
+		}
+	})
+}
+
+func TestDeleteAllTempTokensByPurposeEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	instanceID := testInstanceID + "_by_purpose"
+	resetToken, err := testGlobalDBService.AddTempToken(models.TempToken{
+		UserID:     "test_user_id",
+		InstanceID: instanceID,
+		Purpose:    constants.TOKEN_PURPOSE_PASSWORD_RESET,
+		Expiration: tokens.GetExpirationTime(10 * time.Second),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	verificationToken, err := testGlobalDBService.AddTempToken(models.TempToken{
+		UserID:     "test_user_id",
+		InstanceID: instanceID,
+		Purpose:    constants.TOKEN_PURPOSE_CONTACT_VERIFICATION,
+		Expiration: tokens.GetExpirationTime(10 * time.Second),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	adminToken := &api_types.TokenInfos{
+		Id:         "admin_id",
+		InstanceId: instanceID,
+		Payload:    map[string]string{"roles": "PARTICIPANT,ADMIN"},
+	}
+
+	t.Run("without purpose", func(t *testing.T) {
+		_, err := s.DeleteAllTempTokensByPurpose(context.Background(), &TempTokenPurposeMsg{Token: adminToken})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing argument")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as participant", func(t *testing.T) {
+		_, err := s.DeleteAllTempTokensByPurpose(context.Background(), &TempTokenPurposeMsg{Token: &api_types.TokenInfos{
+			Id:         "test_user_id",
+			InstanceId: instanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT"},
+		}, Purpose: constants.TOKEN_PURPOSE_PASSWORD_RESET})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+		if _, err := testGlobalDBService.GetTempToken(resetToken); err != nil {
+			t.Errorf("token should not be deleted: %v", err)
+		}
+	})
+
+	t.Run("as admin of another instance", func(t *testing.T) {
+		_, err := s.DeleteAllTempTokensByPurpose(context.Background(), &TempTokenPurposeMsg{Token: &api_types.TokenInfos{
+			Id:         "admin_id",
+			InstanceId: testInstanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT,ADMIN"},
+		}, Purpose: constants.TOKEN_PURPOSE_PASSWORD_RESET})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if _, err := testGlobalDBService.GetTempToken(resetToken); err != nil {
+			t.Errorf("token of another instance should not be deleted: %v", err)
+		}
+	})
+
+	t.Run("as admin", func(t *testing.T) {
+		resp, err := s.DeleteAllTempTokensByPurpose(context.Background(), &TempTokenPurposeMsg{Token: adminToken, Purpose: constants.TOKEN_PURPOSE_PASSWORD_RESET})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if resp.Msg != "1 tokens deleted" {
+			t.Errorf("unexpected response: %s", resp.Msg)
+		}
+		if _, err := testGlobalDBService.GetTempToken(resetToken); err == nil {
+			t.Error("token should be deleted")
+		}
+		if _, err := testGlobalDBService.GetTempToken(verificationToken); err != nil {
+			t.Errorf("token of other purpose should be kept: %v", err)
+		}
+	})
+}
+
+func TestSaveUserWithTempTokens(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_save_user_with_temptokens@test.com",
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+	userID := testUsers[0].ID.Hex()
+	staleUser, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, userID)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	newTempToken := func(purpose string) models.TempToken {
+		return models.TempToken{
+			UserID:     userID,
+			InstanceID: testInstanceID,
+			Purpose:    purpose,
+			Expiration: tokens.GetExpirationTime(10 * time.Second),
+		}
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		user := staleUser
+		user.Account.PreferredLanguage = "de"
+		updUser, createdTokens, err := s.saveUserWithTempTokens(context.Background(), testInstanceID, user, []models.TempToken{
+			newTempToken("test_purpose_save_user_1"),
+			newTempToken("test_purpose_save_user_2"),
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if updUser.Account.PreferredLanguage != "de" {
+			t.Errorf("user not updated: %v", updUser.Account)
+		}
+		if len(createdTokens) != 2 {
+			t.Errorf("unexpected number of tokens: %d", len(createdTokens))
+			return
+		}
+		tt, err := testGlobalDBService.GetTempToken(createdTokens[1])
+		if err != nil || tt.Purpose != "test_purpose_save_user_2" {
+			t.Errorf("token not found or wrong order: %v, %v", tt, err)
+		}
+	})
+
+	t.Run("rollback when the user update fails", func(t *testing.T) {
+		// staleUser was modified by the commit subtest
+		user := staleUser
+		user.Account.PreferredLanguage = "fr"
+		_, _, err := s.saveUserWithTempTokens(context.Background(), testInstanceID, user, []models.TempToken{
+			newTempToken("test_purpose_save_user_rollback"),
+		})
+		if err == nil {
+			t.Error("should fail with stale user")
+			return
+		}
+		tts, err := testGlobalDBService.GetTempTokenForUser(testInstanceID, userID, "test_purpose_save_user_rollback")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(tts) != 0 {
+			t.Errorf("created tokens should be removed: %d", len(tts))
+		}
+		current, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, userID)
+		if err != nil || current.Account.PreferredLanguage != "de" {
+			t.Errorf("user should not be changed: %v, %v", current.Account, err)
+		}
+	})
+}
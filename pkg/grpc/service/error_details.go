@@ -0,0 +1,67 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/coneno/logger"
+	"github.com/golang/protobuf/proto"
+	"github.com/influenzanet/user-management-service/pkg/dbs/userdb"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// domain of the google.rpc.ErrorInfo details, the error codes are unique within it
+const errorDomain = "user-management-service"
+
+// fieldViolation describes which field of the request failed validation and why. Field names are the ones of
+// the proto message (e.g. "new_password").
+func fieldViolation(field string, description string) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: description,
+	}
+}
+
+// errorWithCode returns a status error with the message and a google.rpc.ErrorInfo detail with the stable
+// errorCode (see models.ERROR_CODE_*) as reason, so that clients can translate the error. Violations are
+// added as google.rpc.BadRequest detail, so that clients can tell which field was wrong. The message stays the
+// same as without details, for logging and for clients reading only the message.
+func errorWithCode(c codes.Code, msg string, errorCode string, violations ...*errdetails.BadRequest_FieldViolation) error {
+	st := status.New(c, msg)
+	details := []proto.Message{
+		&errdetails.ErrorInfo{Reason: errorCode, Domain: errorDomain},
+	}
+	if len(violations) > 0 {
+		details = append(details, &errdetails.BadRequest{FieldViolations: violations})
+	}
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		logger.Error.Printf("errorWithCode: %v", err)
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// userLookupError translates an error of GetUserByID: InvalidArgument for a malformed user ID, NotFound if the user
+// doesn't exist, Internal if the DB failed
+func userLookupError(err error) error {
+	switch {
+	case errors.Is(err, userdb.ErrInvalidUserID):
+		return status.Error(codes.InvalidArgument, "invalid user id")
+	case errors.Is(err, userdb.ErrUserNotFound):
+		return errorWithCode(codes.NotFound, "user not found", models.ERROR_CODE_USER_NOT_FOUND)
+	}
+	logger.Error.Printf("failed to read user: %v", err)
+	return status.Error(codes.Internal, "failed to read user")
+}
+
+// userUpdateError translates an error of UpdateUser: Aborted if the user was modified since it was read, so that the
+// client can retry, otherwise Internal with msg
+func userUpdateError(err error, msg string) error {
+	if errors.Is(err, userdb.ErrUserVersionConflict) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	return status.Error(codes.Internal, msg)
+}
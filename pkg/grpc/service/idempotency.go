@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+
+	"github.com/coneno/logger"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const idempotencyKeyUsedMsg = "idempotency key already used for another request"
+
+// idempotencyKeyFromContext returns the idempotency key sent by the client in the request metadata, if any
+func idempotencyKeyFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, key := range []string{"grpcgateway-idempotency-key", "idempotency-key"} {
+		if values := md.Get(key); len(values) > 0 && len(values[0]) <= maxIdempotencyKeyLength {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// idempotencyRequestHash is a keyed hash, as requests may contain personal data. Secrets like passwords must be
+// removed from req before.
+func idempotencyRequestHash(req proto.Message) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	return tokens.KeyedHash(b)
+}
+
+// beginIdempotentRequest reserves the key for req. If a previous request with the same key succeeded, the ID of the
+// user it returned is given back, the request must then not be processed again.
+func (s *userManagementServer) beginIdempotentRequest(instanceID string, key string, req proto.Message) (string, error) {
+	hash, err := idempotencyRequestHash(req)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	existing, err := s.globalDBService.ReserveIdempotencyKey(instanceID, key, hash, idempotencyKeyTTL)
+	if err != nil {
+		logger.Error.Printf("beginIdempotentRequest: %v", err)
+		return "", status.Error(codes.Internal, "idempotency key could not be checked")
+	}
+	if existing == nil {
+		return "", nil
+	}
+	if existing.RequestHash != hash {
+		return "", status.Error(codes.InvalidArgument, idempotencyKeyUsedMsg)
+	}
+	if existing.UserID == "" {
+		return "", status.Error(codes.Aborted, "request with the same idempotency key in progress")
+	}
+	return existing.UserID, nil
+}
+
+// endIdempotentRequest stores the outcome for the key. If the request failed, the key is released so the client can retry.
+func (s *userManagementServer) endIdempotentRequest(instanceID string, key string, userID string, reqErr error) {
+	if reqErr == nil {
+		err := s.globalDBService.SaveIdempotencyResult(instanceID, key, userID)
+		if err == nil {
+			return
+		}
+		logger.Error.Printf("endIdempotentRequest: result not saved: %v", err)
+	}
+	if err := s.globalDBService.DeleteIdempotencyKey(instanceID, key); err != nil {
+		logger.Error.Printf("endIdempotentRequest: %v", err)
+	}
+}
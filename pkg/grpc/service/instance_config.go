@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coneno/logger"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/utils"
+	"google.golang.org/grpc/codes"
+)
+
+type cachedInstanceConfig struct {
+	config    models.InstanceConfig
+	expiresAt time.Time
+}
+
+// instanceConfigCache keeps the instance settings read from the global DB for a short time
+type instanceConfigCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	configs map[string]cachedInstanceConfig
+}
+
+func newInstanceConfigCache(ttl time.Duration) *instanceConfigCache {
+	return &instanceConfigCache{
+		ttl:     ttl,
+		configs: map[string]cachedInstanceConfig{},
+	}
+}
+
+func (c *instanceConfigCache) get(instanceID string) (models.InstanceConfig, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.configs[instanceID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return models.InstanceConfig{}, false
+	}
+	return entry.config, true
+}
+
+func (c *instanceConfigCache) set(instanceID string, config models.InstanceConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configs[instanceID] = cachedInstanceConfig{config: config, expiresAt: time.Now().Add(c.ttl)}
+}
+
+// instanceIDCache keeps the IDs of the existing instances, they are reloaded from the global DB when older than ttl
+type instanceIDCache struct {
+	mu          sync.RWMutex
+	ttl         time.Duration
+	ids         map[string]bool
+	refreshedAt time.Time
+}
+
+func newInstanceIDCache(ttl time.Duration) *instanceIDCache {
+	return &instanceIDCache{
+		ttl: ttl,
+		ids: map[string]bool{},
+	}
+}
+
+func (c *instanceIDCache) isStale() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return time.Since(c.refreshedAt) > c.ttl
+}
+
+func (c *instanceIDCache) contains(instanceID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ids[instanceID]
+}
+
+func (c *instanceIDCache) replace(instanceIDs []string) {
+	ids := make(map[string]bool, len(instanceIDs))
+	for _, id := range instanceIDs {
+		ids[id] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ids = ids
+	c.refreshedAt = time.Now()
+}
+
+func (c *instanceIDCache) set(instanceID string, exists bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if exists {
+		c.ids[instanceID] = true
+	} else {
+		delete(c.ids, instanceID)
+	}
+}
+
+// refreshInstanceIDs reloads the IDs of the existing instances
+func (s *userManagementServer) refreshInstanceIDs() error {
+	instances, err := s.globalDBService.GetAllInstances()
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(instances))
+	for i, instance := range instances {
+		ids[i] = instance.InstanceID
+	}
+	s.instanceIDs.replace(ids)
+	return nil
+}
+
+// getInstanceConfig returns the settings of the instance. On error, an empty config is returned, so the global settings apply.
+func (s *userManagementServer) getInstanceConfig(instanceID string) models.InstanceConfig {
+	if s.instanceConfigs != nil {
+		if conf, ok := s.instanceConfigs.get(instanceID); ok {
+			return conf
+		}
+	}
+	conf, err := s.globalDBService.GetInstanceConfig(instanceID)
+	if err != nil {
+		logger.Error.Printf("couldn't read config of instance %s, using global settings: %v", instanceID, err)
+		return models.InstanceConfig{}
+	}
+	if s.instanceConfigs != nil {
+		s.instanceConfigs.set(instanceID, conf)
+	}
+	return conf
+}
+
+// checkEmailDomainPolicy checks the domain of the email address against the allowed and blocked domains of the instance
+func (s *userManagementServer) checkEmailDomainPolicy(instanceID string, email string) bool {
+	return utils.CheckEmailDomainPolicy(email, s.getInstanceConfig(instanceID).EmailDomains)
+}
+
+// isTrustedEmailDomain tells if accounts with the email address can be confirmed without verification email
+func (s *userManagementServer) isTrustedEmailDomain(instanceID string, email string) bool {
+	return utils.IsTrustedEmailDomain(email, s.getInstanceConfig(instanceID).EmailDomains)
+}
+
+// checkPasswordPolicy checks the password against the policy of the instance
+func (s *userManagementServer) checkPasswordPolicy(instanceID string, password string) bool {
+	return utils.CheckPasswordPolicy(password, s.getInstanceConfig(instanceID).PasswordPolicy)
+}
+
+// checkMinimumAge checks that the account holder with the birthdate reaches the minimum age of the instance at today.
+// Without minimum age, any birthdate is accepted.
+func (s *userManagementServer) checkMinimumAge(instanceID string, birthdate string, today time.Time) error {
+	minimumAge := s.getInstanceConfig(instanceID).MinimumAge
+	if minimumAge <= 0 {
+		return nil
+	}
+	if birthdate == "" {
+		return errorWithCode(codes.InvalidArgument, "birthdate required", models.ERROR_CODE_MISSING_ARGUMENT, fieldViolation("birthdate", "required"))
+	}
+	if age, ok := (models.Profile{Birthdate: birthdate}).AgeAt(today); !ok || age < minimumAge {
+		return errorWithCode(codes.FailedPrecondition, minimumAgeNotReachedMsg, models.ERROR_CODE_MINIMUM_AGE_NOT_REACHED)
+	}
+	return nil
+}
+
+// accountHolderBirthdate reads the birthdate of the account holder of a new account from the request metadata, since the
+// signup messages have no field for it, and checks it against the minimum age of the instance
+func (s *userManagementServer) accountHolderBirthdate(ctx context.Context, instanceID string) (string, error) {
+	birthdate := metadataValueFromContext(ctx, birthdateMetadataKey)
+	if birthdate != "" {
+		if err := models.ValidateBirthdate(birthdate, time.Now()); err != nil {
+			return "", errorWithCode(codes.InvalidArgument, err.Error(), models.ERROR_CODE_INVALID_BIRTHDATE, fieldViolation("birthdate", err.Error()))
+		}
+	}
+	if err := s.checkMinimumAge(instanceID, birthdate, time.Now()); err != nil {
+		return "", err
+	}
+	return birthdate, nil
+}
+
+// recordReleasedEmail blocks the email address, no longer used as account ID, for the cooldown of the instance if it has one
+func (s *userManagementServer) recordReleasedEmail(instanceID string, email string) {
+	cooldown := s.getInstanceConfig(instanceID).ReleasedEmailCooldown
+	if cooldown <= 0 || email == "" {
+		return
+	}
+	if err := s.globalDBService.AddReleasedEmail(instanceID, email, time.Duration(cooldown)*time.Second); err != nil {
+		logger.Error.Printf("couldn't record released email of instance %s: %v", instanceID, err)
+	}
+}
+
+// isEmailReleasedRecently tells if the email address is still blocked after being released by another account
+func (s *userManagementServer) isEmailReleasedRecently(instanceID string, email string) bool {
+	if s.getInstanceConfig(instanceID).ReleasedEmailCooldown <= 0 {
+		return false
+	}
+	released, err := s.globalDBService.IsEmailReleasedRecently(instanceID, email)
+	if err != nil {
+		logger.Error.Printf("couldn't check released emails of instance %s: %v", instanceID, err)
+		return false
+	}
+	return released
+}
+
+// sendInstantEmail sends the email with the sender of the instance, and a copy to the BCC address of the instance if
+// the message type is copied. A failed copy is only logged.
+func (s *userManagementServer) sendInstantEmail(ctx context.Context, req *messageAPI.SendEmailReq) error {
+	bcc := s.getInstanceConfig(req.InstanceId).Email.Apply(req)
+	if _, err := s.clients.MessagingService.SendInstantEmail(ctx, req); err != nil {
+		return err
+	}
+	if bcc != nil {
+		if _, err := s.clients.MessagingService.SendInstantEmail(ctx, bcc); err != nil {
+			logger.Error.Printf("couldn't send copy of %s email of instance %s: %v", req.MessageType, req.InstanceId, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,459 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	"github.com/influenzanet/go-utils/pkg/constants"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
+	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+)
+
+func TestPasswordPolicyPerInstance(t *testing.T) {
+	s := userManagementServer{
+		globalDBService: testGlobalDBService,
+		instanceConfigs: newInstanceConfigCache(time.Minute),
+	}
+
+	strictInstance := testInstanceID + "_strict_pw"
+	if err := testGlobalDBService.SaveInstanceConfig(strictInstance, models.InstanceConfig{
+		PasswordPolicy: models.PasswordPolicy{MinLength: 12},
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	for _, tc := range []struct {
+		instanceID string
+		password   string
+		valid      bool
+	}{
+		{instanceID: testInstanceID, password: "1n34T678", valid: true},
+		{instanceID: strictInstance, password: "1n34T678", valid: false},
+		{instanceID: strictInstance, password: "1n34T678abcd", valid: true},
+		{instanceID: testInstanceID, password: "1n34T6@", valid: false},
+	} {
+		if s.checkPasswordPolicy(tc.instanceID, tc.password) != tc.valid {
+			t.Errorf("password %s should be valid for %s: %v", tc.password, tc.instanceID, tc.valid)
+		}
+	}
+
+	t.Run("policy is cached", func(t *testing.T) {
+		if err := testGlobalDBService.SaveInstanceConfig(strictInstance, models.InstanceConfig{}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if s.checkPasswordPolicy(strictInstance, "1n34T678") {
+			t.Error("cached policy should still apply")
+		}
+	})
+}
+
+func TestEmailDomainPolicyPerInstance(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		instanceConfigs: newInstanceConfigCache(time.Minute),
+	}
+
+	restrictedInstance := testInstanceID + "_email_domains"
+	if err := testGlobalDBService.SaveInstanceConfig(restrictedInstance, models.InstanceConfig{
+		EmailDomains: models.EmailDomainPolicy{
+			AllowedDomains: []string{"uni-example.de"},
+			BlockedDomains: []string{"guest.uni-example.de"},
+		},
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	for _, tc := range []struct {
+		instanceID string
+		email      string
+		valid      bool
+	}{
+		{instanceID: testInstanceID, email: "test@test.com", valid: true},
+		{instanceID: restrictedInstance, email: "test@uni-example.de", valid: true},
+		{instanceID: restrictedInstance, email: "test@guest.uni-example.de", valid: false},
+		{instanceID: restrictedInstance, email: "test@test.com", valid: false},
+	} {
+		if s.checkEmailDomainPolicy(tc.instanceID, tc.email) != tc.valid {
+			t.Errorf("email %s should be valid for %s: %v", tc.email, tc.instanceID, tc.valid)
+		}
+	}
+
+	token := &api_types.TokenInfos{
+		Id:         primitive.NewObjectID().Hex(),
+		InstanceId: restrictedInstance,
+		Payload:    map[string]string{"roles": "PARTICIPANT"},
+	}
+
+	t.Run("signup with blocked domain", func(t *testing.T) {
+		_, err := s.SignupWithEmail(context.Background(), &api.SignupWithEmailMsg{
+			Email:             "test@guest.uni-example.de",
+			Password:          "SuperSecurePassword123!§$",
+			InstanceId:        restrictedInstance,
+			PreferredLanguage: "en",
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "email domain not allowed")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("signup with domain not on the allowlist", func(t *testing.T) {
+		_, err := s.SignupWithEmail(context.Background(), &api.SignupWithEmailMsg{
+			Email:             "test@test.com",
+			Password:          "SuperSecurePassword123!§$",
+			InstanceId:        restrictedInstance,
+			PreferredLanguage: "en",
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "email domain not allowed")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("add email with domain not on the allowlist", func(t *testing.T) {
+		_, err := s.AddEmail(context.Background(), &api.ContactInfoMsg{
+			Token: token,
+			ContactInfo: &api.ContactInfo{
+				Type:    "email",
+				Address: &api.ContactInfo_Email{Email: "test@test.com"},
+			},
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "email domain not allowed")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("change account ID to blocked domain", func(t *testing.T) {
+		_, err := s.ChangeAccountIDEmail(context.Background(), &api.EmailChangeMsg{
+			Token:    token,
+			NewEmail: "test@guest.uni-example.de",
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "email domain not allowed")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
+
+func TestInstanceIDsRefresh(t *testing.T) {
+	s := userManagementServer{
+		globalDBService: testGlobalDBService,
+		instanceIDs:     newInstanceIDCache(time.Minute),
+	}
+
+	if !s.isInstanceIDAllowed(testInstanceID) {
+		t.Error("existing instance should be allowed")
+	}
+
+	addedInstance := testInstanceID + "_added_later"
+	if err := testGlobalDBService.CreateInstance(models.Instance{InstanceID: addedInstance}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	t.Run("not allowed before refresh", func(t *testing.T) {
+		if s.isInstanceIDAllowed(addedInstance) {
+			t.Error("cached instance IDs should still apply")
+		}
+	})
+
+	t.Run("allowed after refresh", func(t *testing.T) {
+		if err := s.refreshInstanceIDs(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if !s.isInstanceIDAllowed(addedInstance) {
+			t.Error("instance should be allowed after refresh")
+		}
+	})
+
+	t.Run("refreshed when stale", func(t *testing.T) {
+		s.instanceIDs = newInstanceIDCache(10 * time.Millisecond)
+		s.instanceIDs.replace([]string{testInstanceID})
+		if s.isInstanceIDAllowed(addedInstance) {
+			t.Error("cached instance IDs should still apply")
+		}
+		time.Sleep(20 * time.Millisecond)
+		if !s.isInstanceIDAllowed(addedInstance) {
+			t.Error("instance should be allowed after the refresh interval")
+		}
+	})
+}
+
+func TestReleasedEmailCooldownPerInstance(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		instanceConfigs: newInstanceConfigCache(time.Minute),
+	}
+
+	cooldownInstance := testInstanceID + "_released_emails"
+	if err := testGlobalDBService.SaveInstanceConfig(cooldownInstance, models.InstanceConfig{ReleasedEmailCooldown: 3600}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	t.Run("without cooldown", func(t *testing.T) {
+		s.recordReleasedEmail(testInstanceID, "released@test.com")
+		if s.isEmailReleasedRecently(testInstanceID, "released@test.com") {
+			t.Error("address should not be blocked")
+		}
+	})
+
+	t.Run("during cooldown", func(t *testing.T) {
+		s.recordReleasedEmail(cooldownInstance, "released@test.com")
+		if !s.isEmailReleasedRecently(cooldownInstance, "released@test.com") {
+			t.Error("address should be blocked")
+		}
+
+		_, err := s.SignupWithEmail(context.Background(), &api.SignupWithEmailMsg{
+			Email:             "released@test.com",
+			Password:          "SuperSecurePassword123!§$",
+			InstanceId:        cooldownInstance,
+			PreferredLanguage: "en",
+		})
+		ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_EMAIL_RECENTLY_RELEASED)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("add released address as contact", func(t *testing.T) {
+		userID, err := testUserDBService.AddUser(context.Background(), cooldownInstance, models.User{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_add_released@test.com",
+			},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		_, err = s.AddEmail(context.Background(), &api.ContactInfoMsg{
+			Token: &api_types.TokenInfos{Id: userID, InstanceId: cooldownInstance},
+			ContactInfo: &api.ContactInfo{
+				Type:    "email",
+				Address: &api.ContactInfo_Email{Email: "Released@test.com"},
+			},
+		})
+		ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_EMAIL_RECENTLY_RELEASED)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("after cooldown", func(t *testing.T) {
+		if err := testGlobalDBService.AddReleasedEmail(cooldownInstance, "released@test.com", -time.Second); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if s.isEmailReleasedRecently(cooldownInstance, "released@test.com") {
+			t.Error("address should not be blocked anymore")
+		}
+	})
+}
+
+func TestTrustedEmailDomainsPerInstance(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		instanceConfigs: newInstanceConfigCache(time.Minute),
+		Intervals: models.Intervals{
+			TokenExpiryInterval: time.Second * 2,
+		},
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
+		},
+		newUserCountLimit: 100,
+	}
+
+	trustingInstance := testInstanceID + "_trusted_domains"
+	if err := testGlobalDBService.SaveInstanceConfig(trustingInstance, models.InstanceConfig{
+		EmailDomains: models.EmailDomainPolicy{TrustedDomains: []string{"uni-example.de"}},
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	t.Run("signup with trusted domain", func(t *testing.T) {
+		// no verification email expected
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+		_, err := s.SignupWithEmail(context.Background(), &api.SignupWithEmailMsg{
+			Email:             "trusted@mail.uni-example.de",
+			Password:          "SuperSecurePassword123!§$",
+			InstanceId:        trustingInstance,
+			PreferredLanguage: "en",
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		user, err := testUserDBService.GetUserByAccountID(context.Background(), trustingInstance, "trusted@mail.uni-example.de")
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Account.AccountConfirmedAt <= 0 || user.ContactInfos[0].ConfirmedAt <= 0 {
+			t.Errorf("account should be confirmed: %v", user.Account)
+		}
+		tts, err := testGlobalDBService.GetTempTokenForUser(trustingInstance, user.ID.Hex(), constants.TOKEN_PURPOSE_CONTACT_VERIFICATION)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(tts) > 0 {
+			t.Errorf("unexpected verification tokens: %v", tts)
+		}
+
+		// not removed by the clean up of unverified accounts
+		unverified, err := testUserDBService.FindUnverifiedUsers(context.Background(), trustingInstance, time.Now().Unix()+10)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		for _, u := range unverified {
+			if u.ID == user.ID {
+				t.Error("confirmed account should not be cleaned up")
+			}
+		}
+	})
+
+	t.Run("signup with other domain", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).Return(nil, nil)
+		mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+		_, err := s.SignupWithEmail(context.Background(), &api.SignupWithEmailMsg{
+			Email:             "untrusted@test.com",
+			Password:          "SuperSecurePassword123!§$",
+			InstanceId:        trustingInstance,
+			PreferredLanguage: "en",
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		// the verification email is sent in the background
+		time.Sleep(100 * time.Millisecond)
+		user, err := testUserDBService.GetUserByAccountID(context.Background(), trustingInstance, "untrusted@test.com")
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Account.AccountConfirmedAt != 0 {
+			t.Errorf("account should not be confirmed: %v", user.Account)
+		}
+	})
+}
+
+func TestEmailSettingsPerInstance(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		globalDBService: testGlobalDBService,
+		instanceConfigs: newInstanceConfigCache(time.Minute),
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+		},
+	}
+
+	brandedInstance := testInstanceID + "_email_settings"
+	if err := testGlobalDBService.SaveInstanceConfig(brandedInstance, models.InstanceConfig{
+		Email: models.InstanceEmailConfig{
+			SenderName:    "Example Study",
+			SenderAddress: "study@example.org",
+			BCC:           "admin@example.org",
+		},
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	sent := []*messageAPI.SendEmailReq{}
+	mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			sent = append(sent, req)
+			return &messageAPI.ServiceStatus{}, nil
+		}).AnyTimes()
+
+	t.Run("sensitive email with instance settings", func(t *testing.T) {
+		sent = sent[:0]
+		err := s.sendInstantEmail(context.Background(), &messageAPI.SendEmailReq{
+			InstanceId:  brandedInstance,
+			To:          []string{"user@test.com"},
+			MessageType: constants.EMAIL_TYPE_ACCOUNT_DELETED,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(sent) != 2 {
+			t.Errorf("expected email and copy, got %d requests", len(sent))
+			return
+		}
+		if sent[0].To[0] != "user@test.com" || sent[1].To[0] != "admin@example.org" {
+			t.Errorf("unexpected recipients: %v, %v", sent[0].To, sent[1].To)
+		}
+		for _, req := range sent {
+			if req.MessageType != constants.EMAIL_TYPE_ACCOUNT_DELETED ||
+				req.ContentInfos[models.EMAIL_CONTENT_SENDER_NAME] != "Example Study" ||
+				req.ContentInfos[models.EMAIL_CONTENT_SENDER_ADDRESS] != "study@example.org" {
+				t.Errorf("unexpected request: %v", req)
+			}
+		}
+	})
+
+	t.Run("email with token is not copied", func(t *testing.T) {
+		sent = sent[:0]
+		err := s.sendInstantEmail(context.Background(), &messageAPI.SendEmailReq{
+			InstanceId:   brandedInstance,
+			To:           []string{"user@test.com"},
+			MessageType:  constants.EMAIL_TYPE_REGISTRATION,
+			ContentInfos: map[string]string{"token": "secret"},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(sent) != 1 || sent[0].ContentInfos["token"] != "secret" || sent[0].ContentInfos[models.EMAIL_CONTENT_SENDER_NAME] != "Example Study" {
+			t.Errorf("unexpected requests: %v", sent)
+		}
+	})
+
+	t.Run("instance without settings", func(t *testing.T) {
+		sent = sent[:0]
+		err := s.sendInstantEmail(context.Background(), &messageAPI.SendEmailReq{
+			InstanceId:  testInstanceID,
+			To:          []string{"user@test.com"},
+			MessageType: constants.EMAIL_TYPE_ACCOUNT_DELETED,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(sent) != 1 || len(sent[0].ContentInfos) > 0 {
+			t.Errorf("unexpected requests: %v", sent)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/coneno/logger"
+	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	constants "github.com/influenzanet/go-utils/pkg/constants"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/dbs/globaldb"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"github.com/influenzanet/user-management-service/pkg/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// instance IDs are part of DB names
+var instanceIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,48}$`)
+
+// isInstanceManager tells if the token is a service account token of the management instance, admin roles are
+// only valid within their own instance
+func (s *userManagementServer) isInstanceManager(token *api_types.TokenInfos) bool {
+	return s.managementInstanceID != "" && token.InstanceId == s.managementInstanceID &&
+		tokens.HasRole(token.Payload, constants.USER_ROLE_SERVICE_ACCOUNT)
+}
+
+// CreateInstance adds a new instance with its settings and creates the indexes of its users DB. Only for service
+// accounts of the management instance.
+func (s *userManagementServer) CreateInstance(ctx context.Context, req *InstanceMsg) (*models.Instance, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.Instance == nil || req.Instance.InstanceID == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !s.isInstanceManager(req.Token) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	if !instanceIDPattern.MatchString(req.Instance.InstanceID) {
+		return nil, status.Error(codes.InvalidArgument, "invalid instance ID")
+	}
+
+	if err := s.globalDBService.CreateInstance(*req.Instance); err != nil {
+		if err == globaldb.ErrInstanceExists {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if s.instanceIDs != nil {
+		s.instanceIDs.set(req.Instance.InstanceID, true)
+	}
+	if err := s.userDBservice.CreateIndexForRenewTokens(req.Instance.InstanceID); err != nil {
+		logger.Error.Printf("CreateInstance: %v", err)
+	}
+	if err := s.userDBservice.CreateIndexForUser(req.Instance.InstanceID); err != nil {
+		logger.Error.Printf("CreateInstance: %v", err)
+	}
+
+	logger.Info.Printf("instance %s created by %s", req.Instance.InstanceID, req.Token.Id)
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, models.LOG_EVENT_INSTANCE_CREATED, req.Instance.InstanceID)
+	return req.Instance, nil
+}
+
+// ListInstances returns all instances with their settings. Only for service accounts of the management instance.
+func (s *userManagementServer) ListInstances(ctx context.Context, req *api.UserReference) (*InstanceList, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !s.isInstanceManager(req.Token) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	instances, err := s.globalDBService.ListInstances()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &InstanceList{Instances: make([]*models.Instance, len(instances))}
+	for i := range instances {
+		resp.Instances[i] = &instances[i]
+	}
+	return resp, nil
+}
+
+// GetInstance returns an instance with its settings, to the admins of the instance or the instance managers
+func (s *userManagementServer) GetInstance(ctx context.Context, req *api.UserReference) (*models.Instance, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.InstanceId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !s.isInstanceManager(req.Token) && !(tokens.IsAdmin(req.Token.Payload) && req.Token.InstanceId == req.InstanceId) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	instance, err := s.globalDBService.GetInstance(req.InstanceId)
+	if err == mongo.ErrNoDocuments {
+		return nil, status.Error(codes.NotFound, "instance not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &instance, nil
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
+)
+
+func TestInstanceEndpoints(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+		managementInstanceID: testInstanceID,
+	}
+
+	newInstanceID := testInstanceID + "_created"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testUserDBService.DBClient.Database(testDBNamePrefix + newInstanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	adminToken := &api_types.TokenInfos{
+		Id:         "testadminid",
+		InstanceId: testInstanceID,
+		Payload: map[string]string{
+			"roles": "PARTICIPANT,ADMIN",
+		},
+	}
+	managerToken := &api_types.TokenInfos{
+		Id:         "testserviceid",
+		InstanceId: testInstanceID,
+		Payload: map[string]string{
+			"roles": "SERVICE",
+		},
+	}
+	otherAdminToken := &api_types.TokenInfos{
+		Id:         "testadminid",
+		InstanceId: newInstanceID,
+		Payload: map[string]string{
+			"roles": "PARTICIPANT,ADMIN",
+		},
+	}
+
+	t.Run("create instance without admin role", func(t *testing.T) {
+		_, err := s.CreateInstance(context.Background(), &InstanceMsg{Token: &api_types.TokenInfos{Id: "testuserid", InstanceId: testInstanceID}, Instance: &models.Instance{InstanceID: newInstanceID}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("create instance with admin role", func(t *testing.T) {
+		_, err := s.CreateInstance(context.Background(), &InstanceMsg{Token: adminToken, Instance: &models.Instance{InstanceID: newInstanceID}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("create instance with service account of other instance", func(t *testing.T) {
+		_, err := s.CreateInstance(context.Background(), &InstanceMsg{Token: &api_types.TokenInfos{
+			Id:         "testserviceid",
+			InstanceId: testInstanceID + "_other",
+			Payload: map[string]string{
+				"roles": "SERVICE",
+			},
+		}, Instance: &models.Instance{InstanceID: newInstanceID}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("create instance with invalid ID", func(t *testing.T) {
+		_, err := s.CreateInstance(context.Background(), &InstanceMsg{Token: managerToken, Instance: &models.Instance{InstanceID: "bad/instance"}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid instance ID")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("unknown instance is not allowed", func(t *testing.T) {
+		if s.isInstanceIDAllowed(newInstanceID) {
+			t.Error("instance should not be allowed before creation")
+		}
+	})
+
+	t.Run("create instance", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		_, err := s.CreateInstance(context.Background(), &InstanceMsg{Token: managerToken, Instance: &models.Instance{
+			InstanceID:     newInstanceID,
+			UserManagement: models.InstanceConfig{VerificationCodeLifetime: 300},
+		}})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if !s.isInstanceIDAllowed(newInstanceID) {
+			t.Error("created instance should be allowed")
+		}
+	})
+
+	t.Run("create existing instance", func(t *testing.T) {
+		_, err := s.CreateInstance(context.Background(), &InstanceMsg{Token: managerToken, Instance: &models.Instance{InstanceID: newInstanceID}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "instance already exists")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("get instance", func(t *testing.T) {
+		instance, err := s.GetInstance(context.Background(), &api.UserReference{Token: managerToken, InstanceId: newInstanceID})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if instance.UserManagement.VerificationCodeLifetime != 300 {
+			t.Errorf("unexpected instance: %v", instance)
+		}
+		_, err = s.GetInstance(context.Background(), &api.UserReference{Token: managerToken, InstanceId: newInstanceID + "_unknown"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "instance not found")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("get instance as admin of the instance", func(t *testing.T) {
+		instance, err := s.GetInstance(context.Background(), &api.UserReference{Token: otherAdminToken, InstanceId: newInstanceID})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if instance.InstanceID != newInstanceID {
+			t.Errorf("unexpected instance: %v", instance)
+		}
+	})
+
+	t.Run("get instance as admin of other instance", func(t *testing.T) {
+		_, err := s.GetInstance(context.Background(), &api.UserReference{Token: adminToken, InstanceId: newInstanceID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("list instances with admin role", func(t *testing.T) {
+		_, err := s.ListInstances(context.Background(), &api.UserReference{Token: adminToken})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("list instances", func(t *testing.T) {
+		instances, err := s.ListInstances(context.Background(), &api.UserReference{Token: managerToken})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		found := map[string]bool{}
+		for _, i := range instances.Instances {
+			found[i.InstanceID] = true
+		}
+		if !found[testInstanceID] || !found[newInstanceID] {
+			t.Errorf("unexpected instances: %v", instances)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/coneno/logger"
+	"github.com/influenzanet/go-utils/pkg/constants"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/dbs/userdb"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/pwhash"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"github.com/influenzanet/user-management-service/pkg/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// InviteUser sends an invitation to sign up to the email address. The account is only created once the
+// invitation is accepted, see AcceptInvitation.
+func (s *userManagementServer) InviteUser(ctx context.Context, req *InviteUserReq) (*api.ServiceStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.Email == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+	if !tokens.HasAnyRole(req.Token.Payload, constants.USER_ROLE_ADMIN, constants.USER_ROLE_RESEARCHER) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	req.Email = utils.SanitizeEmail(req.Email)
+	if !utils.CheckEmailFormat(req.Email) {
+		return nil, errorWithCode(codes.InvalidArgument, "email not valid", models.ERROR_CODE_INVALID_EMAIL)
+	}
+	if req.PreferredLanguage != "" && !utils.CheckLanguageCode(req.PreferredLanguage) {
+		return nil, errorWithCode(codes.InvalidArgument, "language code wrong", models.ERROR_CODE_INVALID_LANGUAGE_CODE)
+	}
+	if _, err := s.userDBservice.GetUserByAccountID(ctx, req.Token.InstanceId, req.Email); err == nil {
+		return nil, status.Error(codes.AlreadyExists, "user already exists")
+	}
+
+	tempToken, err := s.globalDBService.AddTempToken(models.TempToken{
+		InstanceID: req.Token.InstanceId,
+		Purpose:    models.TOKEN_PURPOSE_SIGNUP_INVITATION,
+		Info: map[string]string{
+			"type":      models.ACCOUNT_TYPE_EMAIL,
+			"email":     req.Email,
+			"language":  req.PreferredLanguage,
+			"invitedBy": req.Token.Id,
+		},
+		Expiration: tokens.GetExpirationTime(s.Intervals.InvitationTokenLifetime),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// ---> Trigger message sending
+	err = s.sendInstantEmail(ctx, &messageAPI.SendEmailReq{
+		InstanceId:  req.Token.InstanceId,
+		To:          []string{req.Email},
+		MessageType: models.EMAIL_TYPE_SIGNUP_INVITATION,
+		ContentInfos: map[string]string{
+			"token": tempToken,
+		},
+		PreferredLanguage: req.PreferredLanguage,
+	})
+	if err != nil {
+		// without the email the invitation can not be accepted
+		logger.Error.Printf("InviteUser: %s", err.Error())
+		if err := s.globalDBService.DeleteTempToken(tempToken); err != nil {
+			logger.Error.Printf("InviteUser: %s", err.Error())
+		}
+		return nil, status.Error(codes.Internal, "invitation could not be sent")
+	}
+	// <---
+
+	return &api.ServiceStatus{
+		Status:  api.ServiceStatus_NORMAL,
+		Msg:     "invitation sent",
+		Version: apiVersion,
+	}, nil
+}
+
+// AcceptInvitation creates the account of an invited user with the chosen password. The email address
+// counts as confirmed, since the invitation token was sent to it.
+func (s *userManagementServer) AcceptInvitation(ctx context.Context, req *AcceptInvitationReq) (*api.User, error) {
+	if req == nil || req.InvitationToken == "" || req.Password == "" {
+		return nil, errorWithCode(codes.InvalidArgument, "missing arguments", models.ERROR_CODE_MISSING_ARGUMENT)
+	}
+
+	tokenInfos, err := s.ValidateTempToken(req.InvitationToken, []string{models.TOKEN_PURPOSE_SIGNUP_INVITATION})
+	if err != nil {
+		logger.Warning.Printf("AcceptInvitation: %s", err.Error())
+		return nil, errorWithCode(codes.InvalidArgument, "invalid token", models.ERROR_CODE_INVALID_TOKEN)
+	}
+	if !s.checkPasswordPolicy(tokenInfos.InstanceID, req.Password) {
+		return nil, errorWithCode(codes.InvalidArgument, "password too weak", models.ERROR_CODE_PASSWORD_TOO_WEAK)
+	}
+
+	email := tokenInfos.Info["email"]
+	// the instance settings may have changed since the invitation was sent
+	if !s.checkEmailDomainPolicy(tokenInfos.InstanceID, email) {
+		return nil, errorWithCode(codes.InvalidArgument, "email domain not allowed", models.ERROR_CODE_EMAIL_DOMAIN_NOT_ALLOWED)
+	}
+	if s.isEmailReleasedRecently(tokenInfos.InstanceID, email) {
+		return nil, errorWithCode(codes.InvalidArgument, emailRecentlyReleasedMsg, models.ERROR_CODE_EMAIL_RECENTLY_RELEASED)
+	}
+	if _, err := s.userDBservice.GetUserByAccountID(ctx, tokenInfos.InstanceID, email); err == nil {
+		return nil, errorWithCode(codes.AlreadyExists, "user already exists", models.ERROR_CODE_ACCOUNT_ID_IN_USE)
+	}
+	birthdate, err := s.accountHolderBirthdate(ctx, tokenInfos.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := pwhash.HashPassword(req.Password)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	now := time.Now().Unix()
+	newUser := models.User{
+		Account: models.Account{
+			Type:                  models.ACCOUNT_TYPE_EMAIL,
+			AccountID:             email,
+			AccountConfirmedAt:    now,
+			Password:              hashedPassword,
+			PreferredLanguage:     tokenInfos.Info["language"],
+			FailedLoginAttempts:   []int64{},
+			PasswordResetTriggers: []int64{},
+		},
+		Roles: []string{constants.USER_ROLE_PARTICIPANT},
+		Profiles: []models.Profile{
+			{
+				ID:                 primitive.NewObjectID(),
+				Alias:              utils.BlurEmailAddress(email),
+				ConsentConfirmedAt: now,
+				AvatarID:           "default",
+				MainProfile:        true,
+				Birthdate:          birthdate,
+			},
+		},
+		Timestamps: models.Timestamps{
+			CreatedAt: now,
+		},
+	}
+	newUser.AddNewEmail(email, true)
+	newUser.ContactPreferences.SetTopicSubscription(models.TOPIC_NEWSLETTER, false)
+	newUser.ContactPreferences.SubscribedToWeekly = true
+	newUser.ContactPreferences.ReceiveWeeklyMessageDayOfWeek = int32(s.weekdayStrategy.Weekday())
+
+	id, err := s.userDBservice.AddUser(ctx, tokenInfos.InstanceID, newUser)
+	if err == userdb.ErrUserExists {
+		return nil, errorWithCode(codes.AlreadyExists, "user already exists", models.ERROR_CODE_ACCOUNT_ID_IN_USE)
+	}
+	if err != nil {
+		logger.Error.Printf("AcceptInvitation: %s", err.Error())
+		return nil, status.Error(codes.Internal, "user creation failed")
+	}
+	newUser.ID, _ = primitive.ObjectIDFromHex(id)
+
+	// the invitation must not be usable again
+	if err := s.globalDBService.DeleteTempToken(req.InvitationToken); err != nil {
+		logger.Error.Printf("AcceptInvitation: %s", err.Error())
+	}
+
+	s.SaveLogEvent(tokenInfos.InstanceID, id, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_ACCOUNT_CREATED, "by invitation of "+tokenInfos.Info["invitedBy"]+" - "+email)
+	s.notifyWebhook(models.WEBHOOK_EVENT_ACCOUNT_CREATED, tokenInfos.InstanceID, id)
+
+	return newUser.ToAPI(), nil
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/pwhash"
+	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
+	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestInviteUserEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			InvitationTokenLifetime: time.Hour,
+		},
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+		},
+	}
+
+	researcherToken := &api_types.TokenInfos{
+		Id:         "researcher_id",
+		InstanceId: testInstanceID,
+		Payload:    map[string]string{"roles": "PARTICIPANT,RESEARCHER"},
+	}
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.InviteUser(context.Background(), &InviteUserReq{Email: "invited@test.com", PreferredLanguage: "en"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing arguments")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as participant", func(t *testing.T) {
+		_, err := s.InviteUser(context.Background(), &InviteUserReq{Token: &api_types.TokenInfos{
+			Id:         "participant_id",
+			InstanceId: testInstanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT"},
+		}, Email: "invited@test.com", PreferredLanguage: "en"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with wrong email", func(t *testing.T) {
+		_, err := s.InviteUser(context.Background(), &InviteUserReq{Token: researcherToken, Email: "invited-test.com", PreferredLanguage: "en"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "email not valid")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with existing account", func(t *testing.T) {
+		_, err := testUserDBService.AddUser(context.Background(), testInstanceID, models.User{
+			Account: models.Account{Type: models.ACCOUNT_TYPE_EMAIL, AccountID: "invited-existing@test.com"},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		_, err = s.InviteUser(context.Background(), &InviteUserReq{Token: researcherToken, Email: "invited-existing@test.com", PreferredLanguage: "en"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "user already exists")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("when email can not be sent", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, errors.New("messaging service down"))
+
+		_, err := s.InviteUser(context.Background(), &InviteUserReq{Token: researcherToken, Email: "invited-failed@test.com", PreferredLanguage: "en"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invitation could not be sent")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with valid arguments", func(t *testing.T) {
+		var sentToken string
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			if req.MessageType != models.EMAIL_TYPE_SIGNUP_INVITATION || len(req.To) != 1 || req.To[0] != "invited@test.com" {
+				t.Errorf("unexpected email: %v", req)
+			}
+			sentToken = req.ContentInfos["token"]
+			return nil, nil
+		})
+
+		_, err := s.InviteUser(context.Background(), &InviteUserReq{Token: researcherToken, Email: " Invited@test.com", PreferredLanguage: "en"})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		tt, err := testGlobalDBService.GetTempToken(sentToken)
+		if err != nil {
+			t.Errorf("invitation token not found: %v", err)
+			return
+		}
+		if tt.Purpose != models.TOKEN_PURPOSE_SIGNUP_INVITATION || tt.Info["email"] != "invited@test.com" || tt.InstanceID != testInstanceID {
+			t.Errorf("unexpected token: %v", tt)
+		}
+		lifetime := tt.Expiration - time.Now().Unix()
+		if lifetime < 3500 || lifetime > 3600 {
+			t.Errorf("expiration should come from the invitation lifetime: %d", lifetime)
+		}
+		if _, err := testUserDBService.GetUserByAccountID(context.Background(), testInstanceID, "invited@test.com"); err == nil {
+			t.Error("user should only be created once the invitation is accepted")
+		}
+	})
+}
+
+func TestAcceptInvitationEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		instanceConfigs: newInstanceConfigCache(time.Minute),
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+
+	restrictedInstance := testInstanceID + "_invitation_domains"
+	if err := testGlobalDBService.SaveInstanceConfig(restrictedInstance, models.InstanceConfig{
+		EmailDomains: models.EmailDomainPolicy{AllowedDomains: []string{"uni-example.de"}},
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	addInvitationForInstance := func(instanceID string, email string, expiration int64) string {
+		token, err := testGlobalDBService.AddTempToken(models.TempToken{
+			InstanceID: instanceID,
+			Purpose:    models.TOKEN_PURPOSE_SIGNUP_INVITATION,
+			Info: map[string]string{
+				"type":      models.ACCOUNT_TYPE_EMAIL,
+				"email":     email,
+				"language":  "de",
+				"invitedBy": "researcher_id",
+			},
+			Expiration: expiration,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return token
+	}
+	addInvitation := func(email string, expiration int64) string {
+		return addInvitationForInstance(testInstanceID, email, expiration)
+	}
+	password := "SuperSecurePassword123!§$"
+
+	t.Run("without payload", func(t *testing.T) {
+		_, err := s.AcceptInvitation(context.Background(), &AcceptInvitationReq{Password: password})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing arguments")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with wrong token", func(t *testing.T) {
+		_, err := s.AcceptInvitation(context.Background(), &AcceptInvitationReq{InvitationToken: "wrong-token", Password: password})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid token")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with expired invitation", func(t *testing.T) {
+		token := addInvitation("invited-expired@test.com", time.Now().Unix()-10)
+		_, err := s.AcceptInvitation(context.Background(), &AcceptInvitationReq{InvitationToken: token, Password: password})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid token")
+		if !ok {
+			t.Error(msg)
+		}
+		if _, err := testUserDBService.GetUserByAccountID(context.Background(), testInstanceID, "invited-expired@test.com"); err == nil {
+			t.Error("user should not be created")
+		}
+	})
+
+	t.Run("with weak password", func(t *testing.T) {
+		token := addInvitation("invited-weak@test.com", time.Now().Unix()+60)
+		_, err := s.AcceptInvitation(context.Background(), &AcceptInvitationReq{InvitationToken: token, Password: "weak"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "password too weak")
+		if !ok {
+			t.Error(msg)
+		}
+		if _, err := testGlobalDBService.GetTempToken(token); err != nil {
+			t.Errorf("invitation should still be valid: %v", err)
+		}
+	})
+
+	t.Run("with email domain not allowed", func(t *testing.T) {
+		token := addInvitationForInstance(restrictedInstance, "invited@other-example.de", time.Now().Unix()+60)
+		_, err := s.AcceptInvitation(context.Background(), &AcceptInvitationReq{InvitationToken: token, Password: password})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "email domain not allowed")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with existing account", func(t *testing.T) {
+		if _, err := testUserDBService.AddUser(context.Background(), testInstanceID, models.User{
+			Account: models.Account{Type: models.ACCOUNT_TYPE_EMAIL, AccountID: "invited-existing@test.com"},
+		}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		token := addInvitation("invited-existing@test.com", time.Now().Unix()+60)
+		_, err := s.AcceptInvitation(context.Background(), &AcceptInvitationReq{InvitationToken: token, Password: password})
+		if status.Code(err) != codes.AlreadyExists {
+			t.Errorf("unexpected error: %v", err)
+		}
+		ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_ACCOUNT_ID_IN_USE)
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with minimum age of the instance", func(t *testing.T) {
+		ageGatedInstance := testInstanceID + "_invitation_minimum_age"
+		if err := testGlobalDBService.SaveInstanceConfig(ageGatedInstance, models.InstanceConfig{MinimumAge: 16}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		withBirthdate := func(birthdate string) context.Context {
+			return metadata.NewIncomingContext(context.Background(), metadata.Pairs(birthdateMetadataKey, birthdate))
+		}
+
+		token := addInvitationForInstance(ageGatedInstance, "invited-minimum-age@test.com", time.Now().Unix()+60)
+		_, err := s.AcceptInvitation(context.Background(), &AcceptInvitationReq{InvitationToken: token, Password: password})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "birthdate required")
+		if !ok {
+			t.Error(msg)
+		}
+		_, err = s.AcceptInvitation(withBirthdate(time.Now().AddDate(-15, 0, 0).Format(models.BIRTHDATE_LAYOUT)), &AcceptInvitationReq{InvitationToken: token, Password: password})
+		ok, msg = shouldHaveErrorCode(err, models.ERROR_CODE_MINIMUM_AGE_NOT_REACHED)
+		if !ok {
+			t.Error(msg)
+		}
+		if _, err := testGlobalDBService.GetTempToken(token); err != nil {
+			t.Errorf("invitation should still be valid: %v", err)
+		}
+
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+		adult := time.Now().AddDate(-30, 0, 0).Format(models.BIRTHDATE_LAYOUT)
+		if _, err := s.AcceptInvitation(withBirthdate(adult), &AcceptInvitationReq{InvitationToken: token, Password: password}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		user, err := testUserDBService.GetUserByAccountID(context.Background(), ageGatedInstance, "invited-minimum-age@test.com")
+		if err != nil {
+			t.Errorf("user should be created: %v", err)
+			return
+		}
+		if user.Profiles[0].Birthdate != adult {
+			t.Errorf("unexpected birthdate: %s", user.Profiles[0].Birthdate)
+		}
+	})
+
+	t.Run("with valid invitation", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		token := addInvitation("invited-accepted@test.com", time.Now().Unix()+60)
+		resp, err := s.AcceptInvitation(context.Background(), &AcceptInvitationReq{InvitationToken: token, Password: password})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if resp.Account.AccountId != "invited-accepted@test.com" || resp.Account.AccountConfirmedAt < 1 {
+			t.Errorf("unexpected user: %v", resp.Account)
+		}
+
+		user, err := testUserDBService.GetUserByAccountID(context.Background(), testInstanceID, "invited-accepted@test.com")
+		if err != nil {
+			t.Errorf("user should be created: %v", err)
+			return
+		}
+		if match, err := pwhash.ComparePasswordWithHash(user.Account.Password, password); err != nil || !match {
+			t.Errorf("password should be set: %v", err)
+		}
+		if user.Account.PreferredLanguage != "de" || !user.IsEmailConfirmed("invited-accepted@test.com") {
+			t.Errorf("unexpected user: %v", user)
+		}
+		if _, err := testGlobalDBService.GetTempToken(token); err == nil {
+			t.Error("invitation should be used up")
+		}
+
+		_, err = s.AcceptInvitation(context.Background(), &AcceptInvitationReq{InvitationToken: token, Password: password})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid token")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
+// Request and response messages of the endpoints without rpc definition in the api repository yet. Their fields
+// mirror the proto messages to add there (token infos in "token", ids as "...Id"), so that wiring an endpoint only
+// means switching its handler to the generated types. Endpoints that fit an existing api message use it instead.
+
+type AccountTypeChangeMsg struct {
+	Token        *api_types.TokenInfos
+	Password     string
+	NewType      string // models.ACCOUNT_TYPE_EMAIL or models.ACCOUNT_TYPE_USERNAME
+	NewAccountId string // username, or one of the confirmed email addresses of the user
+}
+
+type ReorderProfilesMsg struct {
+	Token      *api_types.TokenInfos
+	ProfileIds []string // all profiles of the user, the first one becomes the main profile
+}
+
+type ProfileReference struct {
+	Token     *api_types.TokenInfos
+	ProfileId string
+}
+
+type ProfileAvatarMsg struct {
+	Token     *api_types.TokenInfos
+	ProfileId string
+	AvatarUrl string // empty removes the avatar
+}
+
+type ProfileConsentMsg struct {
+	Token      *api_types.TokenInfos
+	ProfileId  string
+	ConsentKey string
+	Version    string
+}
+
+type ProfileLinkMsg struct {
+	Token             *api_types.TokenInfos
+	ChildProfileId    string
+	GuardianProfileId string // not used to unlink
+}
+
+type ProfileBirthdateMsg struct {
+	Token     *api_types.TokenInfos
+	ProfileId string
+	Birthdate string // models.BIRTHDATE_LAYOUT, empty removes it
+}
+
+type ProfileLanguageMsg struct {
+	Token        *api_types.TokenInfos
+	ProfileId    string
+	LanguageCode string // empty falls back to the account language
+}
+
+type PolicyAcceptanceMsg struct {
+	Token     *api_types.TokenInfos
+	PolicyKey string
+	Version   string
+}
+
+type TimezoneMsg struct {
+	Token    *api_types.TokenInfos
+	Timezone string // IANA name, empty for the server timezone
+}
+
+type QuietHoursMsg struct {
+	Token      *api_types.TokenInfos
+	QuietHours *models.QuietHours // nil removes the quiet hours
+}
+
+type TopicSubscriptionMsg struct {
+	Token      *api_types.TokenInfos
+	Topic      string
+	Subscribed bool
+}
+
+type ReauthenticateMsg struct {
+	Token    *api_types.TokenInfos
+	Password string
+}
+
+type ContactVerificationCodeMsg struct {
+	Token   *api_types.TokenInfos
+	Address string
+	Code    string // only to confirm the contact
+}
+
+type RevokeSessionReq struct {
+	Token     *api_types.TokenInfos
+	SessionId string // session id from ListSessions, or the refresh token itself
+}
+
+type SessionList struct {
+	Sessions []*models.Session
+}
+
+type LoginHistory struct {
+	Logins []*models.LoginRecord // newest first
+}
+
+type CreateAppTokenReq struct {
+	Token     *api_types.TokenInfos
+	AppName   string
+	Scopes    []string
+	ExpiresAt int64 // 0 means no expiration
+}
+
+type CreateAppTokenResponse struct {
+	AppToken string // only available in this response, it is stored hashed
+}
+
+type AppTokenList struct {
+	AppTokens []*models.AppToken
+}
+
+type AppTokenReference struct {
+	Token      *api_types.TokenInfos
+	AppTokenId string
+}
+
+type TempTokenOverviewList struct {
+	TempTokens []*models.TempTokenOverview
+}
+
+type TempTokenPurposeMsg struct {
+	Token   *api_types.TokenInfos
+	Purpose string
+}
+
+type InstanceMsg struct {
+	Token    *api_types.TokenInfos
+	Instance *models.Instance
+}
+
+type InstanceList struct {
+	Instances []*models.Instance
+}
+
+type InviteUserReq struct {
+	Token             *api_types.TokenInfos
+	Email             string
+	PreferredLanguage string
+}
+
+type AcceptInvitationReq struct {
+	InvitationToken string
+	Password        string
+}
+
+type DuplicateAccountList struct {
+	Groups []*models.DuplicateAccountGroup
+}
+
+type UserAuditLogReq struct {
+	Token     *api_types.TokenInfos
+	UserId    string
+	EventType loggingAPI.LogEventType // NONE for all types
+	Start     int64                   // unix seconds, 0 leaves the range open
+	End       int64
+}
+
+type UserAuditLog struct {
+	Events []*loggingAPI.LogEvent
+}
+
+type ExportInactiveUsersReq struct {
+	Token       *api_types.TokenInfos
+	InactiveFor int64 // seconds
+}
+
+type InactiveUserList struct {
+	Users []*models.InactiveUserRecord
+}
+
+type UsersNeverLoggedInReq struct {
+	Token         *api_types.TokenInfos
+	CreatedBefore int64 // unix seconds
+}
+
+type UserCount struct {
+	Count int64
+}
+
+type ServiceAccountTokenReference struct {
+	Token   *api_types.TokenInfos
+	TokenId string // id of the issuance record, the "jti" claim of the token
+}
+
+type DeleteInstanceReq struct {
+	Token        *api_types.TokenInfos
+	Confirmation string // the instance ID of the token, repeated
+}
+
+type SendTestEmailReq struct {
+	Token             *api_types.TokenInfos
+	To                string
+	MessageType       string
+	ContentInfos      map[string]string
+	PreferredLanguage string
+}
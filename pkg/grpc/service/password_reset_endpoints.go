@@ -2,7 +2,8 @@ package service
 
 import (
 	"context"
-	"math/rand"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/coneno/logger"
@@ -20,7 +21,7 @@ import (
 
 func (s *userManagementServer) InitiatePasswordReset(ctx context.Context, req *api.InitiateResetPasswordMsg) (*api.ServiceStatus, error) {
 	if req == nil || req.AccountId == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 
 	if req.InstanceId == "" {
@@ -32,70 +33,116 @@ func (s *userManagementServer) InitiatePasswordReset(ctx context.Context, req *a
 	}
 	req.AccountId = utils.SanitizeEmail(req.AccountId)
 
-	user, err := s.userDBservice.GetUserByAccountID(req.InstanceId, req.AccountId)
+	// From here on the response must not reveal whether the account exists: always the same response, after a similar delay
+	defer waitForMinResponseTime(time.Now(), time.Duration(passwordResetMinResponseTime)*time.Second)
+	response := &api.ServiceStatus{
+		Msg:     "email sending triggered",
+		Version: apiVersion,
+		Status:  api.ServiceStatus_NORMAL,
+	}
+
+	user, err := s.userDBservice.GetUserByConfirmedEmail(ctx, req.InstanceId, req.AccountId)
 	if err != nil {
 		logger.Warning.Printf("SECURITY WARNING: password reset attempt for invalid email address: %s - error: %v", req.AccountId, err)
-		return &api.ServiceStatus{
-			Msg:     "email sending triggered",
-			Version: apiVersion,
-			Status:  api.ServiceStatus_NORMAL,
-		}, nil
+		return response, nil
 	}
 
-	if utils.HasMoreAttemptsRecently(user.Account.PasswordResetTriggers, 5, passwordResetAttemptWindow) {
+	if s.passwordResetLimitReached(user.Account.PasswordResetTriggers) {
 		logger.Warning.Printf("SECURITY WARNING: password reset attempt blocked for email address for %s - too many tries recently", req.AccountId)
-		time.Sleep(time.Duration(rand.Intn(10)) * time.Second)
-		return nil, status.Error(codes.InvalidArgument, "account blocked for a while")
+		return response, nil
 	}
 
 	// TempToken for contact verification:
 	tempTokenInfos := models.TempToken{
 		UserID:     user.ID.Hex(),
 		InstanceID: req.InstanceId,
-		Purpose:    constants.EMAIL_TYPE_PASSWORD_RESET,
+		Purpose:    constants.TOKEN_PURPOSE_PASSWORD_RESET,
 		Info: map[string]string{
 			"email": user.Account.AccountID,
 		},
-		Expiration: tokens.GetExpirationTime(time.Hour * 24),
+		Expiration: tokens.GetExpirationTime(s.Intervals.PasswordResetTokenLifetime),
 	}
 	tempToken, err := s.globalDBService.AddTempToken(tempTokenInfos)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		logger.Error.Printf("InitiatePasswordReset: %s", err.Error())
+		return response, nil
+	}
+
+	to := user.Account.AccountID
+	if user.Account.AccountID != req.AccountId {
+		// found by a confirmed contact email
+		to = req.AccountId
+	} else if user.Account.Type == models.ACCOUNT_TYPE_USERNAME {
+		to = user.NotificationEmail()
 	}
 
 	// ---> Trigger message sending
-	_, err = s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+	err = s.sendInstantEmail(ctx, &messageAPI.SendEmailReq{
 		InstanceId:  req.InstanceId,
-		To:          []string{user.Account.AccountID},
+		To:          []string{to},
 		MessageType: constants.EMAIL_TYPE_PASSWORD_RESET,
 		ContentInfos: map[string]string{
 			"token":      tempToken,
-			"validUntil": "24", // hours
+			"validUntil": strconv.Itoa(int(math.Ceil(s.Intervals.PasswordResetTokenLifetime.Hours()))), // hours
 		},
 		PreferredLanguage: user.Account.PreferredLanguage,
 	})
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		logger.Error.Printf("InitiatePasswordReset: %s", err.Error())
+		return response, nil
 	}
 	// <---
 
-	if err2 := s.userDBservice.SavePasswordResetTrigger(req.InstanceId, user.ID.Hex()); err != nil {
-		logger.Error.Printf("DB ERROR: unexpected error when updating user: %s ", err2.Error())
+	// The account holder is told about a reset link sent to another address of the account, so that an unexpected
+	// request does not go unnoticed - only to confirmed addresses
+	if holderEmail := user.NotificationEmail(); holderEmail != to && user.IsEmailConfirmed(holderEmail) {
+		// ---> Trigger message sending
+		err = s.sendInstantEmail(ctx, &messageAPI.SendEmailReq{
+			InstanceId:        req.InstanceId,
+			To:                []string{holderEmail},
+			MessageType:       models.EMAIL_TYPE_PASSWORD_RESET_REQUESTED,
+			PreferredLanguage: user.Account.PreferredLanguage,
+			UseLowPrio:        true,
+		})
+		if err != nil {
+			logger.Error.Printf("InitiatePasswordReset: %s", err.Error())
+		}
+		// <---
+	}
+
+	if err := s.userDBservice.SavePasswordResetTrigger(ctx, req.InstanceId, user.ID.Hex()); err != nil {
+		logger.Error.Printf("DB ERROR: unexpected error when updating user: %s ", err.Error())
 	}
 
 	// ---> Log Event
 	s.SaveLogEvent(req.InstanceId, user.ID.Hex(), loggingAPI.LogEventType_LOG, constants.LOG_EVENT_PASSWORD_RESET_INITIATED, "email sent")
 
-	return &api.ServiceStatus{
-		Msg:     "email sending triggered",
-		Version: apiVersion,
-		Status:  api.ServiceStatus_NORMAL,
-	}, nil
+	return response, nil
+}
+
+// passwordResetLimitReached checks if the account already received the allowed number of password reset emails in the configured window
+func (s *userManagementServer) passwordResetLimitReached(triggers []int64) bool {
+	limit := s.passwordResetTriggerLimit
+	if limit < 1 {
+		limit = allowedPasswordResetTriggers
+	}
+	window := int64(s.Intervals.PasswordResetTriggerWindow.Seconds())
+	if window < 1 {
+		window = passwordResetAttemptWindow
+	}
+	return utils.HasMoreAttemptsRecently(triggers, int(limit)-1, window)
+}
+
+// waitForMinResponseTime sleeps until minDuration has passed since start
+func waitForMinResponseTime(start time.Time, minDuration time.Duration) {
+	if remaining := minDuration - time.Since(start); remaining > 0 {
+		time.Sleep(remaining)
+	}
 }
 
 func (s *userManagementServer) GetInfosForPasswordReset(ctx context.Context, req *api.GetInfosForResetPasswordMsg) (*api.UserInfoForPWReset, error) {
 	if req == nil || req.Token == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 
 	tokenInfos, err := s.ValidateTempToken(req.Token, []string{
@@ -104,13 +151,13 @@ func (s *userManagementServer) GetInfosForPasswordReset(ctx context.Context, req
 	})
 	if err != nil {
 		logger.Error.Printf("GetInfosForPasswordReset: %s", err.Error())
-		return nil, status.Error(codes.InvalidArgument, "wrong token")
+		return nil, errorWithCode(codes.InvalidArgument, "wrong token", models.ERROR_CODE_INVALID_TOKEN)
 	}
 
-	user, err := s.userDBservice.GetUserByID(tokenInfos.InstanceID, tokenInfos.UserID)
+	user, err := s.userDBservice.GetUserByID(ctx, tokenInfos.InstanceID, tokenInfos.UserID)
 	if err != nil {
 		logger.Error.Printf("GetInfosForPasswordReset: %s", err.Error())
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, userLookupError(err)
 	}
 
 	return &api.UserInfoForPWReset{
@@ -120,7 +167,7 @@ func (s *userManagementServer) GetInfosForPasswordReset(ctx context.Context, req
 
 func (s *userManagementServer) ResetPassword(ctx context.Context, req *api.ResetPasswordMsg) (*api.ServiceStatus, error) {
 	if req == nil || req.Token == "" || req.NewPassword == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing argument")
+		return nil, errorWithCode(codes.InvalidArgument, "missing argument", models.ERROR_CODE_MISSING_ARGUMENT)
 	}
 
 	tokenInfos, err := s.ValidateTempToken(req.Token,
@@ -131,11 +178,11 @@ func (s *userManagementServer) ResetPassword(ctx context.Context, req *api.Reset
 
 	if err != nil {
 		logger.Error.Printf("GetInfosForPasswordReset: %s", err.Error())
-		return nil, status.Error(codes.InvalidArgument, "wrong token")
+		return nil, errorWithCode(codes.InvalidArgument, "wrong token", models.ERROR_CODE_INVALID_TOKEN)
 	}
 
-	if !utils.CheckPasswordFormat(req.NewPassword) {
-		return nil, status.Error(codes.InvalidArgument, "password too weak")
+	if !s.checkPasswordPolicy(tokenInfos.InstanceID, req.NewPassword) {
+		return nil, errorWithCode(codes.InvalidArgument, "password too weak", models.ERROR_CODE_PASSWORD_TOO_WEAK)
 	}
 
 	password, err := pwhash.HashPassword(req.NewPassword)
@@ -143,39 +190,44 @@ func (s *userManagementServer) ResetPassword(ctx context.Context, req *api.Reset
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	err = s.userDBservice.UpdateUserPassword(tokenInfos.InstanceID, tokenInfos.UserID, password)
+	err = s.userDBservice.UpdateUserPassword(ctx, tokenInfos.InstanceID, tokenInfos.UserID, password)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	logger.Info.Printf("user %s initiated password change", tokenInfos.UserID)
 
-	user, err := s.userDBservice.GetUserByID(tokenInfos.InstanceID, tokenInfos.UserID)
+	user, err := s.userDBservice.GetUserByID(ctx, tokenInfos.InstanceID, tokenInfos.UserID)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, userLookupError(err)
 	}
 
 	if tokenInfos.Purpose == constants.TOKEN_PURPOSE_INVITATION {
 		newContactPrefs := user.ContactPreferences
-		newContactPrefs.SubscribedToNewsletter = true
+		newContactPrefs.SetTopicSubscription(models.TOPIC_NEWSLETTER, true)
 		newContactPrefs.SubscribedToWeekly = true
-		_, err = s.userDBservice.UpdateContactPreferences(tokenInfos.InstanceID, tokenInfos.UserID, newContactPrefs)
+		_, err = s.userDBservice.UpdateContactPreferences(ctx, tokenInfos.InstanceID, tokenInfos.UserID, newContactPrefs)
 		if err != nil {
 			logger.Error.Printf("unexpected error when updating contact preferences: %v", err)
 		}
 	}
 
-	// Trigger message sending
-	_, err = s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
-		InstanceId:        tokenInfos.InstanceID,
-		To:                []string{user.Account.AccountID},
-		MessageType:       constants.EMAIL_TYPE_PASSWORD_CHANGED,
-		PreferredLanguage: user.Account.PreferredLanguage,
-		UseLowPrio:        true,
-	})
-	if err != nil {
-		logger.Error.Printf("ChangePassword: %s", err.Error())
+	// Notify the user about the change, so that an unexpected reset does not go unnoticed - only to confirmed addresses
+	if notificationEmail := user.NotificationEmail(); user.IsEmailConfirmed(notificationEmail) {
+		// ---> Trigger message sending
+		s.sendEmailWithRetry(ctx, "ResetPassword", &messageAPI.SendEmailReq{
+			InstanceId:        tokenInfos.InstanceID,
+			To:                []string{notificationEmail},
+			MessageType:       constants.EMAIL_TYPE_PASSWORD_CHANGED,
+			PreferredLanguage: user.Account.PreferredLanguage,
+			UseLowPrio:        true,
+		})
+		// <---
+	}
+
+	// the used token must not be usable again (also covers invitation tokens)
+	if err := s.globalDBService.DeleteTempToken(req.Token); err != nil {
+		logger.Error.Printf("ResetPassword: %s", err.Error())
 	}
-	// ---
 
 	// remove all temptokens for password reset:
 	if err := s.globalDBService.DeleteAllTempTokenForUser(tokenInfos.InstanceID, tokenInfos.UserID, constants.TOKEN_PURPOSE_PASSWORD_RESET); err != nil {
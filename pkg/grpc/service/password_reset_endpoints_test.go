@@ -2,17 +2,21 @@ package service
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/influenzanet/go-utils/pkg/constants"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
 	"github.com/influenzanet/user-management-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/tokens"
 	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
 	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestInitiatePasswordResetEndpoint(t *testing.T) {
@@ -24,10 +28,10 @@ func TestInitiatePasswordResetEndpoint(t *testing.T) {
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
 		globalDBService: testGlobalDBService,
-		instanceIDs:     []string{testInstanceID},
 		Intervals: models.Intervals{
-			TokenExpiryInterval:      time.Second * 2,
-			VerificationCodeLifetime: 60,
+			TokenExpiryInterval:        time.Second * 2,
+			VerificationCodeLifetime:   60,
+			PasswordResetTokenLifetime: time.Hour * 2,
 		},
 		clients: &models.APIClients{
 			MessagingService: mockMessagingClient,
@@ -48,6 +52,12 @@ func TestInitiatePasswordResetEndpoint(t *testing.T) {
 					Email:       "test_for_pwreset_init@test.com",
 					ConfirmedAt: time.Now().Unix(),
 				},
+				{
+					ID:          primitive.NewObjectID(),
+					Type:        "email",
+					Email:       "test_for_pwreset_secondary@test.com",
+					ConfirmedAt: time.Now().Unix(),
+				},
 			},
 		},
 	})
@@ -72,34 +82,169 @@ func TestInitiatePasswordResetEndpoint(t *testing.T) {
 		}
 	})
 
+	var unknownAccountResp *api.ServiceStatus
 	t.Run("with wrong account id", func(t *testing.T) {
-		_, err := s.InitiatePasswordReset(context.Background(), &api.InitiateResetPasswordMsg{
+		start := time.Now()
+		resp, err := s.InitiatePasswordReset(context.Background(), &api.InitiateResetPasswordMsg{
 			InstanceId: testInstanceID,
 			AccountId:  "wrong@test.test",
 		})
 
 		if err != nil {
 			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if time.Since(start) < time.Duration(passwordResetMinResponseTime)*time.Second {
+			t.Error("response should not be faster than for existing accounts")
 		}
+		unknownAccountResp = resp
 	})
 
 	t.Run("with valid account id", func(t *testing.T) {
+		var sentEmail *messageAPI.SendEmailReq
 		mockMessagingClient.EXPECT().SendInstantEmail(
 			gomock.Any(),
 			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			sentEmail = req
+			return nil, nil
+		})
+
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
 		).Return(nil, nil)
 
+		resp, err := s.InitiatePasswordReset(context.Background(), &api.InitiateResetPasswordMsg{
+			InstanceId: testInstanceID,
+			AccountId:  testUsers[0].Account.AccountID,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if unknownAccountResp == nil || !proto.Equal(resp, unknownAccountResp) {
+			t.Errorf("responses for known and unknown account should be identical: %s - %s", resp, unknownAccountResp)
+		}
+
+		if sentEmail == nil || sentEmail.ContentInfos["validUntil"] != "2" {
+			t.Errorf("unexpected email content: %v", sentEmail)
+			return
+		}
+		tt, err := testGlobalDBService.GetTempToken(sentEmail.ContentInfos["token"])
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if tt.Expiration > tokens.GetExpirationTime(time.Hour*2) || tt.Expiration < tokens.GetExpirationTime(time.Hour*2-time.Minute) {
+			t.Errorf("unexpected token expiration: %d", tt.Expiration)
+		}
+	})
+
+	t.Run("with confirmed secondary email", func(t *testing.T) {
+		sentEmails := []*messageAPI.SendEmailReq{}
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			sentEmails = append(sentEmails, req)
+			return nil, nil
+		}).Times(2)
+
 		mockLoggingClient.EXPECT().SaveLogEvent(
 			gomock.Any(),
 			gomock.Any(),
 		).Return(nil, nil)
 
 		_, err := s.InitiatePasswordReset(context.Background(), &api.InitiateResetPasswordMsg{
+			InstanceId: testInstanceID,
+			AccountId:  "test_for_pwreset_secondary@test.com",
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(sentEmails) != 2 {
+			t.Errorf("unexpected emails: %v", sentEmails)
+			return
+		}
+		if len(sentEmails[0].To) != 1 || sentEmails[0].To[0] != "test_for_pwreset_secondary@test.com" || sentEmails[0].MessageType != constants.EMAIL_TYPE_PASSWORD_RESET {
+			t.Errorf("unexpected reset email: %v", sentEmails[0])
+		}
+		if len(sentEmails[1].To) != 1 || sentEmails[1].To[0] != testUsers[0].Account.AccountID || sentEmails[1].MessageType != models.EMAIL_TYPE_PASSWORD_RESET_REQUESTED {
+			t.Errorf("unexpected notice to the account holder: %v", sentEmails[1])
+		}
+	})
+}
+
+func TestInitiatePasswordResetThrottling(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		Intervals: models.Intervals{
+			PasswordResetTokenLifetime: time.Hour,
+			PasswordResetTriggerWindow: time.Hour,
+		},
+		passwordResetTriggerLimit: 2,
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
+		},
+	}
+
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: "test_for_pwreset_throttling@test.com",
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+
+	t.Run("until the limit is reached", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil).Times(2)
+
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil).Times(2)
+
+		for i := 0; i < 2; i++ {
+			_, err := s.InitiatePasswordReset(context.Background(), &api.InitiateResetPasswordMsg{
+				InstanceId: testInstanceID,
+				AccountId:  testUsers[0].Account.AccountID,
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+				return
+			}
+		}
+	})
+
+	t.Run("after the limit is reached", func(t *testing.T) {
+		// no messaging or logging calls expected
+		resp, err := s.InitiatePasswordReset(context.Background(), &api.InitiateResetPasswordMsg{
 			InstanceId: testInstanceID,
 			AccountId:  testUsers[0].Account.AccountID,
 		})
 		if err != nil {
 			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if resp.Status != api.ServiceStatus_NORMAL || resp.Msg != "email sending triggered" {
+			t.Errorf("unexpected response: %s", resp)
 		}
 	})
 }
@@ -328,10 +473,14 @@ func TestResetPasswordEndpoint(t *testing.T) {
 	})
 
 	t.Run("with valid arguments", func(t *testing.T) {
+		var sentEmail *messageAPI.SendEmailReq
 		mockMessagingClient.EXPECT().SendInstantEmail(
 			gomock.Any(),
 			gomock.Any(),
-		).Return(nil, nil)
+		).DoAndReturn(func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			sentEmail = req
+			return nil, errors.New("messaging service unavailable")
+		})
 
 		mockLoggingClient.EXPECT().SaveLogEvent(
 			gomock.Any(),
@@ -345,5 +494,23 @@ func TestResetPasswordEndpoint(t *testing.T) {
 			t.Errorf("unexpected error: %s", err.Error())
 			return
 		}
+		if sentEmail == nil || sentEmail.MessageType != constants.EMAIL_TYPE_PASSWORD_CHANGED {
+			t.Errorf("expected password changed notification, got: %v", sentEmail)
+			return
+		}
+		if len(sentEmail.To) != 1 || sentEmail.To[0] != testUsers[0].Account.AccountID {
+			t.Errorf("unexpected recipients: %v", sentEmail.To)
+		}
+	})
+
+	t.Run("with replayed token", func(t *testing.T) {
+		_, err := s.ResetPassword(context.Background(), &api.ResetPasswordMsg{
+			Token:       testTempToken.Token,
+			NewPassword: "other-tokmefn4n2p3rnp32mne",
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "wrong token")
+		if !ok {
+			t.Error(msg)
+		}
 	})
 }
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// tokenBucketLimiter keeps a token bucket per key, safe for concurrent requests. The buckets are kept in
+// memory, so with several replicas each one applies the limit on its own. At most rateLimiterMaxKeys buckets are
+// kept, see recentKeys.
+type tokenBucketLimiter struct {
+	limit   models.MethodRateLimit
+	mu      sync.Mutex
+	buckets *recentKeys // of *tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucketLimiter(limit models.MethodRateLimit) *tokenBucketLimiter {
+	return &tokenBucketLimiter{limit: limit, buckets: newRecentKeys(rateLimiterMaxKeys)}
+}
+
+// allow takes a token from the bucket of key at now, false if it is empty
+func (l *tokenBucketLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := float64(l.limit.Burst)
+	// buckets refilled completely are forgotten
+	v, ok := l.buckets.get(key, func(v interface{}) bool {
+		return now.Sub(v.(*tokenBucket).last) >= l.limit.Window
+	}, func() interface{} { return &tokenBucket{tokens: burst, last: now} })
+	b := v.(*tokenBucket)
+	if ok && l.limit.Window > 0 {
+		b.tokens += now.Sub(b.last).Seconds() * burst / l.limit.Window.Seconds()
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// NewRateLimitUnaryInterceptor returns an interceptor rejecting calls exceeding the limit of their method with
+// ResourceExhausted. Calls are counted per client IP (see clientIPFromContext), or per user ID of the access token validated by the auth
+// interceptor if conf.KeyBy is RATE_LIMIT_KEY_USER, so it has to run after that one. Calls without key are not limited.
+func NewRateLimitUnaryInterceptor(conf models.RateLimitConfig) grpc.UnaryServerInterceptor {
+	limiters := map[string]*tokenBucketLimiter{}
+	for method, limit := range conf.Methods {
+		limiters[method] = newTokenBucketLimiter(limit)
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		limiter, ok := limiters[info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]]
+		if !ok {
+			return handler(ctx, req)
+		}
+		key := rateLimitKey(ctx, conf)
+		if key != "" && !limiter.allow(key, time.Now()) {
+			return nil, errorWithCode(codes.ResourceExhausted, "too many requests, please try again later", models.ERROR_CODE_TOO_MANY_REQUESTS)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func rateLimitKey(ctx context.Context, conf models.RateLimitConfig) string {
+	if conf.KeyBy == models.RATE_LIMIT_KEY_USER {
+		if tokenInfos := TokenInfosFromContext(ctx); tokenInfos != nil && tokenInfos.Id != "" {
+			return "user:" + tokenInfos.InstanceId + ":" + tokenInfos.Id
+		}
+	}
+	if ip := clientIPFromContext(ctx, conf.TrustedProxies); ip != "" {
+		return "ip:" + ip
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/influenzanet/go-utils/pkg/api_types"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	l := newTokenBucketLimiter(models.MethodRateLimit{Burst: 2, Window: time.Minute})
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if !l.allow("10.0.0.1", now) {
+			t.Errorf("call %d should be allowed", i)
+		}
+	}
+	if l.allow("10.0.0.1", now) {
+		t.Error("third call should be rejected")
+	}
+	if !l.allow("10.0.0.2", now) {
+		t.Error("other key should be allowed")
+	}
+	// one token refilled after half of the window
+	if !l.allow("10.0.0.1", now.Add(30*time.Second)) {
+		t.Error("call should be allowed after refill")
+	}
+	if l.allow("10.0.0.1", now.Add(30*time.Second)) {
+		t.Error("call should be rejected before next refill")
+	}
+}
+
+func TestTokenBucketLimiterMaxKeys(t *testing.T) {
+	l := newTokenBucketLimiter(models.MethodRateLimit{Burst: 1, Window: time.Hour})
+	l.buckets.maxKeys = 3
+	now := time.Now()
+	for _, key := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if !l.allow(key, now) {
+			t.Errorf("first call of %s should be allowed", key)
+		}
+	}
+	// 10.0.0.2 is now the least recently used
+	if l.allow("10.0.0.1", now) {
+		t.Error("second call should be rejected")
+	}
+	for i := 4; i < 10; i++ {
+		l.allow(fmt.Sprintf("10.0.0.%d", i), now)
+		if len(l.buckets.entries) > 3 || l.buckets.lru.Len() > 3 {
+			t.Errorf("too many buckets: %d", len(l.buckets.entries))
+			return
+		}
+	}
+	if _, ok := l.buckets.entries["10.0.0.2"]; ok {
+		t.Error("least recently used bucket should be dropped")
+	}
+	if !l.allow("10.0.0.1", now) {
+		t.Error("dropped bucket should start full again")
+	}
+}
+
+func TestRateLimitUnaryInterceptor(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	loginInfo := &grpc.UnaryServerInfo{FullMethod: "/influenzanet.user_management_api.UserManagementApi/LoginWithEmail"}
+	signupInfo := &grpc.UnaryServerInfo{FullMethod: "/influenzanet.user_management_api.UserManagementApi/SignupWithEmail"}
+	ctxWithIP := contextFromPeer
+
+	t.Run("limited by client IP", func(t *testing.T) {
+		interceptor := NewRateLimitUnaryInterceptor(models.RateLimitConfig{
+			Methods: map[string]models.MethodRateLimit{"LoginWithEmail": {Burst: 3, Window: time.Hour}},
+			KeyBy:   models.RATE_LIMIT_KEY_IP,
+		})
+		for i := 0; i < 3; i++ {
+			if _, err := interceptor(ctxWithIP("10.0.0.1"), nil, loginInfo, handler); err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+				return
+			}
+		}
+		_, err := interceptor(ctxWithIP("10.0.0.1"), nil, loginInfo, handler)
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("unexpected error: %v", err)
+		}
+		ok, msg := shouldHaveErrorCode(err, models.ERROR_CODE_TOO_MANY_REQUESTS)
+		if !ok {
+			t.Error(msg)
+		}
+
+		if _, err := interceptor(ctxWithIP("10.0.0.2"), nil, loginInfo, handler); err != nil {
+			t.Errorf("other client should not be limited: %s", err.Error())
+		}
+		for i := 0; i < 10; i++ {
+			if _, err := interceptor(ctxWithIP("10.0.0.1"), nil, signupInfo, handler); err != nil {
+				t.Errorf("method without limit should not be limited: %s", err.Error())
+				return
+			}
+		}
+	})
+
+	t.Run("limited by client IP behind trusted proxy", func(t *testing.T) {
+		interceptor := NewRateLimitUnaryInterceptor(models.RateLimitConfig{
+			Methods:        map[string]models.MethodRateLimit{"LoginWithEmail": {Burst: 1, Window: time.Hour}},
+			KeyBy:          models.RATE_LIMIT_KEY_IP,
+			TrustedProxies: testTrustedProxies,
+		})
+		if _, err := interceptor(contextFromProxy("203.0.113.57"), nil, loginInfo, handler); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		// entries added by the client don't change the key
+		if _, err := interceptor(contextFromProxy("198.51.100.1, 203.0.113.57"), nil, loginInfo, handler); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if _, err := interceptor(contextFromProxy("203.0.113.58"), nil, loginInfo, handler); err != nil {
+			t.Errorf("other client should not be limited: %s", err.Error())
+		}
+	})
+
+	t.Run("forwarded IP of untrusted peer is ignored", func(t *testing.T) {
+		interceptor := NewRateLimitUnaryInterceptor(models.RateLimitConfig{
+			Methods:        map[string]models.MethodRateLimit{"LoginWithEmail": {Burst: 1, Window: time.Hour}},
+			KeyBy:          models.RATE_LIMIT_KEY_IP,
+			TrustedProxies: testTrustedProxies,
+		})
+		ctxWithForwardedIP := func(ip string) context.Context {
+			return metadata.NewIncomingContext(contextFromPeer("203.0.113.57"), metadata.Pairs("x-forwarded-for", ip))
+		}
+		if _, err := interceptor(ctxWithForwardedIP("198.51.100.1"), nil, loginInfo, handler); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, err := interceptor(ctxWithForwardedIP("198.51.100.2"), nil, loginInfo, handler); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("limited by user", func(t *testing.T) {
+		interceptor := NewRateLimitUnaryInterceptor(models.RateLimitConfig{
+			Methods: map[string]models.MethodRateLimit{"LoginWithEmail": {Burst: 1, Window: time.Hour}},
+			KeyBy:   models.RATE_LIMIT_KEY_USER,
+		})
+		ctxWithUser := func(userID string) context.Context {
+			return context.WithValue(ctxWithIP("10.0.0.1"), tokenInfosContextKey{}, &api_types.TokenInfos{Id: userID, InstanceId: testInstanceID})
+		}
+		if _, err := interceptor(ctxWithUser("user-1"), nil, loginInfo, handler); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, err := interceptor(ctxWithUser("user-1"), nil, loginInfo, handler); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// same IP, other user
+		if _, err := interceptor(ctxWithUser("user-2"), nil, loginInfo, handler); err != nil {
+			t.Errorf("other user should not be limited: %s", err.Error())
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/coneno/logger"
 	"github.com/influenzanet/user-management-service/pkg/api"
@@ -22,13 +23,50 @@ const (
 
 type userManagementServer struct {
 	api.UnimplementedUserManagementApiServer
-	clients           *models.APIClients
-	userDBservice     *userdb.UserDBService
-	globalDBService   *globaldb.GlobalDBService
-	Intervals         models.Intervals
-	newUserCountLimit int64
-	weekdayStrategy   utils.WeekDayStrategy
-	instanceIDs       []string
+	clients            *models.APIClients
+	userDBservice      *userdb.UserDBService
+	globalDBService    *globaldb.GlobalDBService
+	Intervals          models.Intervals
+	newUserCountLimit  int64
+	maxSessionsPerUser int64 // 0 means no limit
+	// maximum number of password reset emails per account within Intervals.PasswordResetTriggerWindow
+	passwordResetTriggerLimit int64
+	// maximum number of signups per client IP within Intervals.SignupPerIPWindow, 0 means no limit
+	signupPerIPLimit int64
+	signupLimiter    *ipRateLimiter
+	// proxies whose x-forwarded-for header is used to find the client IP, see utils.ClientIP
+	trustedProxies  []*net.IPNet
+	weekdayStrategy utils.WeekDayStrategy
+	// instances where removed accounts are anonymized instead of deleted
+	anonymizeDeletedAccounts map[string]bool
+	instanceConfigs          *instanceConfigCache
+	instanceIDs              *instanceIDCache
+	loginIPStorage           string // full, truncated or none, see utils.AnonymizeIP
+	loginHistory             models.LoginHistoryConfig
+	tempTokenCleanup         tempTokenCleanupThrottle
+	extraTempTokenPurposes   map[string]bool // accepted by the temp token endpoints in addition to knownTempTokenPurposes
+	disposableEmails         models.DisposableEmailConfig
+	embedProfilesInToken     bool // ids and aliases of the profiles in the access token payload
+	// instance whose service accounts may create and list all instances, empty disables these endpoints
+	managementInstanceID string
+}
+
+// ServerConfig holds the settings of the service endpoints, see config.Config for their meaning
+type ServerConfig struct {
+	Intervals                 models.Intervals
+	NewUserCountLimit         int64
+	MaxSessionsPerUser        int64
+	PasswordResetTriggerLimit int64
+	SignupPerIPLimit          int64
+	TrustedProxies            []*net.IPNet
+	WeekdayStrategy           utils.WeekDayStrategy
+	AnonymizeDeletedAccounts  map[string]bool
+	LoginIPStorage            string
+	LoginHistory              models.LoginHistoryConfig
+	ExtraTempTokenPurposes    map[string]bool
+	DisposableEmails          models.DisposableEmailConfig
+	EmbedProfilesInToken      bool
+	ManagementInstanceID      string
 }
 
 // NewUserManagementServer creates a new service instance
@@ -36,19 +74,29 @@ func NewUserManagementServer(
 	clients *models.APIClients,
 	userDBservice *userdb.UserDBService,
 	globalDBservice *globaldb.GlobalDBService,
-	intervals models.Intervals,
-	newUserCountLimit int64,
-	weekdayStrategy utils.WeekDayStrategy,
-	instanceIDs []string,
+	conf ServerConfig,
 ) api.UserManagementApiServer {
 	return &userManagementServer{
-		clients:           clients,
-		userDBservice:     userDBservice,
-		globalDBService:   globalDBservice,
-		Intervals:         intervals,
-		newUserCountLimit: newUserCountLimit,
-		weekdayStrategy:   weekdayStrategy,
-		instanceIDs:       instanceIDs,
+		clients:                   clients,
+		userDBservice:             userDBservice,
+		globalDBService:           globalDBservice,
+		Intervals:                 conf.Intervals,
+		newUserCountLimit:         conf.NewUserCountLimit,
+		maxSessionsPerUser:        conf.MaxSessionsPerUser,
+		passwordResetTriggerLimit: conf.PasswordResetTriggerLimit,
+		signupPerIPLimit:          conf.SignupPerIPLimit,
+		signupLimiter:             newIPRateLimiter(),
+		trustedProxies:            conf.TrustedProxies,
+		weekdayStrategy:           conf.WeekdayStrategy,
+		anonymizeDeletedAccounts:  conf.AnonymizeDeletedAccounts,
+		instanceConfigs:           newInstanceConfigCache(instanceConfigCacheTTL * time.Second),
+		instanceIDs:               newInstanceIDCache(instanceIDsRefreshInterval * time.Second),
+		loginIPStorage:            conf.LoginIPStorage,
+		loginHistory:              conf.LoginHistory,
+		extraTempTokenPurposes:    conf.ExtraTempTokenPurposes,
+		disposableEmails:          conf.DisposableEmails,
+		embedProfilesInToken:      conf.EmbedProfilesInToken,
+		managementInstanceID:      conf.ManagementInstanceID,
 	}
 }
 
@@ -57,10 +105,8 @@ func RunServer(ctx context.Context, port string,
 	clients *models.APIClients,
 	userDBservice *userdb.UserDBService,
 	globalDBservice *globaldb.GlobalDBService,
-	intervals models.Intervals,
-	newUserCountLimit int64,
-	weekdayStrategy utils.WeekDayStrategy,
-	instanceIDs []string,
+	conf ServerConfig,
+	serverOptions ...grpc.ServerOption,
 ) error {
 	lis, err := net.Listen("tcp", ":"+port)
 	if err != nil {
@@ -68,15 +114,12 @@ func RunServer(ctx context.Context, port string,
 	}
 
 	// register service
-	server := grpc.NewServer()
+	server := grpc.NewServer(serverOptions...)
 	api.RegisterUserManagementApiServer(server, NewUserManagementServer(
 		clients,
 		userDBservice,
 		globalDBservice,
-		intervals,
-		newUserCountLimit,
-		weekdayStrategy,
-		instanceIDs,
+		conf,
 	))
 
 	// graceful shutdown
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"testing"
@@ -13,6 +14,9 @@ import (
 	"github.com/influenzanet/user-management-service/pkg/dbs/userdb"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -31,6 +35,12 @@ var (
 func TestMain(m *testing.M) {
 	setupTestGlobalDBService()
 	setupTestUserDBService()
+	if err := testGlobalDBService.CreateInstance(models.Instance{InstanceID: testInstanceID}); err != nil {
+		logger.Error.Fatal(err)
+	}
+	if err := testGlobalDBService.CreateIndexForIdempotencyKeys(); err != nil {
+		logger.Error.Fatal(err)
+	}
 	result := m.Run()
 	dropTestDB()
 	os.Exit(result)
@@ -121,6 +131,20 @@ func dropTestDB() {
 	}
 }
 
+// testTrustedProxies contains the address of the proxy used by contextFromProxy
+var testTrustedProxies = []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}}
+
+// contextFromPeer returns the context of a request received directly from ip
+func contextFromPeer(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}})
+}
+
+// contextFromProxy returns the context of a request forwarded by a proxy of testTrustedProxies with the given
+// x-forwarded-for header
+func contextFromProxy(forwardedFor string) context.Context {
+	return metadata.NewIncomingContext(contextFromPeer("10.0.0.2"), metadata.Pairs("x-forwarded-for", forwardedFor))
+}
+
 func shouldHaveGrpcErrorStatus(err error, expectedError string) (bool, string) {
 	if err == nil {
 		return false, "should return an error"
@@ -136,9 +160,47 @@ func shouldHaveGrpcErrorStatus(err error, expectedError string) (bool, string) {
 	return true, ""
 }
 
+// shouldHaveFieldViolation checks that the error has a BadRequest detail with a violation of the field
+func shouldHaveFieldViolation(err error, field string) (bool, string) {
+	st, ok := status.FromError(err)
+	if err == nil || !ok || st == nil {
+		return false, "should return a status error"
+	}
+	for _, d := range st.Details() {
+		br, ok := d.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+		for _, v := range br.FieldViolations {
+			if v.Field == field {
+				return true, ""
+			}
+		}
+		return false, fmt.Sprintf("unexpected field violations: %v", br.FieldViolations)
+	}
+	return false, "missing bad request details"
+}
+
+// shouldHaveErrorCode checks that the error has an ErrorInfo detail with the stable error code
+func shouldHaveErrorCode(err error, errorCode string) (bool, string) {
+	st, ok := status.FromError(err)
+	if err == nil || !ok || st == nil {
+		return false, "should return a status error"
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			if info.Reason != errorCode || info.Domain != errorDomain {
+				return false, fmt.Sprintf("unexpected error info: %v", info)
+			}
+			return true, ""
+		}
+	}
+	return false, "missing error info"
+}
+
 func addTestUsers(userDefs []models.User) (users []models.User, err error) {
 	for _, uc := range userDefs {
-		ID, err := testUserDBService.AddUser(testInstanceID, uc)
+		ID, err := testUserDBService.AddUser(context.Background(), testInstanceID, uc)
 		if err != nil {
 			return users, err
 		}
@@ -1,13 +1,70 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"sync/atomic"
 	"time"
 
 	"github.com/coneno/logger"
 	"github.com/influenzanet/user-management-service/pkg/models"
 )
 
+// tempTokenCleanupThrottle lets the cleanup of expired temp tokens run at most once per interval, safe for concurrent requests
+type tempTokenCleanupThrottle struct {
+	lastRun int64 // unix time, accessed atomically
+}
+
+// shouldRun returns true if the last run is more than interval seconds before now, and then records now as last run
+func (t *tempTokenCleanupThrottle) shouldRun(now int64, interval int64) bool {
+	last := atomic.LoadInt64(&t.lastRun)
+	if last+interval >= now {
+		return false
+	}
+	return atomic.CompareAndSwapInt64(&t.lastRun, last, now)
+}
+
+// isTempTokenPurposeAllowed checks that temp tokens with this purpose can be created through the temp token endpoints
+func (s *userManagementServer) isTempTokenPurposeAllowed(purpose string) bool {
+	if purpose == models.TOKEN_PURPOSE_SIGNUP_INVITATION {
+		// accepted invitations create accounts with confirmed email, they are only issued by InviteUser
+		return false
+	}
+	for _, p := range knownTempTokenPurposes {
+		if p == purpose {
+			return true
+		}
+	}
+	return s.extraTempTokenPurposes[purpose]
+}
+
+// checkTempTokenInfo checks that the info map of a temp token stays within maxTempTokenInfoEntries and maxTempTokenInfoSize
+func checkTempTokenInfo(info map[string]string) bool {
+	if len(info) > maxTempTokenInfoEntries {
+		return false
+	}
+	size := 0
+	for k, v := range info {
+		size += len(k) + len(v)
+	}
+	return size <= maxTempTokenInfoSize
+}
+
+// cleanExpiredTemptokensIfDue starts the cleanup of expired temp tokens if it did not run recently
+func (s *userManagementServer) cleanExpiredTemptokensIfDue() {
+	interval := s.Intervals.TempTokenCleanupMinInterval
+	if interval <= 0 {
+		interval = defaultTempTokenCleanupMinInterval
+	}
+	retention := s.Intervals.ExpiredTempTokenRetention
+	if retention <= 0 {
+		retention = defaultExpiredTempTokenRetention
+	}
+	if s.tempTokenCleanup.shouldRun(time.Now().Unix(), int64(interval.Seconds())) {
+		go s.CleanExpiredTemptokens(int64(retention.Seconds()))
+	}
+}
+
 func (s *userManagementServer) CleanExpiredTemptokens(offset int64) {
 	err := s.globalDBService.DeleteTempTokensExpireBefore("", "", time.Now().Unix()-offset)
 	if err != nil {
@@ -43,3 +100,55 @@ func (s *userManagementServer) ValidateTempToken(token string, purposes []string
 	tt = &tokenInfos
 	return
 }
+
+// saveUserWithTempTokens creates the temp tokens and updates the user, as one logical change. Temp tokens and users
+// are in separate DBs: if both use the same client and the server supports it, a transaction covers both. Otherwise,
+// if a step fails, the tokens created so far are deleted again. Returns the created token strings in the order of tempTokens.
+func (s *userManagementServer) saveUserWithTempTokens(ctx context.Context, instanceID string, user models.User, tempTokens []models.TempToken) (models.User, []string, error) {
+	if s.globalDBService.DBClient == s.userDBservice.DBClient && s.userDBservice.SupportsTransactions(ctx) {
+		var updUser models.User
+		var createdTokens []string
+		err := s.userDBservice.WithTransaction(ctx, func(ctx context.Context) error {
+			createdTokens = []string{}
+			for _, tt := range tempTokens {
+				token, err := s.globalDBService.AddTempTokenWithContext(ctx, tt)
+				if err != nil {
+					return err
+				}
+				createdTokens = append(createdTokens, token)
+			}
+			var err error
+			updUser, err = s.userDBservice.UpdateUser(ctx, instanceID, user)
+			return err
+		})
+		if err != nil {
+			return user, nil, err
+		}
+		return updUser, createdTokens, nil
+	}
+
+	createdTokens := []string{}
+	rollback := func() {
+		for _, t := range createdTokens {
+			if err := s.globalDBService.DeleteTempToken(t); err != nil {
+				logger.Error.Printf("saveUserWithTempTokens: temp token could not be removed: %v", err)
+			}
+		}
+	}
+
+	for _, tt := range tempTokens {
+		token, err := s.globalDBService.AddTempToken(tt)
+		if err != nil {
+			rollback()
+			return user, nil, err
+		}
+		createdTokens = append(createdTokens, token)
+	}
+
+	updUser, err := s.userDBservice.UpdateUser(ctx, instanceID, user)
+	if err != nil {
+		rollback()
+		return user, nil, err
+	}
+	return updUser, createdTokens, nil
+}
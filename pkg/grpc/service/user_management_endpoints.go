@@ -2,7 +2,13 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/coneno/logger"
@@ -24,7 +30,7 @@ func (s *userManagementServer) CreateUser(ctx context.Context, req *api.CreateUs
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.AccountId == "" || req.InitialPassword == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing arguments")
 	}
-	if !utils.CheckRoleInToken(req.Token, constants.USER_ROLE_ADMIN) {
+	if !tokens.IsAdmin(req.Token.Payload) {
 		return nil, status.Error(codes.PermissionDenied, "permission denied")
 	}
 
@@ -32,7 +38,7 @@ func (s *userManagementServer) CreateUser(ctx context.Context, req *api.CreateUs
 	if !utils.CheckEmailFormat(req.AccountId) {
 		return nil, status.Error(codes.InvalidArgument, "account id not a valid email")
 	}
-	if !utils.CheckPasswordFormat(req.InitialPassword) {
+	if !s.checkPasswordPolicy(req.Token.InstanceId, req.InitialPassword) {
 		return nil, status.Error(codes.InvalidArgument, "password too weak")
 	}
 
@@ -87,13 +93,13 @@ func (s *userManagementServer) CreateUser(ctx context.Context, req *api.CreateUs
 	if req.Use_2Fa {
 		newUser.Account.AuthType = "2FA"
 	}
-	newUser.ContactPreferences.SubscribedToNewsletter = false
+	newUser.ContactPreferences.SetTopicSubscription(models.TOPIC_NEWSLETTER, false)
 	newUser.ContactPreferences.SendNewsletterTo = []string{newUser.ContactInfos[0].ID.Hex()}
 	newUser.ContactPreferences.SubscribedToWeekly = false
 	newUser.ContactPreferences.ReceiveWeeklyMessageDayOfWeek = int32(s.weekdayStrategy.Weekday())
 
 	instanceID := req.Token.InstanceId
-	id, err := s.userDBservice.AddUser(instanceID, newUser)
+	id, err := s.userDBservice.AddUser(ctx, instanceID, newUser)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -116,7 +122,7 @@ func (s *userManagementServer) CreateUser(ctx context.Context, req *api.CreateUs
 	}
 
 	// ---> Trigger message sending
-	_, err = s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+	err = s.sendInstantEmail(ctx, &messageAPI.SendEmailReq{
 		InstanceId:  instanceID,
 		To:          []string{newUser.Account.AccountID},
 		MessageType: constants.EMAIL_TYPE_INVITATION,
@@ -132,6 +138,7 @@ func (s *userManagementServer) CreateUser(ctx context.Context, req *api.CreateUs
 	// <---
 
 	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_ACCOUNT_CREATED, "by admin - "+newUser.ID.Hex()+" - "+newUser.Account.AccountID)
+	s.notifyWebhook(models.WEBHOOK_EVENT_ACCOUNT_CREATED, req.Token.InstanceId, newUser.ID.Hex())
 
 	return newUser.ToAPI(), nil
 }
@@ -140,23 +147,26 @@ func (s *userManagementServer) AddRoleForUser(ctx context.Context, req *api.Role
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.AccountId == "" || req.Role == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing arguments")
 	}
-	if !utils.CheckRoleInToken(req.Token, constants.USER_ROLE_ADMIN) {
+	if !tokens.IsAdmin(req.Token.Payload) {
 		return nil, status.Error(codes.PermissionDenied, "permission denied")
 	}
+	if !isKnownUserRole(req.Role) {
+		return nil, status.Error(codes.InvalidArgument, "unknown role")
+	}
 
-	user, err := s.userDBservice.GetUserByAccountID(req.Token.InstanceId, req.AccountId)
+	user, err := s.userDBservice.GetUserByAccountID(ctx, req.Token.InstanceId, req.AccountId)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	if err := user.AddRole(req.Role); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if user.HasRole(req.Role) {
+		return nil, status.Error(codes.InvalidArgument, "role already added")
 	}
-	user, err = s.userDBservice.UpdateUser(req.Token.InstanceId, user)
+	user, err = s.userDBservice.AddRoleToUser(ctx, req.Token.InstanceId, user.ID.Hex(), req.Role)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_ACCOUNT_ROLE_ADDED, user.Account.AccountID+"("+user.ID.Hex()+") + "+req.Role)
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_ACCOUNT_ROLE_ADDED, "by "+req.Token.Id+": "+user.Account.AccountID+"("+user.ID.Hex()+") + "+req.Role)
 
 	return user.ToAPI(), nil
 }
@@ -165,35 +175,79 @@ func (s *userManagementServer) RemoveRoleForUser(ctx context.Context, req *api.R
 	if req == nil || utils.IsTokenEmpty(req.Token) || req.AccountId == "" || req.Role == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing arguments")
 	}
-	if !utils.CheckRoleInToken(req.Token, constants.USER_ROLE_ADMIN) {
+	if !tokens.IsAdmin(req.Token.Payload) {
 		return nil, status.Error(codes.PermissionDenied, "permission denied")
 	}
-	user, err := s.userDBservice.GetUserByAccountID(req.Token.InstanceId, req.AccountId)
+	if !isKnownUserRole(req.Role) {
+		return nil, status.Error(codes.InvalidArgument, "unknown role")
+	}
+	user, err := s.userDBservice.GetUserByAccountID(ctx, req.Token.InstanceId, req.AccountId)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	if err := user.RemoveRole(req.Role); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if !user.HasRole(req.Role) {
+		return nil, status.Error(codes.InvalidArgument, "role not found")
+	}
+	if req.Role == constants.USER_ROLE_ADMIN {
+		user, err = s.userDBservice.RemoveRoleUnlessLastHolder(ctx, req.Token.InstanceId, user.ID.Hex(), req.Role)
+	} else {
+		user, err = s.userDBservice.RemoveRoleFromUser(ctx, req.Token.InstanceId, user.ID.Hex(), req.Role)
+	}
+	if err == userdb.ErrLastRoleHolder {
+		return nil, status.Error(codes.InvalidArgument, "cannot remove the last admin")
 	}
-	user, err = s.userDBservice.UpdateUser(req.Token.InstanceId, user)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, constants.LOG_EVENT_ACCOUNT_ROLE_REMOVED, user.Account.AccountID+"("+user.ID.Hex()+") - "+req.Role)
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_SECURITY, constants.LOG_EVENT_ACCOUNT_ROLE_REMOVED, "by "+req.Token.Id+": "+user.Account.AccountID+"("+user.ID.Hex()+") - "+req.Role)
 	return user.ToAPI(), nil
 }
 
+func isKnownUserRole(role string) bool {
+	for _, r := range knownUserRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *userManagementServer) FindNonParticipantUsers(ctx context.Context, req *api.FindNonParticipantUsersMsg) (*api.UserListMsg, error) {
 	if req == nil || utils.IsTokenEmpty(req.Token) {
 		return nil, status.Error(codes.InvalidArgument, "missing arguments")
 	}
-	if !utils.CheckRoleInToken(req.Token, constants.USER_ROLE_ADMIN) {
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	users, err := s.userDBservice.FindNonParticipantUsers(ctx, req.Token.InstanceId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := api.UserListMsg{
+		Users: make([]*api.User, len(users)),
+	}
+	for i, u := range users {
+		resp.Users[i] = u.ToAPI()
+	}
+	return &resp, nil
+}
+
+// FindUsersWithoutPolicyAcceptance returns the users who did not accept the current version of at least one of the
+// policies of the instance, for admins
+func (s *userManagementServer) FindUsersWithoutPolicyAcceptance(ctx context.Context, req *api.UserReference) (*api.UserListMsg, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
 		return nil, status.Error(codes.PermissionDenied, "permission denied")
 	}
 
-	users, err := s.userDBservice.FindNonParticipantUsers(req.Token.InstanceId)
+	policies := s.getInstanceConfig(req.Token.InstanceId).Policies
+	users, err := s.userDBservice.FindUsersWithoutAcceptedPolicies(ctx, req.Token.InstanceId, policies.CurrentVersions)
 	if err != nil {
+		logger.Error.Printf("FindUsersWithoutPolicyAcceptance: %s: %v", req.Token.InstanceId, err)
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	resp := api.UserListMsg{
@@ -202,6 +256,7 @@ func (s *userManagementServer) FindNonParticipantUsers(ctx context.Context, req
 	for i, u := range users {
 		resp.Users[i] = u.ToAPI()
 	}
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_USER_DATA_ACCESSED, fmt.Sprintf("FindUsersWithoutPolicyAcceptance: %d accounts", len(users)))
 	return &resp, nil
 }
 
@@ -245,3 +300,386 @@ func (s *userManagementServer) StreamUsers(req *api.StreamUsersMsg, stream api.U
 	}
 	return nil
 }
+
+// GetUserStats returns the number of users of the instance by state, for admins and researchers
+func (s *userManagementServer) GetUserStats(ctx context.Context, req *api.UserReference) (*userdb.UserStats, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.HasAnyRole(req.Token.Payload, constants.USER_ROLE_ADMIN, constants.USER_ROLE_RESEARCHER) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	stats, err := s.userDBservice.GetUserStats(ctx, req.Token.InstanceId, time.Now().Unix()-userStatsActiveWindow)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &stats, nil
+}
+
+// FindDuplicateAccounts returns groups of accounts whose normalized account IDs or confirmed email addresses
+// collide, for admins to review. Accounts are not changed.
+func (s *userManagementServer) FindDuplicateAccounts(ctx context.Context, req *api.UserReference) (*DuplicateAccountList, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	accounts := []models.DuplicateAccount{}
+	emails := [][]string{}
+	err := s.userDBservice.PerfomActionForUsers(ctx, req.Token.InstanceId, userdb.UserFilter{ReminderWeekDay: -1}, func(instanceID string, user models.User, args ...interface{}) error {
+		if user.Timestamps.AnonymizedAt > 0 {
+			return nil
+		}
+		accounts = append(accounts, models.DuplicateAccount{
+			UserID:             user.ID.Hex(),
+			AccountID:          user.Account.AccountID,
+			AccountConfirmedAt: user.Account.AccountConfirmedAt,
+			CreatedAt:          user.Timestamps.CreatedAt,
+			LastLogin:          user.Timestamps.LastLogin,
+		})
+		emails = append(emails, normalizedEmailsOfUser(user))
+		return nil
+	})
+	if err != nil {
+		logger.Error.Printf("FindDuplicateAccounts: %s: %v", req.Token.InstanceId, err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	groups := groupDuplicateAccounts(accounts, emails)
+	resp := &DuplicateAccountList{Groups: make([]*models.DuplicateAccountGroup, len(groups))}
+	for i := range groups {
+		resp.Groups[i] = &groups[i]
+	}
+	return resp, nil
+}
+
+func normalizedEmailsOfUser(user models.User) []string {
+	emails := []string{utils.NormalizeEmail(user.Account.AccountID)}
+	for _, c := range user.ContactInfos {
+		if c.Type == "email" && c.ConfirmedAt > 0 {
+			emails = append(emails, utils.NormalizeEmail(c.Email))
+		}
+	}
+	return emails
+}
+
+// groupDuplicateAccounts groups the accounts sharing at least one email, emails[i] being the addresses of accounts[i].
+// Only groups with more than one account are returned.
+func groupDuplicateAccounts(accounts []models.DuplicateAccount, emails [][]string) []models.DuplicateAccountGroup {
+	parent := make([]int, len(accounts))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	owners := map[string][]int{}
+	for i, userEmails := range emails {
+		for _, email := range userEmails {
+			if o := owners[email]; len(o) > 0 && o[len(o)-1] == i {
+				continue
+			}
+			owners[email] = append(owners[email], i)
+		}
+	}
+	for _, o := range owners {
+		for _, i := range o[1:] {
+			parent[find(i)] = find(o[0])
+		}
+	}
+
+	groupIndex := map[int]int{}
+	groups := []models.DuplicateAccountGroup{}
+	for i, account := range accounts {
+		root := find(i)
+		gi, ok := groupIndex[root]
+		if !ok {
+			gi = len(groups)
+			groupIndex[root] = gi
+			groups = append(groups, models.DuplicateAccountGroup{Emails: []string{}})
+		}
+		groups[gi].Accounts = append(groups[gi].Accounts, account)
+	}
+	for email, o := range owners {
+		if len(o) > 1 {
+			gi := groupIndex[find(o[0])]
+			groups[gi].Emails = append(groups[gi].Emails, email)
+		}
+	}
+
+	duplicates := []models.DuplicateAccountGroup{}
+	for _, group := range groups {
+		if len(group.Accounts) > 1 {
+			sort.Strings(group.Emails)
+			duplicates = append(duplicates, group)
+		}
+	}
+	return duplicates
+}
+
+// GetUserAuditLog returns the log events of a user from the logging service, for admins, e.g. the security
+// timeline for support. eventType NONE returns all types, start and end (unix seconds) of 0 leave the range
+// open. At most maxAuditLogEvents events are returned.
+func (s *userManagementServer) GetUserAuditLog(ctx context.Context, req *UserAuditLogReq) (*UserAuditLog, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if req.Start > 0 && req.End > 0 && req.Start > req.End {
+		return nil, status.Error(codes.InvalidArgument, "invalid time range")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	if s.clients == nil || s.clients.LoggingService == nil {
+		return nil, status.Error(codes.Unavailable, "logging service not available")
+	}
+
+	stream, err := s.clients.LoggingService.GetLogs(ctx, &loggingAPI.LogQuery{
+		Token:     req.Token,
+		UserId:    req.UserId,
+		EventType: req.EventType,
+		Start:     req.Start,
+		End:       req.End,
+	})
+	if err != nil {
+		logger.Error.Printf("GetUserAuditLog: %v", err)
+		return nil, status.Error(codes.Unavailable, "logging service not available")
+	}
+	events := []*loggingAPI.LogEvent{}
+	for len(events) < maxAuditLogEvents {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error.Printf("GetUserAuditLog: %v", err)
+			return nil, status.Error(codes.Unavailable, "logging service not available")
+		}
+		events = append(events, event)
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_USER_DATA_ACCESSED, "GetUserAuditLog: "+req.UserId)
+	return &UserAuditLog{Events: events}, nil
+}
+
+// ExportInactiveUsers returns the accounts without activity for inactiveFor seconds, selected like for the
+// inactivity notification that leads to their deletion, so admins can archive who will be removed. Accounts are not changed.
+func (s *userManagementServer) ExportInactiveUsers(ctx context.Context, req *ExportInactiveUsersReq) (*InactiveUserList, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.InactiveFor <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	users, err := s.userDBservice.FindInactiveUsers(ctx, req.Token.InstanceId, req.InactiveFor)
+	if err != nil {
+		logger.Error.Printf("ExportInactiveUsers: %s: %v", req.Token.InstanceId, err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	records := make([]*models.InactiveUserRecord, len(users))
+	for i, u := range users {
+		records[i] = &models.InactiveUserRecord{
+			UserID:        u.ID.Hex(),
+			AccountIDHash: hashAccountID(u.Account.AccountID),
+			LastLogin:     u.Timestamps.LastLogin,
+		}
+	}
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_USER_DATA_ACCESSED, fmt.Sprintf("ExportInactiveUsers: %d accounts", len(records)))
+	return &InactiveUserList{Users: records}, nil
+}
+
+func hashAccountID(accountID string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(accountID)))
+	return hex.EncodeToString(h[:])
+}
+
+// CountUsersNeverLoggedIn returns the number of users created before createdBefore who never logged in, for admins and researchers
+func (s *userManagementServer) CountUsersNeverLoggedIn(ctx context.Context, req *UsersNeverLoggedInReq) (*UserCount, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.CreatedBefore <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.HasAnyRole(req.Token.Payload, constants.USER_ROLE_ADMIN, constants.USER_ROLE_RESEARCHER) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	count, err := s.userDBservice.CountUsersNeverLoggedIn(ctx, req.Token.InstanceId, req.CreatedBefore)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &UserCount{Count: count}, nil
+}
+
+// StreamUsersNeverLoggedIn streams the users created before createdBefore who never logged in, for admins and researchers.
+// Researchers only get the user ID, account type and creation time, without account ID, profiles or contact data.
+func (s *userManagementServer) StreamUsersNeverLoggedIn(req *UsersNeverLoggedInReq, stream api.UserManagementApi_StreamUsersServer) error {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.CreatedBefore <= 0 || stream == nil {
+		return status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.HasAnyRole(req.Token.Payload, constants.USER_ROLE_ADMIN, constants.USER_ROLE_RESEARCHER) {
+		return status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	ctx := stream.Context()
+	isAdmin := tokens.IsAdmin(req.Token.Payload)
+	sendUser := func(instanceID string, user models.User, args ...interface{}) error {
+		if isAdmin {
+			return stream.Send(user.ToAPI())
+		}
+		return stream.Send(&api.User{
+			Id: user.ID.Hex(),
+			Account: &api.User_Account{
+				Type:               user.Account.Type,
+				AccountConfirmedAt: user.Account.AccountConfirmedAt,
+			},
+			Timestamps: &api.User_Timestamps{CreatedAt: user.Timestamps.CreatedAt},
+		})
+	}
+	filter := userdb.UserFilter{ReminderWeekDay: -1, NeverLoggedInCreatedBefore: req.CreatedBefore}
+	err := s.userDBservice.PerfomActionForUsers(ctx, req.Token.InstanceId, filter, sendUser)
+	if ctx.Err() != nil {
+		return status.Error(codes.Canceled, ctx.Err().Error())
+	}
+	if err != nil {
+		logger.Error.Printf("StreamUsersNeverLoggedIn: %s: %v", req.Token.InstanceId, err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// ExportUsers streams all users of the admin's instance, read with a cursor, until done or the stream context is cancelled.
+// Users are sent in the API format, without password or verification code.
+func (s *userManagementServer) ExportUsers(req *api.UserReference, stream api.UserManagementApi_StreamUsersServer) error {
+	if req == nil || utils.IsTokenEmpty(req.Token) || stream == nil {
+		return status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	ctx := stream.Context()
+	count := 0
+	sendUser := func(instanceID string, user models.User, args ...interface{}) error {
+		if err := stream.Send(user.ToAPI()); err != nil {
+			return err
+		}
+		count++
+		return nil
+	}
+
+	err := s.userDBservice.PerfomActionForUsers(ctx, req.Token.InstanceId, userdb.UserFilter{ReminderWeekDay: -1}, sendUser)
+	if ctx.Err() != nil {
+		logger.Info.Printf("ExportUsers: %s: cancelled after %d users", req.Token.InstanceId, count)
+		return status.Error(codes.Canceled, ctx.Err().Error())
+	}
+	if err != nil {
+		logger.Error.Printf("ExportUsers: %s: %v", req.Token.InstanceId, err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	logger.Info.Printf("ExportUsers: %s: %d users exported by %s", req.Token.InstanceId, count, req.Token.Id)
+	return nil
+}
+
+// DeleteInstance removes all user data of the instance: users collection, temp tokens, app token scopes, emails waiting
+// for a retry, released email addresses, idempotency keys and the instance document.
+// As a safeguard, confirmation has to repeat the instance ID of the admin token.
+func (s *userManagementServer) DeleteInstance(ctx context.Context, req *DeleteInstanceReq) (*api.ServiceStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	instanceID := req.Token.InstanceId
+	if req.Confirmation != instanceID {
+		return nil, status.Error(codes.InvalidArgument, "confirmation doesn't match the instance")
+	}
+
+	if err := s.userDBservice.DropInstanceDB(ctx, instanceID); err != nil {
+		logger.Error.Printf("DeleteInstance: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	count, err := s.globalDBService.DeleteAllTempTokensOfInstance(instanceID)
+	if err != nil {
+		logger.Error.Printf("DeleteInstance: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.globalDBService.RemoveInstanceFromAppTokens(instanceID); err != nil {
+		logger.Error.Printf("DeleteInstance: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	emailCount, err := s.globalDBService.DeleteEmailRetriesOfInstance(instanceID)
+	if err != nil {
+		logger.Error.Printf("DeleteInstance: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.globalDBService.DeleteReleasedEmailsOfInstance(instanceID); err != nil {
+		logger.Error.Printf("DeleteInstance: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.globalDBService.DeleteIdempotencyKeysOfInstance(instanceID); err != nil {
+		logger.Error.Printf("DeleteInstance: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.globalDBService.DeleteInstance(instanceID); err != nil {
+		logger.Error.Printf("DeleteInstance: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if s.instanceIDs != nil {
+		s.instanceIDs.set(instanceID, false)
+	}
+
+	logger.Info.Printf("instance %s deleted by %s, %d temp tokens and %d emails waiting for a retry removed", instanceID, req.Token.Id, count, emailCount)
+	s.SaveLogEvent(instanceID, req.Token.Id, loggingAPI.LogEventType_SECURITY, models.LOG_EVENT_INSTANCE_DELETED, "by "+req.Token.Id)
+	return &api.ServiceStatus{
+		Status: api.ServiceStatus_NORMAL,
+		Msg:    "instance deleted",
+	}, nil
+}
+
+// content info set on the emails sent by SendTestEmail, for the templates to show they are a test
+const testEmailContentInfo = "isTestEmail"
+
+// SendTestEmail sends an email of the given message type to the recipient, with sample content infos, for admins to
+// check a template before it is used for the users. No user is read or changed.
+func (s *userManagementServer) SendTestEmail(ctx context.Context, req *SendTestEmailReq) (*api.ServiceStatus, error) {
+	if req == nil || utils.IsTokenEmpty(req.Token) || req.To == "" || req.MessageType == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !tokens.IsAdmin(req.Token.Payload) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	req.To = utils.SanitizeEmail(req.To)
+	if !utils.CheckEmailFormat(req.To) {
+		return nil, status.Error(codes.InvalidArgument, "email not valid")
+	}
+
+	infos := map[string]string{}
+	for k, v := range req.ContentInfos {
+		infos[k] = v
+	}
+	infos[testEmailContentInfo] = "true"
+
+	err := s.sendInstantEmail(ctx, &messageAPI.SendEmailReq{
+		InstanceId:        req.Token.InstanceId,
+		To:                []string{req.To},
+		MessageType:       req.MessageType,
+		ContentInfos:      infos,
+		PreferredLanguage: req.PreferredLanguage,
+	})
+	if err != nil {
+		logger.Error.Printf("SendTestEmail: %s: %v", req.Token.InstanceId, err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	s.SaveLogEvent(req.Token.InstanceId, req.Token.Id, loggingAPI.LogEventType_LOG, models.LOG_EVENT_TEST_EMAIL_SENT, req.MessageType+" to "+req.To)
+	return &api.ServiceStatus{
+		Status: api.ServiceStatus_NORMAL,
+		Msg:    "test email sent",
+	}, nil
+}
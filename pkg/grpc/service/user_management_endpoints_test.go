@@ -2,16 +2,25 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
 	"github.com/influenzanet/user-management-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
 	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestCreateUserEndpoint(t *testing.T) {
@@ -243,6 +252,25 @@ func TestAddRoleForUserEndpoint(t *testing.T) {
 		}
 	})
 
+	t.Run("with unknown role", func(t *testing.T) {
+		req := &api.RoleMsg{
+			Token: &api_types.TokenInfos{
+				Id:         "testuserid",
+				InstanceId: testInstanceID,
+				Payload: map[string]string{
+					"roles": "PARTICIPANT,ADMIN",
+				},
+			},
+			AccountId: testUsers[0].Account.AccountID,
+			Role:      "SUPERUSER",
+		}
+		_, err := s.AddRoleForUser(context.Background(), req)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "unknown role")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
 }
 
 func TestRemoveRoleForUserEndpoint(t *testing.T) {
@@ -360,6 +388,539 @@ func TestRemoveRoleForUserEndpoint(t *testing.T) {
 	})
 }
 
+func TestRemoveLastAdminRole(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+
+	// separate instance, so that admins created by other tests are not counted
+	instanceID := testInstanceID + "_roles"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testUserDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	accountIDs := []string{"test_for_last_admin_1@test.com", "test_for_last_admin_2@test.com"}
+	for _, accountID := range accountIDs {
+		_, err := testUserDBService.AddUser(context.Background(), instanceID, models.User{
+			Account: models.Account{
+				Type:      "email",
+				AccountID: accountID,
+			},
+			Roles: []string{"PARTICIPANT", "ADMIN"},
+		})
+		if err != nil {
+			t.Errorf("failed to create testusers: %s", err.Error())
+			return
+		}
+	}
+
+	adminToken := &api_types.TokenInfos{
+		Id:         "testadminid",
+		InstanceId: instanceID,
+		Payload: map[string]string{
+			"roles": "PARTICIPANT,ADMIN",
+		},
+	}
+
+	t.Run("remove admin role while another admin exists", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		resp, err := s.RemoveRoleForUser(context.Background(), &api.RoleMsg{
+			Token:     adminToken,
+			AccountId: accountIDs[0],
+			Role:      "ADMIN",
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(resp.Roles) != 1 || resp.Roles[0] != "PARTICIPANT" {
+			t.Errorf("unexpected response: %s", resp)
+		}
+	})
+
+	t.Run("remove role of the last admin", func(t *testing.T) {
+		_, err := s.RemoveRoleForUser(context.Background(), &api.RoleMsg{
+			Token:     adminToken,
+			AccountId: accountIDs[1],
+			Role:      "ADMIN",
+		})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "cannot remove the last admin")
+		if !ok {
+			t.Error(msg)
+		}
+		user, err := testUserDBService.GetUserByAccountID(context.Background(), instanceID, accountIDs[1])
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if !user.HasRole("ADMIN") {
+			t.Error("admin role should not be removed")
+		}
+	})
+}
+
+func TestGetUserStatsEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	instanceID := testInstanceID + "_user_stats"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testUserDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	for _, u := range []models.User{
+		{Account: models.Account{AccountID: "test_stats_1@test.com", AccountConfirmedAt: time.Now().Unix()}, Timestamps: models.Timestamps{LastLogin: time.Now().Unix()}},
+		{Account: models.Account{AccountID: "test_stats_2@test.com"}},
+	} {
+		if _, err := testUserDBService.AddUser(context.Background(), instanceID, u); err != nil {
+			t.Errorf("failed to create testusers: %s", err.Error())
+			return
+		}
+	}
+
+	t.Run("as participant", func(t *testing.T) {
+		_, err := s.GetUserStats(context.Background(), &api.UserReference{Token: &api_types.TokenInfos{
+			Id:         "testuserid",
+			InstanceId: instanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT"},
+		}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as researcher", func(t *testing.T) {
+		stats, err := s.GetUserStats(context.Background(), &api.UserReference{Token: &api_types.TokenInfos{
+			Id:         "testresearcherid",
+			InstanceId: instanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT,RESEARCHER"},
+		}})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if stats.Total != 2 || stats.Confirmed != 1 || stats.Unconfirmed != 1 || stats.Active != 1 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+	})
+}
+
+func TestFindDuplicateAccountsEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	instanceID := testInstanceID + "_duplicates"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testUserDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	newUser := func(accountID string, contact string, confirmed bool) models.User {
+		u := models.User{Account: models.Account{Type: "email", AccountID: accountID}}
+		u.AddNewEmail(accountID, true)
+		if contact != "" {
+			u.AddNewEmail(contact, confirmed)
+		}
+		return u
+	}
+	anonymized := newUser("anonymized@test.com", "", false)
+	anonymized.Timestamps.AnonymizedAt = time.Now().Unix()
+	for _, u := range []models.User{
+		newUser("Test.User@gmail.com", "", false),
+		newUser("testuser+flu@googlemail.com", "", false),
+		newUser("alice@test.com", "alice.work@test.com", true),
+		newUser("alice.work@test.com", "", false),
+		newUser("bob@test.com", "carol@test.com", false),
+		newUser("carol@test.com", "", false),
+		newUser("Anonymized@test.com", "", false),
+		anonymized,
+	} {
+		if _, err := testUserDBService.AddUser(context.Background(), instanceID, u); err != nil {
+			t.Errorf("failed to create testusers: %s", err.Error())
+			return
+		}
+	}
+
+	t.Run("as participant", func(t *testing.T) {
+		_, err := s.FindDuplicateAccounts(context.Background(), &api.UserReference{Token: &api_types.TokenInfos{Id: "testuserid", InstanceId: instanceID}})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as admin", func(t *testing.T) {
+		groups, err := s.FindDuplicateAccounts(context.Background(), &api.UserReference{Token: &api_types.TokenInfos{
+			Id:         "testadminid",
+			InstanceId: instanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT,ADMIN"},
+		}})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(groups.Groups) != 2 {
+			t.Errorf("unexpected groups: %v", groups)
+			return
+		}
+		for i, expected := range []struct {
+			email    string
+			accounts []string
+		}{
+			{email: "testuser@gmail.com", accounts: []string{"Test.User@gmail.com", "testuser+flu@googlemail.com"}},
+			{email: "alice.work@test.com", accounts: []string{"alice@test.com", "alice.work@test.com"}},
+		} {
+			g := groups.Groups[i]
+			if len(g.Emails) != 1 || g.Emails[0] != expected.email || len(g.Accounts) != 2 ||
+				g.Accounts[0].AccountID != expected.accounts[0] || g.Accounts[1].AccountID != expected.accounts[1] {
+				t.Errorf("unexpected group %d: %v", i, g)
+			}
+		}
+	})
+}
+
+func TestUsersNeverLoggedInEndpoints(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	instanceID := testInstanceID + "_never_logged_in"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testUserDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	now := time.Now().Unix()
+	for _, u := range []models.User{
+		{Account: models.Account{AccountID: "test_never_logged_in@test.com"}, Timestamps: models.Timestamps{CreatedAt: now - 1000}},
+		{Account: models.Account{AccountID: "test_logged_in@test.com"}, Timestamps: models.Timestamps{CreatedAt: now - 1000, LastLogin: now - 10}},
+	} {
+		if _, err := testUserDBService.AddUser(context.Background(), instanceID, u); err != nil {
+			t.Errorf("failed to create testusers: %s", err.Error())
+			return
+		}
+	}
+	token := &api_types.TokenInfos{
+		Id:         "testresearcherid",
+		InstanceId: instanceID,
+		Payload:    map[string]string{"roles": "PARTICIPANT,RESEARCHER"},
+	}
+
+	t.Run("count as participant", func(t *testing.T) {
+		_, err := s.CountUsersNeverLoggedIn(context.Background(), &UsersNeverLoggedInReq{Token: &api_types.TokenInfos{Id: "testuserid", InstanceId: instanceID}, CreatedBefore: now})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("count", func(t *testing.T) {
+		count, err := s.CountUsersNeverLoggedIn(context.Background(), &UsersNeverLoggedInReq{Token: token, CreatedBefore: now})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if count.Count != 1 {
+			t.Errorf("unexpected count: %d", count.Count)
+		}
+	})
+
+	t.Run("stream as researcher", func(t *testing.T) {
+		stream := &exportUsersStream{ctx: context.Background()}
+		if err := s.StreamUsersNeverLoggedIn(&UsersNeverLoggedInReq{Token: token, CreatedBefore: now}, stream); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(stream.Results) != 1 {
+			t.Errorf("unexpected users: %v", stream.Results)
+			return
+		}
+		u := stream.Results[0]
+		if u.Id == "" || u.Timestamps.CreatedAt != now-1000 || u.Account.AccountId != "" || len(u.Profiles) > 0 || len(u.ContactInfos) > 0 {
+			t.Errorf("unexpected user record: %v", u)
+		}
+	})
+
+	t.Run("stream as admin", func(t *testing.T) {
+		stream := &exportUsersStream{ctx: context.Background()}
+		adminToken := &api_types.TokenInfos{
+			Id:         "testadminid",
+			InstanceId: instanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT,ADMIN"},
+		}
+		if err := s.StreamUsersNeverLoggedIn(&UsersNeverLoggedInReq{Token: adminToken, CreatedBefore: now}, stream); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(stream.Results) != 1 || stream.Results[0].Account.AccountId != "test_never_logged_in@test.com" {
+			t.Errorf("unexpected users: %v", stream.Results)
+		}
+	})
+}
+
+// exportUsersStream collects the sent users and cancels its context after cancelAfter users, if set
+type exportUsersStream struct {
+	grpc.ServerStream
+	ctx         context.Context
+	cancel      context.CancelFunc
+	cancelAfter int
+	Results     []*api.User
+}
+
+func (m *exportUsersStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *exportUsersStream) Send(user *api.User) error {
+	m.Results = append(m.Results, user)
+	if m.cancelAfter > 0 && len(m.Results) >= m.cancelAfter {
+		m.cancel()
+	}
+	return nil
+}
+
+func TestExportUsersEndpoint(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	instanceID := testInstanceID + "_export"
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := testUserDBService.DBClient.Database(testDBNamePrefix + instanceID + "_users").Drop(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	userCount := 300
+	for i := 0; i < userCount; i++ {
+		_, err := testUserDBService.AddUser(context.Background(), instanceID, models.User{
+			Account: models.Account{
+				Type:             "email",
+				AccountID:        fmt.Sprintf("test_export_%d@test.com", i),
+				Password:         "secret-password-hash",
+				VerificationCode: models.VerificationCode{Code: "secret-code"},
+			},
+		})
+		if err != nil {
+			t.Errorf("failed to create testusers: %s", err.Error())
+			return
+		}
+	}
+
+	adminToken := &api_types.TokenInfos{
+		Id:         "testadminid",
+		InstanceId: instanceID,
+		Payload: map[string]string{
+			"roles": "PARTICIPANT,ADMIN",
+		},
+	}
+
+	t.Run("without admin role", func(t *testing.T) {
+		stream := &exportUsersStream{ctx: context.Background()}
+		err := s.ExportUsers(&api.UserReference{Token: &api_types.TokenInfos{Id: "testuserid", InstanceId: instanceID}}, stream)
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("export all users", func(t *testing.T) {
+		stream := &exportUsersStream{ctx: context.Background()}
+		if err := s.ExportUsers(&api.UserReference{Token: adminToken}, stream); err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(stream.Results) != userCount {
+			t.Errorf("unexpected number of users: %d", len(stream.Results))
+		}
+		for _, u := range stream.Results {
+			if strings.Contains(u.String(), "secret-") {
+				t.Errorf("user should not contain secrets: %s", u)
+				return
+			}
+		}
+	})
+
+	t.Run("stop when cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stream := &exportUsersStream{ctx: ctx, cancel: cancel, cancelAfter: 50}
+		err := s.ExportUsers(&api.UserReference{Token: adminToken}, stream)
+		if status.Code(err) != codes.Canceled {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if len(stream.Results) != 50 {
+			t.Errorf("unexpected number of users: %d", len(stream.Results))
+		}
+	})
+}
+
+func TestDeleteInstanceEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+
+	instanceID := testInstanceID + "_to_delete"
+	if err := testGlobalDBService.SaveInstanceConfig(instanceID, models.InstanceConfig{VerificationCodeLifetime: 300}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	userIDs := []string{}
+	for _, accountID := range []string{"test_delete_instance_1@test.com", "test_delete_instance_2@test.com"} {
+		id, err := testUserDBService.AddUser(context.Background(), instanceID, models.User{
+			Account: models.Account{Type: "email", AccountID: accountID},
+		})
+		if err != nil {
+			t.Errorf("failed to create testusers: %s", err.Error())
+			return
+		}
+		userIDs = append(userIDs, id)
+	}
+	tempTokens := []string{}
+	for _, userID := range userIDs {
+		tt, err := testGlobalDBService.AddTempToken(models.TempToken{
+			UserID:     userID,
+			InstanceID: instanceID,
+			Purpose:    "test_purpose",
+			Expiration: time.Now().Unix() + 3600,
+		})
+		if err != nil {
+			t.Errorf("failed to create temp token: %s", err.Error())
+			return
+		}
+		tempTokens = append(tempTokens, tt)
+	}
+	if err := testGlobalDBService.AddReleasedEmail(instanceID, "test_delete_instance_released@test.com", time.Hour); err != nil {
+		t.Errorf("failed to record released email: %s", err.Error())
+		return
+	}
+	if _, err := testGlobalDBService.ReserveIdempotencyKey(instanceID, "test_delete_instance_key", "hash", time.Hour); err != nil {
+		t.Errorf("failed to reserve idempotency key: %s", err.Error())
+		return
+	}
+	for _, instances := range [][]string{{instanceID}, {instanceID, instanceID + "_other"}} {
+		if _, err := testGlobalDBService.CreateAppToken(models.AppToken{AppName: "test_delete_instance", Instances: instances}); err != nil {
+			t.Errorf("failed to create app token: %s", err.Error())
+			return
+		}
+	}
+
+	adminToken := &api_types.TokenInfos{
+		Id:         userIDs[0],
+		InstanceId: instanceID,
+		Payload: map[string]string{
+			"roles": "PARTICIPANT,ADMIN",
+		},
+	}
+
+	t.Run("without admin role", func(t *testing.T) {
+		_, err := s.DeleteInstance(context.Background(), &DeleteInstanceReq{Token: &api_types.TokenInfos{Id: userIDs[0], InstanceId: instanceID}, Confirmation: instanceID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with wrong confirmation", func(t *testing.T) {
+		_, err := s.DeleteInstance(context.Background(), &DeleteInstanceReq{Token: adminToken, Confirmation: testInstanceID})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "confirmation doesn't match the instance")
+		if !ok {
+			t.Error(msg)
+		}
+		if _, err := testUserDBService.GetUserByID(context.Background(), instanceID, userIDs[0]); err != nil {
+			t.Errorf("users should be kept: %v", err)
+		}
+	})
+
+	t.Run("delete instance", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		_, err := s.DeleteInstance(context.Background(), &DeleteInstanceReq{Token: adminToken, Confirmation: instanceID})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+
+		for _, userID := range userIDs {
+			if _, err := testUserDBService.GetUserByID(context.Background(), instanceID, userID); err == nil {
+				t.Errorf("user %s should be removed", userID)
+			}
+		}
+		for _, tt := range tempTokens {
+			if _, err := testGlobalDBService.GetTempToken(tt); err == nil {
+				t.Errorf("temp token %s should be removed", tt)
+			}
+		}
+		conf, err := testGlobalDBService.GetInstanceConfig(instanceID)
+		if err != nil || conf.VerificationCodeLifetime != 0 {
+			t.Errorf("instance should be removed: %v, %v", conf, err)
+		}
+		appTokens, err := testGlobalDBService.FindAppTokensForInstance(instanceID)
+		if err != nil || len(appTokens) != 0 {
+			t.Errorf("app tokens should not be scoped to the instance: %v, %v", appTokens, err)
+		}
+		appTokens, err = testGlobalDBService.FindAppTokensForInstance(instanceID + "_other")
+		if err != nil || len(appTokens) != 1 {
+			t.Errorf("app token of another instance should be kept: %v, %v", appTokens, err)
+		}
+		released, err := testGlobalDBService.IsEmailReleasedRecently(instanceID, "test_delete_instance_released@test.com")
+		if err != nil || released {
+			t.Errorf("released email should be removed: %v, %v", released, err)
+		}
+		existing, err := testGlobalDBService.ReserveIdempotencyKey(instanceID, "test_delete_instance_key", "hash", time.Hour)
+		if err != nil || existing != nil {
+			t.Errorf("idempotency key should be removed: %v, %v", existing, err)
+		}
+		if err := testGlobalDBService.DeleteIdempotencyKey(instanceID, "test_delete_instance_key"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
 func TestFindNonParticipantUsersEndpoint(t *testing.T) {
 	s := userManagementServer{
 		userDBservice:   testUserDBService,
@@ -546,3 +1107,297 @@ func TestStreamUsersEndpoint(t *testing.T) {
 		}
 	})
 }
+
+// fakeLogStream returns the events, then io.EOF
+type fakeLogStream struct {
+	grpc.ClientStream
+	events []*loggingAPI.LogEvent
+}
+
+func (f *fakeLogStream) Recv() (*loggingAPI.LogEvent, error) {
+	if len(f.events) == 0 {
+		return nil, io.EOF
+	}
+	event := f.events[0]
+	f.events = f.events[1:]
+	return event, nil
+}
+
+func TestGetUserAuditLogEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+
+	adminToken := &api_types.TokenInfos{
+		Id:         "test-admin-id",
+		InstanceId: testInstanceID,
+		Payload:    map[string]string{"roles": "PARTICIPANT,ADMIN"},
+	}
+
+	t.Run("with missing arguments", func(t *testing.T) {
+		_, err := s.GetUserAuditLog(context.Background(), &UserAuditLogReq{Token: adminToken, EventType: loggingAPI.LogEventType_NONE})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing arguments")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with invalid time range", func(t *testing.T) {
+		_, err := s.GetUserAuditLog(context.Background(), &UserAuditLogReq{Token: adminToken, UserId: "test-user-id", EventType: loggingAPI.LogEventType_NONE, Start: 200, End: 100})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "invalid time range")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as participant", func(t *testing.T) {
+		_, err := s.GetUserAuditLog(context.Background(), &UserAuditLogReq{Token: &api_types.TokenInfos{
+			Id:         "test-user-id",
+			InstanceId: testInstanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT"},
+		}, UserId: "test-user-id", EventType: loggingAPI.LogEventType_NONE})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as admin", func(t *testing.T) {
+		var query *loggingAPI.LogQuery
+		mockLoggingClient.EXPECT().GetLogs(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *loggingAPI.LogQuery, opts ...grpc.CallOption) (loggingAPI.LoggingServiceApi_GetLogsClient, error) {
+			query = req
+			return &fakeLogStream{events: []*loggingAPI.LogEvent{
+				{Id: "1", Time: 150, EventType: loggingAPI.LogEventType_SECURITY, EventName: "LOGIN SUCCESS", UserId: "test-user-id"},
+				{Id: "2", Time: 180, EventType: loggingAPI.LogEventType_SECURITY, EventName: "PASSWORD CHANGED", UserId: "test-user-id"},
+			}}, nil
+		})
+		var logEvent *loggingAPI.NewLogEvent
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *loggingAPI.NewLogEvent, opts ...grpc.CallOption) (*api_types.ServiceStatus, error) {
+			logEvent = req
+			return nil, nil
+		})
+
+		events, err := s.GetUserAuditLog(context.Background(), &UserAuditLogReq{Token: adminToken, UserId: "test-user-id", EventType: loggingAPI.LogEventType_SECURITY, Start: 100, End: 200})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(events.Events) != 2 || events.Events[0].EventName != "LOGIN SUCCESS" || events.Events[1].EventName != "PASSWORD CHANGED" {
+			t.Errorf("unexpected events: %v", events)
+		}
+		if query == nil || query.UserId != "test-user-id" || query.EventType != loggingAPI.LogEventType_SECURITY || query.Start != 100 || query.End != 200 {
+			t.Errorf("unexpected query: %v", query)
+		}
+		if logEvent == nil || logEvent.UserId != adminToken.Id || logEvent.EventName != models.LOG_EVENT_USER_DATA_ACCESSED || logEvent.Msg != "GetUserAuditLog: test-user-id" {
+			t.Errorf("unexpected log event: %v", logEvent)
+		}
+	})
+}
+
+func TestExportInactiveUsersEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			LoggingService: mockLoggingClient,
+		},
+	}
+
+	inactiveFor := int64(3600)
+	longAgo := time.Now().Unix() - 2*inactiveFor
+	testUsers, err := addTestUsers([]models.User{
+		{
+			Account:    models.Account{Type: "email", AccountID: "Export_Inactive_1@test.com"},
+			Roles:      []string{"PARTICIPANT"},
+			Timestamps: models.Timestamps{CreatedAt: longAgo, LastLogin: longAgo, LastTokenRefresh: longAgo},
+		},
+		{
+			Account:    models.Account{Type: "email", AccountID: "export_inactive_2@test.com"},
+			Roles:      []string{"PARTICIPANT"},
+			Timestamps: models.Timestamps{CreatedAt: longAgo, LastLogin: longAgo, LastTokenRefresh: time.Now().Unix()},
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create testusers: %s", err.Error())
+		return
+	}
+
+	adminToken := &api_types.TokenInfos{
+		Id:         "test-admin-id",
+		InstanceId: testInstanceID,
+		Payload:    map[string]string{"roles": "PARTICIPANT,ADMIN"},
+	}
+
+	t.Run("with missing arguments", func(t *testing.T) {
+		_, err := s.ExportInactiveUsers(context.Background(), &ExportInactiveUsersReq{Token: adminToken})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing arguments")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as participant", func(t *testing.T) {
+		_, err := s.ExportInactiveUsers(context.Background(), &ExportInactiveUsersReq{Token: &api_types.TokenInfos{
+			Id:         testUsers[0].ID.Hex(),
+			InstanceId: testInstanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT"},
+		}, InactiveFor: inactiveFor})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as admin", func(t *testing.T) {
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		records, err := s.ExportInactiveUsers(context.Background(), &ExportInactiveUsersReq{Token: adminToken, InactiveFor: inactiveFor})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		expected, err := testUserDBService.FindInactiveUsers(context.Background(), testInstanceID, inactiveFor)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if len(records.Users) != len(expected) {
+			t.Errorf("manifest has %d accounts, the filter selects %d", len(records.Users), len(expected))
+			return
+		}
+		for i, u := range expected {
+			h := sha256.Sum256([]byte(strings.ToLower(u.Account.AccountID)))
+			if records.Users[i].UserID != u.ID.Hex() || records.Users[i].AccountIDHash != hex.EncodeToString(h[:]) || records.Users[i].LastLogin != u.Timestamps.LastLogin {
+				t.Errorf("unexpected record: %v for user %s", records.Users[i], u.ID.Hex())
+			}
+		}
+
+		found := false
+		for _, r := range records.Users {
+			if r.UserID == testUsers[1].ID.Hex() {
+				t.Error("active user should not be exported")
+			}
+			if r.UserID == testUsers[0].ID.Hex() {
+				found = true
+				if strings.Contains(r.AccountIDHash, "@") {
+					t.Errorf("account ID should be hashed: %s", r.AccountIDHash)
+				}
+			}
+		}
+		if !found {
+			t.Error("inactive user should be exported")
+		}
+	})
+}
+
+func TestSendTestEmailEndpoint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+		clients: &models.APIClients{
+			MessagingService: mockMessagingClient,
+			LoggingService:   mockLoggingClient,
+		},
+	}
+
+	adminToken := &api_types.TokenInfos{
+		Id:         "test-admin-id",
+		InstanceId: testInstanceID,
+		Payload:    map[string]string{"roles": "PARTICIPANT,ADMIN"},
+	}
+
+	t.Run("with missing arguments", func(t *testing.T) {
+		_, err := s.SendTestEmail(context.Background(), &SendTestEmailReq{Token: adminToken, MessageType: "registration"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "missing arguments")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("as participant", func(t *testing.T) {
+		_, err := s.SendTestEmail(context.Background(), &SendTestEmailReq{Token: &api_types.TokenInfos{
+			Id:         "test-participant-id",
+			InstanceId: testInstanceID,
+			Payload:    map[string]string{"roles": "PARTICIPANT"},
+		}, To: "test-email@test.com", MessageType: "registration"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "permission denied")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with invalid recipient", func(t *testing.T) {
+		_, err := s.SendTestEmail(context.Background(), &SendTestEmailReq{Token: adminToken, To: "not-an-email", MessageType: "registration"})
+		ok, msg := shouldHaveGrpcErrorStatus(err, "email not valid")
+		if !ok {
+			t.Error(msg)
+		}
+	})
+
+	t.Run("with messaging service error", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, fmt.Errorf("unknown message type"))
+
+		_, err := s.SendTestEmail(context.Background(), &SendTestEmailReq{Token: adminToken, To: "test-email@test.com", MessageType: "unknown"})
+		if status.Code(err) != codes.Internal {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("as admin", func(t *testing.T) {
+		mockMessagingClient.EXPECT().SendInstantEmail(
+			gomock.Any(),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			if req.InstanceId != testInstanceID || req.MessageType != "password-changed" || len(req.To) != 1 || req.To[0] != "test-email@test.com" {
+				t.Errorf("unexpected request: %v", req)
+			}
+			if req.ContentInfos["studyName"] != "Sample study" || req.ContentInfos[testEmailContentInfo] != "true" || req.PreferredLanguage != "de" {
+				t.Errorf("unexpected content: %v", req)
+			}
+			return &messageAPI.ServiceStatus{}, nil
+		})
+		mockLoggingClient.EXPECT().SaveLogEvent(
+			gomock.Any(),
+			gomock.Any(),
+		).Return(nil, nil)
+
+		sampleInfos := map[string]string{"studyName": "Sample study"}
+		resp, err := s.SendTestEmail(context.Background(), &SendTestEmailReq{Token: adminToken, To: " Test-Email@test.com", MessageType: "password-changed", ContentInfos: sampleInfos, PreferredLanguage: "de"})
+		if err != nil || resp == nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if _, ok := sampleInfos[testEmailContentInfo]; ok {
+			t.Error("content infos of the request should not be changed")
+		}
+	})
+}
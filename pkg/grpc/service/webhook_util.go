@@ -0,0 +1,20 @@
+package service
+
+import (
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
+// notifyWebhook sends a user lifecycle event to the webhook if one is configured, without waiting for the delivery
+func (s *userManagementServer) notifyWebhook(eventType string, instanceID string, userID string) {
+	if s.clients == nil || s.clients.Webhooks == nil {
+		return
+	}
+	s.clients.Webhooks.Notify(models.WebhookEvent{
+		Type:       eventType,
+		UserID:     userID,
+		InstanceID: instanceID,
+		Timestamp:  time.Now().Unix(),
+	})
+}
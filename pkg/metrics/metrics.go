@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the request latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	method string
+	code   string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range latencyBuckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type poolStats struct {
+	open  int64
+	inUse int64
+}
+
+// Metrics collects request and DB pool metrics and exposes them in the Prometheus text format
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	latencies map[string]*histogram
+	pools     map[string]*poolStats
+}
+
+func New() *Metrics {
+	return &Metrics{
+		requests:  map[requestKey]uint64{},
+		latencies: map[string]*histogram{},
+		pools:     map[string]*poolStats{},
+	}
+}
+
+// ObserveRequest records a completed request of the method with its gRPC status code
+func (m *Metrics) ObserveRequest(method string, code string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{method: method, code: code}]++
+	h, ok := m.latencies[method]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[method] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// RequestCount returns the number of recorded requests for the method and status code
+func (m *Metrics) RequestCount(method string, code string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests[requestKey{method: method, code: code}]
+}
+
+// UnaryServerInterceptor records count and latency of each unary gRPC call
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.ObserveRequest(info.FullMethod, status.Code(err).String(), time.Since(start))
+		return resp, err
+	}
+}
+
+// PoolMonitor returns a mongo pool monitor keeping track of the connections of the pool, labeled with the db name
+func (m *Metrics) PoolMonitor(db string) *event.PoolMonitor {
+	m.mu.Lock()
+	if _, ok := m.pools[db]; !ok {
+		m.pools[db] = &poolStats{}
+	}
+	m.mu.Unlock()
+
+	return &event.PoolMonitor{
+		Event: func(evt *event.PoolEvent) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			stats := m.pools[db]
+			switch evt.Type {
+			case event.ConnectionCreated:
+				stats.open++
+			case event.ConnectionClosed:
+				stats.open--
+			case event.GetSucceeded:
+				stats.inUse++
+			case event.ConnectionReturned:
+				stats.inUse--
+			}
+		},
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP grpc_server_handled_total Total number of RPCs completed on the server, by method and status code.\n")
+	b.WriteString("# TYPE grpc_server_handled_total counter\n")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "grpc_server_handled_total{grpc_method=%q,grpc_code=%q} %d\n", k.method, k.code, m.requests[k])
+	}
+
+	b.WriteString("# HELP grpc_server_handling_seconds Latency of RPCs handled by the server, by method.\n")
+	b.WriteString("# TYPE grpc_server_handling_seconds histogram\n")
+	methods := make([]string, 0, len(m.latencies))
+	for method := range m.latencies {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		h := m.latencies[method]
+		cumulative := uint64(0)
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "grpc_server_handling_seconds_bucket{grpc_method=%q,le=\"%g\"} %d\n", method, bound, cumulative)
+		}
+		fmt.Fprintf(&b, "grpc_server_handling_seconds_bucket{grpc_method=%q,le=\"+Inf\"} %d\n", method, h.count)
+		fmt.Fprintf(&b, "grpc_server_handling_seconds_sum{grpc_method=%q} %g\n", method, h.sum)
+		fmt.Fprintf(&b, "grpc_server_handling_seconds_count{grpc_method=%q} %d\n", method, h.count)
+	}
+
+	dbs := make([]string, 0, len(m.pools))
+	for db := range m.pools {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+	b.WriteString("# HELP mongodb_pool_open_connections Number of open connections in the mongo connection pool.\n")
+	b.WriteString("# TYPE mongodb_pool_open_connections gauge\n")
+	for _, db := range dbs {
+		fmt.Fprintf(&b, "mongodb_pool_open_connections{db=%q} %d\n", db, m.pools[db].open)
+	}
+	b.WriteString("# HELP mongodb_pool_in_use_connections Number of connections checked out from the mongo connection pool.\n")
+	b.WriteString("# TYPE mongodb_pool_in_use_connections gauge\n")
+	for _, db := range dbs {
+		fmt.Fprintf(&b, "mongodb_pool_in_use_connections{db=%q} %d\n", db, m.pools[db].inUse)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Handler serves the metrics for scraping
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = m.WriteTo(w)
+	})
+}
+
+// ListenAndServe serves the metrics on /metrics at the given port
+func (m *Metrics) ListenAndServe(port string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	return http.ListenAndServe(":"+port, mux)
+}
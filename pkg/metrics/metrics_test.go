@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/event"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	m := New()
+	interceptor := m.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/user_management_api.UserManagementApi/Status"}
+
+	t.Run("successful call", func(t *testing.T) {
+		resp, err := interceptor(context.Background(), "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "resp", nil
+		})
+		if err != nil || resp != "resp" {
+			t.Errorf("unexpected result: %v, %v", resp, err)
+		}
+		if c := m.RequestCount(info.FullMethod, "OK"); c != 1 {
+			t.Errorf("unexpected count: %d", c)
+		}
+	})
+
+	t.Run("failing call", func(t *testing.T) {
+		_, err := interceptor(context.Background(), "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.InvalidArgument, "missing argument")
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("error should be passed through: %v", err)
+		}
+		if c := m.RequestCount(info.FullMethod, "InvalidArgument"); c != 1 {
+			t.Errorf("unexpected count: %d", c)
+		}
+	})
+
+	t.Run("exposed metrics", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body := rec.Body.String()
+		for _, expected := range []string{
+			`grpc_server_handled_total{grpc_method="/user_management_api.UserManagementApi/Status",grpc_code="OK"} 1`,
+			`grpc_server_handled_total{grpc_method="/user_management_api.UserManagementApi/Status",grpc_code="InvalidArgument"} 1`,
+			`grpc_server_handling_seconds_count{grpc_method="/user_management_api.UserManagementApi/Status"} 2`,
+			`grpc_server_handling_seconds_bucket{grpc_method="/user_management_api.UserManagementApi/Status",le="+Inf"} 2`,
+		} {
+			if !strings.Contains(body, expected) {
+				t.Errorf("missing metric %s in:\n%s", expected, body)
+			}
+		}
+	})
+}
+
+func TestPoolMonitor(t *testing.T) {
+	m := New()
+	monitor := m.PoolMonitor("users")
+	for _, evtType := range []string{event.ConnectionCreated, event.ConnectionCreated, event.GetSucceeded, event.GetSucceeded, event.ConnectionReturned} {
+		monitor.Event(&event.PoolEvent{Type: evtType})
+	}
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if !strings.Contains(b.String(), `mongodb_pool_open_connections{db="users"} 2`) {
+		t.Errorf("unexpected open connections:\n%s", b.String())
+	}
+	if !strings.Contains(b.String(), `mongodb_pool_in_use_connections{db="users"} 1`) {
+		t.Errorf("unexpected connections in use:\n%s", b.String())
+	}
+}
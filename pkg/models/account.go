@@ -27,6 +27,17 @@ type VerificationCode struct {
 	ExpiresAt int64  `bson:"expiresAt"`
 }
 
+// AccountStatus tells the client if the account ID is confirmed, returned by GetAccountStatus
+type AccountStatus struct {
+	AccountConfirmed    bool  `json:"accountConfirmed"`
+	AccountConfirmedAt  int64 `json:"accountConfirmedAt,omitempty"`
+	VerificationPending bool  `json:"verificationPending"` // a verification link or code for the account ID can still be used
+	// last email sent to confirm the account ID, 0 if none was sent
+	VerificationEmailSentAt int64 `json:"verificationEmailSentAt,omitempty"`
+	// current versions, by policy key, of the instance's policies the user has to (re-)accept with AcceptPolicy
+	PendingPolicies map[string]string `json:"pendingPolicies,omitempty"`
+}
+
 func AccountFromAPI(a *api.User_Account) Account {
 	if a == nil {
 		return Account{}
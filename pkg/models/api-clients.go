@@ -11,4 +11,5 @@ type APIClients struct {
 	MessagingService messageAPI.MessagingServiceApiClient
 	LoggingService   loggingAPI.LoggingServiceApiClient
 	StudyService     studyAPI.StudyServiceApiClient
+	Webhooks         WebhookNotifier // nil if no webhook is configured
 }
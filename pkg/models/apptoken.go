@@ -8,6 +8,16 @@ import (
 type AppToken struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	AppName   string             `bson:"appName"`
-	Tokens    []string           `bson:"tokens"`
+	Tokens    []string           `bson:"tokens,omitempty"` // plain text tokens of entries created before hashing was introduced
+	TokenHash string             `bson:"tokenHash,omitempty" json:"-"`
 	Instances []string           `bson:"instances"`
+	Scopes    []string           `bson:"scopes,omitempty"` // e.g. "users:read", "users:delete"
+	CreatedAt int64              `bson:"createdAt,omitempty"`
+	ExpiresAt int64              `bson:"expiresAt,omitempty"` // 0 means no expiration
+}
+
+// AppTokenGrant describes what a valid app token gives access to
+type AppTokenGrant struct {
+	Instances []string `json:"instances"`
+	Scopes    []string `json:"scopes"`
 }
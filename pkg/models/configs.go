@@ -1,6 +1,11 @@
 package models
 
-import "time"
+import (
+	"net"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+)
 
 type DBConfig struct {
 	URI             string
@@ -9,12 +14,89 @@ type DBConfig struct {
 	NoCursorTimeout bool
 	MaxPoolSize     uint64
 	IdleConnTimeout int
+	TLS             bool
+	TLSCAFile       string             // optional, system CAs are used if empty
+	ReadPreference  string             // e.g. primary, primaryPreferred, secondaryPreferred, empty for driver default
+	WriteConcern    string             // "majority", number of nodes or tag set name, empty for driver default
+	PoolMonitor     *event.PoolMonitor // optional, e.g. for metrics
+}
+
+// CircuitBreakerConfig holds the thresholds of the circuit breaker around a service client
+type CircuitBreakerConfig struct {
+	FailureThreshold int           // consecutive failures opening the breaker
+	OpenDuration     time.Duration // time calls are rejected before a trial call
+	CallTimeout      time.Duration // maximum duration of a call, 0 for no limit
+}
+
+// SendPacingConfig spaces out the emails sent by a background job, so that it doesn't burst the messaging service
+type SendPacingConfig struct {
+	Interval   time.Duration // minimum delay between two emails, 0 for none
+	BatchSize  int           // emails sent before pausing for BatchPause, 0 for no pause
+	BatchPause time.Duration
+}
+
+// EmailRetryConfig sets how emails that could not be sent are retried
+type EmailRetryConfig struct {
+	Interval    time.Duration // how often the queued emails are retried, 0 disables the retries
+	MaxAttempts int           // attempts including the first one, the email is dropped after
+	Delay       time.Duration // delay after the first failed retry, doubled after each further one
+}
+
+// LoginHistoryConfig limits the login history kept for each user
+type LoginHistoryConfig struct {
+	Size      int           // number of logins kept
+	Retention time.Duration // logins older than this are removed, 0 for no limit
+}
+
+// DisposableEmailDetector tells if an email address belongs to a disposable (temporary) email provider.
+// The data source is up to the implementation, e.g. a static domain list or an external service.
+type DisposableEmailDetector interface {
+	IsDisposable(email string) (bool, error)
+}
+
+// DisposableEmailConfig is the handling of disposable email addresses at signup and when adding an email
+type DisposableEmailConfig struct {
+	Detector DisposableEmailDetector // nil disables the check
+	Action   string                  // DISPOSABLE_EMAIL_FLAG or DISPOSABLE_EMAIL_REJECT
+}
+
+// WebhookConfig is the target of the webhook notifications on user lifecycle events
+type WebhookConfig struct {
+	URL         string
+	Secret      string        // key of the HMAC-SHA256 signature of the payload
+	MaxAttempts int           // deliveries of an event before it is dropped
+	RetryDelay  time.Duration // delay before the first retry, doubled for each further one
+	Timeout     time.Duration // of a single delivery
+}
+
+// MethodRateLimit is a token bucket allowing Burst calls at once, refilled at Burst calls per Window
+type MethodRateLimit struct {
+	Burst  int
+	Window time.Duration
+}
+
+// RateLimitConfig holds the rate limits per method (short name, e.g. "LoginWithEmail") of the rate limit interceptor
+type RateLimitConfig struct {
+	Methods        map[string]MethodRateLimit // methods not listed are not limited
+	KeyBy          string                     // RATE_LIMIT_KEY_IP or RATE_LIMIT_KEY_USER
+	TrustedProxies []*net.IPNet               // proxies whose x-forwarded-for header is used for the client IP
 }
 
 // Intervals embeds configuration of time based parameters (durations, frequency, lifetime)
 type Intervals struct {
 	TokenExpiryInterval              time.Duration // interpreted in minutes later
 	VerificationCodeLifetime         int64         // in seconds
+	VerificationCodeResendCooldown   int64         // minimum delay between two verification code requests, in seconds, 0 disables it, negative for the default
 	InvitationTokenLifetime          time.Duration // Duration of the invitation token lifetime
 	ContactVerificationTokenLifetime time.Duration // Duration of the contact verification token lifetime
+	ServiceAccountTokenLifetime      time.Duration // Duration of the tokens issued for service accounts
+	PasswordResetTokenLifetime       time.Duration // Duration of the password reset token lifetime
+	RefreshTokenLifetime             time.Duration // Duration of the refresh tokens
+	RememberMeRefreshTokenLifetime   time.Duration // Duration of the refresh tokens of logins with remember me
+	PasswordResetTriggerWindow       time.Duration // Period in which password reset requests are counted for throttling
+	SignupPerIPWindow                time.Duration // Period in which signups are counted per client IP for throttling
+	StepUpAuthMaxAge                 time.Duration // Sensitive operations require an authentication within this period, 0 disables the check
+	TempTokenCleanupMinInterval      time.Duration // Minimum delay between two cleanups of expired temp tokens triggered by the temp token endpoints
+	ExpiredTempTokenRetention        time.Duration // Expired temp tokens are removed by these cleanups after this delay
+	JWTLeeway                        time.Duration // Tolerated clock skew between services when validating the times of a token
 }
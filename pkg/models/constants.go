@@ -3,4 +3,101 @@ package models
 const (
 	ACCOUNT_TYPE_EMAIL    = "email"
 	ACCOUNT_TYPE_EXTERNAL = "external"
+	ACCOUNT_TYPE_USERNAME = "username" // account ID is a username, emails are contact infos only
+)
+
+// message topics users can subscribe to
+const (
+	TOPIC_NEWSLETTER      = "newsletter"
+	TOPIC_STUDY_REMINDERS = "study-reminders"
+	TOPIC_SURVEYS         = "surveys"
+)
+
+// handling of disposable email addresses, see DisposableEmailConfig
+const (
+	DISPOSABLE_EMAIL_FLAG   = "flag"
+	DISPOSABLE_EMAIL_REJECT = "reject"
+)
+
+// token purposes not (yet) defined in go-utils
+const (
+	TOKEN_PURPOSE_UNSUBSCRIBE_ALL         = "unsubscribe-all"
+	TOKEN_PURPOSE_NEWSLETTER_CONFIRMATION = "newsletter-confirmation"
+	TOKEN_PURPOSE_SIGNUP_INVITATION       = "signup-invitation" // invitation for a user without account yet
+)
+
+// email types not (yet) defined in go-utils
+const (
+	EMAIL_TYPE_NEWSLETTER_CONFIRMATION  = "newsletter-confirmation"
+	EMAIL_TYPE_ACCOUNT_REACTIVATED      = "account-reactivated"
+	EMAIL_TYPE_SIGNUP_INVITATION        = "signup-invitation"
+	EMAIL_TYPE_VERIFY_EMAIL_CODE        = "verify-email-code"
+	EMAIL_TYPE_INACTIVITY_WARNING       = "account-inactivity-warning"
+	EMAIL_TYPE_DELETION_REMINDER        = "account-deletion-reminder"
+	EMAIL_TYPE_PASSWORD_RESET_REQUESTED = "password-reset-requested"
+)
+
+// content infos added to the emails from the instance settings, see InstanceEmailConfig
+const (
+	EMAIL_CONTENT_SENDER_NAME    = "senderName"
+	EMAIL_CONTENT_SENDER_ADDRESS = "senderAddress"
+)
+
+// key of the calls counted by the rate limit interceptor, see RateLimitConfig
+const (
+	RATE_LIMIT_KEY_IP   = "ip"   // client IP
+	RATE_LIMIT_KEY_USER = "user" // user ID of the validated access token, client IP for calls without
+)
+
+// stable error codes, sent as reason of a google.rpc.ErrorInfo detail, for clients to show their own translation
+const (
+	ERROR_CODE_MISSING_ARGUMENT                = "MISSING_ARGUMENT"
+	ERROR_CODE_INVALID_CREDENTIALS             = "INVALID_CREDENTIALS"
+	ERROR_CODE_PASSWORD_TOO_WEAK               = "PASSWORD_TOO_WEAK"
+	ERROR_CODE_INVALID_EMAIL                   = "INVALID_EMAIL"
+	ERROR_CODE_INVALID_LANGUAGE_CODE           = "INVALID_LANGUAGE_CODE"
+	ERROR_CODE_EMAIL_DOMAIN_NOT_ALLOWED        = "EMAIL_DOMAIN_NOT_ALLOWED"
+	ERROR_CODE_DISPOSABLE_EMAIL_NOT_ALLOWED    = "DISPOSABLE_EMAIL_NOT_ALLOWED"
+	ERROR_CODE_INVALID_TOKEN                   = "INVALID_TOKEN"
+	ERROR_CODE_INVALID_REFRESH_TOKEN           = "INVALID_REFRESH_TOKEN"
+	ERROR_CODE_INVALID_VERIFICATION_CODE       = "INVALID_VERIFICATION_CODE"
+	ERROR_CODE_VERIFICATION_CODE_COOLDOWN      = "VERIFICATION_CODE_COOLDOWN"
+	ERROR_CODE_TOO_MANY_REQUESTS               = "TOO_MANY_REQUESTS"
+	ERROR_CODE_REAUTHENTICATION_REQUIRED       = "REAUTHENTICATION_REQUIRED"
+	ERROR_CODE_NOT_ALLOWED_WHILE_IMPERSONATING = "NOT_ALLOWED_WHILE_IMPERSONATING"
+	ERROR_CODE_UNAUTHENTICATED                 = "UNAUTHENTICATED"
+	ERROR_CODE_INVALID_USERNAME                = "INVALID_USERNAME"
+	ERROR_CODE_ACCOUNT_ID_IN_USE               = "ACCOUNT_ID_IN_USE"
+	ERROR_CODE_EMAIL_NOT_CONFIRMED             = "EMAIL_NOT_CONFIRMED"
+	ERROR_CODE_EMAIL_RECENTLY_RELEASED         = "EMAIL_RECENTLY_RELEASED"
+	ERROR_CODE_USER_NOT_FOUND                  = "USER_NOT_FOUND"
+	ERROR_CODE_POLICY_ACCEPTANCE_REQUIRED      = "POLICY_ACCEPTANCE_REQUIRED"
+	ERROR_CODE_MINIMUM_AGE_NOT_REACHED         = "MINIMUM_AGE_NOT_REACHED"
+	ERROR_CODE_INVALID_BIRTHDATE               = "INVALID_BIRTHDATE"
+	ERROR_CODE_NOT_ALLOWED_FOR_CHILD_PROFILE   = "NOT_ALLOWED_FOR_CHILD_PROFILE"
+)
+
+// token payload keys not (yet) defined in go-utils
+const (
+	TOKEN_PAYLOAD_IMPERSONATED_BY = "impersonated_by" // id of the admin acting as the user
+	TOKEN_PAYLOAD_EXPIRES_AT      = "expires_at"      // added by ValidateJWT, expiration of the token in posix time
+)
+
+// log events not (yet) defined in go-utils
+const (
+	LOG_EVENT_SERVICE_ACCOUNT_TOKEN_ISSUED  = "SERVICE ACCOUNT TOKEN ISSUED"
+	LOG_EVENT_SERVICE_ACCOUNT_TOKEN_REVOKED = "SERVICE ACCOUNT TOKEN REVOKED"
+	LOG_EVENT_APP_TOKEN_CREATED             = "APP TOKEN CREATED"
+	LOG_EVENT_APP_TOKEN_REVOKED             = "APP TOKEN REVOKED"
+	LOG_EVENT_INSTANCE_CREATED              = "INSTANCE CREATED"
+	LOG_EVENT_INSTANCE_DELETED              = "INSTANCE DELETED"
+	LOG_EVENT_ACCOUNT_REACTIVATED           = "ACCOUNT REACTIVATED"
+	LOG_EVENT_DISPOSABLE_EMAIL              = "DISPOSABLE EMAIL"
+	LOG_EVENT_USER_IMPERSONATED             = "USER IMPERSONATED"
+	LOG_EVENT_USER_DATA_ACCESSED            = "USER DATA ACCESSED" // by an admin
+	LOG_EVENT_ACCOUNT_TYPE_CHANGED          = "ACCOUNT TYPE CHANGED"
+	LOG_EVENT_TEST_EMAIL_SENT               = "TEST EMAIL SENT"
+	LOG_EVENT_POLICY_ACCEPTED               = "POLICY ACCEPTED"
+	LOG_EVENT_CHILD_PROFILE_LINKED          = "CHILD PROFILE LINKED"
+	LOG_EVENT_CHILD_PROFILE_UNLINKED        = "CHILD PROFILE UNLINKED"
 )
@@ -14,6 +14,9 @@ type ContactInfo struct {
 	ConfirmationLinkSentAt int64              `bson:"confirmationLinkSentAt"`
 	Email                  string             `bson:"email,omitempty"`
 	Phone                  string             `bson:"phone,omitempty"`
+	Primary                bool               `bson:"primary,omitempty"` // preferred address for notifications
+	// pending code of the verification by code, alternative to the verification link
+	VerificationCode *VerificationCode `bson:"verificationCode,omitempty"`
 }
 
 func ContactInfoFromAPI(obj *api.ContactInfo) ContactInfo {
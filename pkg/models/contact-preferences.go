@@ -1,13 +1,117 @@
 package models
 
-import "github.com/influenzanet/user-management-service/pkg/api"
+import (
+	"errors"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+)
 
 // ContactPreferences defines how to reach out to the user for what purpose
 type ContactPreferences struct {
-	SubscribedToNewsletter        bool     `bson:"subscribedToNewsletter"`
-	SendNewsletterTo              []string `bson:"sendNewsletterTo"`
-	SubscribedToWeekly            bool     `bson:"subscribedToWeekly"`
-	ReceiveWeeklyMessageDayOfWeek int32    `bson:"receiveWeeklyMessageDayOfWeek"`
+	SubscribedToNewsletter        bool            `bson:"subscribedToNewsletter"`
+	SendNewsletterTo              []string        `bson:"sendNewsletterTo"`
+	SubscribedToWeekly            bool            `bson:"subscribedToWeekly"`
+	ReceiveWeeklyMessageDayOfWeek int32           `bson:"receiveWeeklyMessageDayOfWeek"`
+	Timezone                      string          `bson:"timezone,omitempty"` // IANA name, e.g. "Europe/Berlin", empty for the server timezone
+	QuietHours                    *QuietHours     `bson:"quietHours,omitempty"`
+	SubscribedTopics              map[string]bool `bson:"subscribedTopics,omitempty"`
+}
+
+// UnsubscribeFromAll removes the subscription to every topic and to the weekly messages, contact infos are kept for transactional emails
+func (obj *ContactPreferences) UnsubscribeFromAll() {
+	topics := map[string]bool{
+		TOPIC_NEWSLETTER:      false,
+		TOPIC_STUDY_REMINDERS: false,
+		TOPIC_SURVEYS:         false,
+	}
+	for t := range obj.SubscribedTopics {
+		topics[t] = false
+	}
+	obj.SubscribedTopics = topics
+	obj.SubscribedToNewsletter = false
+	obj.SubscribedToWeekly = false
+}
+
+// IsSubscribedToTopic checks the subscription to a topic, the newsletter topic falls back to SubscribedToNewsletter for not migrated users
+func (obj ContactPreferences) IsSubscribedToTopic(topic string) bool {
+	subscribed, ok := obj.SubscribedTopics[topic]
+	if !ok && topic == TOPIC_NEWSLETTER {
+		return obj.SubscribedToNewsletter
+	}
+	return subscribed
+}
+
+// SetTopicSubscription subscribes to or unsubscribes from a topic, SubscribedToNewsletter follows the newsletter topic
+func (obj *ContactPreferences) SetTopicSubscription(topic string, subscribed bool) {
+	topics := make(map[string]bool, len(obj.SubscribedTopics)+1)
+	for t, s := range obj.SubscribedTopics {
+		topics[t] = s
+	}
+	topics[topic] = subscribed
+	obj.SubscribedTopics = topics
+	if topic == TOPIC_NEWSLETTER {
+		obj.SubscribedToNewsletter = subscribed
+	}
+}
+
+// QuietHours is a daily period, in the user's timezone, during which reminders are not sent
+type QuietHours struct {
+	Start string `bson:"start"` // "HH:MM"
+	End   string `bson:"end"`   // "HH:MM", before start if the period goes over midnight
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.New("time of day must be formatted as HH:MM")
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Validate checks the format of start and end
+func (q QuietHours) Validate() error {
+	if _, err := parseTimeOfDay(q.Start); err != nil {
+		return err
+	}
+	if _, err := parseTimeOfDay(q.End); err != nil {
+		return err
+	}
+	if q.Start == q.End {
+		return errors.New("start and end must be different")
+	}
+	return nil
+}
+
+// InTimezone returns t in the user's timezone, unchanged if none or an unknown one is set
+func (obj ContactPreferences) InTimezone(t time.Time) time.Time {
+	if obj.Timezone != "" {
+		if loc, err := time.LoadLocation(obj.Timezone); err == nil {
+			return t.In(loc)
+		}
+	}
+	return t
+}
+
+// InQuietHours checks if t falls within the quiet hours in the user's timezone
+func (obj ContactPreferences) InQuietHours(t time.Time) bool {
+	if obj.QuietHours == nil {
+		return false
+	}
+	start, err := parseTimeOfDay(obj.QuietHours.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseTimeOfDay(obj.QuietHours.End)
+	if err != nil {
+		return false
+	}
+	t = obj.InTimezone(t)
+	timeOfDay := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if start < end {
+		return timeOfDay >= start && timeOfDay < end
+	}
+	return timeOfDay >= start || timeOfDay < end
 }
 
 func ContactPreferencesFromAPI(obj *api.ContactPreferences) ContactPreferences {
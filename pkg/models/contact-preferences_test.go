@@ -0,0 +1,110 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursValidate(t *testing.T) {
+	for _, q := range []QuietHours{
+		{Start: "22:00", End: "07:00"},
+		{Start: "12:30", End: "14:00"},
+	} {
+		if err := q.Validate(); err != nil {
+			t.Errorf("unexpected error for %v: %v", q, err)
+		}
+	}
+	for _, q := range []QuietHours{
+		{Start: "22:00", End: ""},
+		{Start: "25:00", End: "07:00"},
+		{Start: "10pm", End: "07:00"},
+		{Start: "07:00", End: "07:00"},
+	} {
+		if err := q.Validate(); err == nil {
+			t.Errorf("%v should be invalid", q)
+		}
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	// 21:30 UTC is 23:30 in Berlin and 17:30 in New York
+	sendTime := time.Date(2026, time.October, 14, 21, 30, 0, 0, time.UTC)
+
+	t.Run("without quiet hours", func(t *testing.T) {
+		cp := ContactPreferences{Timezone: "Europe/Berlin"}
+		if cp.InQuietHours(sendTime) {
+			t.Error("should not be in quiet hours")
+		}
+	})
+
+	t.Run("send inside quiet hours over midnight", func(t *testing.T) {
+		cp := ContactPreferences{Timezone: "Europe/Berlin", QuietHours: &QuietHours{Start: "22:00", End: "07:00"}}
+		if !cp.InQuietHours(sendTime) {
+			t.Error("should be in quiet hours")
+		}
+		if !cp.InQuietHours(sendTime.Add(7 * time.Hour)) {
+			t.Error("06:30 should be in quiet hours")
+		}
+	})
+
+	t.Run("send outside quiet hours in the user's timezone", func(t *testing.T) {
+		cp := ContactPreferences{Timezone: "America/New_York", QuietHours: &QuietHours{Start: "22:00", End: "07:00"}}
+		if cp.InQuietHours(sendTime) {
+			t.Error("should not be in quiet hours")
+		}
+	})
+
+	t.Run("end of quiet hours is excluded", func(t *testing.T) {
+		cp := ContactPreferences{QuietHours: &QuietHours{Start: "12:00", End: "21:30"}}
+		if cp.InQuietHours(sendTime) {
+			t.Error("should not be in quiet hours")
+		}
+		if !cp.InQuietHours(sendTime.Add(-time.Minute)) {
+			t.Error("21:29 should be in quiet hours")
+		}
+	})
+}
+
+func TestTopicSubscription(t *testing.T) {
+	t.Run("newsletter falls back to the boolean", func(t *testing.T) {
+		cp := ContactPreferences{SubscribedToNewsletter: true}
+		if !cp.IsSubscribedToTopic(TOPIC_NEWSLETTER) || cp.IsSubscribedToTopic(TOPIC_SURVEYS) {
+			t.Errorf("unexpected subscriptions: %v", cp)
+		}
+	})
+
+	t.Run("subscribe and unsubscribe single topics", func(t *testing.T) {
+		cp := ContactPreferences{SubscribedToNewsletter: true}
+		cp.SetTopicSubscription(TOPIC_SURVEYS, true)
+		cp.SetTopicSubscription(TOPIC_NEWSLETTER, false)
+		if !cp.IsSubscribedToTopic(TOPIC_SURVEYS) || cp.IsSubscribedToTopic(TOPIC_NEWSLETTER) || cp.SubscribedToNewsletter {
+			t.Errorf("unexpected subscriptions: %v", cp)
+		}
+		cp.SetTopicSubscription(TOPIC_SURVEYS, false)
+		if cp.IsSubscribedToTopic(TOPIC_SURVEYS) {
+			t.Errorf("unexpected subscriptions: %v", cp)
+		}
+	})
+}
+
+func TestUnsubscribeFromAll(t *testing.T) {
+	cp := ContactPreferences{
+		SubscribedToNewsletter:        true,
+		SendNewsletterTo:              []string{"contact_id"},
+		SubscribedToWeekly:            true,
+		ReceiveWeeklyMessageDayOfWeek: 2,
+		SubscribedTopics:              map[string]bool{TOPIC_SURVEYS: true, "custom": true},
+	}
+	cp.UnsubscribeFromAll()
+	for _, topic := range []string{TOPIC_NEWSLETTER, TOPIC_STUDY_REMINDERS, TOPIC_SURVEYS, "custom"} {
+		if cp.IsSubscribedToTopic(topic) {
+			t.Errorf("should be unsubscribed from %s", topic)
+		}
+	}
+	if cp.SubscribedToNewsletter || cp.SubscribedToWeekly {
+		t.Errorf("unexpected contact preferences: %v", cp)
+	}
+	if len(cp.SendNewsletterTo) != 1 || cp.ReceiveWeeklyMessageDayOfWeek != 2 {
+		t.Errorf("addresses and weekday should be kept: %v", cp)
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+// DuplicateAccountGroup lists accounts that probably belong to the same person, for a manual merge
+type DuplicateAccountGroup struct {
+	Emails   []string           `json:"emails"` // normalized addresses shared by the accounts
+	Accounts []DuplicateAccount `json:"accounts"`
+}
+
+// DuplicateAccount describes one account of a DuplicateAccountGroup
+type DuplicateAccount struct {
+	UserID             string `json:"userId"`
+	AccountID          string `json:"accountId"`
+	AccountConfirmedAt int64  `json:"accountConfirmedAt"`
+	CreatedAt          int64  `json:"createdAt"`
+	LastLogin          int64  `json:"lastLogin"`
+}
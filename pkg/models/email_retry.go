@@ -0,0 +1,49 @@
+package models
+
+import (
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EmailRetry is an email the messaging service could not take, kept to be sent again by the retry job
+type EmailRetry struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty"`
+	InstanceID        string             `bson:"instanceID"`
+	To                []string           `bson:"to"`
+	MessageType       string             `bson:"messageType"`
+	ContentInfos      map[string]string  `bson:"contentInfos,omitempty"`
+	PreferredLanguage string             `bson:"preferredLanguage,omitempty"`
+	UseLowPrio        bool               `bson:"useLowPrio"`
+	Attempts          int                `bson:"attempts"` // failed attempts so far
+	NextAttemptAt     int64              `bson:"nextAttemptAt"`
+	LastError         string             `bson:"lastError,omitempty"`
+	CreatedAt         int64              `bson:"createdAt"`
+}
+
+// EmailRetryFromRequest keeps a request which failed once, to be sent again from nextAttemptAt
+func EmailRetryFromRequest(req *messageAPI.SendEmailReq, sendErr error, now int64, nextAttemptAt int64) EmailRetry {
+	return EmailRetry{
+		InstanceID:        req.InstanceId,
+		To:                req.To,
+		MessageType:       req.MessageType,
+		ContentInfos:      req.ContentInfos,
+		PreferredLanguage: req.PreferredLanguage,
+		UseLowPrio:        req.UseLowPrio,
+		Attempts:          1,
+		NextAttemptAt:     nextAttemptAt,
+		LastError:         sendErr.Error(),
+		CreatedAt:         now,
+	}
+}
+
+// ToRequest returns the request to send the email again
+func (e EmailRetry) ToRequest() *messageAPI.SendEmailReq {
+	return &messageAPI.SendEmailReq{
+		InstanceId:        e.InstanceID,
+		To:                e.To,
+		MessageType:       e.MessageType,
+		ContentInfos:      e.ContentInfos,
+		PreferredLanguage: e.PreferredLanguage,
+		UseLowPrio:        e.UseLowPrio,
+	}
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IdempotencyRecord stores the outcome of a request sent with an idempotency key, so a retry with the same key gets the same result.
+// Tokens of the response are not stored, they are issued again for the retry.
+type IdempotencyRecord struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	InstanceID  string             `bson:"instanceID"`
+	Key         string             `bson:"key"`
+	RequestHash string             `bson:"requestHash"`      // keyed hash without secrets like the password
+	UserID      string             `bson:"userID,omitempty"` // result of the first request, empty while it is running
+	ExpiresAt   time.Time          `bson:"expiresAt"`
+}
@@ -0,0 +1,8 @@
+package models
+
+// InactiveUserRecord is an entry of the manifest of inactive accounts, without the account ID in clear
+type InactiveUserRecord struct {
+	UserID        string `json:"userId"`
+	AccountIDHash string `json:"accountIdHash"` // hex encoded sha256 of the lower case account ID
+	LastLogin     int64  `json:"lastLogin"`
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"github.com/influenzanet/go-utils/pkg/constants"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+)
+
+// Instance is the document of an instance in the global DB, with the settings of this service overriding the global configuration
+type Instance struct {
+	InstanceID     string         `bson:"instanceID"`
+	UserManagement InstanceConfig `bson:"userManagement,omitempty"`
+}
+
+// InstanceConfig holds the instance level settings, zero values mean the global configuration is used
+type InstanceConfig struct {
+	VerificationCodeLifetime int64             `bson:"verificationCodeLifetime,omitempty"` // in seconds
+	PasswordPolicy           PasswordPolicy    `bson:"passwordPolicy,omitempty"`
+	NewsletterDoubleOptIn    bool              `bson:"newsletterDoubleOptIn,omitempty"` // newsletter subscriptions are active once confirmed by email
+	SendReactivationEmail    bool              `bson:"sendReactivationEmail,omitempty"` // tell users by email when a login cancels the deletion of their account
+	EmailDomains             EmailDomainPolicy `bson:"emailDomains,omitempty"`
+	// in seconds, email addresses released by account deletion or email change can't be used by another account
+	// during that time, 0 disables it
+	ReleasedEmailCooldown int64               `bson:"releasedEmailCooldown,omitempty"`
+	Email                 InstanceEmailConfig `bson:"email,omitempty"`
+	Policies              PolicyConfig        `bson:"policies,omitempty"`
+	// in years, the account holder (main profile) and the other adult profiles must be at least this old, 0 disables
+	// it. Child profiles linked to a guardian profile are exempt.
+	MinimumAge int `bson:"minimumAge,omitempty"`
+}
+
+// PolicyConfig lists the current versions of the policies users have to accept, e.g. terms of service and privacy policy
+type PolicyConfig struct {
+	CurrentVersions map[string]string `bson:"currentVersions,omitempty"` // by policy key, e.g. {"terms": "2024-05"}
+	// if set, sensitive actions are refused until the user accepted the current versions
+	RequireAcceptance bool `bson:"requireAcceptance,omitempty"`
+}
+
+// InstanceEmailConfig brands and monitors the emails of the instance. The messaging API has no sender or BCC fields:
+// the sender is passed to the templates as content infos, the BCC address gets a separate copy of the email.
+type InstanceEmailConfig struct {
+	SenderName    string `bson:"senderName,omitempty"`
+	SenderAddress string `bson:"senderAddress,omitempty"`
+	// receives a copy of the emails about security relevant account changes (see BCCMessageTypes)
+	BCC string `bson:"bcc,omitempty"`
+}
+
+// BCCMessageTypes are the message types copied to the BCC address of the instance. They don't contain links with tokens.
+var BCCMessageTypes = map[string]bool{
+	constants.EMAIL_TYPE_PASSWORD_CHANGED:                 true,
+	constants.EMAIL_TYPE_ACCOUNT_DELETED:                  true,
+	constants.EMAIL_TYPE_ACCOUNT_DELETED_AFTER_INACTIVITY: true,
+}
+
+// Apply adds the sender of the instance to the content infos of req. It returns the copy of req to send to the BCC
+// address, or nil if there is none for this message type.
+func (c InstanceEmailConfig) Apply(req *messageAPI.SendEmailReq) *messageAPI.SendEmailReq {
+	if c.SenderName != "" || c.SenderAddress != "" {
+		infos := map[string]string{}
+		for k, v := range req.ContentInfos {
+			infos[k] = v
+		}
+		if c.SenderName != "" {
+			infos[EMAIL_CONTENT_SENDER_NAME] = c.SenderName
+		}
+		if c.SenderAddress != "" {
+			infos[EMAIL_CONTENT_SENDER_ADDRESS] = c.SenderAddress
+		}
+		req.ContentInfos = infos
+	}
+	if c.BCC == "" || !BCCMessageTypes[req.MessageType] {
+		return nil
+	}
+	return &messageAPI.SendEmailReq{
+		InstanceId:        req.InstanceId,
+		To:                []string{c.BCC},
+		MessageType:       req.MessageType,
+		StudyKey:          req.StudyKey,
+		PreferredLanguage: req.PreferredLanguage,
+		ContentInfos:      req.ContentInfos,
+		UseLowPrio:        true,
+	}
+}
+
+// EmailDomainPolicy restricts the email addresses users can sign up or add with. A domain also covers its subdomains.
+type EmailDomainPolicy struct {
+	AllowedDomains []string `bson:"allowedDomains,omitempty"` // if set, only these domains are accepted
+	BlockedDomains []string `bson:"blockedDomains,omitempty"` // e.g. disposable email providers
+	TrustedDomains []string `bson:"trustedDomains,omitempty"` // accounts signing up with these domains are confirmed without verification email
+}
+
+// PasswordPolicy describes the rules new passwords have to fulfill, zero values are replaced by the default policy
+type PasswordPolicy struct {
+	MinLength      int `bson:"minLength,omitempty"`
+	MaxLength      int `bson:"maxLength,omitempty"`
+	MinCharClasses int `bson:"minCharClasses,omitempty"` // lowercase, uppercase, digits and symbols
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/influenzanet/go-utils/pkg/constants"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+)
+
+func TestInstanceEmailConfigApply(t *testing.T) {
+	t.Run("without settings", func(t *testing.T) {
+		req := &messageAPI.SendEmailReq{MessageType: constants.EMAIL_TYPE_ACCOUNT_DELETED}
+		if bcc := (InstanceEmailConfig{}).Apply(req); bcc != nil {
+			t.Errorf("unexpected copy: %v", bcc)
+		}
+		if req.ContentInfos != nil {
+			t.Errorf("unexpected content infos: %v", req.ContentInfos)
+		}
+	})
+
+	conf := InstanceEmailConfig{SenderName: "Example Study", BCC: "admin@example.org"}
+
+	t.Run("with sender", func(t *testing.T) {
+		infos := map[string]string{"token": "abc"}
+		req := &messageAPI.SendEmailReq{MessageType: constants.EMAIL_TYPE_PASSWORD_RESET, ContentInfos: infos}
+		if bcc := conf.Apply(req); bcc != nil {
+			t.Errorf("password reset emails should not be copied: %v", bcc)
+		}
+		if req.ContentInfos[EMAIL_CONTENT_SENDER_NAME] != "Example Study" || req.ContentInfos["token"] != "abc" {
+			t.Errorf("unexpected content infos: %v", req.ContentInfos)
+		}
+		if _, ok := req.ContentInfos[EMAIL_CONTENT_SENDER_ADDRESS]; ok {
+			t.Error("sender address should not be set")
+		}
+		if len(infos) != 1 {
+			t.Errorf("content infos of the caller should not be changed: %v", infos)
+		}
+	})
+
+	t.Run("with bcc", func(t *testing.T) {
+		req := &messageAPI.SendEmailReq{InstanceId: "test", To: []string{"user@test.com"}, MessageType: constants.EMAIL_TYPE_ACCOUNT_DELETED}
+		bcc := conf.Apply(req)
+		if bcc == nil || len(bcc.To) != 1 || bcc.To[0] != "admin@example.org" || bcc.InstanceId != "test" || bcc.MessageType != req.MessageType {
+			t.Errorf("unexpected copy: %v", bcc)
+			return
+		}
+		if len(req.To) != 1 || req.To[0] != "user@test.com" {
+			t.Errorf("recipients of the email should not be changed: %v", req.To)
+		}
+	})
+}
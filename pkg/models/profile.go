@@ -1,18 +1,116 @@
 package models
 
 import (
+	"errors"
+	"time"
+
 	"github.com/influenzanet/user-management-service/pkg/api"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Profile describes personal profile information for a User
 type Profile struct {
-	ID                 primitive.ObjectID `bson:"_id,omitempty"`
-	Alias              string             `bson:"alias,omitempty"`
-	ConsentConfirmedAt int64              `bson:"consentConfirmedAt"`
-	CreatedAt          int64              `bson:"createdAt"`
-	AvatarID           string             `bson:"avatarID,omitempty"`
-	MainProfile        bool               `bson:"mainProfile"`
+	ID                 primitive.ObjectID        `bson:"_id,omitempty"`
+	Alias              string                    `bson:"alias,omitempty"`
+	ConsentConfirmedAt int64                     `bson:"consentConfirmedAt"`
+	CreatedAt          int64                     `bson:"createdAt"`
+	AvatarID           string                    `bson:"avatarID,omitempty"`
+	AvatarURL          string                    `bson:"avatarURL,omitempty"` // custom avatar, instead of a predefined one
+	MainProfile        bool                      `bson:"mainProfile"`
+	Consents           map[string]ProfileConsent `bson:"consents,omitempty"`
+	PreferredLanguage  string                    `bson:"preferredLanguage,omitempty"` // overrides the account language for this profile
+	Birthdate          string                    `bson:"birthdate,omitempty"`         // calendar date without time zone, see BIRTHDATE_LAYOUT
+	PreviousVersion    *ProfileSnapshot          `bson:"previousVersion,omitempty"`   // before the last change, to undo it
+	GuardianProfileID  string                    `bson:"guardianProfileID,omitempty"` // set for child profiles, managed by the guardian profile
+}
+
+// IsChild is true for profiles linked to a guardian profile, see User.LinkChildProfile
+func (p Profile) IsChild() bool {
+	return p.GuardianProfileID != ""
+}
+
+// TokenProfile is the compact form of a profile embedded in access tokens, see tokens.GetProfilesFromPayload
+type TokenProfile struct {
+	ID    string `json:"id"`
+	Alias string `json:"alias,omitempty"`
+}
+
+// ProfileSnapshot is a previous version of a profile
+type ProfileSnapshot struct {
+	Profile    Profile `bson:"profile"`
+	ReplacedAt int64   `bson:"replacedAt"`
+}
+
+// KeepPreviousVersion stores previous as the version before the current change, replacing an older one
+func (p *Profile) KeepPreviousVersion(previous Profile, replacedAt int64) {
+	previous.PreviousVersion = nil
+	p.PreviousVersion = &ProfileSnapshot{Profile: previous, ReplacedAt: replacedAt}
+}
+
+// BIRTHDATE_LAYOUT is the format of the profile birthdates. They are stored as dates, not as timestamps, so that
+// they don't shift by a day with the time zone.
+const BIRTHDATE_LAYOUT = "2006-01-02"
+
+const maxBirthdateAge = 120 // years, older birthdates are not plausible
+
+// ValidateBirthdate checks that the birthdate has the format of BIRTHDATE_LAYOUT, is not after today and not more than
+// maxBirthdateAge years before
+func ValidateBirthdate(birthdate string, today time.Time) error {
+	d, err := time.Parse(BIRTHDATE_LAYOUT, birthdate)
+	if err != nil {
+		return errors.New("birthdate has the wrong format")
+	}
+	y, m, day := today.Date()
+	todayDate := time.Date(y, m, day, 0, 0, 0, 0, time.UTC)
+	if d.After(todayDate) {
+		return errors.New("birthdate is in the future")
+	}
+	if d.Before(todayDate.AddDate(-maxBirthdateAge, 0, 0)) {
+		return errors.New("birthdate is too far in the past")
+	}
+	return nil
+}
+
+// Validate checks the fields of the profile that are not free text. today is the current date of the user.
+func (p Profile) Validate(today time.Time) error {
+	if p.Birthdate != "" {
+		return ValidateBirthdate(p.Birthdate, today)
+	}
+	return nil
+}
+
+// AgeAt returns the age in completed years at the date of t, in the location of t. false if the profile has no
+// valid birthdate.
+func (p Profile) AgeAt(t time.Time) (int, bool) {
+	d, err := time.Parse(BIRTHDATE_LAYOUT, p.Birthdate)
+	if err != nil {
+		return 0, false
+	}
+	y, m, day := t.Date()
+	age := y - d.Year()
+	if m < d.Month() || (m == d.Month() && day < d.Day()) {
+		age--
+	}
+	if age < 0 {
+		return 0, false
+	}
+	return age, true
+}
+
+// ProfileConsent records which version of a consent was given for the profile
+type ProfileConsent struct {
+	Version     string `bson:"version"`
+	ConfirmedAt int64  `bson:"confirmedAt"`
+}
+
+// SetConsent records the version of the consent, replacing a previous one
+func (p *Profile) SetConsent(key string, version string, confirmedAt int64) {
+	consents := make(map[string]ProfileConsent, len(p.Consents)+1)
+	for k, c := range p.Consents {
+		consents[k] = c
+	}
+	consents[key] = ProfileConsent{Version: version, ConfirmedAt: confirmedAt}
+	p.Consents = consents
 }
 
 func ProfileFromAPI(p *api.Profile) Profile {
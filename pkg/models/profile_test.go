@@ -0,0 +1,76 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateBirthdate(t *testing.T) {
+	today := time.Date(2024, 5, 10, 23, 30, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+
+	t.Run("valid birthdate", func(t *testing.T) {
+		if err := ValidateBirthdate("1985-02-28", today); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("born today", func(t *testing.T) {
+		// 21:30 UTC, still the 10th for the user
+		if err := ValidateBirthdate("2024-05-10", today); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("future date", func(t *testing.T) {
+		if err := ValidateBirthdate("2024-05-11", today); err == nil {
+			t.Error("future birthdate should be rejected")
+		}
+	})
+
+	t.Run("implausible date", func(t *testing.T) {
+		if err := ValidateBirthdate("1890-01-01", today); err == nil {
+			t.Error("birthdate should be rejected")
+		}
+	})
+
+	t.Run("wrong format", func(t *testing.T) {
+		for _, d := range []string{"10.05.1985", "1985-02-30", "1985-02-28T00:00:00Z"} {
+			if err := ValidateBirthdate(d, today); err == nil {
+				t.Errorf("birthdate %s should be rejected", d)
+			}
+		}
+	})
+}
+
+func TestProfileAgeAt(t *testing.T) {
+	p := Profile{Birthdate: "1990-06-15"}
+
+	t.Run("before the birthday", func(t *testing.T) {
+		age, ok := p.AgeAt(time.Date(2024, 6, 14, 12, 0, 0, 0, time.UTC))
+		if !ok || age != 33 {
+			t.Errorf("unexpected age: %d %v", age, ok)
+		}
+	})
+
+	t.Run("on the birthday", func(t *testing.T) {
+		age, ok := p.AgeAt(time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC))
+		if !ok || age != 34 {
+			t.Errorf("unexpected age: %d %v", age, ok)
+		}
+	})
+
+	t.Run("date of the given location", func(t *testing.T) {
+		// still the 14th in UTC
+		at := time.Date(2024, 6, 14, 22, 0, 0, 0, time.UTC).In(time.FixedZone("UTC+3", 3*60*60))
+		age, ok := p.AgeAt(at)
+		if !ok || age != 34 {
+			t.Errorf("unexpected age: %d %v", age, ok)
+		}
+	})
+
+	t.Run("without birthdate", func(t *testing.T) {
+		if _, ok := (Profile{}).AgeAt(time.Now()); ok {
+			t.Error("age should be unknown")
+		}
+	})
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReleasedEmail is an email address no longer used as account ID, e.g. after account deletion, that can't be used by
+// another account until ExpiresAt. Only a keyed hash of the normalized address is stored.
+type ReleasedEmail struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	InstanceID string             `bson:"instanceID"`
+	EmailHash  string             `bson:"emailHash"`
+	ExpiresAt  time.Time          `bson:"expiresAt"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ServiceAccountToken records the issuance of a long lived token for a service account. Deleting the entry revokes the token.
+type ServiceAccountToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string             `bson:"userID" json:"userID"`
+	IssuedBy  string             `bson:"issuedBy" json:"issuedBy"`
+	IssuedAt  int64              `bson:"issuedAt" json:"issuedAt"`
+	ExpiresAt int64              `bson:"expiresAt" json:"expiresAt"`
+}
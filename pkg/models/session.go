@@ -0,0 +1,10 @@
+package models
+
+// Session describes an active login of a user, backed by a renew token
+type Session struct {
+	ID         string `json:"id"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastUsedAt int64  `json:"lastUsedAt"`
+	ExpiresAt  int64  `json:"expiresAt"`
+	UserAgent  string `json:"userAgent"`
+}
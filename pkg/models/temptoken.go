@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/influenzanet/go-utils/pkg/api_types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -10,6 +12,7 @@ type TempToken struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty" json:"token_id,omitempty"`
 	Token      string             `bson:"token" json:"token"`
 	Expiration int64              `bson:"expiration" json:"expiration"`
+	ExpiresAt  time.Time          `bson:"expiresAt,omitempty" json:"-"` // Expiration as date, for the TTL index
 	Purpose    string             `bson:"purpose" json:"purpose"`
 	UserID     string             `bson:"userID" json:"userID"`
 	Info       map[string]string  `bson:"info" json:"info"`
@@ -45,6 +48,31 @@ func TempTokenFromAPI(t *api_types.TempTokenInfo) *TempToken {
 	}
 }
 
+// TempTokenOverview describes a temp token for admins, without the token string while it is valid
+type TempTokenOverview struct {
+	ID         string            `json:"id"`
+	Token      string            `json:"token,omitempty"` // only set for expired tokens
+	Purpose    string            `json:"purpose"`
+	Expiration int64             `json:"expiration"`
+	Expired    bool              `json:"expired"`
+	Info       map[string]string `json:"info,omitempty"`
+}
+
+// Overview returns the admin view of the token at the time now
+func (t *TempToken) Overview(now int64) TempTokenOverview {
+	o := TempTokenOverview{
+		ID:         t.ID.Hex(),
+		Purpose:    t.Purpose,
+		Expiration: t.Expiration,
+		Expired:    t.Expiration < now,
+		Info:       t.Info,
+	}
+	if o.Expired {
+		o.Token = t.Token
+	}
+	return o
+}
+
 // TempTokens is an array of TempToken
 type TempTokens []TempToken
 
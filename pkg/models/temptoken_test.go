@@ -0,0 +1,34 @@
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTempTokenOverview(t *testing.T) {
+	tt := TempToken{
+		ID:         primitive.NewObjectID(),
+		Token:      "secret-token",
+		Purpose:    "password-reset",
+		Expiration: 1000,
+		Info:       map[string]string{"email": "test@test.com"},
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		o := tt.Overview(999)
+		if o.Expired || o.Token != "" {
+			t.Errorf("unexpected overview: %v", o)
+		}
+		if o.ID != tt.ID.Hex() || o.Purpose != tt.Purpose || o.Expiration != 1000 || o.Info["email"] != "test@test.com" {
+			t.Errorf("unexpected overview: %v", o)
+		}
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		o := tt.Overview(1001)
+		if !o.Expired || o.Token != "secret-token" {
+			t.Errorf("unexpected overview: %v", o)
+		}
+	})
+}
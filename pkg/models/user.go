@@ -2,6 +2,7 @@ package models
 
 import (
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/influenzanet/user-management-service/pkg/api"
@@ -10,13 +11,24 @@ import (
 
 // User describes the user as saved in the DB
 type User struct {
-	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"user_id,omitempty"`
-	Account            Account            `bson:"account"`
-	Roles              []string           `bson:"roles" json:"roles"`
-	Timestamps         Timestamps         `bson:"timestamps"`
-	Profiles           []Profile          `bson:"profiles"`
-	ContactPreferences ContactPreferences `bson:"contactPreferences"`
-	ContactInfos       []ContactInfo      `bson:"contactInfos"`
+	ID                 primitive.ObjectID          `bson:"_id,omitempty" json:"user_id,omitempty"`
+	Account            Account                     `bson:"account"`
+	Roles              []string                    `bson:"roles" json:"roles"`
+	Timestamps         Timestamps                  `bson:"timestamps"`
+	Profiles           []Profile                   `bson:"profiles"`
+	ContactPreferences ContactPreferences          `bson:"contactPreferences"`
+	ContactInfos       []ContactInfo               `bson:"contactInfos"`
+	Version            int64                       `bson:"version"`                    // incremented on each update, to detect concurrent writes
+	RecentLogins       []LoginRecord               `bson:"recentLogins,omitempty"`     // oldest first
+	SchemaVersion      int                         `bson:"schemaVersion,omitempty"`    // shape of the document, see CurrentUserSchemaVersion
+	AcceptedPolicies   map[string]PolicyAcceptance `bson:"acceptedPolicies,omitempty"` // by policy key, e.g. "terms" or "privacy"
+}
+
+// LoginRecord describes where a login came from
+type LoginRecord struct {
+	Time      int64  `bson:"time"`
+	IP        string `bson:"ip,omitempty"` // possibly truncated, depending on the configuration
+	UserAgent string `bson:"userAgent,omitempty"`
 }
 
 // ToAPI converts the object from DB to API format
@@ -90,6 +102,7 @@ func (u *User) ConfirmContactInfo(t string, addr string) error {
 	for i, ci := range u.ContactInfos {
 		if t == "email" && ci.Email == addr {
 			u.ContactInfos[i].ConfirmedAt = time.Now().Unix()
+			u.ContactInfos[i].VerificationCode = nil
 			return nil
 		} else if t == "phone" && ci.Phone == addr {
 			u.ContactInfos[i].ConfirmedAt = time.Now().Unix()
@@ -111,6 +124,93 @@ func (u *User) SetContactInfoVerificationSent(t string, addr string) {
 	}
 }
 
+// SetEmailVerificationCode sets the pending verification code of the email address, nil removes it
+func (u *User) SetEmailVerificationCode(addr string, vc *VerificationCode) {
+	for i, ci := range u.ContactInfos {
+		if ci.Type == "email" && ci.Email == addr {
+			u.ContactInfos[i].VerificationCode = vc
+			return
+		}
+	}
+}
+
+// SetPrimaryEmail marks the confirmed email contact with the given id as primary, and unmarks all others
+func (u *User) SetPrimaryEmail(id string) error {
+	ci, found := u.FindContactInfoById(id)
+	if !found {
+		return errors.New("contact not found")
+	}
+	if ci.Type != "email" {
+		return errors.New("wrong contact type")
+	}
+	if ci.ConfirmedAt <= 0 {
+		return errors.New("contact not confirmed")
+	}
+	for i := range u.ContactInfos {
+		u.ContactInfos[i].Primary = u.ContactInfos[i].ID == ci.ID
+	}
+	return nil
+}
+
+// NotificationEmail returns the primary email address if one is set and confirmed, otherwise the account ID,
+// or the first confirmed email address for username accounts
+func (u User) NotificationEmail() string {
+	for _, ci := range u.ContactInfos {
+		if ci.Primary && ci.Type == "email" && ci.ConfirmedAt > 0 && ci.Email != "" {
+			return ci.Email
+		}
+	}
+	if u.Account.Type == ACCOUNT_TYPE_USERNAME {
+		// the account ID is no address
+		for _, ci := range u.ContactInfos {
+			if ci.Type == "email" && ci.ConfirmedAt > 0 && ci.Email != "" {
+				return ci.Email
+			}
+		}
+	}
+	return u.Account.AccountID
+}
+
+// ErrEmailNotConfirmed is returned by SetEmailAccount if the address is not a confirmed email of the user
+var ErrEmailNotConfirmed = errors.New("email not confirmed")
+
+// SetUsernameAccount turns an email account into a username account. The email address stays as contact info.
+func (u *User) SetUsernameAccount(username string) error {
+	if u.Account.Type != ACCOUNT_TYPE_EMAIL {
+		return errors.New("account is not email type")
+	}
+	if len(u.Profiles) > 0 && u.Profiles[0].Alias == u.Account.AccountID {
+		u.Profiles[0].Alias = username
+	}
+	u.Account.Type = ACCOUNT_TYPE_USERNAME
+	u.Account.AccountID = username
+	return nil
+}
+
+// SetEmailAccount turns a username account into an email account, with one of its confirmed email addresses as account ID
+func (u *User) SetEmailAccount(email string) error {
+	if u.Account.Type != ACCOUNT_TYPE_USERNAME {
+		return errors.New("account is not username type")
+	}
+	ci, found := u.FindContactInfoByTypeAndAddr("email", email)
+	if !found || ci.ConfirmedAt <= 0 {
+		return ErrEmailNotConfirmed
+	}
+	if len(u.Profiles) > 0 && u.Profiles[0].Alias == u.Account.AccountID {
+		u.Profiles[0].Alias = email
+	}
+	u.Account.Type = ACCOUNT_TYPE_EMAIL
+	u.Account.AccountID = email
+	u.Account.AccountConfirmedAt = ci.ConfirmedAt
+	return nil
+}
+
+// IsEmailConfirmed checks if addr is one of the user's confirmed email addresses
+func (u User) IsEmailConfirmed(addr string) bool {
+	ci, found := u.FindContactInfoByTypeAndAddr("email", addr)
+	return found && ci.ConfirmedAt > 0
+}
+
 func (u User) FindContactInfoByTypeAndAddr(t string, addr string) (ContactInfo, bool) {
 	for _, ci := range u.ContactInfos {
 		if t == "email" && ci.Email == addr {
@@ -180,6 +280,21 @@ func (u *User) UpdateProfile(p Profile) error {
 	for i, cP := range u.Profiles {
 		if cP.ID == p.ID {
 			p.MainProfile = cP.MainProfile
+			p.GuardianProfileID = cP.GuardianProfileID
+			// not part of the api message yet, keep them for clients that don't know them
+			if p.AvatarURL == "" {
+				p.AvatarURL = cP.AvatarURL
+			}
+			if p.Consents == nil {
+				p.Consents = cP.Consents
+			}
+			if p.PreferredLanguage == "" {
+				p.PreferredLanguage = cP.PreferredLanguage
+			}
+			if p.Birthdate == "" {
+				p.Birthdate = cP.Birthdate
+			}
+			p.KeepPreviousVersion(cP, time.Now().Unix())
 			u.Profiles[i] = p
 			return nil
 		}
@@ -187,6 +302,25 @@ func (u *User) UpdateProfile(p Profile) error {
 	return errors.New("profile with given ID not found")
 }
 
+// RestorePreviousProfileVersion replaces the profile with its previous version, the main profile flag and the guardian
+// link are kept
+func (u *User) RestorePreviousProfileVersion(id string) error {
+	for i, cP := range u.Profiles {
+		if cP.ID.Hex() != id {
+			continue
+		}
+		if cP.PreviousVersion == nil {
+			return errors.New("no previous version of the profile")
+		}
+		previous := cP.PreviousVersion.Profile
+		previous.MainProfile = cP.MainProfile
+		previous.GuardianProfileID = cP.GuardianProfileID
+		u.Profiles[i] = previous
+		return nil
+	}
+	return errors.New("profile with given ID not found")
+}
+
 // FindProfile finds a profile in the user's array
 func (u User) FindProfile(id string) (Profile, error) {
 	for _, cP := range u.Profiles {
@@ -197,7 +331,16 @@ func (u User) FindProfile(id string) (Profile, error) {
 	return Profile{}, errors.New("profile with given ID not found")
 }
 
-// RemoveProfile finds and removes profile from the user's array
+// PreferredLanguageForProfile returns the language of the profile if set, otherwise the account language
+func (u User) PreferredLanguageForProfile(profileID string) string {
+	p, err := u.FindProfile(profileID)
+	if err == nil && p.PreferredLanguage != "" {
+		return p.PreferredLanguage
+	}
+	return u.Account.PreferredLanguage
+}
+
+// RemoveProfile finds and removes profile from the user's array. Child profiles of a removed guardian are unlinked.
 func (u *User) RemoveProfile(id string) error {
 	for i, cP := range u.Profiles {
 		if cP.ID.Hex() == id {
@@ -205,12 +348,170 @@ func (u *User) RemoveProfile(id string) error {
 				return errors.New("cannot remove main profile")
 			}
 			u.Profiles = append(u.Profiles[:i], u.Profiles[i+1:]...)
+			for j := range u.Profiles {
+				if u.Profiles[j].GuardianProfileID == id {
+					u.Profiles[j].GuardianProfileID = ""
+				}
+			}
 			return nil
 		}
 	}
 	return errors.New("profile with given ID not found")
 }
 
+// IsChildProfile is true if the profile with the given ID is linked to a guardian profile
+func (u User) IsChildProfile(id string) bool {
+	p, err := u.FindProfile(id)
+	return err == nil && p.IsChild()
+}
+
+// LinkChildProfile makes the profile childID a child profile managed by guardianID. The main profile, as account
+// holder, can't be a child, and links are one level deep: a guardian can't be a child and a child can't be a guardian.
+func (u *User) LinkChildProfile(childID string, guardianID string) error {
+	if childID == guardianID {
+		return errors.New("profile can't be its own guardian")
+	}
+	guardian, err := u.FindProfile(guardianID)
+	if err != nil {
+		return errors.New("guardian profile not found")
+	}
+	if guardian.IsChild() {
+		return errors.New("guardian can't be a child profile")
+	}
+	for i, cP := range u.Profiles {
+		if cP.GuardianProfileID == childID {
+			return errors.New("profile is the guardian of other profiles")
+		}
+		if cP.ID.Hex() != childID {
+			continue
+		}
+		if cP.MainProfile {
+			return errors.New("main profile can't be a child profile")
+		}
+		u.Profiles[i].GuardianProfileID = guardianID
+	}
+	if !u.IsChildProfile(childID) {
+		return errors.New("profile with given ID not found")
+	}
+	return nil
+}
+
+// UnlinkChildProfile removes the guardian link of the profile
+func (u *User) UnlinkChildProfile(childID string) error {
+	for i, cP := range u.Profiles {
+		if cP.ID.Hex() == childID {
+			if !cP.IsChild() {
+				return errors.New("profile is not a child profile")
+			}
+			u.Profiles[i].GuardianProfileID = ""
+			return nil
+		}
+	}
+	return errors.New("profile with given ID not found")
+}
+
+// ReorderProfiles arranges the user's profiles in the order of the given IDs, which must contain each
+// profile exactly once. The first profile becomes the main profile, it can't be a child profile.
+func (u *User) ReorderProfiles(ids []string) error {
+	if len(ids) != len(u.Profiles) {
+		return errors.New("profile ids do not match")
+	}
+	reordered := make([]Profile, len(ids))
+	used := map[string]bool{}
+	for i, id := range ids {
+		if used[id] {
+			return errors.New("profile ids do not match")
+		}
+		p, err := u.FindProfile(id)
+		if err != nil {
+			return errors.New("profile ids do not match")
+		}
+		if i == 0 && p.IsChild() {
+			return errors.New("main profile can't be a child profile")
+		}
+		used[id] = true
+		p.MainProfile = i == 0
+		reordered[i] = p
+	}
+	u.Profiles = reordered
+	return nil
+}
+
+// AddLoginRecord appends the login to the history. Only the latest maxEntries logins are kept,
+// and logins older than retention are removed (no age limit if retention is 0).
+func (u *User) AddLoginRecord(record LoginRecord, maxEntries int, retention time.Duration) {
+	u.RecentLogins = append(u.RecentLogins, record)
+	if retention > 0 {
+		minTime := time.Now().Add(-retention).Unix()
+		kept := []LoginRecord{}
+		for _, r := range u.RecentLogins {
+			if r.Time >= minTime {
+				kept = append(kept, r)
+			}
+		}
+		u.RecentLogins = kept
+	}
+	if len(u.RecentLogins) > maxEntries {
+		u.RecentLogins = u.RecentLogins[len(u.RecentLogins)-maxEntries:]
+	}
+}
+
+// Anonymize removes the personal data of the user. The user ID and the profile IDs are kept, so the
+// user still resolves and the study data stays linked to the profiles.
+func (u *User) Anonymize() {
+	u.Account = Account{
+		Type:      u.Account.Type,
+		AccountID: "anonymized-" + u.ID.Hex(), // account IDs are unique
+	}
+	for i := range u.Profiles {
+		u.Profiles[i].Alias = ""
+		u.Profiles[i].AvatarID = ""
+		u.Profiles[i].AvatarURL = ""
+		u.Profiles[i].Consents = nil
+		u.Profiles[i].Birthdate = ""
+		u.Profiles[i].PreviousVersion = nil // would keep the alias before the last change
+	}
+	u.ContactInfos = []ContactInfo{}
+	u.ContactPreferences = ContactPreferences{SendNewsletterTo: []string{}}
+	u.RecentLogins = nil
+	u.Timestamps.MarkedForDeletion = 0
+	u.Timestamps.AnonymizedAt = time.Now().Unix()
+}
+
+// IsAnonymized tells if the personal data of the user has been removed
+func (u User) IsAnonymized() bool {
+	return u.Timestamps.AnonymizedAt > 0
+}
+
+// PolicyAcceptance records which version of a policy (terms of service, privacy policy) the user accepted
+type PolicyAcceptance struct {
+	Version    string `bson:"version"`
+	AcceptedAt int64  `bson:"acceptedAt"`
+}
+
+// AcceptPolicy records the version of the policy, replacing a previous one
+func (u *User) AcceptPolicy(key string, version string, acceptedAt int64) {
+	accepted := make(map[string]PolicyAcceptance, len(u.AcceptedPolicies)+1)
+	for k, a := range u.AcceptedPolicies {
+		accepted[k] = a
+	}
+	accepted[key] = PolicyAcceptance{Version: version, AcceptedAt: acceptedAt}
+	u.AcceptedPolicies = accepted
+}
+
+// MissingPolicyAcceptances returns the keys of the policies whose current version, given by policy key, was not
+// accepted by the user, sorted
+func (u User) MissingPolicyAcceptances(currentVersions map[string]string) []string {
+	missing := []string{}
+	for key, version := range currentVersions {
+		if u.AcceptedPolicies[key].Version != version {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
 // Timestamps describes metadata for the User
 // createdAt contains the account creation time, an offset is added if this account is created by admin, to reduce
 // risk this account to be deleled if account verification is not done in time (use case of migration when users are invited from previous platfom).
@@ -223,6 +524,10 @@ type Timestamps struct {
 	LastPasswordChange      int64 `bson:"lastPasswordChange"`
 	ReminderToConfirmSentAt int64 `bson:"reminderToConfirmSentAt"`
 	MarkedForDeletion       int64 `bson:"markedForDeletion"`
+	AnonymizedAt            int64 `bson:"anonymizedAt,omitempty"`
+	LastStrongAuth          int64 `bson:"lastStrongAuth,omitempty"`          // last authentication with password or external IdP, not token refresh
+	InactivityWarningSentAt int64 `bson:"inactivityWarningSentAt,omitempty"` // first warning before the account is marked for deletion
+	DeletionReminderSentFor int64 `bson:"deletionReminderSentFor,omitempty"` // deletion time the user was last reminded of
 }
 
 // ToAPI converts the object from DB to API format
@@ -0,0 +1,46 @@
+package models
+
+// CurrentUserSchemaVersion is the shape of the user documents expected by this version of the service
+const CurrentUserSchemaVersion = 1
+
+// userMigrations upgrade a user by one schema version, userMigrations[i] from version i to i+1. Each returns the
+// changed fields as bson paths, so they can be persisted without replacing the whole document.
+var userMigrations = []func(u *User) map[string]interface{}{
+	migrateUserToV1,
+}
+
+// Migrate upgrades a user read from the DB to CurrentUserSchemaVersion. It returns the changed fields as bson paths,
+// nil if the user already has the current shape.
+func (u *User) Migrate() map[string]interface{} {
+	if u.SchemaVersion >= CurrentUserSchemaVersion {
+		return nil
+	}
+	changes := map[string]interface{}{}
+	for v := u.SchemaVersion; v < CurrentUserSchemaVersion; v++ {
+		for path, value := range userMigrations[v](u) {
+			changes[path] = value
+		}
+	}
+	u.SchemaVersion = CurrentUserSchemaVersion
+	changes["schemaVersion"] = u.SchemaVersion
+	return changes
+}
+
+// migrateUserToV1 fills the newsletter topic from subscribedToNewsletter, and replaces missing login and password reset
+// histories, which could not be pushed to, with empty lists
+func migrateUserToV1(u *User) map[string]interface{} {
+	changes := map[string]interface{}{}
+	if _, ok := u.ContactPreferences.SubscribedTopics[TOPIC_NEWSLETTER]; !ok {
+		u.ContactPreferences.SetTopicSubscription(TOPIC_NEWSLETTER, u.ContactPreferences.SubscribedToNewsletter)
+		changes["contactPreferences.subscribedTopics."+TOPIC_NEWSLETTER] = u.ContactPreferences.SubscribedToNewsletter
+	}
+	if u.Account.FailedLoginAttempts == nil {
+		u.Account.FailedLoginAttempts = []int64{}
+		changes["account.failedLoginAttempts"] = u.Account.FailedLoginAttempts
+	}
+	if u.Account.PasswordResetTriggers == nil {
+		u.Account.PasswordResetTriggers = []int64{}
+		changes["account.passwordResetTriggers"] = u.Account.PasswordResetTriggers
+	}
+	return changes
+}
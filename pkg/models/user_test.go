@@ -0,0 +1,300 @@
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPreferredLanguageForProfile(t *testing.T) {
+	user := User{
+		Account: Account{PreferredLanguage: "de"},
+		Profiles: []Profile{
+			{ID: primitive.NewObjectID(), MainProfile: true},
+			{ID: primitive.NewObjectID(), PreferredLanguage: "fr"},
+		},
+	}
+
+	t.Run("without override", func(t *testing.T) {
+		if lang := user.PreferredLanguageForProfile(user.Profiles[0].ID.Hex()); lang != "de" {
+			t.Errorf("unexpected language: %s", lang)
+		}
+	})
+
+	t.Run("with override", func(t *testing.T) {
+		if lang := user.PreferredLanguageForProfile(user.Profiles[1].ID.Hex()); lang != "fr" {
+			t.Errorf("unexpected language: %s", lang)
+		}
+	})
+
+	t.Run("with unknown profile", func(t *testing.T) {
+		if lang := user.PreferredLanguageForProfile(primitive.NewObjectID().Hex()); lang != "de" {
+			t.Errorf("unexpected language: %s", lang)
+		}
+	})
+}
+
+func TestChangeAccountType(t *testing.T) {
+	t.Run("email to username", func(t *testing.T) {
+		user := User{
+			Account:  Account{Type: ACCOUNT_TYPE_EMAIL, AccountID: "test@test.com"},
+			Profiles: []Profile{{Alias: "test@test.com"}},
+		}
+		if err := user.SetUsernameAccount("testuser"); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Account.Type != ACCOUNT_TYPE_USERNAME || user.Account.AccountID != "testuser" || user.Profiles[0].Alias != "testuser" {
+			t.Errorf("unexpected user: %v", user)
+		}
+		if err := user.SetUsernameAccount("testuser2"); err == nil {
+			t.Error("username account should not be changed again")
+		}
+	})
+
+	t.Run("username to email", func(t *testing.T) {
+		user := User{
+			Account: Account{Type: ACCOUNT_TYPE_USERNAME, AccountID: "testuser"},
+			ContactInfos: []ContactInfo{
+				{Type: "email", Email: "confirmed@test.com", ConfirmedAt: 100},
+				{Type: "email", Email: "unconfirmed@test.com"},
+			},
+		}
+		if err := user.SetEmailAccount("unconfirmed@test.com"); err != ErrEmailNotConfirmed {
+			t.Error("unconfirmed email should be rejected")
+		}
+		if err := user.SetEmailAccount("unknown@test.com"); err == nil {
+			t.Error("unknown email should be rejected")
+		}
+		if err := user.SetEmailAccount("confirmed@test.com"); err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if user.Account.Type != ACCOUNT_TYPE_EMAIL || user.Account.AccountID != "confirmed@test.com" || user.Account.AccountConfirmedAt != 100 {
+			t.Errorf("unexpected account: %v", user.Account)
+		}
+	})
+}
+
+func TestNotificationEmailOfUsernameAccount(t *testing.T) {
+	user := User{
+		Account: Account{Type: ACCOUNT_TYPE_USERNAME, AccountID: "testuser"},
+		ContactInfos: []ContactInfo{
+			{Type: "email", Email: "unconfirmed@test.com"},
+			{Type: "email", Email: "confirmed@test.com", ConfirmedAt: 100},
+		},
+	}
+	if email := user.NotificationEmail(); email != "confirmed@test.com" {
+		t.Errorf("unexpected notification email: %s", email)
+	}
+}
+
+func TestUserMigrate(t *testing.T) {
+	t.Run("old document", func(t *testing.T) {
+		var user User
+		raw, err := bson.Marshal(bson.M{
+			"account": bson.M{"accountID": "old@test.com", "failedLoginAttempts": nil},
+			"contactPreferences": bson.M{
+				"subscribedToNewsletter": true,
+				"subscribedTopics":       bson.M{TOPIC_SURVEYS: true},
+			},
+			"version": 3,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := bson.Unmarshal(raw, &user); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		changes := user.Migrate()
+		if user.SchemaVersion != CurrentUserSchemaVersion || changes["schemaVersion"] != CurrentUserSchemaVersion {
+			t.Errorf("unexpected schema version: %d, %v", user.SchemaVersion, changes)
+		}
+		if !user.ContactPreferences.SubscribedTopics[TOPIC_NEWSLETTER] || !user.ContactPreferences.SubscribedTopics[TOPIC_SURVEYS] {
+			t.Errorf("unexpected topics: %v", user.ContactPreferences.SubscribedTopics)
+		}
+		if user.Account.FailedLoginAttempts == nil || user.Account.PasswordResetTriggers == nil {
+			t.Errorf("histories should be initialised: %+v", user.Account)
+		}
+		if len(changes) != 4 || changes["contactPreferences.subscribedTopics."+TOPIC_NEWSLETTER] != true {
+			t.Errorf("unexpected changes: %v", changes)
+		}
+		if user.Version != 3 {
+			t.Errorf("version should be kept: %d", user.Version)
+		}
+	})
+
+	t.Run("current document", func(t *testing.T) {
+		user := User{SchemaVersion: CurrentUserSchemaVersion}
+		if changes := user.Migrate(); changes != nil {
+			t.Errorf("unexpected changes: %v", changes)
+		}
+		if user.Account.FailedLoginAttempts != nil {
+			t.Error("current document should not be changed")
+		}
+	})
+}
+
+func TestRestorePreviousProfileVersion(t *testing.T) {
+	id := primitive.NewObjectID()
+	user := User{Profiles: []Profile{{ID: id, Alias: "before", AvatarID: "cat", MainProfile: true}}}
+
+	if err := user.RestorePreviousProfileVersion(id.Hex()); err == nil {
+		t.Error("expected error without previous version")
+	}
+
+	if err := user.UpdateProfile(Profile{ID: id, Alias: "after"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := user.UpdateProfile(Profile{ID: id, Alias: "latest"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prev := user.Profiles[0].PreviousVersion; prev == nil || prev.Profile.Alias != "after" || prev.Profile.PreviousVersion != nil {
+		t.Errorf("only the previous version should be kept: %+v", prev)
+	}
+
+	if err := user.RestorePreviousProfileVersion(id.Hex()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p := user.Profiles[0]; p.Alias != "after" || !p.MainProfile || p.PreviousVersion != nil {
+		t.Errorf("unexpected profile: %+v", p)
+	}
+}
+
+func TestMissingPolicyAcceptances(t *testing.T) {
+	currentVersions := map[string]string{"terms": "2", "privacy": "1"}
+
+	t.Run("without acceptance", func(t *testing.T) {
+		missing := User{}.MissingPolicyAcceptances(currentVersions)
+		if len(missing) != 2 || missing[0] != "privacy" || missing[1] != "terms" {
+			t.Errorf("unexpected missing policies: %v", missing)
+		}
+	})
+
+	t.Run("with outdated version", func(t *testing.T) {
+		user := User{}
+		user.AcceptPolicy("terms", "1", 10)
+		user.AcceptPolicy("privacy", "1", 10)
+		missing := user.MissingPolicyAcceptances(currentVersions)
+		if len(missing) != 1 || missing[0] != "terms" {
+			t.Errorf("unexpected missing policies: %v", missing)
+		}
+	})
+
+	t.Run("with current versions", func(t *testing.T) {
+		user := User{}
+		user.AcceptPolicy("terms", "1", 10)
+		user.AcceptPolicy("terms", "2", 20)
+		user.AcceptPolicy("privacy", "1", 20)
+		if missing := user.MissingPolicyAcceptances(currentVersions); len(missing) != 0 {
+			t.Errorf("unexpected missing policies: %v", missing)
+		}
+		if user.AcceptedPolicies["terms"].AcceptedAt != 20 {
+			t.Errorf("unexpected acceptance: %v", user.AcceptedPolicies["terms"])
+		}
+	})
+}
+
+func TestLinkChildProfile(t *testing.T) {
+	newUser := func() User {
+		return User{Profiles: []Profile{
+			{ID: primitive.NewObjectID(), MainProfile: true},
+			{ID: primitive.NewObjectID()},
+			{ID: primitive.NewObjectID()},
+		}}
+	}
+
+	t.Run("link and unlink", func(t *testing.T) {
+		user := newUser()
+		guardianID, childID := user.Profiles[0].ID.Hex(), user.Profiles[1].ID.Hex()
+		if err := user.LinkChildProfile(childID, guardianID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !user.IsChildProfile(childID) || user.IsChildProfile(guardianID) {
+			t.Errorf("unexpected profiles: %+v", user.Profiles)
+		}
+		if err := user.UpdateProfile(Profile{ID: user.Profiles[1].ID, Alias: "child"}); err != nil || !user.IsChildProfile(childID) {
+			t.Errorf("link should be kept on update: %v", err)
+		}
+		if err := user.UnlinkChildProfile(childID); err != nil || user.IsChildProfile(childID) {
+			t.Errorf("unexpected result: %v", err)
+		}
+		if err := user.UnlinkChildProfile(childID); err == nil {
+			t.Error("expected error for a profile without guardian")
+		}
+	})
+
+	t.Run("invalid links", func(t *testing.T) {
+		user := newUser()
+		mainID, firstID, secondID := user.Profiles[0].ID.Hex(), user.Profiles[1].ID.Hex(), user.Profiles[2].ID.Hex()
+		if err := user.LinkChildProfile(mainID, firstID); err == nil {
+			t.Error("main profile can't be a child")
+		}
+		if err := user.LinkChildProfile(firstID, firstID); err == nil {
+			t.Error("profile can't be its own guardian")
+		}
+		if err := user.LinkChildProfile(primitive.NewObjectID().Hex(), mainID); err == nil {
+			t.Error("expected error for unknown profile")
+		}
+		if err := user.LinkChildProfile(firstID, mainID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := user.LinkChildProfile(secondID, firstID); err == nil {
+			t.Error("child can't be a guardian")
+		}
+		if err := user.ReorderProfiles([]string{firstID, mainID, secondID}); err == nil {
+			t.Error("child can't become the main profile")
+		}
+	})
+
+	t.Run("removing the guardian unlinks its children", func(t *testing.T) {
+		user := newUser()
+		guardianID, childID := user.Profiles[1].ID.Hex(), user.Profiles[2].ID.Hex()
+		if err := user.LinkChildProfile(childID, guardianID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := user.LinkChildProfile(guardianID, user.Profiles[0].ID.Hex()); err == nil {
+			t.Error("guardian can't become a child")
+		}
+		if err := user.RemoveProfile(guardianID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if user.IsChildProfile(childID) {
+			t.Errorf("unexpected profiles: %+v", user.Profiles)
+		}
+	})
+}
+
+func TestAnonymize(t *testing.T) {
+	user := User{
+		ID:      primitive.NewObjectID(),
+		Account: Account{Type: ACCOUNT_TYPE_EMAIL, AccountID: "anonymize@test.com", Password: "hashed"},
+		Profiles: []Profile{
+			{
+				ID:          primitive.NewObjectID(),
+				Alias:       "current alias",
+				AvatarURL:   "https://example.com/avatar.png",
+				MainProfile: true,
+				Consents:    map[string]ProfileConsent{"study": {Version: "1", ConfirmedAt: 1}},
+				Birthdate:   "2000-01-31",
+				PreviousVersion: &ProfileSnapshot{
+					Profile: Profile{Alias: "previous alias"},
+				},
+			},
+		},
+	}
+	user.Anonymize()
+
+	if user.Account.AccountID == "anonymize@test.com" || user.Account.Password != "" {
+		t.Errorf("account not anonymized: %+v", user.Account)
+	}
+	p := user.Profiles[0]
+	if p.Alias != "" || p.PreviousVersion != nil || p.AvatarURL != "" || len(p.Consents) != 0 || p.Birthdate != "" {
+		t.Errorf("profile not anonymized: %+v", p)
+	}
+	if !user.IsAnonymized() {
+		t.Error("user should be marked as anonymized")
+	}
+}
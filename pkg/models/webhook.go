@@ -0,0 +1,21 @@
+package models
+
+// webhook event types, see WebhookEvent
+const (
+	WEBHOOK_EVENT_ACCOUNT_CREATED = "account.created"
+	WEBHOOK_EVENT_ACCOUNT_DELETED = "account.deleted"
+	WEBHOOK_EVENT_EMAIL_CONFIRMED = "email.confirmed"
+)
+
+// WebhookEvent is the JSON payload posted to the webhook on user lifecycle events
+type WebhookEvent struct {
+	Type       string `json:"type"`
+	UserID     string `json:"userId"`
+	InstanceID string `json:"instanceId"`
+	Timestamp  int64  `json:"timestamp"` // unix seconds
+}
+
+// WebhookNotifier delivers webhook events, best-effort and without blocking the caller
+type WebhookNotifier interface {
+	Notify(event WebhookEvent)
+}
@@ -0,0 +1,76 @@
+package timer_event
+
+import (
+	"context"
+	"time"
+
+	"github.com/coneno/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	expiredTempTokenGracePeriod = 3600 // seconds, expired temp tokens are kept this long to report them as expired
+	orphanedTempTokenBatchSize  = 500  // user IDs checked per query
+)
+
+// CleanUpTempTokens removes the expired temp tokens, and the temp tokens of users that do not exist anymore
+func (s *UserManagementTimerService) CleanUpTempTokens(ctx context.Context) {
+	logger.Debug.Println("Starting clean up job for temp tokens:")
+	if err := s.globalDBService.DeleteTempTokensExpireBefore("", "", time.Now().Unix()-expiredTempTokenGracePeriod); err != nil {
+		logger.Error.Printf("unexpected error while deleting expired temp tokens: %v", err)
+	}
+
+	instances, err := s.globalDBService.GetAllInstances()
+	if err != nil {
+		logger.Error.Printf("unexpected error: %s", err.Error())
+		return
+	}
+	for _, instance := range instances {
+		count, err := s.CleanUpOrphanedTempTokensOfInstance(ctx, instance.InstanceID)
+		if err != nil {
+			logger.Error.Printf("%s: unexpected error while deleting orphaned temp tokens: %v", instance.InstanceID, err)
+			continue
+		}
+		if count > 0 {
+			logger.Info.Printf("%s: removed %d temp tokens of deleted users", instance.InstanceID, count)
+		}
+	}
+}
+
+// CleanUpOrphanedTempTokensOfInstance removes the temp tokens referencing users that are not in the users DB of the instance
+func (s *UserManagementTimerService) CleanUpOrphanedTempTokensOfInstance(ctx context.Context, instanceID string) (int64, error) {
+	userIDs, err := s.globalDBService.GetTempTokenUserIDs(instanceID)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for start := 0; start < len(userIDs); start += orphanedTempTokenBatchSize {
+		end := start + orphanedTempTokenBatchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		batch := userIDs[start:end]
+		existing, err := s.userDBService.GetExistingUserIDs(ctx, instanceID, batch)
+		if err != nil {
+			return count, err
+		}
+		orphaned := []string{}
+		for _, id := range batch {
+			// tokens not referencing a user by its ID are left alone
+			if _, err := primitive.ObjectIDFromHex(id); err != nil || existing[id] {
+				continue
+			}
+			orphaned = append(orphaned, id)
+		}
+		if len(orphaned) == 0 {
+			continue
+		}
+		deleted, err := s.globalDBService.DeleteTempTokensOfUsers(instanceID, orphaned)
+		if err != nil {
+			return count, err
+		}
+		count += deleted
+	}
+	return count, nil
+}
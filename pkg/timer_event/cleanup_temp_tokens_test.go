@@ -0,0 +1,66 @@
+package timer_event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/influenzanet/go-utils/pkg/constants"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCleanUpTempTokens(t *testing.T) {
+	s := UserManagementTimerService{
+		globalDBService: testGlobalDBService,
+		userDBService:   testUserDBService,
+	}
+
+	userID, err := testUserDBService.AddUser(context.Background(), testInstanceID, models.User{
+		Account: models.Account{Type: "email", AccountID: "test-temp-token-cleanup@test.com"},
+	})
+	if err != nil {
+		t.Errorf("failed to create testuser: %s", err.Error())
+		return
+	}
+	deletedUserID := primitive.NewObjectID().Hex()
+
+	now := time.Now().Unix()
+	addToken := func(userID string, expiration int64) string {
+		token, err := testGlobalDBService.AddTempToken(models.TempToken{
+			UserID:     userID,
+			InstanceID: testInstanceID,
+			Purpose:    constants.TOKEN_PURPOSE_CONTACT_VERIFICATION,
+			Expiration: expiration,
+		})
+		if err != nil {
+			t.Errorf("failed to create temp token: %s", err.Error())
+		}
+		return token
+	}
+	validToken := addToken(userID, now+3600)
+	expiredToken := addToken(userID, now-2*expiredTempTokenGracePeriod)
+	orphanedToken := addToken(deletedUserID, now+3600)
+	noUserToken := addToken("", now+3600)
+
+	s.CleanUpTempTokens(context.Background())
+
+	for _, c := range []struct {
+		name    string
+		token   string
+		removed bool
+	}{
+		{name: "valid token", token: validToken, removed: false},
+		{name: "expired token", token: expiredToken, removed: true},
+		{name: "token of deleted user", token: orphanedToken, removed: true},
+		{name: "token without user", token: noUserToken, removed: false},
+	} {
+		_, err := testGlobalDBService.GetTempToken(c.token)
+		if c.removed && err == nil {
+			t.Errorf("%s should be removed", c.name)
+		}
+		if !c.removed && err != nil {
+			t.Errorf("%s should be kept: %v", c.name, err)
+		}
+	}
+}
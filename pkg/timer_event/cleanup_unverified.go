@@ -1,30 +1,55 @@
 package timer_event
 
 import (
+	"context"
 	"time"
 
 	"github.com/coneno/logger"
+	"github.com/influenzanet/user-management-service/pkg/models"
 )
 
 // CleanUpUnverifiedUsers handles the deletion of unverified accounts after a threshold delay
 func (s *UserManagementTimerService) CleanUpUnverifiedUsers() {
 	logger.Debug.Println("Starting clean up job for unverified users:")
+	ctx := context.Background()
 	instances, err := s.globalDBService.GetAllInstances()
 	if err != nil {
 		logger.Error.Printf("unexpected error: %s", err.Error())
 	}
 	deleteUnverifiedUsersAfter := s.CleanUpTimeThreshold
 	for _, instance := range instances {
-		count, err := s.userDBService.DeleteUnverfiedUsers(instance.InstanceID, time.Now().Unix()-deleteUnverifiedUsersAfter)
+		count, _, err := s.CleanUpUnverifiedUsersOfInstance(ctx, instance.InstanceID, time.Now().Unix()-deleteUnverifiedUsersAfter)
 		if err != nil {
 			logger.Error.Printf("unexpected error: %s", err.Error())
 			continue
 		}
+		action := "removed"
+		if s.CleanupDryRun {
+			action = "dry run, would remove"
+		}
 		if count > 0 {
-			logger.Info.Printf("%s: removed %d unverified accounts", instance.InstanceID, count)
+			logger.Info.Printf("%s: %s %d unverified accounts", instance.InstanceID, action, count)
 		} else {
-			logger.Debug.Printf("%s: removed %d unverified accounts", instance.InstanceID, count)
+			logger.Debug.Printf("%s: %s %d unverified accounts", instance.InstanceID, action, count)
 		}
 
 	}
 }
+
+// CleanUpUnverifiedUsersOfInstance deletes the accounts not verified before createdBefore. In dry run mode, nothing is deleted,
+// the accounts that would be removed are logged and returned.
+func (s *UserManagementTimerService) CleanUpUnverifiedUsersOfInstance(ctx context.Context, instanceID string, createdBefore int64) (int64, []models.User, error) {
+	if !s.CleanupDryRun {
+		count, err := s.userDBService.DeleteUnverfiedUsers(ctx, instanceID, createdBefore)
+		return count, nil, err
+	}
+
+	users, err := s.userDBService.FindUnverifiedUsers(ctx, instanceID, createdBefore)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, u := range users {
+		logger.Info.Printf("%s: dry run, would remove unverified account with user ID %s", instanceID, u.ID.Hex())
+	}
+	return int64(len(users)), users, nil
+}
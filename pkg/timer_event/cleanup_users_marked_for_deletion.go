@@ -2,101 +2,173 @@ package timer_event
 
 import (
 	"context"
+	"time"
 
 	"github.com/coneno/logger"
 	"github.com/influenzanet/go-utils/pkg/api_types"
 	"github.com/influenzanet/go-utils/pkg/constants"
 	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
 	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/utils"
 )
 
+const deletionProgressLogInterval = 100 // log progress after this many processed users
+
 // CleanupUsersMarkedForDeletion handles the deletion of accounts that did not react to reminder mail
-func (s *UserManagementTimerService) CleanupUsersMarkedForDeletion() {
+func (s *UserManagementTimerService) CleanupUsersMarkedForDeletion(ctx context.Context) {
 	logger.Debug.Println("Starting clean up job for users marked for deletion:")
 	instances, err := s.globalDBService.GetAllInstances()
 	if err != nil {
 		logger.Error.Printf("unexpected error: %s", err.Error())
 	}
 	for _, instance := range instances {
-		users, err := s.userDBService.FindUsersMarkedForDeletion(instance.InstanceID)
-		count := 0
-
+		count, err := s.cleanupUsersMarkedForDeletionOfInstance(ctx, instance.InstanceID)
 		if err != nil {
-			logger.Error.Printf("unexpected error: %s", err.Error())
+			logger.Error.Printf("%s: clean up of inactive accounts stopped after %d accounts: %s", instance.InstanceID, count, err.Error())
+			if ctx.Err() != nil {
+				return
+			}
 			continue
 		}
-		for _, u := range users {
+		action := "removed"
+		if s.CleanupDryRun {
+			action = "dry run, would remove"
+		}
+		if count > 0 {
+			logger.Info.Printf("%s: %s %d inactive accounts", instance.InstanceID, action, count)
+		} else {
+			logger.Debug.Printf("%s: %s %d inactive accounts", instance.InstanceID, action, count)
+		}
+	}
+}
 
-			//notify study service
-			mainProfileID, otherProfileIDs := utils.GetMainAndOtherProfiles(u)
-			userProfileIDs := []string{mainProfileID}
-			userProfileIDs = append(userProfileIDs, otherProfileIDs...)
-			token := &api_types.TokenInfos{
-				Id:              u.ID.Hex(),
-				InstanceId:      instance.InstanceID,
-				ProfilId:        mainProfileID,
-				OtherProfileIds: otherProfileIDs,
-			}
-			studyServiceError := error(nil)
-			for _, profileId := range userProfileIDs {
-				token.ProfilId = profileId
-				if _, err := s.clients.StudyService.ProfileDeleted(context.Background(), token); err != nil {
-					logger.Error.Printf("failed to notify study service: %s", err.Error())
-					studyServiceError = err
-					continue
-				}
-			}
-			if studyServiceError != nil {
-				logger.Error.Printf("failed to notify study service: %s", studyServiceError.Error())
-				continue
-			}
-			err := s.globalDBService.DeleteAllTempTokenForUser(instance.InstanceID, u.ID.Hex(), "")
-			if err != nil {
-				logger.Error.Printf("error, when trying to remove temp-tokens: %s", err.Error())
-				continue
-			}
-			_, err = s.userDBService.DeleteRenewTokensForUser(instance.InstanceID, u.ID.Hex())
-			if err != nil {
-				logger.Error.Printf("error, when trying to remove renew tokens: %s", err.Error())
-				continue
-			}
-			err = s.userDBService.DeleteUser(instance.InstanceID, u.ID.Hex())
-			if err != nil {
-				logger.Error.Printf("error, when trying to delete user: %s", err.Error())
-				continue
-			}
-			// ---> Trigger message sending
-			_, err = s.clients.MessagingService.QueueEmailTemplateForSending(context.TODO(), &messageAPI.SendEmailReq{
-				InstanceId:        instance.InstanceID,
-				To:                []string{u.Account.AccountID},
-				MessageType:       constants.EMAIL_TYPE_ACCOUNT_DELETED_AFTER_INACTIVITY,
-				PreferredLanguage: u.Account.PreferredLanguage,
-				UseLowPrio:        true,
-			})
-			if err != nil {
-				logger.Error.Printf("DeleteAccount: %s", err.Error())
-			}
+// cleanupUsersMarkedForDeletionOfInstance goes through the users to delete one by one, until ctx is cancelled.
+// It returns the number of removed accounts.
+func (s *UserManagementTimerService) cleanupUsersMarkedForDeletionOfInstance(ctx context.Context, instanceID string) (int, error) {
+	total, err := s.userDBService.CountUsersMarkedForDeletion(ctx, instanceID)
+	if err != nil {
+		return 0, err
+	}
+	if total == 0 {
+		return 0, nil
+	}
 
-			_, err = s.clients.LoggingService.SaveLogEvent(context.TODO(), &loggingAPI.NewLogEvent{
-				Origin:     "user-management",
-				InstanceId: instance.InstanceID,
-				UserId:     u.ID.Hex(),
-				EventType:  loggingAPI.LogEventType_LOG,
-				EventName:  constants.LOG_EVENT_ACCOUNT_DELETED_AFTER_INACTIVITY,
-				Msg:        u.Account.AccountID,
-			})
-			if err != nil {
-				logger.Error.Printf("failed to save log: %s", err.Error())
-			}
-			logger.Info.Printf("%s: removed account with user ID %s", instance.InstanceID, u.ID.Hex())
+	processed := 0
+	count := 0
+	err = s.userDBService.PerformActionForUsersMarkedForDeletion(ctx, instanceID, func(instanceID string, u models.User, args ...interface{}) error {
+		if s.CleanupDryRun {
+			logger.Info.Printf("%s: dry run, would remove account with user ID %s", instanceID, u.ID.Hex())
+			count++
+		} else if s.removeUserMarkedForDeletion(ctx, instanceID, u) {
 			count++
 		}
-		if count > 0 {
-			logger.Info.Printf("%s: removed %d inactive accounts", instance.InstanceID, count)
-		} else {
-			logger.Debug.Printf("%s: removed %d inactive accounts", instance.InstanceID, count)
+		processed++
+		if processed%deletionProgressLogInterval == 0 {
+			logger.Info.Printf("%s: clean up of inactive accounts, processed %d/%d", instanceID, processed, total)
 		}
+		return nil
+	})
+	return count, err
+}
+
+// removeUserMarkedForDeletion deletes or anonymizes the account, errors are logged and the account is kept for the next run
+func (s *UserManagementTimerService) removeUserMarkedForDeletion(ctx context.Context, instanceID string, u models.User) bool {
+	anonymize := s.AnonymizeDeletedAccounts[instanceID]
 
+	// anonymized accounts keep their profiles, the study service has nothing to remove
+	if !anonymize {
+		mainProfileID, otherProfileIDs := utils.GetMainAndOtherProfiles(u)
+		userProfileIDs := []string{mainProfileID}
+		userProfileIDs = append(userProfileIDs, otherProfileIDs...)
+		token := &api_types.TokenInfos{
+			Id:              u.ID.Hex(),
+			InstanceId:      instanceID,
+			ProfilId:        mainProfileID,
+			OtherProfileIds: otherProfileIDs,
+		}
+		studyServiceError := error(nil)
+		for _, profileId := range userProfileIDs {
+			token.ProfilId = profileId
+			if _, err := s.clients.StudyService.ProfileDeleted(context.Background(), token); err != nil {
+				logger.Error.Printf("failed to notify study service: %s", err.Error())
+				studyServiceError = err
+				continue
+			}
+		}
+		if studyServiceError != nil {
+			logger.Error.Printf("failed to notify study service: %s", studyServiceError.Error())
+			return false
+		}
+	}
+	err := s.globalDBService.DeleteAllTempTokenForUser(instanceID, u.ID.Hex(), "")
+	if err != nil {
+		logger.Error.Printf("error, when trying to remove temp-tokens: %s", err.Error())
+		return false
+	}
+	_, err = s.userDBService.DeleteRenewTokensForUser(ctx, instanceID, u.ID.Hex())
+	if err != nil {
+		logger.Error.Printf("error, when trying to remove renew tokens: %s", err.Error())
+		return false
+	}
+	if anonymize {
+		_, err = s.userDBService.AnonymizeUser(ctx, instanceID, u.ID.Hex())
+	} else {
+		err = s.userDBService.DeleteUser(ctx, instanceID, u.ID.Hex())
+	}
+	if err != nil {
+		logger.Error.Printf("error, when trying to delete user: %s", err.Error())
+		return false
+	}
+	if u.Account.Type == models.ACCOUNT_TYPE_EMAIL {
+		s.recordReleasedEmail(instanceID, u.Account.AccountID)
+	}
+	// ---> Trigger message sending
+	err = s.queueEmail(context.TODO(), &messageAPI.SendEmailReq{
+		InstanceId:        instanceID,
+		To:                []string{u.NotificationEmail()},
+		MessageType:       constants.EMAIL_TYPE_ACCOUNT_DELETED_AFTER_INACTIVITY,
+		PreferredLanguage: u.Account.PreferredLanguage,
+		UseLowPrio:        true,
+	})
+	if err != nil {
+		logger.Error.Printf("DeleteAccount: %s", err.Error())
+	}
+
+	_, err = s.clients.LoggingService.SaveLogEvent(context.TODO(), &loggingAPI.NewLogEvent{
+		Origin:     "user-management",
+		InstanceId: instanceID,
+		UserId:     u.ID.Hex(),
+		EventType:  loggingAPI.LogEventType_LOG,
+		EventName:  constants.LOG_EVENT_ACCOUNT_DELETED_AFTER_INACTIVITY,
+		Msg:        u.Account.AccountID,
+	})
+	if err != nil {
+		logger.Error.Printf("failed to save log: %s", err.Error())
+	}
+	if s.clients.Webhooks != nil {
+		s.clients.Webhooks.Notify(models.WebhookEvent{
+			Type:       models.WEBHOOK_EVENT_ACCOUNT_DELETED,
+			UserID:     u.ID.Hex(),
+			InstanceID: instanceID,
+			Timestamp:  time.Now().Unix(),
+		})
+	}
+	logger.Info.Printf("%s: removed account with user ID %s", instanceID, u.ID.Hex())
+	return true
+}
+
+// recordReleasedEmail blocks the account ID of the deleted user for the released email cooldown of the instance, if it has one
+func (s *UserManagementTimerService) recordReleasedEmail(instanceID string, email string) {
+	conf, err := s.globalDBService.GetInstanceConfig(instanceID)
+	if err != nil {
+		logger.Error.Printf("couldn't read config of instance %s: %v", instanceID, err)
+		return
+	}
+	if conf.ReleasedEmailCooldown <= 0 {
+		return
+	}
+	if err := s.globalDBService.AddReleasedEmail(instanceID, email, time.Duration(conf.ReleasedEmailCooldown)*time.Second); err != nil {
+		logger.Error.Printf("couldn't record released email of instance %s: %v", instanceID, err)
 	}
 }
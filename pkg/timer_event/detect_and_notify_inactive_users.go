@@ -2,26 +2,36 @@ package timer_event
 
 import (
 	"context"
-	"time"
 
 	"github.com/coneno/logger"
 	"github.com/influenzanet/go-utils/pkg/constants"
 	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
 	"github.com/influenzanet/user-management-service/pkg/models"
-	"github.com/influenzanet/user-management-service/pkg/tokens"
 )
 
+// DetectAndNotifyInactiveUsers marks inactive accounts for deletion and notifies their users. With
+// FinalInactivityWarningThreshold, users get a first warning after NotifyInactiveUserThreshold of inactivity, and are
+// marked for deletion with the final warning if they are still inactive FinalInactivityWarningThreshold later.
 func (s *UserManagementTimerService) DetectAndNotifyInactiveUsers() {
 
 	logger.Debug.Println("Starting search and notify job for inactive users:")
+	ctx := context.Background()
 	instances, err := s.globalDBService.GetAllInstances()
 	if err != nil {
 		logger.Error.Printf("unexpected error: %s", err.Error())
 	}
 
 	for _, instance := range instances {
+		if s.FinalInactivityWarningThreshold > 0 {
+			s.warnInactiveUsers(ctx, instance.InstanceID)
+		}
 
-		users, err := s.userDBService.FindInactiveUsers(instance.InstanceID, s.NotifyInactiveUserThreshold)
+		var users []models.User
+		if s.FinalInactivityWarningThreshold > 0 {
+			users, err = s.userDBService.FindWarnedInactiveUsers(ctx, instance.InstanceID, s.now().Unix()-s.FinalInactivityWarningThreshold)
+		} else {
+			users, err = s.userDBService.FindInactiveUsersToWarn(ctx, instance.InstanceID, s.now().Unix()-s.NotifyInactiveUserThreshold)
+		}
 		count := 0
 		if err != nil {
 			logger.Error.Printf("unexpected error: %s", err.Error())
@@ -29,45 +39,9 @@ func (s *UserManagementTimerService) DetectAndNotifyInactiveUsers() {
 		}
 
 		for _, u := range users {
-			tempTokenInfos := models.TempToken{
-				UserID:     u.ID.Hex(),
-				InstanceID: instance.InstanceID,
-				Purpose:    constants.TOKEN_PURPOSE_INACTIVE_USER_NOTIFICATION,
-				Info: map[string]string{
-					"type":  models.ACCOUNT_TYPE_EMAIL,
-					"email": u.Account.AccountID,
-				},
-				Expiration: tokens.GetExpirationTime(time.Second * time.Duration(s.DeleteAccountAfterNotifyingThreshold)),
-			}
-			tempToken, err := s.globalDBService.AddTempToken(tempTokenInfos)
-			if err != nil {
-				logger.Error.Printf("failed to create verification token: %s", err.Error())
-				continue
+			if s.notifyInactiveUser(ctx, instance.InstanceID, u) {
+				count++
 			}
-			//send message
-			// ---> Trigger message sending
-			_, err = s.clients.MessagingService.QueueEmailTemplateForSending(context.TODO(), &messageAPI.SendEmailReq{
-				InstanceId:  instance.InstanceID,
-				To:          []string{u.Account.AccountID},
-				MessageType: constants.EMAIL_TYPE_ACCOUNT_INACTIVITY,
-				ContentInfos: map[string]string{
-					"token": tempToken,
-				},
-				PreferredLanguage: u.Account.PreferredLanguage,
-			})
-			if err != nil {
-				logger.Error.Printf("unexpected error: %v", err)
-				continue
-			}
-			succcess, err := s.userDBService.UpdateMarkedForDeletionTime(instance.InstanceID, u.ID.Hex(), s.DeleteAccountAfterNotifyingThreshold, false)
-			if err != nil {
-				logger.Error.Printf("unexpected error: %v", err)
-				continue
-			}
-			if !succcess { //markedForDeletion already set by other service
-				continue
-			}
-			count++
 		}
 		if count > 0 {
 			logger.Info.Printf("%s: notification mail will be sent to %d inactive accounts", instance.InstanceID, count)
@@ -76,3 +50,87 @@ func (s *UserManagementTimerService) DetectAndNotifyInactiveUsers() {
 		}
 	}
 }
+
+// warnInactiveUsers sends the first warning to the users inactive for NotifyInactiveUserThreshold
+func (s *UserManagementTimerService) warnInactiveUsers(ctx context.Context, instanceID string) {
+	now := s.now()
+	users, err := s.userDBService.FindInactiveUsersToWarn(ctx, instanceID, now.Unix()-s.NotifyInactiveUserThreshold)
+	if err != nil {
+		logger.Error.Printf("unexpected error: %s", err.Error())
+		return
+	}
+
+	count := 0
+	for _, u := range users {
+		if u.ContactPreferences.InQuietHours(now) {
+			// warned by a later run
+			continue
+		}
+		err := s.queueEmail(context.TODO(), &messageAPI.SendEmailReq{
+			InstanceId:        instanceID,
+			To:                []string{u.NotificationEmail()},
+			MessageType:       models.EMAIL_TYPE_INACTIVITY_WARNING,
+			PreferredLanguage: u.Account.PreferredLanguage,
+		})
+		if err != nil {
+			logger.Error.Printf("unexpected error: %v", err)
+			continue
+		}
+		if err := s.userDBService.UpdateInactivityWarningSentAt(ctx, instanceID, u.ID.Hex(), now.Unix()); err != nil {
+			logger.Error.Printf("unexpected error: %v", err)
+			continue
+		}
+		count++
+	}
+	if count > 0 {
+		logger.Info.Printf("%s: inactivity warning will be sent to %d accounts", instanceID, count)
+	}
+}
+
+// notifyInactiveUser sends the final notification, with a token to keep the account, and marks the account for
+// deletion after DeleteAccountAfterNotifyingThreshold. It returns false if the user was not notified.
+func (s *UserManagementTimerService) notifyInactiveUser(ctx context.Context, instanceID string, u models.User) bool {
+	now := s.now()
+	if u.ContactPreferences.InQuietHours(now) {
+		// notified by a later run
+		return false
+	}
+	deletionTime := now.Unix() + s.DeleteAccountAfterNotifyingThreshold
+	tempTokenInfos := models.TempToken{
+		UserID:     u.ID.Hex(),
+		InstanceID: instanceID,
+		Purpose:    constants.TOKEN_PURPOSE_INACTIVE_USER_NOTIFICATION,
+		Info: map[string]string{
+			"type":  models.ACCOUNT_TYPE_EMAIL,
+			"email": u.Account.AccountID,
+		},
+		Expiration: deletionTime,
+	}
+	tempToken, err := s.globalDBService.AddTempToken(tempTokenInfos)
+	if err != nil {
+		logger.Error.Printf("failed to create verification token: %s", err.Error())
+		return false
+	}
+	//send message
+	// ---> Trigger message sending
+	err = s.queueEmail(context.TODO(), &messageAPI.SendEmailReq{
+		InstanceId:  instanceID,
+		To:          []string{u.NotificationEmail()},
+		MessageType: constants.EMAIL_TYPE_ACCOUNT_INACTIVITY,
+		ContentInfos: map[string]string{
+			"token": tempToken,
+		},
+		PreferredLanguage: u.Account.PreferredLanguage,
+	})
+	if err != nil {
+		logger.Error.Printf("unexpected error: %v", err)
+		return false
+	}
+	succcess, err := s.userDBService.MarkForDeletionAt(ctx, instanceID, u.ID.Hex(), deletionTime)
+	if err != nil {
+		logger.Error.Printf("unexpected error: %v", err)
+		return false
+	}
+	//markedForDeletion already set by other service
+	return succcess
+}
@@ -0,0 +1,146 @@
+package timer_event
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/influenzanet/go-utils/pkg/constants"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	loggingMock "github.com/influenzanet/user-management-service/test/mocks/logging_service"
+	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
+	"google.golang.org/grpc"
+)
+
+func TestInactiveUserStages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+	mockLoggingClient := loggingMock.NewMockLoggingServiceApiClient(mockCtrl)
+
+	// emails sent per address, other users of the test instance may get some too
+	var mu sync.Mutex
+	sentEmails := map[string][]string{}
+	mockMessagingClient.EXPECT().QueueEmailTemplateForSending(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			sentEmails[req.To[0]] = append(sentEmails[req.To[0]], req.MessageType)
+			return nil, nil
+		}).AnyTimes()
+	mockLoggingClient.EXPECT().SaveLogEvent(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+
+	const day = int64(24 * 60 * 60)
+	// the fake clock starts in the past, so the deletion time is reached for the real clock at the end
+	start := time.Now().Unix() - 30*day
+	var now int64
+	s := UserManagementTimerService{
+		globalDBService:                      testGlobalDBService,
+		userDBService:                        testUserDBService,
+		clients:                              &models.APIClients{MessagingService: mockMessagingClient, LoggingService: mockLoggingClient},
+		NotifyInactiveUserThreshold:          10 * day,
+		FinalInactivityWarningThreshold:      5 * day,
+		DeleteAccountAfterNotifyingThreshold: 7 * day,
+		AnonymizeDeletedAccounts:             map[string]bool{testInstanceID: true},
+		clock:                                func() time.Time { return time.Unix(now, 0) },
+	}
+
+	addUser := func(email string) string {
+		id, err := testUserDBService.AddUser(context.Background(), testInstanceID, models.User{
+			Account:    models.Account{Type: models.ACCOUNT_TYPE_EMAIL, AccountID: email, AccountConfirmedAt: start},
+			Roles:      []string{constants.USER_ROLE_PARTICIPANT},
+			Timestamps: models.Timestamps{CreatedAt: start, LastLogin: start, LastTokenRefresh: start},
+		})
+		if err != nil {
+			t.Fatalf("failed to create testuser: %s", err.Error())
+		}
+		return id
+	}
+	inactiveUserID := addUser("test-inactive-stages@test.com")
+	returningUserID := addUser("test-inactive-stages-returning@test.com")
+
+	getUser := func(id string) models.User {
+		u, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, id)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		return u
+	}
+	emailsTo := func(email string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, sentEmails[email]...)
+	}
+
+	t.Run("before the first threshold", func(t *testing.T) {
+		now = start + 5*day
+		s.DetectAndNotifyInactiveUsers()
+		if emails := emailsTo("test-inactive-stages@test.com"); len(emails) != 0 {
+			t.Errorf("unexpected emails: %v", emails)
+		}
+	})
+
+	t.Run("first warning", func(t *testing.T) {
+		now = start + 10*day + 3600
+		s.DetectAndNotifyInactiveUsers()
+		for _, email := range []string{"test-inactive-stages@test.com", "test-inactive-stages-returning@test.com"} {
+			if emails := emailsTo(email); len(emails) != 1 || emails[0] != models.EMAIL_TYPE_INACTIVITY_WARNING {
+				t.Errorf("unexpected emails to %s: %v", email, emails)
+			}
+		}
+		u := getUser(inactiveUserID)
+		if u.Timestamps.InactivityWarningSentAt != now || u.Timestamps.MarkedForDeletion != 0 {
+			t.Errorf("unexpected timestamps: %+v", u.Timestamps)
+		}
+	})
+
+	t.Run("no second warning before the final one", func(t *testing.T) {
+		// the returning user logs in after the warning
+		returning := getUser(returningUserID)
+		returning.Timestamps.LastLogin = start + 11*day
+		if _, err := testUserDBService.UpdateUser(context.Background(), testInstanceID, returning); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+
+		now = start + 12*day
+		s.DetectAndNotifyInactiveUsers()
+		if emails := emailsTo("test-inactive-stages@test.com"); len(emails) != 1 {
+			t.Errorf("unexpected emails: %v", emails)
+		}
+		if u := getUser(inactiveUserID); u.Timestamps.MarkedForDeletion != 0 {
+			t.Errorf("unexpected timestamps: %+v", u.Timestamps)
+		}
+	})
+
+	t.Run("final warning marks for deletion", func(t *testing.T) {
+		now = start + 15*day + 7200
+		s.DetectAndNotifyInactiveUsers()
+		if emails := emailsTo("test-inactive-stages@test.com"); len(emails) != 2 || emails[1] != constants.EMAIL_TYPE_ACCOUNT_INACTIVITY {
+			t.Errorf("unexpected emails: %v", emails)
+		}
+		if u := getUser(inactiveUserID); u.Timestamps.MarkedForDeletion != now+7*day {
+			t.Errorf("unexpected timestamps: %+v", u.Timestamps)
+		}
+
+		// active since the first warning
+		if emails := emailsTo("test-inactive-stages-returning@test.com"); len(emails) != 1 {
+			t.Errorf("unexpected emails to returning user: %v", emails)
+		}
+		if u := getUser(returningUserID); u.Timestamps.MarkedForDeletion != 0 {
+			t.Errorf("returning user should not be marked for deletion: %+v", u.Timestamps)
+		}
+	})
+
+	t.Run("removed after the deletion time", func(t *testing.T) {
+		s.CleanupUsersMarkedForDeletion(context.Background())
+		if u := getUser(inactiveUserID); u.Timestamps.AnonymizedAt <= 0 {
+			t.Errorf("account should be anonymized: %+v", u.Timestamps)
+		}
+		if u := getUser(returningUserID); u.Timestamps.AnonymizedAt > 0 {
+			t.Error("returning user should be kept")
+		}
+	})
+}
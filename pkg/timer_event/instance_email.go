@@ -0,0 +1,33 @@
+package timer_event
+
+import (
+	"context"
+
+	"github.com/coneno/logger"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+)
+
+// applyInstanceEmailConfig adds the sender of the instance to req and returns the copy for the BCC address of the
+// instance, nil if there is none. If the instance settings can't be read, the email is sent with the defaults.
+func (s *UserManagementTimerService) applyInstanceEmailConfig(req *messageAPI.SendEmailReq) *messageAPI.SendEmailReq {
+	conf, err := s.globalDBService.GetInstanceConfig(req.InstanceId)
+	if err != nil {
+		logger.Error.Printf("couldn't read config of instance %s, using default email settings: %v", req.InstanceId, err)
+		return nil
+	}
+	return conf.Email.Apply(req)
+}
+
+// queueEmail queues the email with the sender of the instance, and its copy for the BCC address of the instance
+func (s *UserManagementTimerService) queueEmail(ctx context.Context, req *messageAPI.SendEmailReq) error {
+	bcc := s.applyInstanceEmailConfig(req)
+	if _, err := s.clients.MessagingService.QueueEmailTemplateForSending(ctx, req); err != nil {
+		return err
+	}
+	if bcc != nil {
+		if _, err := s.clients.MessagingService.QueueEmailTemplateForSending(ctx, bcc); err != nil {
+			logger.Error.Printf("couldn't queue copy of %s email of instance %s: %v", req.MessageType, req.InstanceId, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package timer_event
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/coneno/logger"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
+// RemindUsersOfDeletion sends a last reminder to the users whose account will be deleted within DeletionReminderBefore,
+// while they can still cancel the deletion by logging in. Each scheduled deletion is reminded once.
+func (s *UserManagementTimerService) RemindUsersOfDeletion() {
+	ctx := context.Background()
+	instances, err := s.globalDBService.GetAllInstances()
+	if err != nil {
+		logger.Error.Printf("unexpected error: %s", err.Error())
+	}
+
+	for _, instance := range instances {
+		now := s.now()
+		users, err := s.userDBService.FindUsersToRemindOfDeletion(ctx, instance.InstanceID, now.Unix(), now.Unix()+s.DeletionReminderBefore)
+		if err != nil {
+			logger.Error.Printf("unexpected error: %s", err.Error())
+			continue
+		}
+
+		count := 0
+		for _, u := range users {
+			if u.ContactPreferences.InQuietHours(now) {
+				// reminded by a later run
+				continue
+			}
+			deletionTime := u.Timestamps.MarkedForDeletion
+			err := s.queueEmail(context.TODO(), &messageAPI.SendEmailReq{
+				InstanceId:  instance.InstanceID,
+				To:          []string{u.NotificationEmail()},
+				MessageType: models.EMAIL_TYPE_DELETION_REMINDER,
+				ContentInfos: map[string]string{
+					"deletionTime": strconv.FormatInt(deletionTime, 10),
+				},
+				PreferredLanguage: u.Account.PreferredLanguage,
+			})
+			if err != nil {
+				logger.Error.Printf("unexpected error: %v", err)
+				continue
+			}
+			if err := s.userDBService.UpdateDeletionReminderSentFor(ctx, instance.InstanceID, u.ID.Hex(), deletionTime); err != nil {
+				logger.Error.Printf("unexpected error: %v", err)
+				continue
+			}
+			count++
+		}
+		if count > 0 {
+			logger.Info.Printf("%s: deletion reminder will be sent to %d accounts", instance.InstanceID, count)
+		}
+	}
+}
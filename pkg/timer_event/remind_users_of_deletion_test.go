@@ -0,0 +1,115 @@
+package timer_event
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/influenzanet/go-utils/pkg/constants"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
+	"google.golang.org/grpc"
+)
+
+func TestRemindUsersOfDeletion(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+
+	// reminders sent per address, other users of the test instance may get some too
+	var mu sync.Mutex
+	sentEmails := map[string][]*messageAPI.SendEmailReq{}
+	mockMessagingClient.EXPECT().QueueEmailTemplateForSending(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			sentEmails[req.To[0]] = append(sentEmails[req.To[0]], req)
+			return nil, nil
+		}).AnyTimes()
+
+	const day = int64(24 * 60 * 60)
+	start := time.Now().Unix()
+	deletionTime := start + 10*day
+	var now int64
+	s := UserManagementTimerService{
+		globalDBService:        testGlobalDBService,
+		userDBService:          testUserDBService,
+		clients:                &models.APIClients{MessagingService: mockMessagingClient},
+		DeletionReminderBefore: 3 * day,
+		clock:                  func() time.Time { return time.Unix(now, 0) },
+	}
+
+	email := "test-deletion-reminder@test.com"
+	userID, err := testUserDBService.AddUser(context.Background(), testInstanceID, models.User{
+		Account:    models.Account{Type: models.ACCOUNT_TYPE_EMAIL, AccountID: email, AccountConfirmedAt: start},
+		Roles:      []string{constants.USER_ROLE_PARTICIPANT},
+		Timestamps: models.Timestamps{CreatedAt: start, MarkedForDeletion: deletionTime},
+	})
+	if err != nil {
+		t.Fatalf("failed to create testuser: %s", err.Error())
+	}
+	emailsTo := func(email string) []*messageAPI.SendEmailReq {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*messageAPI.SendEmailReq{}, sentEmails[email]...)
+	}
+
+	t.Run("before the reminder window", func(t *testing.T) {
+		now = start + 5*day
+		s.RemindUsersOfDeletion()
+		if emails := emailsTo(email); len(emails) != 0 {
+			t.Errorf("unexpected emails: %v", emails)
+		}
+	})
+
+	t.Run("within the reminder window", func(t *testing.T) {
+		now = start + 8*day
+		s.RemindUsersOfDeletion()
+		emails := emailsTo(email)
+		if len(emails) != 1 || emails[0].MessageType != models.EMAIL_TYPE_DELETION_REMINDER {
+			t.Errorf("unexpected emails: %v", emails)
+			return
+		}
+		if emails[0].ContentInfos["deletionTime"] != strconv.FormatInt(deletionTime, 10) {
+			t.Errorf("unexpected content infos: %v", emails[0].ContentInfos)
+		}
+		u, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, userID)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if u.Timestamps.DeletionReminderSentFor != deletionTime {
+			t.Errorf("unexpected reminder time: %d", u.Timestamps.DeletionReminderSentFor)
+		}
+	})
+
+	t.Run("reminder is sent once", func(t *testing.T) {
+		now = start + 9*day
+		s.RemindUsersOfDeletion()
+		if emails := emailsTo(email); len(emails) != 1 {
+			t.Errorf("unexpected emails: %v", emails)
+		}
+	})
+
+	t.Run("after the deletion time", func(t *testing.T) {
+		pastEmail := "test-deletion-reminder-past@test.com"
+		_, err := testUserDBService.AddUser(context.Background(), testInstanceID, models.User{
+			Account:    models.Account{Type: models.ACCOUNT_TYPE_EMAIL, AccountID: pastEmail, AccountConfirmedAt: start},
+			Roles:      []string{constants.USER_ROLE_PARTICIPANT},
+			Timestamps: models.Timestamps{CreatedAt: start, MarkedForDeletion: start + 2*day},
+		})
+		if err != nil {
+			t.Errorf("failed to create testuser: %s", err.Error())
+			return
+		}
+		now = start + 3*day
+		s.RemindUsersOfDeletion()
+		if emails := emailsTo(pastEmail); len(emails) != 0 {
+			t.Errorf("unexpected emails: %v", emails)
+		}
+	})
+}
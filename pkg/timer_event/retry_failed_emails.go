@@ -0,0 +1,49 @@
+package timer_event
+
+import (
+	"context"
+	"time"
+
+	"github.com/coneno/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const emailRetryLease = 10 * time.Minute // a claimed email is not retried by another replica before
+
+// RetryFailedEmails sends again the queued emails which are due. Stops at the first email that fails again, the
+// messaging service is likely still unavailable.
+func (s *UserManagementTimerService) RetryFailedEmails(ctx context.Context) {
+	start := s.now()
+	for ctx.Err() == nil {
+		e, err := s.globalDBService.ClaimDueEmailRetry(start.Unix(), start.Add(emailRetryLease).Unix())
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				logger.Error.Printf("unexpected error while reading email retries: %v", err)
+			}
+			return
+		}
+
+		_, err = s.clients.MessagingService.SendInstantEmail(ctx, e.ToRequest())
+		if err == nil {
+			if err := s.globalDBService.DeleteEmailRetry(e.ID); err != nil {
+				logger.Error.Printf("failed to remove sent email %s from the retries: %v", e.ID.Hex(), err)
+			}
+			continue
+		}
+
+		attempts := e.Attempts + 1
+		if attempts >= s.EmailRetry.MaxAttempts {
+			logger.Error.Printf("%s: giving up email %s (%s) after %d attempts: %v", e.InstanceID, e.ID.Hex(), e.MessageType, attempts, err)
+			if err := s.globalDBService.DeleteEmailRetry(e.ID); err != nil {
+				logger.Error.Printf("failed to remove email %s from the retries: %v", e.ID.Hex(), err)
+			}
+			continue
+		}
+		delay := s.EmailRetry.Delay << (attempts - 2)
+		if err := s.globalDBService.UpdateEmailRetryFailure(e.ID, attempts, s.now().Add(delay).Unix(), err.Error()); err != nil {
+			logger.Error.Printf("failed to update email retry %s: %v", e.ID.Hex(), err)
+		}
+		logger.Warning.Printf("%s: email %s (%s) failed again, next attempt in %s", e.InstanceID, e.ID.Hex(), e.MessageType, delay)
+		return
+	}
+}
@@ -0,0 +1,72 @@
+package timer_event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc"
+)
+
+func TestRetryFailedEmails(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+
+	now := time.Now()
+	s := UserManagementTimerService{
+		globalDBService: testGlobalDBService,
+		clients:         &models.APIClients{MessagingService: mockMessagingClient},
+		EmailRetry:      models.EmailRetryConfig{MaxAttempts: 3, Delay: time.Minute},
+		clock:           func() time.Time { return now },
+	}
+	queue := func(to string) {
+		req := &messageAPI.SendEmailReq{InstanceId: testInstanceID, To: []string{to}, MessageType: "password-changed"}
+		e := models.EmailRetryFromRequest(req, errors.New("unavailable"), now.Unix(), now.Unix())
+		if err := testGlobalDBService.AddEmailRetry(e); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	shouldBeEmpty := func() {
+		if _, err := testGlobalDBService.ClaimDueEmailRetry(now.Add(time.Hour).Unix(), now.Unix()); err != mongo.ErrNoDocuments {
+			t.Errorf("expected no queued email, got: %v", err)
+		}
+	}
+
+	t.Run("failing again then sent", func(t *testing.T) {
+		queue("retry-1@test.com")
+
+		mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).Return(nil, errors.New("still unavailable"))
+		s.RetryFailedEmails(context.Background())
+
+		// not due before the delay
+		s.RetryFailedEmails(context.Background())
+
+		now = now.Add(time.Minute)
+		mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+				if req.To[0] != "retry-1@test.com" || req.MessageType != "password-changed" {
+					t.Errorf("unexpected request: %v", req)
+				}
+				return &messageAPI.ServiceStatus{}, nil
+			})
+		s.RetryFailedEmails(context.Background())
+		shouldBeEmpty()
+	})
+
+	t.Run("dropped after max attempts", func(t *testing.T) {
+		queue("retry-2@test.com")
+
+		mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).Return(nil, errors.New("still unavailable")).Times(2)
+		s.RetryFailedEmails(context.Background())
+		now = now.Add(time.Minute)
+		s.RetryFailedEmails(context.Background())
+		shouldBeEmpty()
+	})
+}
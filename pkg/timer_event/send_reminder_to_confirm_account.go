@@ -10,8 +10,11 @@ import (
 	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
 	"github.com/influenzanet/user-management-service/pkg/models"
 	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"github.com/influenzanet/user-management-service/pkg/utils"
 )
 
+var errQuietHours = errors.New("quiet hours of the user")
+
 // CleanUpUnverifiedUsers handles the deletion of unverified accounts after a threshold delay
 func (s *UserManagementTimerService) ReminderToConfirmAccount() {
 	logger.Debug.Println("Check if reminders to confirm accounts need to be sent out.")
@@ -20,9 +23,16 @@ func (s *UserManagementTimerService) ReminderToConfirmAccount() {
 		logger.Error.Printf("unexpected error: %s", err.Error())
 	}
 	sendReminderToConfirmAfter := s.ReminderTimeThreshold
+	ctx := context.Background()
+	// shared by all instances, they use the same messaging service
+	pacer := utils.NewPacer(s.ReminderPacing)
 
 	sendReminderToUser := func(instanceID string, user models.User, args ...interface{}) error {
 		count, _ := args[0].(*int)
+		if user.ContactPreferences.InQuietHours(time.Now()) {
+			// not marked as sent, retried by a later run
+			return errQuietHours
+		}
 
 		tempTokenInfos := models.TempToken{
 			UserID:     user.ID.Hex(),
@@ -41,8 +51,10 @@ func (s *UserManagementTimerService) ReminderToConfirmAccount() {
 		}
 
 		// ---> Trigger message sending
-
-		_, err = s.clients.MessagingService.SendInstantEmail(context.TODO(), &messageAPI.SendEmailReq{
+		if err := pacer.Wait(ctx); err != nil {
+			return err
+		}
+		req := &messageAPI.SendEmailReq{
 			InstanceId:  instanceID,
 			To:          []string{user.Account.AccountID},
 			MessageType: constants.EMAIL_TYPE_REGISTRATION,
@@ -50,7 +62,9 @@ func (s *UserManagementTimerService) ReminderToConfirmAccount() {
 				"token": tempToken,
 			},
 			PreferredLanguage: user.Account.PreferredLanguage,
-		})
+		}
+		s.applyInstanceEmailConfig(req) // no copy of registration emails
+		_, err = s.clients.MessagingService.SendInstantEmail(context.TODO(), req)
 		if err != nil {
 			logger.Error.Printf("unexpected error: %s", err.Error())
 			return err
@@ -61,7 +75,6 @@ func (s *UserManagementTimerService) ReminderToConfirmAccount() {
 
 	for _, instance := range instances {
 		count := 0
-		ctx := context.Background()
 		err := s.userDBService.SendReminderToConfirmAccountLoop(ctx, instance.InstanceID, time.Now().Unix()-sendReminderToConfirmAfter, sendReminderToUser, &count)
 		if err != nil {
 			logger.Error.Printf("unexpected error: %s", err.Error())
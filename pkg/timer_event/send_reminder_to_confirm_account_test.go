@@ -0,0 +1,82 @@
+package timer_event
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	messageMock "github.com/influenzanet/user-management-service/test/mocks/messaging_service"
+	"google.golang.org/grpc"
+)
+
+func TestReminderToConfirmAccountPacing(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockMessagingClient := messageMock.NewMockMessagingServiceApiClient(mockCtrl)
+
+	// send times of the reminders to the users of this test
+	var mu sync.Mutex
+	sentAt := []time.Time{}
+	mockMessagingClient.EXPECT().SendInstantEmail(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, req *messageAPI.SendEmailReq, opts ...grpc.CallOption) (*messageAPI.ServiceStatus, error) {
+			if strings.HasPrefix(req.To[0], "test-reminder-pacing") {
+				mu.Lock()
+				defer mu.Unlock()
+				sentAt = append(sentAt, time.Now())
+			}
+			return nil, nil
+		}).AnyTimes()
+
+	interval := 30 * time.Millisecond
+	s := UserManagementTimerService{
+		globalDBService:       testGlobalDBService,
+		userDBService:         testUserDBService,
+		clients:               &models.APIClients{MessagingService: mockMessagingClient},
+		ReminderTimeThreshold: 10,
+		ReminderPacing:        models.SendPacingConfig{Interval: interval, BatchSize: 3, BatchPause: 4 * interval},
+	}
+
+	createdAt := time.Now().Unix() - 3600
+	userIDs := []string{}
+	for i := 0; i < 5; i++ {
+		id, err := testUserDBService.AddUser(context.Background(), testInstanceID, models.User{
+			Account:    models.Account{Type: models.ACCOUNT_TYPE_EMAIL, AccountID: fmt.Sprintf("test-reminder-pacing-%d@test.com", i)},
+			Timestamps: models.Timestamps{CreatedAt: createdAt},
+		})
+		if err != nil {
+			t.Fatalf("failed to create testuser: %s", err.Error())
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	s.ReminderToConfirmAccount()
+
+	if len(sentAt) != len(userIDs) {
+		t.Fatalf("unexpected number of reminders: %d", len(sentAt))
+	}
+	for i := 1; i < len(sentAt); i++ {
+		if d := sentAt[i].Sub(sentAt[i-1]); d < interval {
+			t.Errorf("reminder %d sent %s after the previous one, expected at least %s", i, d, interval)
+		}
+	}
+	// 4 gaps, one of them after a batch of 3
+	if d := sentAt[4].Sub(sentAt[0]); d < 7*interval {
+		t.Errorf("5 reminders sent within %s, expected a batch pause", d)
+	}
+
+	for _, id := range userIDs {
+		u, err := testUserDBService.GetUserByID(context.Background(), testInstanceID, id)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if u.Timestamps.ReminderToConfirmSentAt == 0 {
+			t.Errorf("reminder time not set for %s", u.Account.AccountID)
+		}
+	}
+}
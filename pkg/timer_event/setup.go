@@ -15,12 +15,20 @@ type UserManagementTimerService struct {
 	globalDBService                      *globaldb.GlobalDBService
 	userDBService                        *userdb.UserDBService
 	clients                              *models.APIClients
-	TimerEventFrequency                  int64 // how often the timer event should be performed (only from one instance of the service) - seconds
-	CleanUpTimeThreshold                 int64 // if user account not verified, remove user after this many seconds
-	ReminderTimeThreshold                int64 // if user account not verified, send a reminder email to the user after this many seconds
-	NotifyInactiveUserThreshold          int64 // if user account is inactive, send a reminder email to the user after this many seconds
-	DeleteAccountAfterNotifyingThreshold int64 // if user account is notified by mail, delete account after this many seconds
+	TimerEventFrequency                  int64                   // how often the timer event should be performed (only from one instance of the service) - seconds
+	CleanUpTimeThreshold                 int64                   // if user account not verified, remove user after this many seconds
+	ReminderTimeThreshold                int64                   // if user account not verified, send a reminder email to the user after this many seconds
+	ReminderPacing                       models.SendPacingConfig // spaces out the reminder emails
+	NotifyInactiveUserThreshold          int64                   // if user account is inactive, send a reminder email to the user after this many seconds
+	FinalInactivityWarningThreshold      int64                   // if set, the reminder above is a first warning, the account is marked for deletion with a final warning this many seconds later
+	DeleteAccountAfterNotifyingThreshold int64                   // if user account is notified by mail, delete account after this many seconds
+	DeletionReminderBefore               int64                   // if set, users are reminded of the deletion of their account this many seconds before
+	CleanupDryRun                        bool                    // only log the accounts the cleanup jobs would delete
+	AnonymizeDeletedAccounts             map[string]bool         // instances where accounts are anonymized instead of deleted
+	TempTokenCleanupInterval             time.Duration           // how often expired and orphaned temp tokens are removed, 0 to disable
+	EmailRetry                           models.EmailRetryConfig // retries of the emails the service could not send
 
+	clock func() time.Time // current time for the inactive users jobs, replaced in tests
 }
 
 func NewUserManagmentTimerService(
@@ -30,8 +38,15 @@ func NewUserManagmentTimerService(
 	clients *models.APIClients,
 	cleanUpTimeThreshold int64,
 	reminderTimeThreshold int64,
+	reminderPacing models.SendPacingConfig,
 	notifyInactiveUserThreshold int64,
+	finalInactivityWarningThreshold int64,
 	deleteAccountAfterNotifyingThreshold int64,
+	deletionReminderBefore int64,
+	cleanupDryRun bool,
+	anonymizeDeletedAccounts map[string]bool,
+	tempTokenCleanupInterval time.Duration,
+	emailRetry models.EmailRetryConfig,
 ) *UserManagementTimerService {
 	return &UserManagementTimerService{
 		globalDBService:                      globalDBService,
@@ -40,13 +55,34 @@ func NewUserManagmentTimerService(
 		clients:                              clients,
 		CleanUpTimeThreshold:                 cleanUpTimeThreshold,
 		ReminderTimeThreshold:                reminderTimeThreshold,
+		ReminderPacing:                       reminderPacing,
 		NotifyInactiveUserThreshold:          notifyInactiveUserThreshold,
+		FinalInactivityWarningThreshold:      finalInactivityWarningThreshold,
 		DeleteAccountAfterNotifyingThreshold: deleteAccountAfterNotifyingThreshold,
+		DeletionReminderBefore:               deletionReminderBefore,
+		CleanupDryRun:                        cleanupDryRun,
+		AnonymizeDeletedAccounts:             anonymizeDeletedAccounts,
+		TempTokenCleanupInterval:             tempTokenCleanupInterval,
+		EmailRetry:                           emailRetry,
+		clock:                                time.Now,
 	}
 }
 
+func (s *UserManagementTimerService) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
+}
+
 func (s *UserManagementTimerService) Run(ctx context.Context) {
 	go s.startTimerThread(ctx, s.TimerEventFrequency)
+	if s.TempTokenCleanupInterval > 0 {
+		go s.startTempTokenCleanupThread(ctx, s.TempTokenCleanupInterval)
+	}
+	if s.EmailRetry.Interval > 0 {
+		go s.startEmailRetryThread(ctx, s.EmailRetry.Interval)
+	}
 }
 
 func (s *UserManagementTimerService) startTimerThread(ctx context.Context, timeCheckInterval int64) {
@@ -58,10 +94,37 @@ func (s *UserManagementTimerService) startTimerThread(ctx context.Context, timeC
 			go s.ReminderToConfirmAccount()
 			if s.NotifyInactiveUserThreshold > 0 && s.DeleteAccountAfterNotifyingThreshold > 0 {
 				go s.DetectAndNotifyInactiveUsers()
-				go s.CleanupUsersMarkedForDeletion()
+				go s.CleanupUsersMarkedForDeletion(ctx)
+			}
+			if s.DeletionReminderBefore > 0 {
+				go s.RemindUsersOfDeletion()
 			}
 		case <-ctx.Done():
 			return
 		}
 	}
 }
+
+func (s *UserManagementTimerService) startTempTokenCleanupThread(ctx context.Context, interval time.Duration) {
+	logger.Info.Printf("Starting temp token cleanup with interval %s", interval)
+	for {
+		select {
+		case <-time.After(interval):
+			s.CleanUpTempTokens(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *UserManagementTimerService) startEmailRetryThread(ctx context.Context, interval time.Duration) {
+	logger.Info.Printf("Starting email retries with interval %s", interval)
+	for {
+		select {
+		case <-time.After(interval):
+			s.RetryFailedEmails(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
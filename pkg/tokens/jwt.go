@@ -18,6 +18,10 @@ var (
 	secretKeyEnc string
 )
 
+// signingMethod is the only algorithm accepted by ValidateToken, so that tokens with "none" or another algorithm
+// using the secret differently are rejected
+var signingMethod = jwt.SigningMethodHS256
+
 // UserClaims - Information a token enocodes
 type UserClaims struct {
 	ID               string            `json:"id,omitempty"`
@@ -30,6 +34,51 @@ type UserClaims struct {
 	jwt.StandardClaims
 }
 
+// ErrInvalidAudience is returned by ValidateToken for a token issued for another audience than set with SetAudience
+var ErrInvalidAudience = errors.New("token has invalid audience")
+
+// audience of the issued tokens, tokens have no audience and it is not checked if empty, see SetAudience
+var audience string
+
+// SetAudience sets the audience of the issued and validated tokens, to be called at startup
+func SetAudience(aud string) {
+	audience = aud
+}
+
+// leeway is the tolerated clock skew between the issuing and the validating service, see SetLeeway
+var leeway time.Duration
+
+// SetLeeway sets the clock skew tolerated when validating the times of a token, to be called at startup
+func SetLeeway(d time.Duration) {
+	leeway = d
+}
+
+// Valid checks the time based claims like jwt.StandardClaims.Valid, tolerating a clock skew of leeway
+// between the issuing and the validating service
+func (c UserClaims) Valid() error {
+	now := jwt.TimeFunc()
+	vErr := new(jwt.ValidationError)
+
+	if !c.VerifyExpiresAt(now.Add(-leeway).Unix(), false) {
+		delta := now.Sub(time.Unix(c.ExpiresAt, 0))
+		vErr.Inner = fmt.Errorf("%s by %s", jwt.ErrTokenExpired, delta)
+		vErr.Errors |= jwt.ValidationErrorExpired
+	}
+	if !c.VerifyIssuedAt(now.Add(leeway).Unix(), false) {
+		vErr.Inner = jwt.ErrTokenUsedBeforeIssued
+		vErr.Errors |= jwt.ValidationErrorIssuedAt
+	}
+	if !c.VerifyNotBefore(now.Add(leeway).Unix(), false) {
+		vErr.Inner = jwt.ErrTokenNotValidYet
+		vErr.Errors |= jwt.ValidationErrorNotValidYet
+	}
+
+	if vErr.Errors == 0 {
+		return nil
+	}
+	return vErr
+}
+
 func getSecretKey() (newSecretKey []byte, err error) {
 	newSecretKeyEnc := os.Getenv("JWT_TOKEN_KEY")
 	if secretKeyEnc == newSecretKeyEnc {
@@ -47,8 +96,8 @@ func getSecretKey() (newSecretKey []byte, err error) {
 	return
 }
 
-// GenerateNewToken create and signes a new token
-func GenerateNewToken(userID string, accountConfirmed bool, profileID string, userRoles []string, instanceID string, experiresIn time.Duration, username string, tempTokenInfos *models.TempToken, otherProfileIDs []string) (string, error) {
+// GenerateNewToken create and signes a new token. profiles are embedded in the payload if not empty.
+func GenerateNewToken(userID string, accountConfirmed bool, profileID string, userRoles []string, instanceID string, experiresIn time.Duration, username string, tempTokenInfos *models.TempToken, otherProfileIDs []string, profiles []models.TokenProfile) (string, error) {
 	payload := map[string]string{}
 
 	if len(userRoles) > 0 {
@@ -57,6 +106,11 @@ func GenerateNewToken(userID string, accountConfirmed bool, profileID string, us
 	if len(username) > 0 {
 		payload["username"] = username
 	}
+	if len(profiles) > 0 {
+		if encoded := encodeProfiles(profiles); encoded != "" {
+			payload[payloadKeyProfiles] = encoded
+		}
+	}
 
 	// Create the Claims
 	claims := UserClaims{
@@ -73,8 +127,61 @@ func GenerateNewToken(userID string, accountConfirmed bool, profileID string, us
 		},
 	}
 
+	return signClaims(claims)
+}
+
+// GenerateNewServiceAccountToken creates a long lived token for a service account. The token id is stored as
+// standard "jti" claim, so the token can be revoked individually.
+func GenerateNewServiceAccountToken(userID string, userRoles []string, instanceID string, expiresIn time.Duration, tokenID string) (string, error) {
+	payload := map[string]string{}
+	if len(userRoles) > 0 {
+		payload["roles"] = strings.Join(userRoles, ",")
+	}
+
+	claims := UserClaims{
+		ID:               userID,
+		InstanceID:       instanceID,
+		Payload:          payload,
+		AccountConfirmed: true,
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			ExpiresAt: time.Now().Add(expiresIn).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+	return signClaims(claims)
+}
+
+// GenerateNewImpersonationToken creates a token for an admin to act as the user. The admin's id is stored
+// in the payload, so that services can tell the token apart from one of the user.
+func GenerateNewImpersonationToken(userID string, accountConfirmed bool, profileID string, otherProfileIDs []string, userRoles []string, instanceID string, expiresIn time.Duration, impersonatedBy string) (string, error) {
+	payload := map[string]string{
+		models.TOKEN_PAYLOAD_IMPERSONATED_BY: impersonatedBy,
+	}
+	if len(userRoles) > 0 {
+		payload["roles"] = strings.Join(userRoles, ",")
+	}
+
+	claims := UserClaims{
+		ID:               userID,
+		InstanceID:       instanceID,
+		ProfileID:        profileID,
+		Payload:          payload,
+		AccountConfirmed: accountConfirmed,
+		OtherProfileIDs:  otherProfileIDs,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(expiresIn).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+	return signClaims(claims)
+}
+
+func signClaims(claims UserClaims) (string, error) {
+	claims.Audience = audience
+
 	// Create the token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token := jwt.NewWithClaims(signingMethod, claims)
 
 	_, err := getSecretKey()
 	if err != nil {
@@ -93,8 +200,9 @@ func ValidateToken(tokenString string) (claims *UserClaims, valid bool, err erro
 		return nil, false, err
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{signingMethod.Alg()}))
+	token, err := parser.ParseWithClaims(tokenString, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != signingMethod {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return secretKey, nil
@@ -104,5 +212,21 @@ func ValidateToken(tokenString string) (claims *UserClaims, valid bool, err erro
 	}
 	claims, valid = token.Claims.(*UserClaims)
 	valid = valid && token.Valid
+	// also checked for expired tokens, callers like RenewJWT accept them
+	if claims != nil && audience != "" && isSignatureVerified(err) && !claims.VerifyAudience(audience, true) {
+		return claims, false, ErrInvalidAudience
+	}
 	return
 }
+
+// isSignatureVerified tells if the parsing error of a token still means a verified signature, i.e. only its claims are invalid
+func isSignatureVerified(err error) bool {
+	if err == nil {
+		return true
+	}
+	var vErr *jwt.ValidationError
+	if !errors.As(err, &vErr) {
+		return false
+	}
+	return vErr.Errors&(jwt.ValidationErrorMalformed|jwt.ValidationErrorUnverifiable|jwt.ValidationErrorSignatureInvalid) == 0
+}
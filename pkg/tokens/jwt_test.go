@@ -1,6 +1,14 @@
 package tokens
 
-import "testing"
+import (
+	b64 "encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
 
 func TestGetRolesFromPayload(t *testing.T) {
 	t.Run("with empty payload", func(t *testing.T) {
@@ -40,3 +48,251 @@ func TestGetRolesFromPayload(t *testing.T) {
 		}
 	})
 }
+
+func TestGenerateNewServiceAccountToken(t *testing.T) {
+	t.Setenv("JWT_TOKEN_KEY", b64.StdEncoding.EncodeToString([]byte("test-secret-key-with-at-least-32-bytes")))
+
+	expiresIn := time.Hour * 24 * 365
+	token, err := GenerateNewServiceAccountToken("testuserid", []string{"SERVICE"}, "testinstance", expiresIn, "testtokenid")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	claims, ok, err := ValidateToken(token)
+	if err != nil || !ok {
+		t.Errorf("token should be valid: %v", err)
+		return
+	}
+	if claims.ID != "testuserid" || claims.InstanceID != "testinstance" || claims.Id != "testtokenid" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	expectedExpiry := time.Now().Add(expiresIn).Unix()
+	if claims.ExpiresAt < expectedExpiry-5 || claims.ExpiresAt > expectedExpiry {
+		t.Errorf("unexpected expiry: %d (expected %d)", claims.ExpiresAt, expectedExpiry)
+	}
+	if roles := GetRolesFromPayload(claims.Payload); len(roles) != 1 || roles[0] != "SERVICE" {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+}
+
+func TestGenerateNewImpersonationToken(t *testing.T) {
+	t.Setenv("JWT_TOKEN_KEY", b64.StdEncoding.EncodeToString([]byte("test-secret-key-with-at-least-32-bytes")))
+
+	token, err := GenerateNewImpersonationToken("testuserid", true, "testprofileid", []string{"otherprofileid"}, []string{"PARTICIPANT"}, "testinstance", time.Minute*15, "testadminid")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	claims, ok, err := ValidateToken(token)
+	if err != nil || !ok {
+		t.Errorf("token should be valid: %v", err)
+		return
+	}
+	if claims.ID != "testuserid" || claims.ProfileID != "testprofileid" || len(claims.OtherProfileIDs) != 1 || !claims.AccountConfirmed {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	if claims.Payload["impersonated_by"] != "testadminid" {
+		t.Errorf("unexpected payload: %v", claims.Payload)
+	}
+	if claims.ExpiresAt > time.Now().Add(time.Minute*15).Unix() {
+		t.Errorf("unexpected expiry: %d", claims.ExpiresAt)
+	}
+}
+
+func TestTokenAudience(t *testing.T) {
+	t.Setenv("JWT_TOKEN_KEY", b64.StdEncoding.EncodeToString([]byte("test-secret-key-with-at-least-32-bytes")))
+
+	defer SetAudience("")
+
+	SetAudience("test-audience")
+	token, err := GenerateNewToken("testuserid", true, "testprofileid", []string{"PARTICIPANT"}, "testinstance", time.Minute, "", nil, []string{}, nil)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	expiredToken, err := GenerateNewToken("testuserid", true, "testprofileid", []string{"PARTICIPANT"}, "testinstance", -time.Minute, "", nil, []string{}, nil)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	SetAudience("")
+	tokenWithoutAudience, err := GenerateNewToken("testuserid", true, "testprofileid", []string{"PARTICIPANT"}, "testinstance", time.Minute, "", nil, []string{}, nil)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+
+	t.Run("with matching audience", func(t *testing.T) {
+		SetAudience("test-audience")
+		claims, ok, err := ValidateToken(token)
+		if err != nil || !ok {
+			t.Errorf("token should be valid: %v", err)
+			return
+		}
+		if claims.Audience != "test-audience" {
+			t.Errorf("unexpected audience: %s", claims.Audience)
+		}
+	})
+
+	t.Run("with other audience", func(t *testing.T) {
+		SetAudience("other-audience")
+		_, ok, err := ValidateToken(token)
+		if ok || err != ErrInvalidAudience {
+			t.Errorf("token should be rejected: %v", err)
+		}
+	})
+
+	t.Run("expired with other audience", func(t *testing.T) {
+		SetAudience("other-audience")
+		_, ok, err := ValidateToken(expiredToken)
+		if ok || err != ErrInvalidAudience {
+			t.Errorf("token should be rejected: %v", err)
+		}
+	})
+
+	t.Run("expired with matching audience", func(t *testing.T) {
+		SetAudience("test-audience")
+		_, ok, err := ValidateToken(expiredToken)
+		if ok || !errors.Is(err, jwt.ErrTokenExpired) {
+			t.Errorf("token should be expired: %v", err)
+		}
+	})
+
+	t.Run("without audience in token", func(t *testing.T) {
+		SetAudience("test-audience")
+		_, ok, err := ValidateToken(tokenWithoutAudience)
+		if ok || err != ErrInvalidAudience {
+			t.Errorf("token should be rejected: %v", err)
+		}
+	})
+
+	t.Run("without expected audience", func(t *testing.T) {
+		SetAudience("")
+		if _, ok, err := ValidateToken(token); err != nil || !ok {
+			t.Errorf("token should be valid: %v", err)
+		}
+	})
+}
+
+func TestValidateTokenSigningMethod(t *testing.T) {
+	secret := []byte("test-secret-key-with-at-least-32-bytes")
+	t.Setenv("JWT_TOKEN_KEY", b64.StdEncoding.EncodeToString(secret))
+
+	claims := UserClaims{
+		ID:         "testuserid",
+		InstanceID: "testinstance",
+		Payload:    map[string]string{"roles": "PARTICIPANT,ADMIN"},
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+
+	t.Run("with none algorithm", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, ok, err := ValidateToken(token); ok || err == nil {
+			t.Error("token without signature should be rejected")
+		}
+	})
+
+	t.Run("with other HMAC algorithm", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString(secret)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, ok, err := ValidateToken(token); ok || err == nil {
+			t.Error("token signed with HS512 should be rejected")
+		}
+	})
+
+	t.Run("with RS256 header", func(t *testing.T) {
+		// signed with the secret, but claiming to be signed with a public key algorithm
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["alg"] = jwt.SigningMethodRS256.Alg()
+		signingString, err := token.SigningString()
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		signature, err := jwt.SigningMethodHS256.Sign(signingString, secret)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, ok, err := ValidateToken(signingString + "." + signature); ok || err == nil {
+			t.Error("token with swapped algorithm should be rejected")
+		}
+	})
+
+	t.Run("with expected algorithm", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+			return
+		}
+		if _, ok, err := ValidateToken(token); !ok || err != nil {
+			t.Errorf("token should be valid: %v", err)
+		}
+	})
+}
+
+func TestValidateTokenLeeway(t *testing.T) {
+	secret := []byte("test-secret-key-with-at-least-32-bytes")
+	t.Setenv("JWT_TOKEN_KEY", b64.StdEncoding.EncodeToString(secret))
+
+	signedAt := func(issuedAt time.Time, expiresAt time.Time) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, UserClaims{
+			ID:         "testuserid",
+			InstanceID: "testinstance",
+			StandardClaims: jwt.StandardClaims{
+				IssuedAt:  issuedAt.Unix(),
+				NotBefore: issuedAt.Unix(),
+				ExpiresAt: expiresAt.Unix(),
+			},
+		}).SignedString(secret)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		return token
+	}
+	now := time.Now()
+	// issued by a service with its clock ahead
+	fromFuture := signedAt(now.Add(5*time.Second), now.Add(time.Minute))
+	justExpired := signedAt(now.Add(-time.Minute), now.Add(-5*time.Second))
+	longExpired := signedAt(now.Add(-time.Hour), now.Add(-time.Minute))
+
+	defer SetLeeway(0)
+
+	t.Run("without leeway", func(t *testing.T) {
+		SetLeeway(0)
+		for _, token := range []string{fromFuture, justExpired} {
+			if _, ok, err := ValidateToken(token); ok || err == nil {
+				t.Error("token should be rejected")
+			}
+		}
+	})
+
+	t.Run("within leeway", func(t *testing.T) {
+		SetLeeway(10 * time.Second)
+		for _, token := range []string{fromFuture, justExpired} {
+			if _, ok, err := ValidateToken(token); !ok || err != nil {
+				t.Errorf("token should be valid: %v", err)
+			}
+		}
+	})
+
+	t.Run("expired beyond leeway", func(t *testing.T) {
+		SetLeeway(10 * time.Second)
+		_, ok, err := ValidateToken(longExpired)
+		if ok || err == nil || !strings.Contains(err.Error(), "token is expired by") {
+			t.Errorf("token should be rejected as expired: %v", err)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package tokens
+
+import (
+	"encoding/json"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
+const (
+	payloadKeyProfiles     = "profiles"
+	maxProfilesPayloadSize = 1024 // bytes of the encoded profiles, keeps the size of the tokens bounded
+)
+
+// encodeProfiles encodes the profiles for the token payload. Profiles at the end of the list are left out if the
+// encoded list would exceed maxProfilesPayloadSize.
+func encodeProfiles(profiles []models.TokenProfile) string {
+	for n := len(profiles); n > 0; n-- {
+		encoded, err := json.Marshal(profiles[:n])
+		if err == nil && len(encoded) <= maxProfilesPayloadSize {
+			return string(encoded)
+		}
+	}
+	return ""
+}
+
+// GetProfilesFromPayload returns the profiles embedded in the token payload, nil if the token has none.
+// The list may be incomplete for users with many profiles, the ids of all profiles are in the token claims.
+func GetProfilesFromPayload(payload map[string]string) []models.TokenProfile {
+	v, ok := payload[payloadKeyProfiles]
+	if !ok {
+		return nil
+	}
+	profiles := []models.TokenProfile{}
+	if err := json.Unmarshal([]byte(v), &profiles); err != nil {
+		return nil
+	}
+	return profiles
+}
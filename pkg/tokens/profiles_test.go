@@ -0,0 +1,66 @@
+package tokens
+
+import (
+	b64 "encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
+func TestEncodeProfiles(t *testing.T) {
+	t.Run("with few profiles", func(t *testing.T) {
+		profiles := []models.TokenProfile{{ID: "p1", Alias: "Me"}, {ID: "p2"}}
+		encoded := encodeProfiles(profiles)
+		decoded := GetProfilesFromPayload(map[string]string{payloadKeyProfiles: encoded})
+		if len(decoded) != 2 || decoded[0] != profiles[0] || decoded[1] != profiles[1] {
+			t.Errorf("unexpected profiles: %v from %s", decoded, encoded)
+		}
+	})
+
+	t.Run("with too many profiles", func(t *testing.T) {
+		profiles := []models.TokenProfile{}
+		for i := 0; i < 50; i++ {
+			profiles = append(profiles, models.TokenProfile{ID: fmt.Sprintf("%024d", i), Alias: strings.Repeat("a", 20)})
+		}
+		encoded := encodeProfiles(profiles)
+		if len(encoded) > maxProfilesPayloadSize {
+			t.Errorf("encoded profiles too long: %d", len(encoded))
+		}
+		decoded := GetProfilesFromPayload(map[string]string{payloadKeyProfiles: encoded})
+		if len(decoded) == 0 || len(decoded) >= len(profiles) || decoded[0] != profiles[0] {
+			t.Errorf("unexpected profiles: %v", decoded)
+		}
+	})
+
+	t.Run("without profiles in payload", func(t *testing.T) {
+		if profiles := GetProfilesFromPayload(map[string]string{"roles": "PARTICIPANT"}); profiles != nil {
+			t.Errorf("unexpected profiles: %v", profiles)
+		}
+	})
+}
+
+func TestGenerateNewTokenWithProfiles(t *testing.T) {
+	t.Setenv("JWT_TOKEN_KEY", b64.StdEncoding.EncodeToString([]byte("test-secret-key-with-at-least-32-bytes")))
+
+	profiles := []models.TokenProfile{{ID: "mainprofileid", Alias: "Me"}, {ID: "otherprofileid", Alias: "Child"}}
+	token, err := GenerateNewToken("testuserid", true, "mainprofileid", []string{"PARTICIPANT"}, "testinstance", time.Minute, "", nil, []string{"otherprofileid"}, profiles)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	}
+	claims, ok, err := ValidateToken(token)
+	if err != nil || !ok {
+		t.Errorf("token should be valid: %v", err)
+		return
+	}
+	decoded := GetProfilesFromPayload(claims.Payload)
+	if len(decoded) != 2 || decoded[0] != profiles[0] || decoded[1] != profiles[1] {
+		t.Errorf("unexpected profiles: %v", decoded)
+	}
+	if roles := GetRolesFromPayload(claims.Payload); len(roles) != 1 || roles[0] != "PARTICIPANT" {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+}
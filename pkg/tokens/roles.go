@@ -0,0 +1,28 @@
+package tokens
+
+import "github.com/influenzanet/go-utils/pkg/constants"
+
+// HasRole checks if the role is listed in the roles of the token payload
+func HasRole(payload map[string]string, role string) bool {
+	for _, r := range GetRolesFromPayload(payload) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAnyRole checks if at least one of the roles is listed in the roles of the token payload
+func HasAnyRole(payload map[string]string, roles ...string) bool {
+	for _, role := range roles {
+		if HasRole(payload, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin checks if the token payload has the admin role
+func IsAdmin(payload map[string]string) bool {
+	return HasRole(payload, constants.USER_ROLE_ADMIN)
+}
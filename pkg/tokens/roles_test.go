@@ -0,0 +1,52 @@
+package tokens
+
+import "testing"
+
+func TestHasRole(t *testing.T) {
+	for _, c := range []struct {
+		payload  map[string]string
+		role     string
+		expected bool
+	}{
+		{nil, "ADMIN", false},
+		{map[string]string{}, "ADMIN", false},
+		{map[string]string{"username": "ADMIN"}, "ADMIN", false},
+		{map[string]string{"roles": ""}, "ADMIN", false},
+		{map[string]string{"roles": "ADMIN"}, "ADMIN", true},
+		{map[string]string{"roles": "PARTICIPANT,ADMIN"}, "ADMIN", true},
+		{map[string]string{"roles": "PARTICIPANT,ADMINISTRATOR"}, "ADMIN", false},
+		{map[string]string{"roles": "PARTICIPANT,admin"}, "ADMIN", false},
+	} {
+		if HasRole(c.payload, c.role) != c.expected {
+			t.Errorf("HasRole(%v, %s) should be %t", c.payload, c.role, c.expected)
+		}
+	}
+}
+
+func TestHasAnyRole(t *testing.T) {
+	payload := map[string]string{"roles": "PARTICIPANT,RESEARCHER"}
+	if !HasAnyRole(payload, "ADMIN", "RESEARCHER") {
+		t.Error("should have one of the roles")
+	}
+	if HasAnyRole(payload, "ADMIN", "SERVICE") {
+		t.Error("should have none of the roles")
+	}
+	if HasAnyRole(payload) {
+		t.Error("should be false without roles to check")
+	}
+	if HasAnyRole(nil, "PARTICIPANT") {
+		t.Error("should be false without payload")
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	if !IsAdmin(map[string]string{"roles": "PARTICIPANT,ADMIN"}) {
+		t.Error("should be admin")
+	}
+	if IsAdmin(map[string]string{"roles": "PARTICIPANT,RESEARCHER"}) {
+		t.Error("should not be admin")
+	}
+	if IsAdmin(nil) {
+		t.Error("should not be admin without payload")
+	}
+}
@@ -1,8 +1,12 @@
 package tokens
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	b32 "encoding/base32"
+	"encoding/hex"
+	"errors"
 	"strings"
 	"time"
 )
@@ -29,6 +33,31 @@ func GenerateUniqueTokenString() (string, error) {
 	return tokenStr, nil
 }
 
+// HashAppToken returns the value under which an app token is stored. App tokens are random strings,
+// so a plain sha256 is sufficient here.
+func HashAppToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// KeyedHash returns the hex encoded HMAC-SHA256 of data with a key derived from the JWT secret. It is used for values
+// that are only stored to be recognized again, e.g. email addresses of deleted accounts, which a plain hash would not
+// protect against dictionary attacks.
+func KeyedHash(data []byte) (string, error) {
+	if _, err := getSecretKey(); err != nil {
+		return "", err
+	}
+	if len(secretKey) == 0 {
+		return "", errors.New("couldn't find proper secret key")
+	}
+	// derived key, so that the hashes are not signatures made with the JWT key
+	keyMac := hmac.New(sha256.New, secretKey)
+	keyMac.Write([]byte("keyed-hash"))
+	mac := hmac.New(sha256.New, keyMac.Sum(nil))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
 func GetExpirationTime(validityPeriod time.Duration) int64 {
 	return time.Now().Add(validityPeriod).Unix()
 }
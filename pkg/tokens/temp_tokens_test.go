@@ -1,6 +1,7 @@
 package tokens
 
 import (
+	b64 "encoding/base64"
 	"testing"
 	"time"
 )
@@ -80,3 +81,37 @@ func TestReachedExpirationTime(t *testing.T) {
 }
 
 
+
+func TestHashAppToken(t *testing.T) {
+	h1 := HashAppToken("test-app-token")
+	if len(h1) != 64 || h1 == "test-app-token" {
+		t.Errorf("unexpected hash: %s", h1)
+	}
+	if HashAppToken("test-app-token") != h1 {
+		t.Error("hash should be deterministic")
+	}
+	if HashAppToken("test-app-token2") == h1 {
+		t.Error("different tokens should have different hashes")
+	}
+}
+
+func TestKeyedHash(t *testing.T) {
+	t.Setenv("JWT_TOKEN_KEY", b64.StdEncoding.EncodeToString([]byte("test-secret-key-with-at-least-32-bytes")))
+	h1, err := KeyedHash([]byte("test@test.com"))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	h2, _ := KeyedHash([]byte("test@test.com"))
+	if h1 != h2 || len(h1) != 64 {
+		t.Errorf("unexpected hashes: %s %s", h1, h2)
+	}
+	if h1 == HashAppToken("test@test.com") {
+		t.Error("hash should depend on the secret")
+	}
+
+	t.Setenv("JWT_TOKEN_KEY", b64.StdEncoding.EncodeToString([]byte("other-secret-key-with-at-least-32-bytes")))
+	if h3, _ := KeyedHash([]byte("test@test.com")); h3 == h1 {
+		t.Error("hash should change with the secret")
+	}
+}
@@ -1,6 +1,12 @@
 package tokens
 
-import "crypto/rand"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
 
 const codeCharSet = "1234567890"
 
@@ -17,3 +23,12 @@ func GenerateVerificationCode(length int) (string, error) {
 	}
 	return string(buffer), nil
 }
+
+// CompareVerificationCode checks the provided code against the stored one in constant time.
+// An expired or empty stored code never matches.
+func CompareVerificationCode(stored models.VerificationCode, provided string) bool {
+	if stored.Code == "" || stored.ExpiresAt < time.Now().Unix() {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored.Code), []byte(provided)) == 1
+}
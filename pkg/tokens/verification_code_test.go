@@ -2,8 +2,10 @@ package tokens
 
 import (
 	"testing"
+	"time"
 
 	"github.com/coneno/logger"
+	"github.com/influenzanet/user-management-service/pkg/models"
 )
 
 func TestGenerateVerificationCode(t *testing.T) {
@@ -30,3 +32,40 @@ func TestGenerateVerificationCode(t *testing.T) {
 		}
 	})
 }
+
+func TestCompareVerificationCode(t *testing.T) {
+	stored := models.VerificationCode{
+		Code:      "123456",
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: time.Now().Unix() + 60,
+	}
+
+	t.Run("with matching code", func(t *testing.T) {
+		if !CompareVerificationCode(stored, "123456") {
+			t.Error("should match")
+		}
+	})
+
+	t.Run("with mismatched code", func(t *testing.T) {
+		if CompareVerificationCode(stored, "123457") {
+			t.Error("should not match")
+		}
+		if CompareVerificationCode(stored, "") {
+			t.Error("empty code should not match")
+		}
+	})
+
+	t.Run("with expired code", func(t *testing.T) {
+		expired := stored
+		expired.ExpiresAt = time.Now().Unix() - 1
+		if CompareVerificationCode(expired, "123456") {
+			t.Error("expired code should not match")
+		}
+	})
+
+	t.Run("with empty stored code", func(t *testing.T) {
+		if CompareVerificationCode(models.VerificationCode{}, "") {
+			t.Error("empty stored code should not match")
+		}
+	})
+}
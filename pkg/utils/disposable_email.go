@@ -0,0 +1,37 @@
+package utils
+
+import "strings"
+
+// DisposableDomainList detects disposable email addresses with a static list of domains. A domain also covers its subdomains.
+type DisposableDomainList map[string]bool
+
+func NewDisposableDomainList(domains []string) DisposableDomainList {
+	list := DisposableDomainList{}
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" {
+			list[d] = true
+		}
+	}
+	return list
+}
+
+// IsDisposable checks the domain of the email address and its parent domains against the list
+func (l DisposableDomainList) IsDisposable(email string) (bool, error) {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false, nil
+	}
+	domain := strings.ToLower(email[i+1:])
+	for domain != "" {
+		if l[domain] {
+			return true, nil
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return false, nil
+}
@@ -0,0 +1,29 @@
+package utils
+
+import "testing"
+
+func TestDisposableDomainList(t *testing.T) {
+	list := NewDisposableDomainList([]string{"trashmail.com", " TempMail.org ", ""})
+
+	for _, tc := range []struct {
+		email      string
+		disposable bool
+	}{
+		{email: "test@test.com", disposable: false},
+		{email: "test@trashmail.com", disposable: true},
+		{email: "test@TRASHMAIL.com", disposable: true},
+		{email: "test@sub.trashmail.com", disposable: true},
+		{email: "test@nottrashmail.com", disposable: false},
+		{email: "test@tempmail.org", disposable: true},
+		{email: "test@com", disposable: false},
+		{email: "test", disposable: false},
+	} {
+		disposable, err := list.IsDisposable(tc.email)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if disposable != tc.disposable {
+			t.Errorf("%s should be disposable: %v", tc.email, tc.disposable)
+		}
+	}
+}
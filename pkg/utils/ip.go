@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	IPStorageFull      = "full"
+	IPStorageTruncated = "truncated"
+	IPStorageNone      = "none"
+)
+
+// AnonymizeIP prepares a client IP for storage: unchanged ("full"), truncated to the network part (IPv4 /24, IPv6 /48)
+// or removed ("none"). Values that are not an IP are not stored in truncated mode.
+func AnonymizeIP(ip string, mode string) string {
+	switch mode {
+	case IPStorageNone:
+		return ""
+	case IPStorageTruncated:
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return ""
+		}
+		if v4 := parsed.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	default:
+		return ip
+	}
+}
+
+// ParseTrustedProxies parses a comma separated list of IPs and CIDR ranges of the proxies in front of the service
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	proxies := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP: %s", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP of the client from the address of the peer and the x-forwarded-for values. The forwarded
+// addresses are only used if the peer is a trusted proxy, then the rightmost address that is not a trusted proxy is
+// the client, since entries left of it can be set by the client.
+func ClientIP(peerIP string, forwardedFor []string, trustedProxies []*net.IPNet) string {
+	if !isTrustedProxy(peerIP, trustedProxies) {
+		return peerIP
+	}
+	hops := []string{}
+	for _, v := range forwardedFor {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	client := peerIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		client = hops[i]
+		if !isTrustedProxy(client, trustedProxies) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,77 @@
+package utils
+
+import "testing"
+
+func TestAnonymizeIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip       string
+		mode     string
+		expected string
+	}{
+		{ip: "192.168.12.34", mode: IPStorageFull, expected: "192.168.12.34"},
+		{ip: "192.168.12.34", mode: "", expected: "192.168.12.34"},
+		{ip: "192.168.12.34", mode: IPStorageTruncated, expected: "192.168.12.0"},
+		{ip: "2001:db8:abcd:12:1:2:3:4", mode: IPStorageTruncated, expected: "2001:db8:abcd::"},
+		{ip: "not an ip", mode: IPStorageTruncated, expected: ""},
+		{ip: "192.168.12.34", mode: IPStorageNone, expected: ""},
+	} {
+		if r := AnonymizeIP(tc.ip, tc.mode); r != tc.expected {
+			t.Errorf("unexpected result for %s in mode %s: %s", tc.ip, tc.mode, r)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		proxies, err := ParseTrustedProxies("")
+		if err != nil || len(proxies) != 0 {
+			t.Errorf("unexpected result: %v, %v", proxies, err)
+		}
+	})
+
+	t.Run("IPs and ranges", func(t *testing.T) {
+		proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.2,::1")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		if len(proxies) != 3 || proxies[1].String() != "192.168.1.2/32" || proxies[2].String() != "::1/128" {
+			t.Errorf("unexpected result: %v", proxies)
+		}
+	})
+
+	t.Run("invalid entry", func(t *testing.T) {
+		if _, err := ParseTrustedProxies("10.0.0.0/8,proxy"); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	for _, tc := range []struct {
+		name         string
+		peer         string
+		forwardedFor []string
+		expected     string
+	}{
+		{name: "without proxy", peer: "203.0.113.57", expected: "203.0.113.57"},
+		{name: "untrusted peer", peer: "203.0.113.57", forwardedFor: []string{"198.51.100.1"}, expected: "203.0.113.57"},
+		{name: "trusted peer", peer: "10.0.0.2", forwardedFor: []string{"203.0.113.57"}, expected: "203.0.113.57"},
+		{name: "spoofed entries", peer: "10.0.0.2", forwardedFor: []string{"198.51.100.1, 203.0.113.57"}, expected: "203.0.113.57"},
+		{name: "chain of proxies", peer: "10.0.0.2", forwardedFor: []string{"198.51.100.1, 203.0.113.57, 10.0.0.3"}, expected: "203.0.113.57"},
+		{name: "several headers", peer: "10.0.0.2", forwardedFor: []string{"198.51.100.1", "203.0.113.57"}, expected: "203.0.113.57"},
+		{name: "only proxies", peer: "10.0.0.2", forwardedFor: []string{"10.0.0.4, 10.0.0.3"}, expected: "10.0.0.4"},
+		{name: "trusted peer without header", peer: "10.0.0.2", expected: "10.0.0.2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if r := ClientIP(tc.peer, tc.forwardedFor, trusted); r != tc.expected {
+				t.Errorf("unexpected client IP: %s", r)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
+// Pacer spaces out calls as set by a SendPacingConfig. Not safe for concurrent use, calls are made one at a time.
+type Pacer struct {
+	conf  models.SendPacingConfig
+	last  time.Time
+	count int
+}
+
+func NewPacer(conf models.SendPacingConfig) *Pacer {
+	return &Pacer{conf: conf}
+}
+
+// Wait blocks until the next call is allowed, or returns the error of ctx if it is done before
+func (p *Pacer) Wait(ctx context.Context) error {
+	if p.count > 0 {
+		gap := p.conf.Interval
+		if p.conf.BatchSize > 0 && p.count%p.conf.BatchSize == 0 && p.conf.BatchPause > gap {
+			gap = p.conf.BatchPause
+		}
+		if delay := time.Until(p.last.Add(gap)); delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	p.last = time.Now()
+	p.count++
+	return nil
+}
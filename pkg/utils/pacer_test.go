@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
+func TestPacer(t *testing.T) {
+	callTimes := func(p *Pacer, n int) []time.Time {
+		times := make([]time.Time, n)
+		for i := range times {
+			if err := p.Wait(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			times[i] = time.Now()
+		}
+		return times
+	}
+
+	t.Run("without pacing", func(t *testing.T) {
+		start := time.Now()
+		callTimes(NewPacer(models.SendPacingConfig{}), 100)
+		if d := time.Since(start); d > 50*time.Millisecond {
+			t.Errorf("calls should not be delayed: %s", d)
+		}
+	})
+
+	t.Run("with interval", func(t *testing.T) {
+		interval := 20 * time.Millisecond
+		times := callTimes(NewPacer(models.SendPacingConfig{Interval: interval}), 6)
+		for i := 1; i < len(times); i++ {
+			if d := times[i].Sub(times[i-1]); d < interval {
+				t.Errorf("call %d after %s, expected at least %s", i, d, interval)
+			}
+		}
+		if d := times[5].Sub(times[0]); d < 5*interval {
+			t.Errorf("6 calls within %s", d)
+		}
+	})
+
+	t.Run("with batches", func(t *testing.T) {
+		interval := 5 * time.Millisecond
+		pause := 50 * time.Millisecond
+		times := callTimes(NewPacer(models.SendPacingConfig{Interval: interval, BatchSize: 3, BatchPause: pause}), 7)
+		for i := 1; i < len(times); i++ {
+			expected := interval
+			if i%3 == 0 {
+				expected = pause
+			}
+			if d := times[i].Sub(times[i-1]); d < expected {
+				t.Errorf("call %d after %s, expected at least %s", i, d, expected)
+			}
+		}
+	})
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		p := NewPacer(models.SendPacingConfig{Interval: time.Hour})
+		if err := p.Wait(context.Background()); err != nil {
+			t.Fatalf("first call should not wait: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := p.Wait(ctx); err != context.DeadlineExceeded {
+			t.Errorf("expected deadline exceeded, got: %v", err)
+		}
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/influenzanet/go-utils/pkg/api_types"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
 )
 
 func SanitizeEmail(email string) string {
@@ -14,6 +16,25 @@ func SanitizeEmail(email string) string {
 	return email
 }
 
+// NormalizeEmail returns the form of the address used to find accounts of the same person: sanitized and, for Gmail
+// which ignores them, without dots and "+" suffix in the local part.
+func NormalizeEmail(email string) string {
+	email = SanitizeEmail(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+	if domain == "gmail.com" || domain == "googlemail.com" {
+		if plus := strings.Index(local, "+"); plus >= 0 {
+			local = local[:plus]
+		}
+		local = strings.ReplaceAll(local, ".", "")
+		domain = "gmail.com"
+	}
+	return local + "@" + domain
+}
+
 // CheckEmailFormat to check if input string is a correct email address
 func CheckEmailFormat(email string) bool {
 	if len(email) > 254 {
@@ -28,6 +49,13 @@ func CheckEmailFormat(email string) bool {
 	return emailRule.MatchString(email)
 }
 
+// CheckUsernameFormat checks if the string can be used as username: 3 to 32 lower case letters, digits, '.', '_' or '-',
+// starting with a letter or digit. Without '@' a username can't be taken for an email account ID.
+func CheckUsernameFormat(username string) bool {
+	usernameRule := regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,31}$`)
+	return usernameRule.MatchString(username)
+}
+
 // BlurEmailAddress transforms an email address to reduce exposed personal info
 func BlurEmailAddress(email string) string {
 	items := strings.Split(email, "@")
@@ -39,10 +67,32 @@ func BlurEmailAddress(email string) string {
 	return blurredEmail
 }
 
+// DefaultPasswordPolicy is used for the rules an instance doesn't override
+var DefaultPasswordPolicy = models.PasswordPolicy{
+	MinLength:      8,
+	MaxLength:      512,
+	MinCharClasses: 3,
+}
+
 // CheckPasswordFormat to check if password fulfills password rules
 func CheckPasswordFormat(password string) bool {
+	return CheckPasswordPolicy(password, DefaultPasswordPolicy)
+}
+
+// CheckPasswordPolicy checks the password against the policy, unset rules are taken from the default policy
+func CheckPasswordPolicy(password string, policy models.PasswordPolicy) bool {
+	if policy.MinLength <= 0 {
+		policy.MinLength = DefaultPasswordPolicy.MinLength
+	}
+	if policy.MaxLength <= 0 {
+		policy.MaxLength = DefaultPasswordPolicy.MaxLength
+	}
+	if policy.MinCharClasses <= 0 {
+		policy.MinCharClasses = DefaultPasswordPolicy.MinCharClasses
+	}
+
 	pl := len(password)
-	if pl < 8 || pl > 512 {
+	if pl < policy.MinLength || pl > policy.MaxLength {
 		return false
 	}
 
@@ -65,7 +115,50 @@ func CheckPasswordFormat(password string) bool {
 	if symbol.MatchString(password) {
 		res++
 	}
-	return res > 2
+	return res >= policy.MinCharClasses
+}
+
+// CheckEmailDomainPolicy checks the domain of the email address against the allowed and blocked domains of the policy
+func CheckEmailDomainPolicy(email string, policy models.EmailDomainPolicy) bool {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[i+1:])
+	for _, d := range policy.BlockedDomains {
+		if matchesEmailDomain(domain, d) {
+			return false
+		}
+	}
+	if len(policy.AllowedDomains) == 0 {
+		return true
+	}
+	for _, d := range policy.AllowedDomains {
+		if matchesEmailDomain(domain, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTrustedEmailDomain tells if the email address belongs to one of the trusted domains of the policy
+func IsTrustedEmailDomain(email string, policy models.EmailDomainPolicy) bool {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[i+1:])
+	for _, d := range policy.TrustedDomains {
+		if matchesEmailDomain(domain, d) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesEmailDomain(domain string, policyDomain string) bool {
+	policyDomain = strings.ToLower(strings.TrimSpace(policyDomain))
+	return policyDomain != "" && (domain == policyDomain || strings.HasSuffix(domain, "."+policyDomain))
 }
 
 // CheckLanguageCode checks if a string can be considered as a language code
@@ -82,18 +175,25 @@ func IsTokenEmpty(t *api_types.TokenInfos) bool {
 	return false
 }
 
+// IsImpersonationToken checks if the token was issued for an admin acting as the user
+func IsImpersonationToken(t *api_types.TokenInfos) bool {
+	return t != nil && t.Payload[models.TOKEN_PAYLOAD_IMPERSONATED_BY] != ""
+}
+
+// HasScope checks if the required scope is among the granted ones
+func HasScope(grantedScopes []string, requiredScope string) bool {
+	for _, s := range grantedScopes {
+		if s == requiredScope {
+			return true
+		}
+	}
+	return false
+}
+
 // CheckRoleInToken Check if role is present in the token
 func CheckRoleInToken(t *api_types.TokenInfos, role string) bool {
 	if t == nil {
 		return false
 	}
-	if val, ok := t.Payload["roles"]; ok {
-		roles := strings.Split(val, ",")
-		for _, r := range roles {
-			if r == role {
-				return true
-			}
-		}
-	}
-	return false
+	return tokens.HasRole(t.Payload, role)
 }
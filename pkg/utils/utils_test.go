@@ -6,6 +6,7 @@ import (
 
 	"github.com/coneno/logger"
 	api_types "github.com/influenzanet/go-utils/pkg/api_types"
+	"github.com/influenzanet/user-management-service/pkg/models"
 )
 
 func TestSanitizeEmail(t *testing.T) {
@@ -27,6 +28,23 @@ func TestSanitizeEmail(t *testing.T) {
 	})
 }
 
+func TestNormalizeEmail(t *testing.T) {
+	for _, c := range []struct {
+		email    string
+		expected string
+	}{
+		{email: " Test.User@Test.DE\n", expected: "test.user@test.de"},
+		{email: "test.user+study@test.de", expected: "test.user+study@test.de"},
+		{email: "Test.User+study@Gmail.com", expected: "testuser@gmail.com"},
+		{email: "t.e.s.t.user@googlemail.com", expected: "testuser@gmail.com"},
+		{email: "no-domain", expected: "no-domain"},
+	} {
+		if email := NormalizeEmail(c.email); email != c.expected {
+			t.Errorf("unexpected email for %s: %s", c.email, email)
+		}
+	}
+}
+
 func TestBlurEmailAddress(t *testing.T) {
 	t.Run("with different formats", func(t *testing.T) {
 		email := BlurEmailAddress("a@test.de")
@@ -78,6 +96,75 @@ func TestCheckPasswordFormat(t *testing.T) {
 	})
 }
 
+func TestCheckPasswordPolicy(t *testing.T) {
+	policy := models.PasswordPolicy{MinLength: 12}
+	t.Run("with a password too short for the policy", func(t *testing.T) {
+		if CheckPasswordPolicy("1n34T678", policy) {
+			t.Error("should be false")
+		}
+	})
+	t.Run("with a long enough password", func(t *testing.T) {
+		if !CheckPasswordPolicy("1n34T678abcd", policy) {
+			t.Error("should be true")
+		}
+	})
+	t.Run("unset rules use the default policy", func(t *testing.T) {
+		if CheckPasswordPolicy("123456789abcd", policy) {
+			t.Error("should be false")
+		}
+		if !CheckPasswordPolicy("123456789abcd", models.PasswordPolicy{MinLength: 12, MinCharClasses: 2}) {
+			t.Error("should be true")
+		}
+	})
+}
+
+func TestCheckEmailDomainPolicy(t *testing.T) {
+	blocking := models.EmailDomainPolicy{BlockedDomains: []string{"trashmail.com"}}
+	allowing := models.EmailDomainPolicy{AllowedDomains: []string{"uni-example.de"}, BlockedDomains: []string{"guest.uni-example.de"}}
+
+	for _, tc := range []struct {
+		email  string
+		policy models.EmailDomainPolicy
+		valid  bool
+	}{
+		{email: "test@test.com", policy: models.EmailDomainPolicy{}, valid: true},
+		{email: "test@test.com", policy: blocking, valid: true},
+		{email: "test@trashmail.com", policy: blocking, valid: false},
+		{email: "test@TrashMail.com", policy: blocking, valid: false},
+		{email: "test@sub.trashmail.com", policy: blocking, valid: false},
+		{email: "test@nottrashmail.com", policy: blocking, valid: true},
+		{email: "test@uni-example.de", policy: allowing, valid: true},
+		{email: "test@mail.uni-example.de", policy: allowing, valid: true},
+		{email: "test@guest.uni-example.de", policy: allowing, valid: false},
+		{email: "test@test.com", policy: allowing, valid: false},
+	} {
+		if CheckEmailDomainPolicy(tc.email, tc.policy) != tc.valid {
+			t.Errorf("email %s should be valid for %v: %v", tc.email, tc.policy, tc.valid)
+		}
+	}
+}
+
+func TestIsTrustedEmailDomain(t *testing.T) {
+	policy := models.EmailDomainPolicy{TrustedDomains: []string{"uni-example.de"}}
+
+	for _, tc := range []struct {
+		email   string
+		policy  models.EmailDomainPolicy
+		trusted bool
+	}{
+		{email: "test@uni-example.de", policy: models.EmailDomainPolicy{}, trusted: false},
+		{email: "test@uni-example.de", policy: policy, trusted: true},
+		{email: "test@Mail.Uni-Example.de", policy: policy, trusted: true},
+		{email: "test@not-uni-example.de", policy: policy, trusted: false},
+		{email: "test@test.com", policy: policy, trusted: false},
+		{email: "uni-example.de", policy: policy, trusted: false},
+	} {
+		if IsTrustedEmailDomain(tc.email, tc.policy) != tc.trusted {
+			t.Errorf("email %s should be trusted for %v: %v", tc.email, tc.policy, tc.trusted)
+		}
+	}
+}
+
 func TestCheckEmailFormat(t *testing.T) {
 	t.Run("with missing @", func(t *testing.T) {
 		if CheckEmailFormat("t.t.com") {
@@ -128,6 +215,19 @@ func TestCheckEmailFormat(t *testing.T) {
 	})
 }
 
+func TestCheckUsernameFormat(t *testing.T) {
+	for _, username := range []string{"abc", "test.user_1", "1-user"} {
+		if !CheckUsernameFormat(username) {
+			t.Errorf("%s should be valid", username)
+		}
+	}
+	for _, username := range []string{"", "ab", "Test", "test@test.com", ".test", "test user", strings.Repeat("a", 33)} {
+		if CheckUsernameFormat(username) {
+			t.Errorf("%s should not be valid", username)
+		}
+	}
+}
+
 func TestLanguageCodeFormat(t *testing.T) {
 	t.Run("with t", func(t *testing.T) {
 		if CheckLanguageCode("t") {
@@ -193,6 +293,18 @@ func TestIsTokenEmpty(t *testing.T) {
 	})
 }
 
+func TestIsImpersonationToken(t *testing.T) {
+	if IsImpersonationToken(nil) {
+		t.Error("nil token should be false")
+	}
+	if IsImpersonationToken(&api_types.TokenInfos{Id: "testid", Payload: map[string]string{"roles": "PARTICIPANT"}}) {
+		t.Error("token of the user should be false")
+	}
+	if !IsImpersonationToken(&api_types.TokenInfos{Id: "testid", Payload: map[string]string{"impersonated_by": "adminid"}}) {
+		t.Error("impersonation token should be true")
+	}
+}
+
 func TestCheckRoleInToken(t *testing.T) {
 	t.Run("check with nil input", func(t *testing.T) {
 		if CheckRoleInToken(nil, "") {
@@ -258,3 +370,23 @@ func TestCheckRoleInToken(t *testing.T) {
 		}
 	})
 }
+
+func TestHasScope(t *testing.T) {
+	t.Run("without granted scopes", func(t *testing.T) {
+		if HasScope(nil, "users:read") {
+			t.Error("should be false")
+		}
+	})
+
+	t.Run("with subset of scopes - granted", func(t *testing.T) {
+		if !HasScope([]string{"users:read", "messages:send"}, "users:read") {
+			t.Error("should be true")
+		}
+	})
+
+	t.Run("with subset of scopes - not granted", func(t *testing.T) {
+		if HasScope([]string{"users:read", "messages:send"}, "users:delete") {
+			t.Error("should be false")
+		}
+	})
+}
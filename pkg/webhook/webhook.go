@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coneno/logger"
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
+const (
+	// SignatureHeader carries the signature of the request body, see Sign
+	SignatureHeader = "X-Webhook-Signature"
+
+	queueSize = 1000 // events waiting for delivery, further ones are dropped
+)
+
+// Client posts webhook events to the configured URL. Events are queued and delivered one after the other by
+// a background goroutine, with retries, so that Notify never blocks. Queued events are lost on shutdown.
+type Client struct {
+	conf       models.WebhookConfig
+	httpClient *http.Client
+	queue      chan models.WebhookEvent
+}
+
+func NewClient(conf models.WebhookConfig) *Client {
+	if conf.MaxAttempts < 1 {
+		conf.MaxAttempts = 1
+	}
+	c := &Client{
+		conf:       conf,
+		httpClient: &http.Client{Timeout: conf.Timeout},
+		queue:      make(chan models.WebhookEvent, queueSize),
+	}
+	go c.run()
+	return c
+}
+
+// Sign returns the signature of body sent in SignatureHeader: "sha256=" followed by the hex encoded HMAC-SHA256 of body with secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify queues the event for delivery, it is dropped if the queue is full
+func (c *Client) Notify(event models.WebhookEvent) {
+	select {
+	case c.queue <- event:
+	default:
+		logger.Warning.Printf("webhook queue full, %s event of user %s dropped", event.Type, event.UserID)
+	}
+}
+
+func (c *Client) run() {
+	for event := range c.queue {
+		c.deliver(event)
+	}
+}
+
+func (c *Client) deliver(event models.WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error.Printf("webhook: %v", err)
+		return
+	}
+	delay := c.conf.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := c.post(body)
+		if err == nil {
+			return
+		}
+		if attempt >= c.conf.MaxAttempts {
+			logger.Error.Printf("webhook: %s event of user %s dropped after %d attempts: %v", event.Type, event.UserID, attempt, err)
+			return
+		}
+		logger.Warning.Printf("webhook: delivery of %s event failed, retrying in %s: %v", event.Type, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (c *Client) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(c.conf.Secret, body))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+)
+
+type receivedRequest struct {
+	body      []byte
+	signature string
+}
+
+func newTestServer(t *testing.T, failures int32) (*httptest.Server, chan receivedRequest) {
+	received := make(chan receivedRequest, 10)
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		received <- receivedRequest{body: body, signature: r.Header.Get(SignatureHeader)}
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func TestClient(t *testing.T) {
+	event := models.WebhookEvent{
+		Type:       models.WEBHOOK_EVENT_ACCOUNT_CREATED,
+		UserID:     "test-user-id",
+		InstanceID: "test-instance",
+		Timestamp:  1700000000,
+	}
+
+	t.Run("signed payload", func(t *testing.T) {
+		server, received := newTestServer(t, 0)
+		c := NewClient(models.WebhookConfig{URL: server.URL, Secret: "test-secret", MaxAttempts: 1, Timeout: time.Second})
+		c.Notify(event)
+
+		select {
+		case r := <-received:
+			if r.signature != Sign("test-secret", r.body) {
+				t.Errorf("unexpected signature: %s", r.signature)
+			}
+			if r.signature == Sign("other-secret", r.body) {
+				t.Error("signature should depend on the secret")
+			}
+			var payload models.WebhookEvent
+			if err := json.Unmarshal(r.body, &payload); err != nil {
+				t.Error(err)
+				return
+			}
+			if payload != event {
+				t.Errorf("unexpected payload: %v", payload)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("webhook not received")
+		}
+	})
+
+	t.Run("retry after failure", func(t *testing.T) {
+		server, received := newTestServer(t, 2)
+		c := NewClient(models.WebhookConfig{URL: server.URL, Secret: "test-secret", MaxAttempts: 3, RetryDelay: 10 * time.Millisecond, Timeout: time.Second})
+		c.Notify(event)
+
+		select {
+		case r := <-received:
+			if r.signature != Sign("test-secret", r.body) {
+				t.Errorf("unexpected signature: %s", r.signature)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("webhook not received")
+		}
+	})
+
+	t.Run("notify does not block when the queue is full", func(t *testing.T) {
+		// no worker, the queue is never emptied
+		c := &Client{queue: make(chan models.WebhookEvent, 1)}
+		done := make(chan struct{})
+		go func() {
+			c.Notify(event)
+			c.Notify(event)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("notify blocked")
+		}
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -120,7 +121,7 @@ func main() {
 	newUser.ContactPreferences.ReceiveWeeklyMessageDayOfWeek = int32(req.weekday)
 
 	instanceID := req.instanceID
-	id, err := userDBService.AddUser(instanceID, newUser)
+	id, err := userDBService.AddUser(context.Background(), instanceID, newUser)
 	if err != nil {
 		logger.Error.Fatal(err.Error())
 	}
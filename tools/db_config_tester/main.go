@@ -52,7 +52,7 @@ func parseFlags() {
 
 func generateUsers(usersToGenerate int) {
 	for a := 0; a < usersToGenerate; a++ {
-		_, err := userDB.AddUser(INSTANCE_ID, generateRandomUser())
+		_, err := userDB.AddUser(context.Background(), INSTANCE_ID, generateRandomUser())
 		if err != nil {
 			logger.Error.Fatal(err)
 		}